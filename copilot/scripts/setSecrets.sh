@@ -3,6 +3,10 @@ declare -A PARAMS=(
     ["/collab/QUESTION_SERVICE_URL"]="localhost"
     ["/collab/REDIS_ADDR"]="localhost:6379"
     ["/collab/SANDBOX_URL"]="localhost:8090"
+    ["/collab/SANDBOX_SHARED_SECRET"]="change-me"
+    ["/collab/QUESTION_SERVICE_API_KEY"]="change-me"
+
+    ["/question/QUESTION_API_KEYS"]="collab:change-me"
     
     ["/match/REDIS_ADDR"]="localhost:6379"
 
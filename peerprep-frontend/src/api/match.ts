@@ -93,7 +93,8 @@ export async function getRoomStatus(matchId: string, token: string): Promise<Roo
     return res.json();
 }
 
-export async function rerollQuestion(matchId: string, token: string): Promise<RoomInfo> {
+// Resolves to null while the partner has yet to approve the reroll
+export async function rerollQuestion(matchId: string, token: string): Promise<RoomInfo | null> {
     const res = await fetch(`${COLLAB_API_BASE}/api/v1/collab/room/${matchId}/reroll`, {
         method: "POST",
         headers: {
@@ -105,6 +106,9 @@ export async function rerollQuestion(matchId: string, token: string): Promise<Ro
         const message = await res.text();
         throw new Error(message || `Failed to reroll question: ${res.status}`);
     }
+    if (res.status === 202) {
+        return null;
+    }
 
     return res.json();
 }
@@ -17,19 +17,37 @@ import type { editor as MonacoEditorNS } from "monaco-editor";
 
 type MonacoType = typeof import("monaco-editor");
 
+type RunUsage = { durationMs: number; peakMemoryBytes?: number };
+
 type WSFrame =
-  | { type: "init"; data: { sessionId: string; doc: { text: string; version: number }; language: string } }
+  | {
+      type: "init";
+      data: {
+        sessionId: string;
+        doc: { text: string; version: number };
+        language: string;
+        presence?: { connected: string[] };
+      };
+    }
+  | { type: "presence"; data: { connected: string[]; at: number } }
   | { type: "doc"; data: { text: string; version: number } }
-  | { type: "cursor"; data: { userId: string; pos: number } }
+  | { type: "cursor"; data: { userId: string; pos: number; selStart: number; selEnd: number; color?: string } }
   | { type: "chat"; data: { userId: string; message: string } }
   | { type: "stdout"; data: string }
   | { type: "stderr"; data: string }
-  | { type: "exit"; data: { code: number; timedOut: boolean } }
+  | { type: "compile_error"; data: string }
+  | { type: "usage"; data: RunUsage }
+  | { type: "truncated"; data: { limitBytes: number } }
+  | { type: "exit"; data: { code: number; timedOut: boolean; cancelled?: boolean; oomKilled?: boolean } }
   | { type: "language"; data: string }
-  | { type: "run_reset"; data?: null }
+  | { type: "run_reset"; data?: { seq: number } | null }
   | { type: "question"; data: { question: Question | null; rerollsRemaining: number } }
   | { type: "error"; data: string }
-  | { type: "session_ended"; data: { reason?: string } };
+  | { type: "session_ended"; data: { reason?: string } }
+  | { type: "end_session_requested"; data: { userId: string; expiresAt: number } }
+  | { type: "end_session_cancelled"; data: { userId: string } }
+  | { type: "reroll_proposed"; data: { userId: string; expiresAt: number } }
+  | { type: "reroll_rejected"; data: { userId: string } };
 
 type EditChange = {
   rangeStart: number;
@@ -41,10 +59,20 @@ const CODE_TEMPLATES: Record<string, string> = {
   python: 'print("Hello from Python!")\n',
   cpp: '#include <iostream>\n\nint main() {\n    std::cout << "Hello from C++!" << std::endl;\n    return 0;\n}\n',
   java: 'public class Main {\n    public static void main(String[] args) {\n        System.out.println("Hello from Java!");\n    }\n}\n',
+  javascript: 'console.log("Hello from JavaScript!");\n',
+  go: 'package main\n\nimport "fmt"\n\nfunc main() {\n\tfmt.Println("Hello from Go!")\n}\n',
+  rust: 'fn main() {\n    println!("Hello from Rust!");\n}\n',
 };
 
 const COLLAB_WEBSOCKET_BASE = (import.meta as any).env?.VITE_COLLAB_WEBSOCKET_BASE || "ws://localhost:8084";
 
+// formatUsage renders a run's usage as "120ms, 14MB"
+function formatUsage(usage: RunUsage): string {
+  const time = `${usage.durationMs}ms`;
+  if (!usage.peakMemoryBytes) return time;
+  return `${time}, ${Math.round(usage.peakMemoryBytes / (1024 * 1024))}MB`;
+}
+
 function computeEditChange(prev: string, next: string): EditChange | null {
   if (prev === next) {
     return null;
@@ -86,12 +114,23 @@ export default function Editor() {
   const [docVersion, setDocVersion] = useState<number>(0);
   const [stdout, setStdout] = useState<string>("");
   const [stderr, setStderr] = useState<string>("");
-  const [exitInfo, setExitInfo] = useState<{ code: number | null; timedOut: boolean } | null>(null);
+  const [compileOutput, setCompileOutput] = useState<string | null>(null);
+  const [exitInfo, setExitInfo] = useState<{
+    code: number | null;
+    timedOut: boolean;
+    cancelled?: boolean;
+    oomKilled?: boolean;
+  } | null>(
+    null
+  );
+  const [runUsage, setRunUsage] = useState<RunUsage | null>(null);
+  const [outputTruncated, setOutputTruncated] = useState<boolean>(false);
   const [runError, setRunError] = useState<string | null>(null);
   const [isRunning, setIsRunning] = useState<boolean>(false);
   const [isRerolling, setIsRerolling] = useState<boolean>(false);
   const [rerollsRemaining, setRerollsRemaining] = useState<number>(0);
   const [voiceConnected, setVoiceConnected] = useState<boolean>(false);
+  const [connectedUsers, setConnectedUsers] = useState<string[]>([]);
 
   const wsRef = useRef<WebSocket | null>(null);
   const docVersionRef = useRef(docVersion);
@@ -99,6 +138,7 @@ export default function Editor() {
   const monacoRef = useRef<MonacoType | null>(null);
   const suppressChangeRef = useRef(false);
   const codeRef = useRef(code);
+  const userIdRef = useRef("");
   const sessionIdRef = useRef<string | null>(null);
 
   // Initialize session metrics tracking
@@ -124,7 +164,10 @@ export default function Editor() {
   const resetRunOutputs = () => {
     setStdout("");
     setStderr("");
+    setCompileOutput(null);
     setExitInfo(null);
+    setRunUsage(null);
+    setOutputTruncated(false);
     setRunError(null);
   };
 
@@ -136,6 +179,10 @@ export default function Editor() {
     codeRef.current = code;
   }, [code]);
 
+  useEffect(() => {
+    userIdRef.current = user?.id.toString() ?? "";
+  }, [user]);
+
   const applyDocToEditor = useCallback((nextText: string) => {
     const editor = editorRef.current;
     const monaco = monacoRef.current;
@@ -286,8 +333,8 @@ export default function Editor() {
 
 
   // Map the editor's language (string) to AI Assistant's accepted union type
-  const aiLanguage: 'python' | 'java' | 'cpp' =
-    language === 'python' || language === 'java' || language === 'cpp'
+  const aiLanguage: Language =
+    language === 'python' || language === 'java' || language === 'cpp' || language === 'javascript'
       ? (language as Language)
       : 'python';
 
@@ -383,6 +430,7 @@ export default function Editor() {
             setLanguage(frame.data.language);
           }
           applyServerDoc(frame.data.doc);
+          setConnectedUsers(frame.data.presence?.connected ?? []);
 
           // Store session ID for metrics
           if (frame.data.sessionId) {
@@ -393,6 +441,9 @@ export default function Editor() {
         case "doc":
           applyServerDoc(frame.data);
           break;
+        case "presence":
+          setConnectedUsers(frame.data.connected);
+          break;
         case "language":
           setLanguage(frame.data);
           break;
@@ -402,14 +453,31 @@ export default function Editor() {
         case "stderr":
           setStderr((prev) => prev + frame.data);
           break;
+        case "compile_error":
+          setCompileOutput(frame.data);
+          break;
+        case "usage":
+          setRunUsage(frame.data);
+          break;
+        case "truncated":
+          setOutputTruncated(true);
+          break;
         case "exit":
-          setExitInfo({ code: frame.data.code, timedOut: frame.data.timedOut });
+          setExitInfo({
+            code: frame.data.code,
+            timedOut: frame.data.timedOut,
+            cancelled: frame.data.cancelled,
+            oomKilled: frame.data.oomKilled,
+          });
           setIsRunning(false);
           break;
         case "run_reset":
           setStdout("");
           setStderr("");
+          setCompileOutput(null);
           setExitInfo(null);
+          setRunUsage(null);
+          setOutputTruncated(false);
           setRunError(null);
           setIsRunning(true);
           break;
@@ -461,9 +529,34 @@ export default function Editor() {
           setIsRunning(false);
           console.error("WS error:", frame.data);
           break;
+        case "end_session_requested": {
+          const agree = window.confirm("Your partner wants to end the session. End it for both of you?");
+          ws.send(JSON.stringify({ type: agree ? "end_session_confirm" : "end_session_cancel" }));
+          break;
+        }
+        case "reroll_proposed": {
+          if (frame.data.userId === userIdRef.current) break;
+          const approve = window.confirm("Your partner wants to reroll the question. Load a new one?");
+          ws.send(JSON.stringify({ type: "reroll_vote", data: { approve } }));
+          break;
+        }
+        case "reroll_rejected":
+          if (frame.data.userId !== userIdRef.current) {
+            toast("Your partner kept the current question.", { position: "bottom-center", duration: 3000 });
+          }
+          break;
+        case "end_session_cancelled":
+          toast("The request to end the session was withdrawn.", { position: "bottom-center", duration: 3000 });
+          break;
         case "session_ended": {
           const reason = (frame.data && typeof frame.data === "object" && (frame.data as any).reason) || "session_ended";
-          toast((reason === "partner_left" ? "Your partner left the session." : "Session ended."), {
+          const message =
+            reason === "partner_left"
+              ? "Your partner left the session."
+              : reason === "idle_timeout"
+                ? "Session ended after a period of inactivity."
+                : "Session ended.";
+          toast(message, {
             position: "bottom-center",
             duration: 3000,
           });
@@ -525,7 +618,7 @@ export default function Editor() {
       return;
     }
 
-    const executableLanguages = new Set(["python", "java", "cpp"]);
+    const executableLanguages = new Set(["python", "java", "cpp", "javascript", "go", "rust"]);
     if (!executableLanguages.has(language)) {
       setRunError("Execution is not available for the selected language");
       return;
@@ -545,6 +638,11 @@ export default function Editor() {
     );
   };
 
+  const handleCancelRun = () => {
+    if (!wsRef.current || wsRef.current.readyState !== WebSocket.OPEN) return;
+    wsRef.current.send(JSON.stringify({ type: "cancel_run" }));
+  };
+
   const handleReroll = async () => {
     if (!matchId) return;
 
@@ -569,6 +667,13 @@ export default function Editor() {
     try {
       setIsRerolling(true);
       const updatedRoom = await rerollQuestion(matchId, token);
+      if (!updatedRoom) {
+        toast("Waiting for your partner to approve the reroll.", {
+          position: "bottom-center",
+          duration: 4000,
+        });
+        return;
+      }
       setRoomInfo(updatedRoom);
       setQuestion(updatedRoom.question ?? null);
       setRerollsRemaining(updatedRoom.rerollsRemaining ?? 0);
@@ -642,6 +747,14 @@ export default function Editor() {
           <div>
             <h1 className="text-2xl font-semibold text-black">Collaborative Editor</h1>
             <p className="text-sm text-gray-500">Room: {roomId ?? "new"}</p>
+          <p className="text-xs text-gray-500">
+            Partner:{" "}
+            {connectedUsers.some((id) => id !== user?.id.toString()) ? (
+              <span className="text-green-600">connected</span>
+            ) : (
+              <span className="text-gray-400">not connected</span>
+            )}
+          </p>
           </div>
         </div>
         <div className="flex items-center justify-center h-64">
@@ -670,6 +783,9 @@ export default function Editor() {
             <option value="python">Python</option>
             <option value="cpp">C++</option>
             <option value="java">Java</option>
+            <option value="javascript">JavaScript</option>
+            <option value="go">Go</option>
+            <option value="rust">Rust</option>
           </select>
           <button
             type="button"
@@ -679,6 +795,15 @@ export default function Editor() {
           >
             {isRunning ? "Running..." : "Run"}
           </button>
+          {isRunning && (
+            <button
+              type="button"
+              onClick={handleCancelRun}
+              className="rounded-md border border-gray-300 px-3 py-2 text-sm text-gray-700 hover:bg-gray-50"
+            >
+              Cancel
+            </button>
+          )}
           <button
             type="button"
             onClick={handleExit}
@@ -873,6 +998,14 @@ export default function Editor() {
                 <div className="rounded-md bg-red-50 px-3 py-2 text-red-700">{runError}</div>
               ) : (
                 <div className="space-y-3">
+                  {compileOutput !== null && (
+                    <div>
+                      <div className="text-xs uppercase tracking-wide text-red-600">Compilation failed</div>
+                      <pre className="mt-1 max-h-40 overflow-y-auto rounded bg-gray-900 p-3 text-xs text-red-200">
+                        {compileOutput}
+                      </pre>
+                    </div>
+                  )}
                   <div>
                     <div className="text-xs uppercase tracking-wide text-gray-500">stdout</div>
                     <pre className="mt-1 max-h-40 overflow-y-auto rounded bg-gray-900 p-3 text-xs text-green-200">
@@ -887,7 +1020,16 @@ export default function Editor() {
                   </div>
                   {exitInfo && (
                     <div className="text-xs text-gray-500">
-                      Exit code: {exitInfo.code} {exitInfo.timedOut ? "(timed out)" : ""}
+                      {exitInfo.oomKilled ? (
+                        "Memory limit exceeded"
+                      ) : (
+                        <>
+                          Exit code: {exitInfo.code} {exitInfo.timedOut ? "(timed out)" : ""}
+                          {exitInfo.cancelled ? "(cancelled)" : ""}
+                        </>
+                      )}
+                      {runUsage && ` · ran in ${formatUsage(runUsage)}`}
+                      {outputTruncated && " · output truncated"}
                     </div>
                   )}
                 </div>
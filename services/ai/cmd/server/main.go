@@ -17,6 +17,7 @@ import (
 	"peerprep/ai/internal/llm"
 	_ "peerprep/ai/internal/llm/gemini"
 	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
 	"peerprep/ai/internal/prompts"
 	"peerprep/ai/internal/routers"
 	"peerprep/ai/internal/tuning"
@@ -29,9 +30,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func registerRoutes(router *chi.Mux, aiHandler *handlers.AIHandler, feedbackHandler *handlers.FeedbackHandler, modelHandler *handlers.ModelHandler, healthHandler *handlers.HealthHandler) {
+func registerRoutes(router *chi.Mux, aiHandler *handlers.AIHandler, feedbackHandler *handlers.FeedbackHandler, modelHandler *handlers.ModelHandler, digestHandler *handlers.DigestHandler, healthHandler *handlers.HealthHandler) {
 	routers.HealthRoutes(router, healthHandler)
-	routers.AIRoutes(router, aiHandler, feedbackHandler, modelHandler)
+	routers.AIRoutes(router, aiHandler, feedbackHandler, modelHandler, digestHandler)
 }
 
 // Helper functions for environment variables
@@ -110,6 +111,21 @@ func main() {
 	}
 
 	aiHandler := handlers.NewAIHandler(aiProvider, promptManager, logger)
+
+	// moderation screen for user-supplied content
+	moderationCfg, err := moderation.NewConfig()
+	if err != nil {
+		logger.Fatal("Failed to load moderation configuration", zap.Error(err))
+	}
+	screen, err := moderation.NewScreenFromConfig(moderationCfg)
+	if err != nil {
+		logger.Fatal("Failed to initialize moderation", zap.Error(err))
+	}
+	aiHandler.SetModeration(screen)
+	logger.Info("Moderation enabled",
+		zap.Float64("flag_threshold", moderationCfg.Thresholds.Flag),
+		zap.Float64("block_threshold", moderationCfg.Thresholds.Block),
+		zap.Bool("provider_moderation", moderationCfg.ProviderURL != ""))
 	healthHandler := handlers.NewHealthHandler(aiProvider, promptManager, cfg)
 
 	// Initialize database for feedback storage
@@ -123,6 +139,8 @@ func main() {
 	var feedbackHandler *handlers.FeedbackHandler
 	var modelHandler *handlers.ModelHandler
 	var exporterJob *jobs.FeedbackExporterJob
+	var digestJob *jobs.FeedbackDigestJob
+	var digestHandler *handlers.DigestHandler
 	var geminiTuner *tuning.GeminiTuner
 
 	if db != nil {
@@ -173,6 +191,25 @@ func main() {
 			}
 		}
 
+		// Initialize feedback digest job (disabled without a webhook URL)
+		digestPeriod, _ := time.ParseDuration(getEnv("AI_DIGEST_PERIOD", "168h"))
+		retryBackoff, _ := time.ParseDuration(getEnv("AI_DIGEST_RETRY_BACKOFF", "30s"))
+		digestConfig := &jobs.DigestConfig{
+			Schedule:     getEnv("AI_DIGEST_SCHEDULE", "0 9 * * 1"),
+			WebhookURL:   os.Getenv("AI_DIGEST_WEBHOOK_URL"),
+			Period:       digestPeriod,
+			MaxAttempts:  getEnvInt("AI_DIGEST_MAX_ATTEMPTS", 5),
+			RetryBackoff: retryBackoff,
+		}
+
+		digestJob = jobs.NewFeedbackDigestJob(feedbackManager, aiHandler.ModerationStats(), digestConfig)
+		if err := digestJob.Start(); err != nil {
+			logger.Error("Failed to start feedback digest job", zap.Error(err))
+		} else if digestConfig.WebhookURL != "" {
+			logger.Info("Feedback digest job started", zap.String("schedule", digestConfig.Schedule))
+		}
+		digestHandler = handlers.NewDigestHandler(digestJob)
+
 		// Create feedback handler
 		feedbackHandler = handlers.NewFeedbackHandler(feedbackManager)
 
@@ -197,7 +234,7 @@ func main() {
 
 	router.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer, middleware.Timeout(60*time.Second))
 
-	registerRoutes(router, aiHandler, feedbackHandler, modelHandler, healthHandler)
+	registerRoutes(router, aiHandler, feedbackHandler, modelHandler, digestHandler, healthHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -234,6 +271,9 @@ func main() {
 		exporterJob.Stop()
 		logger.Info("Feedback exporter job stopped")
 	}
+	if digestJob != nil {
+		digestJob.Stop()
+	}
 
 	// graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -67,7 +67,7 @@ func TestRegisterRoutes(t *testing.T) {
 	aiHandler := handlers.NewAIHandler(fakeProvider{}, fakePrompt{}, zap.NewNop())
 	healthHandler := handlers.NewHealthHandler(nil, nil, &config.Config{Provider: "gemini"})
 
-	registerRoutes(router, aiHandler, nil, nil, healthHandler)
+	registerRoutes(router, aiHandler, nil, nil, nil, healthHandler)
 
 	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
 	rec := httptest.NewRecorder()
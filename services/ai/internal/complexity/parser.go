@@ -0,0 +1,117 @@
+package complexity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/utils"
+)
+
+// Canonical complexity classes accepted in a verdict
+const (
+	Constant     = "O(1)"
+	Logarithmic  = "O(log n)"
+	Linear       = "O(n)"
+	Linearithmic = "O(n log n)"
+	Quadratic    = "O(n^2)"
+	Exponential  = "O(2^n)"
+	Factorial    = "O(n!)"
+)
+
+var ErrNoJSON = errors.New("no JSON object found in model output")
+
+// aliases maps a squashed spelling (see squash) onto its canonical form
+var aliases = map[string]string{
+	"o(1)":         Constant,
+	"o(c)":         Constant,
+	"o(logn)":      Logarithmic,
+	"o(lgn)":       Logarithmic,
+	"o(log(n))":    Logarithmic,
+	"o(log2n)":     Logarithmic,
+	"o(log_2n)":    Logarithmic,
+	"o(n)":         Linear,
+	"o(nlogn)":     Linearithmic,
+	"o(nlgn)":      Linearithmic,
+	"o(nlog(n))":   Linearithmic,
+	"o(nlog2n)":    Linearithmic,
+	"o(nlog_2n)":   Linearithmic,
+	"o(lognn)":     Linearithmic,
+	"o(n^2)":       Quadratic,
+	"o(nn)":        Quadratic,
+	"o(n2)":        Quadratic,
+	"o(2^n)":       Exponential,
+	"o(2^(n))":     Exponential,
+	"o(n!)":        Factorial,
+	"o(factorial)": Factorial,
+}
+
+// squash lowercases s and strips the separators models tend to sprinkle into
+// complexity strings, so "O(N * log N)" and "o(nlogn)" compare equal.
+func squash(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(
+		"**", "^",
+		"²", "^2",
+		"θ(", "o(",
+		"ο(", "o(", // greek omicron
+		"⋅", "",
+		"·", "",
+		"*", "",
+		" ", "",
+		"\t", "",
+	).Replace(s)
+	return s
+}
+
+// Canonicalize maps s onto one of the canonical complexity classes. ok is
+// false when s is outside the known grammar.
+func Canonicalize(s string) (canonical string, ok bool) {
+	canonical, ok = aliases[squash(s)]
+	return canonical, ok
+}
+
+// Parse extracts a complexity verdict from model output. It tolerates
+// markdown fences and chatter around the JSON object, but rejects verdicts
+// whose time or space complexity falls outside the known grammar.
+func Parse(text string) (*models.ComplexityAnalysis, error) {
+	body := utils.StripFences(text)
+	start := strings.Index(body, "{")
+	end := strings.LastIndex(body, "}")
+	if start < 0 || end < start {
+		return nil, ErrNoJSON
+	}
+
+	var analysis models.ComplexityAnalysis
+	if err := json.Unmarshal([]byte(body[start:end+1]), &analysis); err != nil {
+		return nil, fmt.Errorf("invalid verdict JSON: %w", err)
+	}
+
+	var ok bool
+	if analysis.TimeComplexity, ok = Canonicalize(analysis.TimeComplexity); !ok {
+		return nil, fmt.Errorf("unrecognised time complexity in %q", body[start:end+1])
+	}
+	if analysis.SpaceComplexity, ok = Canonicalize(analysis.SpaceComplexity); !ok {
+		return nil, fmt.Errorf("unrecognised space complexity in %q", body[start:end+1])
+	}
+
+	// Operation-level complexities are informative only; keep the model's
+	// wording when it does not fit the grammar.
+	for i, op := range analysis.DominantOperations {
+		if canonical, ok := Canonicalize(op.Complexity); ok {
+			analysis.DominantOperations[i].Complexity = canonical
+		}
+	}
+	if analysis.DominantOperations == nil {
+		analysis.DominantOperations = []models.DominantOperation{}
+	}
+
+	if analysis.Confidence < 0 {
+		analysis.Confidence = 0
+	} else if analysis.Confidence > 1 {
+		analysis.Confidence = 1
+	}
+	return &analysis, nil
+}
@@ -0,0 +1,87 @@
+package complexity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalizeNearMisses(t *testing.T) {
+	cases := map[string]string{
+		"O(1)":          Constant,
+		"o(log N)":      Logarithmic,
+		"O(lg n)":       Logarithmic,
+		"O(N)":          Linear,
+		"O(N*logN)":     Linearithmic,
+		"O(n log(n))":   Linearithmic,
+		"Θ(n · log n)":  Linearithmic,
+		"O(n²)":         Quadratic,
+		"O(n * n)":      Quadratic,
+		"O(n**2)":       Quadratic,
+		"O(2**n)":       Exponential,
+		"O(n!)":         Factorial,
+		"  O( n ^ 2 ) ": Quadratic,
+	}
+	for in, want := range cases {
+		got, ok := Canonicalize(in)
+		if !ok || got != want {
+			t.Errorf("Canonicalize(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+
+	for _, in := range []string{"O(m*n)", "O(n^3)", "linear", ""} {
+		if got, ok := Canonicalize(in); ok {
+			t.Errorf("Canonicalize(%q) = %q; expected rejection", in, got)
+		}
+	}
+}
+
+func TestParseCleanJSON(t *testing.T) {
+	text := `{"timeComplexity":"O(N*logN)","spaceComplexity":"O(n)","dominantOperations":[{"description":"sort","complexity":"O(n lg n)"},{"description":"merge k lists","complexity":"O(nk)"}],"confidence":0.8}`
+
+	got, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got.TimeComplexity != Linearithmic || got.SpaceComplexity != Linear {
+		t.Fatalf("unexpected complexities: %+v", got)
+	}
+	if got.DominantOperations[0].Complexity != Linearithmic {
+		t.Fatalf("expected operation complexity canonicalized, got %q", got.DominantOperations[0].Complexity)
+	}
+	if got.DominantOperations[1].Complexity != "O(nk)" {
+		t.Fatalf("expected off-grammar operation complexity kept, got %q", got.DominantOperations[1].Complexity)
+	}
+	if got.Confidence != 0.8 {
+		t.Fatalf("unexpected confidence %v", got.Confidence)
+	}
+}
+
+func TestParseFencedJSONWithChatter(t *testing.T) {
+	text := "```json\nHere you go:\n{\"timeComplexity\":\"O(n^2)\",\"spaceComplexity\":\"O(1)\",\"confidence\":1.7}\n```"
+
+	got, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got.TimeComplexity != Quadratic || got.SpaceComplexity != Constant {
+		t.Fatalf("unexpected complexities: %+v", got)
+	}
+	if got.Confidence != 1 {
+		t.Fatalf("expected confidence clamped to 1, got %v", got.Confidence)
+	}
+	if got.DominantOperations == nil {
+		t.Fatalf("expected empty operations slice, got nil")
+	}
+}
+
+func TestParseRejectsGarbage(t *testing.T) {
+	if _, err := Parse("The algorithm is pretty fast."); !errors.Is(err, ErrNoJSON) {
+		t.Fatalf("expected ErrNoJSON, got %v", err)
+	}
+	if _, err := Parse(`{"timeComplexity": O(n)}`); err == nil {
+		t.Fatalf("expected error for malformed JSON")
+	}
+	if _, err := Parse(`{"timeComplexity":"O(n^3)","spaceComplexity":"O(1)"}`); err == nil {
+		t.Fatalf("expected error for complexity outside the grammar")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"peerprep/ai/internal/models"
@@ -47,6 +48,7 @@ func (fm *FeedbackManager) SubmitFeedback(requestID string, isPositive bool) err
 		Response:     ctx.Response,
 		IsPositive:   isPositive,
 		ModelVersion: ctx.ModelVersion,
+		Moderation:   strings.Join(ctx.Moderation, ","),
 		FeedbackAt:   time.Now(),
 		Exported:     false,
 	}
@@ -196,3 +198,21 @@ func (fm *FeedbackManager) GetFeedbackStats() (map[string]interface{}, error) {
 
 	return stats, nil
 }
+
+// GetFeedbackStatsBetween counts feedback given in [since, until), grouped by
+// request type and model version
+func (fm *FeedbackManager) GetFeedbackStatsBetween(since, until time.Time) ([]models.FeedbackGroupStats, error) {
+	var stats []models.FeedbackGroupStats
+
+	err := fm.db.Model(&models.AIFeedback{}).
+		Select("request_type, model_version, COUNT(*) AS total, SUM(CASE WHEN is_positive THEN 1 ELSE 0 END) AS positive").
+		Where("feedback_at >= ? AND feedback_at < ?", since, until).
+		Group("request_type, model_version").
+		Order("request_type, model_version").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feedback stats between %v and %v: %w", since, until, err)
+	}
+
+	return stats, nil
+}
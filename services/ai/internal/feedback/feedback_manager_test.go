@@ -184,3 +184,37 @@ func TestGetFeedbackStats(t *testing.T) {
 		t.Fatalf("expected cached_contexts 1, got %+v", stats["cached_contexts"])
 	}
 }
+
+func TestGetFeedbackStatsBetween(t *testing.T) {
+	fm := newTestFeedbackManager(t)
+	now := time.Now()
+	seed := func(id, requestType, version string, positive bool, ts time.Time) {
+		fb := models.AIFeedback{RequestID: id, RequestType: requestType, Prompt: "p", Response: "r", IsPositive: positive, ModelVersion: version, FeedbackAt: ts}
+		if err := fm.db.Create(&fb).Error; err != nil {
+			t.Fatalf("failed seeding feedback: %v", err)
+		}
+	}
+	seed("a", "hint", "v1", true, now.Add(-time.Hour))
+	seed("b", "hint", "v1", false, now.Add(-2*time.Hour))
+	seed("c", "hint", "v2", false, now.Add(-time.Hour))
+	seed("d", "explanation", "v1", true, now.Add(-time.Hour))
+	seed("e", "hint", "v1", true, now.Add(-48*time.Hour)) // before the window
+
+	stats, err := fm.GetFeedbackStatsBetween(now.Add(-24*time.Hour), now)
+	if err != nil {
+		t.Fatalf("GetFeedbackStatsBetween error: %v", err)
+	}
+	expected := []models.FeedbackGroupStats{
+		{RequestType: "explanation", ModelVersion: "v1", Total: 1, Positive: 1},
+		{RequestType: "hint", ModelVersion: "v1", Total: 2, Positive: 1},
+		{RequestType: "hint", ModelVersion: "v2", Total: 1, Positive: 0},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d groups, got %+v", len(expected), stats)
+	}
+	for i := range expected {
+		if stats[i] != expected[i] {
+			t.Fatalf("group %d: expected %+v, got %+v", i, expected[i], stats[i])
+		}
+	}
+}
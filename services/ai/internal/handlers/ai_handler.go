@@ -3,15 +3,18 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	"peerprep/ai/internal/complexity"
 	"peerprep/ai/internal/feedback"
 	"peerprep/ai/internal/llm"
 	"peerprep/ai/internal/middleware"
 	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
 	"peerprep/ai/internal/prompts"
 	"peerprep/ai/internal/utils"
 )
@@ -21,6 +24,8 @@ type AIHandler struct {
 	promptManager   prompts.PromptProvider
 	logger          *zap.Logger
 	feedbackManager *feedback.FeedbackManager // Optional, can be nil
+	screen          *moderation.Screen        // Optional, can be nil
+	moderationStats *moderation.Stats
 }
 
 func NewAIHandler(provider llm.Provider, promptManager prompts.PromptProvider, logger *zap.Logger) *AIHandler {
@@ -29,6 +34,8 @@ func NewAIHandler(provider llm.Provider, promptManager prompts.PromptProvider, l
 		promptManager:   promptManager,
 		logger:          logger,
 		feedbackManager: nil, // Set later via SetFeedbackManager if needed
+		screen:          nil, // Set later via SetModeration if needed
+		moderationStats: moderation.NewStats(),
 	}
 }
 
@@ -37,8 +44,15 @@ func (h *AIHandler) SetFeedbackManager(fm *feedback.FeedbackManager) {
 	h.feedbackManager = fm
 }
 
+// SetModeration sets the screen that user-supplied content passes before any
+// prompt is built
+func (h *AIHandler) SetModeration(screen *moderation.Screen) {
+	h.screen = screen
+}
+
 // storeRequestContext stores request context for feedback collection (if enabled)
-func (h *AIHandler) storeRequestContext(requestID, requestType, prompt, response, modelVersion string) {
+// moderation carries the categories of borderline content so it stays tagged
+func (h *AIHandler) storeRequestContext(requestID, requestType, prompt, response, modelVersion string, moderation []string) {
 	if h.feedbackManager != nil {
 		ctx := &models.RequestContext{
 			RequestID:    requestID,
@@ -46,6 +60,7 @@ func (h *AIHandler) storeRequestContext(requestID, requestType, prompt, response
 			Prompt:       prompt,
 			Response:     response,
 			ModelVersion: modelVersion,
+			Moderation:   moderation,
 			Timestamp:    time.Now(),
 		}
 		h.feedbackManager.StoreRequestContext(ctx)
@@ -59,6 +74,11 @@ func (h *AIHandler) ExplainHandler(w http.ResponseWriter, r *http.Request) {
 	// Generate request ID if not provided
 	req.RequestID = ensureRequestID(req.RequestID)
 
+	verdict, ok := h.moderate(w, r, req.RequestID, req.Code)
+	if !ok {
+		return
+	}
+
 	// build the prompt using the prompt manager
 	promptData := map[string]interface{}{
 		"Language": req.Language,
@@ -78,23 +98,7 @@ func (h *AIHandler) ExplainHandler(w http.ResponseWriter, r *http.Request) {
 	// call the AI provider with the built prompt
 	response, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, req.DetailLevel)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		errorCode := "ai_error"
-		errorMsg := "Failed to generate explanation"
-
-		// Check if it's a rate limit error
-		var provErr *llm.ProviderError
-		if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeRateLimit {
-			statusCode = http.StatusTooManyRequests
-			errorCode = "rate_limit_exceeded"
-			errorMsg = "API rate limit exceeded, please try again later"
-		}
-
-		h.logger.Error("AI provider error", zap.Error(err), zap.String("request_id", req.RequestID))
-		utils.JSON(w, statusCode, models.ErrorResponse{
-			Code:    errorCode,
-			Message: errorMsg,
-		})
+		h.writeProviderError(w, err, req.RequestID, "Failed to generate explanation")
 		return
 	}
 
@@ -104,11 +108,95 @@ func (h *AIHandler) ExplainHandler(w http.ResponseWriter, r *http.Request) {
 		zap.Int("processing_time_ms", response.Metadata.ProcessingTime))
 
 	// Store request context for feedback
-	h.storeRequestContext(req.RequestID, "explain", prompt, response.Content, response.Metadata.ModelVersion)
+	h.storeRequestContext(req.RequestID, "explain", prompt, response.Content, response.Metadata.ModelVersion, verdict.Categories)
 
 	utils.JSON(w, http.StatusOK, response)
 }
 
+// moderate screens user-supplied content before prompt assembly. Blocked
+// content gets a 422 and never reaches the provider; it returns false once
+// that response has been written.
+func (h *AIHandler) moderate(w http.ResponseWriter, r *http.Request, requestID, content string) (moderation.Verdict, bool) {
+	if h.screen == nil {
+		return moderation.Verdict{Outcome: moderation.OutcomeAllowed}, true
+	}
+
+	verdict, err := h.screen.Check(r.Context(), content)
+	if err != nil {
+		// fail open: the remaining moderators still decided
+		h.logger.Warn("Moderation check failed", zap.Error(err), zap.String("request_id", requestID))
+	}
+	h.moderationStats.Record(verdict)
+
+	switch verdict.Outcome {
+	case moderation.OutcomeBlocked:
+		h.logger.Info("Content rejected by moderation",
+			zap.String("request_id", requestID), zap.Strings("categories", verdict.Categories))
+		writeContentRejected(w, verdict.Categories)
+		return verdict, false
+	case moderation.OutcomeFlagged:
+		h.logger.Info("Borderline content flagged by moderation",
+			zap.String("request_id", requestID), zap.Strings("categories", verdict.Categories))
+	}
+	return verdict, true
+}
+
+// writeProviderError maps a provider failure onto the API error: rate limits
+// become 429, safety blocks the same 422 moderation uses, the rest 500
+func (h *AIHandler) writeProviderError(w http.ResponseWriter, err error, requestID, errorMsg string) {
+	var provErr *llm.ProviderError
+	if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeSafetyBlock {
+		categories := []string{}
+		if provErr.Category != "" {
+			categories = append(categories, provErr.Category)
+		}
+		h.moderationStats.Record(moderation.Verdict{Outcome: moderation.OutcomeBlocked, Categories: categories})
+		h.logger.Info("Content rejected by provider safety filters",
+			zap.String("request_id", requestID), zap.Strings("categories", categories))
+		writeContentRejected(w, categories)
+		return
+	}
+
+	statusCode := http.StatusInternalServerError
+	errorCode := "ai_error"
+
+	// Check if it's a rate limit error
+	if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeRateLimit {
+		statusCode = http.StatusTooManyRequests
+		errorCode = "rate_limit_exceeded"
+		errorMsg = "API rate limit exceeded, please try again later"
+	}
+
+	h.logger.Error("AI provider error", zap.Error(err), zap.String("request_id", requestID))
+	utils.JSON(w, statusCode, models.ErrorResponse{
+		Code:    errorCode,
+		Message: errorMsg,
+	})
+}
+
+func writeContentRejected(w http.ResponseWriter, categories []string) {
+	if categories == nil {
+		categories = []string{}
+	}
+	utils.JSON(w, http.StatusUnprocessableEntity, models.ContentRejectedResponse{
+		Error: models.ContentRejection{
+			Code:       "content_rejected",
+			Message:    "The submitted content cannot be processed",
+			Categories: categories,
+		},
+	})
+}
+
+// ModerationStats returns the moderation counts recorded by this handler
+func (h *AIHandler) ModerationStats() *moderation.Stats {
+	return h.moderationStats
+}
+
+// ModerationStatsHandler reports moderation outcomes and category counts
+func (h *AIHandler) ModerationStatsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.JSON(w, http.StatusOK, models.Resp{OK: true, Info: h.moderationStats.Snapshot()})
+}
+
 func generateRequestID() string {
 	return uuid.New().String()
 }
@@ -127,12 +215,18 @@ func (h *AIHandler) HintHandler(w http.ResponseWriter, r *http.Request) {
 
 	req.RequestID = ensureRequestID(req.RequestID)
 
+	verdict, ok := h.moderate(w, r, req.RequestID, req.Code)
+	if !ok {
+		return
+	}
+
 	// Build prompt directly from hint.yaml
 	promptData := map[string]interface{}{
-		"Language":  req.Language,
-		"Code":      req.Code,
-		"Question":  req.Question,
-		"HintLevel": req.HintLevel,
+		"Language":   req.Language,
+		"Code":       req.Code,
+		"Question":   req.Question,
+		"HintLevel":  req.HintLevel,
+		"Discussion": req.Discussion,
 	}
 
 	prompt, err := h.promptManager.BuildPrompt("hint", "default", promptData)
@@ -148,23 +242,7 @@ func (h *AIHandler) HintHandler(w http.ResponseWriter, r *http.Request) {
 	// Reuse same provider call as explain
 	result, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, req.HintLevel)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		errorCode := "ai_error"
-		errorMsg := "Failed to generate hint"
-
-		// Check if it's a rate limit error
-		var provErr *llm.ProviderError
-		if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeRateLimit {
-			statusCode = http.StatusTooManyRequests
-			errorCode = "rate_limit_exceeded"
-			errorMsg = "API rate limit exceeded, please try again later"
-		}
-
-		h.logger.Error("AI provider error", zap.Error(err), zap.String("request_id", req.RequestID))
-		utils.JSON(w, statusCode, models.ErrorResponse{
-			Code:    errorCode,
-			Message: errorMsg,
-		})
+		h.writeProviderError(w, err, req.RequestID, "Failed to generate hint")
 		return
 	}
 
@@ -175,7 +253,7 @@ func (h *AIHandler) HintHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Store request context for feedback
-	h.storeRequestContext(req.RequestID, "hint", prompt, result.Content, result.Metadata.ModelVersion)
+	h.storeRequestContext(req.RequestID, "hint", prompt, result.Content, result.Metadata.ModelVersion, verdict.Categories)
 
 	utils.JSON(w, http.StatusOK, resp)
 }
@@ -184,6 +262,11 @@ func (h *AIHandler) TestsHandler(w http.ResponseWriter, r *http.Request) {
 	req := middleware.GetValidatedRequest[*models.TestGenRequest](r)
 	req.RequestID = ensureRequestID(req.RequestID)
 
+	verdict, ok := h.moderate(w, r, req.RequestID, req.Code)
+	if !ok {
+		return
+	}
+
 	// Build prompt from templates/tests.yaml
 	promptData := map[string]interface{}{
 		"Language":  req.Language,
@@ -204,23 +287,7 @@ func (h *AIHandler) TestsHandler(w http.ResponseWriter, r *http.Request) {
 	// Reuse provider call
 	out, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, models.DefaultDetailLevel)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		errorCode := "ai_error"
-		errorMsg := "Failed to generate test cases"
-
-		// Check if it's a rate limit error
-		var provErr *llm.ProviderError
-		if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeRateLimit {
-			statusCode = http.StatusTooManyRequests
-			errorCode = "rate_limit_exceeded"
-			errorMsg = "API rate limit exceeded, please try again later"
-		}
-
-		h.logger.Error("AI provider error", zap.Error(err), zap.String("request_id", req.RequestID))
-		utils.JSON(w, statusCode, models.ErrorResponse{
-			Code:    errorCode,
-			Message: errorMsg,
-		})
+		h.writeProviderError(w, err, req.RequestID, "Failed to generate test cases")
 		return
 	}
 
@@ -231,7 +298,7 @@ func (h *AIHandler) TestsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Store request context for feedback
-	h.storeRequestContext(req.RequestID, "tests", prompt, out.Content, out.Metadata.ModelVersion)
+	h.storeRequestContext(req.RequestID, "tests", prompt, out.Content, out.Metadata.ModelVersion, verdict.Categories)
 
 	utils.JSON(w, http.StatusOK, resp)
 }
@@ -240,6 +307,11 @@ func (h *AIHandler) RefactorTipsHandler(w http.ResponseWriter, r *http.Request)
 	req := middleware.GetValidatedRequest[*models.RefactorTipsRequest](r)
 	req.RequestID = ensureRequestID(req.RequestID)
 
+	verdict, ok := h.moderate(w, r, req.RequestID, req.Code)
+	if !ok {
+		return
+	}
+
 	data := map[string]interface{}{
 		"Language": req.Language,
 		"Code":     utils.AddLineNumbers(req.Code),
@@ -258,30 +330,14 @@ func (h *AIHandler) RefactorTipsHandler(w http.ResponseWriter, r *http.Request)
 
 	result, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, models.DefaultDetailLevel)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		errorCode := "ai_error"
-		errorMsg := "Failed to generate refactor tips"
-
-		// Check if it's a rate limit error
-		var provErr *llm.ProviderError
-		if errors.As(err, &provErr) && provErr.Code == llm.ErrCodeRateLimit {
-			statusCode = http.StatusTooManyRequests
-			errorCode = "rate_limit_exceeded"
-			errorMsg = "API rate limit exceeded, please try again later"
-		}
-
-		h.logger.Error("AI provider error", zap.Error(err), zap.String("request_id", req.RequestID))
-		utils.JSON(w, statusCode, models.ErrorResponse{
-			Code:    errorCode,
-			Message: errorMsg,
-		})
+		h.writeProviderError(w, err, req.RequestID, "Failed to generate refactor tips")
 		return
 	}
 
 	cleaned := utils.StripFences(result.Content)
 
 	// Store request context for feedback
-	h.storeRequestContext(req.RequestID, "refactor_tips", prompt, result.Content, result.Metadata.ModelVersion)
+	h.storeRequestContext(req.RequestID, "refactor_tips", prompt, result.Content, result.Metadata.ModelVersion, verdict.Categories)
 
 	utils.JSON(w, http.StatusOK, models.RefactorTipsTextResponse{
 		TipsText:  cleaned,
@@ -289,3 +345,84 @@ func (h *AIHandler) RefactorTipsHandler(w http.ResponseWriter, r *http.Request)
 		Metadata:  result.Metadata,
 	})
 }
+
+func (h *AIHandler) ComplexityHandler(w http.ResponseWriter, r *http.Request) {
+	req := middleware.GetValidatedRequest[*models.ComplexityRequest](r)
+	req.RequestID = ensureRequestID(req.RequestID)
+
+	verdict, ok := h.moderate(w, r, req.RequestID, req.Code)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Language": req.Language,
+		"Code":     req.Code,
+		"Question": req.Question,
+	}
+
+	prompt, err := h.promptManager.BuildPrompt("complexity", "default", data)
+	if err != nil {
+		h.logger.Error("Failed to build prompt", zap.Error(err), zap.String("request_id", req.RequestID))
+		utils.JSON(w, http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "prompt_error",
+			Message: "Failed to build AI prompt",
+		})
+		return
+	}
+
+	result, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, models.DefaultDetailLevel)
+	if err != nil {
+		h.writeProviderError(w, err, req.RequestID, "Failed to analyse complexity")
+		return
+	}
+
+	analysis, parseErr := complexity.Parse(result.Content)
+	if parseErr != nil {
+		// Give the model one chance to fix its formatting before falling back
+		h.logger.Warn("Complexity verdict not parseable, retrying with repair prompt",
+			zap.Error(parseErr), zap.String("request_id", req.RequestID))
+		analysis, result = h.repairComplexity(r, req, result)
+	}
+
+	resp := models.ComplexityResponse{
+		Analysis:  analysis,
+		RequestID: req.RequestID,
+		Metadata:  result.Metadata,
+	}
+	if analysis == nil {
+		resp.Raw = strings.TrimSpace(result.Content)
+	}
+
+	// Store request context for feedback
+	h.storeRequestContext(req.RequestID, "complexity", prompt, result.Content, result.Metadata.ModelVersion, verdict.Categories)
+
+	utils.JSON(w, http.StatusOK, resp)
+}
+
+// repairComplexity re-asks the model for bare JSON. It returns a nil analysis
+// when the retry fails too, together with the output the raw fallback should use.
+func (h *AIHandler) repairComplexity(r *http.Request, req *models.ComplexityRequest, previous *models.GenerationResponse) (*models.ComplexityAnalysis, *models.GenerationResponse) {
+	prompt, err := h.promptManager.BuildPrompt("complexity", "repair", map[string]interface{}{
+		"Language": req.Language,
+		"Previous": previous.Content,
+	})
+	if err != nil {
+		h.logger.Error("Failed to build repair prompt", zap.Error(err), zap.String("request_id", req.RequestID))
+		return nil, previous
+	}
+
+	retry, err := h.provider.GenerateContent(r.Context(), prompt, req.RequestID, models.DefaultDetailLevel)
+	if err != nil {
+		h.logger.Warn("Complexity repair request failed", zap.Error(err), zap.String("request_id", req.RequestID))
+		return nil, previous
+	}
+
+	analysis, err := complexity.Parse(retry.Content)
+	if err != nil {
+		h.logger.Warn("Complexity repair output not parseable, returning raw text",
+			zap.Error(err), zap.String("request_id", req.RequestID))
+		return nil, retry
+	}
+	return analysis, retry
+}
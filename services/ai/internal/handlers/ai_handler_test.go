@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"peerprep/ai/internal/llm"
 	"peerprep/ai/internal/middleware"
 	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
 	"peerprep/ai/internal/prompts"
 
 	"go.uber.org/zap"
@@ -187,3 +189,242 @@ func TestRefactorTipsHandlerStripsFences(t *testing.T) {
 		t.Fatalf("expected refactor tips to be stripped: %s", rec.Body.String())
 	}
 }
+
+// scriptedProvider returns the given outputs in order and counts calls
+func scriptedProvider(calls *int, outputs ...string) *mockProvider {
+	return &mockProvider{
+		generateContentFn: func(ctx context.Context, prompt, requestID, detailLevel string) (*models.GenerationResponse, error) {
+			out := outputs[*calls]
+			*calls++
+			return &models.GenerationResponse{Content: out, Metadata: models.GenerationMetadata{ModelVersion: "v1"}}, nil
+		},
+	}
+}
+
+func decodeComplexity(t *testing.T, rec *httptest.ResponseRecorder) models.ComplexityResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp models.ComplexityResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response JSON: %v", err)
+	}
+	return resp
+}
+
+func TestComplexityHandler(t *testing.T) {
+	const clean = `{"timeComplexity":"O(N*logN)","spaceComplexity":"O(1)","dominantOperations":[{"description":"sort","complexity":"O(n log n)"}],"confidence":0.9}`
+	body := `{"code":"a.sort()","language":"python"}`
+
+	t.Run("clean JSON", func(t *testing.T) {
+		calls := 0
+		handler := newTestAIHandler(scriptedProvider(&calls, clean), &mockPromptManager{})
+		fm := newSQLiteFeedbackManager(t)
+		handler.SetFeedbackManager(fm)
+
+		wrapped := middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler))
+		resp := decodeComplexity(t, performRequest(wrapped, body))
+
+		if calls != 1 {
+			t.Fatalf("expected a single provider call, got %d", calls)
+		}
+		if resp.Analysis == nil || resp.Analysis.TimeComplexity != "O(n log n)" || resp.Raw != "" {
+			t.Fatalf("unexpected response: %+v", resp)
+		}
+		stats, _ := fm.GetFeedbackStats()
+		if stats["cached_contexts"].(int) != 1 {
+			t.Fatalf("expected context to be cached for feedback")
+		}
+	})
+
+	t.Run("fenced JSON", func(t *testing.T) {
+		calls := 0
+		handler := newTestAIHandler(scriptedProvider(&calls, "```json\n"+clean+"\n```"), &mockPromptManager{})
+
+		wrapped := middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler))
+		resp := decodeComplexity(t, performRequest(wrapped, body))
+
+		if calls != 1 || resp.Analysis == nil || resp.Analysis.SpaceComplexity != "O(1)" {
+			t.Fatalf("unexpected response after %d calls: %+v", calls, resp)
+		}
+	})
+
+	t.Run("repair retry", func(t *testing.T) {
+		calls := 0
+		var variants []string
+		prompts := &mockPromptManager{
+			buildPromptFn: func(mode, variant string, data interface{}) (string, error) {
+				variants = append(variants, mode+":"+variant)
+				return "prompt", nil
+			},
+		}
+		handler := newTestAIHandler(scriptedProvider(&calls, "It runs in n log n time.", clean), prompts)
+
+		wrapped := middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler))
+		resp := decodeComplexity(t, performRequest(wrapped, body))
+
+		if calls != 2 {
+			t.Fatalf("expected one repair retry, got %d calls", calls)
+		}
+		if strings.Join(variants, ",") != "complexity:default,complexity:repair" {
+			t.Fatalf("unexpected prompts used: %v", variants)
+		}
+		if resp.Analysis == nil || resp.Analysis.TimeComplexity != "O(n log n)" {
+			t.Fatalf("expected repaired analysis, got %+v", resp)
+		}
+	})
+
+	t.Run("garbage falls back to raw", func(t *testing.T) {
+		calls := 0
+		handler := newTestAIHandler(scriptedProvider(&calls, "no idea", "still no idea"), &mockPromptManager{})
+
+		wrapped := middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler))
+		rec := performRequest(wrapped, body)
+		resp := decodeComplexity(t, rec)
+
+		if calls != 2 {
+			t.Fatalf("expected exactly one retry, got %d calls", calls)
+		}
+		if resp.Analysis != nil || resp.Raw != "still no idea" {
+			t.Fatalf("unexpected fallback: %+v", resp)
+		}
+		if strings.Contains(rec.Body.String(), `"analysis"`) {
+			t.Fatalf("fallback should only carry raw text: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		provider := &mockProvider{
+			generateContentFn: func(ctx context.Context, prompt, requestID, detailLevel string) (*models.GenerationResponse, error) {
+				return nil, &llm.ProviderError{Code: llm.ErrCodeRateLimit}
+			},
+		}
+		handler := newTestAIHandler(provider, &mockPromptManager{})
+
+		wrapped := middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler))
+		if rec := performRequest(wrapped, body); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", rec.Code)
+		}
+	})
+}
+
+func newModeratedHandler(provider llm.Provider) *AIHandler {
+	handler := newTestAIHandler(provider, &mockPromptManager{})
+	handler.SetModeration(moderation.NewScreen(
+		moderation.Thresholds{Flag: 0.5, Block: 0.9},
+		moderation.NewKeywordModerator(moderation.DefaultKeywords),
+	))
+	return handler
+}
+
+func decodeRejection(t *testing.T, rec *httptest.ResponseRecorder) models.ContentRejection {
+	t.Helper()
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp models.ContentRejectedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response JSON: %v", err)
+	}
+	if resp.Error.Code != "content_rejected" {
+		t.Fatalf("unexpected error code: %s", rec.Body.String())
+	}
+	return resp.Error
+}
+
+func TestModerationBlocksBeforeProviderCall(t *testing.T) {
+	calls := 0
+	handler := newModeratedHandler(scriptedProvider(&calls, "never"))
+
+	routes := map[string]http.Handler{
+		"explain":       middleware.ValidateRequest[*models.ExplainRequest]()(http.HandlerFunc(handler.ExplainHandler)),
+		"refactor tips": middleware.ValidateRequest[*models.RefactorTipsRequest]()(http.HandlerFunc(handler.RefactorTipsHandler)),
+		"complexity":    middleware.ValidateRequest[*models.ComplexityRequest]()(http.HandlerFunc(handler.ComplexityHandler)),
+	}
+	for name, route := range routes {
+		t.Run(name, func(t *testing.T) {
+			body := `{"code":"# kill yourself\nprint(1)","language":"python","detail_level":"beginner","question":{"prompt_markdown":"p"}}`
+			rejection := decodeRejection(t, performRequest(route, body))
+			if len(rejection.Categories) != 1 || rejection.Categories[0] != moderation.CategoryHarassment {
+				t.Fatalf("unexpected categories: %v", rejection.Categories)
+			}
+		})
+	}
+	if calls != 0 {
+		t.Fatalf("blocked content reached the provider %d times", calls)
+	}
+
+	stats := handler.moderationStats.Snapshot()
+	if stats["outcomes"].(map[string]int)[moderation.OutcomeBlocked] != len(routes) {
+		t.Fatalf("expected blocked outcomes to be counted: %v", stats)
+	}
+}
+
+func TestModerationTagsBorderlineContent(t *testing.T) {
+	calls := 0
+	handler := newModeratedHandler(scriptedProvider(&calls, "answer"))
+	fm := newSQLiteFeedbackManager(t)
+	handler.SetFeedbackManager(fm)
+
+	wrapped := middleware.ValidateRequest[*models.ExplainRequest]()(http.HandlerFunc(handler.ExplainHandler))
+	rec := performRequest(wrapped, `{"code":"# what idiot wrote this\nprint(1)","language":"python","detail_level":"beginner","request_id":"req-borderline"}`)
+	if rec.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected borderline content to proceed, got %d after %d calls", rec.Code, calls)
+	}
+
+	if err := fm.SubmitFeedback("req-borderline", true); err != nil {
+		t.Fatalf("SubmitFeedback failed: %v", err)
+	}
+	stored, err := fm.GetUnexportedFeedback(0)
+	if err != nil || len(stored) != 1 || stored[0].Moderation != moderation.CategoryHarassment {
+		t.Fatalf("expected stored interaction to be tagged, got %+v err=%v", stored, err)
+	}
+
+	stats := handler.moderationStats.Snapshot()
+	if stats["outcomes"].(map[string]int)[moderation.OutcomeFlagged] != 1 {
+		t.Fatalf("expected flagged outcome to be counted: %v", stats)
+	}
+}
+
+func TestProviderSafetyBlockReturnsStructured422(t *testing.T) {
+	cases := map[string]*llm.ProviderError{
+		"with category":    {Provider: "gemini", Code: llm.ErrCodeSafetyBlock, Category: moderation.CategoryViolence},
+		"without category": {Provider: "gemini", Code: llm.ErrCodeSafetyBlock},
+	}
+	for name, provErr := range cases {
+		t.Run(name, func(t *testing.T) {
+			provider := &mockProvider{
+				generateContentFn: func(ctx context.Context, prompt, requestID, detailLevel string) (*models.GenerationResponse, error) {
+					return nil, provErr
+				},
+			}
+			handler := newModeratedHandler(provider)
+
+			wrapped := middleware.ValidateRequest[*models.ExplainRequest]()(http.HandlerFunc(handler.ExplainHandler))
+			rec := performRequest(wrapped, `{"code":"print(1)","language":"python","detail_level":"beginner"}`)
+			rejection := decodeRejection(t, rec)
+			if provErr.Category == "" {
+				if rejection.Categories == nil || len(rejection.Categories) != 0 || !strings.Contains(rec.Body.String(), `"categories":[]`) {
+					t.Fatalf("expected empty categories, got %s", rec.Body.String())
+				}
+				return
+			}
+			if len(rejection.Categories) != 1 || rejection.Categories[0] != provErr.Category {
+				t.Fatalf("expected provider category, got %v", rejection.Categories)
+			}
+		})
+	}
+}
+
+func TestModerationStatsHandler(t *testing.T) {
+	handler := newModeratedHandler(&mockProvider{})
+	wrapped := middleware.ValidateRequest[*models.ExplainRequest]()(http.HandlerFunc(handler.ExplainHandler))
+	performRequest(wrapped, `{"code":"print(1)","language":"python","detail_level":"beginner"}`)
+
+	rec := httptest.NewRecorder()
+	handler.ModerationStatsHandler(rec, httptest.NewRequest(http.MethodGet, "/moderation/stats", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"allowed":1`) {
+		t.Fatalf("unexpected stats response: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"peerprep/ai/internal/jobs"
+	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/utils"
+)
+
+type DigestHandler struct {
+	digestJob *jobs.FeedbackDigestJob
+}
+
+func NewDigestHandler(digestJob *jobs.FeedbackDigestJob) *DigestHandler {
+	return &DigestHandler{
+		digestJob: digestJob,
+	}
+}
+
+// GetStatus handles GET /api/v1/ai/digest/status
+func (dh *DigestHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, models.Resp{
+		OK:   true,
+		Info: dh.digestJob.Status(),
+	})
+}
+
+// RunDigest handles POST /api/v1/ai/digest/run
+// The digest is delivered in the background; poll the status endpoint for the outcome.
+func (dh *DigestHandler) RunDigest(w http.ResponseWriter, r *http.Request) {
+	err := dh.digestJob.Trigger()
+	switch {
+	case errors.Is(err, jobs.ErrDigestDisabled):
+		utils.WriteJSON(w, http.StatusServiceUnavailable, models.Resp{
+			OK:   false,
+			Info: "digest webhook is not configured",
+		})
+		return
+	case errors.Is(err, jobs.ErrDigestRunning):
+		utils.WriteJSON(w, http.StatusConflict, models.Resp{
+			OK:   false,
+			Info: "a digest is already running",
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to start digest: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{
+			OK:   false,
+			Info: "failed to start digest",
+		})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, models.Resp{
+		OK:   true,
+		Info: "digest started",
+	})
+}
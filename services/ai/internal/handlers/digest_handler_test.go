@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"peerprep/ai/internal/jobs"
+)
+
+func newDigestHandler(t *testing.T, webhookURL string) *DigestHandler {
+	t.Helper()
+	_, manager := newFeedbackHandlerWithDB(t)
+	return NewDigestHandler(jobs.NewFeedbackDigestJob(manager, nil, &jobs.DigestConfig{
+		Schedule:    "0 9 * * 1",
+		WebhookURL:  webhookURL,
+		MaxAttempts: 1,
+	}))
+}
+
+func digestStatus(t *testing.T, handler *DigestHandler) jobs.DigestStatus {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.GetStatus(rec, httptest.NewRequest(http.MethodGet, "/digest/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		OK   bool              `json:"ok"`
+		Info jobs.DigestStatus `json:"info"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	return resp.Info
+}
+
+func TestDigestRunTriggersDelivery(t *testing.T) {
+	var deliveries atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer webhook.Close()
+	handler := newDigestHandler(t, webhook.URL)
+
+	rec := httptest.NewRecorder()
+	handler.RunDigest(rec, httptest.NewRequest(http.MethodPost, "/digest/run", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	status := digestStatus(t, handler)
+	for status.LastStatus == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		status = digestStatus(t, handler)
+	}
+	if status.LastStatus != "delivered" || status.LastTrigger != "manual" || !status.Enabled || deliveries.Load() != 1 {
+		t.Fatalf("unexpected status after manual run: %+v (deliveries=%d)", status, deliveries.Load())
+	}
+}
+
+func TestDigestRunWhileRunning(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+	handler := newDigestHandler(t, webhook.URL)
+
+	first := httptest.NewRecorder()
+	handler.RunDigest(first, httptest.NewRequest(http.MethodPost, "/digest/run", nil))
+	second := httptest.NewRecorder()
+	handler.RunDigest(second, httptest.NewRequest(http.MethodPost, "/digest/run", nil))
+
+	if first.Code != http.StatusAccepted || second.Code != http.StatusConflict {
+		t.Fatalf("expected 202 then 409, got %d and %d", first.Code, second.Code)
+	}
+}
+
+func TestDigestRunWithoutWebhook(t *testing.T) {
+	handler := newDigestHandler(t, "")
+
+	rec := httptest.NewRecorder()
+	handler.RunDigest(rec, httptest.NewRequest(http.MethodPost, "/digest/run", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	if status := digestStatus(t, handler); status.Enabled || status.Schedule != "0 9 * * 1" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,400 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"peerprep/ai/internal/feedback"
+	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	// ErrDigestDisabled is returned when no webhook URL is configured
+	ErrDigestDisabled = errors.New("feedback digest is disabled")
+	// ErrDigestRunning is returned when a digest is already being built or delivered
+	ErrDigestRunning = errors.New("feedback digest is already running")
+)
+
+// Number of request types listed as worst rated
+const digestWorstCount = 5
+
+// DigestConfig contains configuration for the digest job
+type DigestConfig struct {
+	Schedule     string        // Cron schedule (e.g., "0 9 * * 1" for Monday 9 AM)
+	WebhookURL   string        // Slack-compatible webhook; empty disables the job
+	Period       time.Duration // Length of the summarized period, compared with the one before it
+	MaxAttempts  int           // Delivery attempts before giving up
+	RetryBackoff time.Duration // Wait before the first retry, doubled after each failure
+}
+
+// UsageSource reports usage counts to include in the digest
+type UsageSource interface {
+	Snapshot() map[string]interface{}
+}
+
+// DigestRatings counts ratings in one period
+type DigestRatings struct {
+	Total        int64   `json:"total"`
+	Positive     int64   `json:"positive"`
+	Negative     int64   `json:"negative"`
+	PositiveRate float64 `json:"positive_rate"` // 0-1, 0 when there is no feedback
+}
+
+// DigestGroup compares the ratings of one request type or model version with
+// the previous period
+type DigestGroup struct {
+	Name              string        `json:"name"`
+	Current           DigestRatings `json:"current"`
+	Previous          DigestRatings `json:"previous"`
+	TotalDelta        int64         `json:"total_delta"`
+	PositiveRateDelta float64       `json:"positive_rate_delta"`
+}
+
+// Digest is the payload posted to the webhook. Text holds the same summary as
+// Markdown so Slack incoming webhooks can render it directly.
+type Digest struct {
+	PeriodStart       time.Time              `json:"period_start"`
+	PeriodEnd         time.Time              `json:"period_end"`
+	Overall           DigestGroup            `json:"overall"`
+	ByRequestType     []DigestGroup          `json:"by_request_type"`
+	ByModelVersion    []DigestGroup          `json:"by_model_version"`
+	WorstRequestTypes []DigestGroup          `json:"worst_request_types"`
+	Moderation        map[string]interface{} `json:"moderation,omitempty"` // counts since service start
+	Text              string                 `json:"text"`
+}
+
+// DigestStatus describes the job and its last delivery
+type DigestStatus struct {
+	Enabled         bool       `json:"enabled"`
+	Schedule        string     `json:"schedule"`
+	Running         bool       `json:"running"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	LastTrigger     string     `json:"last_trigger,omitempty"` // "schedule" or "manual"
+	LastStatus      string     `json:"last_status,omitempty"`  // "delivered" or "failed"
+	LastError       string     `json:"last_error,omitempty"`
+	LastAttempts    int        `json:"last_attempts,omitempty"`
+	LastHTTPStatus  int        `json:"last_http_status,omitempty"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+}
+
+// FeedbackDigestJob periodically summarizes feedback trends to a webhook
+type FeedbackDigestJob struct {
+	feedbackManager *feedback.FeedbackManager
+	usage           UsageSource
+	config          *DigestConfig
+	cron            *cron.Cron
+	client          *http.Client
+	now             func() time.Time
+	sleep           func(time.Duration)
+
+	running atomic.Bool
+	mu      sync.Mutex
+	status  DigestStatus
+}
+
+// NewFeedbackDigestJob creates a new digest job. usage may be nil.
+func NewFeedbackDigestJob(feedbackManager *feedback.FeedbackManager, usage UsageSource, config *DigestConfig) *FeedbackDigestJob {
+	if config.Period <= 0 {
+		config.Period = 7 * 24 * time.Hour
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+	return &FeedbackDigestJob{
+		feedbackManager: feedbackManager,
+		usage:           usage,
+		config:          config,
+		cron:            cron.New(),
+		client:          &http.Client{Timeout: 10 * time.Second},
+		now:             time.Now,
+		sleep:           time.Sleep,
+	}
+}
+
+// Start begins the scheduled digest job
+func (fdj *FeedbackDigestJob) Start() error {
+	if fdj.config.WebhookURL == "" {
+		log.Println("Feedback digest webhook not configured, skipping scheduler")
+		return nil
+	}
+
+	log.Printf("Starting feedback digest with schedule: %s", fdj.config.Schedule)
+
+	_, err := fdj.cron.AddFunc(fdj.config.Schedule, func() {
+		if err := fdj.RunDigest("schedule"); err != nil {
+			log.Printf("Digest job failed: %v", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule digest job: %w", err)
+	}
+
+	fdj.cron.Start()
+	log.Println("Feedback digest started successfully")
+
+	return nil
+}
+
+// Stop stops the scheduled digest job
+func (fdj *FeedbackDigestJob) Stop() {
+	if fdj.cron != nil {
+		fdj.cron.Stop()
+		log.Println("Feedback digest stopped")
+	}
+}
+
+// RunDigest builds and delivers a digest for the period ending now. It
+// returns ErrDigestRunning instead of starting a second run.
+func (fdj *FeedbackDigestJob) RunDigest(trigger string) error {
+	if fdj.config.WebhookURL == "" {
+		return ErrDigestDisabled
+	}
+	if !fdj.running.CompareAndSwap(false, true) {
+		return ErrDigestRunning
+	}
+	defer fdj.running.Store(false)
+	return fdj.run(trigger)
+}
+
+// Trigger starts a manual digest in the background, with the same guard as
+// RunDigest
+func (fdj *FeedbackDigestJob) Trigger() error {
+	if fdj.config.WebhookURL == "" {
+		return ErrDigestDisabled
+	}
+	if !fdj.running.CompareAndSwap(false, true) {
+		return ErrDigestRunning
+	}
+	go func() {
+		defer fdj.running.Store(false)
+		if err := fdj.run("manual"); err != nil {
+			log.Printf("Manual digest failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Status returns the job configuration and the outcome of the last run
+func (fdj *FeedbackDigestJob) Status() DigestStatus {
+	fdj.mu.Lock()
+	defer fdj.mu.Unlock()
+	status := fdj.status
+	status.Enabled = fdj.config.WebhookURL != ""
+	status.Schedule = fdj.config.Schedule
+	status.Running = fdj.running.Load()
+	return status
+}
+
+func (fdj *FeedbackDigestJob) run(trigger string) error {
+	startedAt := fdj.now()
+	var attempts, httpStatus int
+
+	digest, err := fdj.BuildDigest(startedAt)
+	if err == nil {
+		var payload []byte
+		if payload, err = json.Marshal(digest); err == nil {
+			attempts, httpStatus, err = fdj.deliver(payload)
+		}
+	}
+
+	fdj.mu.Lock()
+	defer fdj.mu.Unlock()
+	fdj.status.LastRunAt = &startedAt
+	fdj.status.LastTrigger = trigger
+	fdj.status.LastAttempts = attempts
+	fdj.status.LastHTTPStatus = httpStatus
+	if err != nil {
+		fdj.status.LastStatus = "failed"
+		fdj.status.LastError = err.Error()
+		return err
+	}
+	deliveredAt := fdj.now()
+	fdj.status.LastStatus = "delivered"
+	fdj.status.LastError = ""
+	fdj.status.LastDeliveredAt = &deliveredAt
+	log.Printf("Delivered feedback digest after %d attempt(s)", attempts)
+	return nil
+}
+
+// deliver posts the payload, retrying network errors, 429 and 5xx responses
+// with exponential backoff
+func (fdj *FeedbackDigestJob) deliver(payload []byte) (int, int, error) {
+	backoff := fdj.config.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := fdj.post(payload)
+		if err == nil {
+			return attempt, status, nil
+		}
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= fdj.config.MaxAttempts {
+			return attempt, status, fmt.Errorf("failed to deliver digest after %d attempt(s): %w", attempt, err)
+		}
+		log.Printf("Digest delivery attempt %d failed, retrying in %s: %v", attempt, backoff, err)
+		fdj.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (fdj *FeedbackDigestJob) post(payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, fdj.config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := fdj.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// BuildDigest summarizes the period ending at end and compares it with the
+// period before it
+func (fdj *FeedbackDigestJob) BuildDigest(end time.Time) (*Digest, error) {
+	start := end.Add(-fdj.config.Period)
+	current, err := fdj.feedbackManager.GetFeedbackStatsBetween(start, end)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := fdj.feedbackManager.GetFeedbackStatsBetween(start.Add(-fdj.config.Period), start)
+	if err != nil {
+		return nil, err
+	}
+
+	overall := func(models.FeedbackGroupStats) string { return "all" }
+	requestType := func(s models.FeedbackGroupStats) string { return s.RequestType }
+	modelVersion := func(s models.FeedbackGroupStats) string { return s.ModelVersion }
+
+	digest := &Digest{
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		ByRequestType:  groupFeedback(current, previous, requestType),
+		ByModelVersion: groupFeedback(current, previous, modelVersion),
+	}
+	if groups := groupFeedback(current, previous, overall); len(groups) > 0 {
+		digest.Overall = groups[0]
+	} else {
+		digest.Overall = DigestGroup{Name: "all"}
+	}
+
+	for _, g := range digest.ByRequestType {
+		if g.Current.Total > 0 {
+			digest.WorstRequestTypes = append(digest.WorstRequestTypes, g)
+		}
+	}
+	sort.SliceStable(digest.WorstRequestTypes, func(i, j int) bool {
+		a, b := digest.WorstRequestTypes[i].Current, digest.WorstRequestTypes[j].Current
+		if a.PositiveRate != b.PositiveRate {
+			return a.PositiveRate < b.PositiveRate
+		}
+		return a.Negative > b.Negative
+	})
+	if len(digest.WorstRequestTypes) > digestWorstCount {
+		digest.WorstRequestTypes = digest.WorstRequestTypes[:digestWorstCount]
+	}
+
+	if fdj.usage != nil {
+		digest.Moderation = fdj.usage.Snapshot()
+	}
+	digest.Text = renderDigest(digest)
+	return digest, nil
+}
+
+// groupFeedback folds both periods' stats by key, sorted by name
+func groupFeedback(current, previous []models.FeedbackGroupStats, key func(models.FeedbackGroupStats) string) []DigestGroup {
+	groups := map[string]*DigestGroup{}
+	get := func(name string) *DigestGroup {
+		if groups[name] == nil {
+			groups[name] = &DigestGroup{Name: name}
+		}
+		return groups[name]
+	}
+	for _, s := range current {
+		addRatings(&get(key(s)).Current, s)
+	}
+	for _, s := range previous {
+		addRatings(&get(key(s)).Previous, s)
+	}
+
+	result := make([]DigestGroup, 0, len(groups))
+	for _, g := range groups {
+		g.Current.PositiveRate = positiveRate(g.Current)
+		g.Previous.PositiveRate = positiveRate(g.Previous)
+		g.TotalDelta = g.Current.Total - g.Previous.Total
+		g.PositiveRateDelta = g.Current.PositiveRate - g.Previous.PositiveRate
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func addRatings(r *DigestRatings, s models.FeedbackGroupStats) {
+	r.Total += s.Total
+	r.Positive += s.Positive
+	r.Negative += s.Total - s.Positive
+}
+
+func positiveRate(r DigestRatings) float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Positive) / float64(r.Total)
+}
+
+// renderDigest formats the digest as Slack-flavoured Markdown
+func renderDigest(d *Digest) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*AI feedback digest* %s to %s\n", d.PeriodStart.Format("2006-01-02"), d.PeriodEnd.Format("2006-01-02"))
+	fmt.Fprintf(&sb, "%s\n", describeGroup(d.Overall))
+
+	if len(d.WorstRequestTypes) > 0 {
+		sb.WriteString("\n*Worst rated request types*\n")
+		for i, g := range d.WorstRequestTypes {
+			fmt.Fprintf(&sb, "%d. %s: %.1f%% positive, %d thumbs-down\n", i+1, g.Name, g.Current.PositiveRate*100, g.Current.Negative)
+		}
+	}
+	if len(d.ByRequestType) > 0 {
+		sb.WriteString("\n*By request type*\n")
+		for _, g := range d.ByRequestType {
+			fmt.Fprintf(&sb, "• %s: %s\n", g.Name, describeGroup(g))
+		}
+	}
+	if len(d.ByModelVersion) > 0 {
+		sb.WriteString("\n*By model version*\n")
+		for _, g := range d.ByModelVersion {
+			fmt.Fprintf(&sb, "• %s: %s\n", g.Name, describeGroup(g))
+		}
+	}
+	if outcomes, ok := d.Moderation["outcomes"].(map[string]int); ok {
+		fmt.Fprintf(&sb, "\n*Moderation since service start*: %d blocked, %d flagged\n", outcomes[moderation.OutcomeBlocked], outcomes[moderation.OutcomeFlagged])
+	}
+	return sb.String()
+}
+
+func describeGroup(g DigestGroup) string {
+	if g.Current.Total == 0 {
+		return fmt.Sprintf("no ratings (%d in the previous period)", g.Previous.Total)
+	}
+	s := fmt.Sprintf("%d ratings (%+d), %.1f%% positive", g.Current.Total, g.TotalDelta, g.Current.PositiveRate*100)
+	if g.Previous.Total > 0 {
+		s += fmt.Sprintf(" (%+.1f pts)", g.PositiveRateDelta*100)
+	}
+	return s
+}
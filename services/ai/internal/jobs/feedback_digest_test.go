@@ -0,0 +1,276 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"peerprep/ai/internal/feedback"
+	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int // responses to give, the last one repeats
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.mu.Lock()
+	wr.bodies = append(wr.bodies, body)
+	status := wr.statuses[min(len(wr.bodies), len(wr.statuses))-1]
+	wr.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (wr *webhookReceiver) received() [][]byte {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([][]byte(nil), wr.bodies...)
+}
+
+func newDigestJob(t *testing.T, webhookURL string) (*FeedbackDigestJob, *gorm.DB) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&models.AIFeedback{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	job := NewFeedbackDigestJob(feedback.NewFeedbackManager(db, time.Minute), nil, &DigestConfig{
+		Schedule:     "@every 1h",
+		WebhookURL:   webhookURL,
+		Period:       7 * 24 * time.Hour,
+		MaxAttempts:  3,
+		RetryBackoff: time.Second,
+	})
+	job.sleep = func(time.Duration) {}
+	return job, db
+}
+
+func seedRatings(t *testing.T, db *gorm.DB, requestType, modelVersion string, positive, negative int, at time.Time) {
+	t.Helper()
+	for i := 0; i < positive+negative; i++ {
+		fb := models.AIFeedback{
+			RequestID:    fmt.Sprintf("%s-%s-%d-%d", requestType, modelVersion, at.UnixNano(), i),
+			RequestType:  requestType,
+			Prompt:       "prompt",
+			Response:     "response",
+			IsPositive:   i < positive,
+			ModelVersion: modelVersion,
+			FeedbackAt:   at,
+		}
+		if err := db.Create(&fb).Error; err != nil {
+			t.Fatalf("failed seeding feedback: %v", err)
+		}
+	}
+}
+
+func waitForDigest(t *testing.T, job *FeedbackDigestJob) DigestStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := job.Status(); !status.Running && status.LastStatus != "" {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("digest did not finish")
+	return DigestStatus{}
+}
+
+func TestRunDigest_PayloadShape(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	job, db := newDigestJob(t, server.URL)
+	stats := moderation.NewStats()
+	stats.Record(moderation.Verdict{Outcome: moderation.OutcomeBlocked})
+	job.usage = stats
+
+	now := time.Now()
+	lastWeek := now.Add(-8 * 24 * time.Hour)
+	seedRatings(t, db, "hint", "v1", 6, 4, now.Add(-time.Hour))
+	seedRatings(t, db, "explanation", "v2", 9, 1, now.Add(-time.Hour))
+	seedRatings(t, db, "tests", "v2", 1, 3, now.Add(-time.Hour))
+	seedRatings(t, db, "hint", "v1", 9, 1, lastWeek)
+	seedRatings(t, db, "hint", "v1", 5, 0, now.Add(-30*24*time.Hour)) // outside both periods
+
+	if err := job.RunDigest("manual"); err != nil {
+		t.Fatalf("RunDigest returned error: %v", err)
+	}
+
+	bodies := receiver.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(bodies))
+	}
+	var digest Digest
+	if err := json.Unmarshal(bodies[0], &digest); err != nil {
+		t.Fatalf("payload is not a digest: %v", err)
+	}
+
+	if digest.Overall.Current.Total != 24 || digest.Overall.Current.Positive != 16 || digest.Overall.Previous.Total != 10 {
+		t.Fatalf("unexpected overall: %+v", digest.Overall)
+	}
+	if digest.Overall.TotalDelta != 14 {
+		t.Fatalf("expected total delta 14, got %d", digest.Overall.TotalDelta)
+	}
+
+	var hint DigestGroup
+	for _, g := range digest.ByRequestType {
+		if g.Name == "hint" {
+			hint = g
+		}
+	}
+	if hint.Current.PositiveRate != 0.6 || hint.Previous.PositiveRate != 0.9 || fmt.Sprintf("%.2f", hint.PositiveRateDelta) != "-0.30" {
+		t.Fatalf("unexpected week-over-week for hint: %+v", hint)
+	}
+	if len(digest.ByModelVersion) != 2 || digest.ByModelVersion[0].Name != "v1" || digest.ByModelVersion[1].Current.Total != 14 {
+		t.Fatalf("unexpected model version breakdown: %+v", digest.ByModelVersion)
+	}
+
+	var worst []string
+	for _, g := range digest.WorstRequestTypes {
+		worst = append(worst, g.Name)
+	}
+	if strings.Join(worst, ",") != "tests,hint,explanation" {
+		t.Fatalf("unexpected worst request types: %v", worst)
+	}
+
+	if digest.Moderation == nil || !strings.Contains(digest.Text, "1 blocked") {
+		t.Fatalf("expected moderation counts in the digest: %+v\n%s", digest.Moderation, digest.Text)
+	}
+	if !strings.Contains(digest.Text, "*AI feedback digest*") || !strings.Contains(digest.Text, "1. tests: 25.0% positive, 3 thumbs-down") {
+		t.Fatalf("unexpected markdown:\n%s", digest.Text)
+	}
+
+	status := job.Status()
+	if status.LastStatus != "delivered" || status.LastAttempts != 1 || status.LastTrigger != "manual" || status.LastDeliveredAt == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestRunDigest_RetriesServerErrors(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	job, _ := newDigestJob(t, server.URL)
+	var waits []time.Duration
+	job.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if err := job.RunDigest("schedule"); err != nil {
+		t.Fatalf("expected delivery to succeed on retry, got %v", err)
+	}
+	if n := len(receiver.received()); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+	if len(waits) != 1 || waits[0] != time.Second {
+		t.Fatalf("expected one backoff of 1s, got %v", waits)
+	}
+	if status := job.Status(); status.LastStatus != "delivered" || status.LastAttempts != 2 || status.LastHTTPStatus != http.StatusOK {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestRunDigest_GivesUpAndRecordsFailure(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusBadGateway}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	job, _ := newDigestJob(t, server.URL)
+	var waits []time.Duration
+	job.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if err := job.RunDigest("schedule"); err == nil {
+		t.Fatalf("expected delivery to fail")
+	}
+	if n := len(receiver.received()); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+	if len(waits) != 2 || waits[1] != 2*time.Second {
+		t.Fatalf("expected doubling backoff, got %v", waits)
+	}
+	status := job.Status()
+	if status.LastStatus != "failed" || status.LastHTTPStatus != http.StatusBadGateway || status.LastError == "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// client errors are not retried
+	receiver = &webhookReceiver{statuses: []int{http.StatusNotFound}}
+	server404 := httptest.NewServer(receiver)
+	defer server404.Close()
+	job.config.WebhookURL = server404.URL
+	_ = job.RunDigest("schedule")
+	if n := len(receiver.received()); n != 1 {
+		t.Fatalf("expected a 404 not to be retried, got %d attempts", n)
+	}
+}
+
+func TestRunDigest_OverlapGuard(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	job, _ := newDigestJob(t, server.URL)
+	if err := job.Trigger(); err != nil {
+		t.Fatalf("Trigger returned error: %v", err)
+	}
+	<-arrived
+
+	if err := job.Trigger(); !errors.Is(err, ErrDigestRunning) {
+		t.Fatalf("expected ErrDigestRunning from Trigger, got %v", err)
+	}
+	if err := job.RunDigest("schedule"); !errors.Is(err, ErrDigestRunning) {
+		t.Fatalf("expected ErrDigestRunning from RunDigest, got %v", err)
+	}
+	if !job.Status().Running {
+		t.Fatalf("expected status to report the run in progress")
+	}
+
+	close(release)
+	if status := waitForDigest(t, job); status.LastStatus != "delivered" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if err := job.Trigger(); err != nil {
+		t.Fatalf("expected a new run after the first finished, got %v", err)
+	}
+	waitForDigest(t, job)
+}
+
+func TestDigest_DisabledWithoutWebhook(t *testing.T) {
+	job, _ := newDigestJob(t, "")
+
+	if err := job.Start(); err != nil {
+		t.Fatalf("disabled digest should not error, got %v", err)
+	}
+	if len(job.cron.Entries()) != 0 {
+		t.Fatalf("disabled digest should not be scheduled")
+	}
+	if err := job.RunDigest("manual"); !errors.Is(err, ErrDigestDisabled) {
+		t.Fatalf("expected ErrDigestDisabled, got %v", err)
+	}
+	if status := job.Status(); status.Enabled || status.LastStatus != "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"peerprep/ai/internal/llm"
 	"peerprep/ai/internal/models"
+	"peerprep/ai/internal/moderation"
 )
 
 // Client represents a Gemini LLM client
@@ -194,6 +195,16 @@ func (c *Client) GenerateContent(ctx context.Context, prompt string, requestID s
 		}
 	}
 
+	// Safety filters return a response without text instead of an error
+	if blocked, category := safetyBlock(result); blocked {
+		return nil, &llm.ProviderError{
+			Provider: "gemini",
+			Code:     llm.ErrCodeSafetyBlock,
+			Message:  "Content blocked by safety filters",
+			Category: category,
+		}
+	}
+
 	content, err := result.Text()
 	if err != nil {
 		return nil, &llm.ProviderError{
@@ -241,3 +252,28 @@ func isRateLimitError(err error) bool {
 		strings.Contains(errStr, "quota exceeded") ||
 		strings.Contains(errStr, "rate limit")
 }
+
+// checks whether Gemini refused the prompt or the candidate on safety grounds
+// and returns the blocking harm category when Gemini reports one
+func safetyBlock(result *genai.GenerateContentResponse) (bool, string) {
+	if fb := result.PromptFeedback; fb != nil && fb.BlockReason != "" && fb.BlockReason != genai.BlockedReasonUnspecified {
+		return true, blockedCategory(fb.SafetyRatings)
+	}
+	if len(result.Candidates) == 0 || result.Candidates[0] == nil {
+		return false, ""
+	}
+	switch candidate := result.Candidates[0]; candidate.FinishReason {
+	case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist, genai.FinishReasonSPII:
+		return true, blockedCategory(candidate.SafetyRatings)
+	}
+	return false, ""
+}
+
+func blockedCategory(ratings []*genai.SafetyRating) string {
+	for _, rating := range ratings {
+		if rating != nil && rating.Blocked {
+			return moderation.NormalizeCategory(string(rating.Category))
+		}
+	}
+	return ""
+}
@@ -108,6 +108,37 @@ func TestClientGenerateContentEmptyResponse(t *testing.T) {
 	}
 }
 
+func TestClientGenerateContentSafetyBlock(t *testing.T) {
+	cases := map[string]map[string]any{
+		"prompt blocked": {
+			"promptFeedback": map[string]any{
+				"blockReason":   "SAFETY",
+				"safetyRatings": []map[string]any{{"category": "HARM_CATEGORY_HARASSMENT", "blocked": true}},
+			},
+		},
+		"candidate blocked": {
+			"candidates": []map[string]any{{
+				"finishReason":  "SAFETY",
+				"safetyRatings": []map[string]any{{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH", "blocked": true}},
+			}},
+		},
+	}
+	for name, resp := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, cleanup := newStubClient(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(resp)
+			})
+			defer cleanup()
+
+			_, err := client.GenerateContent(context.Background(), "prompt", "req", "detail")
+			var provErr *llm.ProviderError
+			if !errors.As(err, &provErr) || provErr.Code != llm.ErrCodeSafetyBlock || provErr.Category != "harassment" {
+				t.Fatalf("expected harassment safety block, got %v", err)
+			}
+		})
+	}
+}
+
 func TestSelectModelWithDatabase(t *testing.T) {
 	client := &Client{
 		config: &Config{Model: "base"},
@@ -16,6 +16,7 @@ type ProviderError struct {
 	Provider string
 	Code     string
 	Message  string
+	Category string // for safety blocks, the category the provider blocked on (if reported)
 	Err      error
 }
 
@@ -34,4 +35,5 @@ const (
 	ErrCodeServiceDown  = "service_unavailable"
 	ErrCodeInvalidInput = "invalid_input"
 	ErrCodeTimeout      = "timeout"
+	ErrCodeSafetyBlock  = "safety_blocked"
 )
@@ -16,11 +16,20 @@ type AIFeedback struct {
 	Response     string     `gorm:"type:text;not null" json:"response"`
 	IsPositive   bool       `gorm:"not null" json:"is_positive"` // true = thumbs up, false = thumbs down
 	ModelVersion string     `gorm:"not null" json:"model_version"`
+	Moderation   string     `json:"moderation,omitempty"` // comma-separated categories of borderline content
 	FeedbackAt   time.Time  `gorm:"not null" json:"feedback_at"`
 	Exported     bool       `gorm:"not null;default:false;index" json:"exported"`
 	ExportedAt   *time.Time `json:"exported_at"`
 }
 
+// FeedbackGroupStats counts the ratings given to one request type and model version
+type FeedbackGroupStats struct {
+	RequestType  string `json:"request_type"`
+	ModelVersion string `json:"model_version"`
+	Total        int64  `json:"total"`
+	Positive     int64  `json:"positive"`
+}
+
 // TrainingDataPoint represents a single training example in JSONL format for Gemini fine-tuning
 type TrainingDataPoint struct {
 	Contents []TrainingContent `json:"contents"`
@@ -56,5 +65,6 @@ type RequestContext struct {
 	Prompt       string
 	Response     string
 	ModelVersion string
+	Moderation   []string // categories the content was flagged for, if borderline
 	Timestamp    time.Time
 }
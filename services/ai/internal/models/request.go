@@ -68,12 +68,18 @@ type QuestionContext struct {
 	Constraints    string   `json:"constraints,omitempty"`
 }
 
+// MaxDiscussionLength bounds the discussion a hint request may carry
+const MaxDiscussionLength = 8000
+
 type HintRequest struct {
 	Code      string           `json:"code"`
 	Language  string           `json:"language"`
 	Question  *QuestionContext `json:"question"`
 	HintLevel string           `json:"hint_level"`
 	RequestID string           `json:"request_id"`
+	// Discussion is an optional conversation between the users the hint
+	// should answer, e.g. a collab room's question thread
+	Discussion string `json:"discussion,omitempty"`
 }
 
 func (r *HintRequest) Validate() error {
@@ -101,6 +107,12 @@ func (r *HintRequest) Validate() error {
 	if r.Question.PromptMarkdown == "" {
 		return &ErrorResponse{Code: "missing_question_prompt", Message: "Question prompt_markdown must not be empty"}
 	}
+	if len([]rune(r.Discussion)) > MaxDiscussionLength {
+		return &ErrorResponse{
+			Code:    "discussion_too_long",
+			Message: fmt.Sprintf("Discussion must be at most %d characters", MaxDiscussionLength),
+		}
+	}
 
 	originalHintLevel := r.HintLevel
 	r.HintLevel = utils.NormalizeLevel(r.HintLevel)
@@ -193,3 +205,36 @@ func (r *RefactorTipsRequest) Validate() error {
 	}
 	return nil
 }
+
+type ComplexityRequest struct {
+	Code     string `json:"code"`
+	Language string `json:"language"`
+	// Optional; lets the model relate n to the problem's inputs
+	Question  *QuestionContext `json:"question,omitempty"`
+	RequestID string           `json:"request_id"`
+}
+
+func (r *ComplexityRequest) Validate() error {
+	if strings.TrimSpace(r.Code) == "" {
+		return &ErrorResponse{Code: "missing_code", Message: "Code field is required"}
+	}
+	if strings.TrimSpace(r.Language) == "" {
+		return &ErrorResponse{Code: "missing_language", Message: "Language field is required"}
+	}
+
+	// normalize and validate language
+	originalLanguage := r.Language
+	r.Language = utils.NormalizeLanguage(r.Language)
+
+	if !SupportedLanguages[r.Language] {
+		return &ErrorResponse{
+			Code:    "unsupported_language",
+			Message: fmt.Sprintf("Language '%s' not supported. Supported languages: %s", originalLanguage, strings.Join(SupportedLanguagesList(), ", ")),
+		}
+	}
+
+	if r.Question != nil && r.Question.Difficulty != "" {
+		r.Question.Difficulty = utils.NormalizeDifficulty(r.Question.Difficulty)
+	}
+	return nil
+}
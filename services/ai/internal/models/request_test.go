@@ -91,6 +91,12 @@ func TestHintRequestValidate(t *testing.T) {
 		expectErrCode(t, req.Validate(), "invalid_hint_level")
 	})
 
+	t.Run("discussion too long", func(t *testing.T) {
+		req := &HintRequest{Code: "x", Language: "python", Question: baseQuestion, HintLevel: "beginner",
+			Discussion: strings.Repeat("a", MaxDiscussionLength+1)}
+		expectErrCode(t, req.Validate(), "discussion_too_long")
+	})
+
 	t.Run("invalid language", func(t *testing.T) {
 		req := &HintRequest{Code: "x", Language: "ruby", Question: baseQuestion, HintLevel: "beginner"}
 		expectErrCode(t, req.Validate(), "unsupported_language")
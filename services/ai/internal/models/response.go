@@ -28,6 +28,18 @@ func (e *ErrorResponse) Error() string {
 	return e.Message
 }
 
+// ContentRejectedResponse is returned with 422 when moderation, or the
+// provider's own safety filters, reject user-supplied content
+type ContentRejectedResponse struct {
+	Error ContentRejection `json:"error"`
+}
+
+type ContentRejection struct {
+	Code       string   `json:"code"` // always "content_rejected"
+	Message    string   `json:"message"`
+	Categories []string `json:"categories"`
+}
+
 // single field validation error
 type ValidationErrorDetail struct {
 	Field  string `json:"field"`
@@ -58,3 +70,25 @@ type Resp struct {
 	OK   bool        `json:"ok"`
 	Info interface{} `json:"info,omitempty"`
 }
+
+// ComplexityAnalysis is the structured Big-O verdict parsed from the model output
+type ComplexityAnalysis struct {
+	TimeComplexity     string              `json:"timeComplexity"`
+	SpaceComplexity    string              `json:"spaceComplexity"`
+	DominantOperations []DominantOperation `json:"dominantOperations"`
+	Confidence         float64             `json:"confidence"`
+}
+
+type DominantOperation struct {
+	Description string `json:"description"`
+	Complexity  string `json:"complexity"`
+}
+
+// ComplexityResponse returned by /ai/complexity. Exactly one of Analysis or
+// Raw is set; Raw carries the model text when it could not be parsed.
+type ComplexityResponse struct {
+	Analysis  *ComplexityAnalysis `json:"analysis,omitempty"`
+	Raw       string              `json:"raw,omitempty"`
+	RequestID string              `json:"request_id"`
+	Metadata  GenerationMetadata  `json:"metadata"`
+}
@@ -0,0 +1,63 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// holds moderation configuration, tunable by admins through the environment
+type Config struct {
+	Thresholds   Thresholds
+	KeywordsFile string // optional JSON keyword lists, see LoadKeywords
+	ProviderURL  string // optional hosted moderation endpoint
+	ProviderKey  string
+}
+
+func NewConfig() (*Config, error) {
+	flag, err := envFloat("MODERATION_FLAG_THRESHOLD", 0.5)
+	if err != nil {
+		return nil, err
+	}
+	block, err := envFloat("MODERATION_BLOCK_THRESHOLD", 0.9)
+	if err != nil {
+		return nil, err
+	}
+	if flag <= 0 || flag > block {
+		return nil, errors.New("moderation thresholds must satisfy 0 < MODERATION_FLAG_THRESHOLD <= MODERATION_BLOCK_THRESHOLD")
+	}
+
+	return &Config{
+		Thresholds:   Thresholds{Flag: flag, Block: block},
+		KeywordsFile: os.Getenv("MODERATION_KEYWORDS_FILE"),
+		ProviderURL:  os.Getenv("MODERATION_PROVIDER_URL"),
+		ProviderKey:  os.Getenv("MODERATION_PROVIDER_API_KEY"),
+	}, nil
+}
+
+// NewScreenFromConfig builds the keyword screen, followed by the provider
+// moderator when MODERATION_PROVIDER_URL is set
+func NewScreenFromConfig(cfg *Config) (*Screen, error) {
+	lists, err := LoadKeywords(cfg.KeywordsFile)
+	if err != nil {
+		return nil, err
+	}
+	moderators := []Moderator{NewKeywordModerator(lists)}
+	if cfg.ProviderURL != "" {
+		moderators = append(moderators, NewProviderModerator(cfg.ProviderURL, cfg.ProviderKey))
+	}
+	return NewScreen(cfg.Thresholds, moderators...), nil
+}
+
+func envFloat(key string, defaultVal float64) (float64, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return f, nil
+}
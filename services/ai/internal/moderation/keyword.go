@@ -0,0 +1,130 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// weights of the keyword tiers; a block term alone reaches any sane block
+// threshold, flag terms add up
+const (
+	blockTermScore = 1.0
+	flagTermScore  = 0.5
+)
+
+// Keywords are the terms of one category. Terms match case-insensitively on
+// word boundaries, so "class" never matches a shorter term inside it.
+type Keywords struct {
+	Block []string `json:"block"`
+	Flag  []string `json:"flag"`
+}
+
+// DefaultKeywords is a deliberately small starter list. Terms that are common
+// in code ("kill", "abort", "execute", ...) are left out because the screened
+// content is mostly source code; deployments extend the lists through
+// MODERATION_KEYWORDS_FILE.
+var DefaultKeywords = map[string]Keywords{
+	CategoryHarassment: {
+		Block: []string{"kill yourself", "kys"},
+		Flag:  []string{"idiot", "moron", "loser", "shut up"},
+	},
+	CategoryHate: {
+		Block: []string{"heil hitler", "white power"},
+		Flag:  []string{"subhuman", "vermin"},
+	},
+	CategorySelfHarm: {
+		Block: []string{"how to kill myself", "suicide method"},
+		Flag:  []string{"want to die", "self harm", "cut myself"},
+	},
+	CategorySexual: {
+		Block: []string{"child porn"},
+		Flag:  []string{"porn", "nsfw", "nudes"},
+	},
+	CategoryViolence: {
+		Block: []string{"i will kill you", "bomb threat", "shoot up the"},
+		Flag:  []string{"beat you up", "murder you"},
+	},
+}
+
+type keywordTerm struct {
+	pattern *regexp.Regexp
+	score   float64
+}
+
+// KeywordModerator is the local screen: fast, deterministic and free
+type KeywordModerator struct {
+	terms map[string][]keywordTerm
+}
+
+func NewKeywordModerator(lists map[string]Keywords) *KeywordModerator {
+	km := &KeywordModerator{terms: make(map[string][]keywordTerm, len(lists))}
+	for category, kw := range lists {
+		for _, term := range kw.Block {
+			km.add(category, term, blockTermScore)
+		}
+		for _, term := range kw.Flag {
+			km.add(category, term, flagTermScore)
+		}
+	}
+	return km
+}
+
+func (km *KeywordModerator) add(category, term string, score float64) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return
+	}
+	words := strings.Fields(regexp.QuoteMeta(strings.ToLower(term)))
+	pattern := regexp.MustCompile(`(?i)\b` + strings.Join(words, `\s+`) + `\b`)
+	km.terms[category] = append(km.terms[category], keywordTerm{pattern: pattern, score: score})
+}
+
+func (km *KeywordModerator) Moderate(_ context.Context, content string) (Scores, error) {
+	scores := Scores{}
+	for category, terms := range km.terms {
+		total := 0.0
+		for _, t := range terms {
+			if t.pattern.MatchString(content) {
+				total += t.score
+			}
+		}
+		if total > 0 {
+			scores[category] = min(total, 1)
+		}
+	}
+	return scores, nil
+}
+
+func (km *KeywordModerator) Name() string {
+	return "keywords"
+}
+
+// LoadKeywords reads keyword lists from a JSON file shaped like
+// {"harassment": {"block": [...], "flag": [...]}}. Categories in the file
+// replace the defaults; other default categories are kept.
+func LoadKeywords(path string) (map[string]Keywords, error) {
+	lists := make(map[string]Keywords, len(DefaultKeywords))
+	for category, kw := range DefaultKeywords {
+		lists[category] = kw
+	}
+	if path == "" {
+		return lists, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation keywords: %w", err)
+	}
+	var custom map[string]Keywords
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse moderation keywords: %w", err)
+	}
+	for category, kw := range custom {
+		lists[category] = kw
+	}
+	return lists, nil
+}
@@ -0,0 +1,138 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Moderation outcomes
+const (
+	OutcomeAllowed = "allowed"
+	OutcomeFlagged = "flagged" // borderline, the request proceeds but is tagged
+	OutcomeBlocked = "blocked"
+)
+
+// Content categories shared by all moderators
+const (
+	CategoryHarassment = "harassment"
+	CategoryHate       = "hate"
+	CategorySelfHarm   = "self_harm"
+	CategorySexual     = "sexual"
+	CategoryViolence   = "violence"
+)
+
+// Scores maps a category to a score between 0 (clean) and 1 (certain)
+type Scores map[string]float64
+
+// Moderator scores user-supplied content
+type Moderator interface {
+	Moderate(ctx context.Context, content string) (Scores, error)
+	Name() string
+}
+
+// Thresholds decide the outcome from a category score: scores at or above
+// Block reject the content, scores at or above Flag tag it as borderline
+type Thresholds struct {
+	Flag  float64
+	Block float64
+}
+
+// Verdict is the outcome of screening one piece of content. Categories lists
+// the categories that reached the flag threshold (or the block threshold when
+// the content is blocked), sorted.
+type Verdict struct {
+	Outcome    string
+	Categories []string
+}
+
+// Screen runs moderators in order and applies the thresholds to their scores
+type Screen struct {
+	moderators []Moderator
+	thresholds Thresholds
+}
+
+func NewScreen(thresholds Thresholds, moderators ...Moderator) *Screen {
+	return &Screen{moderators: moderators, thresholds: thresholds}
+}
+
+// Check screens content. It stops at the first moderator that blocks, so the
+// cheap local screen runs before any remote call. A failing moderator is
+// skipped and its error returned alongside the verdict of the others, so an
+// unavailable moderation backend does not take the AI endpoints down.
+func (s *Screen) Check(ctx context.Context, content string) (Verdict, error) {
+	merged := Scores{}
+	var errs []error
+	for _, m := range s.moderators {
+		scores, err := m.Moderate(ctx, content)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for category, score := range scores {
+			if score > merged[category] {
+				merged[category] = score
+			}
+		}
+		if blocked := above(merged, s.thresholds.Block); len(blocked) > 0 {
+			return Verdict{Outcome: OutcomeBlocked, Categories: blocked}, errors.Join(errs...)
+		}
+	}
+
+	if flagged := above(merged, s.thresholds.Flag); len(flagged) > 0 {
+		return Verdict{Outcome: OutcomeFlagged, Categories: flagged}, errors.Join(errs...)
+	}
+	return Verdict{Outcome: OutcomeAllowed}, errors.Join(errs...)
+}
+
+func above(scores Scores, threshold float64) []string {
+	var categories []string
+	for category, score := range scores {
+		if score >= threshold {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// Stats counts moderation outcomes and categories for usage reporting
+type Stats struct {
+	mu         sync.Mutex
+	outcomes   map[string]int
+	categories map[string]int
+}
+
+func NewStats() *Stats {
+	return &Stats{outcomes: map[string]int{}, categories: map[string]int{}}
+}
+
+// Record counts one moderated request
+func (s *Stats) Record(v Verdict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outcomes[v.Outcome]++
+	for _, category := range v.Categories {
+		s.categories[category]++
+	}
+}
+
+// Snapshot returns the counts recorded so far
+func (s *Stats) Snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outcomes := map[string]int{OutcomeAllowed: 0, OutcomeFlagged: 0, OutcomeBlocked: 0}
+	for outcome, n := range s.outcomes {
+		outcomes[outcome] = n
+	}
+	categories := make(map[string]int, len(s.categories))
+	for category, n := range s.categories {
+		categories[category] = n
+	}
+	return map[string]interface{}{
+		"outcomes":   outcomes,
+		"categories": categories,
+	}
+}
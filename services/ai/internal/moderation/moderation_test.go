@@ -0,0 +1,159 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testThresholds = Thresholds{Flag: 0.5, Block: 0.9}
+
+func TestKeywordScreenFixtures(t *testing.T) {
+	screen := NewScreen(testThresholds, NewKeywordModerator(DefaultKeywords))
+
+	cases := []struct {
+		name       string
+		content    string
+		outcome    string
+		categories []string
+	}{
+		{"clean code", "def kill_process(pid):\n    os.kill(pid, 9)  # class Killer", OutcomeAllowed, nil},
+		{"harassment blocked", "my partner should just kill yourself lol", OutcomeBlocked, []string{CategoryHarassment}},
+		{"harassment borderline", "# what idiot wrote this loop", OutcomeFlagged, []string{CategoryHarassment}},
+		{"harassment adds up", "you idiot, you absolute moron", OutcomeBlocked, []string{CategoryHarassment}},
+		{"hate blocked", "print('HEIL   Hitler')", OutcomeBlocked, []string{CategoryHate}},
+		{"self harm borderline", "// I want to die debugging this", OutcomeFlagged, []string{CategorySelfHarm}},
+		{"sexual borderline", "url = 'nsfw.example.com'", OutcomeFlagged, []string{CategorySexual}},
+		{"violence blocked", "I will kill you if this fails again", OutcomeBlocked, []string{CategoryViolence}},
+		{"word boundaries", "mooring = loserate + idiotproof", OutcomeAllowed, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			verdict, err := screen.Check(context.Background(), tc.content)
+			if err != nil {
+				t.Fatalf("Check error: %v", err)
+			}
+			if verdict.Outcome != tc.outcome || !reflect.DeepEqual(verdict.Categories, tc.categories) {
+				t.Fatalf("expected %s %v, got %+v", tc.outcome, tc.categories, verdict)
+			}
+		})
+	}
+}
+
+type fakeModerator struct {
+	scores Scores
+	err    error
+	calls  int
+}
+
+func (f *fakeModerator) Moderate(context.Context, string) (Scores, error) {
+	f.calls++
+	return f.scores, f.err
+}
+
+func (f *fakeModerator) Name() string { return "fake" }
+
+func TestScreenStopsAtFirstBlock(t *testing.T) {
+	first := &fakeModerator{scores: Scores{CategoryHate: 1}}
+	second := &fakeModerator{}
+	verdict, _ := NewScreen(testThresholds, first, second).Check(context.Background(), "x")
+	if verdict.Outcome != OutcomeBlocked || second.calls != 0 {
+		t.Fatalf("expected block without calling the second moderator, got %+v after %d calls", verdict, second.calls)
+	}
+}
+
+func TestScreenFailsOpen(t *testing.T) {
+	failing := &fakeModerator{err: errors.New("moderation down")}
+	borderline := &fakeModerator{scores: Scores{CategoryViolence: 0.6, CategoryHate: 0.1}}
+	verdict, err := NewScreen(testThresholds, failing, borderline).Check(context.Background(), "x")
+	if err == nil {
+		t.Fatal("expected the moderator error to be reported")
+	}
+	if verdict.Outcome != OutcomeFlagged || !reflect.DeepEqual(verdict.Categories, []string{CategoryViolence}) {
+		t.Fatalf("unexpected verdict: %+v", verdict)
+	}
+}
+
+func TestProviderModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Fatalf("missing api key")
+		}
+		w.Write([]byte(`{"results":[{"category_scores":{"hate":0.2,"hate/threatening":0.95,"self-harm":0.4}}]}`))
+	}))
+	defer server.Close()
+
+	scores, err := NewProviderModerator(server.URL, "key").Moderate(context.Background(), "x")
+	if err != nil {
+		t.Fatalf("Moderate error: %v", err)
+	}
+	if scores[CategoryHate] != 0.95 || scores[CategorySelfHarm] != 0.4 {
+		t.Fatalf("unexpected scores: %v", scores)
+	}
+}
+
+func TestNormalizeCategory(t *testing.T) {
+	cases := map[string]string{
+		"HARM_CATEGORY_HARASSMENT":        CategoryHarassment,
+		"HARM_CATEGORY_HATE_SPEECH":       CategoryHate,
+		"HARM_CATEGORY_SEXUALLY_EXPLICIT": CategorySexual,
+		"self-harm/intent":                CategorySelfHarm,
+		"violence/graphic":                CategoryViolence,
+		"illicit":                         "illicit",
+	}
+	for in, want := range cases {
+		if got := NormalizeCategory(in); got != want {
+			t.Fatalf("NormalizeCategory(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNewConfigThresholds(t *testing.T) {
+	t.Setenv("MODERATION_FLAG_THRESHOLD", "0.3")
+	t.Setenv("MODERATION_BLOCK_THRESHOLD", "0.7")
+	cfg, err := NewConfig()
+	if err != nil || cfg.Thresholds != (Thresholds{Flag: 0.3, Block: 0.7}) {
+		t.Fatalf("unexpected config %+v err=%v", cfg, err)
+	}
+
+	t.Setenv("MODERATION_FLAG_THRESHOLD", "0.8")
+	if _, err := NewConfig(); err == nil {
+		t.Fatal("expected flag threshold above block threshold to be rejected")
+	}
+}
+
+func TestLoadKeywordsOverridesCategories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keywords.json")
+	if err := os.WriteFile(path, []byte(`{"harassment":{"block":["noob"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lists, err := LoadKeywords(path)
+	if err != nil {
+		t.Fatalf("LoadKeywords error: %v", err)
+	}
+	if !reflect.DeepEqual(lists[CategoryHarassment], Keywords{Block: []string{"noob"}}) || len(lists[CategoryHate].Block) == 0 {
+		t.Fatalf("unexpected lists: %+v", lists)
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := NewStats()
+	stats.Record(Verdict{Outcome: OutcomeAllowed})
+	stats.Record(Verdict{Outcome: OutcomeFlagged, Categories: []string{CategoryHate}})
+	stats.Record(Verdict{Outcome: OutcomeBlocked, Categories: []string{CategoryHate, CategoryViolence}})
+
+	snap := stats.Snapshot()
+	outcomes := snap["outcomes"].(map[string]int)
+	categories := snap["categories"].(map[string]int)
+	if outcomes[OutcomeAllowed] != 1 || outcomes[OutcomeFlagged] != 1 || outcomes[OutcomeBlocked] != 1 {
+		t.Fatalf("unexpected outcomes: %v", outcomes)
+	}
+	if categories[CategoryHate] != 2 || categories[CategoryViolence] != 1 {
+		t.Fatalf("unexpected categories: %v", categories)
+	}
+}
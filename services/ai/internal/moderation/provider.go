@@ -0,0 +1,100 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ProviderModerator calls a hosted moderation endpoint speaking the common
+// moderation API shape: it POSTs {"input": "..."} and reads
+// {"results": [{"category_scores": {"harassment": 0.1, "hate/threatening": 0.7, ...}}]}
+type ProviderModerator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewProviderModerator(url, apiKey string) *ProviderModerator {
+	return &ProviderModerator{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type moderationResponse struct {
+	Results []struct {
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+func (pm *ProviderModerator) Moderate(ctx context.Context, content string) (Scores, error) {
+	body, err := json.Marshal(map[string]string{"input": content})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pm.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if pm.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+pm.apiKey)
+	}
+
+	resp, err := pm.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation provider returned status %d", resp.StatusCode)
+	}
+
+	var out moderationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+
+	scores := Scores{}
+	for _, result := range out.Results {
+		for name, score := range result.CategoryScores {
+			category := NormalizeCategory(name)
+			if score > scores[category] {
+				scores[category] = score
+			}
+		}
+	}
+	return scores, nil
+}
+
+func (pm *ProviderModerator) Name() string {
+	return "provider"
+}
+
+// NormalizeCategory maps provider category names ("hate/threatening",
+// "self-harm", "HARM_CATEGORY_HARASSMENT", ...) onto the shared categories.
+// Unknown names are lower-cased and kept.
+func NormalizeCategory(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, "harm_category_")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.ReplaceAll(name, "-", "_")
+
+	switch name {
+	case "hate_speech":
+		return CategoryHate
+	case "sexually_explicit":
+		return CategorySexual
+	case "dangerous_content":
+		return CategoryViolence
+	}
+	return name
+}
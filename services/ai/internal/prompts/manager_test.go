@@ -37,6 +37,68 @@ func TestPromptManagerBuildPrompt(t *testing.T) {
 	}
 }
 
+func TestPromptManagerComplexityTemplates(t *testing.T) {
+	pm, err := NewPromptManager()
+	if err != nil {
+		t.Fatalf("NewPromptManager error: %v", err)
+	}
+
+	// question context is optional for complexity analysis
+	prompt, err := pm.BuildPrompt("complexity", "default", map[string]interface{}{
+		"Language": "python",
+		"Code":     "for x in xs: pass",
+		"Question": (*struct{ PromptMarkdown, Constraints string })(nil),
+	})
+	if err != nil {
+		t.Fatalf("BuildPrompt error: %v", err)
+	}
+	if !containsAll(prompt, []string{"for x in xs: pass", "timeComplexity"}) || strings.Contains(prompt, "Problem context") {
+		t.Fatalf("unexpected complexity prompt: %s", prompt)
+	}
+
+	repair, err := pm.BuildPrompt("complexity", "repair", map[string]interface{}{
+		"Language": "python",
+		"Previous": "it is linear",
+	})
+	if err != nil {
+		t.Fatalf("BuildPrompt repair error: %v", err)
+	}
+	if !strings.Contains(repair, "it is linear") {
+		t.Fatalf("repair prompt should quote the previous answer: %s", repair)
+	}
+}
+
+func TestPromptManagerHintDiscussion(t *testing.T) {
+	pm, err := NewPromptManager()
+	if err != nil {
+		t.Fatalf("NewPromptManager error: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"Language":   "python",
+		"Code":       "print(1)",
+		"Question":   struct{ PromptMarkdown string }{"two sum"},
+		"HintLevel":  "intermediate",
+		"Discussion": "",
+	}
+	prompt, err := pm.BuildPrompt("hint", "default", data)
+	if err != nil {
+		t.Fatalf("BuildPrompt error: %v", err)
+	}
+	if strings.Contains(prompt, "discussing") {
+		t.Fatalf("hint prompt without a discussion should not mention one: %s", prompt)
+	}
+
+	data["Discussion"] = "alice: should we sort first?"
+	prompt, err = pm.BuildPrompt("hint", "default", data)
+	if err != nil {
+		t.Fatalf("BuildPrompt error: %v", err)
+	}
+	if !containsAll(prompt, []string{"two sum", "alice: should we sort first?"}) {
+		t.Fatalf("hint prompt should quote the discussion: %s", prompt)
+	}
+}
+
 func containsAll(haystack string, terms []string) bool {
 	for _, term := range terms {
 		if !strings.Contains(haystack, term) {
@@ -8,19 +8,23 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func AIRoutes(router *chi.Mux, aiHandler *handlers.AIHandler, feedbackHandler *handlers.FeedbackHandler, modelHandler *handlers.ModelHandler) {
+func AIRoutes(router *chi.Mux, aiHandler *handlers.AIHandler, feedbackHandler *handlers.FeedbackHandler, modelHandler *handlers.ModelHandler, digestHandler *handlers.DigestHandler) {
 	router.Route("/api/v1/ai", func(r chi.Router) {
 		// AI generation endpoints
 		r.With(middleware.ValidateRequest[*models.ExplainRequest]()).Post("/explain", aiHandler.ExplainHandler)
 		r.With(middleware.ValidateRequest[*models.HintRequest]()).Post("/hint", aiHandler.HintHandler)
 		r.With(middleware.ValidateRequest[*models.TestGenRequest]()).Post("/tests", aiHandler.TestsHandler)
 		r.With(middleware.ValidateRequest[*models.RefactorTipsRequest]()).Post("/refactor-tips", aiHandler.RefactorTipsHandler)
+		r.With(middleware.ValidateRequest[*models.ComplexityRequest]()).Post("/complexity", aiHandler.ComplexityHandler)
 
 		// Feedback endpoints
 		r.Post("/feedback/{request_id}", feedbackHandler.SubmitFeedback)
 		r.Get("/feedback/export", feedbackHandler.ExportFeedback)
 		r.Get("/feedback/stats", feedbackHandler.GetFeedbackStats)
 
+		// Moderation usage
+		r.Get("/moderation/stats", aiHandler.ModerationStatsHandler)
+
 		// Model management endpoints
 		if modelHandler != nil {
 			r.Get("/models", modelHandler.ListModels)
@@ -28,5 +32,11 @@ func AIRoutes(router *chi.Mux, aiHandler *handlers.AIHandler, feedbackHandler *h
 			r.Put("/models/{model_id}/traffic", modelHandler.UpdateTrafficWeight)
 			r.Put("/models/{model_id}/deactivate", modelHandler.DeactivateModel)
 		}
+
+		// Feedback digest endpoints
+		if digestHandler != nil {
+			r.Get("/digest/status", digestHandler.GetStatus)
+			r.Post("/digest/run", digestHandler.RunDigest)
+		}
 	})
 }
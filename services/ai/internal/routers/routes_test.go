@@ -62,8 +62,9 @@ func TestAIRoutesRegistersEndpoints(t *testing.T) {
 	aiHandler := handlers.NewAIHandler(stubProvider{}, stubPromptManager{}, logger)
 	feedbackHandler := handlers.NewFeedbackHandler(nil)
 	modelHandler := handlers.NewModelHandler(nil, nil)
+	digestHandler := handlers.NewDigestHandler(nil)
 
-	AIRoutes(router, aiHandler, feedbackHandler, modelHandler)
+	AIRoutes(router, aiHandler, feedbackHandler, modelHandler, digestHandler)
 
 	paths := map[string]bool{}
 	if err := chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
@@ -76,15 +77,19 @@ func TestAIRoutesRegistersEndpoints(t *testing.T) {
 	expected := []string{
 		"GET /api/v1/ai/feedback/export",
 		"GET /api/v1/ai/feedback/stats",
+		"GET /api/v1/ai/moderation/stats",
 		"POST /api/v1/ai/explain",
 		"POST /api/v1/ai/hint",
 		"POST /api/v1/ai/tests",
 		"POST /api/v1/ai/refactor-tips",
+		"POST /api/v1/ai/complexity",
 		"POST /api/v1/ai/feedback/{request_id}",
 		"GET /api/v1/ai/models",
 		"GET /api/v1/ai/models/{model_id}/stats",
 		"PUT /api/v1/ai/models/{model_id}/traffic",
 		"PUT /api/v1/ai/models/{model_id}/deactivate",
+		"GET /api/v1/ai/digest/status",
+		"POST /api/v1/ai/digest/run",
 	}
 
 	for _, route := range expected {
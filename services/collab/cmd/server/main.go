@@ -59,10 +59,16 @@ func run(parent context.Context) error {
 	}
 
 	roomManager := room_management.NewRoomManager(redisAddr, questionURL)
+	if key := os.Getenv("QUESTION_SERVICE_API_KEY"); key != "" {
+		roomManager.SetQuestionAPIKey(key)
+	} else {
+		log.Println("warning: QUESTION_SERVICE_API_KEY is not set; question fetches will fail if the question service requires a key")
+	}
 	defer roomManager.Cleanup()
 
 	// Start Redis subscription in background
 	go roomManager.SubscribeToMatches(ctx)
+	go roomManager.KeepOccupancyAlive(ctx)
 
 	r := chi.NewRouter()
 	r.Use(
@@ -83,7 +89,6 @@ func run(parent context.Context) error {
 	}))
 
 	r.Mount("/api/v1/collab", routers.New(logger, roomManager))
-	r.Handle("/api/v1/collab/metrics", metrics.Handler())
 
 	r.Get("/api/v1/collab/healthz", healthHandler)
 
@@ -0,0 +1,121 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"collab/internal/models"
+)
+
+// Client requests complexity verdicts from the AI service.
+type Client struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewClientFromEnv returns nil unless COLLAB_COMPLEXITY_ANALYSIS=true, so the
+// enrichment stays off by default.
+func NewClientFromEnv() *Client {
+	if strings.TrimSpace(os.Getenv("COLLAB_COMPLEXITY_ANALYSIS")) != "true" {
+		return nil
+	}
+	return newClientFromEnv()
+}
+
+// NewHintClientFromEnv returns nil unless COLLAB_THREAD_AI=true, so assistant
+// replies in discussion threads stay off by default.
+func NewHintClientFromEnv() *Client {
+	if strings.TrimSpace(os.Getenv("COLLAB_THREAD_AI")) != "true" {
+		return nil
+	}
+	return newClientFromEnv()
+}
+
+// NewSessionHintClientFromEnv returns nil unless AI_SERVICE_URL is set: hints
+// asked for from the editor are on wherever the AI service is deployed.
+func NewSessionHintClientFromEnv() *Client {
+	if strings.TrimSpace(os.Getenv("AI_SERVICE_URL")) == "" {
+		return nil
+	}
+	return newClientFromEnv()
+}
+
+func newClientFromEnv() *Client {
+	base := strings.TrimSpace(os.Getenv("AI_SERVICE_URL"))
+	if base == "" {
+		base = "http://localhost:8086"
+	}
+	return &Client{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(base, "/"),
+	}
+}
+
+type complexityRequest struct {
+	Code     string           `json:"code"`
+	Language string           `json:"language"`
+	Question *questionContext `json:"question,omitempty"`
+}
+
+// questionContext mirrors the AI service's question shape; test cases and
+// execution metadata are left out since they don't inform the analysis.
+type questionContext struct {
+	ID             int      `json:"id"`
+	Title          string   `json:"title"`
+	PromptMarkdown string   `json:"prompt_markdown"`
+	Difficulty     string   `json:"difficulty"`
+	TopicTags      []string `json:"topic_tags"`
+	Constraints    string   `json:"constraints,omitempty"`
+}
+
+type complexityResponse struct {
+	Analysis *models.ComplexityVerdict `json:"analysis"`
+	Raw      string                    `json:"raw"`
+	Code     string                    `json:"code"`
+}
+
+// Analyze returns the structured verdict for code. A nil verdict with a nil
+// error means the AI service could only produce unstructured text.
+func (c *Client) Analyze(ctx context.Context, lang models.Language, code string, question *models.Question) (*models.ComplexityVerdict, error) {
+	payload := complexityRequest{Code: code, Language: string(lang)}
+	if question != nil {
+		payload.Question = &questionContext{
+			ID:             question.ID,
+			Title:          question.Title,
+			PromptMarkdown: question.PromptMarkdown,
+			Difficulty:     question.Difficulty,
+			TopicTags:      question.TopicTags,
+			Constraints:    question.Constraints,
+		}
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/ai/complexity", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out complexityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		if out.Code == "" {
+			out.Code = resp.Status
+		}
+		return nil, fmt.Errorf("complexity analysis failed: %s", out.Code)
+	}
+	return out.Analysis, nil
+}
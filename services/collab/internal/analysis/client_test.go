@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"collab/internal/models"
+)
+
+func TestNewClientFromEnvDisabledByDefault(t *testing.T) {
+	t.Setenv("COLLAB_COMPLEXITY_ANALYSIS", "")
+	if NewClientFromEnv() != nil {
+		t.Fatalf("expected enrichment to be disabled without the flag")
+	}
+
+	t.Setenv("COLLAB_COMPLEXITY_ANALYSIS", "true")
+	t.Setenv("AI_SERVICE_URL", "http://ai:8080/")
+	client := NewClientFromEnv()
+	if client == nil || client.baseURL != "http://ai:8080" {
+		t.Fatalf("unexpected client: %#v", client)
+	}
+}
+
+func TestNewSessionHintClientFromEnv(t *testing.T) {
+	t.Setenv("AI_SERVICE_URL", "")
+	if NewSessionHintClientFromEnv() != nil {
+		t.Fatalf("expected session hints to be off without an AI service")
+	}
+
+	t.Setenv("AI_SERVICE_URL", "http://ai:8086")
+	if client := NewSessionHintClientFromEnv(); client == nil || client.baseURL != "http://ai:8086" {
+		t.Fatalf("unexpected client: %#v", client)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ai/complexity" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		switch got["code"] {
+		case "raw":
+			_, _ = w.Write([]byte(`{"raw":"unparseable","request_id":"r"}`))
+		case "limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"code":"rate_limit_exceeded","message":"slow down"}`))
+		default:
+			_, _ = w.Write([]byte(`{"analysis":{"timeComplexity":"O(n)","spaceComplexity":"O(1)","dominantOperations":[],"confidence":0.7}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{client: server.Client(), baseURL: server.URL}
+	question := &models.Question{ID: 3, PromptMarkdown: "sum", TestCases: []models.TestCase{{}}}
+
+	verdict, err := client.Analyze(context.Background(), models.LangPython, "total = sum(xs)", question)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if verdict == nil || verdict.TimeComplexity != "O(n)" || verdict.Confidence != 0.7 {
+		t.Fatalf("unexpected verdict: %#v", verdict)
+	}
+	q, _ := got["question"].(map[string]any)
+	if q["prompt_markdown"] != "sum" || q["test_cases"] != nil {
+		t.Fatalf("unexpected question payload: %#v", got["question"])
+	}
+
+	if verdict, err := client.Analyze(context.Background(), models.LangPython, "raw", nil); err != nil || verdict != nil {
+		t.Fatalf("expected nil verdict for raw fallback, got %#v, %v", verdict, err)
+	}
+	if _, err := client.Analyze(context.Background(), models.LangPython, "limited", nil); err == nil {
+		t.Fatalf("expected error for rate limited analysis")
+	}
+}
+
+func TestHint(t *testing.T) {
+	t.Setenv("COLLAB_THREAD_AI", "")
+	if NewHintClientFromEnv() != nil {
+		t.Fatalf("expected thread replies to be disabled without the flag")
+	}
+
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ai/hint" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		switch got["code"] {
+		case "limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"code":"rate_limit_exceeded","message":"slow down"}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"code":"provider_error","message":"down"}`))
+		default:
+			_, _ = w.Write([]byte(`{"hint":"try a hash map","request_id":"r"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{client: server.Client(), baseURL: server.URL}
+	question := &models.Question{ID: 3, PromptMarkdown: "two sum"}
+
+	hint, err := client.Hint(context.Background(), models.LangPython, "pass", question, "alice: sort first?")
+	if err != nil || hint != "try a hash map" {
+		t.Fatalf("unexpected hint: %q, %v", hint, err)
+	}
+	if got["discussion"] != "alice: sort first?" || got["hint_level"] != "intermediate" {
+		t.Fatalf("unexpected hint payload: %#v", got)
+	}
+	if _, err := client.Hint(context.Background(), models.LangPython, "limited", question, ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if _, err := client.Hint(context.Background(), models.LangPython, "broken", question, ""); err == nil || errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected provider error, got %v", err)
+	}
+	if _, err := client.Hint(context.Background(), models.LangPython, "pass", nil, ""); err == nil {
+		t.Fatalf("expected an error without a question")
+	}
+}
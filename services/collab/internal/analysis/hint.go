@@ -0,0 +1,84 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"collab/internal/models"
+)
+
+// threadHintLevel is the hint level assistant replies in threads are given at.
+const threadHintLevel = "intermediate"
+
+// ErrQuotaExceeded is returned when the AI service rate limits a request.
+var ErrQuotaExceeded = errors.New("ai quota exceeded")
+
+type hintRequest struct {
+	Code       string           `json:"code"`
+	Language   string           `json:"language"`
+	Question   *questionContext `json:"question"`
+	HintLevel  string           `json:"hint_level"`
+	Discussion string           `json:"discussion,omitempty"`
+}
+
+type hintResponse struct {
+	Hint string `json:"hint"`
+	Code string `json:"code"`
+}
+
+// Hint asks the AI service to answer discussion, a thread between the
+// participants, about question and the room's current code.
+func (c *Client) Hint(ctx context.Context, lang models.Language, code string, question *models.Question, discussion string) (string, error) {
+	if question == nil {
+		return "", errors.New("hint needs the room's question")
+	}
+	payload := hintRequest{
+		Code:     code,
+		Language: string(lang),
+		Question: &questionContext{
+			ID:             question.ID,
+			Title:          question.Title,
+			PromptMarkdown: question.PromptMarkdown,
+			Difficulty:     question.Difficulty,
+			TopicTags:      question.TopicTags,
+			Constraints:    question.Constraints,
+		},
+		HintLevel:  threadHintLevel,
+		Discussion: discussion,
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/ai/hint", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", ErrQuotaExceeded
+	}
+	var out hintResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		if out.Code == "" {
+			out.Code = resp.Status
+		}
+		return "", fmt.Errorf("hint failed: %s", out.Code)
+	}
+	if out.Hint == "" {
+		return "", errors.New("hint failed: empty response")
+	}
+	return out.Hint, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// batchRuns tracks the cancel function of each room's batch run in progress.
+// A room runs one batch at a time, guarded by session.Room.StartRun.
+type batchRuns struct {
+	mu    sync.Mutex
+	rooms map[string]context.CancelFunc
+}
+
+func (b *batchRuns) start(roomID string, cancel context.CancelFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rooms == nil {
+		b.rooms = make(map[string]context.CancelFunc)
+	}
+	b.rooms[roomID] = cancel
+}
+
+func (b *batchRuns) finish(roomID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.rooms, roomID)
+}
+
+// cancelFunc returns the cancel function of the room's batch run, reporting
+// whether there is one
+func (b *batchRuns) cancelFunc(roomID string) (context.CancelFunc, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cancel, ok := b.rooms[roomID]
+	return cancel, ok
+}
+
+// handleCancelRun stops the room's batch run on behalf of either participant.
+// The room is told who stopped it with run_cancelled, then the run ends with a
+// cancelled exit frame like any other run.
+func (h *Handlers) handleCancelRun(room *session.Room, client *session.Client) {
+	cancel, ok := h.batch.cancelFunc(room.ID)
+	if !ok {
+		h.sendError(client, "no_run_in_progress", nil)
+		return
+	}
+	room.BroadcastAll(models.WSFrame{Type: "run_cancelled", Data: models.RunCancelled{By: client.UserID}})
+	cancel()
+	room.RecordActivity("cancel_run", client.UserID, "")
+}
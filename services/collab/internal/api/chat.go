@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"collab/internal/models"
+)
+
+// GetChatHistory returns the room's chat, oldest message first, with the
+// read marks of its users. A room this instance does not hold is served from
+// its persisted chat.
+func (h *Handlers) GetChatHistory(w http.ResponseWriter, r *http.Request) {
+	matchId, _, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+
+	if room, ok := h.hub.Get(matchId); ok {
+		writeJSON(w, room.ChatState())
+		return
+	}
+	state, err := h.roomManager.LoadChatState(matchId)
+	if err != nil {
+		h.log.Error("failed to load chat history", "matchId", matchId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, "chat_unavailable", err)
+		return
+	}
+	if state == nil {
+		state = &models.ChatState{Messages: []models.ChatMessage{}, ReadMarks: map[string]int64{}}
+	}
+	writeJSON(w, state)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/models"
+	"collab/internal/utils"
+)
+
+var supportedLanguages = []models.Language{models.LangPython, models.LangJava, models.LangCPP, models.LangJavaScript, models.LangGo, models.LangRust}
+
+// languageSource lists the languages the sandbox runs, see exec.Runner
+type languageSource interface {
+	Languages(ctx context.Context) ([]models.Language, error)
+}
+
+const languagesFetchTimeout = 5 * time.Second
+
+// loadSupportedLanguages replaces supportedLanguages with the sandbox's list,
+// keeping the built-in one when the sandbox cannot be asked. It runs once at
+// startup, before any request is served.
+func loadSupportedLanguages(log *utils.Logger, src languageSource) {
+	ctx, cancel := context.WithTimeout(context.Background(), languagesFetchTimeout)
+	defer cancel()
+	languages, err := src.Languages(ctx)
+	if err != nil {
+		log.Warn("Using the built-in language list", "error", err.Error())
+		return
+	}
+	supportedLanguages = languages
+	log.Info("Languages loaded from the sandbox", "languages", languages)
+}
+
+// defaultRunLimits apply to rooms whose question carries no execution metadata.
+var defaultRunLimits = exec.SandboxLimits{
+	WallTime: 10 * time.Second,
+	MemoryB:  512 * 1024 * 1024,
+	NanoCPUs: 1_000_000_000,
+}
+
+// Global ceilings; a question can ask for more headroom but never beyond these.
+const (
+	maxRunWallTime = 30 * time.Second
+	maxRunMemoryB  = 2048 * 1024 * 1024
+)
+
+// allowedLanguages returns the languages a room may use, preserving the
+// service-wide ordering. Without metadata every supported language is allowed.
+func allowedLanguages(cfg *models.ExecutionConfig) []models.Language {
+	if cfg == nil || len(cfg.AllowedLanguages) == 0 {
+		return append([]models.Language(nil), supportedLanguages...)
+	}
+	allowed := make([]models.Language, 0, len(cfg.AllowedLanguages))
+	for _, lang := range supportedLanguages {
+		for _, candidate := range cfg.AllowedLanguages {
+			if candidate == lang {
+				allowed = append(allowed, lang)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+func languageAllowed(cfg *models.ExecutionConfig, lang models.Language) bool {
+	for _, allowed := range allowedLanguages(cfg) {
+		if allowed == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// runLimitsFor derives sandbox limits from the question metadata, clamped to
+// the global ceilings.
+func runLimitsFor(cfg *models.ExecutionConfig) exec.SandboxLimits {
+	limits := defaultRunLimits
+	if cfg == nil || cfg.Limits == nil {
+		return limits
+	}
+	if cfg.Limits.WallTimeMs > 0 {
+		limits.WallTime = time.Duration(cfg.Limits.WallTimeMs) * time.Millisecond
+		if limits.WallTime > maxRunWallTime {
+			limits.WallTime = maxRunWallTime
+		}
+	}
+	if cfg.Limits.MemoryMb > 0 {
+		limits.MemoryB = cfg.Limits.MemoryMb * 1024 * 1024
+		if limits.MemoryB > maxRunMemoryB {
+			limits.MemoryB = maxRunMemoryB
+		}
+	}
+	return limits
+}
+
+// Run argument, environment and input rules, kept in sync with the sandbox
+// service; stdin is capped well below the sandbox's own limit.
+const (
+	maxRunArgs        = 16
+	maxRunArgLen      = 256
+	maxRunEnvValueLen = 1024
+	maxRunStdinBytes  = 64 * 1024
+)
+
+var (
+	runEnvKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	deniedRunEnv     = map[string]bool{"PATH": true, "LD_PRELOAD": true, "HOME": true}
+)
+
+// validateInvocation checks the args, env and stdin a user supplied for a run
+// and returns one FieldError per violation.
+func validateInvocation(args []string, env map[string]string, stdin string) []models.FieldError {
+	var fields []models.FieldError
+	if len(args) > maxRunArgs {
+		fields = append(fields, models.FieldError{Field: "args", Reason: "at most 16 arguments are allowed"})
+	}
+	for i, arg := range args {
+		if len(arg) > maxRunArgLen {
+			fields = append(fields, models.FieldError{Field: "args[" + strconv.Itoa(i) + "]", Reason: "must be at most 256 characters"})
+		}
+	}
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := "env." + key
+		switch {
+		case !runEnvKeyPattern.MatchString(key):
+			fields = append(fields, models.FieldError{Field: field, Reason: "name must match [A-Z_][A-Z0-9_]*"})
+		case deniedRunEnv[key] || strings.HasPrefix(key, "SANDBOX_"):
+			fields = append(fields, models.FieldError{Field: field, Reason: "variable may not be set"})
+		case len(env[key]) > maxRunEnvValueLen:
+			fields = append(fields, models.FieldError{Field: field, Reason: "value must be at most 1024 bytes"})
+		}
+	}
+	if len(stdin) > maxRunStdinBytes {
+		fields = append(fields, models.FieldError{Field: "stdin", Reason: "must be at most 65536 bytes"})
+	}
+	return fields
+}
+
+// withInvocation sets the run's args, env and stdin on limits. Values
+// predefined by the question win: its args replace the user's and its env
+// vars override user vars of the same name.
+func withInvocation(limits exec.SandboxLimits, cfg *models.ExecutionConfig, args []string, env map[string]string, stdin string) exec.SandboxLimits {
+	limits.Stdin = stdin
+	limits.Args = args
+	if cfg != nil && len(cfg.Args) > 0 {
+		limits.Args = cfg.Args
+	}
+	if cfg == nil || len(cfg.Env) == 0 {
+		limits.Env = env
+		return limits
+	}
+	limits.Env = make(map[string]string, len(env)+len(cfg.Env))
+	for key, value := range env {
+		limits.Env[key] = value
+	}
+	for key, value := range cfg.Env {
+		limits.Env[key] = value
+	}
+	return limits
+}
+
+func constraintsFor(cfg *models.ExecutionConfig) models.Constraints {
+	c := models.Constraints{AllowedLanguages: allowedLanguages(cfg)}
+	if cfg != nil {
+		c.Limits = cfg.Limits
+	}
+	return c
+}
+
+func executionConfig(roomInfo *models.RoomInfo) *models.ExecutionConfig {
+	if roomInfo == nil || roomInfo.Question == nil {
+		return nil
+	}
+	return roomInfo.Question.Execution
+}
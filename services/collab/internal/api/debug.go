@@ -0,0 +1,95 @@
+package api
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"collab/internal/models"
+)
+
+// SetAdminToken sets the bearer token required by the debug endpoints
+func (h *Handlers) SetAdminToken(token string) {
+	h.adminToken = token
+}
+
+func (h *Handlers) authorizedAdmin(r *http.Request) bool {
+	if h.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// DebugRooms dumps the in-memory state of every room on this instance for
+// incident debugging. Each room is locked only while it is copied, never the
+// whole hub, so a dump cannot stall live traffic.
+func (h *Handlers) DebugRooms(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizedAdmin(r) {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	now := time.Now()
+	dump := models.HubDebug{
+		InstanceID: h.roomManager.GetInstanceID(),
+		TakenAt:    now.UnixMilli(),
+		Rooms:      []models.RoomDebug{},
+	}
+	for _, room := range h.hub.Rooms() {
+		snap := h.roomDebug(room.ID, room.DebugSnapshot(now))
+		dump.TotalClients += snap.ClientCount
+		dump.Rooms = append(dump.Rooms, snap)
+	}
+	sort.Slice(dump.Rooms, func(i, j int) bool { return dump.Rooms[i].MatchID < dump.Rooms[j].MatchID })
+	dump.TotalRooms = len(dump.Rooms)
+	dump.Goroutines = runtime.NumGoroutine()
+
+	writeDebugJSON(w, r, dump)
+}
+
+// DebugRoom is DebugRooms for a single room, with its recent activity log.
+func (h *Handlers) DebugRoom(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizedAdmin(r) {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	matchId := chi.URLParam(r, "matchId")
+	room, ok := h.hub.Get(matchId)
+	if !ok {
+		h.writeError(w, r, http.StatusNotFound, "room_not_found", nil)
+		return
+	}
+	writeDebugJSON(w, r, models.RoomDebugDetail{
+		RoomDebug: h.roomDebug(room.ID, room.DebugSnapshot(time.Now())),
+		Activity:  room.Activity(),
+	})
+}
+
+// roomDebug adds the state the handlers own rather than the room
+func (h *Handlers) roomDebug(roomID string, snap models.RoomDebug) models.RoomDebug {
+	snap.InstanceID = h.roomManager.GetInstanceID()
+	snap.Run.Interactive = h.interactive.state(roomID)
+	return snap
+}
+
+// writeDebugJSON writes v, gzip-compressed with ?gzip=true for large dumps
+func writeDebugJSON(w http.ResponseWriter, r *http.Request, v any) {
+	if r.URL.Query().Get("gzip") != "true" {
+		writeJSON(w, v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	_ = json.NewEncoder(gz).Encode(v)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/metrics"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// Text messages below this size are sent uncompressed even when the client
+// negotiated permessage-deflate; deflating a cursor frame costs more than it saves.
+const defaultWSCompressionMinBytes = 1024
+
+// wsCompressionFromEnv reads COLLAB_WS_COMPRESSION (on unless "false") and
+// COLLAB_WS_COMPRESSION_MIN_BYTES
+func wsCompressionFromEnv() (bool, int) {
+	enabled := strings.TrimSpace(os.Getenv("COLLAB_WS_COMPRESSION")) != "false"
+	minBytes := defaultWSCompressionMinBytes
+	if raw := strings.TrimSpace(os.Getenv("COLLAB_WS_COMPRESSION_MIN_BYTES")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			minBytes = n
+		}
+	}
+	return enabled, minBytes
+}
+
+func (h *Handlers) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin:       func(r *http.Request) bool { return true },
+		EnableCompression: h.wsCompression,
+	}
+}
+
+// handleResync answers a client that could not apply a doc_delta with the
+// full document
+func (h *Handlers) handleResync(room *session.Room, client *session.Client, req models.Resync) {
+	reason := req.Reason
+	if reason != metrics.ResyncHashMismatch && reason != metrics.ResyncVersionGap {
+		reason = metrics.ResyncOther
+	}
+	metrics.RecordDocResync(reason)
+	doc, _ := room.Snapshot()
+	client.SendDoc(nil, doc)
+}
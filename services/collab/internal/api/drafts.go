@@ -0,0 +1,256 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"collab/internal/models"
+	"collab/internal/session"
+	"collab/internal/utils"
+)
+
+const (
+	defaultDraftInterval = 30 * time.Second
+	// maxDraftBytes caps a single draft; larger snapshots are not stored.
+	maxDraftBytes = 256 * 1024
+)
+
+var errDraftTooLarge = errors.New("draft_too_large")
+
+// draftIntervalFromEnv reads COLLAB_DRAFT_INTERVAL (a Go duration such as
+// "30s"), falling back to the default for missing or invalid values.
+func draftIntervalFromEnv() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("COLLAB_DRAFT_INTERVAL"))
+	if raw == "" {
+		return defaultDraftInterval
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return defaultDraftInterval
+	}
+	return d
+}
+
+func newDraftTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// draftAutosave snapshots one connection's view of the document whenever
+// there was activity (an edit or cursor move) since the previous snapshot.
+type draftAutosave struct {
+	mu     sync.Mutex
+	cursor int
+	dirty  bool
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+	flush    func(cursor int)
+}
+
+// touch records activity; pos updates the remembered cursor when non-nil.
+func (d *draftAutosave) touch(pos *int) {
+	d.mu.Lock()
+	if pos != nil {
+		d.cursor = *pos
+	}
+	d.dirty = true
+	d.mu.Unlock()
+}
+
+// takeDirty reports whether there was activity and resets the flag.
+func (d *draftAutosave) takeDirty() (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dirty := d.dirty
+	d.dirty = false
+	return d.cursor, dirty
+}
+
+// stop ends the autosave loop and stores any unsaved activity.
+func (d *draftAutosave) stop() {
+	if d.flush == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+		<-d.done
+		if cursor, dirty := d.takeDirty(); dirty {
+			d.flush(cursor)
+		}
+	})
+}
+
+// startDraftAutosave runs the periodic draft snapshot for a connection. Drafts
+// are keyed by participant, so anonymous connections only track activity.
+func (h *Handlers) startDraftAutosave(room *session.Room, client *session.Client) *draftAutosave {
+	d := &draftAutosave{}
+	if client.UserID == "" {
+		return d
+	}
+
+	d.stopCh = make(chan struct{})
+	d.done = make(chan struct{})
+	d.flush = func(cursor int) {
+		if _, err := h.saveDraft(room, client, nil, cursor); err != nil {
+			h.log.Error("Failed to autosave draft", "roomId", room.ID, "userId", client.UserID, "error", err.Error())
+		}
+	}
+
+	ticks, cancel := h.draftTicker(h.draftInterval)
+	go func() {
+		defer close(d.done)
+		defer cancel()
+		for {
+			select {
+			case <-d.stopCh:
+				return
+			case <-ticks:
+				if cursor, dirty := d.takeDirty(); dirty {
+					d.flush(cursor)
+				}
+			}
+		}
+	}()
+	return d
+}
+
+// saveDraft stores client's draft. text overrides the shared document when the
+// client sends changes it has not synced yet.
+func (h *Handlers) saveDraft(room *session.Room, client *session.Client, text *string, cursor int) (models.Draft, error) {
+	doc, lang := room.Snapshot()
+	draft := models.Draft{
+		MatchID:  room.ID,
+		UserID:   client.UserID,
+		Text:     doc.Text,
+		Language: lang,
+		Cursor:   cursor,
+		Version:  doc.Version,
+		SavedAt:  time.Now().UnixMilli(),
+	}
+	if text != nil {
+		draft.Text = *text
+	}
+	if len(draft.Text) > maxDraftBytes {
+		return models.Draft{}, errDraftTooLarge
+	}
+	return draft, h.roomManager.SaveDraft(draft)
+}
+
+func (h *Handlers) handleDraftSave(room *session.Room, client *session.Client, drafts *draftAutosave, save models.DraftSave) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	if save.Cursor != nil {
+		drafts.touch(save.Cursor)
+	}
+	// An explicit save covers any pending autosave
+	cursor, _ := drafts.takeDirty()
+	draft, err := h.saveDraft(room, client, save.Text, cursor)
+	if errors.Is(err, errDraftTooLarge) {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if err != nil {
+		h.log.Error("Failed to save draft", "roomId", room.ID, "userId", client.UserID, "error", err.Error())
+		h.sendError(client, "draft_save_failed", err)
+		return
+	}
+	client.Send(models.WSFrame{Type: "draft_saved", Data: map[string]int64{"savedAt": draft.SavedAt, "version": draft.Version}})
+}
+
+// handleDraftRestore asks the partner to approve replacing the shared document
+// with the requester's draft.
+func (h *Handlers) handleDraftRestore(room *session.Room, client *session.Client) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	draft, err := h.roomManager.LoadDraft(room.ID, client.UserID)
+	if err != nil {
+		h.log.Error("Failed to load draft", "roomId", room.ID, "userId", client.UserID, "error", err.Error())
+		h.sendError(client, "draft_unavailable", err)
+		return
+	}
+	if draft == nil {
+		h.sendError(client, "no_draft", nil)
+		return
+	}
+	if err := room.RequestDraftRestore(client, *draft); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	req := models.DraftRestoreRequest{UserID: client.UserID, SavedAt: draft.SavedAt}
+	room.Broadcast(client, models.WSFrame{Type: "draft_restore_request", Data: req})
+	client.Send(models.WSFrame{Type: "draft_restore_pending", Data: req})
+}
+
+// handleDraftRestoreConfirm applies or rejects the pending restore. An applied
+// restore is an ordinary edit, so every client receives the bumped doc.
+func (h *Handlers) handleDraftRestoreConfirm(room *session.Room, client *session.Client, confirm models.DraftRestoreConfirm) {
+	requester, draft, doc, err := room.ResolveDraftRestore(client, confirm.Accept)
+	if err != nil {
+		if errors.Is(err, session.ErrNoPendingRestore) {
+			h.sendError(client, errorCode(err), err)
+		} else {
+			h.sendError(client, mapOTError(err), err)
+		}
+		return
+	}
+	if !confirm.Accept {
+		requester.Send(models.WSFrame{Type: "draft_restore_rejected", Data: map[string]string{"userId": client.UserID}})
+		return
+	}
+	room.BroadcastDoc(nil, nil, doc)
+	room.BroadcastAll(models.WSFrame{Type: "draft_restored", Data: map[string]any{"userId": draft.UserID, "version": doc.Version}})
+}
+
+// GetDraft returns the caller's own recovery draft (requires the caller's room token)
+func (h *Handlers) GetDraft(w http.ResponseWriter, r *http.Request) {
+	matchId := chi.URLParam(r, "matchId")
+	if matchId == "" {
+		h.writeError(w, r, http.StatusBadRequest, "match_id_required", nil)
+		return
+	}
+
+	token, err := utils.ExtractTokenFromHeader(r.Header.Get("Authorization"))
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", err)
+		return
+	}
+
+	roomInfo, err := h.roomManager.ValidateRoomAccess(token)
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", err)
+		return
+	}
+	if roomInfo.MatchId != matchId {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_room", nil)
+		return
+	}
+	userID := participantID(roomInfo, token)
+	if userID == "" {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	draft, err := h.roomManager.LoadDraft(matchId, userID)
+	if err != nil {
+		h.log.Error("failed to load draft", "matchId", matchId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, "draft_unavailable", nil)
+		return
+	}
+	if draft == nil {
+		h.writeError(w, r, http.StatusNotFound, "no_draft", nil)
+		return
+	}
+	writeJSON(w, draft)
+}
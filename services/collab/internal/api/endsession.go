@@ -0,0 +1,77 @@
+package api
+
+import (
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// handleEndSession asks the partner to agree to ending the session, or ends
+// it when that needs no more agreement. It reports whether the session ended.
+func (h *Handlers) handleEndSession(room *session.Room, client *session.Client) bool {
+	req, agreed, err := room.RequestEndSession(client)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return false
+	}
+	if agreed {
+		h.endSession(room, client)
+		return true
+	}
+	room.Broadcast(client, models.WSFrame{Type: "end_session_requested", Data: req})
+	client.Send(models.WSFrame{Type: "end_session_pending", Data: req})
+	return false
+}
+
+// handleEndSessionConfirm ends the session once the request to end it is
+// agreed to. It reports whether the session ended.
+func (h *Handlers) handleEndSessionConfirm(room *session.Room, client *session.Client) bool {
+	if err := room.ConfirmEndSession(client); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return false
+	}
+	h.endSession(room, client)
+	return true
+}
+
+// handleEndSessionCancel drops the request to end the session, whichever
+// participant sent it.
+func (h *Handlers) handleEndSessionCancel(room *session.Room, client *session.Client) {
+	if err := room.CancelEndSession(); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.BroadcastAll(models.WSFrame{Type: "end_session_cancelled", Data: map[string]string{"userId": client.UserID}})
+}
+
+// endSession ends the room for both participants.
+func (h *Handlers) endSession(room *session.Room, client *session.Client) {
+	room.RecordActivity("end_session", client.UserID, "")
+	if err := h.roomManager.MarkRoomAsEnded(room.ID); err != nil {
+		h.log.Error("Failed to mark room as ended", "sessionID", room.ID, "error", err.Error())
+	}
+	h.stopInteractive(room.ID)
+	room.BroadcastAll(models.WSFrame{Type: "session_ended", Data: map[string]string{"reason": "ended"}})
+	room.EndSessionNow()
+}
+
+// endedByParticipants reports whether the participants ended the session
+// themselves, rather than it being abandoned or timing out
+func endedByParticipants(room *session.Room) bool {
+	for _, entry := range room.Activity() {
+		if entry.Type == "end_session" {
+			return true
+		}
+	}
+	return false
+}
+
+// abandonSession ends the session once userID's grace period to rejoin has
+// lapsed. Like an ended one, the session is reported and the room dropped by
+// the session end handler.
+func (h *Handlers) abandonSession(room *session.Room, userID string) {
+	h.log.Info("Ending abandoned session", "sessionID", room.ID, "userId", userID)
+	room.RecordActivity("abandoned", userID, "")
+	h.stopInteractive(room.ID)
+	room.BroadcastAll(models.WSFrame{Type: "session_ended", Data: map[string]string{"reason": "partner_abandoned", "userId": userID}})
+	room.EndSessionNow()
+}
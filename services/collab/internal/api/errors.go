@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"collab/internal/i18n"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// debugErrorsFromEnv reports whether COLLAB_DEBUG_ERRORS is set, which adds
+// the internal error text to responses as "detail". Never enable it in production.
+func debugErrorsFromEnv() bool {
+	return strings.TrimSpace(os.Getenv("COLLAB_DEBUG_ERRORS")) == "true"
+}
+
+// requestLocale picks the message locale from the Accept-Language header;
+// WebSocket clients, which cannot set headers in browsers, may use ?lang=.
+func requestLocale(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return i18n.Negotiate(lang)
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// errorCode maps an error to its client code. Errors whose text is a known
+// code (the session package's sentinel errors) keep it; anything else is an
+// internal failure whose text must not reach clients.
+func errorCode(err error) string {
+	if err != nil && i18n.Known(err.Error()) {
+		return err.Error()
+	}
+	return i18n.GenericCode
+}
+
+func (h *Handlers) detail(err error) string {
+	if err == nil || !h.debugErrors {
+		return ""
+	}
+	return err.Error()
+}
+
+// writeError writes the JSON error envelope. err is the underlying cause, if
+// any: it is logged for server errors and only returned in debug mode.
+func (h *Handlers) writeError(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	if err != nil && status >= http.StatusInternalServerError {
+		h.log.Error("request failed", "path", r.URL.Path, "code", code, "error", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(models.ErrorResponse{
+		Code:    code,
+		Message: i18n.Message(requestLocale(r), code),
+		Detail:  h.detail(err),
+	})
+}
+
+// writeInvalidInvocation rejects a run whose args or env break the rules.
+func (h *Handlers) writeInvalidInvocation(w http.ResponseWriter, r *http.Request, details []models.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(models.ErrorResponse{
+		Code:    "invalid_invocation",
+		Message: i18n.Message(requestLocale(r), "invalid_invocation"),
+		Details: details,
+	})
+}
+
+// errorFrame builds an "error" WS frame in the given locale.
+func (h *Handlers) errorFrame(locale, code string, err error) models.WSFrame {
+	return models.WSFrame{Type: "error", Data: code, Code: code, Message: i18n.Message(locale, code), Detail: h.detail(err)}
+}
+
+// sendError sends an error frame in the client's locale.
+func (h *Handlers) sendError(client *session.Client, code string, err error) {
+	client.Send(h.errorFrame(client.Locale, code, err))
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"collab/internal/models"
+)
+
+// exportContentTypes are the media types of exported source files; other
+// languages are sent as plain text
+var exportContentTypes = map[models.Language]string{
+	models.LangPython:     "text/x-python",
+	models.LangJava:       "text/x-java-source",
+	models.LangCPP:        "text/x-c++src",
+	models.LangJavaScript: "text/javascript",
+	models.LangGo:         "text/x-go",
+	models.LangRust:       "text/x-rust",
+}
+
+// ExportCode downloads the room's current code as a source file named after
+// the language, or as {text, language, version} with ?format=json. A room
+// this instance does not hold is exported from its persisted document.
+func (h *Handlers) ExportCode(w http.ResponseWriter, r *http.Request) {
+	matchId, _, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+
+	doc := models.DocSnapshot{Language: models.LangPython}
+	if room, ok := h.hub.Get(matchId); ok {
+		state, lang := room.Snapshot()
+		doc = models.DocSnapshot{Text: state.Text, Version: state.Version, Language: lang}
+	} else {
+		stored, err := h.roomManager.LoadDoc(matchId)
+		if err != nil {
+			h.log.Error("failed to load document for export", "matchId", matchId, "error", err.Error())
+			h.writeError(w, r, http.StatusInternalServerError, "export_failed", err)
+			return
+		}
+		if stored != nil {
+			doc = *stored
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, doc)
+		return
+	}
+	contentType, ok := exportContentTypes[doc.Language]
+	if !ok {
+		contentType = "text/plain"
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", h.exportFileName(doc.Language)))
+	_, _ = w.Write([]byte(doc.Text))
+}
+
+// exportFileName is the file the language's programs are run from
+func (h *Handlers) exportFileName(lang models.Language) string {
+	spec, _, _, _, err := h.runner.LangSpecPublic(lang)
+	if err != nil || spec.FileName == "" {
+		return "code.txt"
+	}
+	return spec.FileName
+}
@@ -5,14 +5,21 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Jeffail/leaps/lib/text"
 	"github.com/go-chi/chi/v5"
-	"github.com/gorilla/websocket"
 
+	"collab/internal/analysis"
 	"collab/internal/exec"
 	"collab/internal/format"
+	"collab/internal/i18n"
+	"collab/internal/lint"
+	"collab/internal/metrics"
 	"collab/internal/models"
 	"collab/internal/room_management"
 	"collab/internal/session"
@@ -24,37 +31,137 @@ type Handlers struct {
 	runner      runner
 	hub         *session.Hub
 	roomManager roomManager
+	analyzer    analyzer // Optional, enriches successful runs with a complexity verdict
+	hinter      hinter   // Optional, answers thread replies that ask the assistant
+	hintClient  hinter   // Optional, answers hint_request frames
+	linter      *lint.Linter
+
+	threadAIBudget int // assistant replies per room (COLLAB_THREAD_AI_BUDGET)
+
+	draftInterval time.Duration
+	draftTicker   func(time.Duration) (<-chan time.Time, func()) // replaceable in tests
+
+	interactive interactiveRuns
+	batch       batchRuns
+
+	adminToken string // bearer token for the debug endpoints; empty disables them
+
+	debugErrors bool // include internal error text in responses (COLLAB_DEBUG_ERRORS)
+
+	wsCompression bool  // offer permessage-deflate (COLLAB_WS_COMPRESSION)
+	wsCompressMin int   // smallest message compressed (COLLAB_WS_COMPRESSION_MIN_BYTES)
+	wsReadLimit   int64 // largest frame read from a client (COLLAB_WS_MAX_MESSAGE_BYTES)
+
+	idleTimeout   time.Duration // rooms idle this long are ended (COLLAB_IDLE_TIMEOUT)
+	rerollTimeout time.Duration // how long a proposed reroll waits for the partner
+	pingInterval  time.Duration // how often each connection is pinged
+	pongWait      time.Duration // connections silent this long are dropped
 }
 
 type runner interface {
 	LangSpecPublic(models.Language) (models.LanguageSpec, string, string, [][]string, error)
 	RunOnce(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error)
-	RunStream(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) ([]models.WSFrame, error)
+	// RunStreamFn hands each frame of the run to emit as the program produces it
+	RunStreamFn(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits, emit func(models.WSFrame)) error
+	StartInteractive(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.InteractiveSession, error)
+}
+
+type analyzer interface {
+	Analyze(ctx context.Context, lang models.Language, code string, question *models.Question) (*models.ComplexityVerdict, error)
 }
 
 type roomManager interface {
 	GetInstanceID() string
+	Ready(ctx context.Context) error
 	ValidateRoomAccess(token string) (*models.RoomInfo, error)
+	ValidateSpectatorAccess(token string) (*models.RoomInfo, string, error)
 	GetRoomStatus(matchId string) (*models.RoomInfo, error)
 	RerollQuestion(matchId string) (*models.RoomInfo, error)
+	RetryQuestion(matchId string) (*models.RoomInfo, error)
+	SetOccupancy(matchID string, userIDs []string) error
+	RemoteOccupancy(matchID string) ([]string, error)
 	GetActiveRoomForUser(userId string) (*models.RoomInfo, error)
 	PublishSessionEnded(event models.SessionEndedEvent) error
+	ReportSessionResult(roomInfo *models.RoomInfo, outcome string) error
+	SaveSessionSummary(summary models.SessionSummary) error
+	LoadSessionSummary(matchID string) (*models.SessionSummary, error)
+	RecordUserSession(userID string, record models.SessionRecord) error
+	RecentSessions(userID string, limit int) ([]models.SessionRecord, error)
 	MarkRoomAsEnded(matchID string) error
+	SaveChatState(matchID string, state models.ChatState) error
+	LoadChatState(matchID string) (*models.ChatState, error)
+	SaveRoomSettings(matchID string, settings models.RoomSettings) error
+	LoadRoomSettings(matchID string) (*models.RoomSettings, error)
+	SaveRubricState(matchID string, items []models.RubricItem) error
+	LoadRubricState(matchID string) ([]models.RubricItem, error)
+	SaveTurnState(matchID string, state models.TurnState) error
+	LoadTurnState(matchID string) (*models.TurnState, error)
+	SaveThreadState(matchID string, state models.ThreadState) error
+	LoadThreadState(matchID string) (*models.ThreadState, error)
+	SaveDraft(draft models.Draft) error
+	LoadDraft(matchID, userID string) (*models.Draft, error)
+	SaveDoc(matchID string, doc models.DocSnapshot) error
+	LoadDoc(matchID string) (*models.DocSnapshot, error)
+	AddSnapshot(matchID string, snap models.Snapshot) error
+	ListSnapshots(matchID string) ([]models.Snapshot, error)
 	SetRoomUpdateCallback(callback func(matchId string, roomInfo *models.RoomInfo))
 	SubscribeToRoomUpdates(ctx context.Context)
 }
 
 func NewHandlers(log *utils.Logger, roomManager *room_management.RoomManager) *Handlers {
-	return NewHandlersWithDeps(log, exec.NewRunner(), session.NewHub(), roomManager)
+	runner := exec.NewRunner()
+	if os.Getenv("SANDBOX_URL") != "" {
+		loadSupportedLanguages(log, runner)
+	}
+	h := NewHandlersWithDeps(log, runner, session.NewHub(), roomManager, roomOptionsFromEnv()...)
+	if client := analysis.NewClientFromEnv(); client != nil {
+		h.SetAnalyzer(client)
+		log.Info("Complexity analysis enrichment enabled")
+	}
+	if client := analysis.NewHintClientFromEnv(); client != nil {
+		h.SetHinter(client)
+		log.Info("Assistant replies in discussion threads enabled")
+	}
+	if client := analysis.NewSessionHintClientFromEnv(); client != nil {
+		h.SetHintClient(client)
+		log.Info("Hints in sessions enabled")
+	}
+	return h
 }
 
-func NewHandlersWithDeps(log *utils.Logger, runner runner, hub *session.Hub, roomManager roomManager) *Handlers {
+// NewHandlersWithDeps builds the handlers on the given dependencies. opts
+// configure every room the hub creates, e.g. its document and edit limits.
+func NewHandlersWithDeps(log *utils.Logger, runner runner, hub *session.Hub, roomManager roomManager, opts ...session.RoomOption) *Handlers {
 	h := &Handlers{
 		log:         log,
 		runner:      runner,
 		hub:         hub,
 		roomManager: roomManager,
+		linter:      lint.NewLinter(runner),
+
+		draftInterval: draftIntervalFromEnv(),
+		draftTicker:   newDraftTicker,
+
+		adminToken: strings.TrimSpace(os.Getenv("COLLAB_ADMIN_TOKEN")),
+
+		debugErrors: debugErrorsFromEnv(),
+
+		threadAIBudget: threadAIBudgetFromEnv(),
+
+		idleTimeout:   idleTimeoutFromEnv(),
+		rerollTimeout: defaultRerollTimeout,
+		pingInterval:  defaultPingInterval,
+		pongWait:      2 * defaultPingInterval,
 	}
+	h.wsCompression, h.wsCompressMin = wsCompressionFromEnv()
+	h.wsReadLimit = wsReadLimitFromEnv()
+
+	// Keep room documents in Redis so they survive instance restarts
+	hub.SetDocStore(roomManager, func(matchID string, err error) {
+		log.Error("Failed to persist room document", "roomId", matchID, "error", err.Error())
+	})
+	hub.SetSnapshotStore(roomManager, snapshotEveryFromEnv())
+	hub.SetRoomOptions(opts...)
 
 	// Set up callback for room updates
 	roomManager.SetRoomUpdateCallback(h.handleRoomUpdate)
@@ -62,11 +169,19 @@ func NewHandlersWithDeps(log *utils.Logger, runner runner, hub *session.Hub, roo
 	// Subscribe to room updates in background
 	go roomManager.SubscribeToRoomUpdates(context.Background())
 
+	// End sessions whose users went away without ending them
+	go h.expireIdleRooms()
+
 	log.Info("Handlers initialized with room update subscription")
 
 	return h
 }
 
+// SetAnalyzer enables complexity verdicts after successful runs
+func (h *Handlers) SetAnalyzer(a analyzer) {
+	h.analyzer = a
+}
+
 // handleRoomUpdate is called when a room update is received from Redis
 // This broadcasts the update to WebSocket clients connected to THIS instance
 func (h *Handlers) handleRoomUpdate(matchId string, roomInfo *models.RoomInfo) {
@@ -89,6 +204,10 @@ func (h *Handlers) handleRoomUpdate(matchId string, roomInfo *models.RoomInfo) {
 			},
 		})
 		h.log.Info("Broadcasted question update to WebSocket clients", "matchId", matchId)
+		room.RecordActivity("question", "", strconv.Itoa(roomInfo.Question.ID))
+		h.applyConstraints(room, roomInfo.Question.Execution)
+		h.applyRubric(room, roomInfo.Question.Rubric)
+		room.SetStarterCode(roomInfo.Question.StarterCode)
 	}
 
 	// If room was ended, notify clients
@@ -101,10 +220,68 @@ func (h *Handlers) handleRoomUpdate(matchId string, roomInfo *models.RoomInfo) {
 	}
 }
 
+// starterTemplate is the code a room in lang starts from: the question's
+// starter code for lang, or else the language's example template.
+func (h *Handlers) starterTemplate(room *session.Room, lang models.Language) string {
+	if code := room.StarterCode(lang); code != "" {
+		return code
+	}
+	spec, _, _, _, err := h.runner.LangSpecPublic(lang)
+	if err != nil {
+		return ""
+	}
+	return spec.ExampleTemplate
+}
+
+// applyConstraints installs the question's execution metadata on the room and
+// notifies clients when it changed. If the current language is no longer
+// allowed the room switches to the first allowed one.
+func (h *Handlers) applyConstraints(room *session.Room, cfg *models.ExecutionConfig) {
+	if reflect.DeepEqual(room.ExecutionConfig(), cfg) {
+		return
+	}
+	room.SetExecutionConfig(cfg)
+	room.BroadcastAll(models.WSFrame{Type: "constraints", Data: constraintsFor(cfg)})
+
+	_, lang := room.Snapshot()
+	if languageAllowed(cfg, lang) {
+		return
+	}
+	if allowed := allowedLanguages(cfg); len(allowed) > 0 {
+		room.SetLanguage(allowed[0])
+		room.BroadcastAll(models.WSFrame{Type: "language", Data: allowed[0]})
+	}
+}
+
 func (h *Handlers) Health(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
+// readyTimeout bounds the dependency checks of one readiness probe
+const readyTimeout = 2 * time.Second
+
+// Ready answers 200 once Redis, the match subscription and the question
+// service are all up, and 503 naming the dependency that is not.
+func (h *Handlers) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	err := h.roomManager.Ready(ctx)
+	if err == nil {
+		writeJSON(w, models.Readiness{Status: "ready"})
+		return
+	}
+	dependency := "unknown"
+	var depErr *room_management.DependencyError
+	if errors.As(err, &depErr) {
+		dependency = depErr.Dependency
+	}
+	h.log.Error("not ready", "dependency", dependency, "error", err.Error())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(models.Readiness{Status: "not_ready", Dependency: dependency, Detail: h.detail(err)})
+}
+
 func (h *Handlers) GetInstanceID(w http.ResponseWriter, _ *http.Request) {
 	managerId := h.roomManager.GetInstanceID()
 	writeJSON(w, map[string]string{"managerId": managerId})
@@ -114,7 +291,7 @@ func (h *Handlers) GetInstanceID(w http.ResponseWriter, _ *http.Request) {
 func (h *Handlers) GetRoomStatus(w http.ResponseWriter, r *http.Request) {
 	matchId := chi.URLParam(r, "matchId")
 	if matchId == "" {
-		http.Error(w, "matchId is required", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, "match_id_required", nil)
 		return
 	}
 
@@ -122,25 +299,32 @@ func (h *Handlers) GetRoomStatus(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	token, err := utils.ExtractTokenFromHeader(authHeader)
 	if err != nil {
-		http.Error(w, "Authorization token required", http.StatusUnauthorized)
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", err)
 		return
 	}
 
 	// Validate token and get room info
 	roomInfo, err := h.roomManager.ValidateRoomAccess(token)
 	if err != nil {
-		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", err)
 		return
 	}
 
-	writeJSON(w, roomInfo)
+	status := *roomInfo
+	local := []string{}
+	if room, ok := h.hub.Get(status.MatchId); ok {
+		status.Spectators = room.SpectatorCount()
+		local = room.ConnectedUsers()
+	}
+	status.Occupancy = h.occupancy(status.MatchId, local)
+	writeJSON(w, status)
 }
 
 // GetActiveRoom checks if a user has an active room
 func (h *Handlers) GetActiveRoom(w http.ResponseWriter, r *http.Request) {
 	userId := chi.URLParam(r, "userId")
 	if userId == "" {
-		http.Error(w, "userId is required", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, "user_id_required", nil)
 		return
 	}
 
@@ -167,54 +351,112 @@ func (h *Handlers) GetActiveRoom(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// RerollQuestion proposes another question to the partner, who approves it
+// with a reroll_vote frame or ApproveReroll. Alone in the room, or when the
+// partner proposed one too, the question is rerolled straight away.
 func (h *Handlers) RerollQuestion(w http.ResponseWriter, r *http.Request) {
-	matchId := chi.URLParam(r, "matchId")
-	if matchId == "" {
-		http.Error(w, "matchId is required", http.StatusBadRequest)
+	matchId, userID, ok := h.roomAccess(w, r)
+	if !ok {
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	token, err := utils.ExtractTokenFromHeader(authHeader)
+	if room, ok := h.hub.Get(matchId); ok {
+		proposal, agreed, err := room.ProposeReroll(userID, h.rerollTimeout)
+		if err != nil {
+			h.writeError(w, r, http.StatusConflict, errorCode(err), nil)
+			return
+		}
+		if !agreed {
+			room.BroadcastAll(models.WSFrame{Type: "reroll_proposed", Data: proposal})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(proposal)
+			return
+		}
+	}
+	h.reroll(w, r, matchId)
+}
+
+// roomAccess checks the room token of a request on /room/{matchId} and
+// returns the room and the participant it belongs to.
+func (h *Handlers) roomAccess(w http.ResponseWriter, r *http.Request) (matchId, userID string, ok bool) {
+	matchId = chi.URLParam(r, "matchId")
+	if matchId == "" {
+		h.writeError(w, r, http.StatusBadRequest, "match_id_required", nil)
+		return "", "", false
+	}
+
+	token, err := utils.ExtractTokenFromHeader(r.Header.Get("Authorization"))
 	if err != nil {
-		http.Error(w, "Authorization token required", http.StatusUnauthorized)
-		return
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", err)
+		return "", "", false
 	}
 
 	roomInfo, err := h.roomManager.ValidateRoomAccess(token)
 	if err != nil {
-		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
-		return
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", err)
+		return "", "", false
 	}
 
 	if roomInfo.MatchId != matchId {
-		http.Error(w, "Invalid room", http.StatusBadRequest)
-		return
+		h.writeError(w, r, http.StatusBadRequest, "invalid_room", nil)
+		return "", "", false
 	}
+	return matchId, participantID(roomInfo, token), true
+}
 
+// reroll replaces the room's question and answers with the updated room
+func (h *Handlers) reroll(w http.ResponseWriter, r *http.Request, matchId string) {
 	updated, err := h.roomManager.RerollQuestion(matchId)
 	if err != nil {
-		switch {
-		case errors.Is(err, room_management.ErrNoRerolls):
-			http.Error(w, "No rerolls remaining for this room", http.StatusBadRequest)
-		case errors.Is(err, room_management.ErrNoAlternativeQuestion):
-			http.Error(w, "No different question available right now", http.StatusConflict)
-		default:
-			http.Error(w, "Failed to reroll question", http.StatusInternalServerError)
+		status, code := rerollError(err)
+		if status == http.StatusInternalServerError {
 			h.log.Error("failed to reroll question", "matchId", matchId, "error", err.Error())
 		}
+		h.writeError(w, r, status, code, err)
 		return
 	}
 
+	// Clients of the room are sent the new question by handleRoomUpdate, on
+	// this instance and on the others, so it is not broadcast here
 	writeJSON(w, updated)
+}
 
-	// The broadcast to WebSocket clients will happen automatically via handleRoomUpdate callback
-	// No need to manually broadcast here - the RoomManager will publish to Redis,
-	// and all instances (including this one) will receive the update
+// RetryQuestion fetches the question again for a room whose setup failed.
+// It answers 202 with the room back in processing; the question reaches the
+// room's clients through handleRoomUpdate once it is in.
+func (h *Handlers) RetryQuestion(w http.ResponseWriter, r *http.Request) {
+	matchId, _, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+	updated, err := h.roomManager.RetryQuestion(matchId)
+	if errors.Is(err, room_management.ErrRoomNotInError) {
+		h.writeError(w, r, http.StatusConflict, "room_not_in_error", nil)
+		return
+	}
+	if err != nil {
+		h.log.Error("failed to retry question", "matchId", matchId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, i18n.GenericCode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(updated)
 }
 
-func (h *Handlers) ListLanguages(w http.ResponseWriter, _ *http.Request) {
-	languages := []models.Language{models.LangPython, models.LangJava, models.LangCPP}
+// ListLanguages returns the supported language specs. With ?matchId= only the
+// languages allowed by that room's question are listed.
+func (h *Handlers) ListLanguages(w http.ResponseWriter, r *http.Request) {
+	languages := supportedLanguages
+	if matchId := r.URL.Query().Get("matchId"); matchId != "" {
+		roomInfo, err := h.roomManager.GetRoomStatus(matchId)
+		if err != nil {
+			h.writeError(w, r, http.StatusNotFound, "room_not_found", err)
+			return
+		}
+		languages = allowedLanguages(executionConfig(roomInfo))
+	}
 	resp := make([]models.LanguageSpec, 0, len(languages))
 	for _, lang := range languages {
 		spec, _, _, _, err := h.runner.LangSpecPublic(lang)
@@ -229,52 +471,161 @@ func (h *Handlers) ListLanguages(w http.ResponseWriter, _ *http.Request) {
 func (h *Handlers) FormatCode(w http.ResponseWriter, r *http.Request) {
 	var req models.FormatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", err)
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
 	out, err := format.Format(ctx, req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	switch {
+	case err == nil:
+		writeJSON(w, models.FormatResponse{Formatted: out})
+	case errors.Is(err, format.ErrUnsupportedLanguage):
+		h.writeError(w, r, http.StatusBadRequest, "unsupported_language", err)
+	case errors.Is(err, format.ErrSyntax):
+		h.writeError(w, r, http.StatusUnprocessableEntity, "format_syntax_error", err)
+	case errors.Is(err, format.ErrFormatterUnavailable):
+		h.writeError(w, r, http.StatusServiceUnavailable, "formatter_unavailable", err)
+	default:
+		h.writeError(w, r, http.StatusInternalServerError, "format_failed", err)
 	}
-	writeJSON(w, models.FormatResponse{Formatted: out})
 }
 
+// RunOnce executes code and returns the result. With a matchId the run goes
+// through the room like a WS run: the partner sees the output live and it is
+// kept in the room's run history.
 func (h *Handlers) RunOnce(w http.ResponseWriter, r *http.Request) {
 	var req models.RunRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+	if details := validateInvocation(req.Args, req.Env, req.Stdin); len(details) > 0 {
+		h.writeInvalidInvocation(w, r, details)
 		return
 	}
-	limits := exec.SandboxLimits{
-		WallTime: 10 * time.Second,
-		MemoryB:  512 * 1024 * 1024,
-		NanoCPUs: 1_000_000_000,
+	if req.MatchID != "" {
+		h.runOnceInRoom(w, r, req)
+		return
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 12*time.Second)
+	limits := withInvocation(defaultRunLimits, nil, req.Args, req.Env, req.Stdin)
+	ctx, cancel := context.WithTimeout(r.Context(), limits.WallTime+2*time.Second)
 	defer cancel()
 
+	metrics.RecordRunStarted(string(req.Language))
 	out, err := h.runner.RunOnce(ctx, req.Language, req.Code, limits)
+	metrics.RecordRun(string(req.Language), runOnceOutcome(out, err))
 	if err != nil {
 		switch {
 		case errors.Is(err, exec.ErrDockerUnavailable):
-			http.Error(w, "sandbox_unavailable", http.StatusServiceUnavailable)
+			h.writeError(w, r, http.StatusServiceUnavailable, "sandbox_unavailable", err)
 		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			h.writeError(w, r, http.StatusInternalServerError, "run_failed", err)
 		}
 		return
 	}
 	writeJSON(w, models.RunResult{
 		Stdout: out.Stdout, Stderr: out.Stderr, Exit: out.Exit, TimedOut: out.TimedOut,
+		Args: out.Args, Env: out.Env, Usage: out.Usage, OutputTruncated: out.OutputTruncated,
+		OOMKilled: out.OOMKilled, CompileOutput: out.CompileOutput,
 	})
 }
 
-/*** Collab WebSocket: shared editor + run streaming ***/
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+func (h *Handlers) runOnceInRoom(w http.ResponseWriter, r *http.Request, req models.RunRequest) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", nil)
+		return
+	}
+	roomInfo, err := h.roomManager.ValidateRoomAccess(token)
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", err)
+		return
+	}
+	if roomInfo.MatchId != req.MatchID {
+		h.writeError(w, r, http.StatusForbidden, "token_room_mismatch", nil)
+		return
+	}
+
+	room := h.hub.GetOrCreate(req.MatchID)
+	if roomInfo.Question != nil {
+		room.SetExecutionConfig(roomInfo.Question.Execution)
+	}
+	if !languageAllowed(room.ExecutionConfig(), req.Language) {
+		h.writeError(w, r, http.StatusBadRequest, "language_not_allowed", nil)
+		return
+	}
 
+	userID := participantID(roomInfo, token)
+	seq, err := room.StartRun(userID)
+	switch {
+	case errors.Is(err, session.ErrRunInProgress):
+		h.writeError(w, r, http.StatusConflict, errorCode(err), nil)
+		return
+	case errors.Is(err, session.ErrRunRateLimited), errors.Is(err, session.ErrRunCooldown):
+		h.writeError(w, r, http.StatusTooManyRequests, errorCode(err), nil)
+		return
+	}
+	room.RecordActivity("run", userID, string(req.Language))
+
+	run := models.RunCmd{Language: req.Language, Code: req.Code, Stdin: req.Stdin, Args: req.Args, Env: req.Env}
+	frames, runErr := h.runInRoom(room, run)
+	// The HTTP caller has its result; the enrichment still reaches the room
+	go func() {
+		defer room.EndRun(seq)
+		h.enrichRun(room, run, frames)
+	}()
+
+	if len(frames) == 0 && runErr != nil {
+		if errors.Is(runErr, exec.ErrDockerUnavailable) {
+			h.writeError(w, r, http.StatusServiceUnavailable, "sandbox_unavailable", runErr)
+		} else {
+			h.writeError(w, r, http.StatusInternalServerError, "run_failed", runErr)
+		}
+		return
+	}
+	writeJSON(w, runResultFromFrames(frames))
+}
+
+// runResultFromFrames folds streamed run frames into the aggregate RunResult
+func runResultFromFrames(frames []models.WSFrame) models.RunResult {
+	var stdout, stderr strings.Builder
+	var result models.RunResult
+	for _, frame := range frames {
+		switch frame.Type {
+		case "stdout":
+			msg, _ := frame.Data.(string)
+			stdout.WriteString(msg)
+		case "stderr", "error":
+			msg, _ := frame.Data.(string)
+			stderr.WriteString(msg)
+		case "usage":
+			if usage, ok := frame.Data.(models.RunUsage); ok {
+				result.Usage = &usage
+			}
+		case "compile_error":
+			result.CompileOutput, _ = frame.Data.(string)
+		case "truncated":
+			result.OutputTruncated = true
+		case "exit":
+			data, _ := frame.Data.(map[string]any)
+			result.Exit, _ = data["code"].(int)
+			result.TimedOut, _ = data["timedOut"].(bool)
+			result.Cancelled, _ = data["cancelled"].(bool)
+			result.OOMKilled, _ = data["oomKilled"].(bool)
+			result.Args, _ = data["args"].([]string)
+			result.Env, _ = data["env"].(map[string]string)
+		}
+	}
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	return result
+}
+
+/*** Collab WebSocket: shared editor + run streaming ***/
 func (h *Handlers) CollabWS(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "id")
 
@@ -291,174 +642,709 @@ func (h *Handlers) CollabWS(w http.ResponseWriter, r *http.Request) {
 	// Extract token from query parameter
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		http.Error(w, "Token required", http.StatusUnauthorized)
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", nil)
 		return
 	}
 
 	// Validate token and get room info
-	roomInfo, err := h.roomManager.ValidateRoomAccess(token)
+	roomInfo, userID, spectator, err := h.collabAccess(token)
 	if err != nil {
-		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", err)
 		return
 	}
 
 	// Verify the session ID matches the room
 	if roomInfo.MatchId != sessionID {
-		http.Error(w, "Invalid room", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, "invalid_room", nil)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := h.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(h.wsReadLimit)
 
 	client := session.NewClient(conn)
+	client.UserID = userID
+	client.Locale = requestLocale(r)
+	client.SetCompressionThreshold(h.wsCompressMin)
+	alive, stopPings := h.keepAlive(conn, client)
+	defer stopPings()
 	room := h.hub.GetOrCreate(sessionID)
-	if room.GetClientCount() >= 2 {
-		_ = conn.WriteJSON(models.WSFrame{
-			Type: "error",
-			Data: "room_full",
-		})
-		return
-	}
+	// A connection a proxy dropped silently must not keep the real user out
+	room.PruneStale()
+	if spectator {
+		if err := room.JoinSpectator(client); err != nil {
+			h.sendError(client, errorCode(err), err)
+			return
+		}
+	} else {
+		if room.GetClientCount() >= 2 {
+			h.sendError(client, "room_full", nil)
+			return
+		}
 
-	// Set up session end handler for this room (only once)
-	if room.GetClientCount() == 0 {
-		room.SetSessionEndHandler(func(sessID string, finalCode string, lang models.Language, duration time.Duration) {
-			h.handleSessionEnd(sessID, finalCode, lang, duration)
-		})
-	}
+		// Set up session end handler for this room (only once)
+		if room.GetClientCount() == 0 {
+			room.SetSessionEndHandler(func(sessID string, finalCode string, lang models.Language, duration time.Duration) {
+				h.handleSessionEnd(sessID, finalCode, lang, duration)
+			})
+			room.SetAbandonHandler(func(userID string) { h.abandonSession(room, userID) })
+			h.persistTurns(room)
+		}
 
-	room.Join(client)
+		room.Join(client)
+		h.publishOccupancy(room)
+	}
+	h.restoreChat(room)
+	h.restoreSettings(room)
+	h.restoreTurns(room)
+	h.restoreThreads(room)
 	defer func() {
 		room.Leave(client)
+		if !spectator {
+			h.publishOccupancy(room)
+		}
 	}()
+	if roomInfo.Question != nil {
+		room.SetExecutionConfig(roomInfo.Question.Execution)
+		room.SetStarterCode(roomInfo.Question.StarterCode)
+		room.SetRubric(roomInfo.Question.Rubric)
+		h.restoreRubric(room)
+	}
 
-	_, msg, err := conn.ReadMessage()
+	msg, err := readFrame(conn, h.wsReadLimit)
 	if err != nil {
 		return
 	}
+	alive()
+	// A reconnecting client sends "resume" in place of "init"
 	var init models.WSFrame
-	if err := json.Unmarshal(msg, &init); err != nil || init.Type != "init" {
-		_ = conn.WriteJSON(errFrame("expected init"))
+	if err := json.Unmarshal(msg, &init); err != nil || (init.Type != "init" && init.Type != "resume") {
+		h.sendError(client, "expected_init", err)
 		return
 	}
 	var initReq models.InitRequest
 	b, _ := json.Marshal(init.Data)
 	_ = json.Unmarshal(b, &initReq)
+	protocolVersion, caps, granted := session.NegotiateCapabilities(initReq.ProtocolVersion, initReq.Capabilities)
+	client.SetCapabilities(caps)
 
-	// Set preferred language for the room (optional)
-	if initReq.Language != "" {
+	if !spectator {
+		room.ConfigureRoles(initReq.FreeForAll)
+	}
+
+	// Set preferred language for the room (optional); the question may restrict the choice
+	execCfg := room.ExecutionConfig()
+	if !spectator && initReq.Language != "" && languageAllowed(execCfg, initReq.Language) {
 		room.SetLanguage(initReq.Language)
 	}
-	doc, lang := room.Snapshot()
-	if doc.Text == "" {
-		spec, _, _, _, specErr := h.runner.LangSpecPublic(lang)
-		if specErr == nil && spec.ExampleTemplate != "" {
-			doc = room.BootstrapDoc(spec.ExampleTemplate)
+	if _, current := room.Snapshot(); !languageAllowed(execCfg, current) {
+		if allowed := allowedLanguages(execCfg); len(allowed) > 0 {
+			room.SetLanguage(allowed[0])
 		}
 	}
-	_ = conn.WriteJSON(models.WSFrame{
-		Type: "init",
+	doc, lang := room.Snapshot()
+	if doc.Text == "" && !spectator {
+		doc = room.BootstrapDoc(h.starterTemplate(room, lang))
+	}
+	client.Send(models.WSFrame{
+		Type: init.Type,
 		Data: models.InitResponse{
-			SessionID: sessionID,
-			Doc:       doc,
-			Language:  lang,
+			SessionID:        sessionID,
+			Doc:              doc,
+			Language:         lang,
+			AllowedLanguages: allowedLanguages(execCfg),
+			Limits:           constraintsFor(execCfg).Limits,
+			Settings:         room.Settings(),
+			Presence:         room.Presence(client.UserID),
+			Rubric:           room.Rubric(),
+			Turns:            room.TurnState(),
+			Roles:            room.Roles(),
+			Threads:          room.Threads(),
+			Spectator:        spectator,
+			Color:            room.Color(client.UserID),
+			Cursors:          room.Cursors(client.UserID),
+			ProtocolVersion:  protocolVersion,
+			Capabilities:     granted,
 		},
 	})
 
-	room.ReplayRunHistory(client)
+	if resume := initReq.Resume; init.Type == "resume" && resume != nil {
+		h.resumeClient(room, client, *resume)
+	} else {
+		room.ReplayRunHistory(client)
+		if chat := room.ChatState(); len(chat.Messages) > 0 {
+			client.Send(models.WSFrame{Type: "chat_history", Data: chat})
+		}
+	}
+	drafts := &draftAutosave{}
+	if !spectator {
+		drafts = h.startDraftAutosave(room, client)
+	}
+	defer drafts.stop()
 
 	// Event loop
 	for {
+		data, err := readFrame(conn, h.wsReadLimit)
+		if err != nil {
+			return
+		}
+		metrics.ObserveFrameSize(len(data))
 		var frame models.WSFrame
-		if err := conn.ReadJSON(&frame); err != nil {
+		if err := json.Unmarshal(data, &frame); err != nil {
 			return
 		}
+		alive()
+		if spectator && readOnlyFrames[frame.Type] {
+			h.sendError(client, "read_only", nil)
+			if frame.Type == "edit" {
+				// Undo the edit the client applied locally
+				doc, _ := room.Snapshot()
+				client.SendDoc(nil, doc)
+			}
+			continue
+		}
 
 		switch frame.Type {
 		case "edit":
 			var e models.Edit
-			marshal(frame.Data, &e)
-			ok, newDoc, applyErr := room.ApplyEdit(e)
+			if err := marshal(frame.Data, &e); err != nil {
+				h.sendError(client, "invalid_payload", err)
+				doc, _ := room.Snapshot()
+				client.SendDoc(nil, doc)
+				continue
+			}
+			if !room.AllowEdit(client) {
+				// Dropped like a rejected edit, so the client resyncs
+				h.sendError(client, "rate_limited", nil)
+				doc, _ := room.Snapshot()
+				client.SendDoc(nil, doc)
+				continue
+			}
+			if err := room.CheckTurn(client.UserID); err != nil {
+				// Undo the edit the client applied locally
+				h.sendError(client, errorCode(err), err)
+				doc, _ := room.Snapshot()
+				client.SendDoc(nil, doc)
+				continue
+			}
+			if err := room.CheckDriver(client.UserID); err != nil {
+				h.sendError(client, errorCode(err), err)
+				doc, _ := room.Snapshot()
+				client.SendDoc(nil, doc)
+				continue
+			}
+			ok, prevDoc, newDoc, applyErr := room.ApplyEditFrom(client.UserID, e)
 			if !ok {
 				errType := mapOTError(applyErr)
-				_ = conn.WriteJSON(models.WSFrame{Type: "error", Data: errType})
-				_ = conn.WriteJSON(models.WSFrame{Type: "doc", Data: newDoc})
+				metrics.RecordOTError(errType)
+				client.Send(h.errorFrame(client.Locale, errType, applyErr))
+				client.SendDoc(nil, newDoc)
 				continue
 			}
+			room.RecordActivity("edit", client.UserID, "")
+			drafts.touch(nil)
 			// broadcast updated authoritative doc to all peers
-			room.Broadcast(client, models.WSFrame{Type: "doc", Data: newDoc})
+			room.BroadcastDoc(client, &prevDoc, newDoc)
 			// echo doc back to sender (ack)
-			_ = conn.WriteJSON(models.WSFrame{Type: "doc", Data: newDoc})
+			client.SendDoc(&prevDoc, newDoc)
+
+		case "undo":
+			h.handleUndo(room, client, false)
+
+		case "redo":
+			h.handleUndo(room, client, true)
+
+		case "resync":
+			var req models.Resync
+			if !h.decodePayload(client, frame.Data, &req) {
+				continue
+			}
+			h.handleResync(room, client, req)
 
 		case "cursor":
 			var c models.Cursor
-			marshal(frame.Data, &c)
+			if !h.decodePayload(client, frame.Data, &c) {
+				continue
+			}
+			c, err := room.UpdateCursor(client.UserID, c)
+			if err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+			drafts.touch(&c.Pos)
+			room.Touch()
 			room.Broadcast(client, models.WSFrame{Type: "cursor", Data: c})
 
 		case "chat":
 			var ch models.Chat
-			marshal(frame.Data, &ch)
-			room.Broadcast(client, models.WSFrame{Type: "chat", Data: ch})
+			if !h.decodePayload(client, frame.Data, &ch) {
+				continue
+			}
+			if ch.Message == "" {
+				continue
+			}
+			// Messages are attributed to the token's user, whatever the payload says
+			if client.UserID == "" {
+				h.sendError(client, "unknown_user", nil)
+				continue
+			}
+			msg := room.AddChatMessage(client.UserID, ch.Message)
+			room.RecordActivity("chat", client.UserID, "")
+			h.persistChat(room)
+			room.Broadcast(client, models.WSFrame{Type: "chat", Data: msg})
+			client.Send(models.WSFrame{Type: "chat_ack", Data: msg})
+
+		case "chat_reaction":
+			var reaction models.ChatReaction
+			if !h.decodePayload(client, frame.Data, &reaction) {
+				continue
+			}
+			if client.UserID == "" {
+				h.sendError(client, "unknown_user", nil)
+				continue
+			}
+			reactions, err := room.ReactToChat(client.UserID, reaction)
+			if err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+			h.persistChat(room)
+			room.BroadcastAll(models.WSFrame{
+				Type: "chat_reaction",
+				Data: models.ChatReactionUpdate{MessageID: reaction.MessageID, Reactions: reactions},
+			})
+
+		case "chat_read":
+			var read models.ChatRead
+			if !h.decodePayload(client, frame.Data, &read) {
+				continue
+			}
+			if client.UserID == "" {
+				h.sendError(client, "unknown_user", nil)
+				continue
+			}
+			mark, changed, err := room.MarkChatRead(client.UserID, read.UpToMessageID)
+			if err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			h.persistChat(room)
+			room.BroadcastAll(models.WSFrame{
+				Type: "chat_read_update",
+				Data: models.ChatReadUpdate{UserID: client.UserID, UpToMessageID: mark},
+			})
+
+		case "settings_update":
+			var changes map[string]any
+			if !h.decodePayload(client, frame.Data, &changes) {
+				continue
+			}
+			h.handleSettingsUpdate(room, client, changes)
+
+		case "rubric_check":
+			var check models.RubricCheck
+			if !h.decodePayload(client, frame.Data, &check) {
+				continue
+			}
+			h.handleRubricCheck(room, client, check)
+
+		case "thread_create":
+			var create models.ThreadCreate
+			if !h.decodePayload(client, frame.Data, &create) {
+				continue
+			}
+			h.handleThreadCreate(room, client, create)
+
+		case "thread_reply":
+			var reply models.ThreadReplyCmd
+			if !h.decodePayload(client, frame.Data, &reply) {
+				continue
+			}
+			h.handleThreadReply(room, client, reply)
+
+		case "thread_resolve":
+			var resolve models.ThreadResolve
+			if !h.decodePayload(client, frame.Data, &resolve) {
+				continue
+			}
+			h.handleThreadResolve(room, client, resolve)
+
+		case "mode_set":
+			var set models.ModeSet
+			if !h.decodePayload(client, frame.Data, &set) {
+				continue
+			}
+			h.handleModeSet(room, client, set)
+
+		case "mode_confirm":
+			var confirm models.ModeConfirm
+			if !h.decodePayload(client, frame.Data, &confirm) {
+				continue
+			}
+			h.handleModeConfirm(room, client, confirm)
+
+		case "request_control":
+			h.handleRequestControl(room, client)
+
+		case "grant_control":
+			h.handleGrantControl(room, client)
+
+		case "turn_pass":
+			h.handleTurnPass(room, client)
+
+		case "draft_save":
+			var save models.DraftSave
+			if !h.decodePayload(client, frame.Data, &save) {
+				continue
+			}
+			h.handleDraftSave(room, client, drafts, save)
+
+		case "draft_restore":
+			h.handleDraftRestore(room, client)
+
+		case "draft_restore_confirm":
+			var confirm models.DraftRestoreConfirm
+			if !h.decodePayload(client, frame.Data, &confirm) {
+				continue
+			}
+			h.handleDraftRestoreConfirm(room, client, confirm)
 
 		case "language":
 			var langChange models.LanguageChange
-			marshal(frame.Data, &langChange)
+			if !h.decodePayload(client, frame.Data, &langChange) {
+				continue
+			}
 			if langChange.Language == "" {
 				continue
 			}
+			if !languageAllowed(room.ExecutionConfig(), langChange.Language) {
+				h.sendError(client, "language_not_allowed", nil)
+				continue
+			}
 			room.SetLanguage(langChange.Language)
+			room.RecordActivity("language", client.UserID, string(langChange.Language))
 			room.Broadcast(client, models.WSFrame{Type: "language", Data: langChange.Language})
-			_ = conn.WriteJSON(models.WSFrame{Type: "language", Data: langChange.Language})
+			client.Send(models.WSFrame{Type: "language", Data: langChange.Language})
+			// Nobody has written code yet, so the room starts over from the
+			// new language's template
+			if prev, doc, swapped := room.SwapTemplate(h.starterTemplate(room, langChange.Language)); swapped {
+				room.BroadcastDoc(client, &prev, doc)
+				client.SendDoc(&prev, doc)
+			}
 
 		case "run":
 			var run models.RunCmd
-			marshal(frame.Data, &run)
-			room.BeginRun()
-			go h.runInSandbox(room, run)
+			if !h.decodePayload(client, frame.Data, &run) {
+				continue
+			}
+			if !languageAllowed(room.ExecutionConfig(), run.Language) {
+				h.sendError(client, "language_not_allowed", nil)
+				continue
+			}
+			if err := room.CheckTurn(client.UserID); err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+			if details := validateInvocation(run.Args, run.Env, run.Stdin); len(details) > 0 {
+				frame := h.errorFrame(client.Locale, "invalid_invocation", nil)
+				frame.Data = models.InvalidInvocation{Error: "invalid_invocation", Details: details}
+				client.Send(frame)
+				continue
+			}
+			// A run asked for while one is going waits for it, in place of
+			// any run that was already waiting
+			_, err := room.QueueRun(client.UserID, func() {
+				room.RecordActivity("run", client.UserID, string(run.Language))
+				h.runInSandbox(room, run)
+			}, func(err error) {
+				h.sendError(client, errorCode(err), err)
+			})
+			if err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+
+		case "restore_snapshot":
+			var req models.SnapshotRestore
+			if !h.decodePayload(client, frame.Data, &req) {
+				continue
+			}
+			h.handleSnapshotRestore(room, client, req)
+
+		case "hint_request":
+			h.handleHintRequest(room, client)
+
+		case "lint_request":
+			h.handleLintRequest(room, client)
+
+		case "run_tests":
+			var cmd models.RunTestsCmd
+			if !h.decodePayload(client, frame.Data, &cmd) {
+				continue
+			}
+			h.handleRunTests(room, client, cmd)
+
+		case "typing":
+			// A malformed indicator is not worth an error frame; drop it
+			if active, ok := typingActive(frame.Data); ok {
+				room.Touch()
+				room.SetTyping(client, active)
+			}
+
+		case "interactive_run":
+			var run models.RunCmd
+			if !h.decodePayload(client, frame.Data, &run) {
+				continue
+			}
+			if err := room.CheckTurn(client.UserID); err != nil {
+				h.sendError(client, errorCode(err), err)
+				continue
+			}
+			room.RecordActivity("interactive_run", client.UserID, string(run.Language))
+			h.handleInteractiveRun(room, client, run)
+
+		case "interactive_stdin":
+			var in models.InteractiveStdin
+			if !h.decodePayload(client, frame.Data, &in) {
+				continue
+			}
+			h.handleInteractiveStdin(room, client, in)
+
+		case "interactive_kill":
+			h.handleInteractiveKill(room, client)
+
+		case "cancel_run":
+			h.handleCancelRun(room, client)
 
 		case "end_session":
-			if err := h.roomManager.MarkRoomAsEnded(sessionID); err != nil {
-				h.log.Error("Failed to mark room as ended", "sessionID", sessionID, "error", err.Error())
+			if h.handleEndSession(room, client) {
+				return
 			}
-			room.BroadcastAll(models.WSFrame{Type: "session_ended", Data: map[string]string{"reason": "partner_left"}})
-			room.EndSessionNow()
-			return
+
+		case "end_session_confirm":
+			if h.handleEndSessionConfirm(room, client) {
+				return
+			}
+
+		case "reroll_vote":
+			var vote models.RerollVote
+			if !h.decodePayload(client, frame.Data, &vote) {
+				continue
+			}
+			h.handleRerollVote(room, client, vote)
+
+		case "end_session_cancel":
+			h.handleEndSessionCancel(room, client)
 
 		default:
-			_ = conn.WriteJSON(errFrame("unknown_type"))
+			h.sendError(client, "unknown_type", nil)
 		}
 	}
 }
 
 func (h *Handlers) runInSandbox(room *session.Room, run models.RunCmd) {
-	limits := exec.SandboxLimits{
-		WallTime: 10 * time.Second,
-		MemoryB:  512 * 1024 * 1024,
-		NanoCPUs: 1_000_000_000,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
-	defer cancel()
+	frames, _ := h.runInRoom(room, run)
+	h.enrichRun(room, run, frames)
+}
 
-	frames, runErr := h.runner.RunStream(ctx, run.Language, run.Code, limits)
+// runInRoom executes run and records its output in the room's run history as
+// the sandbox produces it, so connected clients see it live and late joiners
+// replay it.
+func (h *Handlers) runInRoom(room *session.Room, run models.RunCmd) ([]models.WSFrame, error) {
+	cfg := room.ExecutionConfig()
+	limits := withInvocation(runLimitsFor(cfg), cfg, run.Args, run.Env, run.Stdin)
+	ctx, cancel := context.WithTimeout(context.Background(), limits.WallTime+2*time.Second)
+	defer cancel()
+	h.batch.start(room.ID, cancel)
+	defer h.batch.finish(room.ID)
+
+	metrics.RecordRunStarted(string(run.Language))
+	var frames []models.WSFrame
+	runErr := h.runner.RunStreamFn(ctx, run.Language, run.Code, limits, func(frame models.WSFrame) {
+		if frame.Type == "error" {
+			// The sandbox reports error codes; anything else stays in the logs
+			code, _ := frame.Data.(string)
+			if !i18n.Known(code) {
+				code = "run_failed"
+			}
+			frame = h.errorFrame(i18n.DefaultLocale, code, nil)
+		}
+		frames = append(frames, frame)
+		room.RecordRunFrame(frame)
+	})
+	if runErr != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// Abandoning the request has the sandbox kill the program, and its
+		// exit with it, so the cancelled exit is recorded here
+		metrics.RecordRun(string(run.Language), metrics.RunCancelled)
+		frame := models.WSFrame{Type: "exit", Data: map[string]any{"code": -1, "timedOut": false, "cancelled": true}}
+		room.RecordRunFrame(frame)
+		return append(frames, frame), nil
+	}
+	metrics.RecordRun(string(run.Language), runStreamOutcome(frames, runErr))
 	if runErr != nil && !errors.Is(runErr, exec.ErrDockerUnavailable) {
 		h.log.Error("sandbox run failed", "language", run.Language, "error", runErr.Error())
 	}
 	if len(frames) == 0 && runErr != nil {
-		room.RecordRunFrame(models.WSFrame{Type: "error", Data: runErr.Error()})
+		// Run history is shared by both participants, so it is kept in the default locale
+		code := "run_failed"
+		if errors.Is(runErr, exec.ErrDockerUnavailable) {
+			code = "sandbox_unavailable"
+		}
+		room.RecordRunFrame(h.errorFrame(i18n.DefaultLocale, code, runErr))
+		return nil, runErr
+	}
+	return frames, runErr
+}
+
+// enrichRun appends the optional complexity verdict after a successful run.
+func (h *Handlers) enrichRun(room *session.Room, run models.RunCmd, frames []models.WSFrame) {
+	if h.analyzer != nil && runSucceeded(frames) {
+		h.recordComplexity(room, run)
+	}
+}
+
+// complexityTimeout bounds the optional AI enrichment after a successful run.
+const complexityTimeout = 30 * time.Second
+
+// recordComplexity appends the AI complexity verdict to the run output. It is
+// best effort: failures are logged and the run result stands on its own.
+func (h *Handlers) recordComplexity(room *session.Room, run models.RunCmd) {
+	var question *models.Question
+	if roomInfo, err := h.roomManager.GetRoomStatus(room.ID); err == nil && roomInfo != nil {
+		question = roomInfo.Question
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), complexityTimeout)
+	defer cancel()
+
+	verdict, err := h.analyzer.Analyze(ctx, run.Language, run.Code, question)
+	if err != nil {
+		h.log.Error("complexity analysis failed", "roomId", room.ID, "error", err.Error())
 		return
 	}
+	if verdict == nil {
+		return
+	}
+	room.RecordRunFrame(models.WSFrame{Type: "complexity", Data: verdict})
+}
+
+func runSucceeded(frames []models.WSFrame) bool {
 	for _, frame := range frames {
-		room.RecordRunFrame(frame)
+		if frame.Type != "exit" {
+			continue
+		}
+		data, ok := frame.Data.(map[string]any)
+		if !ok {
+			return false
+		}
+		code, _ := data["code"].(int)
+		timedOut, _ := data["timedOut"].(bool)
+		return code == 0 && !timedOut
 	}
+	return false
 }
 
-func marshal(in any, out any) { b, _ := json.Marshal(in); _ = json.Unmarshal(b, out) }
+func runOnceOutcome(out exec.RunOutput, err error) string {
+	switch {
+	case errors.Is(err, exec.ErrDockerUnavailable):
+		return metrics.RunUnavailable
+	case err != nil:
+		return metrics.RunError
+	case out.TimedOut:
+		return metrics.RunTimeout
+	case out.Exit != 0:
+		return metrics.RunError
+	}
+	return metrics.RunSuccess
+}
+
+func runStreamOutcome(frames []models.WSFrame, err error) string {
+	if errors.Is(err, exec.ErrDockerUnavailable) {
+		return metrics.RunUnavailable
+	}
+	for _, frame := range frames {
+		if frame.Type != "exit" {
+			continue
+		}
+		data, _ := frame.Data.(map[string]any)
+		if timedOut, _ := data["timedOut"].(bool); timedOut {
+			return metrics.RunTimeout
+		}
+	}
+	if err == nil && runSucceeded(frames) {
+		return metrics.RunSuccess
+	}
+	return metrics.RunError
+}
+
+// participantID resolves which room participant a room token belongs to.
+func participantID(roomInfo *models.RoomInfo, token string) string {
+	switch {
+	case roomInfo.Token1 != "" && token == roomInfo.Token1:
+		return roomInfo.User1
+	case roomInfo.Token2 != "" && token == roomInfo.Token2:
+		return roomInfo.User2
+	}
+	return ""
+}
+
+// restoreChat seeds a freshly created room with its persisted chat.
+func (h *Handlers) restoreChat(room *session.Room) {
+	state, err := h.roomManager.LoadChatState(room.ID)
+	if err != nil {
+		h.log.Error("Failed to load chat state", "roomId", room.ID, "error", err.Error())
+		return
+	}
+	if state != nil {
+		room.RestoreChatState(*state)
+	}
+}
+
+func (h *Handlers) persistChat(room *session.Room) {
+	if err := h.roomManager.SaveChatState(room.ID, room.ChatState()); err != nil {
+		h.log.Error("Failed to persist chat state", "roomId", room.ID, "error", err.Error())
+	}
+}
 
-func errFrame(msg string) models.WSFrame { return models.WSFrame{Type: "error", Data: msg} }
+// typingActive reads the flag of a typing frame; ok is false unless the
+// payload is an object with a boolean active.
+func typingActive(data any) (active, ok bool) {
+	var typing struct {
+		Active *bool `json:"active"`
+	}
+	b, err := json.Marshal(data)
+	if err != nil || json.Unmarshal(b, &typing) != nil || typing.Active == nil {
+		return false, false
+	}
+	return *typing.Active, true
+}
+
+// marshal decodes a frame's data, already decoded as JSON, into out
+func marshal(in any, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// decodePayload decodes the data of a client's frame into out, answering
+// invalid_payload when it does not have the shape of out; the frame must then
+// be dropped rather than acted on or relayed.
+func (h *Handlers) decodePayload(client *session.Client, data any, out any) bool {
+	if err := marshal(data, out); err != nil {
+		h.sendError(client, "invalid_payload", err)
+		return false
+	}
+	return true
+}
 
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -486,6 +1372,12 @@ func mapOTError(err error) string {
 
 func (h *Handlers) handleSessionEnd(sessionID string, finalCode string, lang models.Language, duration time.Duration) {
 	h.log.Info("Session ended", "sessionID", sessionID, "duration", duration.Seconds())
+	// Drop the room even if reporting the session fails below
+	defer func() {
+		h.stopInteractive(sessionID)
+		h.hub.Delete(sessionID)
+		h.log.Info("Cleaned up room from hub", "sessionID", sessionID)
+	}()
 
 	if err := h.roomManager.MarkRoomAsEnded(sessionID); err != nil {
 		h.log.Error("Failed to mark room as ended", "sessionID", sessionID, "error", err.Error())
@@ -519,10 +1411,28 @@ func (h *Handlers) handleSessionEnd(sessionID string, finalCode string, lang mod
 		event.QuestionTitle = roomInfo.Question.Title
 	}
 
+	outcome := models.OutcomeAbandoned
+	// The handler runs without the room lock held, see Room.EndSessionNow
+	if room, ok := h.hub.Get(sessionID); ok {
+		if endedByParticipants(room) {
+			outcome = models.OutcomeCompleted
+		}
+		event.RubricResults = room.RubricResults()
+		event.Threads = room.ThreadSummary()
+		for user, d := range room.DrivingTime() {
+			if event.DrivingSec == nil {
+				event.DrivingSec = make(map[string]int)
+			}
+			event.DrivingSec[user] = int(d.Seconds())
+		}
+	}
+
 	if err := h.roomManager.PublishSessionEnded(event); err != nil {
 		h.log.Error("Failed to publish session ended event", "sessionID", sessionID, "error", err.Error())
 	}
-
-	h.hub.Delete(sessionID)
-	h.log.Info("Cleaned up room from hub", "sessionID", sessionID)
+	if err := h.roomManager.ReportSessionResult(roomInfo, outcome); err != nil {
+		h.log.Error("Failed to report session result", "sessionID", sessionID, "error", err.Error())
+	}
+	h.saveSummary(event, roomInfo)
+	h.recordUserSessions(event)
 }
@@ -2,21 +2,33 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Jeffail/leaps/lib/text"
+	miniredis "github.com/alicebob/miniredis/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 
+	"collab/internal/analysis"
 	"collab/internal/exec"
+	"collab/internal/i18n"
+	"collab/internal/metrics"
 	"collab/internal/models"
 	"collab/internal/room_management"
 	"collab/internal/session"
@@ -27,6 +39,10 @@ type mockRunner struct {
 	langSpecFn  func(models.Language) (models.LanguageSpec, string, string, [][]string, error)
 	runOnceFn   func(context.Context, models.Language, string, exec.SandboxLimits) (exec.RunOutput, error)
 	runStreamFn func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error)
+	// runEmitFn, when set, streams frames itself instead of runStreamFn
+	// returning them all at the end
+	runEmitFn func(context.Context, models.Language, string, exec.SandboxLimits, func(models.WSFrame)) error
+	startFn   func(context.Context, models.Language, string, exec.SandboxLimits) (exec.InteractiveSession, error)
 }
 
 func (m *mockRunner) LangSpecPublic(lang models.Language) (models.LanguageSpec, string, string, [][]string, error) {
@@ -43,20 +59,88 @@ func (m *mockRunner) RunOnce(ctx context.Context, lang models.Language, code str
 	return exec.RunOutput{}, nil
 }
 
-func (m *mockRunner) RunStream(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) ([]models.WSFrame, error) {
-	if m.runStreamFn != nil {
-		return m.runStreamFn(ctx, lang, code, limits)
+func (m *mockRunner) RunStreamFn(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits, emit func(models.WSFrame)) error {
+	if m.runEmitFn != nil {
+		return m.runEmitFn(ctx, lang, code, limits, emit)
 	}
-	return nil, nil
+	if m.runStreamFn == nil {
+		return nil
+	}
+	frames, err := m.runStreamFn(ctx, lang, code, limits)
+	for _, frame := range frames {
+		emit(frame)
+	}
+	return err
+}
+
+func (m *mockRunner) StartInteractive(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.InteractiveSession, error) {
+	if m.startFn != nil {
+		return m.startFn(ctx, lang, code, limits)
+	}
+	return nil, exec.ErrDockerUnavailable
 }
 
 type mockRoomManager struct {
 	validateFn func(string) (*models.RoomInfo, error)
+	spectateFn func(string) (*models.RoomInfo, string, error)
 	getFn      func(string) (*models.RoomInfo, error)
 	rerollFn   func(string) (*models.RoomInfo, error)
+	retryFn    func(string) (*models.RoomInfo, error)
+	readyErr   error // returned by Ready
 	cb         func(string, *models.RoomInfo)
+	chatMu     sync.Mutex
+	chat       map[string]models.ChatState
+	drafts     map[string]models.Draft
+	settings   map[string]models.RoomSettings
+	rubrics    map[string][]models.RubricItem
+	turns      map[string]models.TurnState
+	threads    map[string]models.ThreadState
+	docs       map[string]models.DocSnapshot
+	snapshots  map[string][]models.Snapshot
+	snapErr    error // optional, returned by ListSnapshots
+	summaries  map[string]models.SessionSummary
+	sessions   map[string][]models.SessionRecord // per user, newest first
+	docSaved   chan models.DocSnapshot           // optional, notified on every SaveDoc
+	draftSaved chan models.Draft                 // optional, notified on every SaveDraft
+	published  chan models.SessionEndedEvent     // optional, notified on every PublishSessionEnded
+	ended      chan string                       // optional, notified on every MarkRoomAsEnded
+	reported   chan string                       // optional, notified with the outcome of every ReportSessionResult
+	occupancy  map[string][]string               // published connected users per room
+	occupied   occupancyStore                    // optional, backs SetOccupancy and RemoteOccupancy in place of occupancy
+	occErr     error                             // optional, returned by RemoteOccupancy
+}
+
+type occupancyStore interface {
+	SetOccupancy(matchID string, userIDs []string) error
+	RemoteOccupancy(matchID string) ([]string, error)
+}
+
+func (m *mockRoomManager) SetOccupancy(matchID string, userIDs []string) error {
+	if m.occupied != nil {
+		return m.occupied.SetOccupancy(matchID, userIDs)
+	}
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.occupancy == nil {
+		m.occupancy = make(map[string][]string)
+	}
+	m.occupancy[matchID] = userIDs
+	return nil
+}
+
+// RemoteOccupancy sees no other instance unless occupied stands in for Redis
+func (m *mockRoomManager) RemoteOccupancy(matchID string) ([]string, error) {
+	if m.occErr != nil {
+		return nil, m.occErr
+	}
+	if m.occupied != nil {
+		return m.occupied.RemoteOccupancy(matchID)
+	}
+	return nil, nil
 }
 
+func (m *mockRoomManager) Ready(context.Context) error { return m.readyErr }
+
 func (m *mockRoomManager) ValidateRoomAccess(token string) (*models.RoomInfo, error) {
 	if m.validateFn != nil {
 		return m.validateFn(token)
@@ -64,6 +148,13 @@ func (m *mockRoomManager) ValidateRoomAccess(token string) (*models.RoomInfo, er
 	return nil, errors.New("not implemented")
 }
 
+func (m *mockRoomManager) ValidateSpectatorAccess(token string) (*models.RoomInfo, string, error) {
+	if m.spectateFn != nil {
+		return m.spectateFn(token)
+	}
+	return nil, "", errors.New("not implemented")
+}
+
 func (m *mockRoomManager) GetRoomStatus(id string) (*models.RoomInfo, error) {
 	if m.getFn != nil {
 		return m.getFn(id)
@@ -83,18 +174,236 @@ func (m *mockRoomManager) RerollQuestion(id string) (*models.RoomInfo, error) {
 	return nil, errors.New("not implemented")
 }
 
+func (m *mockRoomManager) RetryQuestion(id string) (*models.RoomInfo, error) {
+	if m.retryFn != nil {
+		return m.retryFn(id)
+	}
+	return nil, errors.New("not implemented")
+}
+
 func (m *mockRoomManager) GetActiveRoomForUser(userId string) (*models.RoomInfo, error) {
 	return nil, errors.New("not implemented")
 }
 
 func (m *mockRoomManager) PublishSessionEnded(event models.SessionEndedEvent) error {
+	if m.published != nil {
+		m.published <- event
+	}
+	return nil
+}
+
+func (m *mockRoomManager) ReportSessionResult(roomInfo *models.RoomInfo, outcome string) error {
+	if m.reported != nil {
+		m.reported <- outcome
+	}
+	return nil
+}
+
+func (m *mockRoomManager) SaveSessionSummary(summary models.SessionSummary) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.summaries == nil {
+		m.summaries = make(map[string]models.SessionSummary)
+	}
+	m.summaries[summary.MatchID] = summary
+	return nil
+}
+
+func (m *mockRoomManager) LoadSessionSummary(matchID string) (*models.SessionSummary, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	summary, ok := m.summaries[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &summary, nil
+}
+
+func (m *mockRoomManager) RecordUserSession(userID string, record models.SessionRecord) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[string][]models.SessionRecord)
+	}
+	m.sessions[userID] = append([]models.SessionRecord{record}, m.sessions[userID]...)
 	return nil
 }
 
+func (m *mockRoomManager) RecentSessions(userID string, limit int) ([]models.SessionRecord, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	records := m.sessions[userID]
+	return append([]models.SessionRecord{}, records[:min(limit, len(records))]...), nil
+}
+
 func (m *mockRoomManager) MarkRoomAsEnded(matchID string) error {
+	if m.ended != nil {
+		m.ended <- matchID
+	}
+	return nil
+}
+
+func (m *mockRoomManager) SaveChatState(matchID string, state models.ChatState) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.chat == nil {
+		m.chat = make(map[string]models.ChatState)
+	}
+	m.chat[matchID] = state
+	return nil
+}
+
+func (m *mockRoomManager) LoadChatState(matchID string) (*models.ChatState, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	state, ok := m.chat[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (m *mockRoomManager) SaveRoomSettings(matchID string, settings models.RoomSettings) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.settings == nil {
+		m.settings = make(map[string]models.RoomSettings)
+	}
+	m.settings[matchID] = settings
+	return nil
+}
+
+func (m *mockRoomManager) LoadRoomSettings(matchID string) (*models.RoomSettings, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	settings, ok := m.settings[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &settings, nil
+}
+
+func (m *mockRoomManager) SaveRubricState(matchID string, items []models.RubricItem) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.rubrics == nil {
+		m.rubrics = make(map[string][]models.RubricItem)
+	}
+	m.rubrics[matchID] = append([]models.RubricItem(nil), items...)
+	return nil
+}
+
+func (m *mockRoomManager) LoadRubricState(matchID string) ([]models.RubricItem, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	return append([]models.RubricItem(nil), m.rubrics[matchID]...), nil
+}
+
+func (m *mockRoomManager) SaveTurnState(matchID string, state models.TurnState) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.turns == nil {
+		m.turns = make(map[string]models.TurnState)
+	}
+	m.turns[matchID] = state
+	return nil
+}
+
+func (m *mockRoomManager) LoadTurnState(matchID string) (*models.TurnState, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	state, ok := m.turns[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (m *mockRoomManager) SaveThreadState(matchID string, state models.ThreadState) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.threads == nil {
+		m.threads = make(map[string]models.ThreadState)
+	}
+	m.threads[matchID] = state
+	return nil
+}
+
+func (m *mockRoomManager) LoadThreadState(matchID string) (*models.ThreadState, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	state, ok := m.threads[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (m *mockRoomManager) SaveDoc(matchID string, doc models.DocSnapshot) error {
+	m.chatMu.Lock()
+	if m.docs == nil {
+		m.docs = make(map[string]models.DocSnapshot)
+	}
+	m.docs[matchID] = doc
+	m.chatMu.Unlock()
+	if m.docSaved != nil {
+		m.docSaved <- doc
+	}
+	return nil
+}
+
+func (m *mockRoomManager) LoadDoc(matchID string) (*models.DocSnapshot, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	doc, ok := m.docs[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+func (m *mockRoomManager) AddSnapshot(matchID string, snap models.Snapshot) error {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.snapshots == nil {
+		m.snapshots = make(map[string][]models.Snapshot)
+	}
+	m.snapshots[matchID] = append(m.snapshots[matchID], snap)
+	return nil
+}
+
+func (m *mockRoomManager) ListSnapshots(matchID string) ([]models.Snapshot, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	if m.snapErr != nil {
+		return nil, m.snapErr
+	}
+	return append([]models.Snapshot{}, m.snapshots[matchID]...), nil
+}
+
+func (m *mockRoomManager) SaveDraft(draft models.Draft) error {
+	m.chatMu.Lock()
+	if m.drafts == nil {
+		m.drafts = make(map[string]models.Draft)
+	}
+	m.drafts[draft.MatchID+":"+draft.UserID] = draft
+	m.chatMu.Unlock()
+	if m.draftSaved != nil {
+		m.draftSaved <- draft
+	}
 	return nil
 }
 
+func (m *mockRoomManager) LoadDraft(matchID, userID string) (*models.Draft, error) {
+	m.chatMu.Lock()
+	defer m.chatMu.Unlock()
+	draft, ok := m.drafts[matchID+":"+userID]
+	if !ok {
+		return nil, nil
+	}
+	return &draft, nil
+}
+
 func (m *mockRoomManager) GetInstanceID() string {
 	return "abcd"
 }
@@ -176,6 +485,128 @@ func TestGetRoomStatusSuccess(t *testing.T) {
 	}
 }
 
+// roomStatus answers GET /room/room1 with tok1
+func roomStatus(t *testing.T, h *Handlers) models.RoomInfo {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/room/room1", nil)
+	req = req.WithContext(addMatchID(req.Context(), "room1"))
+	req.Header.Set("Authorization", "Bearer tok1")
+	rec := httptest.NewRecorder()
+	h.GetRoomStatus(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	var status models.RoomInfo
+	decodeBody(t, rec.Body, &status)
+	return status
+}
+
+func TestGetRoomStatusOccupancy(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	if occ := roomStatus(t, h).Occupancy; occ == nil || occ.Count != 0 || len(occ.Connected) != 0 {
+		t.Fatalf("expected an empty room, got %+v", occ)
+	}
+
+	alice, _ := dial("tok1")
+	rm.chatMu.Lock()
+	published := rm.occupancy["room1"]
+	rm.chatMu.Unlock()
+	if len(published) != 1 || published[0] != "u1" {
+		t.Fatalf("expected the join to be published, got %q", published)
+	}
+
+	// This instance's hub answers even when nothing was published
+	rm.occErr = errors.New("redis down")
+	if occ := roomStatus(t, h).Occupancy; occ.Count != 1 || occ.Connected[0] != "u1" {
+		t.Fatalf("expected u1 from the hub, got %+v", occ)
+	}
+	rm.occErr = nil
+
+	alice.Close()
+	waitUntil(func() bool {
+		rm.chatMu.Lock()
+		defer rm.chatMu.Unlock()
+		return len(rm.occupancy["room1"]) == 0
+	}, t)
+}
+
+func TestGetRoomStatusOccupancyAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	local := room_management.NewRoomManager(mr.Addr(), "")
+	remote := room_management.NewRoomManager(mr.Addr(), "")
+	t.Cleanup(local.Cleanup)
+	t.Cleanup(remote.Cleanup)
+
+	rm := &mockRoomManager{occupied: local}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	dial("tok1")
+	// u2 is connected to the room on the other instance
+	if err := remote.SetOccupancy("room1", []string{"u2"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+
+	occ := roomStatus(t, h).Occupancy
+	if occ.Count != 2 || occ.Connected[0] != "u1" || occ.Connected[1] != "u2" {
+		t.Fatalf("expected both instances' users, got %+v", occ)
+	}
+
+	// This instance's users come from its hub, not from what it published
+	if err := local.SetOccupancy("room1", []string{"u1", "gone"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+	if occ := roomStatus(t, h).Occupancy; occ.Count != 2 {
+		t.Fatalf("expected a stale entry of this instance to be ignored, got %+v", occ)
+	}
+
+	// An instance without the room answers from Redis alone
+	other := newTestHandlers(&mockRunner{}, &mockRoomManager{occupied: remote, validateFn: rm.validateFn})
+	if occ := roomStatus(t, other).Occupancy; occ.Count != 2 {
+		t.Fatalf("expected the published occupancy, got %+v", occ)
+	}
+}
+
+// slowOccupancy records published occupancy, taking longer the more users
+// a list holds, so unordered publishes land out of order
+type slowOccupancy struct {
+	mu        sync.Mutex
+	published []string
+}
+
+func (s *slowOccupancy) SetOccupancy(_ string, userIDs []string) error {
+	time.Sleep(time.Duration(len(userIDs)) * 50 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = userIDs
+	return nil
+}
+
+func (s *slowOccupancy) RemoteOccupancy(string) ([]string, error) { return nil, nil }
+
+func TestOccupancyAfterConcurrentLeaves(t *testing.T) {
+	store := &slowOccupancy{}
+	rm := &mockRoomManager{occupied: store}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+	waitUntil(func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.published) == 2
+	}, t)
+
+	// The first leave's list, still holding the other user, is slower to write
+	// than the last leave's empty one
+	_ = alice.Close()
+	_ = bob.Close()
+	time.Sleep(200 * time.Millisecond)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.published) != 0 {
+		t.Fatalf("expected nobody published after both left, got %q", store.published)
+	}
+}
+
 func TestGetRoomStatusErrors(t *testing.T) {
 	h := newTestHandlers(&mockRunner{}, &mockRoomManager{
 		validateFn: func(string) (*models.RoomInfo, error) { return nil, errors.New("auth error") },
@@ -260,6 +691,15 @@ func TestRerollQuestionBranches(t *testing.T) {
 		t.Fatalf("expected 409 for no alternative, got %d", rec.Code)
 	}
 
+	rm.rerollFn = func(string) (*models.RoomInfo, error) {
+		return nil, fmt.Errorf("failed to call question service: %w", room_management.ErrQuestionServiceTimeout)
+	}
+	rec = httptest.NewRecorder()
+	h.RerollQuestion(rec, req)
+	if rec.Code != http.StatusGatewayTimeout || decodeError(t, rec).Code != "question_service_timeout" {
+		t.Fatalf("expected 504 question_service_timeout, got %d %s", rec.Code, rec.Body.String())
+	}
+
 	rm.rerollFn = func(string) (*models.RoomInfo, error) { return nil, errors.New("boom") }
 	rec = httptest.NewRecorder()
 	h.RerollQuestion(rec, req)
@@ -325,6 +765,51 @@ func TestRerollQuestionMissingAuth(t *testing.T) {
 	}
 }
 
+func TestRetryQuestionBranches(t *testing.T) {
+	rm := &mockRoomManager{
+		validateFn: func(string) (*models.RoomInfo, error) { return &models.RoomInfo{MatchId: "m1"}, nil },
+		retryFn: func(id string) (*models.RoomInfo, error) {
+			return &models.RoomInfo{MatchId: id, Status: "processing"}, nil
+		},
+	}
+	h := newTestHandlers(&mockRunner{}, rm)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/m1/retry-question", nil)
+	req = req.WithContext(addMatchID(req.Context(), "m1"))
+	req.Header.Set("Authorization", "Bearer token")
+
+	rec := httptest.NewRecorder()
+	h.RetryQuestion(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+	var info models.RoomInfo
+	decodeBody(t, rec.Body, &info)
+	if info.MatchId != "m1" || info.Status != "processing" {
+		t.Fatalf("unexpected body %+v", info)
+	}
+
+	rm.retryFn = func(string) (*models.RoomInfo, error) { return nil, room_management.ErrRoomNotInError }
+	rec = httptest.NewRecorder()
+	h.RetryQuestion(rec, req)
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Code != "room_not_in_error" {
+		t.Fatalf("expected 409 room_not_in_error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rm.retryFn = func(string) (*models.RoomInfo, error) { return nil, errors.New("boom") }
+	rec = httptest.NewRecorder()
+	h.RetryQuestion(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for unknown error, got %d", rec.Code)
+	}
+
+	req.Header.Del("Authorization")
+	rec = httptest.NewRecorder()
+	h.RetryQuestion(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when auth header missing, got %d", rec.Code)
+	}
+}
+
 func TestListLanguages(t *testing.T) {
 	runner := &mockRunner{
 		langSpecFn: func(lang models.Language) (models.LanguageSpec, string, string, [][]string, error) {
@@ -349,6 +834,29 @@ func TestListLanguages(t *testing.T) {
 	}
 }
 
+type languageSourceFunc func(context.Context) ([]models.Language, error)
+
+func (f languageSourceFunc) Languages(ctx context.Context) ([]models.Language, error) { return f(ctx) }
+
+func TestLoadSupportedLanguages(t *testing.T) {
+	orig := supportedLanguages
+	t.Cleanup(func() { supportedLanguages = orig })
+
+	loadSupportedLanguages(utils.NewLogger(), languageSourceFunc(func(context.Context) ([]models.Language, error) {
+		return nil, errors.New("connection refused")
+	}))
+	if !reflect.DeepEqual(supportedLanguages, orig) {
+		t.Fatalf("expected the built-in list to stay, got %v", supportedLanguages)
+	}
+
+	loadSupportedLanguages(utils.NewLogger(), languageSourceFunc(func(context.Context) ([]models.Language, error) {
+		return []models.Language{models.LangGo, models.LangPython}, nil
+	}))
+	if got := allowedLanguages(nil); !reflect.DeepEqual(got, []models.Language{models.LangGo, models.LangPython}) {
+		t.Fatalf("expected the sandbox's languages, got %v", got)
+	}
+}
+
 func TestFormatCode(t *testing.T) {
 	h := newTestHandlers(&mockRunner{}, &mockRoomManager{})
 	body := bytes.NewBufferString(`{"language":"python","code":"x"}`)
@@ -365,6 +873,50 @@ func TestFormatCode(t *testing.T) {
 	}
 }
 
+func TestFormatCodeLanguages(t *testing.T) {
+	dir := t.TempDir()
+	formatter := filepath.Join(dir, "formatter")
+	script := "#!/bin/sh\ncode=$(cat)\ncase \"$code\" in *broken*) echo 'parse error' >&2; exit 1;; esac\nprintf '%s\\n' \"$code\"\n"
+	if err := os.WriteFile(formatter, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake formatter: %v", err)
+	}
+	t.Setenv("COLLAB_JAVA_FORMAT", formatter)
+	t.Setenv("COLLAB_CLANG_FORMAT", filepath.Join(dir, "missing"))
+
+	h := newTestHandlers(&mockRunner{}, &mockRoomManager{})
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "java", body: `{"language":"java","code":"class A {}"}`, status: http.StatusOK},
+		{name: "java syntax error", body: `{"language":"java","code":"broken"}`, status: http.StatusUnprocessableEntity, code: "format_syntax_error"},
+		{name: "cpp without clang-format", body: `{"language":"cpp","code":"int main() {}"}`, status: http.StatusServiceUnavailable, code: "formatter_unavailable"},
+		{name: "unsupported", body: `{"language":"cobol","code":"x"}`, status: http.StatusBadRequest, code: "unsupported_language"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.FormatCode(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/format", bytes.NewBufferString(tc.body)))
+			if rec.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+			if tc.code != "" {
+				if got := decodeError(t, rec).Code; got != tc.code {
+					t.Fatalf("expected code %q, got %q", tc.code, got)
+				}
+				return
+			}
+			var resp models.FormatResponse
+			decodeBody(t, rec.Body, &resp)
+			if resp.Formatted != "class A {}\n" {
+				t.Fatalf("unexpected formatted code %q", resp.Formatted)
+			}
+		})
+	}
+}
+
 func TestFormatCodeContextError(t *testing.T) {
 	h := newTestHandlers(&mockRunner{}, &mockRoomManager{})
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/format", bytes.NewBufferString(`{"language":"python","code":"x"}`))
@@ -467,7 +1019,7 @@ func TestRunInSandboxDockerUnavailable(t *testing.T) {
 }
 
 func TestCollabWSFlow(t *testing.T) {
-	room := &models.RoomInfo{MatchId: "room1", User1: "u1"}
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "valid", Token2: "valid2"}
 	runner := &mockRunner{
 		langSpecFn: func(models.Language) (models.LanguageSpec, string, string, [][]string, error) {
 			return models.LanguageSpec{
@@ -484,7 +1036,7 @@ func TestCollabWSFlow(t *testing.T) {
 	}
 	rm := &mockRoomManager{
 		validateFn: func(token string) (*models.RoomInfo, error) {
-			if token != "valid" {
+			if token != "valid" && token != "valid2" {
 				return nil, errors.New("invalid token")
 			}
 			return room, nil
@@ -541,6 +1093,9 @@ func TestCollabWSFlow(t *testing.T) {
 
 	conn.WriteJSON(models.WSFrame{Type: "cursor", Data: models.Cursor{UserID: "u1", Pos: 1}})
 	conn.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{UserID: "u1", Message: "hi"}})
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "chat_ack" {
+		t.Fatalf("expected chat ack, got %#v err=%v", frame, err)
+	}
 
 	if err := conn.WriteJSON(models.WSFrame{Type: "language", Data: models.LanguageChange{Language: models.LangPython}}); err != nil {
 		t.Fatalf("send language: %v", err)
@@ -562,12 +1117,18 @@ func TestCollabWSFlow(t *testing.T) {
 	if frame.Type != "run_reset" {
 		t.Fatalf("expected run_reset, got %q", frame.Type)
 	}
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "run_status" {
+		t.Fatalf("expected run_status frame, got %#v err=%v", frame, err)
+	}
 	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "stdout" {
 		t.Fatalf("expected stdout frame, got %#v err=%v", frame, err)
 	}
 	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "exit" {
 		t.Fatalf("expected exit frame, got %#v err=%v", frame, err)
 	}
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "run_status" {
+		t.Fatalf("expected run_status frame, got %#v err=%v", frame, err)
+	}
 
 	// Send invalid edit to trigger OT error handling.
 	if err := conn.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 999, RangeStart: 5, RangeEnd: 3}}); err != nil {
@@ -588,7 +1149,7 @@ func TestCollabWSFlow(t *testing.T) {
 	}
 
 	// Connect second client to ensure room reuse.
-	conn2, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	conn2, _, err := websocket.DefaultDialer.Dial(wsURL+"2", nil)
 	if err != nil {
 		t.Fatalf("dial second websocket: %v", err)
 	}
@@ -599,6 +1160,26 @@ func TestCollabWSFlow(t *testing.T) {
 	if err := conn2.ReadJSON(&frame); err != nil || frame.Type != "init" {
 		t.Fatalf("expected init response for second client, got %#v err=%v", frame, err)
 	}
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "presence" {
+		t.Fatalf("expected presence frame as the partner joined, got %#v err=%v", frame, err)
+	}
+
+	// Typing reaches the partner; malformed indicators are dropped silently
+	_ = conn2.WriteJSON(models.WSFrame{Type: "typing", Data: "yes"})
+	_ = conn2.WriteJSON(models.WSFrame{Type: "typing", Data: map[string]any{"active": "yes"}})
+	_ = conn2.WriteJSON(models.WSFrame{Type: "typing", Data: models.Typing{Active: true}})
+	var typing models.Typing
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "typing" {
+		t.Fatalf("expected typing frame, got %#v err=%v", frame, err)
+	}
+	marshal(frame.Data, &typing)
+	if typing != (models.Typing{UserID: "u2", Active: true}) {
+		t.Fatalf("unexpected typing frame: %+v", typing)
+	}
+	_ = conn2.WriteJSON(models.WSFrame{Type: "unknown"})
+	if err := conn2.ReadJSON(&frame); err != nil || frame.Code != "unknown_type" {
+		t.Fatalf("expected no error for the malformed typing frames, got %#v err=%v", frame, err)
+	}
 
 	// Third client should be rejected because room already has 2 participants.
 	conn3, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
@@ -678,7 +1259,7 @@ func TestCollabWSInvalidInitFrame(t *testing.T) {
 		t.Fatalf("send invalid init: %v", err)
 	}
 	var frame models.WSFrame
-	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "error" || frame.Data != "expected init" {
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "error" || frame.Data != "expected_init" {
 		t.Fatalf("expected init error, got %#v err=%v", frame, err)
 	}
 }
@@ -791,3 +1372,3855 @@ func waitUntil(cond func() bool, t *testing.T) {
 	}
 	t.Fatalf("condition not met")
 }
+
+func TestListLanguagesForRoom(t *testing.T) {
+	runner := &mockRunner{
+		langSpecFn: func(lang models.Language) (models.LanguageSpec, string, string, [][]string, error) {
+			return models.LanguageSpec{Name: lang}, "", "", nil, nil
+		},
+	}
+	rm := &mockRoomManager{
+		getFn: func(id string) (*models.RoomInfo, error) {
+			switch id {
+			case "restricted":
+				return &models.RoomInfo{MatchId: id, Question: &models.Question{
+					Execution: &models.ExecutionConfig{AllowedLanguages: []models.Language{models.LangPython}},
+				}}, nil
+			case "plain":
+				return &models.RoomInfo{MatchId: id, Question: &models.Question{ID: 1}}, nil
+			}
+			return nil, errors.New("room not found")
+		},
+	}
+	h := newTestHandlers(runner, rm)
+
+	cases := map[string]int{"restricted": 1, "plain": len(supportedLanguages)}
+	for matchID, want := range cases {
+		rec := httptest.NewRecorder()
+		h.ListLanguages(rec, httptest.NewRequest(http.MethodGet, "/api/v1/collab/languages?matchId="+matchID, nil))
+		var resp []models.LanguageSpec
+		decodeBody(t, rec.Body, &resp)
+		if len(resp) != want {
+			t.Fatalf("%s: expected %d languages, got %d", matchID, want, len(resp))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ListLanguages(rec, httptest.NewRequest(http.MethodGet, "/api/v1/collab/languages?matchId=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown room, got %d", rec.Code)
+	}
+}
+
+func TestCollabWSAppliesExecutionConstraints(t *testing.T) {
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", Question: &models.Question{
+		ID: 1,
+		Execution: &models.ExecutionConfig{
+			AllowedLanguages: []models.Language{models.LangJava},
+			Limits:           &models.ExecutionLimits{WallTimeMs: 60000, MemoryMb: 256},
+		},
+	}}
+	limitsCh := make(chan exec.SandboxLimits, 1)
+	runner := &mockRunner{
+		runStreamFn: func(_ context.Context, _ models.Language, _ string, limits exec.SandboxLimits) ([]models.WSFrame, error) {
+			limitsCh <- limits
+			return []models.WSFrame{{Type: "exit", Data: map[string]any{"code": 0}}}, nil
+		},
+	}
+	rm := &mockRoomManager{
+		validateFn: func(string) (*models.RoomInfo, error) { return room, nil },
+	}
+	h := NewHandlersWithDeps(utils.NewLogger(), runner, session.NewHub(), rm)
+
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", h.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/session/room1?token=valid"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}}); err != nil {
+		t.Fatalf("send init: %v", err)
+	}
+	var frame models.WSFrame
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "init" {
+		t.Fatalf("expected init, got %#v err=%v", frame, err)
+	}
+	var initResp models.InitResponse
+	marshal(frame.Data, &initResp)
+	if initResp.Language != models.LangJava {
+		t.Fatalf("expected room to fall back to java, got %q", initResp.Language)
+	}
+	if len(initResp.AllowedLanguages) != 1 || initResp.AllowedLanguages[0] != models.LangJava {
+		t.Fatalf("unexpected allowed languages: %v", initResp.AllowedLanguages)
+	}
+
+	_ = conn.WriteJSON(models.WSFrame{Type: "language", Data: models.LanguageChange{Language: models.LangPython}})
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "error" || frame.Data != "language_not_allowed" {
+		t.Fatalf("expected language_not_allowed, got %#v err=%v", frame, err)
+	}
+
+	_ = conn.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangJava, Code: "class Main {}"}})
+	select {
+	case limits := <-limitsCh:
+		if limits.WallTime != maxRunWallTime || limits.MemoryB != 256*1024*1024 {
+			t.Fatalf("expected question limits clamped to ceilings, got %+v", limits)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected run to reach the sandbox")
+	}
+}
+
+func TestCollabWSRunArgsAndEnv(t *testing.T) {
+	rm := &mockRoomManager{}
+	limitsCh := make(chan exec.SandboxLimits, 1)
+	runner := &mockRunner{
+		runStreamFn: func(_ context.Context, _ models.Language, _ string, limits exec.SandboxLimits) ([]models.WSFrame, error) {
+			limitsCh <- limits
+			return []models.WSFrame{{Type: "exit", Data: map[string]any{"code": 0}}}, nil
+		},
+	}
+	_, dial := serveTestRoom(t, rm, runner, make(chan time.Time))
+	info := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2", Question: &models.Question{
+		ID:        1,
+		Execution: &models.ExecutionConfig{Args: []string{"input.txt"}, Env: map[string]string{"MODE": "judge"}},
+	}}
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+	conn, _ := dial("tok1")
+
+	// Question values take precedence over the user's
+	run := models.RunCmd{Language: models.LangPython, Stdin: "1 2\n", Args: []string{"--other"}, Env: map[string]string{"MODE": "fast", "DEBUG": "1"}}
+	_ = conn.WriteJSON(models.WSFrame{Type: "run", Data: run})
+	select {
+	case limits := <-limitsCh:
+		want := map[string]string{"MODE": "judge", "DEBUG": "1"}
+		if strings.Join(limits.Args, " ") != "input.txt" || !reflect.DeepEqual(limits.Env, want) {
+			t.Fatalf("expected question args and env to win, got %v %v", limits.Args, limits.Env)
+		}
+		if limits.Stdin != "1 2\n" {
+			t.Fatalf("expected stdin passed to the runner, got %q", limits.Stdin)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected run to reach the sandbox")
+	}
+
+	run = models.RunCmd{Language: models.LangPython, Stdin: strings.Repeat("x", maxRunStdinBytes+1), Env: map[string]string{"SANDBOX_TOKEN": "x"}}
+	_ = conn.WriteJSON(models.WSFrame{Type: "run", Data: run})
+	var invalid models.InvalidInvocation
+	for {
+		// skip the frames of the first run
+		var frame models.WSFrame
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("expected an error frame: %v", err)
+		}
+		if frame.Type == "error" {
+			marshal(frame.Data, &invalid)
+			break
+		}
+	}
+	if invalid.Error != "invalid_invocation" || len(invalid.Details) != 2 ||
+		invalid.Details[0].Field != "env.SANDBOX_TOKEN" || invalid.Details[1].Field != "stdin" {
+		t.Fatalf("unexpected rejection: %#v", invalid)
+	}
+}
+
+func TestRunOnceArgsAndEnv(t *testing.T) {
+	var got exec.SandboxLimits
+	runner := &mockRunner{
+		runOnceFn: func(_ context.Context, _ models.Language, _ string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+			got = limits
+			return exec.RunOutput{Stdout: "ok", Args: limits.Args, Env: limits.Env, Usage: &models.RunUsage{DurationMs: 120}}, nil
+		},
+	}
+	h := newTestHandlers(runner, &mockRoomManager{})
+
+	body := `{"language":"cpp","code":"int main(){}","stdin":"5\n","args":["--mode=fast","input.txt"],"env":{"DEBUG":"1"}}`
+	rec := httptest.NewRecorder()
+	h.RunOnce(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/run", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Join(got.Args, " ") != "--mode=fast input.txt" || got.Env["DEBUG"] != "1" || got.Stdin != "5\n" {
+		t.Fatalf("expected args, env and stdin passed to the runner, got %+v", got)
+	}
+	var result models.RunResult
+	_ = json.Unmarshal(rec.Body.Bytes(), &result)
+	if len(result.Args) != 2 || result.Env["DEBUG"] != "1" {
+		t.Fatalf("expected the effective args and env echoed, got %+v", result)
+	}
+	if result.Usage == nil || result.Usage.DurationMs != 120 {
+		t.Fatalf("expected the run's usage, got %+v", result.Usage)
+	}
+
+	args := make([]string, maxRunArgs+1)
+	args[0] = strings.Repeat("a", maxRunArgLen+1)
+	payload, _ := json.Marshal(models.RunRequest{
+		Language: models.LangPython,
+		Stdin:    strings.Repeat("x", maxRunStdinBytes+1),
+		Args:     args,
+		Env:      map[string]string{"PATH": "/tmp", "lower": "1", "BIG": strings.Repeat("v", maxRunEnvValueLen+1)},
+	})
+	rec = httptest.NewRecorder()
+	h.RunOnce(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/run", bytes.NewReader(payload)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	resp := decodeError(t, rec)
+	var fields []string
+	for _, d := range resp.Details {
+		fields = append(fields, d.Field)
+	}
+	if resp.Code != "invalid_invocation" || strings.Join(fields, ",") != "args,args[0],env.BIG,env.PATH,env.lower,stdin" {
+		t.Fatalf("unexpected rejection: %+v", resp)
+	}
+}
+
+func TestHandleRoomUpdateReappliesConstraints(t *testing.T) {
+	hub := session.NewHub()
+	h := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, hub, &mockRoomManager{})
+
+	room := hub.GetOrCreate("m1")
+	room.SetLanguage(models.LangCPP)
+	client := session.NewClient(nil)
+	var frames []models.WSFrame
+	client.SetSendHook(func(frame models.WSFrame) { frames = append(frames, frame) })
+	room.Join(client)
+
+	cfg := &models.ExecutionConfig{AllowedLanguages: []models.Language{models.LangPython}}
+	h.handleRoomUpdate("m1", &models.RoomInfo{MatchId: "m1", Question: &models.Question{ID: 2, Execution: cfg}})
+
+	if len(frames) != 3 || frames[0].Type != "question" || frames[1].Type != "constraints" || frames[2].Type != "language" {
+		t.Fatalf("expected question, constraints and language frames, got %#v", frames)
+	}
+	constraints := frames[1].Data.(models.Constraints)
+	if len(constraints.AllowedLanguages) != 1 || constraints.AllowedLanguages[0] != models.LangPython {
+		t.Fatalf("unexpected constraints: %+v", constraints)
+	}
+	if _, lang := room.Snapshot(); lang != models.LangPython {
+		t.Fatalf("expected language switched to python, got %q", lang)
+	}
+
+	// Rerolling onto a question without metadata lifts the restriction again.
+	frames = nil
+	h.handleRoomUpdate("m1", &models.RoomInfo{MatchId: "m1", Question: &models.Question{ID: 3}})
+	if len(frames) != 2 || frames[1].Type != "constraints" {
+		t.Fatalf("expected constraints frame after reroll, got %#v", frames)
+	}
+	if got := frames[1].Data.(models.Constraints).AllowedLanguages; len(got) != len(supportedLanguages) {
+		t.Fatalf("expected all languages allowed, got %v", got)
+	}
+	if room.ExecutionConfig() != nil {
+		t.Fatalf("expected execution config cleared")
+	}
+}
+
+func TestRunLimitsWithoutMetadata(t *testing.T) {
+	if got := runLimitsFor(nil); !reflect.DeepEqual(got, defaultRunLimits) {
+		t.Fatalf("expected default limits, got %+v", got)
+	}
+	if got := runLimitsFor(&models.ExecutionConfig{AllowedLanguages: []models.Language{models.LangCPP}}); !reflect.DeepEqual(got, defaultRunLimits) {
+		t.Fatalf("expected default limits when only languages are restricted, got %+v", got)
+	}
+	if got := allowedLanguages(nil); len(got) != len(supportedLanguages) {
+		t.Fatalf("expected every language without metadata, got %v", got)
+	}
+}
+
+type mockAnalyzer struct {
+	analyzeFn func(context.Context, models.Language, string, *models.Question) (*models.ComplexityVerdict, error)
+}
+
+func (m *mockAnalyzer) Analyze(ctx context.Context, lang models.Language, code string, question *models.Question) (*models.ComplexityVerdict, error) {
+	return m.analyzeFn(ctx, lang, code, question)
+}
+
+func TestRunInSandboxComplexityEnrichment(t *testing.T) {
+	exitCode := 0
+	runner := &mockRunner{
+		runStreamFn: func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error) {
+			return []models.WSFrame{
+				{Type: "stdout", Data: "ok"},
+				{Type: "exit", Data: map[string]any{"code": exitCode, "timedOut": false}},
+			}, nil
+		},
+	}
+	rm := &mockRoomManager{
+		getFn: func(matchId string) (*models.RoomInfo, error) {
+			return &models.RoomInfo{MatchId: matchId, Question: &models.Question{ID: 7, PromptMarkdown: "two sum"}}, nil
+		},
+	}
+	var gotQuestion *models.Question
+	calls := 0
+	h := newTestHandlers(runner, rm)
+	h.SetAnalyzer(&mockAnalyzer{
+		analyzeFn: func(_ context.Context, _ models.Language, _ string, q *models.Question) (*models.ComplexityVerdict, error) {
+			calls++
+			gotQuestion = q
+			return &models.ComplexityVerdict{TimeComplexity: "O(n)", SpaceComplexity: "O(n)", Confidence: 0.9}, nil
+		},
+	})
+
+	room := session.NewRoom("room1")
+	client := session.NewClient(nil)
+	var frames []models.WSFrame
+	client.SetSendHook(func(frame models.WSFrame) { frames = append(frames, frame) })
+	room.Join(client)
+
+	h.runInSandbox(room, models.RunCmd{Language: models.LangPython, Code: "print('ok')"})
+	if len(frames) != 3 || frames[2].Type != "complexity" {
+		t.Fatalf("expected complexity frame after the run, got %#v", frames)
+	}
+	if verdict, ok := frames[2].Data.(*models.ComplexityVerdict); !ok || verdict.TimeComplexity != "O(n)" {
+		t.Fatalf("unexpected verdict payload: %#v", frames[2].Data)
+	}
+	if gotQuestion == nil || gotQuestion.ID != 7 {
+		t.Fatalf("expected question context to be forwarded, got %#v", gotQuestion)
+	}
+
+	// failed runs are not analysed
+	exitCode = 1
+	frames = nil
+	h.runInSandbox(room, models.RunCmd{Language: models.LangPython, Code: "raise"})
+	if calls != 1 || len(frames) != 2 {
+		t.Fatalf("expected no enrichment for failing run, got %d calls and frames %#v", calls, frames)
+	}
+}
+
+func TestCollabWSChatReactionsAndReadReceipts(t *testing.T) {
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2"}
+	rm := &mockRoomManager{
+		validateFn: func(token string) (*models.RoomInfo, error) {
+			if token != "tok1" && token != "tok2" {
+				return nil, errors.New("invalid token")
+			}
+			return room, nil
+		},
+	}
+	newServer := func() *httptest.Server {
+		h := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), rm)
+		router := chi.NewRouter()
+		router.Get("/ws/session/{id}", h.CollabWS)
+		return httptest.NewServer(router)
+	}
+	connect := func(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+		t.Helper()
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/session/room1?token=" + token
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial websocket: %v", err)
+		}
+		_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}})
+		var frame models.WSFrame
+		if err := conn.ReadJSON(&frame); err != nil || frame.Type != "init" {
+			t.Fatalf("expected init, got %#v err=%v", frame, err)
+		}
+		return conn
+	}
+	expect := func(t *testing.T, conn *websocket.Conn, typ string, out any) {
+		t.Helper()
+		expectFrame(t)(conn, typ, out)
+	}
+
+	server := newServer()
+	defer server.Close()
+	alice := connect(t, server, "tok1")
+	defer alice.Close()
+	bob := connect(t, server, "tok2")
+	defer bob.Close()
+
+	// client-supplied user ids are replaced by the authenticated participant
+	_ = alice.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{UserID: "spoofed", Message: "run it now?"}})
+	var ack, received models.ChatMessage
+	expect(t, alice, "chat_ack", &ack)
+	expect(t, bob, "chat", &received)
+	if ack.ID != 1 || received.ID != 1 || received.UserID != "u1" {
+		t.Fatalf("unexpected chat delivery: ack=%#v received=%#v", ack, received)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat_reaction", Data: models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "add"}})
+	var update models.ChatReactionUpdate
+	expect(t, alice, "chat_reaction", &update)
+	expect(t, bob, "chat_reaction", nil)
+	if update.MessageID != 1 || len(update.Reactions["👍"]) != 1 || update.Reactions["👍"][0] != "u2" {
+		t.Fatalf("unexpected reaction update: %#v", update)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat_reaction", Data: models.ChatReaction{MessageID: 1, Emoji: "🦄", Action: "add"}})
+	var errMsg string
+	expect(t, bob, "error", &errMsg)
+	if errMsg != "emoji_not_allowed" {
+		t.Fatalf("expected allowlist error, got %q", errMsg)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat_reaction", Data: models.ChatReaction{MessageID: 99, Emoji: "👍", Action: "add"}})
+	expect(t, bob, "error", &errMsg)
+	if errMsg != "unknown_message" {
+		t.Fatalf("expected unknown_message, got %q", errMsg)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat_read", Data: models.ChatRead{UpToMessageID: 1}})
+	var read models.ChatReadUpdate
+	expect(t, alice, "chat_read_update", &read)
+	expect(t, bob, "chat_read_update", nil)
+	if read.UserID != "u2" || read.UpToMessageID != 1 {
+		t.Fatalf("unexpected read update: %#v", read)
+	}
+
+	// Reconnect to a fresh instance: the chat must come back from the snapshot.
+	alice.Close()
+	bob.Close()
+	server2 := newServer()
+	defer server2.Close()
+	again := connect(t, server2, "tok1")
+	defer again.Close()
+
+	var history models.ChatState
+	expect(t, again, "chat_history", &history)
+	if len(history.Messages) != 1 || history.Messages[0].Reactions["👍"][0] != "u2" || history.ReadMarks["u2"] != 1 {
+		t.Fatalf("unexpected replayed chat: %#v", history)
+	}
+}
+
+// draftTestRoom wires two participants into room1 and returns helpers to talk
+// to it over WebSockets.
+func draftTestRoom(t *testing.T, rm *mockRoomManager, ticks chan time.Time) (connect func(token string) *websocket.Conn, expect func(conn *websocket.Conn, typ string, out any)) {
+	t.Helper()
+	_, connect, expect = wsTestRoom(t, rm, &mockRunner{}, ticks)
+	return connect, expect
+}
+
+// wsTestRoom serves room1 (participants u1/tok1 and u2/tok2) over a test server.
+func wsTestRoom(t *testing.T, rm *mockRoomManager, runner runner, ticks chan time.Time) (h *Handlers, connect func(token string) *websocket.Conn, expect func(conn *websocket.Conn, typ string, out any)) {
+	t.Helper()
+	h, dial := serveTestRoom(t, rm, runner, ticks)
+	connect = func(token string) *websocket.Conn {
+		t.Helper()
+		conn, _ := dial(token)
+		return conn
+	}
+	return h, connect, expectFrame(t)
+}
+
+// serveTestRoom is wsTestRoom with a dialer that also returns the init
+// response. Capabilities passed to dial are requested with protocol version 2.
+func serveTestRoom(t *testing.T, rm *mockRoomManager, runner runner, ticks chan time.Time) (h *Handlers, dial func(token string, caps ...string) (*websocket.Conn, models.InitResponse)) {
+	t.Helper()
+	h, dial, _ = serveResumableRoom(t, rm, runner, ticks)
+	return h, dial
+}
+
+// serveResumableRoom is serveTestRoom with a second dialer that reconnects
+// with a resume frame carrying the given markers.
+func serveResumableRoom(t *testing.T, rm *mockRoomManager, runner runner, ticks chan time.Time) (h *Handlers, dial func(token string, caps ...string) (*websocket.Conn, models.InitResponse), resume func(token string, markers models.ResumeMarkers) (*websocket.Conn, models.InitResponse)) {
+	t.Helper()
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2"}
+	rm.validateFn = func(token string) (*models.RoomInfo, error) {
+		if token != "tok1" && token != "tok2" {
+			return nil, errors.New("invalid token")
+		}
+		return room, nil
+	}
+	// Spectator tokens are "spec<n>", held by "mentor<n>"
+	rm.spectateFn = func(token string) (*models.RoomInfo, string, error) {
+		if !strings.HasPrefix(token, "spec") {
+			return nil, "", errors.New("not a spectator token")
+		}
+		return room, "mentor" + strings.TrimPrefix(token, "spec"), nil
+	}
+	h = NewHandlersWithDeps(utils.NewLogger(), runner, session.NewHub(), rm)
+	h.draftTicker = func(time.Duration) (<-chan time.Time, func()) { return ticks, func() {} }
+	// Runs follow each other closely here; TestCollabWSRunQueue covers the cooldown
+	h.hub.SetRoomOptions(session.WithRunCooldown(0))
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", h.CollabWS)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	open := func(token, typ string, initReq map[string]any) (*websocket.Conn, models.InitResponse) {
+		t.Helper()
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/session/room1?token=" + token
+		// Offer permessage-deflate like browsers do, so large frames take the compressed path
+		dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second, EnableCompression: true}
+		conn, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial websocket: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		_ = conn.WriteJSON(models.WSFrame{Type: typ, Data: initReq})
+		var frame models.WSFrame
+		if err := conn.ReadJSON(&frame); err != nil || frame.Type != typ {
+			t.Fatalf("expected %s, got %#v err=%v", typ, frame, err)
+		}
+		var init models.InitResponse
+		marshal(frame.Data, &init)
+		return conn, init
+	}
+	dial = func(token string, caps ...string) (*websocket.Conn, models.InitResponse) {
+		t.Helper()
+		initReq := map[string]any{"language": "python"}
+		if len(caps) > 0 {
+			initReq["protocolVersion"] = 2
+			initReq["capabilities"] = caps
+		}
+		return open(token, "init", initReq)
+	}
+	resume = func(token string, markers models.ResumeMarkers) (*websocket.Conn, models.InitResponse) {
+		t.Helper()
+		return open(token, "resume", map[string]any{"language": "python", "resume": markers})
+	}
+	return h, dial, resume
+}
+
+func TestCollabWSStarterCode(t *testing.T) {
+	runner := &mockRunner{
+		langSpecFn: func(lang models.Language) (models.LanguageSpec, string, string, [][]string, error) {
+			return models.LanguageSpec{Name: lang, ExampleTemplate: "example " + string(lang)}, "", "", nil, nil
+		},
+	}
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, runner, make(chan time.Time))
+	question := &models.Question{ID: 1, StarterCode: map[models.Language]string{models.LangPython: "def solve():\n"}}
+	rm.validateFn = func(token string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2", Question: question}, nil
+	}
+	expect := expectFrame(t)
+
+	conn, init := dial("tok1")
+	if init.Doc.Text != "def solve():\n" {
+		t.Fatalf("expected the question's starter code, got %q", init.Doc.Text)
+	}
+
+	// Java has no starter, so the untouched doc becomes its example template
+	_ = conn.WriteJSON(models.WSFrame{Type: "language", Data: models.LanguageChange{Language: models.LangJava}})
+	expect(conn, "language", nil)
+	var doc models.DocState
+	expect(conn, "doc", &doc)
+	if doc.Text != "example java" || doc.Version != init.Doc.Version+1 {
+		t.Fatalf("expected the java example template, got %#v", doc)
+	}
+
+	_ = conn.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: doc.Version, Text: "// "}})
+	expect(conn, "doc", &doc)
+	_ = conn.WriteJSON(models.WSFrame{Type: "language", Data: models.LanguageChange{Language: models.LangPython}})
+	expect(conn, "language", nil)
+	room, _ := h.hub.Get("room1")
+	if text, lang := room.Snapshot(); text.Text != "// example java" || lang != models.LangPython {
+		t.Fatalf("an edited doc must survive a language change, got %q in %s", text.Text, lang)
+	}
+}
+
+// expectFrame reads the next frame, which must be of type typ. Presence
+// frames come whenever the partner connects or leaves, so they are skipped
+// unless asked for.
+func expectFrame(t *testing.T) func(conn *websocket.Conn, typ string, out any) {
+	return func(conn *websocket.Conn, typ string, out any) {
+		t.Helper()
+		var frame models.WSFrame
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		err := conn.ReadJSON(&frame)
+		for err == nil && frame.Type == "presence" && typ != "presence" {
+			frame = models.WSFrame{}
+			err = conn.ReadJSON(&frame)
+		}
+		if err != nil || frame.Type != typ {
+			t.Fatalf("expected %s frame, got %#v err=%v", typ, frame, err)
+		}
+		if out != nil {
+			marshal(frame.Data, out)
+		}
+	}
+}
+
+func TestCollabWSDraftAutosaveOnTick(t *testing.T) {
+	rm := &mockRoomManager{draftSaved: make(chan models.Draft, 4)}
+	ticks := make(chan time.Time)
+	connect, expect := draftTestRoom(t, rm, ticks)
+	alice := connect("tok1")
+
+	// No activity yet: a tick must not store anything
+	ticks <- time.Now()
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "x = 1"}})
+	expect(alice, "doc", nil)
+	_ = alice.WriteJSON(models.WSFrame{Type: "cursor", Data: models.Cursor{Pos: 5}})
+	// Round-trip a frame so the cursor is processed before the tick
+	_ = alice.WriteJSON(models.WSFrame{Type: "bogus"})
+	expect(alice, "error", nil)
+
+	ticks <- time.Now()
+	select {
+	case draft := <-rm.draftSaved:
+		if draft.UserID != "u1" || draft.Text != "x = 1" || draft.Cursor != 5 || draft.Version != 1 || draft.SavedAt == 0 {
+			t.Fatalf("unexpected draft: %#v", draft)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected draft to be saved on tick")
+	}
+
+	// Idle again: the next tick is a no-op
+	ticks <- time.Now()
+	select {
+	case draft := <-rm.draftSaved:
+		t.Fatalf("unexpected save without activity: %#v", draft)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCollabWSDocSurvivesRestart(t *testing.T) {
+	rm := &mockRoomManager{docSaved: make(chan models.DocSnapshot, 8)}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	alice, _ := dial("tok1")
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "x = 1"}})
+	expectFrame(t)(alice, "doc", nil)
+	for saved := false; !saved; {
+		select {
+		case doc := <-rm.docSaved:
+			saved = doc.Text == "x = 1"
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected the document to be persisted")
+		}
+	}
+	alice.Close()
+
+	// A new instance, with an empty hub, serves the persisted document
+	_, dial = serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	_, init := dial("tok2")
+	if init.Doc.Text != "x = 1" || init.Doc.Version != 1 {
+		t.Fatalf("expected the persisted document in init, got %+v", init.Doc)
+	}
+}
+
+func TestCollabWSDraftSave(t *testing.T) {
+	rm := &mockRoomManager{}
+	connect, expect := draftTestRoom(t, rm, make(chan time.Time))
+	alice := connect("tok1")
+
+	local := "unsynced local work"
+	cursor := 7
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_save", Data: models.DraftSave{Text: &local, Cursor: &cursor}})
+	expect(alice, "draft_saved", nil)
+	draft, _ := rm.LoadDraft("room1", "u1")
+	if draft == nil || draft.Text != local || draft.Cursor != 7 {
+		t.Fatalf("unexpected draft: %#v", draft)
+	}
+
+	huge := strings.Repeat("a", maxDraftBytes+1)
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_save", Data: models.DraftSave{Text: &huge}})
+	var errMsg string
+	expect(alice, "error", &errMsg)
+	if errMsg != "draft_too_large" {
+		t.Fatalf("expected draft_too_large, got %q", errMsg)
+	}
+	if draft, _ := rm.LoadDraft("room1", "u1"); draft.Text != local {
+		t.Fatalf("oversized draft must not replace the stored one")
+	}
+}
+
+func TestCollabWSDraftRestoreNeedsPartnerConfirm(t *testing.T) {
+	rm := &mockRoomManager{}
+	connect, expect := draftTestRoom(t, rm, make(chan time.Time))
+	alice := connect("tok1")
+
+	var errMsg string
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_restore"})
+	expect(alice, "error", &errMsg)
+	if errMsg != "no_draft" {
+		t.Fatalf("expected no_draft, got %q", errMsg)
+	}
+
+	mine := "def solve(): return 42"
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_save", Data: models.DraftSave{Text: &mine}})
+	expect(alice, "draft_saved", nil)
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_restore"})
+	expect(alice, "error", &errMsg)
+	if errMsg != "partner_unavailable" {
+		t.Fatalf("expected partner_unavailable, got %q", errMsg)
+	}
+
+	bob := connect("tok2")
+
+	// Rejected restores leave the document alone
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_restore"})
+	var req models.DraftRestoreRequest
+	expect(alice, "draft_restore_pending", nil)
+	expect(bob, "draft_restore_request", &req)
+	if req.UserID != "u1" || req.SavedAt == 0 {
+		t.Fatalf("unexpected restore request: %#v", req)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_restore_confirm", Data: models.DraftRestoreConfirm{Accept: true}})
+	expect(alice, "error", &errMsg)
+	if errMsg != "no_pending_restore" {
+		t.Fatalf("requester must not confirm their own restore, got %q", errMsg)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "draft_restore_confirm", Data: models.DraftRestoreConfirm{Accept: false}})
+	expect(alice, "draft_restore_rejected", nil)
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "draft_restore"})
+	expect(alice, "draft_restore_pending", nil)
+	expect(bob, "draft_restore_request", nil)
+	_ = bob.WriteJSON(models.WSFrame{Type: "draft_restore_confirm", Data: models.DraftRestoreConfirm{Accept: true}})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var doc models.DocState
+		expect(conn, "doc", &doc)
+		if doc.Text != mine || doc.Version != 1 {
+			t.Fatalf("expected restored doc at version 1, got %#v", doc)
+		}
+		expect(conn, "draft_restored", nil)
+	}
+}
+
+func TestGetDraft(t *testing.T) {
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2"}
+	rm := &mockRoomManager{
+		validateFn: func(token string) (*models.RoomInfo, error) {
+			if token != "tok1" && token != "tok2" {
+				return nil, errors.New("invalid token")
+			}
+			return room, nil
+		},
+	}
+	_ = rm.SaveDraft(models.Draft{MatchID: "room1", UserID: "u1", Text: "alice's", SavedAt: 1234})
+	h := newTestHandlers(&mockRunner{}, rm)
+
+	get := func(matchID, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/room/"+matchID+"/draft", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		req = req.WithContext(addMatchID(req.Context(), matchID))
+		rec := httptest.NewRecorder()
+		h.GetDraft(rec, req)
+		return rec
+	}
+
+	if rec := get("room1", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	if rec := get("room1", "Bearer nope"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for invalid token, got %d", rec.Code)
+	}
+	if rec := get("other", "Bearer tok1"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for another room, got %d", rec.Code)
+	}
+	// The partner only ever sees their own (missing) draft
+	if rec := get("room1", "Bearer tok2"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for partner without draft, got %d", rec.Code)
+	}
+
+	rec := get("room1", "Bearer tok1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var draft models.Draft
+	decodeBody(t, rec.Body, &draft)
+	if draft.UserID != "u1" || draft.Text != "alice's" || draft.SavedAt != 1234 {
+		t.Fatalf("unexpected draft: %#v", draft)
+	}
+}
+
+// scrapeMetric reads one series from the Prometheus handler, 0 if absent.
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			var v float64
+			if _, err := fmt.Sscan(value, &v); err != nil {
+				t.Fatalf("bad sample %q: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsAcrossRoomLifecycle(t *testing.T) {
+	const (
+		roomsSeries   = "collab_rooms_active"
+		connsSeries   = "collab_ws_connections"
+		editsSeries   = "collab_edits_total"
+		otSeries      = `collab_ot_errors_total{type="version_mismatch"}`
+		timeoutSeries = `collab_runs_total{language="python",outcome="timeout"}`
+		unavailSeries = `collab_runs_total{language="cpp",outcome="unavailable"}`
+		startedSeries = `collab_runs_started_total{language="python"}`
+		framesSeries  = "collab_ws_frame_size_bytes_count"
+	)
+	// Sockets closed by earlier tests leave their rooms asynchronously; wait
+	// for the connection gauge to settle before taking the baseline.
+	for settled, last, deadline := 0, -1.0, time.Now().Add(2*time.Second); settled < 5 && time.Now().Before(deadline); {
+		if cur := scrapeMetric(t, connsSeries); cur == last {
+			settled++
+		} else {
+			settled, last = 0, cur
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	series := []string{roomsSeries, connsSeries, editsSeries, otSeries, timeoutSeries, unavailSeries, startedSeries, framesSeries}
+	before := make(map[string]float64)
+	for _, s := range series {
+		before[s] = scrapeMetric(t, s)
+	}
+	delta := func(s string) float64 { return scrapeMetric(t, s) - before[s] }
+
+	runner := &mockRunner{
+		runStreamFn: func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error) {
+			return []models.WSFrame{{Type: "exit", Data: map[string]any{"code": -1, "timedOut": true}}}, nil
+		},
+		runOnceFn: func(context.Context, models.Language, string, exec.SandboxLimits) (exec.RunOutput, error) {
+			return exec.RunOutput{}, exec.ErrDockerUnavailable
+		},
+	}
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2"}
+	rm := &mockRoomManager{validateFn: func(string) (*models.RoomInfo, error) { return room, nil }}
+	hub := session.NewHub()
+	h := NewHandlersWithDeps(utils.NewLogger(), runner, hub, rm)
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", h.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	connect := func(token string) *websocket.Conn {
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/session/room1?token=" + token
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial websocket: %v", err)
+		}
+		_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}})
+		var frame models.WSFrame
+		if err := conn.ReadJSON(&frame); err != nil || frame.Type != "init" {
+			t.Fatalf("expected init, got %#v err=%v", frame, err)
+		}
+		return conn
+	}
+	alice := connect("tok1")
+	defer alice.Close()
+	bob := connect("tok2")
+	defer bob.Close()
+	if delta(roomsSeries) != 1 || delta(connsSeries) != 2 {
+		t.Fatalf("expected 1 room and 2 connections, got %v and %v", delta(roomsSeries), delta(connsSeries))
+	}
+
+	var frame models.WSFrame
+	_ = alice.ReadJSON(&frame) // presence as bob joined
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	_ = alice.ReadJSON(&frame)
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 99, Text: "y"}})
+	_ = alice.ReadJSON(&frame)
+	if frame.Type != "error" || frame.Data != "version_mismatch" {
+		t.Fatalf("expected version_mismatch, got %#v", frame)
+	}
+	_ = alice.ReadJSON(&frame) // authoritative doc after the rejected edit
+	if delta(editsSeries) != 1 || delta(otSeries) != 1 {
+		t.Fatalf("expected 1 edit and 1 OT error, got %v and %v", delta(editsSeries), delta(otSeries))
+	}
+	// Frames after the init are measured
+	if delta(framesSeries) != 2 {
+		t.Fatalf("expected the 2 edit frames to be measured, got %v", delta(framesSeries))
+	}
+
+	r, _ := hub.Get("room1")
+	h.runInSandbox(r, models.RunCmd{Language: models.LangPython, Code: "while True: pass"})
+	rec := httptest.NewRecorder()
+	h.RunOnce(rec, httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"language":"cpp","code":"int main(){}"}`)))
+	if delta(timeoutSeries) != 1 || delta(unavailSeries) != 1 {
+		t.Fatalf("expected timeout and unavailable runs, got %v and %v", delta(timeoutSeries), delta(unavailSeries))
+	}
+	if delta(startedSeries) != 1 {
+		t.Fatalf("expected 1 started python run, got %v", delta(startedSeries))
+	}
+
+	// Force-deleting the room releases its connections even though the
+	// sockets are still open; the later disconnects must not count twice.
+	h.handleSessionEnd("room1", "x = 1", models.LangPython, time.Minute)
+	if delta(roomsSeries) != 0 || delta(connsSeries) != 0 {
+		t.Fatalf("expected gauges back to baseline, got %v rooms and %v connections", delta(roomsSeries), delta(connsSeries))
+	}
+	alice.Close()
+	bob.Close()
+	time.Sleep(100 * time.Millisecond)
+	if delta(connsSeries) != 0 {
+		t.Fatalf("expected no double-counted disconnects, got %v", delta(connsSeries))
+	}
+}
+
+// fakeInteractive echoes stdin as stdout and exits on EOF or kill.
+type fakeInteractive struct {
+	frames chan models.WSFrame
+	once   sync.Once
+}
+
+func newFakeInteractive() *fakeInteractive {
+	return &fakeInteractive{frames: make(chan models.WSFrame, 16)}
+}
+
+func (f *fakeInteractive) exit(data map[string]any) {
+	f.once.Do(func() {
+		f.frames <- models.WSFrame{Type: "exit", Data: data}
+		close(f.frames)
+	})
+}
+
+func (f *fakeInteractive) WriteStdin(data string, eof bool) error {
+	if data != "" {
+		f.frames <- models.WSFrame{Type: "stdout", Data: data}
+	}
+	if eof {
+		f.exit(map[string]any{"code": 0, "timedOut": false})
+	}
+	return nil
+}
+
+func (f *fakeInteractive) Kill() error {
+	f.exit(map[string]any{"code": -1, "timedOut": false, "reason": "killed"})
+	return nil
+}
+
+func (f *fakeInteractive) Next() (models.WSFrame, error) {
+	frame, ok := <-f.frames
+	if !ok {
+		return models.WSFrame{}, errors.New("closed")
+	}
+	return frame, nil
+}
+
+func (f *fakeInteractive) Close() error { return nil }
+
+func TestCollabWSInteractiveRun(t *testing.T) {
+	sess := newFakeInteractive()
+	var gotLimits exec.SandboxLimits
+	runner := &mockRunner{startFn: func(_ context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.InteractiveSession, error) {
+		if lang != models.LangPython || code != "print(input())" {
+			t.Errorf("unexpected run: %s %q", lang, code)
+		}
+		gotLimits = limits
+		return sess, nil
+	}}
+	h, connect, expect := wsTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	alice, bob := connect("tok1"), connect("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "interactive_run", Data: models.RunCmd{Language: models.LangPython, Code: "print(input())"}})
+	var started models.InteractiveStarted
+	expect(alice, "interactive_started", &started)
+	expect(bob, "interactive_started", nil)
+	if started.UserID != "u1" || gotLimits.WallTime != interactiveWallTime {
+		t.Fatalf("unexpected start: %#v limits=%#v", started, gotLimits)
+	}
+
+	var errMsg string
+	_ = bob.WriteJSON(models.WSFrame{Type: "interactive_run", Data: models.RunCmd{Language: models.LangPython}})
+	expect(bob, "error", &errMsg)
+	if errMsg != "interactive_run_active" {
+		t.Fatalf("expected interactive_run_active, got %q", errMsg)
+	}
+
+	// Either participant can type; the partner sees the input and both see output
+	_ = bob.WriteJSON(models.WSFrame{Type: "interactive_stdin", Data: models.InteractiveStdin{Data: "5\n"}})
+	expect(bob, "interactive_stdout", nil)
+	seen := map[string]any{}
+	for i := 0; i < 2; i++ {
+		var frame models.WSFrame
+		_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := alice.ReadJSON(&frame); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		seen[frame.Type] = frame.Data
+	}
+	if out := seen["interactive_stdout"]; out != "5\n" {
+		t.Fatalf("expected stdout echo, got %#v", seen)
+	}
+	if in, _ := seen["interactive_stdin"].(map[string]any); in["userId"] != "u2" || in["data"] != "5\n" {
+		t.Fatalf("expected partner input echo, got %#v", seen)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "interactive_kill"})
+	var exit map[string]any
+	expect(alice, "interactive_exit", &exit)
+	expect(bob, "interactive_exit", nil)
+	if exit["reason"] != "killed" {
+		t.Fatalf("expected killed exit, got %#v", exit)
+	}
+
+	waitUntil(func() bool { return h.interactive.get("room1") == nil }, t)
+	_ = alice.WriteJSON(models.WSFrame{Type: "interactive_stdin", Data: models.InteractiveStdin{Data: "late"}})
+	expect(alice, "error", &errMsg)
+	if errMsg != "no_interactive_run" {
+		t.Fatalf("expected no_interactive_run, got %q", errMsg)
+	}
+}
+
+func TestCollabWSInteractiveRunStartFailure(t *testing.T) {
+	var attempts int32
+	var mu sync.Mutex
+	runner := &mockRunner{startFn: func(context.Context, models.Language, string, exec.SandboxLimits) (exec.InteractiveSession, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return nil, exec.ErrDockerUnavailable
+	}}
+	_, connect, expect := wsTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	alice := connect("tok1")
+
+	for i := 0; i < 2; i++ {
+		var errMsg string
+		_ = alice.WriteJSON(models.WSFrame{Type: "interactive_run", Data: models.RunCmd{Language: models.LangPython}})
+		expect(alice, "error", &errMsg)
+		if errMsg != "sandbox_unavailable" {
+			t.Fatalf("expected sandbox_unavailable, got %q", errMsg)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("a failed start must free the room for another run, attempts=%d", attempts)
+	}
+}
+
+// syncBuffer is a log sink safe to read while handlers are writing.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCollabWSRoomSettings(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, connect, expect := wsTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	var logs syncBuffer
+	h.log = utils.NewLoggerTo(&logs)
+	alice, bob := connect("tok1"), connect("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "settings_update", Data: map[string]any{"tabSize": 2, "keymap": "vim"}})
+	var changed models.SettingsChanged
+	expect(alice, "settings", &changed)
+	expect(bob, "settings", nil)
+	want := models.RoomSettings{TabSize: 2, AutoRunOnSave: false, Keymap: "vim", Theme: "light"}
+	if changed.Settings != want || changed.UpdatedBy != "u1" || strings.Join(changed.Changed, ",") != "keymap,tabSize" {
+		t.Fatalf("unexpected settings broadcast: %#v", changed)
+	}
+	if line := logs.String(); !strings.Contains(line, "Room settings changed roomId room1 userId u1 keymap vim tabSize 2") {
+		t.Fatalf("expected settings change in activity log, got %q", line)
+	}
+
+	var invalid models.InvalidSetting
+	_ = bob.WriteJSON(models.WSFrame{Type: "settings_update", Data: map[string]any{"tabSize": 3, "theme": "dark"}})
+	expect(bob, "error", &invalid)
+	if invalid.Error != "invalid_setting" || invalid.Key != "tabSize" || fmt.Sprint(invalid.Allowed) != "[2 4 8]" {
+		t.Fatalf("unexpected rejection: %#v", invalid)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "settings_update", Data: map[string]any{"fontSize": 14}})
+	expect(bob, "error", &invalid)
+	if invalid.Key != "fontSize" || fmt.Sprint(invalid.Allowed) != "[autoRunOnSave keymap tabSize theme]" {
+		t.Fatalf("unknown keys should list the known settings: %#v", invalid)
+	}
+
+	// Last writer wins
+	_ = bob.WriteJSON(models.WSFrame{Type: "settings_update", Data: map[string]any{"keymap": "emacs"}})
+	expect(bob, "settings", &changed)
+	expect(alice, "settings", nil)
+	if changed.Settings.Keymap != "emacs" || changed.Settings.TabSize != 2 || changed.UpdatedBy != "u2" {
+		t.Fatalf("unexpected settings after second update: %#v", changed)
+	}
+
+	// A restarted instance serves the persisted settings in init
+	rm.chatMu.Lock()
+	persisted := rm.settings["room1"]
+	rm.chatMu.Unlock()
+	if persisted != changed.Settings {
+		t.Fatalf("expected settings to be persisted, got %#v", persisted)
+	}
+	fresh := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), rm)
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", fresh.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/session/room1?token=tok1", nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}})
+	var init models.InitResponse
+	expect(conn, "init", &init)
+	if init.Settings != changed.Settings {
+		t.Fatalf("expected restored settings in init, got %#v", init.Settings)
+	}
+}
+
+func TestCollabWSRubricChecklist(t *testing.T) {
+	rm := &mockRoomManager{published: make(chan models.SessionEndedEvent, 1), reported: make(chan string, 1)}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	info := &models.RoomInfo{
+		MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2",
+		Question: &models.Question{ID: 7, Title: "Two Sum", Rubric: []string{"handles empty input", "O(n) time", "explains trade-offs"}},
+	}
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+	rm.getFn = func(string) (*models.RoomInfo, error) { return info, nil }
+
+	alice, init := dial("tok1")
+	bob, _ := dial("tok2")
+	if init.Rubric == nil || len(init.Rubric.Items) != 3 || init.Rubric.Unchecked != 3 {
+		t.Fatalf("expected an unchecked rubric in init, got %#v", init.Rubric)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "rubric_check", Data: models.RubricCheck{Index: 1, Checked: true}})
+	var state models.RubricState
+	expect(alice, "rubric", &state)
+	expect(bob, "rubric", nil)
+	item := state.Items[1]
+	if !item.Checked || item.ToggledBy != "u1" || item.ToggledAt == 0 || state.Unchecked != 2 {
+		t.Fatalf("unexpected rubric broadcast: %#v", state)
+	}
+
+	// Re-sending the same state is not broadcast; unknown items are rejected
+	_ = bob.WriteJSON(models.WSFrame{Type: "rubric_check", Data: models.RubricCheck{Index: 1, Checked: true}})
+	_ = bob.WriteJSON(models.WSFrame{Type: "rubric_check", Data: models.RubricCheck{Index: 3, Checked: true}})
+	var code string
+	expect(bob, "error", &code)
+	if code != "unknown_rubric_item" {
+		t.Fatalf("expected unknown_rubric_item, got %q", code)
+	}
+
+	// A restarted instance serves the persisted ticks in init
+	fresh := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), rm)
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", fresh.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/session/room1?token=tok2", nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}})
+	var restored models.InitResponse
+	expect(conn, "init", &restored)
+	if restored.Rubric == nil || !restored.Rubric.Items[1].Checked || restored.Rubric.Items[1].ToggledBy != "u1" {
+		t.Fatalf("expected restored rubric in init, got %#v", restored.Rubric)
+	}
+
+	// Ending the session is allowed with open items and reports the results
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	_ = bob.WriteJSON(models.WSFrame{Type: "end_session"})
+	select {
+	case event := <-rm.published:
+		if len(event.RubricResults) != 3 || !event.RubricResults[1].Checked || event.RubricResults[0].Checked {
+			t.Fatalf("unexpected rubric results: %#v", event.RubricResults)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a session_ended event")
+	}
+	select {
+	case outcome := <-rm.reported:
+		if outcome != models.OutcomeCompleted {
+			t.Fatalf("expected the session reported completed, got %q", outcome)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the session result to be reported")
+	}
+}
+
+func TestCollabWSRubricResetsOnReroll(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	info := &models.RoomInfo{
+		MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2",
+		Question: &models.Question{ID: 7, Rubric: []string{"a", "b", "c"}},
+	}
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+
+	alice, _ := dial("tok1")
+	_ = alice.WriteJSON(models.WSFrame{Type: "rubric_check", Data: models.RubricCheck{Index: 0, Checked: true}})
+	expect(alice, "rubric", nil)
+
+	h.handleRoomUpdate("room1", &models.RoomInfo{Question: &models.Question{ID: 8, Rubric: []string{"d", "e", "f"}}})
+	expect(alice, "question", nil)
+	var state models.RubricState
+	expect(alice, "rubric", &state)
+	if state.Unchecked != 3 || state.Items[0].Text != "d" || state.Items[0].Checked {
+		t.Fatalf("expected a fresh rubric after reroll, got %#v", state)
+	}
+}
+
+// debugHub builds rooms in varied states for the debug dump tests
+func debugHub(t *testing.T) (*Handlers, *session.Hub, http.Handler) {
+	t.Helper()
+	hub := session.NewHub()
+	h := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, hub, &mockRoomManager{})
+	h.SetAdminToken("admin-secret")
+
+	busy := hub.GetOrCreate("m1")
+	for _, user := range []string{"u1", "u2"} {
+		c := session.NewClient(nil)
+		c.UserID = user
+		busy.Join(c)
+	}
+	if ok, _, err := busy.ApplyEdit(models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "SECRET_DOC_TEXT"}); !ok {
+		t.Fatalf("edit failed: %v", err)
+	}
+	busy.RecordActivity("edit", "u1", "")
+	busy.RecordActivity("edit", "u1", "")
+	busy.AddChatMessage("u2", "SECRET_CHAT")
+	busy.RecordActivity("chat", "u2", "")
+	busy.BeginRun("u1")
+
+	abandoned := hub.GetOrCreate("m2")
+	c := session.NewClient(nil)
+	c.UserID = "u3"
+	abandoned.Join(c)
+	abandoned.Leave(c)
+	h.interactive.reserve("m2")
+
+	idle := hub.GetOrCreate("m3")
+	idle.SetLanguage(models.LangJava)
+
+	r := chi.NewRouter()
+	r.Get("/debug/rooms", h.DebugRooms)
+	r.Get("/debug/rooms/{matchId}", h.DebugRoom)
+	return h, hub, r
+}
+
+func debugGet(router http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDebugRoomsRequiresAdminToken(t *testing.T) {
+	_, _, router := debugHub(t)
+	for _, path := range []string{"/debug/rooms", "/debug/rooms/m1"} {
+		for _, token := range []string{"", "wrong"} {
+			if rec := debugGet(router, path, token); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%s with token %q: expected 401, got %d", path, token, rec.Code)
+			}
+		}
+	}
+
+	h := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), &mockRoomManager{})
+	h.SetAdminToken("")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/rooms", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	h.DebugRooms(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected endpoints to be disabled without a configured token, got %d", rec.Code)
+	}
+}
+
+func TestDebugRoomsDump(t *testing.T) {
+	_, _, router := debugHub(t)
+
+	rec := debugGet(router, "/debug/rooms", "admin-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{"SECRET_DOC_TEXT", "SECRET_CHAT", "admin-secret", "token"} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("dump leaks %q: %s", secret, body)
+		}
+	}
+
+	var dump models.HubDebug
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if dump.InstanceID != "abcd" || dump.TotalRooms != 3 || dump.TotalClients != 2 || dump.Goroutines <= 0 || len(dump.Rooms) != 3 {
+		t.Fatalf("unexpected aggregates: %+v", dump)
+	}
+
+	busy, abandoned, idle := dump.Rooms[0], dump.Rooms[1], dump.Rooms[2]
+	if busy.MatchID != "m1" || busy.ClientCount != 2 || len(busy.Clients) != 2 || busy.Clients[0].UserID != "u1" ||
+		busy.DocBytes != len("SECRET_DOC_TEXT") || busy.DocVersion != 1 || !busy.Run.Active || busy.InstanceID != "abcd" {
+		t.Fatalf("unexpected busy room: %+v", busy)
+	}
+	if abandoned.ClientCount != 0 || !abandoned.Timer.SessionEndPending || abandoned.Timer.DisconnectedAt == nil || abandoned.Run.Interactive != "starting" {
+		t.Fatalf("unexpected abandoned room: %+v", abandoned)
+	}
+	if idle.Language != models.LangJava || idle.Run.Active || idle.Timer.SessionEndPending || idle.LastActivity == 0 {
+		t.Fatalf("unexpected idle room: %+v", idle)
+	}
+}
+
+func TestDebugRoomsGzip(t *testing.T) {
+	_, _, router := debugHub(t)
+
+	rec := debugGet(router, "/debug/rooms?gzip=true", "admin-secret")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip response, got %d %v", rec.Code, rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("not gzip: %v", err)
+	}
+	var dump models.HubDebug
+	if err := json.NewDecoder(gz).Decode(&dump); err != nil || dump.TotalRooms != 3 {
+		t.Fatalf("unexpected gzip dump %+v err=%v", dump, err)
+	}
+}
+
+func TestDebugRoomActivity(t *testing.T) {
+	_, _, router := debugHub(t)
+
+	if rec := debugGet(router, "/debug/rooms/missing", "admin-secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown room, got %d", rec.Code)
+	}
+
+	rec := debugGet(router, "/debug/rooms/m1", "admin-secret")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "SECRET") {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	var detail models.RoomDebugDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	var types []string
+	for _, entry := range detail.Activity {
+		types = append(types, fmt.Sprintf("%s:%s:%d", entry.Type, entry.UserID, entry.Count))
+	}
+	if detail.MatchID != "m1" || strings.Join(types, ",") != "join:u1:1,join:u2:1,edit:u1:2,chat:u2:1" {
+		t.Fatalf("unexpected detail %+v activity %v", detail.RoomDebug, types)
+	}
+}
+
+func TestDebugRoomsDuringConcurrentEdits(t *testing.T) {
+	_, hub, router := debugHub(t)
+	room, _ := hub.Get("m1")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			doc, _ := room.Snapshot()
+			room.ApplyEdit(models.Edit{BaseVersion: doc.Version, RangeStart: 0, RangeEnd: 0, Text: "x"})
+			room.RecordActivity("edit", "u1", "")
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if rec := debugGet(router, "/debug/rooms", "admin-secret"); rec.Code != http.StatusOK {
+			t.Fatalf("dump failed: %d", rec.Code)
+		}
+		if rec := debugGet(router, "/debug/rooms/m1", "admin-secret"); rec.Code != http.StatusOK {
+			t.Fatalf("room dump failed: %d", rec.Code)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestCollabWSRunStatusAndPresenceAfterReconnect(t *testing.T) {
+	release := make(chan struct{})
+	runner := &mockRunner{
+		runStreamFn: func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error) {
+			<-release
+			return []models.WSFrame{
+				{Type: "stdout", Data: "done\n"},
+				{Type: "exit", Data: map[string]any{"code": 0, "timedOut": false}},
+			}, nil
+		},
+	}
+	h, dial := serveTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, init := dial("tok2")
+	if init.Presence.Run != nil || len(init.Presence.Typing) != 0 {
+		t.Fatalf("expected idle presence, got %+v", init.Presence)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print('done')"}})
+	var status models.RunStatus
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		expect(conn, "run_reset", nil)
+		expect(conn, "run_status", &status)
+		if status != (models.RunStatus{State: models.RunStarted, By: "u1"}) {
+			t.Fatalf("unexpected run status: %+v", status)
+		}
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "typing", Data: models.Typing{Active: true}})
+	var typing models.Typing
+	expect(alice, "typing", &typing)
+	if typing != (models.Typing{UserID: "u2", Active: true}) {
+		t.Fatalf("unexpected typing frame: %+v", typing)
+	}
+
+	// Alice drops mid-run and reconnects: the indicators come with init
+	alice.Close()
+	room := h.hub.GetOrCreate("room1")
+	deadline := time.Now().Add(2 * time.Second)
+	for room.GetClientCount() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	alice, init = dial("tok1")
+	if init.Presence.Run == nil || *init.Presence.Run != (models.RunStatus{State: models.RunStarted, By: "u1"}) {
+		t.Fatalf("expected run in progress on init, got %+v", init.Presence.Run)
+	}
+	if len(init.Presence.Typing) != 1 || init.Presence.Typing[0] != "u2" {
+		t.Fatalf("expected u2 typing on init, got %+v", init.Presence.Typing)
+	}
+	expect(alice, "run_reset", nil) // replayed history carries no run_status
+	expect(bob, "peer_disconnected", nil)
+	expect(bob, "peer_rejoined", nil)
+
+	close(release)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		expect(conn, "stdout", nil)
+		expect(conn, "exit", nil)
+		expect(conn, "run_status", &status)
+		if status != (models.RunStatus{State: models.RunFinished, By: "u1"}) {
+			t.Fatalf("unexpected run status: %+v", status)
+		}
+	}
+	if p := room.Presence("u1"); p.Run != nil {
+		t.Fatalf("expected no run after it finished, got %+v", p.Run)
+	}
+}
+
+func TestCollabWSPresenceFrames(t *testing.T) {
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, init := dial("tok1")
+	if !reflect.DeepEqual(init.Presence.Connected, []string{"u1"}) {
+		t.Fatalf("expected only alice connected, got %v", init.Presence.Connected)
+	}
+
+	// The late joiner learns who is there from init, the others from a frame
+	bob, init := dial("tok2")
+	if !reflect.DeepEqual(init.Presence.Connected, []string{"u1", "u2"}) {
+		t.Fatalf("expected both connected in bob's init, got %v", init.Presence.Connected)
+	}
+	var update models.PresenceUpdate
+	expect(alice, "presence", &update)
+	if !reflect.DeepEqual(update.Connected, []string{"u1", "u2"}) || update.At == 0 {
+		t.Fatalf("unexpected presence on join: %+v", update)
+	}
+
+	bob.Close()
+	expect(alice, "presence", &update)
+	if !reflect.DeepEqual(update.Connected, []string{"u1"}) {
+		t.Fatalf("unexpected presence on leave: %+v", update)
+	}
+}
+
+func TestCollabWSResumeReplaysOnlyWhatWasMissed(t *testing.T) {
+	runner := &mockRunner{
+		runStreamFn: func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error) {
+			return []models.WSFrame{
+				{Type: "stdout", Data: "one\n"},
+				{Type: "stdout", Data: "two\n"},
+				{Type: "exit", Data: map[string]any{"code": 0, "timedOut": false}},
+			}, nil
+		},
+	}
+	h, dial, resume := serveResumableRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: "before"}})
+	expect(alice, "chat_ack", nil)
+	expect(bob, "chat", nil)
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(1)"}})
+	var reset models.RunReset
+	expect(alice, "run_reset", &reset)
+	expect(alice, "run_status", nil)
+	expect(alice, "stdout", nil)
+
+	// Alice drops having seen the run up to its first line; bob chats meanwhile
+	alice.Close()
+	room := h.hub.GetOrCreate("room1")
+	deadline := time.Now().Add(2 * time.Second)
+	for room.GetClientCount() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: "while away"}})
+	for {
+		var frame models.WSFrame
+		_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := bob.ReadJSON(&frame); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if frame.Type == "chat_ack" {
+			break
+		}
+	}
+
+	doc, _ := room.Snapshot()
+	alice, init := resume("tok1", models.ResumeMarkers{DocVersion: doc.Version, RunSeq: reset.Seq, RunFrame: 2, ChatID: 1})
+	if init.Doc != doc {
+		t.Fatalf("expected the doc snapshot, got %+v", init.Doc)
+	}
+	var out string
+	expect(alice, "stdout", &out)
+	if out != "two\n" {
+		t.Fatalf("expected only the missed output, got %q", out)
+	}
+	expect(alice, "exit", nil)
+	var msg models.ChatMessage
+	expect(alice, "chat", &msg)
+	if msg.ID != 2 || msg.Message != "while away" {
+		t.Fatalf("expected only the missed chat message, got %+v", msg)
+	}
+
+	var back map[string]string
+	expect(bob, "peer_rejoined", nil)
+	expect(bob, "peer_reconnected", &back)
+	if back["userId"] != "u1" {
+		t.Fatalf("unexpected peer_reconnected: %+v", back)
+	}
+
+	// A resume from before the run replays all of it
+	alice.Close()
+	for room.GetClientCount() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	alice, _ = resume("tok1", models.ResumeMarkers{ChatID: 2})
+	for _, typ := range []string{"run_reset", "stdout", "stdout", "exit"} {
+		expect(alice, typ, nil)
+	}
+}
+
+func roomRunRequest(t *testing.T, h *Handlers, matchID, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(models.RunRequest{Language: models.LangPython, Code: "print('hi')", MatchID: matchID})
+	req := httptest.NewRequest(http.MethodPost, "/run", bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.RunOnce(rec, req)
+	return rec
+}
+
+func helloRunner(release <-chan struct{}) *mockRunner {
+	return &mockRunner{
+		runStreamFn: func(context.Context, models.Language, string, exec.SandboxLimits) ([]models.WSFrame, error) {
+			if release != nil {
+				<-release
+			}
+			return []models.WSFrame{
+				{Type: "stdout", Data: "hi\n"},
+				{Type: "stderr", Data: "warn\n"},
+				{Type: "exit", Data: map[string]any{"code": 3, "timedOut": false}},
+			}, nil
+		},
+	}
+}
+
+func TestRunOnceInRoomStreamsToPartner(t *testing.T) {
+	h, dial := serveTestRoom(t, &mockRoomManager{}, helloRunner(nil), make(chan time.Time))
+	expect := expectFrame(t)
+	bob, _ := dial("tok2")
+
+	rec := roomRunRequest(t, h, "room1", "tok1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	var result models.RunResult
+	_ = json.Unmarshal(rec.Body.Bytes(), &result)
+	if !reflect.DeepEqual(result, models.RunResult{Stdout: "hi\n", Stderr: "warn\n", Exit: 3}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	var status models.RunStatus
+	expect(bob, "run_reset", nil)
+	expect(bob, "run_status", &status)
+	if status.By != "u1" || status.State != models.RunStarted {
+		t.Fatalf("unexpected run status: %+v", status)
+	}
+	var out string
+	expect(bob, "stdout", &out)
+	if out != "hi\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+	expect(bob, "stderr", nil)
+	expect(bob, "exit", nil)
+	expect(bob, "run_status", &status)
+	if status.State != models.RunFinished {
+		t.Fatalf("expected run to finish, got %+v", status)
+	}
+
+	room, _ := h.hub.Get("room1")
+	var counted bool
+	for _, entry := range room.Activity() {
+		counted = counted || (entry.Type == "run" && entry.UserID == "u1")
+	}
+	if !counted {
+		t.Fatalf("expected the REST run in u1's activity, got %+v", room.Activity())
+	}
+}
+
+func TestRunResultFromFramesCarriesUsage(t *testing.T) {
+	usage := models.RunUsage{DurationMs: 120, PeakMemoryBytes: 14 << 20}
+	result := runResultFromFrames([]models.WSFrame{
+		{Type: "stdout", Data: "hi\n"},
+		{Type: "usage", Data: usage},
+		{Type: "exit", Data: map[string]any{"code": 0, "timedOut": false}},
+	})
+	if result.Usage == nil || *result.Usage != usage || result.Stdout != "hi\n" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRunResultFromFramesMarksTruncation(t *testing.T) {
+	result := runResultFromFrames([]models.WSFrame{
+		{Type: "stdout", Data: "yyyy"},
+		{Type: "truncated", Data: map[string]any{"limitBytes": 4}},
+		{Type: "exit", Data: map[string]any{"code": 137, "timedOut": false}},
+	})
+	if !result.OutputTruncated || result.Stdout != "yyyy" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestCancelRunStopsRoomRun(t *testing.T) {
+	started := make(chan struct{}, 1)
+	runner := &mockRunner{
+		runStreamFn: func(ctx context.Context, _ models.Language, _ string, _ exec.SandboxLimits) ([]models.WSFrame, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	_, dial := serveTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "cancel_run"})
+	var msg string
+	expect(bob, "error", &msg)
+	if msg != "no_run_in_progress" {
+		t.Fatalf("expected no_run_in_progress, got %q", msg)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "input()"}})
+	expect(alice, "run_reset", nil)
+	expect(alice, "run_status", nil)
+	<-started
+
+	// A second run waits for the first
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(2)"}})
+	var status models.RunStatus
+	expect(alice, "run_status", &status)
+	if status.State != models.RunQueued {
+		t.Fatalf("expected the run to be queued, got %+v", status)
+	}
+
+	// Either participant may stop the run
+	_ = bob.WriteJSON(models.WSFrame{Type: "cancel_run"})
+	var cancelled models.RunCancelled
+	expect(alice, "run_cancelled", &cancelled)
+	if cancelled.By != "u2" {
+		t.Fatalf("expected the run cancelled by u2, got %+v", cancelled)
+	}
+	var exit map[string]any
+	expect(alice, "exit", &exit)
+	if exit["code"] != float64(-1) || exit["timedOut"] != false || exit["cancelled"] != true {
+		t.Fatalf("expected a cancelled exit, got %v", exit)
+	}
+	expect(alice, "run_status", &status)
+	if status.State != models.RunFinished {
+		t.Fatalf("expected the run to finish, got %+v", status)
+	}
+
+	// The queued run goes next, and can be stopped the same way
+	expect(alice, "run_reset", nil)
+	expect(alice, "run_status", nil)
+	<-started
+	_ = alice.WriteJSON(models.WSFrame{Type: "cancel_run"})
+	expect(alice, "run_cancelled", &cancelled)
+	expect(alice, "exit", &exit)
+	expect(alice, "run_status", &status)
+}
+
+func TestCollabWSRunOutputArrivesIncrementally(t *testing.T) {
+	step := make(chan struct{})
+	runner := &mockRunner{
+		runEmitFn: func(_ context.Context, _ models.Language, _ string, _ exec.SandboxLimits, emit func(models.WSFrame)) error {
+			emit(models.WSFrame{Type: "stdout", Data: "1\n"})
+			<-step
+			emit(models.WSFrame{Type: "stdout", Data: "2\n"})
+			<-step
+			emit(models.WSFrame{Type: "exit", Data: map[string]any{"code": 0, "timedOut": false}})
+			return nil
+		},
+	}
+	_, dial := serveTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(1); print(2)"}})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		expect(conn, "run_reset", nil)
+		expect(conn, "run_status", nil)
+	}
+	// Each chunk reaches both participants while the program still runs
+	for _, want := range []string{"1\n", "2\n"} {
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			var out string
+			expect(conn, "stdout", &out)
+			if out != want {
+				t.Fatalf("expected stdout %q, got %q", want, out)
+			}
+		}
+		step <- struct{}{}
+	}
+	var status models.RunStatus
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		expect(conn, "exit", nil)
+		expect(conn, "run_status", &status)
+		if status.State != models.RunFinished {
+			t.Fatalf("expected the run to finish, got %+v", status)
+		}
+	}
+}
+
+func TestRunResultFromFramesMarksCancellation(t *testing.T) {
+	result := runResultFromFrames([]models.WSFrame{
+		{Type: "exit", Data: map[string]any{"code": -1, "timedOut": false, "cancelled": true}},
+	})
+	if !result.Cancelled || result.Exit != -1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRunResultFromFramesMarksOOMKill(t *testing.T) {
+	result := runResultFromFrames([]models.WSFrame{
+		{Type: "error", Data: "Your program ran out of memory and was stopped."},
+		{Type: "exit", Data: map[string]any{"code": 137, "timedOut": false, "oomKilled": true}},
+	})
+	if !result.OOMKilled || result.Exit != 137 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRunResultFromFramesKeepsCompileOutput(t *testing.T) {
+	result := runResultFromFrames([]models.WSFrame{
+		{Type: "compile_error", Data: "main.cpp:1: error: expected ';'\n"},
+		{Type: "exit", Data: map[string]any{"code": 1, "timedOut": false}},
+	})
+	if result.CompileOutput != "main.cpp:1: error: expected ';'\n" || result.Stderr != "" || result.Exit != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRunOnceInRoomSharesInProgressGuard(t *testing.T) {
+	release := make(chan struct{}) // one send lets one run finish
+	h, dial := serveTestRoom(t, &mockRoomManager{}, helloRunner(release), make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print('hi')"}})
+	expect(alice, "run_reset", nil)
+	expect(alice, "run_status", nil)
+
+	// A REST run while the WS run is going is rejected...
+	if rec := roomRunRequest(t, h, "room1", "tok2"); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "run_in_progress") {
+		t.Fatalf("expected 409 run_in_progress, got %d %s", rec.Code, rec.Body.String())
+	}
+	release <- struct{}{}
+	for _, typ := range []string{"stdout", "stderr", "exit", "run_status"} {
+		expect(alice, typ, nil)
+	}
+
+	// ...while a WS run waits for a REST run to finish
+	done := make(chan int)
+	go func() { done <- roomRunRequest(t, h, "room1", "tok2").Code }()
+	expect(alice, "run_reset", nil)
+	expect(alice, "run_status", nil)
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print('hi')"}})
+	var status models.RunStatus
+	expect(alice, "run_status", &status)
+	if status.State != models.RunQueued {
+		t.Fatalf("expected the WS run to be queued, got %+v", status)
+	}
+	release <- struct{}{}
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected the REST run to complete, got %d", code)
+	}
+	for _, typ := range []string{"stdout", "stderr", "exit", "run_status", "run_reset", "run_status"} {
+		expect(alice, typ, nil)
+	}
+	release <- struct{}{}
+	for _, typ := range []string{"stdout", "stderr", "exit", "run_status"} {
+		expect(alice, typ, nil)
+	}
+}
+
+func TestCollabWSRunQueue(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var ran []string
+	runner := &mockRunner{
+		runStreamFn: func(_ context.Context, _ models.Language, code string, _ exec.SandboxLimits) ([]models.WSFrame, error) {
+			mu.Lock()
+			ran = append(ran, code)
+			mu.Unlock()
+			<-release
+			return []models.WSFrame{{Type: "exit", Data: map[string]any{"code": 0, "timedOut": false}}}, nil
+		},
+	}
+	h, dial := serveTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	h.hub.SetRoomOptions(session.WithRunCooldown(300 * time.Millisecond))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+
+	for _, code := range []string{"print(1)", "print(2)", "print(3)"} {
+		_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: code}})
+	}
+	expect(alice, "run_reset", nil)
+	var status models.RunStatus
+	expect(alice, "run_status", &status)
+	if status.State != models.RunStarted {
+		t.Fatalf("expected the first run to start, got %+v", status)
+	}
+	for i := 0; i < 2; i++ {
+		expect(alice, "run_status", &status)
+		if status.State != models.RunQueued {
+			t.Fatalf("expected the later runs to be queued, got %+v", status)
+		}
+	}
+
+	release <- struct{}{}
+	for _, typ := range []string{"exit", "run_status", "run_reset", "run_status"} {
+		expect(alice, typ, nil)
+	}
+	release <- struct{}{}
+	expect(alice, "exit", nil)
+	expect(alice, "run_status", nil)
+
+	// Straight after a run the next one has to wait out the cooldown
+	_ = alice.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(4)"}})
+	var msg string
+	expect(alice, "error", &msg)
+	if msg != "run_cooldown" {
+		t.Fatalf("expected run_cooldown, got %q", msg)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != 2 || ran[0] != "print(1)" || ran[1] != "print(3)" {
+		t.Fatalf("expected the first run and the latest queued one, got %q", ran)
+	}
+}
+
+func TestRunOnceInRoomRejectsBadTokens(t *testing.T) {
+	h, _ := serveTestRoom(t, &mockRoomManager{}, helloRunner(nil), make(chan time.Time))
+
+	if rec := roomRunRequest(t, h, "room1", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	if rec := roomRunRequest(t, h, "room1", "bogus"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for invalid token, got %d", rec.Code)
+	}
+	if rec := roomRunRequest(t, h, "room2", "tok1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another room, got %d", rec.Code)
+	}
+	if _, ok := h.hub.Get("room2"); ok {
+		t.Fatal("a rejected run must not create a room")
+	}
+}
+
+func TestRunOnceInEmptyRoomIsReplayedOnJoin(t *testing.T) {
+	h, dial := serveTestRoom(t, &mockRoomManager{}, helloRunner(nil), make(chan time.Time))
+	expect := expectFrame(t)
+
+	if rec := roomRunRequest(t, h, "room1", "tok1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	alice, _ := dial("tok1")
+	for _, typ := range []string{"run_reset", "stdout", "stderr", "exit"} {
+		expect(alice, typ, nil)
+	}
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) models.ErrorResponse {
+	t.Helper()
+	var resp models.ErrorResponse
+	decodeBody(t, rec.Body, &resp)
+	return resp
+}
+
+func TestHTTPErrorsCarryCodeAndMessage(t *testing.T) {
+	rm := &mockRoomManager{validateFn: func(string) (*models.RoomInfo, error) { return &models.RoomInfo{MatchId: "m1"}, nil }}
+	h := newTestHandlers(&mockRunner{}, rm)
+	reroll := func(acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/m1/reroll", nil)
+		req = req.WithContext(addMatchID(req.Context(), "m1"))
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rec := httptest.NewRecorder()
+		h.RerollQuestion(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{room_management.ErrNoRerolls, http.StatusBadRequest, "no_rerolls"},
+		{room_management.ErrNoAlternativeQuestion, http.StatusConflict, "no_alternative_question"},
+		{errors.New("redis: connection refused"), http.StatusInternalServerError, "reroll_failed"},
+	}
+	for _, tc := range cases {
+		rm.rerollFn = func(string) (*models.RoomInfo, error) { return nil, tc.err }
+		rec := reroll("de-DE, en;q=0.5")
+		resp := decodeError(t, rec)
+		if rec.Code != tc.status || resp.Code != tc.code || resp.Message != i18n.Message("en", tc.code) || resp.Detail != "" {
+			t.Fatalf("%v: unexpected %d %+v", tc.err, rec.Code, resp)
+		}
+		if strings.Contains(rec.Body.String(), tc.err.Error()) {
+			t.Fatalf("internal error text leaked: %s", rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.RunOnce(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/run", strings.NewReader("{")))
+	if resp := decodeError(t, rec); rec.Code != http.StatusBadRequest || resp.Code != "invalid_request" || resp.Message == "" || resp.Detail != "" {
+		t.Fatalf("unexpected invalid JSON response %d %+v", rec.Code, resp)
+	}
+}
+
+func TestHTTPErrorDetailOnlyInDebugMode(t *testing.T) {
+	rm := &mockRoomManager{
+		validateFn: func(string) (*models.RoomInfo, error) { return &models.RoomInfo{MatchId: "m1"}, nil },
+		rerollFn:   func(string) (*models.RoomInfo, error) { return nil, errors.New("redis: connection refused") },
+	}
+	h := newTestHandlers(&mockRunner{}, rm)
+	h.debugErrors = true
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/m1/reroll", nil)
+	req = req.WithContext(addMatchID(req.Context(), "m1"))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	h.RerollQuestion(rec, req)
+	if resp := decodeError(t, rec); resp.Code != "reroll_failed" || resp.Detail != "redis: connection refused" {
+		t.Fatalf("expected the cause as detail in debug mode, got %+v", resp)
+	}
+}
+
+func TestErrorCodeHidesUnknownErrors(t *testing.T) {
+	if got := errorCode(session.ErrRunInProgress); got != "run_in_progress" {
+		t.Fatalf("expected a session sentinel to keep its code, got %q", got)
+	}
+	if got := errorCode(errors.New("dial tcp 10.0.0.3:6379: i/o timeout")); got != i18n.GenericCode {
+		t.Fatalf("expected the generic code for an internal error, got %q", got)
+	}
+}
+
+func TestWSErrorFramesCarryCodeAndMessage(t *testing.T) {
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", Token1: "tok1",
+		Question: &models.Question{Execution: &models.ExecutionConfig{AllowedLanguages: []models.Language{models.LangPython}}}}
+	rm := &mockRoomManager{validateFn: func(string) (*models.RoomInfo, error) { return room, nil }}
+	h := newTestHandlers(&mockRunner{}, rm)
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", h.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/session/room1?token=tok1&lang=xx", nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: map[string]any{"language": "python"}})
+	expect := expectFrame(t)
+	expect(conn, "init", nil)
+
+	for _, tc := range []struct {
+		frame models.WSFrame
+		code  string
+	}{
+		{models.WSFrame{Type: "bogus"}, "unknown_type"},
+		{models.WSFrame{Type: "language", Data: models.LanguageChange{Language: models.LangJava}}, "language_not_allowed"},
+		{models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangJava}}, "language_not_allowed"},
+	} {
+		_ = conn.WriteJSON(tc.frame)
+		var frame models.WSFrame
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		// an unavailable locale falls back to English
+		if frame.Type != "error" || frame.Data != tc.code || frame.Code != tc.code || frame.Message != i18n.Message("en", tc.code) || frame.Detail != "" {
+			t.Fatalf("%s: unexpected frame %#v", tc.frame.Type, frame)
+		}
+	}
+}
+
+// readDocFrame reads until a doc or doc_delta frame, unpacking binary
+// messages, and reports whether it arrived as a binary message.
+func readDocFrame(t *testing.T, conn *websocket.Conn) (models.WSFrame, bool) {
+	t.Helper()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		msgType, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		binary := msgType == websocket.BinaryMessage
+		if binary {
+			zr, err := gzip.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				t.Fatalf("binary frame is not gzip: %v", err)
+			}
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(zr); err != nil {
+				t.Fatalf("gunzip: %v", err)
+			}
+			payload = buf.Bytes()
+		}
+		var frame models.WSFrame
+		if err := json.Unmarshal(payload, &frame); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		if frame.Type == "doc" || frame.Type == "doc_delta" {
+			return frame, binary
+		}
+		if binary {
+			t.Fatalf("non-doc frame %q sent as binary", frame.Type)
+		}
+	}
+}
+
+func applyDocDelta(t *testing.T, text string, d models.DocDelta) string {
+	t.Helper()
+	runes := []rune(text)
+	if d.RangeStart < 0 || d.RangeEnd < d.RangeStart || d.RangeEnd > len(runes) {
+		t.Fatalf("delta range [%d,%d) outside doc of %d runes", d.RangeStart, d.RangeEnd, len(runes))
+	}
+	return string(runes[:d.RangeStart]) + d.Text + string(runes[d.RangeEnd:])
+}
+
+func TestCollabWSNegotiatesDocCapabilities(t *testing.T) {
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+
+	_, newInit := dial("tok1", session.CapDocDelta, session.CapDocBinary, "doc_telepathy")
+	if newInit.ProtocolVersion != 2 || !reflect.DeepEqual(newInit.Capabilities, []string{"doc_binary", "doc_delta"}) {
+		t.Fatalf("unexpected negotiation: version=%d caps=%v", newInit.ProtocolVersion, newInit.Capabilities)
+	}
+	_, oldInit := dial("tok2")
+	if oldInit.ProtocolVersion != 1 || len(oldInit.Capabilities) != 0 {
+		t.Fatalf("old client must get protocol 1 without capabilities: version=%d caps=%v", oldInit.ProtocolVersion, oldInit.Capabilities)
+	}
+}
+
+func TestCollabWSDocDeltaInMixedRoom(t *testing.T) {
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+	alice, init := dial("tok1", session.CapDocDelta)
+	bob, _ := dial("tok2")
+
+	text, version := init.Doc.Text, init.Doc.Version
+	edits := []models.Edit{
+		{RangeStart: 0, RangeEnd: 0, Text: "def añadir(a, b):\n    return a + b\n"},
+		{RangeStart: 4, RangeEnd: 10, Text: "sumar"},
+		{RangeStart: 0, RangeEnd: 0, Text: "# 合計\n"},
+	}
+	for i, e := range edits {
+		e.BaseVersion = version
+		_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: e})
+
+		ack, binary := readDocFrame(t, alice)
+		if ack.Type != "doc_delta" || binary {
+			t.Fatalf("edit %d: expected a text doc_delta ack, got %s (binary=%v)", i, ack.Type, binary)
+		}
+		var delta models.DocDelta
+		marshal(ack.Data, &delta)
+		if delta.BaseVersion != version {
+			t.Fatalf("edit %d: delta base %d, client is at %d", i, delta.BaseVersion, version)
+		}
+		text = applyDocDelta(t, text, delta)
+		version = delta.Version
+
+		full, _ := readDocFrame(t, bob)
+		if full.Type != "doc" {
+			t.Fatalf("edit %d: old client must get full docs, got %s", i, full.Type)
+		}
+		var doc models.DocState
+		marshal(full.Data, &doc)
+		if doc.Text != text || doc.Version != version {
+			t.Fatalf("edit %d: delta gave %q@%d, full doc is %q@%d", i, text, version, doc.Text, doc.Version)
+		}
+		if delta.Hash != session.DocHash(doc.Text) {
+			t.Fatalf("edit %d: delta hash does not match the document", i)
+		}
+	}
+}
+
+func TestCollabWSBinaryDocFrames(t *testing.T) {
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+	alice, init := dial("tok1", session.CapDocBinary)
+
+	large := strings.Repeat("print('hello world')\n", 500)
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: init.Doc.Version, Text: large}})
+	frame, binary := readDocFrame(t, alice)
+	if frame.Type != "doc" || !binary {
+		t.Fatalf("expected a binary doc frame, got %s (binary=%v)", frame.Type, binary)
+	}
+	var doc models.DocState
+	marshal(frame.Data, &doc)
+	if doc.Text != large+init.Doc.Text {
+		t.Fatalf("binary doc does not carry the document")
+	}
+}
+
+func TestCollabWSResyncSendsFullDoc(t *testing.T) {
+	const series = `collab_doc_resyncs_total{reason="hash_mismatch"}`
+	before := scrapeMetric(t, series)
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+	alice, init := dial("tok1", session.CapDocDelta)
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: init.Doc.Version, Text: "x = 1\n"}})
+	if frame, _ := readDocFrame(t, alice); frame.Type != "doc_delta" {
+		t.Fatalf("expected doc_delta, got %s", frame.Type)
+	}
+
+	// the client computed another hash: it asks for the whole document
+	_ = alice.WriteJSON(models.WSFrame{Type: "resync", Data: models.Resync{Reason: "hash_mismatch"}})
+	frame, _ := readDocFrame(t, alice)
+	if frame.Type != "doc" {
+		t.Fatalf("resync must answer with a full doc, got %s", frame.Type)
+	}
+	var doc models.DocState
+	marshal(frame.Data, &doc)
+	if doc.Text != "x = 1\n"+init.Doc.Text || doc.Version != init.Doc.Version+1 {
+		t.Fatalf("unexpected resync doc: %+v", doc)
+	}
+	if got := scrapeMetric(t, series) - before; got != 1 {
+		t.Fatalf("expected one hash_mismatch resync recorded, got %v", got)
+	}
+}
+
+func TestWSCompressionFromEnv(t *testing.T) {
+	enabled, minBytes := wsCompressionFromEnv()
+	if !enabled || minBytes != defaultWSCompressionMinBytes {
+		t.Fatalf("defaults: enabled=%v min=%d", enabled, minBytes)
+	}
+	t.Setenv("COLLAB_WS_COMPRESSION", "false")
+	t.Setenv("COLLAB_WS_COMPRESSION_MIN_BYTES", "256")
+	enabled, minBytes = wsCompressionFromEnv()
+	if enabled || minBytes != 256 {
+		t.Fatalf("from env: enabled=%v min=%d", enabled, minBytes)
+	}
+	h := newTestHandlers(&mockRunner{}, &mockRoomManager{})
+	if h.upgrader().EnableCompression {
+		t.Fatalf("upgrader must not offer compression when disabled")
+	}
+}
+
+func TestCollabWSTurnTaking(t *testing.T) {
+	rm := &mockRoomManager{}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, init := dial("tok1")
+	if init.Turns.Mode != models.ModeFree {
+		t.Fatalf("expected a new room in free mode, got %+v", init.Turns)
+	}
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "mode_set", Data: models.ModeSet{Mode: models.ModeTurns, TurnDurationSec: 120}})
+	var req models.ModeRequest
+	expect(alice, "mode_pending", nil)
+	expect(bob, "mode_request", &req)
+	if req.UserID != "u1" || req.Mode != models.ModeTurns || req.TurnDurationSec != 120 {
+		t.Fatalf("unexpected mode request: %+v", req)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "mode_confirm", Data: models.ModeConfirm{Accept: true}})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var turn models.TurnChanged
+		expect(conn, "turn_changed", &turn)
+		if turn.ActiveUser != "u1" || turn.Reason != "start" || turn.EndsAt == 0 {
+			t.Fatalf("unexpected first turn: %+v", turn)
+		}
+		var state models.TurnState
+		expect(conn, "mode_changed", &state)
+		if state.Mode != models.ModeTurns || state.ActiveUser != "u1" {
+			t.Fatalf("unexpected mode: %+v", state)
+		}
+	}
+
+	// the observer can neither edit nor run, but still chats
+	var errMsg string
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x"}})
+	expect(bob, "error", &errMsg)
+	if errMsg != "not_your_turn" {
+		t.Fatalf("expected not_your_turn, got %q", errMsg)
+	}
+	expect(bob, "doc", nil)
+	_ = bob.WriteJSON(models.WSFrame{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(1)"}})
+	expect(bob, "error", &errMsg)
+	if errMsg != "not_your_turn" {
+		t.Fatalf("expected not_your_turn for run, got %q", errMsg)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: "why a map?"}})
+	expect(bob, "chat_ack", nil)
+	expect(alice, "chat", nil)
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "turn_pass"})
+	expect(bob, "error", &errMsg)
+	if errMsg != "not_your_turn" {
+		t.Fatalf("only the active user may pass, got %q", errMsg)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "turn_pass"})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var turn models.TurnChanged
+		expect(conn, "turn_changed", &turn)
+		if turn.ActiveUser != "u2" || turn.Reason != "pass" {
+			t.Fatalf("unexpected turn after pass: %+v", turn)
+		}
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x"}})
+	expect(bob, "doc", nil)
+
+	// the turn survives a restart of the instance
+	if saved := rm.turns["room1"]; saved.ActiveUser != "u2" || saved.Mode != models.ModeTurns {
+		t.Fatalf("expected the turn to be persisted, got %+v", saved)
+	}
+	_, redial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	_, init = redial("tok1")
+	if init.Turns.Mode != models.ModeTurns || init.Turns.ActiveUser != "u2" || init.Turns.DrivingMs == nil {
+		t.Fatalf("expected the restored turn in init, got %+v", init.Turns)
+	}
+}
+
+type mockHinter struct {
+	hintFn func(context.Context, models.Language, string, *models.Question, string) (string, error)
+}
+
+func (m *mockHinter) Hint(ctx context.Context, lang models.Language, code string, question *models.Question, discussion string) (string, error) {
+	return m.hintFn(ctx, lang, code, question, discussion)
+}
+
+func TestCollabWSThreads(t *testing.T) {
+	rm := &mockRoomManager{published: make(chan models.SessionEndedEvent, 1)}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	info := &models.RoomInfo{
+		MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2",
+		Question: &models.Question{ID: 7, Title: "Two Sum", PromptMarkdown: "find two numbers"},
+	}
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+	rm.getFn = func(string) (*models.RoomInfo, error) { return info, nil }
+
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "thread_create", Data: models.ThreadCreate{Title: "Sort first?", Body: "is O(n log n) ok"}})
+	var thread models.Thread
+	expect(alice, "thread_created", &thread)
+	expect(bob, "thread_created", nil)
+	if thread.ID != 1 || thread.Author != "u1" || thread.Title != "Sort first?" || thread.CreatedAt == 0 {
+		t.Fatalf("unexpected thread: %#v", thread)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyCmd{ThreadID: 1, Body: "probably"}})
+	var added models.ThreadReplyAdded
+	expect(alice, "thread_reply", &added)
+	expect(bob, "thread_reply", nil)
+	if added.ThreadID != 1 || added.Reply.Author != "u2" || added.Reply.Body != "probably" {
+		t.Fatalf("unexpected reply: %#v", added)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyCmd{ThreadID: 2, Body: "?"}})
+	var code string
+	expect(bob, "error", &code)
+	if code != "unknown_thread" {
+		t.Fatalf("expected unknown_thread, got %q", code)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "thread_resolve", Data: models.ThreadResolve{ThreadID: 1}})
+	var resolved models.ThreadResolved
+	expect(alice, "thread_resolved", &resolved)
+	expect(bob, "thread_resolved", nil)
+	if resolved.ThreadID != 1 || resolved.ResolvedBy != "u1" {
+		t.Fatalf("unexpected resolve: %#v", resolved)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyCmd{ThreadID: 1, Body: "reopen?"}})
+	expect(alice, "error", &code)
+	if code != "thread_resolved" {
+		t.Fatalf("expected thread_resolved, got %q", code)
+	}
+
+	// A restarted instance serves the persisted threads in init
+	_, redial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+	_, init := redial("tok2")
+	if len(init.Threads) != 1 || !init.Threads[0].Resolved || len(init.Threads[0].Replies) != 1 {
+		t.Fatalf("expected the restored thread in init, got %#v", init.Threads)
+	}
+
+	// The session record counts threads but carries none of their text
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	_ = bob.WriteJSON(models.WSFrame{Type: "end_session"})
+	select {
+	case event := <-rm.published:
+		if event.Threads == nil || event.Threads.Total != 1 || event.Threads.Resolved != 1 || event.Threads.Replies != 1 {
+			t.Fatalf("unexpected thread summary: %#v", event.Threads)
+		}
+		data, _ := json.Marshal(event)
+		if strings.Contains(string(data), "Sort first?") || strings.Contains(string(data), "probably") {
+			t.Fatalf("session event must not include thread text: %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a session_ended event")
+	}
+}
+
+func TestCollabWSThreadAssistantReplies(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	info := &models.RoomInfo{
+		MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2",
+		Question: &models.Question{ID: 7, Title: "Two Sum", PromptMarkdown: "find two numbers"},
+	}
+	rm.validateFn = func(string) (*models.RoomInfo, error) { return info, nil }
+	rm.getFn = func(string) (*models.RoomInfo, error) { return info, nil }
+
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+	_ = alice.WriteJSON(models.WSFrame{Type: "thread_create", Data: models.ThreadCreate{Title: "Sort first?"}})
+	expect(alice, "thread_created", nil)
+	expect(bob, "thread_created", nil)
+
+	ask := models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyCmd{ThreadID: 1, Body: "what do you think?", AskAI: true}}
+	var code string
+
+	// Without an AI client the request is refused outright
+	_ = alice.WriteJSON(ask)
+	expect(alice, "error", &code)
+	if code != "ai_unavailable" {
+		t.Fatalf("expected ai_unavailable, got %q", code)
+	}
+
+	var (
+		mu          sync.Mutex
+		discussions []string
+		failWith    error
+	)
+	h.SetHinter(&mockHinter{hintFn: func(_ context.Context, _ models.Language, _ string, question *models.Question, discussion string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if question == nil || question.PromptMarkdown != "find two numbers" {
+			t.Errorf("expected the room's question, got %#v", question)
+		}
+		discussions = append(discussions, discussion)
+		if failWith != nil {
+			return "", failWith
+		}
+		return "a hash map avoids the sort", nil
+	}})
+	h.threadAIBudget = 1
+
+	// The assistant answers in the thread, visible to both users
+	_ = alice.WriteJSON(ask)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var asked, answer models.ThreadReplyAdded
+		expect(conn, "thread_reply", &asked)
+		expect(conn, "thread_reply", &answer)
+		if !asked.Reply.AskAI || asked.Reply.Author != "u1" {
+			t.Fatalf("unexpected question reply: %#v", asked)
+		}
+		if answer.Reply.Author != models.ThreadAuthorAssistant || answer.Reply.Body != "a hash map avoids the sort" {
+			t.Fatalf("unexpected assistant reply: %#v", answer)
+		}
+	}
+	mu.Lock()
+	if len(discussions) != 1 || !strings.Contains(discussions[0], "Sort first?") || !strings.Contains(discussions[0], "u1: what do you think?") {
+		t.Fatalf("expected the thread as context, got %q", discussions)
+	}
+	mu.Unlock()
+
+	// The room's budget is spent
+	_ = bob.WriteJSON(ask)
+	expect(bob, "error", &code)
+	if code != "hint_budget_exhausted" {
+		t.Fatalf("expected hint_budget_exhausted, got %q", code)
+	}
+
+	// A failed answer posts a system reply and gives the budget back
+	h.threadAIBudget = 2
+	mu.Lock()
+	failWith = analysis.ErrQuotaExceeded
+	mu.Unlock()
+	_ = bob.WriteJSON(ask)
+	expect(bob, "thread_reply", nil)
+	var failure models.ThreadReplyAdded
+	expect(bob, "thread_reply", &failure)
+	expect(alice, "thread_reply", nil)
+	expect(alice, "thread_reply", nil)
+	if failure.Reply.Author != models.ThreadAuthorSystem || failure.Reply.Body != i18n.Message(i18n.DefaultLocale, "ai_quota_exceeded") {
+		t.Fatalf("unexpected failure reply: %#v", failure)
+	}
+	rm.chatMu.Lock()
+	state := rm.threads["room1"]
+	rm.chatMu.Unlock()
+	if state.AIRepliesUsed != 1 || len(state.Threads[0].Replies) != 4 {
+		t.Fatalf("expected the failed answer to be refunded and persisted, got %#v", state)
+	}
+}
+
+func TestIdleRoomIsEnded(t *testing.T) {
+	rm := &mockRoomManager{published: make(chan models.SessionEndedEvent, 1), ended: make(chan string, 1)}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	rm.getFn = func(id string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{MatchId: id, User1: "u1", User2: "u2"}, nil
+	}
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+
+	// Recent activity keeps the room open
+	h.sweepIdleRooms(time.Now())
+	if _, ok := h.hub.Get("room1"); !ok {
+		t.Fatalf("expected an active room to stay")
+	}
+
+	h.sweepIdleRooms(time.Now().Add(h.idleTimeout))
+	var ended map[string]string
+	expect(alice, "session_ended", &ended)
+	if ended["reason"] != "idle_timeout" {
+		t.Fatalf("expected idle_timeout, got %v", ended)
+	}
+	select {
+	case id := <-rm.ended:
+		if id != "room1" {
+			t.Fatalf("expected room1 marked ended, got %q", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the room to be marked ended")
+	}
+	select {
+	case event := <-rm.published:
+		if event.MatchID != "room1" || event.User1 != "u1" {
+			t.Fatalf("unexpected session event %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the session to be reported")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := h.hub.Get("room1"); !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected the room dropped from the hub")
+}
+
+func TestIdleTimeoutFromEnv(t *testing.T) {
+	t.Setenv("COLLAB_IDLE_TIMEOUT", "")
+	if got := idleTimeoutFromEnv(); got != defaultIdleTimeout {
+		t.Fatalf("expected the default, got %v", got)
+	}
+	t.Setenv("COLLAB_IDLE_TIMEOUT", "5m")
+	if got := idleTimeoutFromEnv(); got != 5*time.Minute {
+		t.Fatalf("expected 5m, got %v", got)
+	}
+	t.Setenv("COLLAB_IDLE_TIMEOUT", "soon")
+	if got := idleTimeoutFromEnv(); got != defaultIdleTimeout {
+		t.Fatalf("expected the default for an invalid value, got %v", got)
+	}
+}
+
+func TestRoomOptionsFromEnv(t *testing.T) {
+	t.Setenv("COLLAB_MAX_DOC_BYTES", "")
+	t.Setenv("COLLAB_EDIT_RATE", "-1")
+	t.Setenv("COLLAB_MAX_SPECTATORS", "x")
+	t.Setenv("COLLAB_PARTNER_GRACE", "soon")
+	t.Setenv("COLLAB_RUN_COOLDOWN", "-1s")
+	if opts := roomOptionsFromEnv(); len(opts) != 0 {
+		t.Fatalf("expected the room defaults, got %d options", len(opts))
+	}
+	t.Setenv("COLLAB_MAX_DOC_BYTES", "4")
+	t.Setenv("COLLAB_EDIT_RATE", "0")
+	t.Setenv("COLLAB_MAX_SPECTATORS", "0")
+	t.Setenv("COLLAB_PARTNER_GRACE", "0")
+	t.Setenv("COLLAB_RUN_COOLDOWN", "0")
+	if opts := roomOptionsFromEnv(); len(opts) != 5 {
+		t.Fatalf("expected every option to be read, got %d", len(opts))
+	}
+	room := session.NewRoom("r", roomOptionsFromEnv()...)
+	if ok, _, err := room.ApplyEdit(models.Edit{Text: "12345"}); ok || !errors.Is(err, session.ErrDocTooLarge) {
+		t.Fatalf("expected the 4 byte cap, got ok=%v err=%v", ok, err)
+	}
+	client := session.NewClient(nil)
+	for i := 0; i < 100; i++ {
+		if !room.AllowEdit(client) {
+			t.Fatal("expected edits to be unlimited")
+		}
+	}
+	if err := room.JoinSpectator(session.NewClient(nil)); !errors.Is(err, session.ErrSpectatorsFull) {
+		t.Fatalf("expected spectating to be off, got %v", err)
+	}
+	seq, _ := room.StartRun("u1")
+	room.EndRun(seq)
+	if _, err := room.StartRun("u1"); err != nil {
+		t.Fatalf("expected no cooldown, got %v", err)
+	}
+}
+
+func TestCollabWSEndSessionNeedsBothUsers(t *testing.T) {
+	rm := &mockRoomManager{ended: make(chan string, 1)}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	// Asking starts a request the partner can decline
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	var req models.EndSessionRequest
+	expect(alice, "end_session_pending", &req)
+	expect(bob, "end_session_requested", &req)
+	if req.UserID != "u1" || req.ExpiresAt == 0 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "end_session_cancel"})
+	var cancelled map[string]string
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		expect(conn, "end_session_cancelled", &cancelled)
+		if cancelled["userId"] != "u2" {
+			t.Fatalf("expected u2 to cancel, got %v", cancelled)
+		}
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "end_session_confirm"})
+	var code string
+	expect(bob, "error", &code)
+	if code != "no_pending_end" {
+		t.Fatalf("expected no_pending_end, got %q", code)
+	}
+	if _, ok := h.hub.Get("room1"); !ok {
+		t.Fatalf("expected the room to stay open")
+	}
+
+	// The partner's confirmation ends it for both
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	expect(bob, "end_session_requested", nil)
+	_ = bob.WriteJSON(models.WSFrame{Type: "end_session_confirm"})
+	var ended map[string]string
+	expect(alice, "end_session_pending", nil)
+	expect(alice, "session_ended", &ended)
+	if ended["reason"] != "ended" {
+		t.Fatalf("unexpected session_ended %v", ended)
+	}
+	select {
+	case id := <-rm.ended:
+		if id != "room1" {
+			t.Fatalf("expected room1 marked ended, got %q", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the room to be marked ended")
+	}
+}
+
+func TestCollabWSEndSessionAlone(t *testing.T) {
+	rm := &mockRoomManager{ended: make(chan string, 1)}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	expect(alice, "end_session_pending", nil)
+	expect(bob, "end_session_requested", nil)
+	bob.Close()
+	room, _ := h.hub.Get("room1")
+	deadline := time.Now().Add(2 * time.Second)
+	for room.GetClientCount() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	expect(alice, "peer_disconnected", nil)
+
+	// With the partner gone, asking again is enough
+	_ = alice.WriteJSON(models.WSFrame{Type: "end_session"})
+	var ended map[string]string
+	expect(alice, "session_ended", &ended)
+	select {
+	case <-rm.ended:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the room to be marked ended")
+	}
+}
+
+// rerollRequest posts to the reroll endpoint served by handle as the holder of token
+func rerollRequest(handle http.HandlerFunc, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/room1/reroll", nil)
+	req = req.WithContext(addMatchID(req.Context(), "room1"))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handle(rec, req)
+	return rec
+}
+
+func TestRerollNeedsPartnerApproval(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	var rerolls atomic.Int32
+	rm.rerollFn = func(string) (*models.RoomInfo, error) {
+		n := int(rerolls.Add(1))
+		return &models.RoomInfo{Question: &models.Question{ID: 10 + n}, RerollsRemaining: 2 - n}, nil
+	}
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	// Approved over the socket
+	rec := rerollRequest(h.RerollQuestion, "tok1")
+	var proposal models.RerollProposal
+	if err := json.NewDecoder(rec.Body).Decode(&proposal); rec.Code != http.StatusAccepted || err != nil || proposal.UserID != "u1" {
+		t.Fatalf("expected a pending proposal, got %d %s", rec.Code, rec.Body.String())
+	}
+	expect(alice, "reroll_proposed", nil)
+	expect(bob, "reroll_proposed", &proposal)
+	if proposal.UserID != "u1" || proposal.ExpiresAt == 0 || rerolls.Load() != 0 {
+		t.Fatalf("unexpected proposal %#v after %d rerolls", proposal, rerolls.Load())
+	}
+	if rec := rerollRequest(h.RerollQuestion, "tok1"); rec.Code != http.StatusConflict || decodeError(t, rec).Code != "reroll_pending" {
+		t.Fatalf("expected a second proposal to be refused, got %d", rec.Code)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "reroll_vote", Data: models.RerollVote{Approve: true}})
+	expect(alice, "error", nil)
+	_ = bob.WriteJSON(models.WSFrame{Type: "reroll_vote", Data: models.RerollVote{Approve: true}})
+	var update models.QuestionUpdate
+	expect(alice, "question", &update)
+	expect(bob, "question", nil)
+	if rerolls.Load() != 1 || update.Question.ID != 11 || update.RerollsRemaining != 1 {
+		t.Fatalf("expected one reroll, got %d %#v", rerolls.Load(), update)
+	}
+
+	// Rejected
+	rerollRequest(h.RerollQuestion, "tok2")
+	expect(alice, "reroll_proposed", nil)
+	expect(bob, "reroll_proposed", nil)
+	_ = alice.WriteJSON(models.WSFrame{Type: "reroll_vote", Data: models.RerollVote{Approve: false}})
+	var rejected map[string]string
+	expect(bob, "reroll_rejected", &rejected)
+	expect(alice, "reroll_rejected", nil)
+	if rejected["userId"] != "u1" || rerolls.Load() != 1 {
+		t.Fatalf("unexpected rejection %#v after %d rerolls", rejected, rerolls.Load())
+	}
+	if rec := rerollRequest(h.ApproveReroll, "tok1"); rec.Code != http.StatusConflict || decodeError(t, rec).Code != "no_pending_reroll" {
+		t.Fatalf("expected nothing left to approve, got %d", rec.Code)
+	}
+
+	// Approved over HTTP
+	rerollRequest(h.RerollQuestion, "tok1")
+	expect(alice, "reroll_proposed", nil)
+	expect(bob, "reroll_proposed", nil)
+	if rec := rerollRequest(h.ApproveReroll, "tok2"); rec.Code != http.StatusOK || rerolls.Load() != 2 {
+		t.Fatalf("expected the approval to reroll, got %d after %d rerolls", rec.Code, rerolls.Load())
+	}
+	expect(alice, "question", nil)
+	expect(bob, "question", nil)
+
+	// Out of rerolls: both participants hear about it once approved
+	rm.rerollFn = func(string) (*models.RoomInfo, error) { return nil, room_management.ErrNoRerolls }
+	rerollRequest(h.RerollQuestion, "tok1")
+	expect(alice, "reroll_proposed", nil)
+	expect(bob, "reroll_proposed", nil)
+	_ = bob.WriteJSON(models.WSFrame{Type: "reroll_vote", Data: models.RerollVote{Approve: true}})
+	var failed string
+	expect(alice, "error", &failed)
+	expect(bob, "error", nil)
+	if failed != "no_rerolls" {
+		t.Fatalf("expected no_rerolls, got %#v", failed)
+	}
+	rerollRequest(h.RerollQuestion, "tok1")
+	if rec := rerollRequest(h.ApproveReroll, "tok2"); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "no_rerolls" {
+		t.Fatalf("expected no_rerolls over HTTP, got %d", rec.Code)
+	}
+}
+
+func TestRerollProposalExpires(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	h.rerollTimeout = 50 * time.Millisecond
+	expect := expectFrame(t)
+	rm.rerollFn = func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{Question: &models.Question{ID: 2}}, nil
+	}
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	rerollRequest(h.RerollQuestion, "tok1")
+	expect(alice, "reroll_proposed", nil)
+	expect(bob, "reroll_proposed", nil)
+	time.Sleep(100 * time.Millisecond)
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "reroll_vote", Data: models.RerollVote{Approve: true}})
+	var resp string
+	expect(bob, "error", &resp)
+	if resp != "no_pending_reroll" {
+		t.Fatalf("expected the proposal to have expired, got %#v", resp)
+	}
+	// The proposer may try again once it lapsed
+	if rec := rerollRequest(h.RerollQuestion, "tok1"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected a fresh proposal, got %d", rec.Code)
+	}
+}
+
+func TestRerollAloneIsImmediate(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	rm.rerollFn = func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{Question: &models.Question{ID: 2}}, nil
+	}
+	alice, _ := dial("tok1")
+
+	if rec := rerollRequest(h.RerollQuestion, "tok1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected an immediate reroll, got %d", rec.Code)
+	}
+	expect(alice, "question", nil)
+}
+
+// testsRoom serves a room whose question has two examples: doubling the
+// number read from stdin.
+func testsRoom(t *testing.T, runner *mockRunner) (*Handlers, *mockRoomManager, func(token string, caps ...string) (*websocket.Conn, models.InitResponse)) {
+	t.Helper()
+	rm := &mockRoomManager{}
+	rm.getFn = func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{MatchId: "room1", Question: &models.Question{ID: 1, TestCases: []models.TestCase{
+			{Input: "2\n", Output: "4\n"},
+			{Input: "5\n", Output: "10"},
+		}}}, nil
+	}
+	h, dial := serveTestRoom(t, rm, runner, make(chan time.Time))
+	return h, rm, dial
+}
+
+func TestCollabWSRunTests(t *testing.T) {
+	var stdins []string
+	var mu sync.Mutex
+	runner := &mockRunner{runOnceFn: func(_ context.Context, _ models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+		mu.Lock()
+		stdins = append(stdins, limits.Stdin)
+		mu.Unlock()
+		if code == "broken" {
+			return exec.RunOutput{Exit: 1, CompileOutput: "syntax error"}, nil
+		}
+		// Right for the first example only
+		return exec.RunOutput{Stdout: "4  \n\n"}, nil
+	}}
+	_, _, dial := testsRoom(t, runner)
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run_tests", Data: models.RunTestsCmd{Language: models.LangPython, Code: "print(int(input())*2)"}})
+	expect(bob, "run_reset", nil)
+	expect(bob, "run_status", nil)
+	var results models.TestResults
+	expect(bob, "test_results", &results)
+	expect(bob, "run_status", nil)
+	if results.By != "u1" || results.Total != 2 || results.Passed != 1 || len(results.Cases) != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if !results.Cases[0].Passed || results.Cases[0].Diff != "" {
+		t.Fatalf("expected trailing whitespace to be ignored, got %+v", results.Cases[0])
+	}
+	if c := results.Cases[1]; c.Passed || c.Diff != "line 1:\n- 10\n+ 4\n" || c.Input != "5\n" || c.Actual != "4  \n\n" {
+		t.Fatalf("expected the second example to fail with a diff, got %+v", c)
+	}
+	mu.Lock()
+	if len(stdins) != 2 || stdins[0] != "2\n" || stdins[1] != "5\n" {
+		t.Fatalf("expected each example's input as stdin, got %q", stdins)
+	}
+	mu.Unlock()
+
+	for _, typ := range []string{"run_reset", "run_status", "test_results", "run_status"} {
+		expect(alice, typ, nil)
+	}
+
+	// A compile error stops at the first example
+	_ = alice.WriteJSON(models.WSFrame{Type: "run_tests", Data: models.RunTestsCmd{Language: models.LangPython, Code: "broken"}})
+	expect(alice, "run_reset", nil)
+	expect(alice, "run_status", nil)
+	expect(alice, "test_results", &results)
+	if results.CompileOutput != "syntax error" || len(results.Cases) != 0 || results.Passed != 0 {
+		t.Fatalf("expected the compile output only, got %+v", results)
+	}
+}
+
+func TestCollabWSRunTestsWithoutExamples(t *testing.T) {
+	runner := &mockRunner{runOnceFn: func(context.Context, models.Language, string, exec.SandboxLimits) (exec.RunOutput, error) {
+		t.Error("nothing should run without examples")
+		return exec.RunOutput{}, nil
+	}}
+	_, rm, dial := testsRoom(t, runner)
+	rm.getFn = func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{MatchId: "room1", Question: &models.Question{ID: 1}}, nil
+	}
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "run_tests", Data: models.RunTestsCmd{Language: models.LangPython, Code: "pass"}})
+	var msg string
+	expect(alice, "error", &msg)
+	if msg != "no_tests_available" {
+		t.Fatalf("expected no_tests_available, got %q", msg)
+	}
+}
+
+func TestRunTestsEndpoint(t *testing.T) {
+	runner := &mockRunner{runOnceFn: func(_ context.Context, _ models.Language, _ string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+		n, _ := strconv.Atoi(strings.TrimSpace(limits.Stdin))
+		return exec.RunOutput{Stdout: strconv.Itoa(2*n) + "\n"}, nil
+	}}
+	h, rm, dial := testsRoom(t, runner)
+	expect := expectFrame(t)
+	bob, _ := dial("tok2")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/room1/run-tests", strings.NewReader(body))
+		req = req.WithContext(addMatchID(req.Context(), "room1"))
+		req.Header.Set("Authorization", "Bearer tok1")
+		rec := httptest.NewRecorder()
+		h.RunTests(rec, req)
+		return rec
+	}
+	rec := post(`{"language":"python","code":"print(int(input())*2)"}`)
+	var results models.TestResults
+	if err := json.NewDecoder(rec.Body).Decode(&results); rec.Code != http.StatusOK || err != nil || results.Passed != 2 || results.Total != 2 {
+		t.Fatalf("expected both examples to pass, got %d %+v", rec.Code, results)
+	}
+	expect(bob, "run_reset", nil)
+	expect(bob, "run_status", nil)
+	expect(bob, "test_results", nil)
+
+	rm.getFn = func(string) (*models.RoomInfo, error) { return &models.RoomInfo{MatchId: "room1"}, nil }
+	if rec := post(`{"language":"python","code":"pass"}`); rec.Code != http.StatusNotFound || decodeError(t, rec).Code != "no_tests_available" {
+		t.Fatalf("expected no_tests_available, got %d", rec.Code)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	rm := &mockRoomManager{snapshots: map[string][]models.Snapshot{"m1": {
+		{Version: 50, Text: "a", Language: models.LangPython, Timestamp: 1},
+		{Version: 100, Text: "ab", Language: models.LangPython, Timestamp: 2},
+	}}}
+	rm.validateFn = func(token string) (*models.RoomInfo, error) {
+		if token != "tok1" {
+			return nil, errors.New("invalid token")
+		}
+		return &models.RoomInfo{MatchId: "m1", User1: "u1", Token1: "tok1"}, nil
+	}
+	h := newTestHandlers(&mockRunner{}, rm)
+	list := func(matchID, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/room/"+matchID+"/snapshots", nil)
+		req = req.WithContext(addMatchID(req.Context(), matchID))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ListSnapshots(rec, req)
+		return rec
+	}
+
+	rec := list("m1", "Bearer tok1")
+	var snapshots []models.Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snapshots); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("expected the snapshots, got %d %v", rec.Code, err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Version != 50 || snapshots[1].Text != "ab" {
+		t.Fatalf("unexpected snapshots %+v", snapshots)
+	}
+
+	for _, tc := range []struct {
+		matchID, header string
+		status          int
+		code            string
+	}{
+		{"m1", "", http.StatusUnauthorized, "token_required"},
+		{"m1", "Bearer nope", http.StatusUnauthorized, "unauthorized"},
+		{"m2", "Bearer tok1", http.StatusBadRequest, "invalid_room"},
+	} {
+		rec := list(tc.matchID, tc.header)
+		if rec.Code != tc.status || decodeError(t, rec).Code != tc.code {
+			t.Fatalf("%s %q: expected %d %s, got %d", tc.matchID, tc.header, tc.status, tc.code, rec.Code)
+		}
+	}
+
+	rm.snapErr = errors.New("redis down")
+	if rec := list("m1", "Bearer tok1"); rec.Code != http.StatusInternalServerError || decodeError(t, rec).Code != "snapshots_unavailable" {
+		t.Fatalf("expected snapshots_unavailable, got %d", rec.Code)
+	}
+}
+
+func TestCollabWSRestoreSnapshot(t *testing.T) {
+	rm := &mockRoomManager{snapshots: map[string][]models.Snapshot{"room1": {{Version: 50, Text: "print(1)", Language: models.LangPython}}}}
+	_, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "broken code"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", nil)
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "restore_snapshot", Data: models.SnapshotRestore{Version: 7}})
+	var msg string
+	expect(bob, "error", &msg)
+	if msg != "unknown_snapshot" {
+		t.Fatalf("expected unknown_snapshot, got %q", msg)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "restore_snapshot", Data: models.SnapshotRestore{Version: 50}})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var doc models.DocState
+		expect(conn, "doc", &doc)
+		if doc.Text != "print(1)" || doc.Version != 2 {
+			t.Fatalf("expected the snapshot as a new version, got %+v", doc)
+		}
+		var restored map[string]any
+		expect(conn, "snapshot_restored", &restored)
+		if restored["userId"] != "u2" || restored["snapshotVersion"] != float64(50) {
+			t.Fatalf("unexpected snapshot_restored %v", restored)
+		}
+	}
+}
+
+func TestSessionSummary(t *testing.T) {
+	rm := &mockRoomManager{getFn: func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{
+			MatchId: "m1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2",
+			Question: &models.Question{ID: 3, Title: "Two Sum"},
+		}, nil
+	}}
+	h := newTestHandlers(&mockRunner{}, rm)
+	h.handleSessionEnd("m1", "print(42)", models.LangPython, 90*time.Second)
+
+	get := func(matchID, header, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/session/"+matchID+"/summary"+query, nil)
+		req = req.WithContext(addMatchID(req.Context(), matchID))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.GetSessionSummary(rec, req)
+		return rec
+	}
+
+	// Either participant, by room token or user ID
+	for _, tc := range []struct{ header, query string }{{"Bearer tok2", ""}, {"", "?userId=u1"}} {
+		rec := get("m1", tc.header, tc.query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%+v: expected the summary, got %d %s", tc, rec.Code, rec.Body.String())
+		}
+		if strings.Contains(rec.Body.String(), "tok1") {
+			t.Fatalf("room tokens leaked: %s", rec.Body.String())
+		}
+		var summary models.SessionEndedEvent
+		_ = json.NewDecoder(rec.Body).Decode(&summary)
+		if summary.FinalCode != "print(42)" || summary.EndedAt == "" || summary.QuestionTitle != "Two Sum" || summary.DurationSec != 90 {
+			t.Fatalf("unexpected summary %+v", summary)
+		}
+	}
+
+	for _, tc := range []struct {
+		matchID, header, query string
+		status                 int
+		code                   string
+	}{
+		{"m1", "", "", http.StatusUnauthorized, "token_required"},
+		{"m1", "Bearer tok3", "", http.StatusUnauthorized, "unauthorized"},
+		{"m1", "", "?userId=u3", http.StatusUnauthorized, "unauthorized"},
+		{"m2", "Bearer tok1", "", http.StatusNotFound, "no_summary"},
+	} {
+		rec := get(tc.matchID, tc.header, tc.query)
+		if rec.Code != tc.status || decodeError(t, rec).Code != tc.code {
+			t.Fatalf("%+v: got %d %s", tc, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	rooms := map[string]*models.RoomInfo{
+		"m1": {MatchId: "m1", User1: "u1", User2: "u2", Question: &models.Question{ID: 3, Title: "Two Sum"}},
+		"m2": {MatchId: "m2", User1: "u3", User2: "u1", Question: &models.Question{ID: 4, Title: "Valid Parentheses"}},
+	}
+	rm := &mockRoomManager{getFn: func(id string) (*models.RoomInfo, error) { return rooms[id], nil }}
+	h := newTestHandlers(&mockRunner{}, rm)
+	h.handleSessionEnd("m1", "print(1)", models.LangPython, 90*time.Second)
+	h.handleSessionEnd("m2", "int main() {}", models.LangCPP, 30*time.Minute)
+
+	get := func(userID, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/users/"+userID+"/sessions"+query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("userId", userID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		h.GetUserSessions(rec, req)
+		return rec
+	}
+	sessions := func(userID, query string) []models.SessionRecord {
+		t.Helper()
+		rec := get(userID, query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the sessions of %s, got %d %s", userID, rec.Code, rec.Body.String())
+		}
+		var records []models.SessionRecord
+		decodeBody(t, rec.Body, &records)
+		return records
+	}
+
+	records := sessions("u1", "")
+	if len(records) != 2 || records[0].MatchID != "m2" || records[1].MatchID != "m1" {
+		t.Fatalf("expected both sessions of u1 newest first, got %+v", records)
+	}
+	if r := records[0]; r.PartnerID != "u3" || r.QuestionTitle != "Valid Parentheses" || r.Language != "cpp" || r.DurationSec != 1800 || r.EndedAt == "" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if records[1].PartnerID != "u2" {
+		t.Fatalf("expected u2 as the partner in m1, got %+v", records[1])
+	}
+	if records := sessions("u2", ""); len(records) != 1 || records[0].MatchID != "m1" || records[0].PartnerID != "u1" {
+		t.Fatalf("expected m1 with u1 as partner for u2, got %+v", records)
+	}
+	if records := sessions("u1", "?limit=1"); len(records) != 1 || records[0].MatchID != "m2" {
+		t.Fatalf("expected only the newest session, got %+v", records)
+	}
+	if records := sessions("u4", ""); len(records) != 0 {
+		t.Fatalf("expected no sessions for u4, got %+v", records)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=-2", "?limit=ten"} {
+		if rec := get("u1", query); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "invalid_limit" {
+			t.Fatalf("%s: expected invalid_limit, got %d %s", query, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestExportCode(t *testing.T) {
+	runner := &mockRunner{langSpecFn: func(lang models.Language) (models.LanguageSpec, string, string, [][]string, error) {
+		names := map[models.Language]string{models.LangPython: "main.py", models.LangJava: "Main.java", models.LangCPP: "main.cpp"}
+		if name, ok := names[lang]; ok {
+			return models.LanguageSpec{Name: lang, FileName: name}, "", name, nil, nil
+		}
+		return models.LanguageSpec{}, "", "", nil, errors.New("unsupported")
+	}}
+	rm := &mockRoomManager{validateFn: func(token string) (*models.RoomInfo, error) {
+		if token != "tok1" {
+			return nil, errors.New("invalid token")
+		}
+		return &models.RoomInfo{MatchId: "m1"}, nil
+	}}
+	h := newTestHandlers(runner, rm)
+	export := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/room/m1/export"+query, nil)
+		req = req.WithContext(addMatchID(req.Context(), "m1"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ExportCode(rec, req)
+		return rec
+	}
+
+	// Not held by this instance: the persisted document
+	_ = rm.SaveDoc("m1", models.DocSnapshot{Text: "int main() {}", Version: 3, Language: models.LangCPP})
+	rec := export("tok1", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "int main() {}" {
+		t.Fatalf("expected the persisted code, got %d %q", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="main.cpp"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+
+	room := h.hub.GetOrCreate("m1")
+	for _, tc := range []struct {
+		lang        models.Language
+		file, ctype string
+	}{
+		{models.LangPython, "main.py", "text/x-python; charset=utf-8"},
+		{models.LangJava, "Main.java", "text/x-java-source; charset=utf-8"},
+		{models.LangRust, "code.txt", "text/x-rust; charset=utf-8"},
+	} {
+		room.SetLanguage(tc.lang)
+		rec := export("tok1", "")
+		if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="`+tc.file+`"` || rec.Header().Get("Content-Type") != tc.ctype {
+			t.Fatalf("%s: unexpected headers %q %q", tc.lang, cd, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	room.SetLanguage(models.LangJava)
+	if _, err := room.ReplaceDoc("class Main {}"); err != nil {
+		t.Fatalf("edit failed: %v", err)
+	}
+	rec = export("tok1", "?format=json")
+	var doc models.DocSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&doc); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("expected JSON, got %d %v", rec.Code, err)
+	}
+	if doc.Text != "class Main {}" || doc.Language != models.LangJava || doc.Version != 4 {
+		t.Fatalf("expected the live document, got %+v", doc)
+	}
+
+	if rec := export("nope", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad token, got %d", rec.Code)
+	}
+}
+
+func TestCollabWSDocLimitsKeepPartnerConsistent(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	h.hub.SetRoomOptions(session.WithMaxDocBytes(10), session.WithEditRate(3))
+	// Both edit, so the roles are not enforced
+	h.hub.GetOrCreate("room1").ConfigureRoles(true)
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+	var doc, bobDoc models.DocState
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "hello"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", &bobDoc)
+
+	// Too large: rejected with a resync, the partner hears nothing
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 1, RangeStart: 5, RangeEnd: 5, Text: " world"}})
+	var msg string
+	expect(alice, "error", &msg)
+	expect(alice, "doc", &doc)
+	if msg != "doc_too_large" || doc.Text != "hello" || doc.Version != 1 {
+		t.Fatalf("expected doc_too_large and a resync, got %q %+v", msg, doc)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 1, RangeStart: 0, RangeEnd: 5, Text: "hi"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", &bobDoc)
+
+	// The burst of 3 is spent
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 2, RangeStart: 2, RangeEnd: 2, Text: "!"}})
+	expect(alice, "error", &msg)
+	expect(alice, "doc", &doc)
+	if msg != "rate_limited" || doc.Text != "hi" || doc.Version != 2 {
+		t.Fatalf("expected rate_limited and a resync, got %q %+v", msg, doc)
+	}
+
+	// Bob's edits have their own bucket and land on the document he was sent
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: bobDoc.Version, RangeStart: 2, RangeEnd: 2, Text: "?"}})
+	expect(bob, "doc", &bobDoc)
+	expect(alice, "doc", &doc)
+	room, _ := h.hub.Get("room1")
+	state, _ := room.Snapshot()
+	if bobDoc.Text != "hi?" || doc.Text != "hi?" || state.Text != "hi?" || state.Version != 3 {
+		t.Fatalf("expected both clients on the room's document, got %+v %+v %+v", bobDoc, doc, state)
+	}
+}
+
+// readForever keeps reading conn, which also answers the server's pings
+func readForever(conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestCollabWSUnresponsiveClientIsEvicted(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	h.pingInterval, h.pongWait = 20*time.Millisecond, 100*time.Millisecond
+	alice, _ := dial("tok1")
+	readForever(alice)
+	// Bob's connection stops reading, so his pongs never come
+	dial("tok2")
+	room, _ := h.hub.Get("room1")
+
+	waitUntil(func() bool { return room.GetClientCount() == 1 }, t)
+	bob, init := dial("tok2")
+	if init.SessionID != "room1" {
+		t.Fatalf("expected bob to rejoin, got %+v", init)
+	}
+	readForever(bob)
+	time.Sleep(3 * h.pongWait)
+	if n := room.GetClientCount(); n != 2 {
+		t.Fatalf("expected responsive clients to stay, got %d", n)
+	}
+}
+
+func TestCollabWSStaleClientIsPrunedOnJoin(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	// No pings and no read deadline in time: only the prune frees the seat
+	h.pingInterval, h.pongWait = time.Hour, time.Hour
+	h.hub.SetRoomOptions(session.WithStaleAfter(50 * time.Millisecond))
+	alice, _ := dial("tok1")
+	dial("tok2")
+	room, _ := h.hub.Get("room1")
+
+	time.Sleep(100 * time.Millisecond)
+	// Alice is still there: any frame counts as a sign of life
+	_ = alice.WriteJSON(models.WSFrame{Type: "resync", Data: models.Resync{Reason: "other"}})
+	expectFrame(t)(alice, "doc", nil)
+
+	_, init := dial("tok2")
+	if init.SessionID != "room1" || room.GetClientCount() != 2 {
+		t.Fatalf("expected the stale connection to make way, got %+v with %d clients", init, room.GetClientCount())
+	}
+}
+
+func TestCollabWSSpectatorIsReadOnly(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	mentor, init := dial("spec1")
+	bob, _ := dial("tok2")
+	if !init.Spectator || init.Language != models.LangPython {
+		t.Fatalf("expected a spectator init, got %+v", init)
+	}
+
+	// The spectator takes no seat and watches the participants' edits and chat
+	var doc models.DocState
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "hi"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", nil)
+	expect(mentor, "doc", &doc)
+	if doc.Text != "hi" || doc.Version != 1 {
+		t.Fatalf("expected the spectator to see the edit, got %+v", doc)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat", Data: models.ChatMessage{Message: "hello"}})
+	expect(alice, "chat", nil)
+	expect(mentor, "chat", nil)
+
+	// Edits, runs and language changes are refused and change nothing
+	var msg string
+	_ = mentor.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 1, RangeStart: 0, RangeEnd: 2, Text: "bye"}})
+	expect(mentor, "error", &msg)
+	expect(mentor, "doc", &doc)
+	if msg != "read_only" || doc.Text != "hi" || doc.Version != 1 {
+		t.Fatalf("expected read_only and a resync, got %q %+v", msg, doc)
+	}
+	for _, frame := range []models.WSFrame{
+		{Type: "run", Data: models.RunCmd{Language: models.LangPython, Code: "print(1)"}},
+		{Type: "language", Data: models.LanguageChange{Language: models.LangGo}},
+	} {
+		_ = mentor.WriteJSON(frame)
+		expect(mentor, "error", &msg)
+		if msg != "read_only" {
+			t.Fatalf("expected %s to be refused as read_only, got %q", frame.Type, msg)
+		}
+	}
+	room, _ := h.hub.Get("room1")
+	state, lang := room.Snapshot()
+	if state.Version != 1 || lang != models.LangPython || room.GetClientCount() != 2 || room.SpectatorCount() != 1 {
+		t.Fatalf("expected the room untouched by the spectator, got %+v %s with %d/%d clients", state, lang, room.GetClientCount(), room.SpectatorCount())
+	}
+
+	// The room status reports the spectator
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/room/room1", nil)
+	req = req.WithContext(addMatchID(req.Context(), "room1"))
+	req.Header.Set("Authorization", "Bearer tok1")
+	rec := httptest.NewRecorder()
+	h.GetRoomStatus(rec, req)
+	var status models.RoomInfo
+	_ = json.NewDecoder(rec.Body).Decode(&status)
+	if rec.Code != http.StatusOK || status.Spectators != 1 {
+		t.Fatalf("expected the status to count 1 spectator, got %d %+v", rec.Code, status)
+	}
+}
+
+func TestCollabWSCursors(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial, resume := serveResumableRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, aliceInit := dial("tok1")
+	bob, bobInit := dial("tok2")
+	if aliceInit.Color == "" || bobInit.Color == "" || aliceInit.Color == bobInit.Color {
+		t.Fatalf("expected distinct cursor colors, got %q and %q", aliceInit.Color, bobInit.Color)
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "hello"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", nil)
+
+	// Attributed, colored, ordered and clamped to the 5 runes of the document
+	var c models.Cursor
+	_ = alice.WriteJSON(models.WSFrame{Type: "cursor", Data: models.Cursor{Pos: 42, SelStart: 9, SelEnd: 2}})
+	expect(bob, "cursor", &c)
+	if c != (models.Cursor{UserID: "u1", Pos: 5, SelStart: 2, SelEnd: 5, Color: aliceInit.Color}) {
+		t.Fatalf("unexpected relayed cursor: %+v", c)
+	}
+
+	// A cursor claiming to be the partner's is refused and not relayed
+	var msg string
+	_ = alice.WriteJSON(models.WSFrame{Type: "cursor", Data: models.Cursor{UserID: "u2", Pos: 1}})
+	expect(alice, "error", &msg)
+	if msg != "cursor_spoofed" {
+		t.Fatalf("expected cursor_spoofed, got %q", msg)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "cursor", Data: models.Cursor{UserID: "u1", Pos: 3, SelStart: 3, SelEnd: 3}})
+	expect(bob, "cursor", &c)
+	if c.UserID != "u1" || c.Pos != 3 {
+		t.Fatalf("expected the spoofed cursor to be dropped, got %+v", c)
+	}
+
+	// A reconnecting client gets the partner's marker and keeps its color
+	_ = bob.Close()
+	room, _ := h.hub.Get("room1")
+	waitUntil(func() bool { return room.GetClientCount() == 1 }, t)
+	_, init := resume("tok2", models.ResumeMarkers{DocVersion: 1})
+	if init.Color != bobInit.Color || len(init.Cursors) != 1 || init.Cursors[0] != c {
+		t.Fatalf("expected alice's cursor and the same color on resume, got %q %+v", init.Color, init.Cursors)
+	}
+}
+
+func TestCollabWSChatHistory(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+
+	// The payload's userId is ignored: messages belong to the token's user
+	var ack models.ChatMessage
+	_ = alice.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{UserID: "u2", Message: "first"}})
+	expect(alice, "chat_ack", &ack)
+	if ack.UserID != "u1" || ack.SentAt == 0 {
+		t.Fatalf("expected the server to stamp the message, got %+v", ack)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: "second"}})
+	expect(alice, "chat_ack", nil)
+
+	// Joining second replays the chat in order, right after init
+	bob, _ := dial("tok2")
+	var history models.ChatState
+	expect(bob, "chat_history", &history)
+	if len(history.Messages) != 2 || history.Messages[0].Message != "first" || history.Messages[1].Message != "second" || history.Messages[0].UserID != "u1" {
+		t.Fatalf("unexpected chat history: %+v", history.Messages)
+	}
+
+	get := func() (*httptest.ResponseRecorder, models.ChatState) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/collab/room/room1/chat", nil)
+		req = req.WithContext(addMatchID(req.Context(), "room1"))
+		req.Header.Set("Authorization", "Bearer tok2")
+		rec := httptest.NewRecorder()
+		h.GetChatHistory(rec, req)
+		var state models.ChatState
+		_ = json.NewDecoder(rec.Body).Decode(&state)
+		return rec, state
+	}
+	if rec, state := get(); rec.Code != http.StatusOK || len(state.Messages) != 2 || state.Messages[1].ID != 2 {
+		t.Fatalf("expected the live chat, got %d %+v", rec.Code, state)
+	}
+
+	// Once the room is gone, the persisted chat is served
+	h.hub.Delete("room1")
+	if rec, state := get(); rec.Code != http.StatusOK || len(state.Messages) != 2 || state.Messages[0].Message != "first" {
+		t.Fatalf("expected the persisted chat, got %d %+v", rec.Code, state)
+	}
+}
+
+func TestCollabWSHintRequest(t *testing.T) {
+	rm := &mockRoomManager{}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	var mu sync.Mutex
+	question := &models.Question{ID: 1, Title: "Two Sum", PromptMarkdown: "find two numbers"}
+	rm.getFn = func(string) (*models.RoomInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &models.RoomInfo{MatchId: "room1", Question: question}, nil
+	}
+	rm.rerollFn = func(string) (*models.RoomInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		question = &models.Question{ID: 2, Title: "Three Sum"}
+		return &models.RoomInfo{MatchId: "room1", Question: question}, nil
+	}
+	var failWith error
+	h.SetHintClient(&mockHinter{hintFn: func(_ context.Context, _ models.Language, code string, q *models.Question, _ string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failWith != nil {
+			return "", failWith
+		}
+		return "hint on " + q.Title + " for " + code, nil
+	}})
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	// Both participants get each hint
+	var hint models.Hint
+	for want := 2; want >= 0; want-- {
+		_ = alice.WriteJSON(models.WSFrame{Type: "hint_request"})
+		expect(alice, "hint", &hint)
+		expect(bob, "hint", nil)
+		if hint.RequestedBy != "u1" || hint.QuestionID != 1 || hint.Remaining != want || hint.Hint != "hint on Two Sum for (no code written yet)" {
+			t.Fatalf("unexpected hint: %+v", hint)
+		}
+	}
+	var msg string
+	_ = bob.WriteJSON(models.WSFrame{Type: "hint_request"})
+	expect(bob, "error", &msg)
+	if msg != "hint_limit_reached" {
+		t.Fatalf("expected the limit of 3 hints, got %q", msg)
+	}
+
+	// A reroll starts the count over
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/room1/reroll", nil)
+	h.reroll(httptest.NewRecorder(), req, "room1")
+	expect(alice, "question", nil)
+	expect(bob, "question", nil)
+
+	// A failing AI service costs nothing and leaves the session usable
+	mu.Lock()
+	failWith = errors.New("provider down")
+	mu.Unlock()
+	_ = bob.WriteJSON(models.WSFrame{Type: "hint_request"})
+	expect(bob, "error", &msg)
+	if msg != "hint_unavailable" {
+		t.Fatalf("expected hint_unavailable, got %q", msg)
+	}
+	mu.Lock()
+	failWith = nil
+	mu.Unlock()
+	_ = bob.WriteJSON(models.WSFrame{Type: "hint_request"})
+	expect(bob, "hint", &hint)
+	expect(alice, "hint", nil)
+	if hint.QuestionID != 2 || hint.Remaining != 2 || hint.RequestedBy != "u2" {
+		t.Fatalf("expected a fresh budget on the new question, got %+v", hint)
+	}
+}
+
+func TestCollabWSPartnerAbandonsSession(t *testing.T) {
+	rm := &mockRoomManager{published: make(chan models.SessionEndedEvent, 1), reported: make(chan string, 1)}
+	rm.getFn = func(string) (*models.RoomInfo, error) {
+		return &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2"}, nil
+	}
+	h, dial := serveTestRoom(t, rm, &mockRunner{}, make(chan time.Time))
+	h.hub.SetRoomOptions(session.WithPartnerGrace(100 * time.Millisecond))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	// Bob's connection dies and he never comes back
+	_ = bob.Close()
+	var gone models.PeerDisconnected
+	expect(alice, "peer_disconnected", &gone)
+	if gone.UserID != "u2" || gone.GraceMs != 100 || gone.RejoinBy == 0 {
+		t.Fatalf("unexpected peer_disconnected: %+v", gone)
+	}
+	select {
+	case <-rm.published:
+		t.Fatal("expected the session to stay open during the grace period")
+	default:
+	}
+
+	var ended map[string]string
+	expect(alice, "session_ended", &ended)
+	if ended["reason"] != "partner_abandoned" || ended["userId"] != "u2" {
+		t.Fatalf("unexpected session_ended: %+v", ended)
+	}
+	select {
+	case event := <-rm.published:
+		if event.MatchID != "room1" || event.User2 != "u2" {
+			t.Fatalf("unexpected session ended event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the session end to be published")
+	}
+	if outcome := <-rm.reported; outcome != models.OutcomeAbandoned {
+		t.Fatalf("expected the session reported abandoned, got %q", outcome)
+	}
+	waitUntil(func() bool { _, ok := h.hub.Get("room1"); return !ok }, t)
+}
+
+func TestLint(t *testing.T) {
+	runner := &mockRunner{runOnceFn: func(_ context.Context, lang models.Language, _ string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+		if limits.Stdin == "down" {
+			return exec.RunOutput{}, exec.ErrDockerUnavailable
+		}
+		return exec.RunOutput{Stdout: "main.cpp:2:5: error: 'x' was not declared in this scope\n", Exit: 1}, nil
+	}}
+	h := newTestHandlers(runner, &mockRoomManager{})
+	lint := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.Lint(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/lint", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := lint(`{"language":"cpp","code":"int main() {\n    x;\n}"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp models.Diagnostics
+	decodeBody(t, rec.Body, &resp)
+	want := []models.Diagnostic{{Line: 2, Column: 5, Severity: "error", Message: "'x' was not declared in this scope"}}
+	if resp.Language != models.LangCPP || !reflect.DeepEqual(resp.Diagnostics, want) {
+		t.Fatalf("unexpected diagnostics: %+v", resp)
+	}
+
+	if rec := lint(`{"language":"cobol","code":"x"}`); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "unsupported_language" {
+		t.Fatalf("expected 400 unsupported_language, got %d", rec.Code)
+	}
+	if rec := lint(`{"language":"cpp","code":"down"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a sandbox, got %d", rec.Code)
+	}
+	if rec := lint(`bad-json`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad json, got %d", rec.Code)
+	}
+}
+
+func TestCollabWSLintRequest(t *testing.T) {
+	var runs atomic.Int32
+	runner := &mockRunner{runOnceFn: func(_ context.Context, lang models.Language, _ string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+		runs.Add(1)
+		if lang != models.LangPython || limits.WallTime != 2*time.Second || limits.MemoryB != 128*1024*1024 {
+			t.Errorf("unexpected lint run: %s %+v", lang, limits)
+		}
+		return exec.RunOutput{Stdout: "main.py:1:1: undefined name '" + limits.Stdin + "'\n", Exit: 1}, nil
+	}}
+	_, dial := serveTestRoom(t, &mockRoomManager{}, runner, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "y"}})
+	expect(alice, "doc", nil)
+	expect(bob, "doc", nil)
+
+	// Both users get the diagnostics, and linting the same code again is
+	// answered from the cache
+	var diagnostics models.Diagnostics
+	for _, requester := range []*websocket.Conn{alice, bob} {
+		_ = requester.WriteJSON(models.WSFrame{Type: "lint_request"})
+		expect(alice, "diagnostics", &diagnostics)
+		expect(bob, "diagnostics", nil)
+	}
+	want := []models.Diagnostic{{Line: 1, Column: 1, Severity: "error", Message: "undefined name 'y'"}}
+	if diagnostics.Version != 1 || !reflect.DeepEqual(diagnostics.Diagnostics, want) {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("expected one sandbox run for the same code, got %d", n)
+	}
+}
+
+func TestReady(t *testing.T) {
+	rm := &mockRoomManager{}
+	h := newTestHandlers(&mockRunner{}, rm)
+
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/api/v1/collab/readyz", nil))
+	var body models.Readiness
+	decodeBody(t, rec.Body, &body)
+	if rec.Code != http.StatusOK || body.Status != "ready" {
+		t.Fatalf("expected 200 ready, got %d %+v", rec.Code, body)
+	}
+
+	for _, dependency := range []string{room_management.DependencyRedis, room_management.DependencyQuestion} {
+		rm.readyErr = fmt.Errorf("probe: %w", &room_management.DependencyError{Dependency: dependency, Err: errors.New("connection refused")})
+		rec = httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/api/v1/collab/readyz", nil))
+		body = models.Readiness{}
+		decodeBody(t, rec.Body, &body)
+		if rec.Code != http.StatusServiceUnavailable || body.Status != "not_ready" || body.Dependency != dependency {
+			t.Fatalf("expected 503 naming %s, got %d %+v", dependency, rec.Code, body)
+		}
+	}
+}
+
+func TestRerollBroadcastsQuestionOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var nextID atomic.Int32
+	questions := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(models.Question{ID: int(nextID.Add(1)), Title: "Q"})
+	}))
+	defer questions.Close()
+	mr.HSet("room:room1", "matchId", "room1", "user1", "u1", "user2", "u2", "status", "ready", "rerollsRemaining", "5")
+
+	// Two instances sharing Redis, the clients connected to the first
+	newInstance := func() *Handlers {
+		rm := room_management.NewRoomManager(mr.Addr(), questions.URL)
+		t.Cleanup(rm.Cleanup)
+		return NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), rm)
+	}
+	local, remote := newInstance(), newInstance()
+	waitUntil(func() bool { return mr.PubSubNumSub("room_updates")["room_updates"] == 2 }, t)
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	room := local.hub.GetOrCreate("room1")
+	for _, userID := range []string{"u1", "u2"} {
+		client := session.NewClient(nil)
+		client.UserID = userID
+		client.SetSendHook(func(frame models.WSFrame) {
+			if frame.Type == "question" {
+				mu.Lock()
+				received[userID]++
+				mu.Unlock()
+			}
+		})
+		room.Join(client)
+	}
+	questionFrames := func(want int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received["u1"] == want && received["u2"] == want
+	}
+
+	for i, instance := range []*Handlers{local, remote} {
+		rec := httptest.NewRecorder()
+		instance.reroll(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collab/room/room1/reroll", nil), "room1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("reroll %d: expected 200, got %d", i, rec.Code)
+		}
+		waitUntil(func() bool { return questionFrames(i + 1) }, t)
+		// Give a duplicate, e.g. our own published event, time to arrive
+		time.Sleep(100 * time.Millisecond)
+		if !questionFrames(i + 1) {
+			mu.Lock()
+			t.Fatalf("reroll %d: expected one question frame per client, got %v", i, received)
+		}
+	}
+}
+
+func TestCollabWSDriverAndNavigator(t *testing.T) {
+	_, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, make(chan time.Time))
+	expect := expectFrame(t)
+	alice, init := dial("tok1")
+	if init.Roles.Driver != "u1" || init.Roles.FreeForAll {
+		t.Fatalf("expected the first user to drive, got %+v", init.Roles)
+	}
+	bob, init := dial("tok2")
+	if init.Roles.Driver != "u1" || init.Roles.Navigator != "u2" {
+		t.Fatalf("expected the second user to navigate, got %+v", init.Roles)
+	}
+
+	// The navigator's edit is refused and undone
+	var msg string
+	var doc models.DocState
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	expect(bob, "error", &msg)
+	expect(bob, "doc", &doc)
+	if msg != "not_driver" || doc.Version != 0 {
+		t.Fatalf("expected not_driver and a resync, got %q %+v", msg, doc)
+	}
+
+	// Handover: the navigator asks, the driver grants
+	var req models.ControlRequest
+	_ = bob.WriteJSON(models.WSFrame{Type: "request_control"})
+	expect(alice, "control_requested", &req)
+	if req.UserID != "u2" {
+		t.Fatalf("unexpected control request: %+v", req)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "grant_control"})
+	var roles models.Roles
+	expect(alice, "roles", &roles)
+	expect(bob, "roles", nil)
+	if roles.Driver != "u2" || roles.Navigator != "u1" {
+		t.Fatalf("expected the roles to swap, got %+v", roles)
+	}
+
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	expect(bob, "doc", &doc)
+	expect(alice, "doc", nil)
+	if doc.Text != "x = 1" {
+		t.Fatalf("expected the new driver's edit to apply, got %+v", doc)
+	}
+	_ = alice.WriteJSON(models.WSFrame{Type: "grant_control"})
+	expect(alice, "error", &msg)
+	if msg != "not_driver" {
+		t.Fatalf("expected only the driver to grant control, got %q", msg)
+	}
+}
+
+func TestCollabWSFreeForAll(t *testing.T) {
+	room := &models.RoomInfo{MatchId: "room1", User1: "u1", User2: "u2", Token1: "tok1", Token2: "tok2"}
+	rm := &mockRoomManager{validateFn: func(string) (*models.RoomInfo, error) { return room, nil }}
+	h := NewHandlersWithDeps(utils.NewLogger(), &mockRunner{}, session.NewHub(), rm)
+	router := chi.NewRouter()
+	router.Get("/ws/session/{id}", h.CollabWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	connect := func(token string, freeForAll bool) (*websocket.Conn, models.InitResponse) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/session/room1?token="+token, nil)
+		if err != nil {
+			t.Fatalf("dial websocket: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		_ = conn.WriteJSON(models.WSFrame{Type: "init", Data: models.InitRequest{Language: models.LangPython, FreeForAll: freeForAll}})
+		var frame models.WSFrame
+		if err := conn.ReadJSON(&frame); err != nil || frame.Type != "init" {
+			t.Fatalf("expected init, got %#v err=%v", frame, err)
+		}
+		var init models.InitResponse
+		marshal(frame.Data, &init)
+		return conn, init
+	}
+	_, init := connect("tok1", true)
+	if !init.Roles.FreeForAll {
+		t.Fatalf("expected a free-for-all room, got %+v", init.Roles)
+	}
+	// The partner's init does not turn the roles back on
+	bob, init := connect("tok2", false)
+	if !init.Roles.FreeForAll {
+		t.Fatalf("expected the first init to decide, got %+v", init.Roles)
+	}
+
+	expect := expectFrame(t)
+	var doc models.DocState
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	expect(bob, "doc", &doc)
+	if doc.Text != "x = 1" {
+		t.Fatalf("expected the navigator to edit a free-for-all room, got %+v", doc)
+	}
+	_ = bob.WriteJSON(models.WSFrame{Type: "request_control"})
+	var msg string
+	expect(bob, "error", &msg)
+	if msg != "roles_disabled" {
+		t.Fatalf("expected roles_disabled, got %q", msg)
+	}
+}
+
+func TestCollabWSRejectsOversizedAndMalformedFrames(t *testing.T) {
+	h, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, nil)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+	expect := expectFrame(t)
+
+	// Frames whose data does not fit their type are refused, not relayed
+	for _, frame := range []models.WSFrame{
+		{Type: "cursor", Data: map[string]any{"pos": "end", "selStart": []int{1}}},
+		{Type: "chat", Data: "hello"},
+	} {
+		_ = alice.WriteJSON(frame)
+		var code string
+		expect(alice, "error", &code)
+		if code != "invalid_payload" {
+			t.Fatalf("%s: expected invalid_payload, got %q", frame.Type, code)
+		}
+	}
+
+	// A long chat message is cut short, and is the first thing the partner sees
+	long := strings.Repeat("é", 2500)
+	_ = alice.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: long}})
+	var ack, received models.ChatMessage
+	expect(alice, "chat_ack", &ack)
+	expect(bob, "chat", &received)
+	if !received.Truncated || utf8.RuneCountInString(received.Message) != 2000 || !strings.HasSuffix(received.Message, "…") {
+		t.Fatalf("expected the message truncated to 2000 characters, got %d truncated=%v", utf8.RuneCountInString(received.Message), received.Truncated)
+	}
+
+	// A frame over the read limit closes the sender's connection
+	_ = bob.WriteJSON(models.WSFrame{Type: "chat", Data: models.Chat{Message: strings.Repeat("x", defaultWSReadLimit)}})
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = bob.ReadMessage()
+	}
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected the connection closed as too big, got %v", err)
+	}
+	room, _ := h.hub.Get("room1")
+	if msgs := room.ChatState().Messages; len(msgs) != 1 {
+		t.Fatalf("expected only the truncated message in the room, got %d", len(msgs))
+	}
+}
+
+func TestCollabWSUndoRedo(t *testing.T) {
+	h, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, nil)
+	h.hub.GetOrCreate("room1").ConfigureRoles(true)
+	alice, _ := dial("tok1")
+	bob, _ := dial("tok2")
+	expect := expectFrame(t)
+	expectDoc := func(want string) {
+		t.Helper()
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			var doc models.DocState
+			expect(conn, "doc", &doc)
+			if doc.Text != want {
+				t.Fatalf("expected %q, got %+v", want, doc)
+			}
+		}
+	}
+	expectError := func(conn *websocket.Conn, want string) {
+		t.Helper()
+		var code string
+		expect(conn, "error", &code)
+		if code != want {
+			t.Fatalf("expected %s, got %q", want, code)
+		}
+	}
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	expectDoc("x = 1")
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 1, RangeStart: 5, RangeEnd: 5, Text: "\ny = 2"}})
+	expectDoc("x = 1\ny = 2")
+
+	// Undo only reverts the sender's own edits
+	_ = alice.WriteJSON(models.WSFrame{Type: "undo"})
+	expectDoc("\ny = 2")
+	_ = alice.WriteJSON(models.WSFrame{Type: "undo"})
+	expectError(alice, "nothing_to_undo")
+	_ = alice.WriteJSON(models.WSFrame{Type: "redo"})
+	expectDoc("x = 1\ny = 2")
+
+	// Once the partner changed the text, it cannot be undone
+	_ = bob.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 4, RangeStart: 4, RangeEnd: 5, Text: "10"}})
+	expectDoc("x = 10\ny = 2")
+	_ = alice.WriteJSON(models.WSFrame{Type: "undo"})
+	expectError(alice, "undo_conflict")
+	_ = bob.WriteJSON(models.WSFrame{Type: "undo"})
+	expectDoc("x = 1\ny = 2")
+}
+
+func TestCollabWSUndoIsRateLimited(t *testing.T) {
+	h, dial := serveTestRoom(t, &mockRoomManager{}, &mockRunner{}, nil)
+	h.hub.SetRoomOptions(session.WithEditRate(2))
+	h.hub.GetOrCreate("room1").ConfigureRoles(true)
+	alice, _ := dial("tok1")
+	expect := expectFrame(t)
+
+	_ = alice.WriteJSON(models.WSFrame{Type: "edit", Data: models.Edit{BaseVersion: 0, Text: "x = 1"}})
+	expect(alice, "doc", nil)
+	_ = alice.WriteJSON(models.WSFrame{Type: "undo"})
+	expect(alice, "doc", nil)
+
+	// The burst of 2 is spent, undo and redo included
+	_ = alice.WriteJSON(models.WSFrame{Type: "redo"})
+	var msg string
+	var doc models.DocState
+	expect(alice, "error", &msg)
+	expect(alice, "doc", &doc)
+	if msg != "rate_limited" || doc.Text != "" || doc.Version != 2 {
+		t.Fatalf("expected rate_limited and a resync, got %q %+v", msg, doc)
+	}
+}
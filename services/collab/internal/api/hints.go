@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+const (
+	// maxHintsPerQuestion is how many hint_request frames a room may have
+	// answered for one question; a reroll starts the count over.
+	maxHintsPerQuestion = 3
+	// hintTimeout bounds one hint from the AI service.
+	hintTimeout = 30 * time.Second
+)
+
+// SetHintClient enables hint_request frames
+func (h *Handlers) SetHintClient(hn hinter) {
+	h.hintClient = hn
+}
+
+// handleHintRequest asks the AI service for a hint on the room's question and
+// code, and sends it to the whole room. Any failure on the way is reported to
+// the requester as hint_unavailable.
+func (h *Handlers) handleHintRequest(room *session.Room, client *session.Client) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	if h.hintClient == nil {
+		h.sendError(client, "hint_unavailable", nil)
+		return
+	}
+	roomInfo, err := h.roomManager.GetRoomStatus(room.ID)
+	if err != nil || roomInfo == nil || roomInfo.Question == nil {
+		h.sendError(client, "hint_unavailable", err)
+		return
+	}
+	question := roomInfo.Question
+	remaining, err := room.TakeHint(question.ID, maxHintsPerQuestion)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.RecordActivity("hint", client.UserID, "")
+
+	go func() {
+		doc, lang := room.Snapshot()
+		code := doc.Text
+		if strings.TrimSpace(code) == "" {
+			code = "(no code written yet)"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), hintTimeout)
+		defer cancel()
+		hint, err := h.hintClient.Hint(ctx, lang, code, question, "")
+		if err != nil {
+			h.log.Error("hint request failed", "roomId", room.ID, "error", err.Error())
+			room.RefundHint(question.ID)
+			h.sendError(client, "hint_unavailable", err)
+			return
+		}
+		room.BroadcastAll(models.WSFrame{Type: "hint", Data: models.Hint{
+			RequestedBy: client.UserID,
+			QuestionID:  question.ID,
+			Hint:        hint,
+			Remaining:   remaining,
+		}})
+	}()
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+const defaultIdleTimeout = 30 * time.Minute
+
+// idleTimeoutFromEnv reads COLLAB_IDLE_TIMEOUT (a Go duration such as "30m"),
+// falling back to the default for missing or invalid values.
+func idleTimeoutFromEnv() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("COLLAB_IDLE_TIMEOUT"))
+	if raw == "" {
+		return defaultIdleTimeout
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return defaultIdleTimeout
+	}
+	return d
+}
+
+// expireIdleRooms sweeps the hub for idle rooms for as long as the process
+// runs. A room is looked at a few times per timeout, so it ends at most a
+// minute or so after going idle.
+func (h *Handlers) expireIdleRooms() {
+	interval := h.idleTimeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.sweepIdleRooms(now)
+	}
+}
+
+// sweepIdleRooms ends every room that has gone idleTimeout without activity
+// as of now.
+func (h *Handlers) sweepIdleRooms(now time.Time) {
+	for _, room := range h.hub.Rooms() {
+		if room.IdleFor(now) >= h.idleTimeout {
+			h.expireRoom(room)
+		}
+	}
+}
+
+// expireRoom tells anyone still connected that the session timed out and
+// ends it like an abandoned one: the room is marked ended, the session
+// reported and the room dropped from the hub. Rooms that never had a client,
+// such as those of HTTP runs, get the handler here.
+func (h *Handlers) expireRoom(room *session.Room) {
+	h.log.Info("Ending idle session", "sessionID", room.ID, "idleTimeout", h.idleTimeout.String())
+	room.BroadcastAll(models.WSFrame{Type: "session_ended", Data: map[string]string{"reason": "idle_timeout"}})
+	room.SetSessionEndHandler(h.handleSessionEnd)
+	room.EndSessionNow()
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/metrics"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+const (
+	// interactiveWallTime replaces the question's wall time for interactive
+	// runs, which is sized for batch input rather than people typing.
+	interactiveWallTime    = 5 * time.Minute
+	interactiveDialTimeout = 10 * time.Second
+)
+
+// interactiveRuns tracks the interactive run of each room. A nil session
+// marks a run that is still starting.
+type interactiveRuns struct {
+	mu    sync.Mutex
+	rooms map[string]exec.InteractiveSession
+}
+
+func (r *interactiveRuns) reserve(roomID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, busy := r.rooms[roomID]; busy {
+		return false
+	}
+	if r.rooms == nil {
+		r.rooms = make(map[string]exec.InteractiveSession)
+	}
+	r.rooms[roomID] = nil
+	return true
+}
+
+func (r *interactiveRuns) set(roomID string, sess exec.InteractiveSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rooms[roomID] = sess
+}
+
+func (r *interactiveRuns) get(roomID string) exec.InteractiveSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rooms[roomID]
+}
+
+// state describes the room's interactive run for the debug dump
+func (r *interactiveRuns) state(roomID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sess, ok := r.rooms[roomID]
+	switch {
+	case !ok:
+		return ""
+	case sess == nil:
+		return "starting"
+	}
+	return "running"
+}
+
+func (r *interactiveRuns) release(roomID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rooms, roomID)
+}
+
+// handleInteractiveRun starts an interactive run for the room. Output is
+// broadcast to everyone as interactive_* frames and either participant can
+// send input; only one interactive run per room at a time.
+func (h *Handlers) handleInteractiveRun(room *session.Room, client *session.Client, run models.RunCmd) {
+	if !languageAllowed(room.ExecutionConfig(), run.Language) {
+		h.sendError(client, "language_not_allowed", nil)
+		return
+	}
+	if !h.interactive.reserve(room.ID) {
+		h.sendError(client, "interactive_run_active", nil)
+		return
+	}
+
+	go func() {
+		limits := runLimitsFor(room.ExecutionConfig())
+		limits.WallTime = interactiveWallTime
+		ctx, cancel := context.WithTimeout(context.Background(), interactiveDialTimeout)
+		metrics.RecordRunStarted(string(run.Language))
+		sess, err := h.runner.StartInteractive(ctx, run.Language, run.Code, limits)
+		cancel()
+		if err != nil {
+			h.interactive.release(room.ID)
+			metrics.RecordRun(string(run.Language), runStreamOutcome(nil, err))
+			h.log.Error("interactive run failed to start", "roomId", room.ID, "error", err.Error())
+			h.sendError(client, "sandbox_unavailable", err)
+			return
+		}
+		h.interactive.set(room.ID, sess)
+		room.BroadcastAll(models.WSFrame{
+			Type: "interactive_started",
+			Data: models.InteractiveStarted{UserID: client.UserID, Language: run.Language},
+		})
+		h.bridgeInteractive(room, run.Language, sess)
+	}()
+}
+
+// bridgeInteractive relays sandbox frames to the room until the run exits.
+func (h *Handlers) bridgeInteractive(room *session.Room, lang models.Language, sess exec.InteractiveSession) {
+	defer h.interactive.release(room.ID)
+	defer sess.Close()
+
+	for {
+		frame, err := sess.Next()
+		if err != nil {
+			// The sandbox went away without an exit event
+			metrics.RecordRun(string(lang), metrics.RunError)
+			room.BroadcastAll(models.WSFrame{
+				Type: "interactive_exit",
+				Data: map[string]any{"code": -1, "timedOut": false, "error": "sandbox_disconnected"},
+			})
+			return
+		}
+		room.BroadcastAll(models.WSFrame{Type: "interactive_" + frame.Type, Data: frame.Data})
+		if frame.Type == "exit" {
+			metrics.RecordRun(string(lang), runStreamOutcome([]models.WSFrame{frame}, nil))
+			return
+		}
+	}
+}
+
+func (h *Handlers) handleInteractiveStdin(room *session.Room, client *session.Client, in models.InteractiveStdin) {
+	sess := h.interactive.get(room.ID)
+	if sess == nil {
+		h.sendError(client, "no_interactive_run", nil)
+		return
+	}
+	if err := sess.WriteStdin(in.Data, in.EOF); err != nil {
+		h.sendError(client, "interactive_run_closed", nil)
+		return
+	}
+	room.Broadcast(client, models.WSFrame{
+		Type: "interactive_stdin",
+		Data: models.InteractiveInput{UserID: client.UserID, Data: in.Data, EOF: in.EOF},
+	})
+}
+
+func (h *Handlers) handleInteractiveKill(room *session.Room, client *session.Client) {
+	sess := h.interactive.get(room.ID)
+	if sess == nil {
+		h.sendError(client, "no_interactive_run", nil)
+		return
+	}
+	if err := sess.Kill(); err != nil {
+		h.sendError(client, "interactive_run_closed", nil)
+	}
+}
+
+// stopInteractive kills the room's interactive run, if any, when the session ends.
+func (h *Handlers) stopInteractive(roomID string) {
+	if sess := h.interactive.get(roomID); sess != nil {
+		_ = sess.Kill()
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/session"
+)
+
+const (
+	// defaultPingInterval is how often the server pings each connection
+	defaultPingInterval = 30 * time.Second
+	// pingWriteWait bounds writing one ping to a slow connection
+	pingWriteWait = 10 * time.Second
+)
+
+// keepAlive pings the client every pingInterval and gives up on reads once
+// it has gone silent for pongWait, which ends the read loop and with it the
+// client's seat in the room. The caller calls alive after every frame it
+// reads, and stop once the connection is done.
+func (h *Handlers) keepAlive(conn *websocket.Conn, client *session.Client) (alive func(), stop func()) {
+	alive = func() {
+		client.MarkSeen()
+		_ = conn.SetReadDeadline(time.Now().Add(h.pongWait))
+	}
+	alive()
+	conn.SetPongHandler(func(string) error {
+		alive()
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(h.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := client.Ping(time.Now().Add(pingWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return alive, func() { close(done) }
+}
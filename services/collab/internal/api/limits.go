@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/session"
+)
+
+// roomOptionsFromEnv reads the document and edit limits of rooms:
+// COLLAB_MAX_DOC_BYTES and COLLAB_EDIT_RATE (edits per second per client),
+// where 0 lifts the limit, and COLLAB_MAX_SPECTATORS, where 0 turns
+// spectating off. COLLAB_PARTNER_GRACE (a Go duration such as "5m") is how
+// long a participant who dropped out has to rejoin; 0 never ends the session
+// for it. COLLAB_RUN_COOLDOWN is the wait between batch runs of a room.
+// Unset or invalid values keep the room defaults.
+func roomOptionsFromEnv() []session.RoomOption {
+	var opts []session.RoomOption
+	if n, ok := nonNegativeIntEnv("COLLAB_MAX_DOC_BYTES"); ok {
+		opts = append(opts, session.WithMaxDocBytes(n))
+	}
+	if n, ok := nonNegativeIntEnv("COLLAB_EDIT_RATE"); ok {
+		opts = append(opts, session.WithEditRate(n))
+	}
+	if n, ok := nonNegativeIntEnv("COLLAB_MAX_SPECTATORS"); ok {
+		opts = append(opts, session.WithMaxSpectators(n))
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("COLLAB_PARTNER_GRACE"))); err == nil && d >= 0 {
+		opts = append(opts, session.WithPartnerGrace(d))
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("COLLAB_RUN_COOLDOWN"))); err == nil && d >= 0 {
+		opts = append(opts, session.WithRunCooldown(d))
+	}
+	return opts
+}
+
+// defaultWSReadLimit is the largest frame read from a client. It leaves room
+// for a draft of maxDraftBytes once JSON-escaped, so an oversized draft still
+// gets draft_too_large rather than a closed connection.
+const defaultWSReadLimit = 2 * maxDraftBytes
+
+// wsReadLimitFromEnv reads COLLAB_WS_MAX_MESSAGE_BYTES, where 0 lifts the limit
+func wsReadLimitFromEnv() int64 {
+	if n, ok := nonNegativeIntEnv("COLLAB_WS_MAX_MESSAGE_BYTES"); ok {
+		return int64(n)
+	}
+	return defaultWSReadLimit
+}
+
+var errMessageTooBig = errors.New("message too big")
+
+// readFrame reads the next message of conn, closing the connection with 1009
+// (message too big) when it is over limit once decompressed. The conn's own
+// read limit only bounds the bytes on the wire, which a compressed message
+// stays well under.
+func readFrame(conn *websocket.Conn, limit int64) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(time.Second))
+		return nil, errMessageTooBig
+	}
+	return data, nil
+}
+
+func nonNegativeIntEnv(name string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
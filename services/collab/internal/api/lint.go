@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/lint"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// lintTimeout bounds one lint, queueing for the sandbox included
+const lintTimeout = 10 * time.Second
+
+// Lint runs the static check of the request's language on its code and
+// returns the problems found.
+func (h *Handlers) Lint(w http.ResponseWriter, r *http.Request) {
+	var req models.LintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), lintTimeout)
+	defer cancel()
+
+	diagnostics, err := h.linter.Lint(ctx, req.Language, req.Code)
+	switch {
+	case err == nil:
+		writeJSON(w, models.Diagnostics{Language: req.Language, Diagnostics: diagnostics})
+	case errors.Is(err, lint.ErrUnsupportedLanguage):
+		h.writeError(w, r, http.StatusBadRequest, "unsupported_language", err)
+	case errors.Is(err, exec.ErrDockerUnavailable):
+		h.writeError(w, r, http.StatusServiceUnavailable, "sandbox_unavailable", err)
+	default:
+		h.writeError(w, r, http.StatusInternalServerError, "lint_failed", err)
+	}
+}
+
+// handleLintRequest lints the room's document in its language and sends the
+// diagnostics to the whole room, so both editors show the same squiggles.
+func (h *Handlers) handleLintRequest(room *session.Room, client *session.Client) {
+	doc, lang := room.Snapshot()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), lintTimeout)
+		defer cancel()
+		diagnostics, err := h.linter.Lint(ctx, lang, doc.Text)
+		if err != nil {
+			h.log.Error("lint failed", "roomId", room.ID, "error", err.Error())
+			code := "lint_failed"
+			switch {
+			case errors.Is(err, lint.ErrUnsupportedLanguage):
+				code = "unsupported_language"
+			case errors.Is(err, exec.ErrDockerUnavailable):
+				code = "sandbox_unavailable"
+			}
+			h.sendError(client, code, err)
+			return
+		}
+		room.BroadcastAll(models.WSFrame{Type: "diagnostics", Data: models.Diagnostics{
+			Language:    lang,
+			Version:     doc.Version,
+			Diagnostics: diagnostics,
+		}})
+	}()
+}
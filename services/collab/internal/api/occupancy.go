@@ -0,0 +1,38 @@
+package api
+
+import (
+	"sort"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// publishOccupancy records who is connected to room on this instance, for
+// room status answers on any instance
+func (h *Handlers) publishOccupancy(room *session.Room) {
+	room.PublishOccupancy(func(userIDs []string) {
+		if err := h.roomManager.SetOccupancy(room.ID, userIDs); err != nil {
+			h.log.Error("failed to publish occupancy", "roomId", room.ID, "error", err.Error())
+		}
+	})
+}
+
+// occupancy merges the users connected on this instance, from its hub, with
+// those the other instances published. Without Redis it falls back to this
+// instance's own.
+func (h *Handlers) occupancy(matchID string, local []string) *models.Occupancy {
+	published, err := h.roomManager.RemoteOccupancy(matchID)
+	if err != nil {
+		h.log.Error("failed to load occupancy", "matchId", matchID, "error", err.Error())
+	}
+	seen := make(map[string]bool)
+	connected := []string{}
+	for _, id := range append(local, published...) {
+		if !seen[id] {
+			seen[id] = true
+			connected = append(connected, id)
+		}
+	}
+	sort.Strings(connected)
+	return &models.Occupancy{Connected: connected, Count: len(connected)}
+}
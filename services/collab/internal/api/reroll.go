@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"collab/internal/i18n"
+	"collab/internal/models"
+	"collab/internal/room_management"
+	"collab/internal/session"
+)
+
+// defaultRerollTimeout bounds how long a proposed reroll waits for the partner.
+const defaultRerollTimeout = 30 * time.Second
+
+// ApproveReroll approves the partner's proposed reroll over HTTP, like a
+// reroll_vote frame.
+func (h *Handlers) ApproveReroll(w http.ResponseWriter, r *http.Request) {
+	matchId, userID, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+	room, ok := h.hub.Get(matchId)
+	if !ok {
+		h.writeError(w, r, http.StatusConflict, "no_pending_reroll", nil)
+		return
+	}
+	if _, err := room.VoteReroll(userID); err != nil {
+		h.writeError(w, r, http.StatusConflict, errorCode(err), nil)
+		return
+	}
+	h.reroll(w, r, matchId)
+}
+
+// handleRerollVote settles a proposed reroll from the partner's frame. The
+// new question reaches the room through handleRoomUpdate like any reroll.
+func (h *Handlers) handleRerollVote(room *session.Room, client *session.Client, vote models.RerollVote) {
+	requester, err := room.VoteReroll(client.UserID)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if !vote.Approve {
+		room.BroadcastAll(models.WSFrame{Type: "reroll_rejected", Data: map[string]string{"userId": client.UserID, "requester": requester}})
+		return
+	}
+	if _, err := h.roomManager.RerollQuestion(room.ID); err != nil {
+		status, code := rerollError(err)
+		if status == http.StatusInternalServerError {
+			h.log.Error("failed to reroll question", "matchId", room.ID, "error", err.Error())
+		}
+		// Both participants are waiting on the reroll
+		room.BroadcastAll(h.errorFrame(i18n.DefaultLocale, code, err))
+	}
+}
+
+// rerollError maps a failed reroll to its status and error code
+func rerollError(err error) (int, string) {
+	switch {
+	case errors.Is(err, room_management.ErrNoRerolls):
+		return http.StatusBadRequest, "no_rerolls"
+	case errors.Is(err, room_management.ErrNoAlternativeQuestion):
+		return http.StatusConflict, "no_alternative_question"
+	case errors.Is(err, room_management.ErrQuestionServiceTimeout):
+		return http.StatusGatewayTimeout, "question_service_timeout"
+	}
+	return http.StatusInternalServerError, "reroll_failed"
+}
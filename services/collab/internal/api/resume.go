@@ -0,0 +1,16 @@
+package api
+
+import (
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// resumeClient sends a reconnecting client the run output and chat it missed
+// while away and tells the partner it is back.
+func (h *Handlers) resumeClient(room *session.Room, client *session.Client, resume models.ResumeMarkers) {
+	room.ReplayRunHistorySince(client, resume.RunSeq, resume.RunFrame)
+	for _, msg := range room.ChatSince(resume.ChatID) {
+		client.Send(models.WSFrame{Type: "chat", Data: msg})
+	}
+	room.Broadcast(client, models.WSFrame{Type: "peer_reconnected", Data: map[string]string{"userId": client.UserID}})
+}
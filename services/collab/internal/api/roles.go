@@ -0,0 +1,35 @@
+package api
+
+import (
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// handleRequestControl asks the driver to hand over control, or takes it
+// when the driver is not connected.
+func (h *Handlers) handleRequestControl(room *session.Room, client *session.Client) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	roles, taken, err := room.RequestControl(client)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if taken {
+		room.BroadcastAll(models.WSFrame{Type: "roles", Data: roles})
+		return
+	}
+	room.Broadcast(client, models.WSFrame{Type: "control_requested", Data: models.ControlRequest{UserID: client.UserID}})
+}
+
+// handleGrantControl swaps the driver and navigator at the driver's request.
+func (h *Handlers) handleGrantControl(room *session.Room, client *session.Client) {
+	roles, err := room.GrantControl(client)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.BroadcastAll(models.WSFrame{Type: "roles", Data: roles})
+}
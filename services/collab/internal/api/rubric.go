@@ -0,0 +1,55 @@
+package api
+
+import (
+	"strconv"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// handleRubricCheck ticks or unticks a rubric item for either participant and
+// broadcasts the resulting checklist to the whole room.
+func (h *Handlers) handleRubricCheck(room *session.Room, client *session.Client, check models.RubricCheck) {
+	state, changed, err := room.ToggleRubric(client.UserID, check)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if !changed {
+		return
+	}
+	room.RecordActivity("rubric", client.UserID, strconv.Itoa(check.Index))
+	if err := h.roomManager.SaveRubricState(room.ID, state.Items); err != nil {
+		h.log.Error("Failed to persist rubric state", "roomId", room.ID, "error", err.Error())
+	}
+	room.BroadcastAll(models.WSFrame{Type: "rubric", Data: state})
+}
+
+// applyRubric installs the question's rubric on the room and notifies clients
+// when it changed, e.g. after a reroll. The ticks start over for new items.
+func (h *Handlers) applyRubric(room *session.Room, items []string) {
+	if !room.SetRubric(items) {
+		return
+	}
+	state := room.Rubric()
+	if state == nil {
+		state = &models.RubricState{Items: []models.RubricItem{}}
+	}
+	if err := h.roomManager.SaveRubricState(room.ID, state.Items); err != nil {
+		h.log.Error("Failed to persist rubric state", "roomId", room.ID, "error", err.Error())
+	}
+	room.BroadcastAll(models.WSFrame{Type: "rubric", Data: state})
+}
+
+// restoreRubric seeds a room with its persisted ticks so they survive
+// reconnects and instance restarts.
+func (h *Handlers) restoreRubric(room *session.Room) {
+	items, err := h.roomManager.LoadRubricState(room.ID)
+	if err != nil {
+		h.log.Error("Failed to load rubric state", "roomId", room.ID, "error", err.Error())
+		return
+	}
+	if len(items) > 0 {
+		room.RestoreRubric(items)
+	}
+}
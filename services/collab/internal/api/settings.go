@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// handleSettingsUpdate applies a settings_update from either participant and
+// broadcasts the resulting settings to the whole room.
+func (h *Handlers) handleSettingsUpdate(room *session.Room, client *session.Client, changes map[string]any) {
+	if len(changes) == 0 {
+		return
+	}
+	settings, changed, err := room.UpdateSettings(changes)
+	var settingErr *session.SettingError
+	if errors.As(err, &settingErr) {
+		frame := h.errorFrame(client.Locale, settingErr.Error(), nil)
+		frame.Data = models.InvalidSetting{
+			Error:   settingErr.Error(),
+			Key:     settingErr.Key,
+			Allowed: settingErr.Allowed,
+		}
+		client.Send(frame)
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	values := make([]any, 0, 2*len(changed))
+	for _, key := range changed {
+		values = append(values, key, fmt.Sprint(changes[key]))
+	}
+	h.log.Info("Room settings changed", append([]any{"roomId", room.ID, "userId", client.UserID}, values...)...)
+	room.RecordActivity("settings", client.UserID, strings.Join(changed, ","))
+
+	if err := h.roomManager.SaveRoomSettings(room.ID, settings); err != nil {
+		h.log.Error("Failed to persist room settings", "roomId", room.ID, "error", err.Error())
+	}
+	room.BroadcastAll(models.WSFrame{
+		Type: "settings",
+		Data: models.SettingsChanged{Settings: settings, Changed: changed, UpdatedBy: client.UserID},
+	})
+}
+
+// restoreSettings seeds a room with its persisted settings so they survive
+// reconnects and instance restarts.
+func (h *Handlers) restoreSettings(room *session.Room) {
+	settings, err := h.roomManager.LoadRoomSettings(room.ID)
+	if err != nil {
+		h.log.Error("Failed to load room settings", "roomId", room.ID, "error", err.Error())
+		return
+	}
+	if settings != nil {
+		room.RestoreSettings(*settings)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// defaultSnapshotEvery is how many document versions go by between two
+// snapshots of a room
+const defaultSnapshotEvery = 50
+
+// snapshotEveryFromEnv reads COLLAB_SNAPSHOT_EVERY, a number of versions;
+// 0 turns snapshots off
+func snapshotEveryFromEnv() int64 {
+	if raw := strings.TrimSpace(os.Getenv("COLLAB_SNAPSHOT_EVERY")); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultSnapshotEvery
+}
+
+// ListSnapshots returns the room's recent document snapshots, oldest first
+// (requires a room token)
+func (h *Handlers) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	matchId, _, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+	snapshots, err := h.roomManager.ListSnapshots(matchId)
+	if err != nil {
+		h.log.Error("failed to load snapshots", "matchId", matchId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, "snapshots_unavailable", nil)
+		return
+	}
+	writeJSON(w, snapshots)
+}
+
+// handleSnapshotRestore replaces the shared document with the text of one of
+// the room's snapshots. The restore is an ordinary edit, so every client
+// receives the bumped doc.
+func (h *Handlers) handleSnapshotRestore(room *session.Room, client *session.Client, req models.SnapshotRestore) {
+	if err := room.CheckTurn(client.UserID); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	snapshots, err := h.roomManager.ListSnapshots(room.ID)
+	if err != nil {
+		h.log.Error("failed to load snapshots", "roomId", room.ID, "error", err.Error())
+		h.sendError(client, "snapshots_unavailable", err)
+		return
+	}
+	var snap *models.Snapshot
+	for i := range snapshots {
+		if snapshots[i].Version == req.Version {
+			snap = &snapshots[i]
+		}
+	}
+	if snap == nil {
+		h.sendError(client, "unknown_snapshot", nil)
+		return
+	}
+
+	doc, err := room.ReplaceDoc(snap.Text)
+	if err != nil {
+		h.sendError(client, mapOTError(err), err)
+		return
+	}
+	room.RecordActivity("restore_snapshot", client.UserID, strconv.FormatInt(snap.Version, 10))
+	room.BroadcastDoc(nil, nil, doc)
+	room.BroadcastAll(models.WSFrame{Type: "snapshot_restored", Data: map[string]any{"userId": client.UserID, "snapshotVersion": snap.Version, "version": doc.Version}})
+}
@@ -0,0 +1,51 @@
+package api
+
+import "collab/internal/models"
+
+// readOnlyFrames are the frames a spectator may not send: anything that would
+// change the code or its language, run it, or steer the session. Spectators
+// can still chat and move their cursor.
+var readOnlyFrames = map[string]bool{
+	"edit":                  true,
+	"undo":                  true,
+	"redo":                  true,
+	"language":              true,
+	"restore_snapshot":      true,
+	"draft_save":            true,
+	"draft_restore":         true,
+	"draft_restore_confirm": true,
+	"run":                   true,
+	"run_tests":             true,
+	"cancel_run":            true,
+	"interactive_run":       true,
+	"interactive_stdin":     true,
+	"interactive_kill":      true,
+	"settings_update":       true,
+	"rubric_check":          true,
+	"mode_set":              true,
+	"mode_confirm":          true,
+	"turn_pass":             true,
+	"request_control":       true,
+	"grant_control":         true,
+	"reroll_vote":           true,
+	"hint_request":          true,
+	"lint_request":          true,
+	"end_session":           true,
+	"end_session_confirm":   true,
+	"end_session_cancel":    true,
+}
+
+// collabAccess validates the token of a collab connection. A participant
+// token takes a seat in the room; a spectator token only lets its holder
+// watch. The error is the participant check's when neither accepts the token.
+func (h *Handlers) collabAccess(token string) (roomInfo *models.RoomInfo, userID string, spectator bool, err error) {
+	roomInfo, err = h.roomManager.ValidateRoomAccess(token)
+	if err == nil {
+		return roomInfo, participantID(roomInfo, token), false, nil
+	}
+	roomInfo, userID, specErr := h.roomManager.ValidateSpectatorAccess(token)
+	if specErr != nil {
+		return nil, "", false, err
+	}
+	return roomInfo, userID, true, nil
+}
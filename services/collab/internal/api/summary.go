@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"collab/internal/i18n"
+	"collab/internal/models"
+	"collab/internal/utils"
+)
+
+// saveSummary keeps the ended session so the history page can show it
+// without the history service. Either participant's room token still reads
+// it after the room is gone.
+func (h *Handlers) saveSummary(event models.SessionEndedEvent, roomInfo *models.RoomInfo) {
+	summary := models.SessionSummary{SessionEndedEvent: event}
+	for _, token := range []string{roomInfo.Token1, roomInfo.Token2} {
+		if token != "" {
+			summary.Tokens = append(summary.Tokens, token)
+		}
+	}
+	if err := h.roomManager.SaveSessionSummary(summary); err != nil {
+		h.log.Error("Failed to save session summary", "sessionID", event.MatchID, "error", err.Error())
+	}
+}
+
+// GetSessionSummary returns the summary of an ended session to one of its
+// participants, identified by their room token or by ?userId=
+func (h *Handlers) GetSessionSummary(w http.ResponseWriter, r *http.Request) {
+	matchId := chi.URLParam(r, "matchId")
+	if matchId == "" {
+		h.writeError(w, r, http.StatusBadRequest, "match_id_required", nil)
+		return
+	}
+	token, _ := utils.ExtractTokenFromHeader(r.Header.Get("Authorization"))
+	userID := r.URL.Query().Get("userId")
+	if token == "" && userID == "" {
+		h.writeError(w, r, http.StatusUnauthorized, "token_required", nil)
+		return
+	}
+
+	summary, err := h.roomManager.LoadSessionSummary(matchId)
+	if err != nil {
+		h.log.Error("failed to load session summary", "matchId", matchId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, i18n.GenericCode, err)
+		return
+	}
+	if summary == nil {
+		h.writeError(w, r, http.StatusNotFound, "no_summary", nil)
+		return
+	}
+	if !summaryReader(summary, token, userID) {
+		h.writeError(w, r, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+	writeJSON(w, summary.SessionEndedEvent)
+}
+
+// summaryReader reports whether the token or user ID belongs to a participant
+// of the summarized session
+func summaryReader(summary *models.SessionSummary, token, userID string) bool {
+	if userID != "" && (userID == summary.User1 || userID == summary.User2) {
+		return true
+	}
+	for _, t := range summary.Tokens {
+		if token != "" && token == t {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	defaultRecentSessions = 10
+	maxRecentSessions     = 50
+)
+
+// recordUserSessions adds the ended session to both participants' recent
+// sessions, each with the other as partner
+func (h *Handlers) recordUserSessions(event models.SessionEndedEvent) {
+	for _, pair := range [][2]string{{event.User1, event.User2}, {event.User2, event.User1}} {
+		userID, partnerID := pair[0], pair[1]
+		if userID == "" {
+			continue
+		}
+		record := models.SessionRecord{
+			MatchID:       event.MatchID,
+			QuestionTitle: event.QuestionTitle,
+			Language:      event.Language,
+			DurationSec:   event.DurationSec,
+			PartnerID:     partnerID,
+			EndedAt:       event.EndedAt,
+		}
+		if err := h.roomManager.RecordUserSession(userID, record); err != nil {
+			h.log.Error("Failed to record user session", "sessionID", event.MatchID, "userId", userID, "error", err.Error())
+		}
+	}
+}
+
+// GetUserSessions returns the latest sessions a user took part in, newest
+// first; ?limit= defaults to 10 and is capped at 50
+func (h *Handlers) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		h.writeError(w, r, http.StatusBadRequest, "user_id_required", nil)
+		return
+	}
+	limit := defaultRecentSessions
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_limit", nil)
+			return
+		}
+		limit = min(n, maxRecentSessions)
+	}
+
+	records, err := h.roomManager.RecentSessions(userId, limit)
+	if err != nil {
+		h.log.Error("failed to load user sessions", "userId", userId, "error", err.Error())
+		h.writeError(w, r, http.StatusInternalServerError, i18n.GenericCode, err)
+		return
+	}
+	writeJSON(w, records)
+}
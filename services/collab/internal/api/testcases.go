@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+var (
+	errNoTests             = errors.New("no_tests_available")
+	errQuestionUnavailable = errors.New("question_unavailable")
+	errLanguageNotAllowed  = errors.New("language_not_allowed")
+)
+
+// RunTests runs the code against the examples of the room's question, like a
+// run_tests frame. The results also go to the room's run output.
+func (h *Handlers) RunTests(w http.ResponseWriter, r *http.Request) {
+	matchId, userID, ok := h.roomAccess(w, r)
+	if !ok {
+		return
+	}
+	var cmd models.RunTestsCmd
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+
+	room := h.hub.GetOrCreate(matchId)
+	cases, seq, err := h.prepareTests(room, userID, cmd)
+	if err != nil {
+		h.writeError(w, r, testsStatus(err), errorCode(err), nil)
+		return
+	}
+	defer room.EndRun(seq)
+
+	results, err := h.runTests(room, userID, cmd, cases)
+	if err != nil {
+		if errors.Is(err, exec.ErrDockerUnavailable) {
+			h.writeError(w, r, http.StatusServiceUnavailable, "sandbox_unavailable", err)
+		} else {
+			h.writeError(w, r, http.StatusInternalServerError, "run_failed", err)
+		}
+		return
+	}
+	room.RecordRunFrame(models.WSFrame{Type: "test_results", Data: results})
+	writeJSON(w, results)
+}
+
+// handleRunTests runs the code of a run_tests frame against the question's
+// examples. The room shares one run slot between runs and tests.
+func (h *Handlers) handleRunTests(room *session.Room, client *session.Client, cmd models.RunTestsCmd) {
+	cases, seq, err := h.prepareTests(room, client.UserID, cmd)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	go func() {
+		defer room.EndRun(seq)
+		results, err := h.runTests(room, client.UserID, cmd, cases)
+		if err != nil {
+			code := "run_failed"
+			if errors.Is(err, exec.ErrDockerUnavailable) {
+				code = "sandbox_unavailable"
+			}
+			h.sendError(client, code, err)
+			return
+		}
+		room.RecordRunFrame(models.WSFrame{Type: "test_results", Data: results})
+	}()
+}
+
+// prepareTests loads the examples of the room's question and takes the room's
+// run slot for userID. The caller ends the run with the returned seq.
+func (h *Handlers) prepareTests(room *session.Room, userID string, cmd models.RunTestsCmd) ([]models.TestCase, int, error) {
+	roomInfo, err := h.roomManager.GetRoomStatus(room.ID)
+	if err != nil || roomInfo == nil {
+		h.log.Error("failed to load question for tests", "roomId", room.ID, "error", fmt.Sprint(err))
+		return nil, 0, errQuestionUnavailable
+	}
+	if roomInfo.Question == nil || len(roomInfo.Question.TestCases) == 0 {
+		return nil, 0, errNoTests
+	}
+	room.SetExecutionConfig(roomInfo.Question.Execution)
+	if !languageAllowed(room.ExecutionConfig(), cmd.Language) {
+		return nil, 0, errLanguageNotAllowed
+	}
+	if err := room.CheckTurn(userID); err != nil {
+		return nil, 0, err
+	}
+	seq, err := room.StartRun(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	room.RecordActivity("run_tests", userID, string(cmd.Language))
+	return roomInfo.Question.TestCases, seq, nil
+}
+
+// runTests runs the code once per example with its input as stdin. Cancelling
+// the room's run stops at the current case and returns what ran so far.
+func (h *Handlers) runTests(room *session.Room, userID string, cmd models.RunTestsCmd, cases []models.TestCase) (models.TestResults, error) {
+	cfg := room.ExecutionConfig()
+	limits := runLimitsFor(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(cases))*(limits.WallTime+2*time.Second))
+	defer cancel()
+	h.batch.start(room.ID, cancel)
+	defer h.batch.finish(room.ID)
+
+	results := models.TestResults{By: userID, Language: cmd.Language, Total: len(cases), Cases: []models.TestCaseResult{}}
+	for i, tc := range cases {
+		out, err := h.runner.RunOnce(ctx, cmd.Language, cmd.Code, withInvocation(limits, cfg, nil, nil, tc.Input))
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				results.Cancelled = true
+				return results, nil
+			}
+			return models.TestResults{}, err
+		}
+		if out.CompileOutput != "" {
+			// Every case would fail the same way
+			results.CompileOutput = out.CompileOutput
+			return results, nil
+		}
+		result := models.TestCaseResult{
+			Index: i, Input: tc.Input, Expected: tc.Output, Actual: out.Stdout,
+			Stderr: out.Stderr, Exit: out.Exit, TimedOut: out.TimedOut,
+			Diff: outputDiff(tc.Output, out.Stdout),
+		}
+		result.Passed = result.Diff == "" && out.Exit == 0 && !out.TimedOut
+		if result.Passed {
+			results.Passed++
+		}
+		results.Cases = append(results.Cases, result)
+	}
+	return results, nil
+}
+
+// testsStatus maps a refused test run to its HTTP status
+func testsStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoTests):
+		return http.StatusNotFound
+	case errors.Is(err, errLanguageNotAllowed):
+		return http.StatusBadRequest
+	case errors.Is(err, session.ErrNotYourTurn):
+		return http.StatusForbidden
+	case errors.Is(err, session.ErrRunInProgress):
+		return http.StatusConflict
+	case errors.Is(err, session.ErrRunRateLimited), errors.Is(err, session.ErrRunCooldown):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// outputDiff compares the program's output with the expected one line by
+// line, ignoring trailing whitespace and blank lines at the end. It returns
+// "" when they match.
+func outputDiff(expected, actual string) string {
+	want, got := outputLines(expected), outputLines(actual)
+	var b strings.Builder
+	for i := 0; i < max(len(want), len(got)); i++ {
+		if i < len(want) && i < len(got) && want[i] == got[i] {
+			continue
+		}
+		fmt.Fprintf(&b, "line %d:\n", i+1)
+		if i < len(want) {
+			fmt.Fprintf(&b, "- %s\n", want[i])
+		}
+		if i < len(got) {
+			fmt.Fprintf(&b, "+ %s\n", got[i])
+		}
+	}
+	return b.String()
+}
+
+func outputLines(s string) []string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"collab/internal/analysis"
+	"collab/internal/i18n"
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+const (
+	// defaultThreadAIBudget is how many assistant replies a room may ask for.
+	defaultThreadAIBudget = 5
+	// threadAITimeout bounds one assistant reply.
+	threadAITimeout = 30 * time.Second
+	// maxThreadDiscussion bounds the thread text sent to the AI service, which
+	// rejects longer discussions. The oldest replies are dropped first.
+	maxThreadDiscussion = 8000 // runes
+)
+
+type hinter interface {
+	Hint(ctx context.Context, lang models.Language, code string, question *models.Question, discussion string) (string, error)
+}
+
+// SetHinter enables assistant replies in discussion threads
+func (h *Handlers) SetHinter(hn hinter) {
+	h.hinter = hn
+}
+
+// threadAIBudgetFromEnv reads COLLAB_THREAD_AI_BUDGET, the assistant replies
+// allowed per room. 0 disables them without turning off the AI client.
+func threadAIBudgetFromEnv() int {
+	raw := strings.TrimSpace(os.Getenv("COLLAB_THREAD_AI_BUDGET"))
+	if raw == "" {
+		return defaultThreadAIBudget
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return defaultThreadAIBudget
+	}
+	return n
+}
+
+func (h *Handlers) handleThreadCreate(room *session.Room, client *session.Client, create models.ThreadCreate) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	thread, err := room.CreateThread(client.UserID, create)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.RecordActivity("thread", client.UserID, strconv.FormatInt(thread.ID, 10))
+	h.persistThreads(room)
+	room.BroadcastAll(models.WSFrame{Type: "thread_created", Data: thread})
+}
+
+// handleThreadReply posts a participant's reply and, when it asks for one,
+// fetches the assistant's answer in the background.
+func (h *Handlers) handleThreadReply(room *session.Room, client *session.Client, cmd models.ThreadReplyCmd) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	if cmd.AskAI && h.hinter == nil {
+		h.sendError(client, "ai_unavailable", nil)
+		return
+	}
+	reply, err := room.ReplyToThread(client.UserID, cmd, h.threadAIBudget)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.RecordActivity("thread_reply", client.UserID, strconv.FormatInt(cmd.ThreadID, 10))
+	h.persistThreads(room)
+	room.BroadcastAll(models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyAdded{ThreadID: cmd.ThreadID, Reply: reply}})
+	if cmd.AskAI {
+		go h.answerThread(room, cmd.ThreadID)
+	}
+}
+
+func (h *Handlers) handleThreadResolve(room *session.Room, client *session.Client, resolve models.ThreadResolve) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	resolved, err := room.ResolveThread(client.UserID, resolve.ThreadID)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.RecordActivity("thread_resolve", client.UserID, strconv.FormatInt(resolve.ThreadID, 10))
+	h.persistThreads(room)
+	room.BroadcastAll(models.WSFrame{Type: "thread_resolved", Data: resolved})
+}
+
+// answerThread asks the AI service to answer a thread and posts the answer
+// as an assistant reply. When it fails, a system reply says so and the
+// budget spent on the request is given back.
+func (h *Handlers) answerThread(room *session.Room, threadID int64) {
+	thread, ok := room.Thread(threadID)
+	if !ok {
+		room.RefundThreadAI()
+		return
+	}
+	var question *models.Question
+	if roomInfo, err := h.roomManager.GetRoomStatus(room.ID); err == nil && roomInfo != nil {
+		question = roomInfo.Question
+	}
+	doc, lang := room.Snapshot()
+	code := doc.Text
+	if strings.TrimSpace(code) == "" {
+		code = "(no code written yet)"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), threadAITimeout)
+	defer cancel()
+
+	author := models.ThreadAuthorAssistant
+	body, err := h.hinter.Hint(ctx, lang, code, question, threadDiscussion(thread))
+	if err != nil {
+		h.log.Error("thread assistant reply failed", "roomId", room.ID, "threadId", threadID, "error", err.Error())
+		room.RefundThreadAI()
+		failure := "ai_failed"
+		if errors.Is(err, analysis.ErrQuotaExceeded) {
+			failure = "ai_quota_exceeded"
+		}
+		// Threads are shared by both participants, so the notice is kept in the default locale
+		author, body = models.ThreadAuthorSystem, i18n.Message(i18n.DefaultLocale, failure)
+	}
+
+	reply, err := room.PostThreadReply(threadID, author, body)
+	if err != nil {
+		h.log.Error("Failed to post thread reply", "roomId", room.ID, "threadId", threadID, "error", err.Error())
+		return
+	}
+	h.persistThreads(room)
+	room.BroadcastAll(models.WSFrame{Type: "thread_reply", Data: models.ThreadReplyAdded{ThreadID: threadID, Reply: reply}})
+}
+
+// threadDiscussion renders a thread as the transcript the assistant answers,
+// keeping the most recent replies that fit the AI service's limit. The opening
+// post alone always fits.
+func threadDiscussion(thread models.Thread) string {
+	head := "Thread: " + thread.Title + "\n" + thread.Author + ": " + thread.Body + "\n"
+	budget := maxThreadDiscussion - len([]rune(head))
+	var lines []string
+	for i := len(thread.Replies) - 1; i >= 0; i-- {
+		line := thread.Replies[i].Author + ": " + thread.Replies[i].Body + "\n"
+		n := len([]rune(line))
+		if n > budget {
+			break
+		}
+		budget -= n
+		lines = append(lines, line)
+	}
+	var b strings.Builder
+	b.WriteString(head)
+	for i := len(lines) - 1; i >= 0; i-- {
+		b.WriteString(lines[i])
+	}
+	return b.String()
+}
+
+// persistThreads stores the room's threads after every change.
+func (h *Handlers) persistThreads(room *session.Room) {
+	if err := h.roomManager.SaveThreadState(room.ID, room.ThreadState()); err != nil {
+		h.log.Error("Failed to persist thread state", "roomId", room.ID, "error", err.Error())
+	}
+}
+
+// restoreThreads seeds a room with its persisted threads so they survive
+// reconnects and instance restarts.
+func (h *Handlers) restoreThreads(room *session.Room) {
+	state, err := h.roomManager.LoadThreadState(room.ID)
+	if err != nil {
+		h.log.Error("Failed to load thread state", "roomId", room.ID, "error", err.Error())
+		return
+	}
+	if state != nil {
+		room.RestoreThreadState(*state)
+	}
+}
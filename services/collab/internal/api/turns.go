@@ -0,0 +1,66 @@
+package api
+
+import (
+	"collab/internal/models"
+	"collab/internal/session"
+)
+
+// handleModeSet asks the partner to approve switching the room's editing mode.
+func (h *Handlers) handleModeSet(room *session.Room, client *session.Client, set models.ModeSet) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	req, err := room.RequestModeChange(client, set)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.Broadcast(client, models.WSFrame{Type: "mode_request", Data: req})
+	client.Send(models.WSFrame{Type: "mode_pending", Data: req})
+}
+
+// handleModeConfirm applies or rejects the pending mode change. Turn changes
+// are announced by the room itself as turn_changed frames.
+func (h *Handlers) handleModeConfirm(room *session.Room, client *session.Client, confirm models.ModeConfirm) {
+	requester, state, err := room.ResolveModeChange(client, confirm.Accept)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if !confirm.Accept {
+		requester.Send(models.WSFrame{Type: "mode_rejected", Data: map[string]string{"userId": client.UserID}})
+		return
+	}
+	room.RecordActivity("mode", client.UserID, state.Mode)
+	room.BroadcastAll(models.WSFrame{Type: "mode_changed", Data: state})
+}
+
+func (h *Handlers) handleTurnPass(room *session.Room, client *session.Client) {
+	if err := room.PassTurn(client); err != nil {
+		h.sendError(client, errorCode(err), err)
+	}
+}
+
+// persistTurns stores the turn state after every change, including the
+// rotations the room's timers make on their own.
+func (h *Handlers) persistTurns(room *session.Room) {
+	room.SetTurnStateHandler(func(state models.TurnState) {
+		if err := h.roomManager.SaveTurnState(room.ID, state); err != nil {
+			h.log.Error("Failed to persist turn state", "roomId", room.ID, "error", err.Error())
+		}
+	})
+}
+
+// restoreTurns seeds a room with its persisted turn state so turns survive
+// reconnects and instance restarts.
+func (h *Handlers) restoreTurns(room *session.Room) {
+	state, err := h.roomManager.LoadTurnState(room.ID)
+	if err != nil {
+		h.log.Error("Failed to load turn state", "roomId", room.ID, "error", err.Error())
+		return
+	}
+	if state != nil {
+		room.RestoreTurnState(*state)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"collab/internal/session"
+)
+
+// handleUndo reverts the client's last edit, or reapplies the edit their
+// last undo reverted when redo is set. Either way it is a new edit on the
+// room's document, sent to everyone like one.
+func (h *Handlers) handleUndo(room *session.Room, client *session.Client, redo bool) {
+	if client.UserID == "" {
+		h.sendError(client, "unknown_user", nil)
+		return
+	}
+	if !room.AllowEdit(client) {
+		// Counted like an edit, so undo cannot get around the edit rate
+		h.sendError(client, "rate_limited", nil)
+		doc, _ := room.Snapshot()
+		client.SendDoc(nil, doc)
+		return
+	}
+	if err := room.CheckTurn(client.UserID); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	if err := room.CheckDriver(client.UserID); err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	undo, action := room.Undo, "undo"
+	if redo {
+		undo, action = room.Redo, "redo"
+	}
+	prev, doc, err := undo(client.UserID)
+	if err != nil {
+		h.sendError(client, errorCode(err), err)
+		return
+	}
+	room.RecordActivity(action, client.UserID, "")
+	room.BroadcastDoc(client, &prev, doc)
+	client.SendDoc(&prev, doc)
+}
@@ -0,0 +1,293 @@
+package exec
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"collab/internal/metrics"
+)
+
+const (
+	defaultFailThreshold = 3
+	defaultProbeInterval = 5 * time.Second
+	defaultDNSRefresh    = 30 * time.Second
+	// latencyWindow is the number of recent successful runs the p95 covers
+	latencyWindow = 64
+)
+
+// attemptResult is how a request to a backend went, for its health
+type attemptResult int
+
+const (
+	attemptOK       attemptResult = iota
+	attemptFailed                 // transport error, 5xx or sandbox_unavailable
+	attemptCanceled               // the caller gave up, e.g. a hedge that lost
+)
+
+// backend is one sandbox replica. Its fields are guarded by the pool's mutex.
+type backend struct {
+	url string
+
+	inFlight  int
+	fails     int
+	healthy   bool
+	probing   bool
+	latencies []time.Duration // ring of the last latencyWindow successful runs
+	next      int
+}
+
+func (b *backend) p95() time.Duration {
+	if len(b.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), b.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*95+99)/100-1]
+}
+
+func (b *backend) observe(d time.Duration) {
+	if len(b.latencies) < latencyWindow {
+		b.latencies = append(b.latencies, d)
+		return
+	}
+	b.latencies[b.next] = d
+	b.next = (b.next + 1) % latencyWindow
+}
+
+// backendPool spreads runs over the sandbox replicas: the least-loaded
+// healthy backend takes the next run, and a backend failing failThreshold
+// times in a row is ejected until its /readyz answers again.
+type backendPool struct {
+	mu            sync.Mutex
+	backends      []*backend
+	rotate        int // breaks ties between equally loaded backends
+	failThreshold int
+	probeInterval time.Duration
+	client        *http.Client
+}
+
+func newBackendPool(urls []string, client *http.Client) *backendPool {
+	p := &backendPool{
+		failThreshold: defaultFailThreshold,
+		probeInterval: defaultProbeInterval,
+		client:        client,
+	}
+	p.setURLs(urls)
+	return p
+}
+
+// setURLs replaces the backends, keeping the state of the ones still listed
+func (p *backendPool) setURLs(urls []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := make(map[string]*backend, len(p.backends))
+	for _, b := range p.backends {
+		known[b.url] = b
+	}
+	backends := make([]*backend, 0, len(urls))
+	for _, u := range urls {
+		b, ok := known[u]
+		if !ok {
+			b = &backend{url: u, healthy: true}
+			metrics.SetSandboxBackendHealth(u, true)
+		}
+		backends = append(backends, b)
+	}
+	p.backends = backends
+}
+
+// acquire picks the backend for a run and counts the run against it. A
+// primary attempt falls back to an ejected backend when none is healthy; a
+// hedge (exclude set) only goes to another healthy one. It returns nil when
+// there is nothing to pick.
+func (p *backendPool) acquire(exclude *backend) *backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.backends)
+	if n == 0 {
+		return nil
+	}
+	var best *backend
+	var bestP95 time.Duration
+	for _, healthyOnly := range []bool{true, false} {
+		for i := 0; i < n; i++ {
+			b := p.backends[(p.rotate+i)%n]
+			if b == exclude || (healthyOnly && !b.healthy) {
+				continue
+			}
+			p95 := b.p95()
+			if best == nil || b.inFlight < best.inFlight || (b.inFlight == best.inFlight && p95 < bestP95) {
+				best, bestP95 = b, p95
+			}
+		}
+		if best != nil || exclude != nil {
+			break
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	p.rotate = (p.rotate + 1) % n
+	best.inFlight++
+	metrics.RecordSandboxSelection(best.url)
+	metrics.SetSandboxBackendInFlight(best.url, best.inFlight)
+	return best
+}
+
+// release ends a run on b. latency is recorded for successful runs when
+// positive.
+func (p *backendPool) release(b *backend, latency time.Duration, result attemptResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.inFlight--
+	metrics.SetSandboxBackendInFlight(b.url, b.inFlight)
+	switch result {
+	case attemptOK:
+		b.fails = 0
+		if latency > 0 {
+			b.observe(latency)
+			metrics.SetSandboxBackendP95(b.url, b.p95())
+		}
+	case attemptFailed:
+		b.fails++
+		if b.healthy && b.fails >= p.failThreshold {
+			b.healthy = false
+			metrics.SetSandboxBackendHealth(b.url, false)
+		}
+		if !b.healthy && !b.probing {
+			b.probing = true
+			go p.probe(b)
+		}
+	}
+}
+
+// probe polls b's /readyz until it answers 200 and restores it, or until b
+// is no longer part of the pool
+func (p *backendPool) probe(b *backend) {
+	ticker := time.NewTicker(p.probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		listed := false
+		for _, other := range p.backends {
+			listed = listed || other == b
+		}
+		p.mu.Unlock()
+		if !listed {
+			return
+		}
+		if !p.ready(b.url) {
+			continue
+		}
+		p.mu.Lock()
+		b.healthy, b.probing, b.fails = true, false, 0
+		p.mu.Unlock()
+		metrics.SetSandboxBackendHealth(b.url, true)
+		return
+	}
+}
+
+func (p *backendPool) ready(base string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.probeInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/readyz", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// resolveBackends expands the host of a headless service URL such as
+// http://sandbox:8090 into one backend URL per address it resolves to
+func resolveBackends(ctx context.Context, lookup func(context.Context, string) ([]string, error), service string) ([]string, error) {
+	u, err := url.Parse(service)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := lookup(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := addr
+		if port := u.Port(); port != "" {
+			host = net.JoinHostPort(addr, port)
+		} else if strings.Contains(addr, ":") {
+			host = "[" + addr + "]"
+		}
+		urls = append(urls, u.Scheme+"://"+host+strings.TrimRight(u.Path, "/"))
+	}
+	return urls, nil
+}
+
+// watchDNS re-resolves service every interval and updates the pool. Failed
+// or empty lookups keep the current backends.
+func (p *backendPool) watchDNS(lookup func(context.Context, string) ([]string, error), service string, interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		urls, err := resolveBackends(ctx, lookup, service)
+		cancel()
+		if err == nil && len(urls) > 0 {
+			p.setURLs(urls)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// backendConfig is the sandbox routing read from the environment
+type backendConfig struct {
+	urls          []string
+	dnsService    string
+	dnsRefresh    time.Duration
+	failThreshold int
+	probeInterval time.Duration
+	hedgeDelay    time.Duration
+}
+
+// backendConfigFromEnv reads SANDBOX_URLS (comma-separated, falling back to
+// SANDBOX_URL), SANDBOX_DNS and SANDBOX_DNS_REFRESH (a headless service
+// resolved to one backend per address), SANDBOX_FAIL_THRESHOLD,
+// SANDBOX_PROBE_INTERVAL and SANDBOX_HEDGE_DELAY (off unless set)
+func backendConfigFromEnv(fallbackURL string) backendConfig {
+	cfg := backendConfig{
+		dnsService:    strings.TrimRight(strings.TrimSpace(os.Getenv("SANDBOX_DNS")), "/"),
+		dnsRefresh:    envDuration("SANDBOX_DNS_REFRESH", defaultDNSRefresh),
+		failThreshold: defaultFailThreshold,
+		probeInterval: envDuration("SANDBOX_PROBE_INTERVAL", defaultProbeInterval),
+		hedgeDelay:    envDuration("SANDBOX_HEDGE_DELAY", 0),
+	}
+	for _, u := range strings.Split(os.Getenv("SANDBOX_URLS"), ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			cfg.urls = append(cfg.urls, u)
+		}
+	}
+	if len(cfg.urls) == 0 {
+		cfg.urls = []string{fallbackURL}
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SANDBOX_FAIL_THRESHOLD"))); err == nil && n > 0 {
+		cfg.failThreshold = n
+	}
+	return cfg
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv(key))); err == nil && d >= 0 {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,331 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/models"
+)
+
+// sandboxStub is a sandbox replica with scripted latency and failures
+type sandboxStub struct {
+	*httptest.Server
+	name string
+
+	mu       sync.Mutex
+	runs     int
+	delay    time.Duration
+	status   int
+	ready    bool
+	canceled chan struct{}
+}
+
+func newSandboxStub(t *testing.T, name string) *sandboxStub {
+	t.Helper()
+	s := &sandboxStub{name: name, status: http.StatusOK, ready: true, canceled: make(chan struct{}, 8)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		delay, status, ready := s.delay, s.status, s.ready
+		if r.URL.Path != "/readyz" {
+			s.runs++
+		}
+		s.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/readyz":
+			if !ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/run/interactive":
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		case "/run":
+			// the server only notices a gone client once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				s.canceled <- struct{}{}
+				return
+			}
+			w.WriteHeader(status)
+			if status >= 500 {
+				_ = json.NewEncoder(w).Encode(sandboxResponse{Error: "sandbox_error"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(sandboxResponse{Stdout: s.name})
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *sandboxStub) script(delay time.Duration, status int, ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay, s.status, s.ready = delay, status, ready
+}
+
+func (s *sandboxStub) runCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs
+}
+
+func poolRunner(stubs ...*sandboxStub) *Runner {
+	urls := make([]string, len(stubs))
+	for i, s := range stubs {
+		urls[i] = s.URL
+	}
+	client := &http.Client{}
+	pool := newBackendPool(urls, client)
+	pool.probeInterval = 10 * time.Millisecond
+	return &Runner{client: client, pool: pool}
+}
+
+func (p *backendPool) state(url string) (healthy bool, inFlight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		if b.url == url {
+			return b.healthy, b.inFlight
+		}
+	}
+	return false, -1
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func runOnce(t *testing.T, r *Runner) RunOutput {
+	t.Helper()
+	out, err := r.RunOnce(context.Background(), models.LangPython, "print(1)", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	return out
+}
+
+func TestPoolPrefersLeastLoadedBackend(t *testing.T) {
+	slow, fast := newSandboxStub(t, "slow"), newSandboxStub(t, "fast")
+	slow.script(300*time.Millisecond, http.StatusOK, true)
+	runner := poolRunner(slow, fast)
+
+	done := make(chan RunOutput)
+	go func() { done <- runOnce(t, runner) }()
+	waitFor(t, "the first run to reach the slow backend", func() bool { return slow.runCount() == 1 })
+
+	for i := 0; i < 5; i++ {
+		if out := runOnce(t, runner); out.Stdout != "fast" {
+			t.Fatalf("run %d went to the busy backend", i)
+		}
+	}
+	if out := <-done; out.Stdout != "slow" {
+		t.Fatalf("unexpected first run: %+v", out)
+	}
+
+	// both idle now: the lower p95 wins
+	for i := 0; i < 3; i++ {
+		runOnce(t, runner)
+	}
+	if slow.runCount() != 1 || fast.runCount() != 8 {
+		t.Fatalf("expected 1 slow and 8 fast runs, got %d and %d", slow.runCount(), fast.runCount())
+	}
+	if _, inFlight := runner.pool.state(slow.URL); inFlight != 0 {
+		t.Fatalf("expected no runs in flight, got %d", inFlight)
+	}
+}
+
+func TestPoolEjectsFailingBackendAndRecovers(t *testing.T) {
+	broken, good := newSandboxStub(t, "broken"), newSandboxStub(t, "good")
+	broken.script(0, http.StatusInternalServerError, false)
+	runner := poolRunner(broken, good)
+	runner.pool.failThreshold = 2
+
+	for i := 0; i < 10 && broken.runCount() < 2; i++ {
+		runner.RunOnce(context.Background(), models.LangPython, "", SandboxLimits{})
+	}
+	if healthy, _ := runner.pool.state(broken.URL); healthy {
+		t.Fatalf("expected the backend to be ejected after 2 failures")
+	}
+	for i := 0; i < 5; i++ {
+		if out := runOnce(t, runner); out.Stdout != "good" {
+			t.Fatalf("run %d went to the ejected backend", i)
+		}
+	}
+	if broken.runCount() != 2 {
+		t.Fatalf("expected no runs on the ejected backend, got %d", broken.runCount())
+	}
+
+	// still failing its probe, so it stays out
+	time.Sleep(50 * time.Millisecond)
+	if healthy, _ := runner.pool.state(broken.URL); healthy {
+		t.Fatalf("a backend failing /readyz must stay ejected")
+	}
+
+	broken.script(0, http.StatusOK, true)
+	waitFor(t, "the backend to be restored", func() bool {
+		healthy, _ := runner.pool.state(broken.URL)
+		return healthy
+	})
+	// no latency recorded yet, so it wins the tie against the busy one
+	if out := runOnce(t, runner); out.Stdout != "broken" {
+		t.Fatalf("expected the restored backend to take runs again, got %q", out.Stdout)
+	}
+}
+
+func TestPoolFallsBackToEjectedBackends(t *testing.T) {
+	only := newSandboxStub(t, "only")
+	only.script(0, http.StatusBadGateway, false)
+	runner := poolRunner(only)
+	runner.pool.failThreshold = 1
+
+	runner.RunOnce(context.Background(), models.LangPython, "", SandboxLimits{})
+	if healthy, _ := runner.pool.state(only.URL); healthy {
+		t.Fatalf("expected the backend to be ejected")
+	}
+	if _, err := runner.RunOnce(context.Background(), models.LangPython, "", SandboxLimits{}); err == nil || only.runCount() != 2 {
+		t.Fatalf("with no healthy backend the run should still be attempted, got %v after %d runs", err, only.runCount())
+	}
+}
+
+func TestRunOnceHedgesSlowBackend(t *testing.T) {
+	slow, fast := newSandboxStub(t, "slow"), newSandboxStub(t, "fast")
+	slow.script(2*time.Second, http.StatusOK, true)
+	runner := poolRunner(slow, fast)
+	runner.hedgeDelay = 20 * time.Millisecond
+
+	start := time.Now()
+	out := runOnce(t, runner)
+	if out.Stdout != "fast" || time.Since(start) > time.Second {
+		t.Fatalf("expected the hedge to answer, got %q after %s", out.Stdout, time.Since(start))
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the losing attempt to be cancelled")
+	}
+	waitFor(t, "both attempts to be released", func() bool {
+		_, a := runner.pool.state(slow.URL)
+		_, b := runner.pool.state(fast.URL)
+		return a == 0 && b == 0
+	})
+	// a cancelled loser is not a failure of its backend
+	if healthy, _ := runner.pool.state(slow.URL); !healthy {
+		t.Fatalf("the cancelled backend must stay healthy")
+	}
+
+	// a primary that answers before the delay is never hedged
+	runner.hedgeDelay = time.Second
+	slow.script(0, http.StatusOK, true)
+	before := fast.runCount() + slow.runCount()
+	runOnce(t, runner)
+	if after := fast.runCount() + slow.runCount(); after != before+1 {
+		t.Fatalf("expected a single attempt, got %d", after-before)
+	}
+}
+
+func TestHedgingSkipsStreamsAndInteractiveRuns(t *testing.T) {
+	slow, fast := newSandboxStub(t, "slow"), newSandboxStub(t, "fast")
+	slow.script(100*time.Millisecond, http.StatusOK, true)
+	runner := poolRunner(slow, fast)
+	runner.hedgeDelay = 10 * time.Millisecond
+
+	if _, err := runner.RunStream(context.Background(), models.LangPython, "", SandboxLimits{}); err != nil {
+		t.Fatalf("run stream: %v", err)
+	}
+	if slow.runCount() != 1 || fast.runCount() != 0 {
+		t.Fatalf("streams must not be hedged: slow=%d fast=%d", slow.runCount(), fast.runCount())
+	}
+
+	// the stream's latency makes fast the pick; the session holds it until closed
+	sess, err := runner.StartInteractive(context.Background(), models.LangPython, "", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("start interactive: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if slow.runCount() != 1 || fast.runCount() != 1 {
+		t.Fatalf("interactive sessions must not be hedged: slow=%d fast=%d", slow.runCount(), fast.runCount())
+	}
+	if _, inFlight := runner.pool.state(fast.URL); inFlight != 1 {
+		t.Fatalf("expected the open session to count as load, got %d", inFlight)
+	}
+	sess.Close()
+	sess.Close()
+	if _, inFlight := runner.pool.state(fast.URL); inFlight != 0 {
+		t.Fatalf("expected the session to be released once, got %d", inFlight)
+	}
+}
+
+func TestResolveBackends(t *testing.T) {
+	lookup := func(_ context.Context, host string) ([]string, error) {
+		if host != "sandbox-headless" {
+			t.Fatalf("unexpected lookup of %q", host)
+		}
+		return []string{"10.0.0.2", "10.0.0.1", "fd00::1"}, nil
+	}
+	urls, err := resolveBackends(context.Background(), lookup, "http://sandbox-headless:8090/api")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	want := []string{"http://10.0.0.1:8090/api", "http://10.0.0.2:8090/api", "http://[fd00::1]:8090/api"}
+	if len(urls) != len(want) {
+		t.Fatalf("unexpected urls: %v", urls)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Fatalf("unexpected urls: %v", urls)
+		}
+	}
+
+	// re-resolving keeps what the pool knows about surviving backends
+	pool := newBackendPool(urls[:2], &http.Client{})
+	pool.backends[0].healthy = false
+	pool.setURLs([]string{urls[0], urls[2]})
+	if healthy, _ := pool.state(urls[0]); healthy {
+		t.Fatalf("expected the ejected backend to stay ejected")
+	}
+	if _, inFlight := pool.state(urls[1]); inFlight != -1 {
+		t.Fatalf("expected the vanished backend to be dropped")
+	}
+}
+
+func TestBackendConfigFromEnv(t *testing.T) {
+	cfg := backendConfigFromEnv("http://sandbox:8090")
+	if len(cfg.urls) != 1 || cfg.urls[0] != "http://sandbox:8090" || cfg.hedgeDelay != 0 || cfg.failThreshold != defaultFailThreshold {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+
+	t.Setenv("SANDBOX_URLS", " http://a:8090/, ,http://b:8090")
+	t.Setenv("SANDBOX_HEDGE_DELAY", "750ms")
+	t.Setenv("SANDBOX_FAIL_THRESHOLD", "5")
+	t.Setenv("SANDBOX_PROBE_INTERVAL", "bogus")
+	cfg = backendConfigFromEnv("http://sandbox:8090")
+	if len(cfg.urls) != 2 || cfg.urls[0] != "http://a:8090" || cfg.hedgeDelay != 750*time.Millisecond || cfg.failThreshold != 5 || cfg.probeInterval != defaultProbeInterval {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
@@ -0,0 +1,153 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/models"
+)
+
+// InteractiveSession is a sandbox execution with a live stdin.
+type InteractiveSession interface {
+	WriteStdin(data string, eof bool) error
+	Kill() error
+	// Next blocks for the next stdout, stderr, error or exit frame. It
+	// returns an error once the sandbox has closed the session.
+	Next() (models.WSFrame, error)
+	Close() error
+}
+
+type interactiveMessage struct {
+	Type     string         `json:"type"`
+	Language string         `json:"language,omitempty"`
+	Code     string         `json:"code,omitempty"`
+	Limits   *sandboxLimits `json:"limits,omitempty"`
+	Data     string         `json:"data,omitempty"`
+	EOF      bool           `json:"eof,omitempty"`
+}
+
+type interactiveExit struct {
+	Code     int    `json:"code"`
+	TimedOut bool   `json:"timedOut"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type wsInteractiveSession struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	// done releases the backend, once, when the session closes
+	done sync.Once
+	end  func()
+}
+
+// StartInteractive opens an interactive sandbox session for code. The
+// session's wall time comes from limits; the sandbox also reaps it when idle.
+// Sessions are stateful, so they are never hedged; they count against their
+// backend's load until closed.
+func (r *Runner) StartInteractive(ctx context.Context, lang models.Language, code string, limits SandboxLimits) (InteractiveSession, error) {
+	b := r.acquire(nil)
+	if b == nil {
+		return nil, ErrDockerUnavailable
+	}
+	init, err := json.Marshal(interactiveMessage{
+		Type:     "init",
+		Language: string(lang),
+		Code:     code,
+		Limits: &sandboxLimits{
+			WallTimeMs:  limitsMillis(limits.WallTime, 5*time.Minute),
+			MemoryBytes: limits.MemoryB,
+			NanoCPUs:    limits.NanoCPUs,
+		},
+	})
+	if err != nil {
+		r.release(b, 0, attemptFailed)
+		return nil, err
+	}
+	// The sandbox checks the signature of the init frame, which carries the
+	// code, against the header of the dial
+	header := http.Header{}
+	header.Set("X-Requesting-Service", "collab")
+	signHeader(header, init, r.secret)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, interactiveURL(b.url), header)
+	if err != nil {
+		r.release(b, 0, attemptFailed)
+		return nil, ErrDockerUnavailable
+	}
+
+	s := &wsInteractiveSession{conn: conn, end: func() { r.release(b, 0, attemptOK) }}
+	s.writeMu.Lock()
+	err = conn.WriteMessage(websocket.TextMessage, init)
+	s.writeMu.Unlock()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func interactiveURL(base string) string {
+	switch {
+	case strings.HasPrefix(base, "https://"):
+		base = "wss://" + strings.TrimPrefix(base, "https://")
+	case strings.HasPrefix(base, "http://"):
+		base = "ws://" + strings.TrimPrefix(base, "http://")
+	}
+	return base + "/run/interactive"
+}
+
+func (s *wsInteractiveSession) write(msg interactiveMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *wsInteractiveSession) WriteStdin(data string, eof bool) error {
+	return s.write(interactiveMessage{Type: "stdin", Data: data, EOF: eof})
+}
+
+func (s *wsInteractiveSession) Kill() error {
+	return s.write(interactiveMessage{Type: "kill"})
+}
+
+func (s *wsInteractiveSession) Next() (models.WSFrame, error) {
+	for {
+		var evt sandboxEvent
+		if err := s.conn.ReadJSON(&evt); err != nil {
+			return models.WSFrame{}, err
+		}
+		switch evt.Type {
+		case "stdout", "stderr", "error":
+			var msg string
+			if err := json.Unmarshal(evt.Data, &msg); err != nil {
+				continue
+			}
+			return models.WSFrame{Type: evt.Type, Data: msg}, nil
+		case "exit":
+			var exit interactiveExit
+			if err := json.Unmarshal(evt.Data, &exit); err != nil {
+				return models.WSFrame{}, errors.New("invalid exit event")
+			}
+			data := map[string]any{"code": exit.Code, "timedOut": exit.TimedOut}
+			if exit.Reason != "" {
+				data["reason"] = exit.Reason
+			}
+			if exit.Error != "" {
+				data["error"] = exit.Error
+			}
+			return models.WSFrame{Type: "exit", Data: data}, nil
+		}
+	}
+}
+
+func (s *wsInteractiveSession) Close() error {
+	s.done.Do(s.end)
+	return s.conn.Close()
+}
@@ -5,17 +5,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"collab/internal/metrics"
 	"collab/internal/models"
 )
 
+// Runner executes code on the sandbox service. With a pool, runs go to the
+// least-loaded healthy replica; without one (as in tests), to baseURL.
 type Runner struct {
 	client  *http.Client
 	baseURL string
+	pool    *backendPool
+	// hedgeDelay, when positive, sends a RunOnce that has not answered after
+	// it to a second backend; the first answer wins
+	hedgeDelay time.Duration
+	// secret signs every run; see SignRequest
+	secret string
 }
 
 func NewRunner() *Runner {
@@ -24,9 +34,27 @@ func NewRunner() *Runner {
 		base = "http://localhost:8090"
 	}
 	base = strings.TrimRight(base, "/")
+
+	// keep connections to every replica warm instead of redialling per run
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 256
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	client := &http.Client{Transport: transport}
+
+	cfg := backendConfigFromEnv(base)
+	pool := newBackendPool(cfg.urls, client)
+	pool.failThreshold = cfg.failThreshold
+	pool.probeInterval = cfg.probeInterval
+	if cfg.dnsService != "" {
+		go pool.watchDNS(net.DefaultResolver.LookupHost, cfg.dnsService, cfg.dnsRefresh)
+	}
 	return &Runner{
-		client:  &http.Client{},
-		baseURL: base,
+		client:     client,
+		baseURL:    base,
+		pool:       pool,
+		hedgeDelay: cfg.hedgeDelay,
+		secret:     os.Getenv("SANDBOX_SHARED_SECRET"),
 	}
 }
 
@@ -35,18 +63,38 @@ type RunOutput struct {
 	Stderr   string
 	Exit     int
 	TimedOut bool
+	Args     []string
+	Env      map[string]string
+	Usage    *models.RunUsage // nil when the program never ran
+	// OutputTruncated is set when the output hit the sandbox's limit and the
+	// program was killed
+	OutputTruncated bool
+	// OOMKilled is set when the program was killed for going over its memory
+	// limit
+	OOMKilled bool
+	// CompileOutput holds the compiler's output when the program did not
+	// build and never ran; Exit is then the compiler's
+	CompileOutput string
 }
 
+// SandboxLimits is the per-run sandbox configuration: resource limits plus
+// the arguments, environment and standard input of the program.
 type SandboxLimits struct {
 	WallTime time.Duration
 	MemoryB  int64
 	NanoCPUs int64
+	Args     []string
+	Env      map[string]string
+	Stdin    string
 }
 
 type sandboxRequest struct {
-	Language string        `json:"language"`
-	Code     string        `json:"code"`
-	Limits   sandboxLimits `json:"limits"`
+	Language string            `json:"language"`
+	Code     string            `json:"code"`
+	Limits   sandboxLimits     `json:"limits"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Stdin    string            `json:"stdin,omitempty"`
 }
 
 type sandboxLimits struct {
@@ -56,16 +104,25 @@ type sandboxLimits struct {
 }
 
 type sandboxResponse struct {
-	Stdout string         `json:"stdout"`
-	Stderr string         `json:"stderr"`
-	Exit   runExit        `json:"exit"`
-	Events []sandboxEvent `json:"events"`
-	Error  string         `json:"error,omitempty"`
+	Stdout string           `json:"stdout"`
+	Stderr string           `json:"stderr"`
+	Exit   runExit          `json:"exit"`
+	Usage  *models.RunUsage `json:"usage,omitempty"`
+	Events []sandboxEvent   `json:"events"`
+	Error  string           `json:"error,omitempty"`
+
+	OutputTruncated bool   `json:"outputTruncated,omitempty"`
+	CompileOutput   string `json:"compileOutput,omitempty"`
+
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
 }
 
 type runExit struct {
-	Code     int  `json:"code"`
-	TimedOut bool `json:"timedOut"`
+	Code      int  `json:"code"`
+	TimedOut  bool `json:"timedOut"`
+	Cancelled bool `json:"cancelled,omitempty"`
+	OOMKilled bool `json:"oomKilled,omitempty"`
 }
 
 type sandboxEvent struct {
@@ -76,7 +133,7 @@ type sandboxEvent struct {
 var ErrDockerUnavailable = errors.New("docker daemon unreachable")
 
 func (r *Runner) RunOnce(ctx context.Context, lang models.Language, code string, limits SandboxLimits) (RunOutput, error) {
-	resp, err := r.invokeSandbox(ctx, lang, code, limits)
+	resp, err := r.invokeHedged(ctx, sandboxBody(lang, code, limits))
 	if err != nil {
 		return RunOutput{}, err
 	}
@@ -88,9 +145,19 @@ func (r *Runner) RunOnce(ctx context.Context, lang models.Language, code string,
 		Stderr:   resp.Stderr,
 		Exit:     resp.Exit.Code,
 		TimedOut: resp.Exit.TimedOut,
+		Args:     resp.Args,
+		Env:      resp.Env,
+		Usage:    resp.Usage,
+
+		OutputTruncated: resp.OutputTruncated,
+		OOMKilled:       resp.Exit.OOMKilled,
+		CompileOutput:   resp.CompileOutput,
 	}, nil
 }
 
+// RunStream runs code and returns its frames once the program has finished.
+// Rooms stream through RunStreamFn instead; this stays for callers that want
+// the whole run at once.
 func (r *Runner) RunStream(ctx context.Context, lang models.Language, code string, limits SandboxLimits) ([]models.WSFrame, error) {
 	resp, err := r.invokeSandbox(ctx, lang, code, limits)
 	if err != nil {
@@ -99,22 +166,8 @@ func (r *Runner) RunStream(ctx context.Context, lang models.Language, code strin
 
 	frames := make([]models.WSFrame, 0, len(resp.Events))
 	for _, evt := range resp.Events {
-		switch evt.Type {
-		case "stdout", "stderr", "error":
-			var msg string
-			if err := json.Unmarshal(evt.Data, &msg); err != nil {
-				continue
-			}
-			frames = append(frames, models.WSFrame{Type: evt.Type, Data: msg})
-		case "exit":
-			var exitData runExit
-			if err := json.Unmarshal(evt.Data, &exitData); err != nil {
-				continue
-			}
-			frames = append(frames, models.WSFrame{
-				Type: "exit",
-				Data: map[string]any{"code": exitData.Code, "timedOut": exitData.TimedOut},
-			})
+		if frame, ok := eventFrame(evt, resp.Args, resp.Env); ok {
+			frames = append(frames, frame)
 		}
 	}
 	if resp.Error != "" && !hasErrorFrame(frames) {
@@ -123,6 +176,54 @@ func (r *Runner) RunStream(ctx context.Context, lang models.Language, code strin
 	return frames, mapSandboxError(resp.Error)
 }
 
+// eventFrame converts a sandbox event to the frame clients get; args and env
+// are the invocation echoed on the exit frame. Events clients do not know are
+// skipped.
+func eventFrame(evt sandboxEvent, args []string, env map[string]string) (models.WSFrame, bool) {
+	switch evt.Type {
+	case "stdout", "stderr", "error", "compile_error":
+		var msg string
+		if err := json.Unmarshal(evt.Data, &msg); err != nil {
+			return models.WSFrame{}, false
+		}
+		return models.WSFrame{Type: evt.Type, Data: msg}, true
+	case "usage":
+		var usage models.RunUsage
+		if err := json.Unmarshal(evt.Data, &usage); err != nil {
+			return models.WSFrame{}, false
+		}
+		return models.WSFrame{Type: "usage", Data: usage}, true
+	case "truncated":
+		var truncation struct {
+			LimitBytes int `json:"limitBytes"`
+		}
+		if err := json.Unmarshal(evt.Data, &truncation); err != nil {
+			return models.WSFrame{}, false
+		}
+		return models.WSFrame{Type: "truncated", Data: map[string]any{"limitBytes": truncation.LimitBytes}}, true
+	case "exit":
+		var exitData runExit
+		if err := json.Unmarshal(evt.Data, &exitData); err != nil {
+			return models.WSFrame{}, false
+		}
+		data := map[string]any{"code": exitData.Code, "timedOut": exitData.TimedOut}
+		if exitData.Cancelled {
+			data["cancelled"] = true
+		}
+		if exitData.OOMKilled {
+			data["oomKilled"] = true
+		}
+		if len(args) > 0 {
+			data["args"] = args
+		}
+		if len(env) > 0 {
+			data["env"] = env
+		}
+		return models.WSFrame{Type: "exit", Data: data}, true
+	}
+	return models.WSFrame{}, false
+}
+
 func hasErrorFrame(frames []models.WSFrame) bool {
 	for _, f := range frames {
 		if f.Type == "error" {
@@ -132,7 +233,24 @@ func hasErrorFrame(frames []models.WSFrame) bool {
 	return false
 }
 
-func (r *Runner) invokeSandbox(ctx context.Context, lang models.Language, code string, limits SandboxLimits) (sandboxResponse, error) {
+// acquire picks the backend for a run, see backendPool.acquire
+func (r *Runner) acquire(exclude *backend) *backend {
+	if r.pool == nil {
+		if exclude != nil {
+			return nil
+		}
+		return &backend{url: r.baseURL}
+	}
+	return r.pool.acquire(exclude)
+}
+
+func (r *Runner) release(b *backend, latency time.Duration, result attemptResult) {
+	if r.pool != nil {
+		r.pool.release(b, latency, result)
+	}
+}
+
+func sandboxBody(lang models.Language, code string, limits SandboxLimits) []byte {
 	reqPayload := sandboxRequest{
 		Language: string(lang),
 		Code:     code,
@@ -141,6 +259,9 @@ func (r *Runner) invokeSandbox(ctx context.Context, lang models.Language, code s
 			MemoryBytes: limits.MemoryB,
 			NanoCPUs:    limits.NanoCPUs,
 		},
+		Args:  limits.Args,
+		Env:   limits.Env,
+		Stdin: limits.Stdin,
 	}
 	if reqPayload.Limits.MemoryBytes == 0 {
 		reqPayload.Limits.MemoryBytes = 512 * 1024 * 1024
@@ -148,16 +269,99 @@ func (r *Runner) invokeSandbox(ctx context.Context, lang models.Language, code s
 	if reqPayload.Limits.NanoCPUs == 0 {
 		reqPayload.Limits.NanoCPUs = 1_000_000_000
 	}
-
 	body, _ := json.Marshal(reqPayload)
+	return body
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/run", bytes.NewReader(body))
-	if err != nil {
-		return sandboxResponse{}, err
+func (r *Runner) invokeSandbox(ctx context.Context, lang models.Language, code string, limits SandboxLimits) (sandboxResponse, error) {
+	b := r.acquire(nil)
+	if b == nil {
+		return sandboxResponse{}, ErrDockerUnavailable
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	return r.call(ctx, b, sandboxBody(lang, code, limits))
+}
 
-	resp, err := r.client.Do(httpReq)
+// invokeHedged runs body like invokeSandbox and, if no answer has come after
+// hedgeDelay, sends it to a second healthy backend as well. The first answer
+// that is not a backend failure wins and the other attempt is cancelled.
+func (r *Runner) invokeHedged(ctx context.Context, body []byte) (sandboxResponse, error) {
+	primary := r.acquire(nil)
+	if primary == nil {
+		return sandboxResponse{}, ErrDockerUnavailable
+	}
+	if r.hedgeDelay <= 0 || r.pool == nil {
+		return r.call(ctx, primary, body)
+	}
+
+	type attempt struct {
+		resp   sandboxResponse
+		err    error
+		failed bool
+		hedge  bool
+	}
+	attempts := make(chan attempt, 2)
+	start := func(b *backend, hedge bool) context.CancelFunc {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			resp, err := r.call(attemptCtx, b, body)
+			attempts <- attempt{resp: resp, err: err, failed: classifyAttempt(resp, err) == attemptFailed, hedge: hedge}
+		}()
+		return cancel
+	}
+
+	cancels := []context.CancelFunc{start(primary, false)}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	timer := time.NewTimer(r.hedgeDelay)
+	defer timer.Stop()
+
+	pending := 1
+	var failure *attempt
+	for {
+		select {
+		case <-timer.C:
+			if hedge := r.acquire(primary); hedge != nil {
+				cancels = append(cancels, start(hedge, true))
+				pending++
+			}
+		case a := <-attempts:
+			pending--
+			if a.failed && pending > 0 {
+				failure = &a
+				continue
+			}
+			if a.failed && failure != nil {
+				a = *failure
+			}
+			if len(cancels) > 1 {
+				winner := "primary"
+				if a.hedge {
+					winner = "hedge"
+				}
+				metrics.RecordSandboxHedge(winner)
+			}
+			return a.resp, a.err
+		}
+	}
+}
+
+// call posts a run to b and records the outcome against it
+func (r *Runner) call(ctx context.Context, b *backend, body []byte) (sandboxResponse, error) {
+	start := time.Now()
+	resp, err := r.post(ctx, b.url, body)
+	result := classifyAttempt(resp, err)
+	if ctx.Err() == context.Canceled {
+		result = attemptCanceled
+	}
+	r.release(b, time.Since(start), result)
+	return resp, err
+}
+
+func (r *Runner) post(ctx context.Context, base string, body []byte) (sandboxResponse, error) {
+	resp, err := r.send(ctx, base+"/run", body)
 	if err != nil {
 		return sandboxResponse{}, err
 	}
@@ -165,17 +369,66 @@ func (r *Runner) invokeSandbox(ctx context.Context, lang models.Language, code s
 
 	var sr sandboxResponse
 	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
-		return sandboxResponse{}, err
+		return sandboxResponse{}, backendError{err}
 	}
 	if resp.StatusCode >= 400 {
-		if sr.Error == "" {
-			sr.Error = resp.Status
-		}
-		return sandboxResponse{}, mapSandboxError(sr.Error)
+		return sandboxResponse{}, statusError(resp, sr.Error)
 	}
 	return sr, nil
 }
 
+// send posts a signed run request to url
+func (r *Runner) send(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Requesting-Service", "collab")
+	SignRequest(httpReq, body, r.secret)
+
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, backendError{err}
+	}
+	return resp, nil
+}
+
+// statusError is the error of a run the sandbox refused with resp, whose
+// body carried code
+func statusError(resp *http.Response, code string) error {
+	if code == "" {
+		code = resp.Status
+	}
+	err := mapSandboxError(code)
+	if resp.StatusCode >= 500 {
+		err = backendError{err}
+	}
+	return err
+}
+
+// backendError marks a failure of the sandbox replica itself rather than of
+// the run, counting towards ejecting the replica
+type backendError struct{ err error }
+
+func (e backendError) Error() string { return e.err.Error() }
+func (e backendError) Unwrap() error { return e.err }
+
+func classifyAttempt(resp sandboxResponse, err error) attemptResult {
+	var be backendError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return attemptCanceled
+	case errors.As(err, &be), errors.Is(err, ErrDockerUnavailable), resp.Error == "sandbox_unavailable":
+		return attemptFailed
+	}
+	return attemptOK
+}
+
 func limitsMillis(d time.Duration, fallback time.Duration) int64 {
 	if d <= 0 {
 		d = fallback
@@ -187,6 +440,9 @@ func mapSandboxError(code string) error {
 	switch {
 	case code == "" || code == "success":
 		return nil
+	case code == "memory_limit_exceeded":
+		// The program's own failure, reported through the exit
+		return nil
 	case code == "sandbox_unavailable":
 		return ErrDockerUnavailable
 	case code == "unsupported_language":
@@ -195,6 +451,48 @@ func mapSandboxError(code string) error {
 	return errors.New(code)
 }
 
+// Languages fetches the languages the sandbox supports, in its order. The
+// runner keeps the editor side of every language, so those it has no spec for
+// are left out.
+func (r *Runner) Languages(ctx context.Context) ([]models.Language, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/languages", nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("X-Requesting-Service", "collab")
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("sandbox languages: " + resp.Status)
+	}
+
+	var body struct {
+		Languages []struct {
+			Language models.Language `json:"language"`
+		} `json:"languages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	languages := make([]models.Language, 0, len(body.Languages))
+	for _, info := range body.Languages {
+		if _, _, _, _, err := r.langSpec(info.Language); err == nil {
+			languages = append(languages, info.Language)
+		}
+	}
+	if len(languages) == 0 {
+		return nil, errors.New("sandbox languages: none supported")
+	}
+	return languages, nil
+}
+
 func (r *Runner) LangSpecPublic(lang models.Language) (spec models.LanguageSpec, image string, fileName string, cmds [][]string, err error) {
 	return r.langSpec(lang)
 }
@@ -220,14 +518,14 @@ func (r *Runner) langSpec(lang models.Language) (models.LanguageSpec, string, st
 				Name:            lang,
 				FileName:        "Main.java",
 				CompileCmd:      []string{"javac", "Main.java"},
-				ExecCmd:         []string{"/bin/sh", "-c", "java Main"},
+				ExecCmd:         []string{"java", "Main"},
 				DefaultTabSize:  4,
 				Formatter:       []string{"google-java-format"},
 				ExampleTemplate: "public class Main {\n    public static void main(String[] args) {\n        System.out.println(\"Hello from Java!\");\n    }\n}\n",
 			},
 			"eclipse-temurin:17-jdk",
 			"Main.java",
-			[][]string{{"javac", "Main.java"}, {"/bin/sh", "-c", "java Main"}},
+			[][]string{{"javac", "Main.java"}, {"java", "Main"}},
 			nil
 
 	case models.LangCPP:
@@ -244,6 +542,50 @@ func (r *Runner) langSpec(lang models.Language) (models.LanguageSpec, string, st
 			"main.cpp",
 			[][]string{{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"}, {"./main"}},
 			nil
+
+	case models.LangJavaScript:
+		return models.LanguageSpec{
+				Name:            lang,
+				FileName:        "main.js",
+				RunCmd:          []string{"node", "main.js"},
+				DefaultTabSize:  2,
+				Formatter:       []string{"prettier"},
+				ExampleTemplate: "console.log(\"Hello from JavaScript!\");\n",
+			},
+			"node:20-slim",
+			"main.js",
+			[][]string{{"node", "main.js"}},
+			nil
+
+	case models.LangGo:
+		return models.LanguageSpec{
+				Name:            lang,
+				FileName:        "main.go",
+				CompileCmd:      []string{"go", "build", "-o", "app", "main.go"},
+				ExecCmd:         []string{"./app"},
+				DefaultTabSize:  4,
+				Formatter:       []string{"gofmt"},
+				ExampleTemplate: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Hello from Go!\")\n}\n",
+			},
+			"golang:1.22-alpine",
+			"main.go",
+			[][]string{{"go", "build", "-o", "app", "main.go"}, {"./app"}},
+			nil
+
+	case models.LangRust:
+		return models.LanguageSpec{
+				Name:            lang,
+				FileName:        "main.rs",
+				CompileCmd:      []string{"rustc", "-O", "main.rs", "-o", "app"},
+				ExecCmd:         []string{"./app"},
+				DefaultTabSize:  4,
+				Formatter:       []string{"rustfmt"},
+				ExampleTemplate: "fn main() {\n    println!(\"Hello from Rust!\");\n}\n",
+			},
+			"rust:1.78-slim",
+			"main.rs",
+			[][]string{{"rustc", "-O", "main.rs", "-o", "app"}, {"./app"}},
+			nil
 	default:
 		return models.LanguageSpec{}, "", "", nil, errors.New("unsupported language")
 	}
@@ -2,14 +2,21 @@ package exec
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"collab/internal/models"
 )
 
@@ -57,6 +64,36 @@ func TestRunOnceSuccess(t *testing.T) {
 	}
 }
 
+func TestRunStreamSendsAndEchoesInvocation(t *testing.T) {
+	var got sandboxRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(sandboxResponse{
+			Events: []sandboxEvent{{Type: "exit", Data: json.RawMessage(`{"code":0,"timedOut":false}`)}},
+			Args:   got.Args,
+			Env:    got.Env,
+		})
+	}))
+	defer server.Close()
+
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+	frames, err := runner.RunStream(context.Background(), models.LangCPP, "int main(){}", SandboxLimits{
+		Args:  []string{"--mode=fast"},
+		Env:   map[string]string{"DEBUG": "1"},
+		Stdin: "3\n1 2 3\n",
+	})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(got.Args) != 1 || got.Args[0] != "--mode=fast" || got.Env["DEBUG"] != "1" || got.Stdin != "3\n1 2 3\n" {
+		t.Fatalf("expected args, env and stdin in the sandbox request, got %#v", got)
+	}
+	data, _ := frames[0].Data.(map[string]any)
+	if args, _ := data["args"].([]string); len(args) != 1 || data["env"].(map[string]string)["DEBUG"] != "1" {
+		t.Fatalf("expected the effective invocation on the exit frame, got %#v", frames[0])
+	}
+}
+
 func TestRunOnceSandboxUnavailable(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -71,6 +108,149 @@ func TestRunOnceSandboxUnavailable(t *testing.T) {
 	}
 }
 
+func TestRunSurfacesUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"stdout":"hi\n","exit":{"code":0},"usage":{"durationMs":120,"peakMemoryBytes":14680064},
+			"events":[{"type":"stdout","data":"hi\n"},{"type":"usage","data":{"durationMs":120,"peakMemoryBytes":14680064}},{"type":"exit","data":{"code":0}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+	want := models.RunUsage{DurationMs: 120, PeakMemoryBytes: 14 << 20}
+
+	output, err := runner.RunOnce(context.Background(), models.LangPython, "print('hi')", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run once error: %v", err)
+	}
+	if output.Usage == nil || *output.Usage != want {
+		t.Fatalf("expected usage %+v, got %+v", want, output.Usage)
+	}
+
+	frames, err := runner.RunStream(context.Background(), models.LangPython, "print('hi')", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 3 || frames[1].Type != "usage" || frames[1].Data != want {
+		t.Fatalf("expected a usage frame before exit, got %#v", frames)
+	}
+}
+
+func TestRunForwardsOutputTruncation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"stdout":"yyyy","exit":{"code":137},"outputTruncated":true,
+			"events":[{"type":"stdout","data":"yyyy"},{"type":"truncated","data":{"limitBytes":4}},{"type":"exit","data":{"code":137}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	output, err := runner.RunOnce(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run once error: %v", err)
+	}
+	if !output.OutputTruncated {
+		t.Fatalf("expected the output to be marked truncated, got %+v", output)
+	}
+
+	frames, err := runner.RunStream(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 3 || frames[1].Type != "truncated" {
+		t.Fatalf("expected a truncated frame before exit, got %#v", frames)
+	}
+	if data, ok := frames[1].Data.(map[string]any); !ok || data["limitBytes"] != 4 {
+		t.Fatalf("expected the limit on the truncated frame, got %#v", frames[1].Data)
+	}
+}
+
+func TestRunStreamMarksCancelledExit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"exit":{"code":-1,"timedOut":false,"cancelled":true},"events":[{"type":"exit","data":{"code":-1,"timedOut":false,"cancelled":true}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	frames, err := runner.RunStream(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("expected one exit frame, got %#v", frames)
+	}
+	if data, _ := frames[0].Data.(map[string]any); data["cancelled"] != true || data["code"] != -1 {
+		t.Fatalf("expected a cancelled exit, got %#v", frames[0].Data)
+	}
+}
+
+func TestRunStreamKeepsOutputOfTimedOutRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"stdout":"first\nsecond\n","exit":{"code":-1,"timedOut":true},"events":[
+			{"type":"stdout","data":"first\n"},{"type":"stdout","data":"second\n"},{"type":"exit","data":{"code":-1,"timedOut":true}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	frames, err := runner.RunStream(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 3 || frames[0].Data != "first\n" || frames[1].Data != "second\n" || frames[2].Type != "exit" {
+		t.Fatalf("expected both chunks before the exit, got %#v", frames)
+	}
+	if data, _ := frames[2].Data.(map[string]any); data["timedOut"] != true || data["code"] != -1 {
+		t.Fatalf("expected a timed out exit, got %#v", frames[2].Data)
+	}
+}
+
+func TestRunForwardsOOMKill(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"exit":{"code":137,"timedOut":false,"oomKilled":true},"error":"memory_limit_exceeded",
+			"events":[{"type":"error","data":"memory_limit_exceeded"},{"type":"exit","data":{"code":137,"timedOut":false,"oomKilled":true}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	output, err := runner.RunOnce(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("an OOM kill must not fail the run, got %v", err)
+	}
+	if !output.OOMKilled || output.Exit != 137 {
+		t.Fatalf("expected the exit to be marked OOM-killed, got %+v", output)
+	}
+
+	frames, err := runner.RunStream(context.Background(), models.LangPython, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 2 || frames[0].Type != "error" || frames[0].Data != "memory_limit_exceeded" {
+		t.Fatalf("expected the memory error before exit, got %#v", frames)
+	}
+	if data, _ := frames[1].Data.(map[string]any); data["oomKilled"] != true || data["code"] != 137 {
+		t.Fatalf("expected an OOM-killed exit, got %#v", frames[1].Data)
+	}
+}
+
+func TestRunForwardsCompileError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"exit":{"code":1,"timedOut":false},"stage":"compile","compileOutput":"Main.java:1: error\n",
+			"events":[{"type":"compile_error","data":"Main.java:1: error\n"},{"type":"exit","data":{"code":1,"timedOut":false}}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	output, err := runner.RunOnce(context.Background(), models.LangJava, "code", SandboxLimits{})
+	if err != nil || output.CompileOutput != "Main.java:1: error\n" || output.Exit != 1 {
+		t.Fatalf("expected the compiler's output, got %+v err=%v", output, err)
+	}
+
+	frames, err := runner.RunStream(context.Background(), models.LangJava, "code", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("run stream error: %v", err)
+	}
+	if len(frames) != 2 || frames[0].Type != "compile_error" || frames[0].Data != "Main.java:1: error\n" {
+		t.Fatalf("expected a compile_error frame before exit, got %#v", frames)
+	}
+}
+
 func TestRunStreamConvertsEvents(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp := sandboxResponse{
@@ -239,11 +419,50 @@ func TestLangSpecPublic(t *testing.T) {
 	if err != nil || spec.FileName != "main.cpp" {
 		t.Fatalf("unexpected cpp spec: %#v err=%v", spec, err)
 	}
+	spec, image, _, cmds, err := runner.LangSpecPublic(models.LangJavaScript)
+	if err != nil || spec.FileName != "main.js" || image != "node:20-slim" || len(cmds) != 1 || cmds[0][0] != "node" {
+		t.Fatalf("unexpected javascript spec: %#v image=%s cmds=%v err=%v", spec, image, cmds, err)
+	}
+	spec, image, _, cmds, err = runner.LangSpecPublic(models.LangGo)
+	if err != nil || spec.FileName != "main.go" || image != "golang:1.22-alpine" || len(cmds) != 2 ||
+		!reflect.DeepEqual(spec.CompileCmd, cmds[0]) || !reflect.DeepEqual(spec.ExecCmd, cmds[1]) {
+		t.Fatalf("unexpected go spec: %#v image=%s cmds=%v err=%v", spec, image, cmds, err)
+	}
+	spec, image, _, cmds, err = runner.LangSpecPublic(models.LangRust)
+	if err != nil || spec.FileName != "main.rs" || image != "rust:1.78-slim" || len(cmds) != 2 ||
+		!reflect.DeepEqual(spec.CompileCmd, cmds[0]) || !reflect.DeepEqual(spec.ExecCmd, cmds[1]) ||
+		!strings.Contains(spec.ExampleTemplate, "println!") {
+		t.Fatalf("unexpected rust spec: %#v image=%s cmds=%v err=%v", spec, image, cmds, err)
+	}
 	if _, _, _, _, err := runner.LangSpecPublic(models.Language("unknown")); err == nil {
 		t.Fatalf("expected error for unsupported language")
 	}
 }
 
+func TestRunnerLanguages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/languages" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"languages":[{"language":"go","displayName":"Go"},{"language":"cobol"},{"language":"python"}]}`))
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+
+	languages, err := runner.Languages(context.Background())
+	if err != nil {
+		t.Fatalf("languages: %v", err)
+	}
+	if !reflect.DeepEqual(languages, []models.Language{models.LangGo, models.LangPython}) {
+		t.Fatalf("expected the sandbox's order without unknown languages, got %v", languages)
+	}
+
+	server.Close()
+	if _, err := runner.Languages(context.Background()); err == nil {
+		t.Fatalf("expected an unreachable sandbox to fail")
+	}
+}
+
 func TestNewRunnerDefaults(t *testing.T) {
 	t.Setenv("SANDBOX_URL", "")
 	r := NewRunner()
@@ -271,6 +490,35 @@ func TestRunOnceMapsSandboxError(t *testing.T) {
 	}
 }
 
+func TestRunOnceSignsRequest(t *testing.T) {
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+		body, _ = io.ReadAll(r.Body)
+		_ = json.NewEncoder(w).Encode(sandboxResponse{})
+	}))
+	defer server.Close()
+
+	runner := &Runner{client: server.Client(), baseURL: server.URL, secret: "shh"}
+	if _, err := runner.RunOnce(context.Background(), models.LangPython, "print(1)", SandboxLimits{}); err != nil {
+		t.Fatalf("run once error: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Fatalf("expected signature %q over the body, got %q", want, signature)
+	}
+
+	runner.secret = ""
+	if _, err := runner.RunOnce(context.Background(), models.LangPython, "print(1)", SandboxLimits{}); err != nil {
+		t.Fatalf("run once error: %v", err)
+	}
+	if signature != "" {
+		t.Fatalf("expected no signature without a secret, got %q", signature)
+	}
+}
+
 func TestRunStreamIgnoresUnknownEvents(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp := sandboxResponse{
@@ -345,3 +593,116 @@ func TestRunStreamSkipsInvalidJSON(t *testing.T) {
 		t.Fatalf("expected frames to be skipped, got %#v", frames)
 	}
 }
+
+func TestStartInteractiveRelaysSession(t *testing.T) {
+	msgs := make(chan interactiveMessage, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run/interactive" || r.Header.Get("X-Requesting-Service") != "collab" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			var msg interactiveMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgs <- msg
+		}
+		_ = conn.WriteJSON(map[string]any{"type": "stdout", "data": "42\n"})
+		_ = conn.WriteJSON(map[string]any{"type": "exit", "data": map[string]any{"code": -1, "timedOut": false, "reason": "killed"}})
+	}))
+	defer server.Close()
+
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+	sess, err := runner.StartInteractive(context.Background(), models.LangPython, "print(input())", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("start interactive: %v", err)
+	}
+	defer sess.Close()
+	if err := sess.WriteStdin("42\n", true); err != nil {
+		t.Fatalf("write stdin: %v", err)
+	}
+	if err := sess.Kill(); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+
+	init := <-msgs
+	if init.Type != "init" || init.Language != "python" || init.Code != "print(input())" || init.Limits.WallTimeMs != 300000 {
+		t.Fatalf("unexpected init: %#v limits=%#v", init, init.Limits)
+	}
+	if stdin := <-msgs; stdin.Type != "stdin" || stdin.Data != "42\n" || !stdin.EOF {
+		t.Fatalf("unexpected stdin message: %#v", stdin)
+	}
+	if kill := <-msgs; kill.Type != "kill" {
+		t.Fatalf("unexpected kill message: %#v", kill)
+	}
+
+	frame, err := sess.Next()
+	if err != nil || frame.Type != "stdout" || frame.Data != "42\n" {
+		t.Fatalf("unexpected stdout frame: %#v err=%v", frame, err)
+	}
+	frame, err = sess.Next()
+	if err != nil || frame.Type != "exit" {
+		t.Fatalf("unexpected exit frame: %#v err=%v", frame, err)
+	}
+	if data := frame.Data.(map[string]any); data["code"] != -1 || data["reason"] != "killed" {
+		t.Fatalf("unexpected exit data: %#v", data)
+	}
+	if _, err := sess.Next(); err == nil {
+		t.Fatalf("expected error once the sandbox closes the session")
+	}
+}
+
+func TestStartInteractiveSignsInit(t *testing.T) {
+	signatures := make(chan string, 1)
+	inits := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures <- r.Header.Get(SignatureHeader)
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, data, _ := conn.ReadMessage()
+		inits <- data
+	}))
+	defer server.Close()
+
+	runner := &Runner{client: server.Client(), baseURL: server.URL, secret: "shh"}
+	sess, err := runner.StartInteractive(context.Background(), models.LangPython, "print(input())", SandboxLimits{})
+	if err != nil {
+		t.Fatalf("start interactive: %v", err)
+	}
+	defer sess.Close()
+
+	signature, init := <-signatures, <-inits
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(init)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Fatalf("expected signature %q over the init frame %s, got %q", want, init, signature)
+	}
+}
+
+func TestStartInteractiveSandboxUnavailable(t *testing.T) {
+	runner := &Runner{baseURL: "http://127.0.0.1:1"}
+	if _, err := runner.StartInteractive(context.Background(), models.LangPython, "", SandboxLimits{}); !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("expected ErrDockerUnavailable, got %v", err)
+	}
+}
+
+func TestInteractiveURL(t *testing.T) {
+	cases := map[string]string{
+		"http://sandbox:8090":       "ws://sandbox:8090/run/interactive",
+		"https://example.com/sbx":   "wss://example.com/sbx/run/interactive",
+		"ws://already-websocket:80": "ws://already-websocket:80/run/interactive",
+	}
+	for base, want := range cases {
+		if got := interactiveURL(base); got != want {
+			t.Fatalf("interactiveURL(%q) = %q, want %q", base, got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package exec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a run's body, keyed with
+// SANDBOX_SHARED_SECRET, which the sandbox checks before executing it
+const SignatureHeader = "X-Sandbox-Signature"
+
+// SignRequest sets the signature of body, the body req sends, under secret.
+// An empty secret leaves req unsigned, for a sandbox that does not check.
+func SignRequest(req *http.Request, body []byte, secret string) {
+	signHeader(req.Header, body, secret)
+}
+
+// signHeader sets the signature of body in header, for requests like the
+// interactive dial whose signed content is not the HTTP body
+func signHeader(header http.Header, body []byte, secret string) {
+	if secret == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,93 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"collab/internal/models"
+)
+
+// maxStreamLine bounds one line of the sandbox's event stream; an output
+// chunk is far smaller.
+const maxStreamLine = 1 << 20
+
+// RunStreamFn runs code like RunStream, but on the sandbox's streaming
+// endpoint: every frame is handed to emit as the program produces it rather
+// than all of them once it has finished.
+func (r *Runner) RunStreamFn(ctx context.Context, lang models.Language, code string, limits SandboxLimits, emit func(models.WSFrame)) error {
+	b := r.acquire(nil)
+	if b == nil {
+		return ErrDockerUnavailable
+	}
+	start := time.Now()
+	runErr, err := r.stream(ctx, b.url, sandboxBody(lang, code, limits), limits, emit)
+	result := classifyAttempt(sandboxResponse{Error: runErr}, err)
+	if ctx.Err() == context.Canceled {
+		result = attemptCanceled
+	}
+	r.release(b, time.Since(start), result)
+	if err != nil {
+		return err
+	}
+	return mapSandboxError(runErr)
+}
+
+// stream reads the Server-Sent Events of one run, returning the error code
+// the sandbox reported for it, if any
+func (r *Runner) stream(ctx context.Context, base string, body []byte, limits SandboxLimits, emit func(models.WSFrame)) (string, error) {
+	resp, err := r.send(ctx, base+"/run/stream", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		// Refused before the run started: a plain JSON error
+		var sr sandboxResponse
+		_ = json.NewDecoder(resp.Body).Decode(&sr)
+		return "", statusError(resp, sr.Error)
+	}
+
+	var (
+		runErr string
+		exited bool
+		evt    sandboxEvent
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case bytes.HasPrefix(line, []byte("event: ")):
+			evt.Type = string(line[len("event: "):])
+		case bytes.HasPrefix(line, []byte("data: ")):
+			evt.Data = append(json.RawMessage(nil), line[len("data: "):]...)
+		case len(line) == 0 && evt.Type != "":
+			if frame, ok := eventFrame(evt, limits.Args, limits.Env); ok {
+				if frame.Type == "error" {
+					runErr, _ = frame.Data.(string)
+				}
+				exited = exited || frame.Type == "exit"
+				emit(frame)
+			}
+			evt = sandboxEvent{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return runErr, ctx.Err()
+		}
+		return runErr, backendError{err}
+	}
+	if !exited {
+		// The replica went away mid-run
+		if ctx.Err() != nil {
+			return runErr, ctx.Err()
+		}
+		return runErr, backendError{io.ErrUnexpectedEOF}
+	}
+	return runErr, nil
+}
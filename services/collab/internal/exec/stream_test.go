@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"collab/internal/models"
+)
+
+func TestRunStreamFnEmitsFramesAsTheyArrive(t *testing.T) {
+	next := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run/stream" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, evt := range []string{
+			"event: run\ndata: {\"runId\":\"r1\"}\n\n",
+			"event: stdout\ndata: \"1\\n\"\n\n",
+			"event: stdout\ndata: \"2\\n\"\n\n",
+			"event: exit\ndata: {\"code\":0,\"timedOut\":false}\n\n",
+		} {
+			if i > 1 {
+				<-next
+			}
+			fmt.Fprint(w, evt)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	frames := make(chan models.WSFrame, 4)
+	done := make(chan error, 1)
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+	go func() {
+		done <- runner.RunStreamFn(context.Background(), models.LangPython, "code", SandboxLimits{Args: []string{"-v"}}, func(f models.WSFrame) {
+			frames <- f
+		})
+	}()
+
+	// The run event is not for clients; each later one is sent only once the
+	// frame before it has been emitted
+	for _, want := range []string{"1\n", "2\n"} {
+		select {
+		case f := <-frames:
+			if f.Type != "stdout" || f.Data != want {
+				t.Fatalf("expected stdout %q, got %#v", want, f)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected stdout %q before the run finished", want)
+		}
+		next <- struct{}{}
+	}
+	f := <-frames
+	data, _ := f.Data.(map[string]any)
+	if f.Type != "exit" || data["code"] != 0 || len(data["args"].([]string)) != 1 {
+		t.Fatalf("expected the exit frame with the invocation, got %#v", f)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRunStreamFnErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	runner := &Runner{client: server.Client(), baseURL: server.URL}
+	run := func() error {
+		return runner.RunStreamFn(context.Background(), models.LangPython, "code", SandboxLimits{}, func(models.WSFrame) {})
+	}
+
+	// Refused before the run started
+	status, body = http.StatusServiceUnavailable, `{"error":"sandbox_unavailable"}`
+	if err := run(); !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("expected the sandbox to be unavailable, got %v", err)
+	}
+
+	// Reported by the run itself
+	status, body = http.StatusOK, "event: error\ndata: \"sandbox_unavailable\"\n\nevent: exit\ndata: {\"code\":-1}\n\n"
+	if err := run(); !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("expected the run's error, got %v", err)
+	}
+
+	// Cut off before the exit
+	status, body = http.StatusOK, "event: stdout\ndata: \"1\"\n\n"
+	var be backendError
+	if err := run(); !errors.As(err, &be) {
+		t.Fatalf("expected a backend error for a stream without exit, got %v", err)
+	}
+}
@@ -1,18 +1,109 @@
 package format
 
 import (
+	"bytes"
 	"context"
+	_ "embed"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"collab/internal/models"
 )
 
-// TODO: formatting support requires dedicated tooling in the sandbox service.
-// For now, return the source unchanged.
+var (
+	ErrUnsupportedLanguage  = errors.New("unsupported_language")
+	ErrFormatterUnavailable = errors.New("formatter_unavailable")
+	ErrSyntax               = errors.New("format_syntax_error")
+)
+
+// SyntaxError is returned when the formatter rejects the code. Output is what
+// the formatter printed about it.
+type SyntaxError struct {
+	Output string
+}
+
+func (e *SyntaxError) Error() string { return ErrSyntax.Error() + ": " + e.Output }
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+// clangFormatStyle is the project's C++ style
+//
+//go:embed .clang-format
+var clangFormatStyle []byte
+
+// Format returns code formatted in the style of its language. Java goes
+// through google-java-format and C++ through clang-format, found on PATH or
+// at COLLAB_JAVA_FORMAT and COLLAB_CLANG_FORMAT. Python is returned as is.
 func Format(ctx context.Context, req models.FormatRequest) (string, error) {
 	select {
 	case <-ctx.Done():
 		return "", ctx.Err()
 	default:
 	}
-	return req.Code, nil
+	switch req.Language {
+	case models.LangPython:
+		return req.Code, nil
+	case models.LangJava:
+		return run(ctx, binary("COLLAB_JAVA_FORMAT", "google-java-format"), []string{"-"}, req.Code)
+	case models.LangCPP:
+		dir, err := styleDir()
+		if err != nil {
+			return "", errors.Join(ErrFormatterUnavailable, err)
+		}
+		// clang-format picks up the .clang-format next to the file it is told it formats
+		args := []string{"--style=file", "--assume-filename=" + filepath.Join(dir, "main.cpp")}
+		return run(ctx, binary("COLLAB_CLANG_FORMAT", "clang-format"), args, req.Code)
+	}
+	return "", ErrUnsupportedLanguage
+}
+
+func binary(env, fallback string) string {
+	if name := strings.TrimSpace(os.Getenv(env)); name != "" {
+		return name
+	}
+	return fallback
+}
+
+// run feeds code to the formatter and returns what it prints. A formatter
+// that exits non-zero rejected the code.
+func run(ctx context.Context, name string, args []string, code string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", errors.Join(ErrFormatterUnavailable, err)
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = strings.NewReader(code)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", &SyntaxError{Output: strings.TrimSpace(stderr.String())}
+		}
+		return "", errors.Join(ErrFormatterUnavailable, err)
+	}
+	return stdout.String(), nil
+}
+
+var (
+	styleOnce    sync.Once
+	styleDirPath string
+	styleErr     error
+)
+
+// styleDir writes the project's .clang-format to a directory of its own, once
+func styleDir() (string, error) {
+	styleOnce.Do(func() {
+		styleDirPath, styleErr = os.MkdirTemp("", "collab-clang-format")
+		if styleErr == nil {
+			styleErr = os.WriteFile(filepath.Join(styleDirPath, ".clang-format"), clangFormatStyle, 0o644)
+		}
+	})
+	return styleDirPath, styleErr
 }
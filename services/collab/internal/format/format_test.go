@@ -2,6 +2,10 @@ package format
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"collab/internal/models"
@@ -28,3 +32,75 @@ func TestFormatContextCancelled(t *testing.T) {
 		t.Fatalf("expected context cancellation error")
 	}
 }
+
+// fakeFormatter writes a shell script standing in for a formatter binary
+func fakeFormatter(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "formatter")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatalf("write fake formatter: %v", err)
+	}
+	return path
+}
+
+func TestFormatLanguages(t *testing.T) {
+	// Collapses runs of spaces, or rejects code containing "syntax error"
+	formatter := fakeFormatter(t, `code=$(cat)
+case "$code" in *"syntax error"*) echo "1:1: error: bad code" >&2; exit 1;; esac
+for arg in "$@"; do echo "# $arg"; done
+printf '%s\n' "$code" | tr -s ' '`)
+	t.Setenv("COLLAB_JAVA_FORMAT", formatter)
+	t.Setenv("COLLAB_CLANG_FORMAT", formatter)
+
+	cases := []struct {
+		name     string
+		lang     models.Language
+		code     string
+		want     []string // lines expected in the output
+		wantErr  error
+		syntaxed string
+	}{
+		{name: "python", lang: models.LangPython, code: "x  =  1", want: []string{"x  =  1"}},
+		{name: "java", lang: models.LangJava, code: "class  A {}", want: []string{"# -", "class A {}"}},
+		{name: "cpp", lang: models.LangCPP, code: "int  main() {}", want: []string{"# --style=file", "int main() {}"}},
+		{name: "java syntax error", lang: models.LangJava, code: "syntax error", wantErr: ErrSyntax, syntaxed: "1:1: error: bad code"},
+		{name: "unsupported", lang: models.Language("cobol"), code: "x", wantErr: ErrUnsupportedLanguage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Format(context.Background(), models.FormatRequest{Language: tc.lang, Code: tc.code})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			var syntaxErr *SyntaxError
+			if tc.syntaxed != "" && (!errors.As(err, &syntaxErr) || syntaxErr.Output != tc.syntaxed) {
+				t.Fatalf("expected the formatter's message, got %v", err)
+			}
+			for _, line := range tc.want {
+				if !strings.Contains(out, line+"\n") && out != line {
+					t.Fatalf("expected %q in the output, got %q", line, out)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatCppUsesProjectStyle(t *testing.T) {
+	// Prints the .clang-format next to the file it is told it formats
+	t.Setenv("COLLAB_CLANG_FORMAT", fakeFormatter(t, `cat >/dev/null
+file=$(echo "$2" | sed 's/^--assume-filename=//')
+cat "$(dirname "$file")/.clang-format"`))
+
+	out, err := Format(context.Background(), models.FormatRequest{Language: models.LangCPP, Code: "int main() {}"})
+	if err != nil || out != string(clangFormatStyle) {
+		t.Fatalf("expected clang-format to find the project style, got %q err=%v", out, err)
+	}
+}
+
+func TestFormatterUnavailable(t *testing.T) {
+	t.Setenv("COLLAB_JAVA_FORMAT", filepath.Join(t.TempDir(), "missing"))
+	_, err := Format(context.Background(), models.FormatRequest{Language: models.LangJava, Code: "class A {}"})
+	if !errors.Is(err, ErrFormatterUnavailable) || errors.Is(err, ErrSyntax) {
+		t.Fatalf("expected the formatter to be unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package i18n holds the user-facing messages for the collab service's error
+// codes. Codes are the stable contract with clients; messages may change and
+// are chosen per request from the available locales.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when the client asks for nothing we have.
+const DefaultLocale = "en"
+
+// GenericCode is the code for failures that have no more specific one. Its
+// message is also the fallback for codes missing from the catalog.
+const GenericCode = "internal_error"
+
+// catalogs maps a locale to its messages by code. A locale may be partial:
+// codes it lacks fall back to English. Add a locale by adding a file with its
+// map and registering it here.
+var catalogs = map[string]map[string]string{
+	"en": english,
+}
+
+// Known reports whether code has a message, i.e. is part of the contract.
+func Known(code string) bool {
+	_, ok := english[code]
+	return ok
+}
+
+// Message returns the message for code in locale, falling back to English and
+// then to the generic message for unknown codes.
+func Message(locale, code string) string {
+	if msg, ok := catalogs[locale][code]; ok {
+		return msg
+	}
+	if msg, ok := english[code]; ok {
+		return msg
+	}
+	if msg, ok := catalogs[locale][GenericCode]; ok {
+		return msg
+	}
+	return english[GenericCode]
+}
+
+// Locales returns the available locales, sorted.
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Negotiate picks the available locale that best matches an Accept-Language
+// header (or a plain tag such as "en-GB"), honouring q-values. Region subtags
+// match their base language. It returns DefaultLocale when nothing matches.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := DefaultLocale, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		locale := matchLocale(strings.ToLower(strings.TrimSpace(tag)))
+		if locale != "" && q > bestQ {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+func matchLocale(tag string) string {
+	if tag == "" || tag == "*" {
+		return ""
+	}
+	if _, ok := catalogs[tag]; ok {
+		return tag
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	if _, ok := catalogs[base]; ok {
+		return base
+	}
+	return ""
+}
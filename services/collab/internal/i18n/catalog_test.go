@@ -0,0 +1,59 @@
+package i18n
+
+import "testing"
+
+func withLocale(t *testing.T, locale string, messages map[string]string) {
+	t.Helper()
+	catalogs[locale] = messages
+	t.Cleanup(func() { delete(catalogs, locale) })
+}
+
+func TestNegotiate(t *testing.T) {
+	withLocale(t, "fr", map[string]string{"room_full": "Cette salle est pleine."})
+
+	cases := map[string]string{
+		"":                          "en",
+		"*":                         "en",
+		"de-DE":                     "en",
+		"fr":                        "fr",
+		"FR-ca":                     "fr",
+		"de, fr;q=0.8, en;q=0.5":    "fr",
+		"en;q=0.9, fr;q=0.3":        "en",
+		"fr;q=bogus, en-GB;q=0.1":   "en",
+		"  fr-CH ; q=1 , en ; q=.9": "fr",
+	}
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMessageFallbacks(t *testing.T) {
+	withLocale(t, "fr", map[string]string{"room_full": "Cette salle est pleine."})
+
+	if got := Message("fr", "room_full"); got != "Cette salle est pleine." {
+		t.Fatalf("expected the French message, got %q", got)
+	}
+	// missing from a partial locale: English
+	if got := Message("fr", "no_draft"); got != english["no_draft"] {
+		t.Fatalf("expected the English fallback, got %q", got)
+	}
+	// unknown locale and unknown code: the generic message
+	if got := Message("xx", "no_such_code"); got != english[GenericCode] {
+		t.Fatalf("expected the generic message, got %q", got)
+	}
+	if Known("no_such_code") || !Known("room_full") {
+		t.Fatal("Known disagrees with the English catalog")
+	}
+}
+
+func TestLocalesOnlyTranslateKnownCodes(t *testing.T) {
+	for _, locale := range Locales() {
+		for code := range catalogs[locale] {
+			if _, ok := english[code]; !ok {
+				t.Errorf("locale %s has a message for %q, which English lacks", locale, code)
+			}
+		}
+	}
+}
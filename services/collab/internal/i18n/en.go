@@ -0,0 +1,130 @@
+package i18n
+
+// english is the reference catalog: every error code the collab service sends
+// to clients must have an entry here.
+var english = map[string]string{
+	GenericCode: "Something went wrong on our side. Please try again.",
+
+	// requests and authentication
+	"invalid_request":     "The request could not be read.",
+	"match_id_required":   "A match ID is required.",
+	"user_id_required":    "A user ID is required.",
+	"token_required":      "Your session token is missing. Please rejoin the room.",
+	"unauthorized":        "You are not allowed to access this room.",
+	"invalid_room":        "This link does not belong to your room.",
+	"token_room_mismatch": "Your session token belongs to a different room.",
+	"room_not_found":      "This room could not be found. It may have ended.",
+	"room_full":           "This room already has two participants.",
+	"spectators_full":     "This room already has as many spectators as it allows.",
+	"read_only":           "You are watching this room and cannot change it.",
+	"no_summary":          "There is no summary for this session. It may have expired.",
+	"invalid_limit":       "The limit must be a positive number.",
+	"unknown_user":        "We could not tell who you are in this room. Please rejoin.",
+	"expected_init":       "The connection was not set up correctly. Please reload the page.",
+	"unknown_type":        "That action is not supported.",
+	"invalid_payload":     "That message was malformed and was ignored.",
+
+	// questions
+	"no_rerolls":               "You have no question rerolls left in this room.",
+	"no_alternative_question":  "No different question is available right now.",
+	"reroll_pending":           "A question reroll is already waiting for your partner.",
+	"no_pending_reroll":        "There is no question reroll waiting for approval.",
+	"reroll_failed":            "The question could not be changed. Please try again.",
+	"question_service_timeout": "The question service took too long to answer. Please try again.",
+	"room_not_in_error":        "This room already has its question or is still fetching it.",
+	"hint_unavailable":         "A hint could not be fetched right now. Please try again.",
+	"hint_limit_reached":       "You have used all the hints for this question.",
+
+	// editing
+	"version_mismatch":      "Your editor fell out of sync and has been refreshed.",
+	"invalid_range":         "That edit did not match the document and was not applied.",
+	"transform_too_long":    "That edit was too large to merge and was not applied.",
+	"doc_too_large":         "That edit would make the document too large and was not applied.",
+	"rate_limited":          "You are editing too fast. Some changes were not applied.",
+	"cursor_spoofed":        "That cursor update was not yours and was ignored.",
+	"ot_error":              "That edit could not be applied.",
+	"undo_conflict":         "That change has since been edited and can no longer be undone or redone.",
+	"nothing_to_undo":       "There is nothing of yours to undo.",
+	"nothing_to_redo":       "There is nothing to redo.",
+	"format_failed":         "The code could not be formatted.",
+	"format_syntax_error":   "The code could not be formatted because it has a syntax error.",
+	"formatter_unavailable": "Formatting is not available for this language right now.",
+	"export_failed":         "The code could not be exported. Please try again.",
+	"invalid_setting":       "That setting value is not allowed.",
+
+	// running code
+	"language_not_allowed":   "This question does not allow that language.",
+	"sandbox_unavailable":    "Code execution is unavailable right now. Please try again shortly.",
+	"run_failed":             "Your code could not be run. Please try again.",
+	"run_in_progress":        "A run is already in progress in this room.",
+	"run_rate_limited":       "You are running code too often. Please wait a moment.",
+	"run_cooldown":           "The last run just finished. Please wait a moment before running again.",
+	"no_run_in_progress":     "There is no run in progress to cancel.",
+	"memory_limit_exceeded":  "Your program ran out of memory and was stopped.",
+	"interactive_run_active": "An interactive run is already in progress in this room.",
+	"no_interactive_run":     "There is no interactive run in progress.",
+	"interactive_run_closed": "The interactive run has already finished.",
+	"sandbox_disconnected":   "The connection to the code runner was lost.",
+	"sandbox_error":          "The code runner failed. Please try again.",
+	"sandbox_busy":           "The code runner is busy. Please try again shortly.",
+	"unsupported_language":   "That language is not supported.",
+	"stdin_failed":           "Your input could not be sent to the program.",
+	"invalid_invocation":     "The program arguments or environment variables are not allowed.",
+	"no_tests_available":     "This question has no example test cases to run.",
+	"lint_failed":            "Your code could not be checked. Please try again.",
+	"question_unavailable":   "The room's question could not be loaded. Please try again.",
+
+	// chat
+	"unknown_message":    "That message no longer exists.",
+	"emoji_not_allowed":  "That reaction is not available.",
+	"too_many_reactions": "That message has too many reactions already.",
+	"invalid_reaction":   "That reaction could not be applied.",
+	"chat_unavailable":   "The chat of this room could not be loaded. Please try again.",
+
+	// drafts
+	"draft_too_large":     "Your draft is too large to save.",
+	"draft_save_failed":   "Your draft could not be saved. Please try again.",
+	"draft_unavailable":   "Your draft could not be loaded. Please try again.",
+	"no_draft":            "You have no saved draft in this room.",
+	"restore_pending":     "A draft restore is already waiting for approval.",
+	"no_pending_restore":  "There is no draft restore waiting for approval.",
+	"partner_unavailable": "Your partner needs to be connected to approve a draft restore.",
+
+	// snapshots
+	"unknown_snapshot":      "That snapshot no longer exists.",
+	"snapshots_unavailable": "The snapshots of this room could not be loaded. Please try again.",
+
+	// turn-taking
+	"invalid_mode":     "That editing mode or turn length is not supported.",
+	"mode_unchanged":   "The room is already in that editing mode.",
+	"mode_pending":     "A mode change is already waiting for approval.",
+	"no_pending_mode":  "There is no mode change waiting for approval.",
+	"partner_required": "Turn-taking needs both participants in the room.",
+	"not_your_turn":    "It is your partner's turn to edit and run code.",
+	"turns_disabled":   "Turn-taking is not enabled in this room.",
+
+	// driver and navigator
+	"not_driver":     "Only the driver can do that. Ask for control to take over.",
+	"already_driver": "You are already the driver.",
+	"no_navigator":   "There is no navigator to hand control to.",
+	"roles_disabled": "Everyone can edit in this room, so there is no control to hand over.",
+
+	// ending the session
+	"end_pending":    "You have already asked your partner to end the session.",
+	"no_pending_end": "There is no request to end the session waiting for approval.",
+
+	// rubric
+	"unknown_rubric_item": "That rubric item does not exist.",
+
+	// discussion threads
+	"invalid_thread":        "A thread needs a title and replies need a message.",
+	"thread_too_long":       "That thread title or message is too long.",
+	"too_many_threads":      "This room has reached its limit of threads.",
+	"too_many_replies":      "That thread has reached its limit of replies.",
+	"unknown_thread":        "That thread does not exist.",
+	"thread_resolved":       "That thread is already resolved.",
+	"hint_budget_exhausted": "This room has used all of its assistant replies.",
+	"ai_unavailable":        "The assistant is not available right now.",
+	"ai_quota_exceeded":     "The assistant has answered too many questions. Please try again later.",
+	"ai_failed":             "The assistant could not answer. Please try again.",
+}
@@ -0,0 +1,157 @@
+package lint
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"collab/internal/models"
+)
+
+// The sandbox only runs programs, so each language's check is a small program
+// of that language: it reads the code to check from standard input, runs the
+// checker on it and prints the checker's output as is.
+
+// pythonChecker runs pyflakes when the image has it and falls back to
+// compiling the code, which only finds syntax errors, printed the way
+// pyflakes prints them.
+const pythonChecker = `import sys
+
+src = sys.stdin.read()
+try:
+    from pyflakes.api import check
+    from pyflakes.reporter import Reporter
+except ImportError:
+    try:
+        compile(src, "main.py", "exec")
+    except SyntaxError as e:
+        print(f"main.py:{e.lineno or 1}:{e.offset or 1}: {e.msg}")
+        sys.exit(1)
+    sys.exit(0)
+sys.exit(check(src, "main.py", Reporter(sys.stdout, sys.stdout)))
+`
+
+// javaChecker compiles the code as Main.java with javac -Xlint
+const javaChecker = `import java.nio.file.Files;
+import java.nio.file.Path;
+import javax.tools.ToolProvider;
+
+public class Main {
+    public static void main(String[] args) throws Exception {
+        Path dir = Files.createTempDirectory("lint");
+        Path src = dir.resolve("Main.java");
+        Files.write(src, System.in.readAllBytes());
+        int exit = ToolProvider.getSystemJavaCompiler()
+                .run(null, System.out, System.out, "-Xlint", "-d", dir.toString(), src.toString());
+        System.exit(exit);
+    }
+}
+`
+
+// cppChecker checks the code as main.cpp with g++ -fsyntax-only
+const cppChecker = `#include <cstdlib>
+#include <fstream>
+#include <iostream>
+#include <sstream>
+
+int main() {
+    std::stringstream code;
+    code << std::cin.rdbuf();
+    std::ofstream("/tmp/main.cpp") << code.str();
+    int status = std::system("cd /tmp && g++ -std=c++17 -fsyntax-only -Wall -Wextra main.cpp 2>&1");
+    return status == 0 ? 0 : 1;
+}
+`
+
+var checkers = map[models.Language]string{
+	models.LangPython: pythonChecker,
+	models.LangJava:   javaChecker,
+	models.LangCPP:    cppChecker,
+}
+
+var (
+	// main.py:3:5: undefined name 'x'; older pyflakes leave out the column
+	pyflakesLine = regexp.MustCompile(`^main\.py:(\d+):(?:(\d+):)? (.+)$`)
+	// /tmp/lint123/Main.java:3: error: ';' expected
+	javacLine = regexp.MustCompile(`(?:^|/)Main\.java:(\d+): (error|warning): (.+)$`)
+	// main.cpp:3:5: error: 'x' was not declared in this scope
+	gccLine = regexp.MustCompile(`(?:^|/)main\.cpp:(\d+):(\d+): (fatal error|error|warning|note): (.+)$`)
+)
+
+// pyflakesWarnings are the pyflakes messages about code that still runs;
+// anything else pyflakes or the compiler reports is an error.
+var pyflakesWarnings = []string{
+	"imported but unused",
+	"redefinition of unused",
+	"is assigned to but never used",
+	"shadowed by loop variable",
+	"unable to detect undefined names",
+	"may be undefined, or defined from star imports",
+	"is missing placeholders",
+}
+
+// Parse turns what the checker of lang printed into diagnostics. Lines that
+// are not diagnostics, like the source excerpts compilers print, are skipped.
+func Parse(lang models.Language, output string) []models.Diagnostic {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	diagnostics := []models.Diagnostic{}
+	for i, line := range lines {
+		switch lang {
+		case models.LangPython:
+			m := pyflakesLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			severity := "error"
+			for _, warning := range pyflakesWarnings {
+				if strings.Contains(m[3], warning) {
+					severity = "warning"
+					break
+				}
+			}
+			diagnostics = append(diagnostics, models.Diagnostic{
+				Line: atoi(m[1]), Column: atoi(m[2]), Severity: severity, Message: m[3],
+			})
+
+		case models.LangJava:
+			m := javacLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			diagnostics = append(diagnostics, models.Diagnostic{
+				Line: atoi(m[1]), Column: javacColumn(lines[i+1:]), Severity: m[2], Message: m[3],
+			})
+
+		case models.LangCPP:
+			m := gccLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			severity := m[3]
+			switch severity {
+			case "fatal error":
+				severity = "error"
+			case "note":
+				severity = "info"
+			}
+			diagnostics = append(diagnostics, models.Diagnostic{
+				Line: atoi(m[1]), Column: atoi(m[2]), Severity: severity, Message: m[4],
+			})
+		}
+	}
+	return diagnostics
+}
+
+// javacColumn finds the column javac points at: after the diagnostic it
+// prints the source line and then a line with a caret under the column.
+func javacColumn(rest []string) int {
+	if len(rest) < 2 || strings.TrimSpace(rest[1]) != "^" {
+		return 0
+	}
+	return strings.Index(rest[1], "^") + 1
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,132 @@
+package lint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/models"
+)
+
+const (
+	// cacheTTL is how long the diagnostics of one piece of code are reused, so
+	// both users linting the same document spin up a single container
+	cacheTTL = 10 * time.Second
+	// wallTime and memoryBytes bound one check in the sandbox
+	wallTime    = 2 * time.Second
+	memoryBytes = 128 * 1024 * 1024
+)
+
+var (
+	ErrUnsupportedLanguage = errors.New("unsupported_language")
+	// ErrLintFailed is returned when the check itself did not run to the end,
+	// e.g. it timed out; problems in the code are diagnostics, not errors.
+	ErrLintFailed = errors.New("lint_failed")
+)
+
+// Runner runs a program in the sandbox
+type Runner interface {
+	RunOnce(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error)
+}
+
+// Linter runs the static check of a language in the sandbox and parses what
+// it reports. Results are cached by code for cacheTTL, and concurrent checks
+// of the same code share one sandbox run.
+type Linter struct {
+	runner Runner
+	now    func() time.Time // replaceable in tests
+
+	mu       sync.Mutex
+	cache    map[string]cacheEntry
+	inflight map[string]*pendingCheck
+}
+
+type cacheEntry struct {
+	diagnostics []models.Diagnostic
+	expires     time.Time
+}
+
+type pendingCheck struct {
+	done        chan struct{}
+	diagnostics []models.Diagnostic
+	err         error
+}
+
+func NewLinter(runner Runner) *Linter {
+	return &Linter{
+		runner:   runner,
+		now:      time.Now,
+		cache:    make(map[string]cacheEntry),
+		inflight: make(map[string]*pendingCheck),
+	}
+}
+
+// Lint returns the problems the static check of lang finds in code, in the
+// order the checker reported them.
+func (l *Linter) Lint(ctx context.Context, lang models.Language, code string) ([]models.Diagnostic, error) {
+	program, ok := checkers[lang]
+	if !ok {
+		return nil, ErrUnsupportedLanguage
+	}
+	key := cacheKey(lang, code)
+
+	l.mu.Lock()
+	now := l.now()
+	for k, entry := range l.cache {
+		if !now.Before(entry.expires) {
+			delete(l.cache, k)
+		}
+	}
+	if entry, ok := l.cache[key]; ok {
+		l.mu.Unlock()
+		return entry.diagnostics, nil
+	}
+	if pending, ok := l.inflight[key]; ok {
+		l.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.diagnostics, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pending := &pendingCheck{done: make(chan struct{})}
+	l.inflight[key] = pending
+	l.mu.Unlock()
+
+	pending.diagnostics, pending.err = l.check(ctx, lang, program, code)
+
+	l.mu.Lock()
+	delete(l.inflight, key)
+	if pending.err == nil {
+		l.cache[key] = cacheEntry{diagnostics: pending.diagnostics, expires: l.now().Add(cacheTTL)}
+	}
+	l.mu.Unlock()
+	close(pending.done)
+	return pending.diagnostics, pending.err
+}
+
+// check runs the checker program of lang with code on its standard input
+func (l *Linter) check(ctx context.Context, lang models.Language, program, code string) ([]models.Diagnostic, error) {
+	out, err := l.runner.RunOnce(ctx, lang, program, exec.SandboxLimits{
+		WallTime: wallTime,
+		MemoryB:  memoryBytes,
+		Stdin:    code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.TimedOut || out.OOMKilled || out.CompileOutput != "" {
+		return nil, ErrLintFailed
+	}
+	return Parse(lang, out.Stdout), nil
+}
+
+func cacheKey(lang models.Language, code string) string {
+	sum := sha256.Sum256([]byte(string(lang) + "\x00" + code))
+	return hex.EncodeToString(sum[:])
+}
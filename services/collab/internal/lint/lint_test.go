@@ -0,0 +1,225 @@
+package lint
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"collab/internal/exec"
+	"collab/internal/models"
+)
+
+type fakeRunner struct {
+	mu     sync.Mutex
+	runs   int
+	output exec.RunOutput
+	err    error
+	block  chan struct{} // when set, runs wait for it to close
+}
+
+func (f *fakeRunner) RunOnce(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+	f.mu.Lock()
+	f.runs++
+	block := f.block
+	f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	return f.output, f.err
+}
+
+func (f *fakeRunner) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.runs
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		lang   models.Language
+		output string
+		want   []models.Diagnostic
+	}{
+		{
+			name: "pyflakes",
+			lang: models.LangPython,
+			output: "main.py:1:1: 'os' imported but unused\n" +
+				"main.py:4:11: undefined name 'total'\n" +
+				"main.py:7: local variable 'x' is assigned to but never used\n",
+			want: []models.Diagnostic{
+				{Line: 1, Column: 1, Severity: "warning", Message: "'os' imported but unused"},
+				{Line: 4, Column: 11, Severity: "error", Message: "undefined name 'total'"},
+				{Line: 7, Column: 0, Severity: "warning", Message: "local variable 'x' is assigned to but never used"},
+			},
+		},
+		{
+			name:   "python syntax error",
+			lang:   models.LangPython,
+			output: "main.py:2:11: expected ':'\n    if x > 1\n            ^\n",
+			want:   []models.Diagnostic{{Line: 2, Column: 11, Severity: "error", Message: "expected ':'"}},
+		},
+		{
+			name: "javac",
+			lang: models.LangJava,
+			output: "/tmp/lint8312/Main.java:3: error: ';' expected\n" +
+				"        int x = 1\n" +
+				"                 ^\n" +
+				"/tmp/lint8312/Main.java:5: warning: [rawtypes] found raw type: List\n" +
+				"        List xs = new ArrayList<Integer>();\n" +
+				"        ^\n" +
+				"  missing type arguments for generic class List<E>\n" +
+				"1 error\n1 warning\n",
+			want: []models.Diagnostic{
+				{Line: 3, Column: 18, Severity: "error", Message: "';' expected"},
+				{Line: 5, Column: 9, Severity: "warning", Message: "[rawtypes] found raw type: List"},
+			},
+		},
+		{
+			name: "g++",
+			lang: models.LangCPP,
+			output: "main.cpp: In function 'int main()':\n" +
+				"main.cpp:4:9: warning: unused variable 'y' [-Wunused-variable]\n" +
+				"    4 |     int y = 2;\n" +
+				"      |         ^\n" +
+				"main.cpp:5:5: error: 'x' was not declared in this scope\n" +
+				"main.cpp:1:10: fatal error: missing.h: No such file or directory\n" +
+				"main.cpp:2:6: note: declared here\n",
+			want: []models.Diagnostic{
+				{Line: 4, Column: 9, Severity: "warning", Message: "unused variable 'y' [-Wunused-variable]"},
+				{Line: 5, Column: 5, Severity: "error", Message: "'x' was not declared in this scope"},
+				{Line: 1, Column: 10, Severity: "error", Message: "missing.h: No such file or directory"},
+				{Line: 2, Column: 6, Severity: "info", Message: "declared here"},
+			},
+		},
+		{name: "clean", lang: models.LangCPP, output: "", want: []models.Diagnostic{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Parse(tc.lang, tc.output); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected diagnostics:\n got %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLintRunsCheckerInSandbox(t *testing.T) {
+	var got exec.SandboxLimits
+	var program string
+	runner := &fakeRunner{output: exec.RunOutput{Stdout: "main.py:1:1: undefined name 'x'\n", Exit: 1}}
+	l := NewLinter(runnerFunc(func(_ context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+		program, got = code, limits
+		return runner.RunOnce(context.Background(), lang, code, limits)
+	}))
+
+	diagnostics, err := l.Lint(context.Background(), models.LangPython, "x")
+	if err != nil || len(diagnostics) != 1 {
+		t.Fatalf("unexpected result: %+v err=%v", diagnostics, err)
+	}
+	if program != pythonChecker || got.Stdin != "x" || got.WallTime != 2*time.Second || got.MemoryB != 128*1024*1024 {
+		t.Fatalf("expected the checker to run on the code with tight limits, got %+v", got)
+	}
+
+	if _, err := l.Lint(context.Background(), models.Language("cobol"), "x"); !errors.Is(err, ErrUnsupportedLanguage) {
+		t.Fatalf("expected ErrUnsupportedLanguage, got %v", err)
+	}
+}
+
+func TestLintFailures(t *testing.T) {
+	cases := []struct {
+		name   string
+		output exec.RunOutput
+		err    error
+		want   error
+	}{
+		{name: "sandbox down", err: exec.ErrDockerUnavailable, want: exec.ErrDockerUnavailable},
+		{name: "timed out", output: exec.RunOutput{TimedOut: true}, want: ErrLintFailed},
+		{name: "checker did not build", output: exec.RunOutput{CompileOutput: "javac: not found"}, want: ErrLintFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := &fakeRunner{output: tc.output, err: tc.err}
+			l := NewLinter(runner)
+			if _, err := l.Lint(context.Background(), models.LangJava, "class Main {}"); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			// Failures are not cached
+			_, _ = l.Lint(context.Background(), models.LangJava, "class Main {}")
+			if runner.count() != 2 {
+				t.Fatalf("expected a failed lint to be retried, got %d runs", runner.count())
+			}
+		})
+	}
+}
+
+func TestLintCachesByCode(t *testing.T) {
+	runner := &fakeRunner{output: exec.RunOutput{Stdout: "main.cpp:1:1: error: bad\n"}}
+	l := NewLinter(runner)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	lint := func(code string) {
+		t.Helper()
+		if _, err := l.Lint(context.Background(), models.LangCPP, code); err != nil {
+			t.Fatalf("lint: %v", err)
+		}
+	}
+	lint("a")
+	lint("a")
+	if runner.count() != 1 {
+		t.Fatalf("expected the second lint of the same code to be cached, got %d runs", runner.count())
+	}
+	lint("b")
+	if runner.count() != 2 {
+		t.Fatalf("expected different code to be linted, got %d runs", runner.count())
+	}
+	now = now.Add(cacheTTL)
+	lint("a")
+	if runner.count() != 3 {
+		t.Fatalf("expected the cache to expire after %s, got %d runs", cacheTTL, runner.count())
+	}
+}
+
+func TestLintSharesConcurrentChecks(t *testing.T) {
+	runner := &fakeRunner{output: exec.RunOutput{Stdout: "main.cpp:1:1: error: bad\n"}, block: make(chan struct{})}
+	l := NewLinter(runner)
+
+	var wg sync.WaitGroup
+	results := make([][]models.Diagnostic, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = l.Lint(context.Background(), models.LangCPP, "int main() {}")
+		}()
+	}
+	// Let both lints reach the linter before the check finishes
+	for deadline := time.Now().Add(time.Second); ; {
+		l.mu.Lock()
+		pending := len(l.inflight)
+		l.mu.Unlock()
+		if pending == 1 && runner.count() == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(runner.block)
+	wg.Wait()
+
+	if runner.count() != 1 {
+		t.Fatalf("expected concurrent lints of the same code to share a run, got %d", runner.count())
+	}
+	if len(results[0]) != 1 || !reflect.DeepEqual(results[0], results[1]) {
+		t.Fatalf("expected both lints to get the diagnostics, got %+v", results)
+	}
+}
+
+type runnerFunc func(context.Context, models.Language, string, exec.SandboxLimits) (exec.RunOutput, error)
+
+func (f runnerFunc) RunOnce(ctx context.Context, lang models.Language, code string, limits exec.SandboxLimits) (exec.RunOutput, error) {
+	return f(ctx, lang, code, limits)
+}
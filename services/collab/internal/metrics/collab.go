@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Run outcomes reported in collab_runs_total
+const (
+	RunSuccess     = "success"
+	RunError       = "error"
+	RunTimeout     = "timeout"
+	RunUnavailable = "unavailable"
+	RunCancelled   = "cancelled"
+)
+
+var (
+	roomsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collab_rooms_active",
+		Help: "Number of collaboration rooms held in memory by this instance",
+	})
+
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collab_ws_connections",
+		Help: "Number of WebSocket clients joined to a room on this instance",
+	})
+
+	roomSetupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collab_room_setup_duration_seconds",
+		Help:    "Time from receiving a match event until the room is ready or has failed",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	runsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_runs_started_total",
+		Help: "Sandbox runs started by language",
+	}, []string{"language"})
+
+	runsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_runs_total",
+		Help: "Sandbox runs by language and outcome",
+	}, []string{"language", "outcome"})
+
+	editsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "collab_edits_total",
+		Help: "Document edits applied",
+	})
+
+	otErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_ot_errors_total",
+		Help: "Rejected document edits by mapped OT error",
+	}, []string{"type"})
+
+	wsFrameSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "collab_ws_frame_size_bytes",
+		Help:    "Size of the WebSocket frames received from clients",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	})
+
+	docResyncsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_doc_resyncs_total",
+		Help: "Full document resyncs requested by clients that could not apply a doc_delta",
+	}, []string{"reason"})
+
+	turnDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collab_turn_duration_seconds",
+		Help:    "Time one user spent as the active user in turn-taking mode, by how the turn ended",
+		Buckets: []float64{30, 60, 120, 300, 600, 900, 1800, 3600},
+	}, []string{"reason"})
+
+	sandboxBackendHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collab_sandbox_backend_healthy",
+		Help: "Whether a sandbox backend is taking runs (1) or ejected after consecutive failures (0)",
+	}, []string{"backend"})
+
+	sandboxBackendInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collab_sandbox_backend_in_flight",
+		Help: "Runs and interactive sessions open against a sandbox backend",
+	}, []string{"backend"})
+
+	sandboxBackendP95 = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collab_sandbox_backend_latency_p95_seconds",
+		Help: "Rolling p95 latency of successful runs on a sandbox backend",
+	}, []string{"backend"})
+
+	sandboxSelections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_sandbox_backend_selections_total",
+		Help: "Runs sent to a sandbox backend, hedges included",
+	}, []string{"backend"})
+
+	sandboxHedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collab_sandbox_hedges_total",
+		Help: "Hedged runs by which attempt answered first",
+	}, []string{"winner"})
+)
+
+// Resync reasons reported in collab_doc_resyncs_total
+const (
+	ResyncHashMismatch = "hash_mismatch"
+	ResyncVersionGap   = "version_gap"
+	ResyncOther        = "other"
+)
+
+func RoomOpened() { roomsActive.Inc() }
+
+func RoomClosed() { roomsActive.Dec() }
+
+func ConnectionOpened() { wsConnections.Inc() }
+
+// ConnectionsClosed removes n connections, e.g. every client of a deleted room.
+func ConnectionsClosed(n int) { wsConnections.Sub(float64(n)) }
+
+// ObserveRoomSetup records how long a room took to become ready ("ready") or fail ("error").
+func ObserveRoomSetup(outcome string, d time.Duration) {
+	roomSetupDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+func RecordRunStarted(language string) { runsStarted.WithLabelValues(language).Inc() }
+
+// RecordRun records how a run ended; every started run ends with one outcome.
+func RecordRun(language, outcome string) { runsTotal.WithLabelValues(language, outcome).Inc() }
+
+func RecordEdit() { editsTotal.Inc() }
+
+func RecordOTError(errType string) { otErrorsTotal.WithLabelValues(errType).Inc() }
+
+func ObserveFrameSize(bytes int) { wsFrameSize.Observe(float64(bytes)) }
+
+func RecordDocResync(reason string) { docResyncsTotal.WithLabelValues(reason).Inc() }
+
+// ObserveTurn records a completed turn. reason is how it ended: "timer",
+// "pass", "disconnect" or "disabled".
+func ObserveTurn(reason string, d time.Duration) {
+	turnDuration.WithLabelValues(reason).Observe(d.Seconds())
+}
+
+// SetSandboxBackendHealth records whether backend is taking runs
+func SetSandboxBackendHealth(backend string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	sandboxBackendHealthy.WithLabelValues(backend).Set(v)
+}
+
+func SetSandboxBackendInFlight(backend string, n int) {
+	sandboxBackendInFlight.WithLabelValues(backend).Set(float64(n))
+}
+
+func SetSandboxBackendP95(backend string, d time.Duration) {
+	sandboxBackendP95.WithLabelValues(backend).Set(d.Seconds())
+}
+
+func RecordSandboxSelection(backend string) { sandboxSelections.WithLabelValues(backend).Inc() }
+
+// RecordSandboxHedge records which attempt of a hedged run answered first,
+// "primary" or "hedge"
+func RecordSandboxHedge(winner string) { sandboxHedges.WithLabelValues(winner).Inc() }
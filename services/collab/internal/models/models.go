@@ -3,9 +3,12 @@ package models
 type Language string
 
 const (
-	LangPython Language = "python"
-	LangJava   Language = "java"
-	LangCPP    Language = "cpp"
+	LangPython     Language = "python"
+	LangJava       Language = "java"
+	LangCPP        Language = "cpp"
+	LangJavaScript Language = "javascript"
+	LangGo         Language = "go"
+	LangRust       Language = "rust"
 )
 
 type LanguageSpec struct {
@@ -23,6 +26,12 @@ type RunRequest struct {
 	Language Language `json:"language"`
 	Code     string   `json:"code"`
 	Stdin    string   `json:"stdin,omitempty"`
+	// Args and Env are passed to the program; the question's own take precedence
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+	// MatchID runs the code in that room's shared session; the request must
+	// carry the room token for it
+	MatchID string `json:"matchId,omitempty"`
 }
 
 type RunResult struct {
@@ -30,6 +39,27 @@ type RunResult struct {
 	Stderr   string `json:"stderr"`
 	Exit     int    `json:"exit"`
 	TimedOut bool   `json:"timedOut"`
+	// set when a participant cancelled the run before it finished
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// the arguments and environment the program actually ran with
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+
+	Usage *RunUsage `json:"usage,omitempty"`
+	// set when the output hit the sandbox's limit and the program was killed
+	OutputTruncated bool `json:"outputTruncated,omitempty"`
+	// set when the program was killed for going over its memory limit
+	OOMKilled bool `json:"oomKilled,omitempty"`
+	// the compiler's output when the program did not build and never ran
+	CompileOutput string `json:"compileOutput,omitempty"`
+}
+
+// RunUsage is what the program of a run used, as measured by the sandbox.
+// PeakMemoryBytes is omitted when the sandbox could not measure it.
+type RunUsage struct {
+	DurationMs      int64 `json:"durationMs"`
+	PeakMemoryBytes int64 `json:"peakMemoryBytes,omitempty"`
 }
 
 type FormatRequest struct {
@@ -41,6 +71,28 @@ type FormatResponse struct {
 	Formatted string `json:"formatted"`
 }
 
+type LintRequest struct {
+	Language Language `json:"language"`
+	Code     string   `json:"code"`
+}
+
+// Diagnostic is one problem a static check found in the code. Line and
+// Column are 1-based; Column is 0 when the checker did not report one.
+type Diagnostic struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"` // error, warning or info
+	Message  string `json:"message"`
+}
+
+// Diagnostics is the answer to a lint request, and the data of the
+// diagnostics frame sent to a room.
+type Diagnostics struct {
+	Language    Language     `json:"language"`
+	Version     int64        `json:"version,omitempty"` // document version linted, for frames
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
 /*** Collaboration session state ***/
 type DocState struct {
 	Text    string `json:"text"`
@@ -48,19 +100,100 @@ type DocState struct {
 }
 
 type WSFrame struct {
-	Type string      `json:"type"` // "init","edit","cursor","chat","run","language","stdout","stderr","exit","error","doc"
+	Type string      `json:"type"` // "init","resume","edit","cursor","chat","chat_reaction","chat_read","run","language","stdout","stderr","exit","error","doc","doc_delta","resync","mode_set","mode_confirm","turn_pass","turn_changed"
 	Data interface{} `json:"data"`
+
+	// Set on "error" frames only; Data carries the code too for older clients
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// ErrorResponse is the body of every collab HTTP error. Code is the stable
+// contract; Message is for display and Detail only set in debug mode.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+
+	Details []FieldError `json:"details,omitempty"` // per-field reasons of a validation error
+}
+
+// Readiness is the body of the readiness probe. Dependency names what is
+// not ready, e.g. "redis" or "question_service".
+type Readiness struct {
+	Status     string `json:"status"` // ready or not_ready
+	Dependency string `json:"dependency,omitempty"`
+	Detail     string `json:"detail,omitempty"`
 }
 
 type InitRequest struct {
 	SessionID string   `json:"sessionId"`
 	Language  Language `json:"language"` // current language tab
+
+	// Protocol version 2 lets clients ask for doc frame capabilities
+	// ("doc_binary", "doc_delta"); older clients get plain JSON doc frames
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+
+	// Resume is set on a "resume" frame, the init of a reconnecting client
+	Resume *ResumeMarkers `json:"resume,omitempty"`
+
+	// FreeForAll lets everyone edit instead of only the driver. The first
+	// init of a room decides.
+	FreeForAll bool `json:"freeForAll,omitempty"`
+}
+
+// ResumeMarkers tell what a reconnecting client already has. The answer to a
+// resume still carries the whole doc, which a client still at DocVersion can
+// keep its pending edits on; of the run output and chat only what came after
+// the markers is sent.
+type ResumeMarkers struct {
+	DocVersion int64 `json:"docVersion"` // last doc version acknowledged
+	RunSeq     int   `json:"runSeq"`     // run of the last run_reset seen
+	RunFrame   int   `json:"runFrame"`   // frames of that run seen, run_reset included
+	ChatID     int64 `json:"chatId"`     // last chat message seen
 }
 
 type InitResponse struct {
-	SessionID string   `json:"sessionId"`
-	Doc       DocState `json:"doc"`
-	Language  Language `json:"language"`
+	SessionID        string           `json:"sessionId"`
+	Doc              DocState         `json:"doc"`
+	Language         Language         `json:"language"`
+	AllowedLanguages []Language       `json:"allowedLanguages"`
+	Limits           *ExecutionLimits `json:"limits,omitempty"`
+	Settings         RoomSettings     `json:"settings"`
+	Presence         PresenceState    `json:"presence"`
+	Rubric           *RubricState     `json:"rubric,omitempty"`
+	Turns            TurnState        `json:"turns"`
+	Roles            Roles            `json:"roles"`
+	Threads          []Thread         `json:"threads,omitempty"`
+	// Spectator is set for a read-only connection, whose edits and runs are refused
+	Spectator bool `json:"spectator,omitempty"`
+	// Color is this client's cursor color; Cursors the others' last known cursors
+	Color   string   `json:"color,omitempty"`
+	Cursors []Cursor `json:"cursors,omitempty"`
+
+	// The protocol version in use and the capabilities granted to this client
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// DocDelta replaces "doc" for clients with the doc_delta capability. Applied
+// to the text at BaseVersion, it replaces the runes in [RangeStart, RangeEnd)
+// with Text, giving the document at Version whose SHA-256 is Hash. A client
+// that cannot apply it, or gets another hash, sends "resync".
+type DocDelta struct {
+	BaseVersion int64  `json:"baseVersion"`
+	Version     int64  `json:"version"`
+	RangeStart  int    `json:"rangeStart"`
+	RangeEnd    int    `json:"rangeEnd"`
+	Text        string `json:"text"`
+	Hash        string `json:"hash"`
+}
+
+// Resync asks for the full document, e.g. after a delta hash mismatch
+type Resync struct {
+	Reason string `json:"reason"` // "hash_mismatch" or "version_gap"
 }
 
 type Edit struct {
@@ -70,9 +203,14 @@ type Edit struct {
 	Text        string `json:"text"`
 }
 
+// Cursor is a user's caret and selection, as rune offsets into the document.
+// The server attributes it and assigns the color.
 type Cursor struct {
-	UserID string `json:"userId"`
-	Pos    int    `json:"pos"`
+	UserID   string `json:"userId"`
+	Pos      int    `json:"pos"`
+	SelStart int    `json:"selStart"`
+	SelEnd   int    `json:"selEnd"`
+	Color    string `json:"color,omitempty"`
 }
 
 type Chat struct {
@@ -80,10 +218,276 @@ type Chat struct {
 	Message string `json:"message"`
 }
 
+// ChatMessage is a chat message as stored by the room, with a server-assigned id.
+type ChatMessage struct {
+	ID        int64               `json:"id"`
+	UserID    string              `json:"userId"`
+	Message   string              `json:"message"`
+	SentAt    int64               `json:"sentAt"`              // unix millis
+	Truncated bool                `json:"truncated,omitempty"` // cut short to the length limit
+	Reactions map[string][]string `json:"reactions,omitempty"` // emoji -> user ids
+}
+
+type ChatReaction struct {
+	MessageID int64  `json:"messageId"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action"` // "add" or "remove"
+}
+
+// ChatReactionUpdate carries the full reaction set of a message after a change.
+type ChatReactionUpdate struct {
+	MessageID int64               `json:"messageId"`
+	Reactions map[string][]string `json:"reactions"`
+}
+
+type ChatRead struct {
+	UpToMessageID int64 `json:"upToMessageId"`
+}
+
+type ChatReadUpdate struct {
+	UserID        string `json:"userId"`
+	UpToMessageID int64  `json:"upToMessageId"`
+}
+
+// DocSnapshot is the authoritative document of a room as persisted, so a
+// room can be rebuilt after the collab instance holding it restarts.
+type DocSnapshot struct {
+	Text     string   `json:"text"`
+	Version  int64    `json:"version"`
+	Language Language `json:"language"`
+}
+
+// Snapshot is a copy of a room's document taken every few versions, kept so
+// users can go back to it. Timestamp is in unix millis.
+type Snapshot struct {
+	Version   int64    `json:"version"`
+	Text      string   `json:"text"`
+	Language  Language `json:"language"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// SnapshotRestore is the payload of a "restore_snapshot" frame.
+type SnapshotRestore struct {
+	Version int64 `json:"version"`
+}
+
+// Draft is a user's private recovery snapshot of the document. It is never
+// shared with the partner unless the owner restores it.
+type Draft struct {
+	MatchID  string   `json:"matchId"`
+	UserID   string   `json:"userId"`
+	Text     string   `json:"text"`
+	Language Language `json:"language"`
+	Cursor   int      `json:"cursor"`
+	Version  int64    `json:"version"` // doc version the snapshot was taken from
+	SavedAt  int64    `json:"savedAt"` // unix millis
+}
+
+// DraftSave optionally carries text the client has not synced yet; without it
+// the current shared document is snapshotted.
+type DraftSave struct {
+	Text   *string `json:"text,omitempty"`
+	Cursor *int    `json:"cursor,omitempty"`
+}
+
+type DraftRestoreRequest struct {
+	UserID  string `json:"userId"`
+	SavedAt int64  `json:"savedAt"`
+}
+
+type DraftRestoreConfirm struct {
+	Accept bool `json:"accept"`
+}
+
+// Room editing modes
+const (
+	ModeFree  = "free"  // both participants edit and run at will
+	ModeTurns = "turns" // only the active user edits and runs; the role rotates
+)
+
+// ModeSet asks to switch the room's editing mode; the partner confirms it
+// with a mode_confirm frame.
+type ModeSet struct {
+	Mode            string `json:"mode"`
+	TurnDurationSec int    `json:"turnDurationSec,omitempty"`
+}
+
+// ModeRequest announces a pending mode change to both participants.
+type ModeRequest struct {
+	UserID          string `json:"userId"`
+	Mode            string `json:"mode"`
+	TurnDurationSec int    `json:"turnDurationSec,omitempty"`
+}
+
+// RerollProposal announces that a participant wants another question; it
+// lapses at ExpiresAt (unix millis) unless the partner approves it.
+type RerollProposal struct {
+	UserID    string `json:"userId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// RerollVote is the partner's answer to a RerollProposal.
+type RerollVote struct {
+	Approve bool `json:"approve"`
+}
+
+// EndSessionRequest announces that a participant wants to end the session;
+// it lapses at ExpiresAt (unix millis) unless the partner confirms it.
+type EndSessionRequest struct {
+	UserID    string `json:"userId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type ModeConfirm struct {
+	Accept bool `json:"accept"`
+}
+
+// TurnState is the room's editing mode, sent on init and persisted so turns
+// survive reconnects and instance restarts. DrivingMs holds each user's time
+// as the active user over the completed turns.
+type TurnState struct {
+	Mode            string           `json:"mode"`
+	TurnDurationSec int              `json:"turnDurationSec,omitempty"`
+	Users           []string         `json:"users,omitempty"` // rotation order
+	ActiveUser      string           `json:"activeUser,omitempty"`
+	StartedAt       int64            `json:"startedAt,omitempty"` // unix ms, start of the current turn
+	EndsAt          int64            `json:"endsAt,omitempty"`    // unix ms
+	DrivingMs       map[string]int64 `json:"drivingMs,omitempty"`
+}
+
+// TurnChanged is broadcast when the active user changes. Reason is "start",
+// "timer", "pass" or "disconnect".
+type TurnChanged struct {
+	ActiveUser string `json:"activeUser"`
+	EndsAt     int64  `json:"endsAt"`
+	Reason     string `json:"reason"`
+}
+
+// Roles are the pair-programming roles of a room: only the driver may edit,
+// unless the room is free-for-all. Sent on init and as the roles frame.
+type Roles struct {
+	Driver     string `json:"driver,omitempty"`
+	Navigator  string `json:"navigator,omitempty"`
+	FreeForAll bool   `json:"freeForAll"`
+}
+
+// ControlRequest tells the driver that the navigator asked for control
+type ControlRequest struct {
+	UserID string `json:"userId"`
+}
+
+// ChatState is the persisted chat of a room, replayed to clients on (re)connect.
+type ChatState struct {
+	Messages  []ChatMessage    `json:"messages"`
+	ReadMarks map[string]int64 `json:"readMarks"` // user id -> highest message id seen
+}
+
+// RoomSettings are the editor settings both participants of a room share.
+type RoomSettings struct {
+	TabSize       int    `json:"tabSize"`
+	AutoRunOnSave bool   `json:"autoRunOnSave"`
+	Keymap        string `json:"keymap"`
+	Theme         string `json:"theme"`
+}
+
+// SettingsChanged is broadcast after a settings_update is applied.
+type SettingsChanged struct {
+	Settings  RoomSettings `json:"settings"`
+	Changed   []string     `json:"changed"`
+	UpdatedBy string       `json:"updatedBy"`
+}
+
+// InvalidSetting rejects a settings_update. Allowed lists the valid values of
+// Key, or the known keys when Key itself is unknown.
+type InvalidSetting struct {
+	Error   string `json:"error"`
+	Key     string `json:"key"`
+	Allowed []any  `json:"allowed"`
+}
+
 type RunCmd struct {
+	Language Language          `json:"language"`
+	Code     string            `json:"code"`
+	Stdin    string            `json:"stdin,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+}
+
+// FieldError is one rejected field of a request.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// InvalidInvocation rejects the args or env of a run, one entry per field.
+type InvalidInvocation struct {
+	Error   string       `json:"error"`
+	Details []FieldError `json:"details"`
+}
+
+// InteractiveStdin is input for the room's interactive run.
+type InteractiveStdin struct {
+	Data string `json:"data,omitempty"`
+	EOF  bool   `json:"eof,omitempty"`
+}
+
+// InteractiveInput echoes a participant's stdin to the rest of the room.
+type InteractiveInput struct {
+	UserID string `json:"userId,omitempty"`
+	Data   string `json:"data,omitempty"`
+	EOF    bool   `json:"eof,omitempty"`
+}
+
+// InteractiveStarted announces an interactive run to the room.
+type InteractiveStarted struct {
+	UserID   string   `json:"userId,omitempty"`
 	Language Language `json:"language"`
-	Code     string   `json:"code"`
-	Stdin    string   `json:"stdin,omitempty"`
+}
+
+// Typing is sent by a client to report whether its user is typing; the
+// server relays it to the partner with the user filled in.
+type Typing struct {
+	UserID string `json:"userId,omitempty"`
+	Active bool   `json:"active"`
+}
+
+// Run status states
+const (
+	RunQueued   = "queued"
+	RunStarted  = "started"
+	RunFinished = "finished"
+)
+
+// RunReset starts the output of run Seq, clearing that of the previous run.
+type RunReset struct {
+	Seq int `json:"seq"`
+}
+
+// RunCancelled is broadcast when a participant stops the room's run, ahead
+// of the run's cancelled exit.
+type RunCancelled struct {
+	By string `json:"by,omitempty"`
+}
+
+// RunStatus is broadcast by the server when a run starts and finishes.
+type RunStatus struct {
+	State string `json:"state"`
+	By    string `json:"by,omitempty"`
+}
+
+// PresenceState is the current typing and run state, sent on init so a
+// reconnecting client renders the indicators straight away.
+type PresenceState struct {
+	Connected []string   `json:"connected"` // users with a connection to the room
+	Typing    []string   `json:"typing"`    // users currently shown as typing
+	Run       *RunStatus `json:"run,omitempty"`
+}
+
+// PresenceUpdate is broadcast as a "presence" frame whenever a user connects
+// to or disconnects from the room. At is unix millis.
+type PresenceUpdate struct {
+	Connected []string `json:"connected"`
+	At        int64    `json:"at"`
 }
 
 type LanguageChange struct {
@@ -103,6 +507,18 @@ type RoomInfo struct {
 	CreatedAt        string    `json:"createdAt"`
 	Token1           string    `json:"token1,omitempty"`
 	Token2           string    `json:"token2,omitempty"`
+	// QuestionSince is when the room got its current question, RFC 3339
+	QuestionSince string `json:"questionSince,omitempty"`
+	// Spectators is how many spectators are watching the room on this instance
+	Spectators int `json:"spectators"`
+	// Occupancy is who is connected to the room; only room status answers carry it
+	Occupancy *Occupancy `json:"occupancy,omitempty"`
+}
+
+// Occupancy lists the participants connected to a room, on any instance
+type Occupancy struct {
+	Connected []string `json:"connected"`
+	Count     int      `json:"count"`
 }
 
 type QuestionUpdate struct {
@@ -120,6 +536,32 @@ type Question struct {
 	Constraints    string     `json:"constraints,omitempty"`
 	TestCases      []TestCase `json:"test_cases,omitempty"`
 	ImageURLs      []string   `json:"image_urls,omitempty"`
+
+	Execution *ExecutionConfig `json:"execution,omitempty"`
+	Rubric    []string         `json:"rubric,omitempty"`
+	// StarterCode is the code a room starts from, by language; languages
+	// without one start from the language's example template
+	StarterCode map[Language]string `json:"starterCode,omitempty"`
+}
+
+// ExecutionConfig is the optional per-question run metadata validated by the question service.
+// Args and Env apply to every run in the room and override the user's.
+type ExecutionConfig struct {
+	AllowedLanguages []Language        `json:"allowedLanguages,omitempty"`
+	Limits           *ExecutionLimits  `json:"limits,omitempty"`
+	Args             []string          `json:"args,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+}
+
+type ExecutionLimits struct {
+	WallTimeMs int64 `json:"wallTimeMs,omitempty"`
+	MemoryMb   int64 `json:"memoryMb,omitempty"`
+}
+
+// Constraints is pushed to clients whenever the room's question (and thus its run constraints) changes.
+type Constraints struct {
+	AllowedLanguages []Language       `json:"allowedLanguages"`
+	Limits           *ExecutionLimits `json:"limits,omitempty"`
 }
 
 type TestCase struct {
@@ -128,6 +570,148 @@ type TestCase struct {
 	Description string `json:"description,omitempty"`
 }
 
+// RunTestsCmd is the payload of a "run_tests" frame and the body of the
+// run-tests endpoint.
+type RunTestsCmd struct {
+	Language Language `json:"language"`
+	Code     string   `json:"code"`
+}
+
+// TestCaseResult is the outcome of one of the question's examples. Diff lists
+// the lines where the program's output differs from the expected one.
+type TestCaseResult struct {
+	Index    int    `json:"index"`
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Stderr   string `json:"stderr,omitempty"`
+	Exit     int    `json:"exit"`
+	TimedOut bool   `json:"timedOut,omitempty"`
+	Passed   bool   `json:"passed"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+// TestResults is broadcast in "test_results" frames. When the program does
+// not compile, CompileOutput is set and no case ran.
+type TestResults struct {
+	By            string           `json:"by"`
+	Language      Language         `json:"language"`
+	Passed        int              `json:"passed"`
+	Total         int              `json:"total"`
+	Cases         []TestCaseResult `json:"cases"`
+	CompileOutput string           `json:"compileOutput,omitempty"`
+	// set when a participant cancelled the tests before every case ran
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// RubricItem is one entry of a question's rubric as ticked off in a room.
+// ToggledBy and ToggledAt (unix millis) record the last change.
+type RubricItem struct {
+	Text      string `json:"text"`
+	Checked   bool   `json:"checked"`
+	ToggledBy string `json:"toggledBy,omitempty"`
+	ToggledAt int64  `json:"toggledAt,omitempty"`
+}
+
+// RubricState is broadcast in "rubric" frames and included in init.
+// Unchecked is informational; ending a session with open items is allowed.
+type RubricState struct {
+	Items     []RubricItem `json:"items"`
+	Unchecked int          `json:"unchecked"`
+}
+
+// RubricCheck is the payload of a "rubric_check" frame.
+type RubricCheck struct {
+	Index   int  `json:"index"`
+	Checked bool `json:"checked"`
+}
+
+// Thread authors the server posts replies as, besides participant user ids.
+const (
+	ThreadAuthorAssistant = "assistant"
+	ThreadAuthorSystem    = "system"
+)
+
+// Thread is a question discussion in a room. Times are unix millis.
+type Thread struct {
+	ID         int64         `json:"id"`
+	Title      string        `json:"title"`
+	Body       string        `json:"body"`
+	Author     string        `json:"author"`
+	CreatedAt  int64         `json:"createdAt"`
+	Resolved   bool          `json:"resolved"`
+	ResolvedBy string        `json:"resolvedBy,omitempty"`
+	ResolvedAt int64         `json:"resolvedAt,omitempty"`
+	Replies    []ThreadReply `json:"replies"`
+}
+
+// ThreadReply is one message of a thread. AskAI marks a participant's reply
+// that asked the assistant to answer.
+type ThreadReply struct {
+	ID     int64  `json:"id"`
+	Author string `json:"author"`
+	Body   string `json:"body"`
+	SentAt int64  `json:"sentAt"`
+	AskAI  bool   `json:"askAI,omitempty"`
+}
+
+// ThreadCreate is the payload of a "thread_create" frame.
+type ThreadCreate struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// ThreadReplyCmd is the payload of a "thread_reply" frame.
+type ThreadReplyCmd struct {
+	ThreadID int64  `json:"threadId"`
+	Body     string `json:"body"`
+	AskAI    bool   `json:"askAI"`
+}
+
+// ThreadResolve is the payload of a "thread_resolve" frame.
+type ThreadResolve struct {
+	ThreadID int64 `json:"threadId"`
+}
+
+// ThreadReplyAdded is broadcast for every new reply, including the ones the
+// assistant and the server post.
+type ThreadReplyAdded struct {
+	ThreadID int64       `json:"threadId"`
+	Reply    ThreadReply `json:"reply"`
+}
+
+// ThreadResolved is broadcast when a thread is closed.
+type ThreadResolved struct {
+	ThreadID   int64  `json:"threadId"`
+	ResolvedBy string `json:"resolvedBy"`
+	ResolvedAt int64  `json:"resolvedAt"`
+}
+
+// ThreadState is the persisted discussion of a room. AIRepliesUsed counts
+// the room's hint budget spent on assistant replies.
+type ThreadState struct {
+	Threads       []Thread `json:"threads"`
+	AIRepliesUsed int      `json:"aiRepliesUsed"`
+}
+
+// ThreadSummary describes a room's threads in the session record without
+// their text.
+type ThreadSummary struct {
+	Total      int             `json:"total"`
+	Resolved   int             `json:"resolved"`
+	Unresolved int             `json:"unresolved"`
+	Replies    int             `json:"replies"`
+	AIReplies  int             `json:"aiReplies"`
+	Threads    []ThreadOutcome `json:"threads"`
+}
+
+// ThreadOutcome is one thread of a ThreadSummary.
+type ThreadOutcome struct {
+	ID       int64 `json:"id"`
+	Resolved bool  `json:"resolved"`
+	Replies  int   `json:"replies"`
+}
+
 // SessionEndedEvent is published when a session ends
 type SessionEndedEvent struct {
 	MatchID       string `json:"matchId"`
@@ -145,4 +729,137 @@ type SessionEndedEvent struct {
 	EndedAt       string `json:"endedAt"`
 	DurationSec   int    `json:"durationSeconds"`
 	RerollsUsed   int    `json:"rerollsUsed"`
+
+	RubricResults []RubricItem `json:"rubricResults,omitempty"`
+	// Seconds each user spent as the active user in turn-taking mode
+	DrivingSec map[string]int `json:"drivingSec,omitempty"`
+	// Counts of the question discussion threads; never their text
+	Threads *ThreadSummary `json:"threads,omitempty"`
+}
+
+// Outcomes of a session with a question, reported to the question service
+// for difficulty calibration
+const (
+	OutcomeCompleted = "completed" // the pair ended the session on the question
+	OutcomeAbandoned = "abandoned" // the session ended some other way
+	OutcomeRerolled  = "rerolled"  // the pair rerolled away from the question
+)
+
+// SessionResult is how a session went with one question
+type SessionResult struct {
+	MatchID     string `json:"matchId"`
+	Outcome     string `json:"outcome"`
+	DurationSec int    `json:"durationSec"` // time on the question, until the end or the reroll
+}
+
+// SessionSummary is what is kept of an ended session for the history page.
+// Tokens are the room tokens that may still read it; they are never returned.
+type SessionSummary struct {
+	SessionEndedEvent
+	Tokens []string `json:"tokens,omitempty"`
+}
+
+// SessionRecord is the compact entry of an ended session kept in each
+// participant's list of recent sessions.
+type SessionRecord struct {
+	MatchID       string `json:"matchId"`
+	QuestionTitle string `json:"questionTitle"`
+	Language      string `json:"language"`
+	DurationSec   int    `json:"durationSeconds"`
+	PartnerID     string `json:"partnerId"`
+	EndedAt       string `json:"endedAt"` // RFC 3339
+}
+
+// PeerDisconnected tells the room a participant dropped out. Unless they
+// rejoin by RejoinBy (unix millis), the session ends as partner_abandoned;
+// with no grace period GraceMs and RejoinBy are 0 and it stays open.
+type PeerDisconnected struct {
+	UserID   string `json:"userId"`
+	GraceMs  int64  `json:"graceMs"`
+	RejoinBy int64  `json:"rejoinBy,omitempty"`
+}
+
+// Hint is the AI service's answer to a hint_request, sent to the whole room.
+type Hint struct {
+	RequestedBy string `json:"requestedBy"`
+	QuestionID  int    `json:"questionId"`
+	Hint        string `json:"hint"`
+	Remaining   int    `json:"remaining"` // hints left on this question
+}
+
+// ComplexityVerdict is the structured Big-O analysis returned by the AI service.
+type ComplexityVerdict struct {
+	TimeComplexity     string              `json:"timeComplexity"`
+	SpaceComplexity    string              `json:"spaceComplexity"`
+	DominantOperations []DominantOperation `json:"dominantOperations"`
+	Confidence         float64             `json:"confidence"`
+}
+
+type DominantOperation struct {
+	Description string `json:"description"`
+	Complexity  string `json:"complexity"`
+}
+
+// HubDebug is the admin debug dump of one instance's hub. Rooms are
+// snapshotted one at a time, so each room is consistent in itself but rooms
+// may be a few microseconds apart. Document text, chat and tokens are never
+// included.
+type HubDebug struct {
+	InstanceID   string      `json:"instanceId"`
+	TotalRooms   int         `json:"totalRooms"`
+	TotalClients int         `json:"totalClients"`
+	Goroutines   int         `json:"goroutines"`
+	TakenAt      int64       `json:"takenAt"` // unix millis
+	Rooms        []RoomDebug `json:"rooms"`
+}
+
+type RoomDebug struct {
+	MatchID      string        `json:"matchId"`
+	InstanceID   string        `json:"instanceId"`
+	ClientCount  int           `json:"clientCount"`
+	Clients      []ClientDebug `json:"clients"`
+	DocVersion   int64         `json:"docVersion"`
+	DocBytes     int           `json:"docBytes"`
+	Language     Language      `json:"language"`
+	Run          RunDebug      `json:"run"`
+	Timer        TimerDebug    `json:"timer"`
+	SessionEnded bool          `json:"sessionEnded"`
+	StartedAt    int64         `json:"startedAt"`    // unix millis
+	LastActivity int64         `json:"lastActivity"` // unix millis
+}
+
+type ClientDebug struct {
+	UserID          string `json:"userId"`
+	ConnectedAt     int64  `json:"connectedAt"` // unix millis
+	ConnectedForSec int64  `json:"connectedForSec"`
+}
+
+// RunDebug describes the room's batch run and interactive run. Interactive is
+// empty, "starting" or "running".
+type RunDebug struct {
+	Active      bool   `json:"active"`
+	Frames      int    `json:"frames"`
+	Interactive string `json:"interactive,omitempty"`
+}
+
+// TimerDebug is the session-end countdown armed when every client has left.
+type TimerDebug struct {
+	SessionEndPending bool   `json:"sessionEndPending"`
+	DisconnectedAt    *int64 `json:"disconnectedAt,omitempty"` // unix millis
+}
+
+// RoomDebugDetail adds the room's recent activity to its debug entry.
+type RoomDebugDetail struct {
+	RoomDebug
+	Activity []ActivityEntry `json:"activity"`
+}
+
+// ActivityEntry is one event in a room's activity log. Consecutive events of
+// the same type by the same user are folded into one entry with a count.
+type ActivityEntry struct {
+	At     int64  `json:"at"` // unix millis, of the latest folded event
+	Type   string `json:"type"`
+	UserID string `json:"userId,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Count  int    `json:"count"`
 }
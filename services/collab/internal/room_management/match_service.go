@@ -1,19 +1,24 @@
 package room_management
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"collab/internal/metrics"
 	"collab/internal/models"
 	"collab/internal/utils"
 
@@ -26,24 +31,66 @@ type RoomManager struct {
 	pubClient     *redis.Client
 	subClient     *redis.Client
 	questionURL   string
+	questionKey   string       // sent as X-API-Key to the question service
+	httpClient    *http.Client // shared by every call to the question service
 	roomStatusMap map[string]*models.RoomInfo
 	mu            sync.RWMutex
 	instanceID    string
 	ctx           context.Context
 
+	matchesSubscribed atomic.Bool // SubscribeToMatches is receiving match events
+
+	// occupied is the occupancy this instance published, per room, which
+	// KeepOccupancyAlive refreshes
+	occupied map[string][]byte
+	occMu    sync.Mutex
+
+	// questionRetryDelay is the wait before the second try to fetch a room's
+	// question; it doubles after every failed try
+	questionRetryDelay time.Duration
+
 	// Callback for room update events (set by handlers)
 	onRoomUpdate func(matchId string, roomInfo *models.RoomInfo)
 }
 
 const (
 	maxFetchAttempts = 5
+
+	// questionFetchAttempts bounds the tries to fetch a new room's question;
+	// with the delay doubling from defaultQuestionRetryDelay they span 30s
+	questionFetchAttempts     = 5
+	defaultQuestionRetryDelay = 2 * time.Second
+
+	// questionTimeout bounds one call to the question service, reading the
+	// response included
+	questionTimeout = 5 * time.Second
 )
 
 var (
 	ErrNoRerolls             = errors.New("no rerolls remaining")
 	ErrNoAlternativeQuestion = errors.New("no alternative question available")
+	ErrRoomNotInError        = errors.New("room is not in error")
+	// ErrQuestionServiceTimeout wraps the error of a question service call
+	// that ran out of time
+	ErrQuestionServiceTimeout = errors.New("question service timed out")
 )
 
+// Dependencies named by a DependencyError
+const (
+	DependencyRedis    = "redis"
+	DependencyMatches  = "match_subscription"
+	DependencyQuestion = "question_service"
+)
+
+// DependencyError reports which dependency keeps the service from being ready
+type DependencyError struct {
+	Dependency string
+	Err        error
+}
+
+func (e *DependencyError) Error() string { return e.Dependency + ": " + e.Err.Error() }
+func (e *DependencyError) Unwrap() error { return e.Err }
+
 func NewRoomManager(redisAddr, questionURL string) *RoomManager {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
@@ -59,9 +106,12 @@ func NewRoomManager(redisAddr, questionURL string) *RoomManager {
 		pubClient:     rdb,
 		subClient:     subClient,
 		questionURL:   questionURL,
+		httpClient:    newQuestionClient(),
 		roomStatusMap: make(map[string]*models.RoomInfo),
 		instanceID:    uuid.New().String()[:8], // Short instance ID for logging
 		ctx:           context.Background(),
+
+		questionRetryDelay: defaultQuestionRetryDelay,
 	}
 
 	log.Printf("[RoomManager %s] Initialized", rm.instanceID)
@@ -69,12 +119,30 @@ func NewRoomManager(redisAddr, questionURL string) *RoomManager {
 	return rm
 }
 
+// newQuestionClient returns the client for the question service, which keeps
+// a few connections to it open between calls
+func newQuestionClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 20
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ResponseHeaderTimeout = questionTimeout
+	return &http.Client{Timeout: questionTimeout, Transport: transport}
+}
+
+// SetQuestionAPIKey sets the key used to authenticate to the question service
+func (rm *RoomManager) SetQuestionAPIKey(key string) {
+	rm.questionKey = key
+}
+
 func (rm *RoomManager) GetInstanceID() string {
 	return rm.instanceID
 }
 
-// SetRoomUpdateCallback sets the callback for when room updates are received
-// This is typically called by the handlers to broadcast updates to WebSocket clients
+// SetRoomUpdateCallback sets the callback for room updates, which the handlers
+// use to broadcast them to WebSocket clients. Every instance gets each update
+// exactly once: the instance that made the change calls it directly and
+// ignores its own published event, the others call it from the event.
 func (rm *RoomManager) SetRoomUpdateCallback(callback func(matchId string, roomInfo *models.RoomInfo)) {
 	rm.onRoomUpdate = callback
 }
@@ -84,6 +152,33 @@ func (rm *RoomManager) GetRedisClient() *redis.Client {
 	return rm.rdb
 }
 
+// Ready checks that the service can take traffic: Redis answers, match
+// events are being received and the question service is up. The error is a
+// *DependencyError naming the first dependency that is not.
+func (rm *RoomManager) Ready(ctx context.Context) error {
+	if err := rm.rdb.Ping(ctx).Err(); err != nil {
+		return &DependencyError{Dependency: DependencyRedis, Err: err}
+	}
+	if !rm.matchesSubscribed.Load() {
+		return &DependencyError{Dependency: DependencyMatches, Err: errors.New("not subscribed to match events")}
+	}
+
+	healthURL := strings.TrimRight(rm.questionURL, "/") + "/api/v1/questions/healthz"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return &DependencyError{Dependency: DependencyQuestion, Err: err}
+	}
+	resp, err := rm.httpClient.Do(req)
+	if err != nil {
+		return &DependencyError{Dependency: DependencyQuestion, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &DependencyError{Dependency: DependencyQuestion, Err: fmt.Errorf("health check returned status %d", resp.StatusCode)}
+	}
+	return nil
+}
+
 // SubscribeToMatches listens for match events until the provided context is cancelled
 func (rm *RoomManager) SubscribeToMatches(ctx context.Context) {
 	if ctx == nil {
@@ -91,6 +186,24 @@ func (rm *RoomManager) SubscribeToMatches(ctx context.Context) {
 	}
 	subscriber := rm.rdb.Subscribe(ctx, "matches")
 	defer subscriber.Close()
+	// Wait until Redis confirms the subscription before reporting ready
+	for {
+		_, err := subscriber.Receive(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[RoomManager %s] Match subscription failed, retrying: %v", rm.instanceID, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	rm.matchesSubscribed.Store(true)
+	defer rm.matchesSubscribed.Store(false)
 	ch := subscriber.Channel()
 
 	log.Printf("[RoomManager %s] Subscribed to match events", rm.instanceID)
@@ -179,6 +292,7 @@ func (rm *RoomManager) handleRoomUpdateEvent(payload string) {
 // Process a match event by fetching question and creating room
 func (rm *RoomManager) processMatchEvent(event models.RoomInfo) {
 	ctx := context.Background()
+	received := time.Now()
 
 	roomInfo := &models.RoomInfo{
 		MatchId:          event.MatchId,
@@ -201,37 +315,128 @@ func (rm *RoomManager) processMatchEvent(event models.RoomInfo) {
 
 	log.Printf("[RoomManager %s] Processing match %s", rm.instanceID, event.MatchId)
 
-	question, err := rm.fetchQuestion(event.Category, event.Difficulty)
+	rm.loadQuestion(roomInfo, received)
+}
+
+// loadQuestion fetches the question of a room in processing, retrying while
+// the question service fails, and marks the room ready with it or, once the
+// tries run out, error. Clients already in the room get the question as soon
+// as it is in.
+func (rm *RoomManager) loadQuestion(roomInfo *models.RoomInfo, received time.Time) {
+	ctx := context.Background()
+	rm.mu.RLock()
+	matchID, category, difficulty := roomInfo.MatchId, roomInfo.Category, roomInfo.Difficulty
+	rm.mu.RUnlock()
+
+	question, err := rm.fetchQuestionWithRetries(rm.ctx, matchID, category, difficulty)
 	if err != nil {
 		log.Printf("[RoomManager %s] Failed to fetch question for match %s: %v",
-			rm.instanceID, event.MatchId, err)
+			rm.instanceID, matchID, err)
 		rm.mu.Lock()
 		roomInfo.Status = "error"
 		rm.mu.Unlock()
 		rm.updateRoomStatusInRedis(ctx, roomInfo)
+		metrics.ObserveRoomSetup("error", time.Since(received))
 		return
 	}
 
 	rm.mu.Lock()
 	roomInfo.Question = question
+	roomInfo.QuestionSince = time.Now().Format(time.RFC3339)
 	roomInfo.Status = "ready"
+	updatedCopy := cloneRoomInfo(roomInfo)
 	rm.mu.Unlock()
 
 	rm.updateRoomStatusInRedis(ctx, roomInfo)
+	metrics.ObserveRoomSetup("ready", time.Since(received))
 
-	// Publish room update event
-	rm.publishRoomUpdate(event.MatchId, roomInfo)
+	// Our own clients get the update here, not from the published event
+	if rm.onRoomUpdate != nil {
+		rm.onRoomUpdate(matchID, updatedCopy)
+	}
+	rm.publishRoomUpdate(matchID, updatedCopy)
 
 	log.Printf("[RoomManager %s] Room %s is ready with question %d",
-		rm.instanceID, event.MatchId, question.ID)
+		rm.instanceID, matchID, question.ID)
 }
 
-// Fetch a random question from the question service
-func (rm *RoomManager) fetchQuestion(category string, difficulty string) (*models.Question, error) {
-	return rm.fetchQuestionWithFallback(category, difficulty, true)
+// fetchQuestionWithRetries makes up to questionFetchAttempts tries to fetch
+// a question, doubling the wait between them, and returns the last error
+// when none succeeds.
+func (rm *RoomManager) fetchQuestionWithRetries(ctx context.Context, matchID, category, difficulty string) (*models.Question, error) {
+	delay := rm.questionRetryDelay
+	for attempt := 1; ; attempt++ {
+		question, err := rm.fetchQuestion(ctx, matchID, category, difficulty)
+		if err == nil || attempt == questionFetchAttempts {
+			return question, err
+		}
+		log.Printf("[RoomManager %s] Question fetch %d/%d for match %s failed, retrying in %s: %v",
+			rm.instanceID, attempt, questionFetchAttempts, matchID, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+		delay *= 2
+	}
 }
 
-func (rm *RoomManager) fetchQuestionWithFallback(category, difficulty string, allowFallback bool) (*models.Question, error) {
+// RetryQuestion fetches the question of a room whose setup failed once more,
+// in the background and with the same retries as a new room. The room is in
+// processing again until it gets its question or the retries run out; the
+// returned copy shows it so.
+func (rm *RoomManager) RetryQuestion(matchID string) (*models.RoomInfo, error) {
+	roomInfo, err := rm.cachedRoom(matchID)
+	if err != nil {
+		return nil, err
+	}
+	rm.mu.Lock()
+	if roomInfo.Status != "error" {
+		rm.mu.Unlock()
+		return nil, ErrRoomNotInError
+	}
+	roomInfo.Status = "processing"
+	updatedCopy := cloneRoomInfo(roomInfo)
+	rm.mu.Unlock()
+
+	rm.updateRoomStatusInRedis(context.Background(), roomInfo)
+	log.Printf("[RoomManager %s] Retrying question for match %s", rm.instanceID, matchID)
+	go rm.loadQuestion(roomInfo, time.Now())
+	return updatedCopy, nil
+}
+
+// cachedRoom returns the room this instance keeps the status of, loading it
+// from Redis the first time
+func (rm *RoomManager) cachedRoom(matchID string) (*models.RoomInfo, error) {
+	rm.mu.Lock()
+	roomInfo, exists := rm.roomStatusMap[matchID]
+	rm.mu.Unlock()
+	if exists {
+		return roomInfo, nil
+	}
+
+	loaded, err := rm.fetchRoomStatusFromRedis(matchID)
+	if err != nil {
+		return nil, err
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if roomInfo, exists = rm.roomStatusMap[matchID]; !exists {
+		rm.roomStatusMap[matchID] = loaded
+		roomInfo = loaded
+	}
+	return roomInfo, nil
+}
+
+// Fetch a random question from the question service. The match ID seeds any
+// generated examples, so both participants (and rerolls back to the same
+// question) see the same example values. A call that takes longer than
+// questionTimeout fails with ErrQuestionServiceTimeout.
+func (rm *RoomManager) fetchQuestion(ctx context.Context, matchID, category, difficulty string) (*models.Question, error) {
+	return rm.fetchQuestionWithFallback(ctx, matchID, category, difficulty, true)
+}
+
+func (rm *RoomManager) fetchQuestionWithFallback(ctx context.Context, matchID, category, difficulty string, allowFallback bool) (*models.Question, error) {
 	base := strings.TrimRight(rm.questionURL, "/")
 	queryURL := fmt.Sprintf("%s/api/v1/questions/random", base)
 
@@ -242,20 +447,30 @@ func (rm *RoomManager) fetchQuestionWithFallback(category, difficulty string, al
 	if category != "" {
 		params.Set("topic", category)
 	}
+	if matchID != "" {
+		params.Set("seed", matchID)
+	}
 	if encoded := params.Encode(); encoded != "" {
 		queryURL = queryURL + "?" + encoded
 	}
 
-	resp, err := http.Get(queryURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, queryURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call question service: %w", err)
+		return nil, fmt.Errorf("failed to build question request: %w", err)
+	}
+	if rm.questionKey != "" {
+		req.Header.Set("X-API-Key", rm.questionKey)
+	}
+	resp, err := rm.httpClient.Do(req)
+	if err != nil {
+		return nil, questionServiceError("failed to call question service", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound && allowFallback && category != "" {
 		log.Printf("[RoomManager %s] No question for category=%s difficulty=%s, retrying without category filter",
 			rm.instanceID, category, difficulty)
-		return rm.fetchQuestionWithFallback("", difficulty, false)
+		return rm.fetchQuestionWithFallback(ctx, matchID, "", difficulty, false)
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -269,15 +484,25 @@ func (rm *RoomManager) fetchQuestionWithFallback(category, difficulty string, al
 
 	var question models.Question
 	if err := json.NewDecoder(resp.Body).Decode(&question); err != nil {
-		return nil, fmt.Errorf("failed to decode question response: %w", err)
+		return nil, questionServiceError("failed to decode question response", err)
 	}
 
 	return &question, nil
 }
 
-func (rm *RoomManager) fetchAlternativeQuestion(category string, difficulty string, currentID int) (*models.Question, error) {
+// questionServiceError wraps err with what failed, marking it with
+// ErrQuestionServiceTimeout as well when the call ran out of time
+func questionServiceError(what string, err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%s: %w: %w", what, ErrQuestionServiceTimeout, err)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+func (rm *RoomManager) fetchAlternativeQuestion(ctx context.Context, matchID, category, difficulty string, currentID int) (*models.Question, error) {
 	for i := 0; i < maxFetchAttempts; i++ {
-		question, err := rm.fetchQuestion(category, difficulty)
+		question, err := rm.fetchQuestion(ctx, matchID, category, difficulty)
 		if err != nil {
 			return nil, err
 		}
@@ -311,6 +536,7 @@ func (rm *RoomManager) updateRoomStatusInRedis(ctx context.Context, roomInfo *mo
 		"question":         questionJSON,
 		"rerollsRemaining": roomInfo.RerollsRemaining,
 		"createdAt":        roomInfo.CreatedAt,
+		"questionSince":    roomInfo.QuestionSince,
 	})
 
 	rm.rdb.Expire(ctx, roomKey, 24*time.Hour)
@@ -389,6 +615,7 @@ func (rm *RoomManager) fetchRoomStatusFromRedis(matchId string) (*models.RoomInf
 		Status:           roomMap["status"],
 		RerollsRemaining: 0,
 		CreatedAt:        roomMap["createdAt"],
+		QuestionSince:    roomMap["questionSince"],
 		Token1:           roomMap["token1"],
 		Token2:           roomMap["token2"],
 	}
@@ -427,23 +654,9 @@ func cloneRoomInfo(src *models.RoomInfo) *models.RoomInfo {
 func (rm *RoomManager) RerollQuestion(matchId string) (*models.RoomInfo, error) {
 	log.Printf("[RoomManager %s] Reroll requested for match %s", rm.instanceID, matchId)
 
-	rm.mu.Lock()
-	roomInfo, exists := rm.roomStatusMap[matchId]
-	rm.mu.Unlock()
-
-	if !exists {
-		loaded, err := rm.fetchRoomStatusFromRedis(matchId)
-		if err != nil {
-			return nil, err
-		}
-
-		rm.mu.Lock()
-		roomInfo, exists = rm.roomStatusMap[matchId]
-		if !exists {
-			rm.roomStatusMap[matchId] = loaded
-			roomInfo = loaded
-		}
-		rm.mu.Unlock()
+	roomInfo, err := rm.cachedRoom(matchId)
+	if err != nil {
+		return nil, err
 	}
 
 	rm.mu.Lock()
@@ -460,7 +673,7 @@ func (rm *RoomManager) RerollQuestion(matchId string) (*models.RoomInfo, error)
 	}
 	rm.mu.Unlock()
 
-	question, err := rm.fetchAlternativeQuestion(category, difficulty, currentQuestionID)
+	question, err := rm.fetchAlternativeQuestion(rm.ctx, matchId, category, difficulty, currentQuestionID)
 	if err != nil {
 		rm.mu.Lock()
 		roomInfo.RerollsRemaining++
@@ -471,11 +684,20 @@ func (rm *RoomManager) RerollQuestion(matchId string) (*models.RoomInfo, error)
 	}
 
 	rm.mu.Lock()
+	previous := cloneRoomInfo(roomInfo)
 	roomInfo.Question = question
+	roomInfo.QuestionSince = time.Now().Format(time.RFC3339)
 	roomInfo.Status = "ready"
 	updatedCopy := cloneRoomInfo(roomInfo)
 	rm.mu.Unlock()
 
+	go func() {
+		if err := rm.ReportSessionResult(previous, models.OutcomeRerolled); err != nil {
+			log.Printf("[RoomManager %s] Failed to report reroll of room %s: %v", rm.instanceID, matchId, err)
+		}
+	}()
+
+	// Our own clients get the update here, not from the published event
 	if rm.onRoomUpdate != nil {
 		rm.onRoomUpdate(matchId, updatedCopy)
 	}
@@ -492,6 +714,48 @@ func (rm *RoomManager) RerollQuestion(matchId string) (*models.RoomInfo, error)
 	return updatedCopy, nil
 }
 
+// ReportSessionResult tells the question service how the session went with
+// the room's current question, timed from when the room got it, for the
+// difficulty calibration. Rooms without a question are not reported.
+func (rm *RoomManager) ReportSessionResult(roomInfo *models.RoomInfo, outcome string) error {
+	if roomInfo == nil || roomInfo.Question == nil {
+		return nil
+	}
+	result := models.SessionResult{MatchID: roomInfo.MatchId, Outcome: outcome}
+	since := roomInfo.QuestionSince
+	if since == "" {
+		since = roomInfo.CreatedAt
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		result.DurationSec = max(int(time.Since(t).Seconds()), 0)
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode session result: %w", err)
+	}
+
+	resultURL := fmt.Sprintf("%s/api/v1/questions/%d/session-results", strings.TrimRight(rm.questionURL, "/"), roomInfo.Question.ID)
+	ctx, cancel := context.WithTimeout(rm.ctx, questionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resultURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build session result request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rm.questionKey != "" {
+		req.Header.Set("X-API-Key", rm.questionKey)
+	}
+	resp, err := rm.httpClient.Do(req)
+	if err != nil {
+		return questionServiceError("failed to report session result", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("question service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // ValidateRoomAccess validates if a user can access a room using their token
 func (rm *RoomManager) ValidateRoomAccess(token string) (*models.RoomInfo, error) {
 	claims, err := utils.ValidateRoomToken(token)
@@ -499,6 +763,10 @@ func (rm *RoomManager) ValidateRoomAccess(token string) (*models.RoomInfo, error
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 
+	if claims.IsSpectator() {
+		return nil, fmt.Errorf("spectator token does not grant participant access")
+	}
+
 	roomInfo, err := rm.GetRoomStatus(claims.MatchId)
 	if err != nil {
 		return nil, fmt.Errorf("room not found: %w", err)
@@ -511,6 +779,26 @@ func (rm *RoomManager) ValidateRoomAccess(token string) (*models.RoomInfo, error
 	return roomInfo, nil
 }
 
+// ValidateSpectatorAccess validates a spectator token and returns the room it
+// lets its holder watch, along with the spectator's user ID
+func (rm *RoomManager) ValidateSpectatorAccess(token string) (*models.RoomInfo, string, error) {
+	claims, err := utils.ValidateRoomToken(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid token: %w", err)
+	}
+
+	if !claims.IsSpectator() {
+		return nil, "", fmt.Errorf("not a spectator token")
+	}
+
+	roomInfo, err := rm.GetRoomStatus(claims.MatchId)
+	if err != nil {
+		return nil, "", fmt.Errorf("room not found: %w", err)
+	}
+
+	return roomInfo, claims.UserId, nil
+}
+
 // PublishSessionEnded publishes a session ended event to Redis
 func (rm *RoomManager) PublishSessionEnded(event models.SessionEndedEvent) error {
 	ctx := context.Background()
@@ -529,6 +817,97 @@ func (rm *RoomManager) PublishSessionEnded(event models.SessionEndedEvent) error
 	return nil
 }
 
+// sessionSummaryKey holds what is kept of an ended session, outside the room:* namespace
+func sessionSummaryKey(matchID string) string { return "session_summary:" + matchID }
+
+// sessionSummaryTTL is how long an ended session's summary is kept
+const sessionSummaryTTL = 7 * 24 * time.Hour
+
+// SaveSessionSummary keeps the summary of an ended session for sessionSummaryTTL
+func (rm *RoomManager) SaveSessionSummary(summary models.SessionSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode session summary: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), sessionSummaryKey(summary.MatchID), data, sessionSummaryTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save session summary: %w", err)
+	}
+	return nil
+}
+
+// LoadSessionSummary returns the summary of an ended session, or nil if there is none
+func (rm *RoomManager) LoadSessionSummary(matchID string) (*models.SessionSummary, error) {
+	data, err := rm.rdb.Get(context.Background(), sessionSummaryKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session summary: %w", err)
+	}
+	var summary models.SessionSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("failed to decode session summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// userSessionsKey is a sorted set of a user's ended sessions, scored by when
+// they ended
+func userSessionsKey(userID string) string { return "user_sessions:" + userID }
+
+const (
+	// maxUserSessions is how many sessions a user keeps; older ones are dropped
+	maxUserSessions = 50
+	// userSessionsTTL drops the list of a user who has not had a session in a while
+	userSessionsTTL = 30 * 24 * time.Hour
+)
+
+// RecordUserSession adds an ended session to the recent sessions of userID,
+// keeping the latest maxUserSessions
+func (rm *RoomManager) RecordUserSession(userID string, record models.SessionRecord) error {
+	endedAt, err := time.Parse(time.RFC3339, record.EndedAt)
+	if err != nil {
+		return fmt.Errorf("invalid session end time: %w", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode session record: %w", err)
+	}
+	ctx := context.Background()
+	key := userSessionsKey(userID)
+	_, err = rm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(endedAt.Unix()), Member: data})
+		pipe.ZRemRangeByRank(ctx, key, 0, -maxUserSessions-1)
+		pipe.Expire(ctx, key, userSessionsTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record user session: %w", err)
+	}
+	return nil
+}
+
+// RecentSessions returns up to limit of the sessions userID took part in,
+// newest first
+func (rm *RoomManager) RecentSessions(userID string, limit int) ([]models.SessionRecord, error) {
+	if limit <= 0 {
+		return []models.SessionRecord{}, nil
+	}
+	items, err := rm.rdb.ZRevRange(context.Background(), userSessionsKey(userID), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %w", err)
+	}
+	records := make([]models.SessionRecord, 0, len(items))
+	for _, item := range items {
+		var record models.SessionRecord
+		if err := json.Unmarshal([]byte(item), &record); err != nil {
+			return nil, fmt.Errorf("failed to decode session record: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
 // GetRoomInfoForSession retrieves room information for a session
 func (rm *RoomManager) GetRoomInfoForSession(matchID string) (*models.RoomInfo, error) {
 	return rm.GetRoomStatus(matchID)
@@ -584,6 +963,405 @@ func (rm *RoomManager) MarkRoomAsEnded(matchID string) error {
 	return nil
 }
 
+// chatKey holds a room's chat snapshot. It lives outside the room:* namespace
+// so room scans only ever see room hashes.
+func chatKey(matchID string) string { return "chat:" + matchID }
+
+// SaveChatState persists the room's chat (messages, reactions and read marks)
+// so it survives reconnects and instance changes
+func (rm *RoomManager) SaveChatState(matchID string, state models.ChatState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode chat state: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), chatKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save chat state: %w", err)
+	}
+	return nil
+}
+
+// LoadChatState returns the persisted chat of a room, or nil if there is none
+func (rm *RoomManager) LoadChatState(matchID string) (*models.ChatState, error) {
+	data, err := rm.rdb.Get(context.Background(), chatKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat state: %w", err)
+	}
+	var state models.ChatState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode chat state: %w", err)
+	}
+	return &state, nil
+}
+
+// settingsKey holds a room's shared editor settings, outside the room:* namespace
+func settingsKey(matchID string) string { return "settings:" + matchID }
+
+// SaveRoomSettings persists the room's shared settings so they survive
+// reconnects and instance restarts
+func (rm *RoomManager) SaveRoomSettings(matchID string, settings models.RoomSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode room settings: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), settingsKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save room settings: %w", err)
+	}
+	return nil
+}
+
+// LoadRoomSettings returns the persisted settings of a room, or nil if there are none
+func (rm *RoomManager) LoadRoomSettings(matchID string) (*models.RoomSettings, error) {
+	data, err := rm.rdb.Get(context.Background(), settingsKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room settings: %w", err)
+	}
+	var settings models.RoomSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode room settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// rubricKey holds a room's rubric checklist, outside the room:* namespace
+func rubricKey(matchID string) string { return "rubric:" + matchID }
+
+// SaveRubricState persists the room's rubric ticks so they survive reconnects
+func (rm *RoomManager) SaveRubricState(matchID string, items []models.RubricItem) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("failed to encode rubric state: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), rubricKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save rubric state: %w", err)
+	}
+	return nil
+}
+
+// LoadRubricState returns the persisted rubric of a room, or nil if there is none
+func (rm *RoomManager) LoadRubricState(matchID string) ([]models.RubricItem, error) {
+	data, err := rm.rdb.Get(context.Background(), rubricKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rubric state: %w", err)
+	}
+	var items []models.RubricItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("failed to decode rubric state: %w", err)
+	}
+	return items, nil
+}
+
+// threadsKey holds a room's discussion threads, outside the room:* namespace
+func threadsKey(matchID string) string { return "threads:" + matchID }
+
+// SaveThreadState persists the room's threads and assistant budget spend so
+// they survive reconnects and instance restarts
+func (rm *RoomManager) SaveThreadState(matchID string, state models.ThreadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode thread state: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), threadsKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save thread state: %w", err)
+	}
+	return nil
+}
+
+// LoadThreadState returns the persisted threads of a room, or nil if there are none
+func (rm *RoomManager) LoadThreadState(matchID string) (*models.ThreadState, error) {
+	data, err := rm.rdb.Get(context.Background(), threadsKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread state: %w", err)
+	}
+	var state models.ThreadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode thread state: %w", err)
+	}
+	return &state, nil
+}
+
+// turnsKey holds a room's turn-taking state, outside the room:* namespace
+func turnsKey(matchID string) string { return "turns:" + matchID }
+
+// SaveTurnState persists the room's editing mode and turn so they survive
+// reconnects and instance restarts
+func (rm *RoomManager) SaveTurnState(matchID string, state models.TurnState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode turn state: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), turnsKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save turn state: %w", err)
+	}
+	return nil
+}
+
+// LoadTurnState returns the persisted turn state of a room, or nil if there is none
+func (rm *RoomManager) LoadTurnState(matchID string) (*models.TurnState, error) {
+	data, err := rm.rdb.Get(context.Background(), turnsKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load turn state: %w", err)
+	}
+	var state models.TurnState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode turn state: %w", err)
+	}
+	return &state, nil
+}
+
+// docKey holds a room's shared document, outside the room:* namespace
+func docKey(matchID string) string { return "doc:" + matchID }
+
+// SaveDoc persists the room's document so it survives instance restarts
+func (rm *RoomManager) SaveDoc(matchID string, doc models.DocSnapshot) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	if err := rm.rdb.Set(context.Background(), docKey(matchID), data, 24*time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save document: %w", err)
+	}
+	return nil
+}
+
+// LoadDoc returns the persisted document of a room, or nil if there is none
+func (rm *RoomManager) LoadDoc(matchID string) (*models.DocSnapshot, error) {
+	data, err := rm.rdb.Get(context.Background(), docKey(matchID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document: %w", err)
+	}
+	var doc models.DocSnapshot
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return &doc, nil
+}
+
+// snapshotsKey holds a room's recent document snapshots, oldest first,
+// outside the room:* namespace
+func snapshotsKey(matchID string) string { return "snapshots:" + matchID }
+
+// maxSnapshots is how many snapshots a room keeps; older ones are dropped
+const maxSnapshots = 20
+
+// AddSnapshot appends a snapshot of the room's document, keeping the last
+// maxSnapshots
+func (rm *RoomManager) AddSnapshot(matchID string, snap models.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	ctx := context.Background()
+	key := snapshotsKey(matchID)
+	_, err = rm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -maxSnapshots, -1)
+		pipe.Expire(ctx, key, 24*time.Hour)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// ListSnapshots returns the kept snapshots of a room, oldest first
+func (rm *RoomManager) ListSnapshots(matchID string) ([]models.Snapshot, error) {
+	items, err := rm.rdb.LRange(context.Background(), snapshotsKey(matchID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshots: %w", err)
+	}
+	snapshots := make([]models.Snapshot, 0, len(items))
+	for _, item := range items {
+		var snap models.Snapshot
+		if err := json.Unmarshal([]byte(item), &snap); err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, nil
+}
+
+// draftKey holds one participant's recovery draft, outside the room:* namespace
+func draftKey(matchID, userID string) string { return "draft:" + matchID + ":" + userID }
+
+// draftGrace is how long a draft outlives its room
+const draftGrace = 24 * time.Hour
+
+// SaveDraft stores a participant's recovery draft. It expires draftGrace after
+// the room itself would, so it remains available after the session ends.
+func (rm *RoomManager) SaveDraft(draft models.Draft) error {
+	ctx := context.Background()
+	data, err := json.Marshal(draft)
+	if err != nil {
+		return fmt.Errorf("failed to encode draft: %w", err)
+	}
+	roomTTL, err := rm.rdb.TTL(ctx, fmt.Sprintf("room:%s", draft.MatchID)).Result()
+	if err != nil || roomTTL < 0 {
+		roomTTL = 24 * time.Hour
+	}
+	if err := rm.rdb.Set(ctx, draftKey(draft.MatchID, draft.UserID), data, roomTTL+draftGrace).Err(); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	return nil
+}
+
+// LoadDraft returns a participant's recovery draft, or nil if there is none
+func (rm *RoomManager) LoadDraft(matchID, userID string) (*models.Draft, error) {
+	data, err := rm.rdb.Get(context.Background(), draftKey(matchID, userID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load draft: %w", err)
+	}
+	var draft models.Draft
+	if err := json.Unmarshal(data, &draft); err != nil {
+		return nil, fmt.Errorf("failed to decode draft: %w", err)
+	}
+	return &draft, nil
+}
+
+const (
+	// occupancyTTL is how long an instance's occupancy outlives its last
+	// heartbeat, so the users of an instance that went away drop out
+	occupancyTTL       = 60 * time.Second
+	occupancyHeartbeat = occupancyTTL / 3
+)
+
+// occupancyKey holds the users connected to a room on one instance
+func occupancyKey(matchID, instanceID string) string {
+	return "occupancy:" + matchID + ":" + instanceID
+}
+
+// occupancyInstancesKey lists the instances that published occupancy for a
+// room; instances whose occupancy expired are pruned on read
+func occupancyInstancesKey(matchID string) string { return "occupancy:" + matchID }
+
+// SetOccupancy records the users connected to a room on this instance, so
+// that other instances can tell who is in the room. An empty list drops this
+// instance's entry. KeepOccupancyAlive refreshes the entry until then.
+func (rm *RoomManager) SetOccupancy(matchID string, userIDs []string) error {
+	rm.occMu.Lock()
+	defer rm.occMu.Unlock()
+	if len(userIDs) == 0 {
+		delete(rm.occupied, matchID)
+		ctx := context.Background()
+		_, err := rm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, occupancyKey(matchID, rm.instanceID))
+			pipe.SRem(ctx, occupancyInstancesKey(matchID), rm.instanceID)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clear occupancy: %w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode occupancy: %w", err)
+	}
+	if rm.occupied == nil {
+		rm.occupied = make(map[string][]byte)
+	}
+	rm.occupied[matchID] = data
+	if err := rm.saveOccupancyLocked(matchID, data); err != nil {
+		return fmt.Errorf("failed to save occupancy: %w", err)
+	}
+	return nil
+}
+
+func (rm *RoomManager) saveOccupancyLocked(matchID string, data []byte) error {
+	ctx := context.Background()
+	_, err := rm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, occupancyKey(matchID, rm.instanceID), data, occupancyTTL)
+		pipe.SAdd(ctx, occupancyInstancesKey(matchID), rm.instanceID)
+		pipe.Expire(ctx, occupancyInstancesKey(matchID), 24*time.Hour)
+		return nil
+	})
+	return err
+}
+
+// KeepOccupancyAlive refreshes this instance's occupancy entries until ctx is
+// cancelled
+func (rm *RoomManager) KeepOccupancyAlive(ctx context.Context) {
+	ticker := time.NewTicker(occupancyHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rm.refreshOccupancy()
+		}
+	}
+}
+
+func (rm *RoomManager) refreshOccupancy() {
+	rm.occMu.Lock()
+	defer rm.occMu.Unlock()
+	for matchID, data := range rm.occupied {
+		if err := rm.saveOccupancyLocked(matchID, data); err != nil {
+			log.Printf("[RoomManager %s] Failed to refresh occupancy of %s: %v", rm.instanceID, matchID, err)
+		}
+	}
+}
+
+// RemoteOccupancy returns the users connected to a room on the other
+// instances, sorted and each once. This instance's users come from its hub.
+func (rm *RoomManager) RemoteOccupancy(matchID string) ([]string, error) {
+	ctx := context.Background()
+	instances, err := rm.rdb.SMembers(ctx, occupancyInstancesKey(matchID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load occupancy: %w", err)
+	}
+	seen := make(map[string]bool)
+	connected := []string{}
+	for _, instance := range instances {
+		if instance == rm.instanceID {
+			continue
+		}
+		data, err := rm.rdb.Get(ctx, occupancyKey(matchID, instance)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// The instance stopped refreshing its entry
+			rm.rdb.SRem(ctx, occupancyInstancesKey(matchID), instance)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load occupancy: %w", err)
+		}
+		var userIDs []string
+		if err := json.Unmarshal(data, &userIDs); err != nil {
+			continue
+		}
+		for _, id := range userIDs {
+			if !seen[id] {
+				seen[id] = true
+				connected = append(connected, id)
+			}
+		}
+	}
+	sort.Strings(connected)
+	return connected, nil
+}
+
 // Cleanup closes Redis connections
 func (rm *RoomManager) Cleanup() {
 	rm.subClient.Close()
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -13,6 +15,7 @@ import (
 	miniredis "github.com/alicebob/miniredis/v2"
 	"github.com/golang-jwt/jwt/v5"
 
+	"collab/internal/metrics"
 	"collab/internal/models"
 	"collab/internal/utils"
 )
@@ -36,6 +39,7 @@ func setupRoomManager(t *testing.T, questionHandler http.HandlerFunc) (*RoomMana
 	}
 
 	manager := NewRoomManager(mr.Addr(), questionURL)
+	manager.questionRetryDelay = time.Millisecond
 	t.Cleanup(func() {
 		manager.Cleanup()
 		_ = manager.rdb.Close()
@@ -229,6 +233,47 @@ func TestHandleMatchPayloadValid(t *testing.T) {
 	})
 }
 
+// setupCount scrapes how many room setups finished with outcome.
+func setupCount(t *testing.T, outcome string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	prefix := `collab_room_setup_duration_seconds_count{outcome="` + outcome + `"} `
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, prefix); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("bad sample %q: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestProcessMatchEventRecordsSetupDuration(t *testing.T) {
+	fail := false
+	manager, _, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.Question{ID: 7})
+	})
+	ready, failed := setupCount(t, "ready"), setupCount(t, "error")
+
+	manager.processMatchEvent(models.RoomInfo{MatchId: "m1", Difficulty: "easy"})
+	fail = true
+	manager.processMatchEvent(models.RoomInfo{MatchId: "m2", Difficulty: "easy"})
+
+	if got := setupCount(t, "ready") - ready; got != 1 {
+		t.Fatalf("expected one ready setup, got %v", got)
+	}
+	if got := setupCount(t, "error") - failed; got != 1 {
+		t.Fatalf("expected one failed setup, got %v", got)
+	}
+}
+
 func TestProcessMatchEventFetchError(t *testing.T) {
 	manager, _, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "nope", http.StatusInternalServerError)
@@ -244,15 +289,104 @@ func TestProcessMatchEventFetchError(t *testing.T) {
 	}
 }
 
+// flakyQuestionServer fails the next `failures` calls with 503 and serves
+// question 7 after that
+func flakyQuestionServer(calls *atomic.Int32, failures *atomic.Int32, onCall func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if onCall != nil {
+			onCall()
+		}
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.Question{ID: 7, Title: "Two Sum"})
+	}
+}
+
+func TestProcessMatchEventRetriesQuestion(t *testing.T) {
+	var calls, failures atomic.Int32
+	failures.Store(2)
+	var manager *RoomManager
+	var statuses []string
+	manager, _, _ = setupRoomManager(t, flakyQuestionServer(&calls, &failures, func() {
+		manager.mu.RLock()
+		statuses = append(statuses, manager.roomStatusMap["m1"].Status)
+		manager.mu.RUnlock()
+	}))
+	updates := make(chan *models.RoomInfo, 1)
+	manager.SetRoomUpdateCallback(func(_ string, info *models.RoomInfo) { updates <- info })
+
+	manager.processMatchEvent(models.RoomInfo{MatchId: "m1", User1: "u1", User2: "u2", Difficulty: "easy"})
+
+	if calls.Load() != 3 || strings.Join(statuses, ",") != "processing,processing,processing" {
+		t.Fatalf("expected the room to stay in processing over 3 tries, got %d calls and %v", calls.Load(), statuses)
+	}
+	info, err := manager.GetRoomStatus("m1")
+	if err != nil || info.Status != "ready" || info.Question == nil || info.Question.ID != 7 {
+		t.Fatalf("expected the room ready with its question, got %#v err=%v", info, err)
+	}
+	select {
+	case update := <-updates:
+		if update.Question == nil || update.Question.ID != 7 {
+			t.Fatalf("expected the question in the room update, got %#v", update)
+		}
+	default:
+		t.Fatal("expected this instance's clients to be sent the question")
+	}
+}
+
+func TestRetryQuestion(t *testing.T) {
+	var calls, failures atomic.Int32
+	failures.Store(questionFetchAttempts)
+	manager, mr, _ := setupRoomManager(t, flakyQuestionServer(&calls, &failures, nil))
+	updates := make(chan *models.RoomInfo, 1)
+	manager.SetRoomUpdateCallback(func(_ string, info *models.RoomInfo) { updates <- info })
+
+	manager.processMatchEvent(models.RoomInfo{MatchId: "m1", User1: "u1", User2: "u2", Difficulty: "easy"})
+	if calls.Load() != questionFetchAttempts || mr.HGet("room:m1", "status") != "error" {
+		t.Fatalf("expected the room in error after %d tries, got %d calls and status %q", questionFetchAttempts, calls.Load(), mr.HGet("room:m1", "status"))
+	}
+
+	// The question service comes back after two more failures
+	failures.Store(2)
+	info, err := manager.RetryQuestion("m1")
+	if err != nil || info.Status != "processing" {
+		t.Fatalf("expected the room back in processing, got %#v err=%v", info, err)
+	}
+	select {
+	case update := <-updates:
+		if update.Status != "ready" || update.Question == nil || update.Question.ID != 7 {
+			t.Fatalf("unexpected room update %#v", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the question once the retry succeeded")
+	}
+	if got := calls.Load() - questionFetchAttempts; got != 3 {
+		t.Fatalf("expected 3 more tries, got %d", got)
+	}
+	if status := mr.HGet("room:m1", "status"); status != "ready" {
+		t.Fatalf("expected the room ready in Redis, got %q", status)
+	}
+
+	if _, err := manager.RetryQuestion("m1"); !errors.Is(err, ErrRoomNotInError) {
+		t.Fatalf("expected ErrRoomNotInError for a ready room, got %v", err)
+	}
+	if _, err := manager.RetryQuestion("missing"); err == nil {
+		t.Fatal("expected an error for an unknown room")
+	}
+}
+
 func TestFetchQuestion(t *testing.T) {
 	manager, _, server := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("difficulty") != "easy" || r.URL.Query().Get("topic") != "graphs" {
+		if r.URL.Query().Get("difficulty") != "easy" || r.URL.Query().Get("topic") != "graphs" || r.URL.Query().Get("seed") != "m1" {
 			t.Fatalf("unexpected query: %s", r.URL.RawQuery)
 		}
 		_ = json.NewEncoder(w).Encode(models.Question{ID: 7})
 	})
 
-	q, err := manager.fetchQuestion("graphs", "easy")
+	q, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy")
 	if err != nil || q.ID != 7 {
 		t.Fatalf("unexpected question: %#v err=%v", q, err)
 	}
@@ -260,18 +394,37 @@ func TestFetchQuestion(t *testing.T) {
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing", http.StatusNotFound)
 	})
-	if _, err := manager.fetchQuestion("graphs", "easy"); err == nil {
+	if _, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy"); err == nil {
 		t.Fatalf("expected error when service returns non-200")
 	}
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("{invalid"))
 	})
-	if _, err := manager.fetchQuestion("graphs", "easy"); err == nil {
+	if _, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy"); err == nil {
 		t.Fatalf("expected decode error")
 	}
 }
 
+func TestFetchQuestionSendsAPIKey(t *testing.T) {
+	var got []string
+	manager, _, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-API-Key"))
+		_ = json.NewEncoder(w).Encode(models.Question{ID: 7})
+	})
+
+	if _, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy"); err != nil {
+		t.Fatalf("fetchQuestion error: %v", err)
+	}
+	manager.SetQuestionAPIKey("collab-secret")
+	if _, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy"); err != nil {
+		t.Fatalf("fetchQuestion error: %v", err)
+	}
+	if len(got) != 2 || got[0] != "" || got[1] != "collab-secret" {
+		t.Fatalf("unexpected api key headers: %q", got)
+	}
+}
+
 func TestFetchQuestionFallsBackToDifficultyOnly(t *testing.T) {
 	var calls atomic.Int32
 	manager, _, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
@@ -287,10 +440,13 @@ func TestFetchQuestionFallsBackToDifficultyOnly(t *testing.T) {
 		if topic != "" {
 			t.Fatalf("expected fallback request without topic filter, got %q", topic)
 		}
+		if seed := r.URL.Query().Get("seed"); seed != "m1" {
+			t.Fatalf("expected fallback request to keep the seed, got %q", seed)
+		}
 		_ = json.NewEncoder(w).Encode(models.Question{ID: 99})
 	})
 
-	q, err := manager.fetchQuestion("graphs", "easy")
+	q, err := manager.fetchQuestion(context.Background(), "m1", "graphs", "easy")
 	if err != nil {
 		t.Fatalf("expected fallback to succeed, got error %v", err)
 	}
@@ -305,7 +461,7 @@ func TestFetchQuestionFallsBackToDifficultyOnly(t *testing.T) {
 func TestFetchQuestionNetworkError(t *testing.T) {
 	manager := NewRoomManager("localhost:0", "http://127.0.0.1:0")
 	defer manager.Cleanup()
-	if _, err := manager.fetchQuestion("cat", "easy"); err == nil {
+	if _, err := manager.fetchQuestion(context.Background(), "m1", "cat", "easy"); err == nil {
 		t.Fatalf("expected network error")
 	}
 }
@@ -320,7 +476,7 @@ func TestFetchAlternativeQuestion(t *testing.T) {
 		_ = json.NewEncoder(w).Encode(models.Question{ID: int(id)})
 	})
 
-	q, err := manager.fetchAlternativeQuestion("cat", "hard", 3)
+	q, err := manager.fetchAlternativeQuestion(context.Background(), "m1", "cat", "hard", 3)
 	if err != nil || q.ID == 3 {
 		t.Fatalf("expected different question, got %#v err=%v", q, err)
 	}
@@ -329,14 +485,14 @@ func TestFetchAlternativeQuestion(t *testing.T) {
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_ = json.NewEncoder(w).Encode(models.Question{ID: 5})
 	})
-	if _, err := manager.fetchAlternativeQuestion("cat", "hard", 5); !errors.Is(err, ErrNoAlternativeQuestion) {
+	if _, err := manager.fetchAlternativeQuestion(context.Background(), "m1", "cat", "hard", 5); !errors.Is(err, ErrNoAlternativeQuestion) {
 		t.Fatalf("expected ErrNoAlternativeQuestion, got %v", err)
 	}
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad", http.StatusInternalServerError)
 	})
-	if _, err := manager.fetchAlternativeQuestion("cat", "hard", 0); err == nil {
+	if _, err := manager.fetchAlternativeQuestion(context.Background(), "m1", "cat", "hard", 0); err == nil {
 		t.Fatalf("expected error when fetchQuestion fails")
 	}
 }
@@ -483,6 +639,108 @@ func TestRerollQuestionSuccessAndErrors(t *testing.T) {
 	}
 }
 
+func TestReportSessionResult(t *testing.T) {
+	type request struct {
+		path, key string
+		result    models.SessionResult
+	}
+	requests := make(chan request, 2)
+	manager, _, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(models.Question{ID: 99})
+			return
+		}
+		var result models.SessionResult
+		_ = json.NewDecoder(r.Body).Decode(&result)
+		requests <- request{r.URL.Path, r.Header.Get("X-API-Key"), result}
+		w.WriteHeader(http.StatusCreated)
+	})
+	manager.SetQuestionAPIKey("collab-secret")
+
+	since := time.Now().Add(-90 * time.Second).Format(time.RFC3339)
+	info := &models.RoomInfo{MatchId: "m", Question: &models.Question{ID: 1}, QuestionSince: since, RerollsRemaining: 1, Status: "ready"}
+	if err := manager.ReportSessionResult(info, models.OutcomeCompleted); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	got := <-requests
+	if got.path != "/api/v1/questions/1/session-results" || got.key != "collab-secret" ||
+		got.result.MatchID != "m" || got.result.Outcome != models.OutcomeCompleted || got.result.DurationSec < 90 {
+		t.Fatalf("unexpected session result request: %+v", got)
+	}
+
+	// An approved reroll reports the question the pair moved away from
+	manager.roomStatusMap["m"] = info
+	if _, err := manager.RerollQuestion("m"); err != nil {
+		t.Fatalf("reroll: %v", err)
+	}
+	select {
+	case got := <-requests:
+		if got.path != "/api/v1/questions/1/session-results" || got.result.Outcome != models.OutcomeRerolled {
+			t.Fatalf("unexpected reroll result request: %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the reroll to be reported")
+	}
+	if info.QuestionSince == since {
+		t.Fatal("expected the new question to be timed from the reroll")
+	}
+
+	if err := manager.ReportSessionResult(&models.RoomInfo{MatchId: "q"}, models.OutcomeAbandoned); err != nil {
+		t.Fatalf("expected a room without a question to be skipped, got %v", err)
+	}
+}
+
+// slowQuestion never answers; it returns once the caller gives up
+func slowQuestion(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+func TestFetchQuestionTimesOut(t *testing.T) {
+	manager, _, _ := setupRoomManager(t, slowQuestion)
+	manager.httpClient.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	_, err := manager.fetchQuestion(context.Background(), "m1", "cat", "easy")
+	if !errors.Is(err, ErrQuestionServiceTimeout) {
+		t.Fatalf("expected ErrQuestionServiceTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+}
+
+func TestFetchQuestionCanBeCancelled(t *testing.T) {
+	manager, _, _ := setupRoomManager(t, slowQuestion)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := manager.fetchQuestion(ctx, "m1", "cat", "easy")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if errors.Is(err, ErrQuestionServiceTimeout) {
+		t.Fatalf("a cancelled call is not a timeout: %v", err)
+	}
+}
+
+func TestRerollQuestionTimesOut(t *testing.T) {
+	manager, _, _ := setupRoomManager(t, slowQuestion)
+	manager.httpClient.Timeout = 20 * time.Millisecond
+	manager.roomStatusMap["m"] = &models.RoomInfo{
+		MatchId:          "m",
+		RerollsRemaining: 1,
+		Question:         &models.Question{ID: 1},
+		Status:           "ready",
+	}
+
+	if _, err := manager.RerollQuestion("m"); !errors.Is(err, ErrQuestionServiceTimeout) {
+		t.Fatalf("expected ErrQuestionServiceTimeout, got %v", err)
+	}
+	if got := manager.roomStatusMap["m"].RerollsRemaining; got != 1 {
+		t.Fatalf("expected the reroll to be given back, have %d", got)
+	}
+}
+
 func TestRerollQuestionMissingRoom(t *testing.T) {
 	manager, _, _ := setupRoomManager(t, nil)
 	if _, err := manager.RerollQuestion("missing"); err == nil {
@@ -586,6 +844,37 @@ func TestValidateRoomAccess(t *testing.T) {
 	}
 }
 
+func TestValidateSpectatorAccess(t *testing.T) {
+	manager, _, _ := setupRoomManager(t, nil)
+	manager.roomStatusMap["match"] = &models.RoomInfo{MatchId: "match", User1: "u1", User2: "u2"}
+	sign := func(claims *utils.RoomTokenClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("your-secret-key"))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return token
+	}
+
+	spectator := sign(&utils.RoomTokenClaims{MatchId: "match", UserId: "mentor", Role: utils.RoleSpectator})
+	info, userID, err := manager.ValidateSpectatorAccess(spectator)
+	if err != nil || info.MatchId != "match" || userID != "mentor" {
+		t.Fatalf("unexpected spectator validation: %#v %q err=%v", info, userID, err)
+	}
+	if _, err := manager.ValidateRoomAccess(spectator); err == nil {
+		t.Fatalf("a spectator token must not grant participant access")
+	}
+
+	// Even a participant's own spectator token only lets them watch
+	if _, err := manager.ValidateRoomAccess(sign(&utils.RoomTokenClaims{MatchId: "match", UserId: "u1", Role: utils.RoleSpectator})); err == nil {
+		t.Fatalf("expected a spectator token of a participant to be refused participant access")
+	}
+
+	participant := sign(&utils.RoomTokenClaims{MatchId: "match", UserId: "u1"})
+	if _, _, err := manager.ValidateSpectatorAccess(participant); err == nil {
+		t.Fatalf("expected a participant token to be refused as a spectator token")
+	}
+}
+
 func TestValidateRoomAccessMissingRoom(t *testing.T) {
 	manager, _, _ := setupRoomManager(t, nil)
 	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &utils.RoomTokenClaims{MatchId: "missing", UserId: "u"}).SignedString([]byte("your-secret-key"))
@@ -735,3 +1024,336 @@ func waitUntil(t *testing.T, timeout time.Duration, cond func() bool) {
 	}
 	t.Fatalf("condition not met within %s", timeout)
 }
+
+func TestChatStateRoundTrip(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	state, err := manager.LoadChatState("room1")
+	if err != nil || state != nil {
+		t.Fatalf("expected no chat state yet, got %#v, %v", state, err)
+	}
+
+	saved := models.ChatState{
+		Messages: []models.ChatMessage{
+			{ID: 1, UserID: "u1", Message: "run it now?", Reactions: map[string][]string{"👍": {"u2"}}},
+		},
+		ReadMarks: map[string]int64{"u2": 1},
+	}
+	if err := manager.SaveChatState("room1", saved); err != nil {
+		t.Fatalf("SaveChatState error: %v", err)
+	}
+	if ttl := mr.TTL("chat:room1"); ttl <= 0 {
+		t.Fatalf("expected chat state to expire, got ttl %v", ttl)
+	}
+
+	state, err = manager.LoadChatState("room1")
+	if err != nil || state == nil {
+		t.Fatalf("LoadChatState error: %v", err)
+	}
+	if len(state.Messages) != 1 || state.Messages[0].Reactions["👍"][0] != "u2" || state.ReadMarks["u2"] != 1 {
+		t.Fatalf("unexpected chat state: %#v", state)
+	}
+}
+
+func TestRoomSettingsRoundTrip(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	settings, err := manager.LoadRoomSettings("room1")
+	if err != nil || settings != nil {
+		t.Fatalf("expected no settings yet, got %#v, %v", settings, err)
+	}
+
+	saved := models.RoomSettings{TabSize: 2, AutoRunOnSave: true, Keymap: "vim", Theme: "dark"}
+	if err := manager.SaveRoomSettings("room1", saved); err != nil {
+		t.Fatalf("SaveRoomSettings error: %v", err)
+	}
+	if ttl := mr.TTL("settings:room1"); ttl <= 0 {
+		t.Fatalf("expected settings to expire, got ttl %v", ttl)
+	}
+
+	settings, err = manager.LoadRoomSettings("room1")
+	if err != nil || settings == nil || *settings != saved {
+		t.Fatalf("unexpected settings: %#v, %v", settings, err)
+	}
+}
+
+func TestDocRoundTrip(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	doc, err := manager.LoadDoc("room1")
+	if err != nil || doc != nil {
+		t.Fatalf("expected no document yet, got %#v, %v", doc, err)
+	}
+
+	saved := models.DocSnapshot{Text: "print(1)", Version: 7, Language: models.LangJava}
+	if err := manager.SaveDoc("room1", saved); err != nil {
+		t.Fatalf("SaveDoc error: %v", err)
+	}
+	if ttl := mr.TTL("doc:room1"); ttl != 24*time.Hour {
+		t.Fatalf("expected the document to expire with the room, got ttl %v", ttl)
+	}
+
+	doc, err = manager.LoadDoc("room1")
+	if err != nil || doc == nil || *doc != saved {
+		t.Fatalf("unexpected document: %#v, %v", doc, err)
+	}
+}
+
+func TestSnapshotsKeepTheLatest(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	snapshots, err := manager.ListSnapshots("room1")
+	if err != nil || len(snapshots) != 0 {
+		t.Fatalf("expected no snapshots yet, got %#v, %v", snapshots, err)
+	}
+
+	for v := int64(1); v <= maxSnapshots+5; v++ {
+		snap := models.Snapshot{Version: v * 50, Text: strconv.FormatInt(v, 10), Language: models.LangGo, Timestamp: v}
+		if err := manager.AddSnapshot("room1", snap); err != nil {
+			t.Fatalf("AddSnapshot error: %v", err)
+		}
+	}
+	if ttl := mr.TTL("snapshots:room1"); ttl != 24*time.Hour {
+		t.Fatalf("expected the snapshots to expire with the room, got ttl %v", ttl)
+	}
+
+	snapshots, err = manager.ListSnapshots("room1")
+	if err != nil || len(snapshots) != maxSnapshots {
+		t.Fatalf("expected %d snapshots, got %d, %v", maxSnapshots, len(snapshots), err)
+	}
+	first, last := snapshots[0], snapshots[maxSnapshots-1]
+	if first.Version != 6*50 || last.Version != (maxSnapshots+5)*50 || last.Text != "25" || last.Language != models.LangGo {
+		t.Fatalf("expected the latest snapshots oldest first, got %+v .. %+v", first, last)
+	}
+}
+
+func TestSessionSummaryRoundTrip(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	summary, err := manager.LoadSessionSummary("room1")
+	if err != nil || summary != nil {
+		t.Fatalf("expected no summary yet, got %#v, %v", summary, err)
+	}
+
+	saved := models.SessionSummary{
+		SessionEndedEvent: models.SessionEndedEvent{MatchID: "room1", User1: "u1", User2: "u2", FinalCode: "print(1)", EndedAt: "2025-01-01T00:00:00Z"},
+		Tokens:            []string{"tok1", "tok2"},
+	}
+	if err := manager.SaveSessionSummary(saved); err != nil {
+		t.Fatalf("SaveSessionSummary error: %v", err)
+	}
+	if ttl := mr.TTL("session_summary:room1"); ttl != 7*24*time.Hour {
+		t.Fatalf("expected the summary to be kept for a week, got ttl %v", ttl)
+	}
+
+	summary, err = manager.LoadSessionSummary("room1")
+	if err != nil || summary == nil || summary.FinalCode != "print(1)" || len(summary.Tokens) != 2 {
+		t.Fatalf("unexpected summary: %#v, %v", summary, err)
+	}
+}
+
+func TestUserSessionsKeepTheLatest(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+
+	records, err := manager.RecentSessions("u1", 10)
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no sessions yet, got %#v, %v", records, err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Recorded out of order: the end time decides the order, not the insert
+	for _, i := range []int{2, 1} {
+		record := models.SessionRecord{
+			MatchID:   "m" + strconv.Itoa(i),
+			PartnerID: "u2",
+			EndedAt:   start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+		}
+		if err := manager.RecordUserSession("u1", record); err != nil {
+			t.Fatalf("RecordUserSession error: %v", err)
+		}
+	}
+	records, err = manager.RecentSessions("u1", 10)
+	if err != nil || len(records) != 2 || records[0].MatchID != "m2" || records[1].MatchID != "m1" {
+		t.Fatalf("expected the sessions newest first, got %#v, %v", records, err)
+	}
+	if ttl := mr.TTL("user_sessions:u1"); ttl != 30*24*time.Hour {
+		t.Fatalf("expected the sessions to expire after 30 days, got ttl %v", ttl)
+	}
+
+	for i := 3; i <= maxUserSessions+5; i++ {
+		record := models.SessionRecord{MatchID: "m" + strconv.Itoa(i), EndedAt: start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)}
+		if err := manager.RecordUserSession("u1", record); err != nil {
+			t.Fatalf("RecordUserSession error: %v", err)
+		}
+	}
+	if n, _ := mr.ZMembers("user_sessions:u1"); len(n) != maxUserSessions {
+		t.Fatalf("expected %d sessions kept, got %d", maxUserSessions, len(n))
+	}
+	records, err = manager.RecentSessions("u1", 100)
+	if err != nil || len(records) != maxUserSessions {
+		t.Fatalf("expected %d sessions, got %d, %v", maxUserSessions, len(records), err)
+	}
+	if first, last := records[0], records[maxUserSessions-1]; first.MatchID != "m55" || last.MatchID != "m6" {
+		t.Fatalf("expected the latest sessions newest first, got %+v .. %+v", first, last)
+	}
+	if records, _ := manager.RecentSessions("u1", 3); len(records) != 3 || records[2].MatchID != "m53" {
+		t.Fatalf("expected the limit to be honoured, got %+v", records)
+	}
+
+	if err := manager.RecordUserSession("u1", models.SessionRecord{MatchID: "bad", EndedAt: "yesterday"}); err == nil {
+		t.Fatal("expected an invalid end time to be refused")
+	}
+}
+
+func TestOccupancyAcrossInstances(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+	other := NewRoomManager(mr.Addr(), "")
+	t.Cleanup(other.Cleanup)
+	third := NewRoomManager(mr.Addr(), "")
+	t.Cleanup(third.Cleanup)
+
+	if connected, err := manager.RemoteOccupancy("m1"); err != nil || len(connected) != 0 {
+		t.Fatalf("expected nobody, got %q err=%v", connected, err)
+	}
+	if err := manager.SetOccupancy("m1", []string{"u2", "u1"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+	if err := other.SetOccupancy("m1", []string{"u1"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+	connected, err := third.RemoteOccupancy("m1")
+	if err != nil || len(connected) != 2 || connected[0] != "u1" || connected[1] != "u2" {
+		t.Fatalf("expected u1 and u2 once each, got %q err=%v", connected, err)
+	}
+	// An instance leaves itself out
+	if connected, err := manager.RemoteOccupancy("m1"); err != nil || len(connected) != 1 || connected[0] != "u1" {
+		t.Fatalf("expected only the other instance's u1, got %q err=%v", connected, err)
+	}
+
+	// Leaving clears only this instance's entry
+	if err := manager.SetOccupancy("m1", nil); err != nil {
+		t.Fatalf("clear occupancy: %v", err)
+	}
+	if connected, err := third.RemoteOccupancy("m1"); err != nil || len(connected) != 1 || connected[0] != "u1" {
+		t.Fatalf("expected the other instance's u1, got %q err=%v", connected, err)
+	}
+}
+
+func TestOccupancyOfDeadInstanceExpires(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+	other := NewRoomManager(mr.Addr(), "")
+	t.Cleanup(other.Cleanup)
+
+	if err := manager.SetOccupancy("m1", []string{"u1"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+	if err := other.SetOccupancy("m1", []string{"u2"}); err != nil {
+		t.Fatalf("set occupancy: %v", err)
+	}
+
+	// manager keeps its heartbeat while other has gone away
+	mr.FastForward(occupancyTTL / 2)
+	manager.refreshOccupancy()
+	mr.FastForward(occupancyTTL / 2)
+
+	connected, err := other.RemoteOccupancy("m1")
+	if err != nil || len(connected) != 1 || connected[0] != "u1" {
+		t.Fatalf("expected the live instance's u1, got %q err=%v", connected, err)
+	}
+	probe := NewRoomManager(mr.Addr(), "")
+	t.Cleanup(probe.Cleanup)
+	if connected, err := probe.RemoteOccupancy("m1"); err != nil || len(connected) != 1 || connected[0] != "u1" {
+		t.Fatalf("expected the dead instance's u2 to have expired, got %q err=%v", connected, err)
+	}
+	if members, _ := mr.Members("occupancy:m1"); len(members) != 1 {
+		t.Fatalf("expected the dead instance to be pruned, got %q", members)
+	}
+}
+
+func TestDraftOutlivesRoomThenExpires(t *testing.T) {
+	manager, mr, _ := setupRoomManager(t, nil)
+	mr.HSet("room:room1", "status", "ready")
+	mr.SetTTL("room:room1", time.Hour)
+
+	draft := models.Draft{MatchID: "room1", UserID: "u1", Text: "print(1)", Cursor: 3, Version: 4, SavedAt: 1}
+	if err := manager.SaveDraft(draft); err != nil {
+		t.Fatalf("SaveDraft error: %v", err)
+	}
+	if ttl := mr.TTL("draft:room1:u1"); ttl != 25*time.Hour {
+		t.Fatalf("expected draft ttl of room lifetime plus 24h, got %v", ttl)
+	}
+
+	got, err := manager.LoadDraft("room1", "u1")
+	if err != nil || got == nil || *got != draft {
+		t.Fatalf("unexpected draft %#v, %v", got, err)
+	}
+	if other, err := manager.LoadDraft("room1", "u2"); err != nil || other != nil {
+		t.Fatalf("expected no draft for partner, got %#v, %v", other, err)
+	}
+
+	// Still there after the room itself has gone
+	mr.FastForward(2 * time.Hour)
+	if mr.Exists("room:room1") {
+		t.Fatalf("expected room to have expired")
+	}
+	if got, _ := manager.LoadDraft("room1", "u1"); got == nil {
+		t.Fatalf("expected draft to outlive its room")
+	}
+
+	mr.FastForward(24 * time.Hour)
+	if got, err := manager.LoadDraft("room1", "u1"); err != nil || got != nil {
+		t.Fatalf("expected draft to expire, got %#v, %v", got, err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	questionUp := atomic.Bool{}
+	questionUp.Store(true)
+	manager, mr, _ := setupRoomManager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/questions/healthz" || !questionUp.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	dependency := func() string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := manager.Ready(ctx)
+		if err == nil {
+			return ""
+		}
+		var depErr *DependencyError
+		if !errors.As(err, &depErr) {
+			t.Fatalf("expected a DependencyError, got %v", err)
+		}
+		return depErr.Dependency
+	}
+
+	// Not ready until match events are being received
+	if got := dependency(); got != DependencyMatches {
+		t.Fatalf("expected the match subscription to be missing, got %q", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.SubscribeToMatches(ctx)
+	deadline := time.Now().Add(time.Second)
+	for dependency() != "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := dependency(); got != "" {
+		t.Fatalf("expected ready, got %q not ready", got)
+	}
+
+	questionUp.Store(false)
+	if got := dependency(); got != DependencyQuestion {
+		t.Fatalf("expected the question service to be down, got %q", got)
+	}
+	questionUp.Store(true)
+
+	mr.Close()
+	if got := dependency(); got != DependencyRedis {
+		t.Fatalf("expected redis to be down, got %q", got)
+	}
+}
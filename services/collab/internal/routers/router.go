@@ -6,6 +6,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"collab/internal/api"
+	"collab/internal/metrics"
 	"collab/internal/room_management"
 	"collab/internal/utils"
 )
@@ -15,20 +16,37 @@ func New(log *utils.Logger, roomManager *room_management.RoomManager) http.Handl
 	r := chi.NewRouter()
 
 	r.Get("/healthz", h.Health)
+	r.Get("/readyz", h.Ready)
+	r.Handle("/metrics", metrics.Handler())
 
 	r.Get("/languages", h.ListLanguages)
 	r.Post("/format", h.FormatCode)
+	r.Post("/lint", h.Lint)
 
 	r.Post("/run", h.RunOnce)
 
 	// Room status endpoint
 	r.Get("/room/{matchId}", h.GetRoomStatus)
 	r.Post("/room/{matchId}/reroll", h.RerollQuestion)
+	r.Post("/room/{matchId}/reroll/approve", h.ApproveReroll)
+	r.Post("/room/{matchId}/retry-question", h.RetryQuestion)
+	r.Post("/room/{matchId}/run-tests", h.RunTests)
+	r.Get("/room/{matchId}/snapshots", h.ListSnapshots)
+	r.Get("/room/{matchId}/draft", h.GetDraft)
+	r.Get("/room/{matchId}/export", h.ExportCode)
+	r.Get("/room/{matchId}/chat", h.GetChatHistory)
 	r.Get("/room/active/{userId}", h.GetActiveRoom)
 
 	r.Get("/room/{matchId}/getInstanceID", h.GetInstanceID)
 
+	r.Get("/session/{matchId}/summary", h.GetSessionSummary)
+	r.Get("/users/{userId}/sessions", h.GetUserSessions)
+
 	r.Get("/ws/session/{id}", h.CollabWS)
 
+	// Admin debug dumps of this instance's hub
+	r.Get("/debug/rooms", h.DebugRooms)
+	r.Get("/debug/rooms/{matchId}", h.DebugRoom)
+
 	return r
 }
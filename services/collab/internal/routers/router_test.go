@@ -1,8 +1,10 @@
 package routers
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"collab/internal/room_management"
@@ -27,3 +29,19 @@ func TestNewRouterHealthEndpoint(t *testing.T) {
 		t.Fatalf("expected 200, got %d", resp.StatusCode)
 	}
 }
+
+func TestNewRouterMetricsEndpoint(t *testing.T) {
+	manager := room_management.NewRoomManager("localhost:0", "http://localhost")
+	server := httptest.NewServer(New(utils.NewLogger(), manager))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "collab_rooms_active") {
+		t.Fatalf("expected collab metrics, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package session
+
+import (
+	"errors"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"collab/internal/models"
+)
+
+const (
+	// maxChatHistory bounds the messages kept (and persisted) per room.
+	maxChatHistory = 200
+	// maxChatReplay bounds the messages replayed to a resuming client
+	maxChatReplay = 50
+	// maxChatMessageRunes caps a chat message; longer ones are cut short
+	maxChatMessageRunes = 2000
+	// maxReactionsPerMessage caps the distinct emojis on a single message.
+	maxReactionsPerMessage = 10
+)
+
+// allowedReactions is the emoji allowlist for chat reactions.
+var allowedReactions = map[string]bool{
+	"👍": true, "👎": true, "✅": true, "❌": true,
+	"❤️": true, "😂": true, "🎉": true, "👀": true,
+	"🤔": true, "🙏": true, "🔥": true, "🚀": true,
+}
+
+var (
+	ErrUnknownMessage    = errors.New("unknown_message")
+	ErrEmojiNotAllowed   = errors.New("emoji_not_allowed")
+	ErrTooManyReactions  = errors.New("too_many_reactions")
+	ErrInvalidReactionOp = errors.New("invalid_reaction")
+)
+
+// AddChatMessage stores a message under the next server-assigned id. A
+// message over maxChatMessageRunes is cut short, ending in an ellipsis and
+// marked truncated.
+func (r *Room) AddChatMessage(userID, message string) models.ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var id int64 = 1
+	if n := len(r.chat); n > 0 {
+		id = r.chat[n-1].ID + 1
+	}
+	msg := models.ChatMessage{
+		ID:      id,
+		UserID:  userID,
+		Message: message,
+		SentAt:  time.Now().UnixMilli(),
+	}
+	if utf8.RuneCountInString(message) > maxChatMessageRunes {
+		msg.Message = string([]rune(message)[:maxChatMessageRunes-1]) + "…"
+		msg.Truncated = true
+	}
+	r.chat = append(r.chat, msg)
+	if len(r.chat) > maxChatHistory {
+		r.chat = r.chat[len(r.chat)-maxChatHistory:]
+	}
+	return msg
+}
+
+// ChatSince returns the messages after afterID, at most the last
+// maxChatReplay of them.
+func (r *Room) ChatSince(afterID int64) []models.ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := sort.Search(len(r.chat), func(i int) bool { return r.chat[i].ID > afterID })
+	missed := r.chat[i:]
+	if len(missed) > maxChatReplay {
+		missed = missed[len(missed)-maxChatReplay:]
+	}
+	return append([]models.ChatMessage(nil), missed...)
+}
+
+// ReactToChat adds or removes userID's emoji on a message and returns the
+// message's resulting reaction set.
+func (r *Room) ReactToChat(userID string, reaction models.ChatReaction) (map[string][]string, error) {
+	if !allowedReactions[reaction.Emoji] {
+		return nil, ErrEmojiNotAllowed
+	}
+	if reaction.Action != "add" && reaction.Action != "remove" {
+		return nil, ErrInvalidReactionOp
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg := r.chatMessageLocked(reaction.MessageID)
+	if msg == nil {
+		return nil, ErrUnknownMessage
+	}
+
+	users := msg.Reactions[reaction.Emoji]
+	idx := indexOf(users, userID)
+	switch reaction.Action {
+	case "add":
+		if idx >= 0 {
+			break
+		}
+		if len(users) == 0 && len(msg.Reactions) >= maxReactionsPerMessage {
+			return nil, ErrTooManyReactions
+		}
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string][]string)
+		}
+		msg.Reactions[reaction.Emoji] = append(users, userID)
+	case "remove":
+		if idx < 0 {
+			break
+		}
+		users = append(users[:idx:idx], users[idx+1:]...)
+		if len(users) == 0 {
+			delete(msg.Reactions, reaction.Emoji)
+		} else {
+			msg.Reactions[reaction.Emoji] = users
+		}
+	}
+	return copyReactions(msg.Reactions), nil
+}
+
+// MarkChatRead advances userID's read high-water mark. It never moves the mark
+// backwards; changed reports whether the mark advanced.
+func (r *Room) MarkChatRead(userID string, upTo int64) (mark int64, changed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.chatMessageLocked(upTo) == nil {
+		return r.chatReadMarks[userID], false, ErrUnknownMessage
+	}
+	if upTo <= r.chatReadMarks[userID] {
+		return r.chatReadMarks[userID], false, nil
+	}
+	r.chatReadMarks[userID] = upTo
+	return upTo, true, nil
+}
+
+// ChatState returns a copy of the room's chat for replay and persistence.
+func (r *Room) ChatState() models.ChatState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := models.ChatState{
+		Messages:  make([]models.ChatMessage, len(r.chat)),
+		ReadMarks: make(map[string]int64, len(r.chatReadMarks)),
+	}
+	for i, msg := range r.chat {
+		msg.Reactions = copyReactions(msg.Reactions)
+		state.Messages[i] = msg
+	}
+	for user, mark := range r.chatReadMarks {
+		state.ReadMarks[user] = mark
+	}
+	return state
+}
+
+// RestoreChatState seeds the room from a persisted snapshot. It is a no-op
+// once the room already holds chat, so a live room is never rolled back.
+func (r *Room) RestoreChatState(state models.ChatState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.chat) > 0 || len(r.chatReadMarks) > 0 {
+		return false
+	}
+	sort.Slice(state.Messages, func(i, j int) bool { return state.Messages[i].ID < state.Messages[j].ID })
+	if len(state.Messages) > maxChatHistory {
+		state.Messages = state.Messages[len(state.Messages)-maxChatHistory:]
+	}
+	r.chat = state.Messages
+	for user, mark := range state.ReadMarks {
+		r.chatReadMarks[user] = mark
+	}
+	return true
+}
+
+func (r *Room) chatMessageLocked(id int64) *models.ChatMessage {
+	i := sort.Search(len(r.chat), func(i int) bool { return r.chat[i].ID >= id })
+	if i < len(r.chat) && r.chat[i].ID == id {
+		return &r.chat[i]
+	}
+	return nil
+}
+
+func copyReactions(in map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(in))
+	for emoji, users := range in {
+		out[emoji] = append([]string(nil), users...)
+	}
+	return out
+}
+
+func indexOf(values []string, v string) int {
+	for i, candidate := range values {
+		if candidate == v {
+			return i
+		}
+	}
+	return -1
+}
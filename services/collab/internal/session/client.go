@@ -2,6 +2,8 @@ package session
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -10,11 +12,24 @@ import (
 
 type Client struct {
 	Conn *websocket.Conn
-	mu   sync.Mutex
-	hook func(models.WSFrame)
+	// UserID is the authenticated room participant, empty if unknown.
+	UserID string
+	// Locale selects the language of error messages sent to this client.
+	Locale      string
+	ConnectedAt time.Time
+	mu          sync.Mutex
+	hook        func(models.WSFrame)
+
+	caps        Capabilities
+	compressMin int          // smallest text message worth permessage-deflate
+	seen        atomic.Int64 // unix nanos of the last sign of life, see MarkSeen
 }
 
-func NewClient(conn *websocket.Conn) *Client { return &Client{Conn: conn} }
+func NewClient(conn *websocket.Conn) *Client {
+	c := &Client{Conn: conn, ConnectedAt: time.Now()}
+	c.MarkSeen()
+	return c
+}
 
 // SetSendHook replaces the default WebSocket sender (used in tests).
 func (c *Client) SetSendHook(fn func(models.WSFrame)) {
@@ -23,9 +38,36 @@ func (c *Client) SetSendHook(fn func(models.WSFrame)) {
 	c.mu.Unlock()
 }
 
+// SetCapabilities applies the doc frame capabilities negotiated in init.
+func (c *Client) SetCapabilities(caps Capabilities) {
+	c.mu.Lock()
+	c.caps = caps
+	c.mu.Unlock()
+}
+
+// SetCompressionThreshold skips permessage-deflate for text messages shorter
+// than n bytes. It has no effect unless compression was negotiated.
+func (c *Client) SetCompressionThreshold(n int) {
+	c.mu.Lock()
+	c.compressMin = n
+	c.mu.Unlock()
+}
+
 func (c *Client) Send(frame models.WSFrame) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.sendLocked(frame)
+}
+
+// SendDoc sends a document update in the form this client negotiated. prev is
+// the state the update applies to, or nil to always send the full doc.
+func (c *Client) SendDoc(prev *models.DocState, doc models.DocState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendLocked(c.docFrame(prev, doc))
+}
+
+func (c *Client) sendLocked(frame models.WSFrame) {
 	if c.hook != nil {
 		c.hook(frame)
 		return
@@ -33,5 +75,11 @@ func (c *Client) Send(frame models.WSFrame) {
 	if c.Conn == nil {
 		return
 	}
-	_ = c.Conn.WriteJSON(frame)
+	msgType, payload, err := c.encodeFrame(frame)
+	if err != nil {
+		return
+	}
+	// gzipped binary frames do not deflate any further
+	c.Conn.EnableWriteCompression(msgType == websocket.TextMessage && len(payload) >= c.compressMin)
+	_ = c.Conn.WriteMessage(msgType, payload)
 }
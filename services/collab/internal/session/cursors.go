@@ -0,0 +1,92 @@
+package session
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+
+	"collab/internal/models"
+)
+
+var ErrCursorSpoofed = errors.New("cursor_spoofed")
+
+// cursorColors are handed out to the users of a room in the order they join
+var cursorColors = []string{"#e8590c", "#1c7ed6", "#2f9e44", "#ae3ec9", "#f08c00", "#0c8599", "#e03131", "#5c940d"}
+
+// assignColorLocked gives userID the first color no other user of the room
+// has. A user keeps their color for the room's lifetime, across reconnects.
+func (r *Room) assignColorLocked(userID string) {
+	if userID == "" {
+		return
+	}
+	if r.colors == nil {
+		r.colors = make(map[string]string)
+	}
+	if _, ok := r.colors[userID]; ok {
+		return
+	}
+	taken := make(map[string]bool, len(r.colors))
+	for _, color := range r.colors {
+		taken[color] = true
+	}
+	color := cursorColors[len(r.colors)%len(cursorColors)]
+	for _, c := range cursorColors {
+		if !taken[c] {
+			color = c
+			break
+		}
+	}
+	r.colors[userID] = color
+}
+
+// Color returns the cursor color assigned to userID, "" if none is.
+func (r *Room) Color(userID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.colors[userID]
+}
+
+// UpdateCursor records the cursor of userID and returns it as it should be
+// relayed: attributed to userID, in the user's color, with the selection
+// ordered and every position clamped to the document. A cursor claiming to
+// be another user's is refused.
+func (r *Room) UpdateCursor(userID string, c models.Cursor) (models.Cursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if userID == "" || (c.UserID != "" && c.UserID != userID) {
+		return models.Cursor{}, ErrCursorSpoofed
+	}
+	c.UserID = userID
+	c.Color = r.colors[userID]
+	if c.SelStart > c.SelEnd {
+		c.SelStart, c.SelEnd = c.SelEnd, c.SelStart
+	}
+	c = r.clampCursorLocked(c)
+	if r.cursors == nil {
+		r.cursors = make(map[string]models.Cursor)
+	}
+	r.cursors[userID] = c
+	return c, nil
+}
+
+// Cursors returns the last known cursor of every user but userID, clamped to
+// the current document.
+func (r *Room) Cursors(userID string) []models.Cursor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cursors := []models.Cursor{}
+	for id, c := range r.cursors {
+		if id != userID {
+			cursors = append(cursors, r.clampCursorLocked(c))
+		}
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].UserID < cursors[j].UserID })
+	return cursors
+}
+
+func (r *Room) clampCursorLocked(c models.Cursor) models.Cursor {
+	n := utf8.RuneCountInString(r.doc.Text)
+	clamp := func(pos int) int { return max(0, min(pos, n)) }
+	c.Pos, c.SelStart, c.SelEnd = clamp(c.Pos), clamp(c.SelStart), clamp(c.SelEnd)
+	return c
+}
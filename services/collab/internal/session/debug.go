@@ -0,0 +1,125 @@
+package session
+
+import (
+	"sort"
+	"time"
+
+	"collab/internal/models"
+)
+
+// maxActivityEntries bounds each room's activity log.
+const maxActivityEntries = 100
+
+// Rooms returns the hub's rooms. The hub lock is only held while copying, so
+// callers can inspect rooms one by one without stalling joins and leaves.
+func (h *Hub) Rooms() []*Room {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// RecordActivity appends an event to the room's activity log. Detail must not
+// carry document text or chat content.
+func (r *Room) RecordActivity(kind, userID, detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordActivityLocked(kind, userID, detail)
+}
+
+func (r *Room) recordActivityLocked(kind, userID, detail string) {
+	now := time.Now()
+	r.lastActivity = now
+	if n := len(r.activity); n > 0 {
+		last := &r.activity[n-1]
+		if last.Type == kind && last.UserID == userID && last.Detail == detail {
+			last.At = now.UnixMilli()
+			last.Count++
+			return
+		}
+	}
+	if len(r.activity) == maxActivityEntries {
+		r.activity = append(r.activity[:0], r.activity[1:]...)
+	}
+	r.activity = append(r.activity, models.ActivityEntry{
+		At: now.UnixMilli(), Type: kind, UserID: userID, Detail: detail, Count: 1,
+	})
+}
+
+// Touch marks the room as in use without logging an activity entry, for
+// frames too frequent for the log such as cursor moves and typing.
+func (r *Room) Touch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastActivity = time.Now()
+}
+
+// IdleFor returns how long the room has gone without activity as of now.
+func (r *Room) IdleFor(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return now.Sub(r.lastActivity)
+}
+
+// Activity returns the room's recent activity, oldest first.
+func (r *Room) Activity() []models.ActivityEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.ActivityEntry{}, r.activity...)
+}
+
+// DebugSnapshot describes the room for the admin debug dump. It holds the room
+// lock only while copying and deliberately leaves out the document text, the
+// chat and anything token related.
+func (r *Room) DebugSnapshot(now time.Time) models.RoomDebug {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := models.RoomDebug{
+		MatchID:      r.ID,
+		ClientCount:  len(r.clients),
+		Clients:      make([]models.ClientDebug, 0, len(r.clients)),
+		DocVersion:   r.doc.Version,
+		DocBytes:     len(r.doc.Text),
+		Language:     r.language,
+		Run:          models.RunDebug{Active: r.runActiveLocked(), Frames: len(r.runHistory)},
+		SessionEnded: r.sessionEnded,
+		StartedAt:    r.startedAt.UnixMilli(),
+		LastActivity: r.lastActivity.UnixMilli(),
+	}
+	for c := range r.clients {
+		snap.Clients = append(snap.Clients, models.ClientDebug{
+			UserID:          c.UserID,
+			ConnectedAt:     c.ConnectedAt.UnixMilli(),
+			ConnectedForSec: int64(now.Sub(c.ConnectedAt).Seconds()),
+		})
+	}
+	sort.Slice(snap.Clients, func(i, j int) bool {
+		if snap.Clients[i].ConnectedAt != snap.Clients[j].ConnectedAt {
+			return snap.Clients[i].ConnectedAt < snap.Clients[j].ConnectedAt
+		}
+		return snap.Clients[i].UserID < snap.Clients[j].UserID
+	})
+	if r.allDisconnected && r.lastDisconnectAt != nil {
+		at := r.lastDisconnectAt.UnixMilli()
+		snap.Timer = models.TimerDebug{SessionEndPending: !r.sessionEnded, DisconnectedAt: &at}
+	}
+	return snap
+}
+
+// runActiveLocked reports whether a batch run has started without its result
+// being recorded yet.
+func (r *Room) runActiveLocked() bool {
+	if len(r.runHistory) == 0 {
+		return false
+	}
+	for _, frame := range r.runHistory {
+		if frame.Type == "exit" || frame.Type == "error" {
+			return false
+		}
+	}
+	return true
+}
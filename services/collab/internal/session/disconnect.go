@@ -0,0 +1,95 @@
+package session
+
+import (
+	"time"
+
+	"collab/internal/models"
+)
+
+// defaultPartnerGrace is how long a participant who dropped out may take to
+// come back before the session is ended as abandoned
+const defaultPartnerGrace = 5 * time.Minute
+
+// WithPartnerGrace gives a participant who disconnects d to rejoin before the
+// room's abandon handler runs; 0 leaves the session open for good.
+func WithPartnerGrace(d time.Duration) RoomOption {
+	return func(r *Room) { r.partnerGrace = d }
+}
+
+// SetAbandonHandler sets what runs when a participant's grace period lapses
+// without them rejoining. It runs without the room lock held.
+func (r *Room) SetAbandonHandler(handler func(userID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.abandonHandler = handler
+}
+
+// graceTimer is the grace period of a participant who dropped out; stop is
+// nil when the room gives no grace period
+type graceTimer struct {
+	stop func() bool
+}
+
+// peerLeftLocked tells the remaining participants that c's user dropped out
+// and starts their grace period. A user with another connection still open
+// has not left, and with nobody left to tell, the room's own end timer
+// applies instead.
+func (r *Room) peerLeftLocked(c *Client) {
+	userID := c.UserID
+	if userID == "" || r.sessionEnded || len(r.clients) == 0 || r.connectedLocked(userID) {
+		return
+	}
+	if r.graces == nil {
+		r.graces = make(map[string]*graceTimer)
+	}
+	if g := r.graces[userID]; g != nil && g.stop != nil {
+		g.stop()
+	}
+	g := &graceTimer{}
+	r.graces[userID] = g
+	notice := models.PeerDisconnected{UserID: userID, GraceMs: r.partnerGrace.Milliseconds()}
+	if r.partnerGrace > 0 {
+		notice.RejoinBy = r.clock.Now().Add(r.partnerGrace).UnixMilli()
+		g.stop = r.clock.AfterFunc(r.partnerGrace, func() { r.graceLapsed(userID, g) })
+	}
+	r.broadcastFrameLocked(models.WSFrame{Type: "peer_disconnected", Data: notice})
+}
+
+// peerRejoinedLocked ends c's user's grace period, telling the others they
+// are back.
+func (r *Room) peerRejoinedLocked(c *Client) {
+	g, ok := r.graces[c.UserID]
+	if !ok {
+		return
+	}
+	if g.stop != nil {
+		g.stop()
+	}
+	delete(r.graces, c.UserID)
+	frame := models.WSFrame{Type: "peer_rejoined", Data: map[string]string{"userId": c.UserID}}
+	for other := range r.clients {
+		if other != c {
+			other.Send(frame)
+		}
+	}
+	for other := range r.spectators {
+		other.Send(frame)
+	}
+}
+
+// graceLapsed runs the abandon handler unless userID came back, or the
+// session ended, in the meantime.
+func (r *Room) graceLapsed(userID string, g *graceTimer) {
+	r.mu.Lock()
+	if r.graces[userID] != g || r.sessionEnded || r.connectedLocked(userID) {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.graces, userID)
+	handler := r.abandonHandler
+	r.mu.Unlock()
+
+	if handler != nil {
+		handler(userID)
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+
+	"collab/internal/models"
+)
+
+// ProtocolVersion is the newest collab WebSocket protocol. Version 2 added
+// doc frame capabilities.
+const ProtocolVersion = 2
+
+// Doc frame capabilities a client can ask for in init
+const (
+	// CapDocBinary sends doc and doc_delta frames as gzipped JSON in binary messages
+	CapDocBinary = "doc_binary"
+	// CapDocDelta sends doc_delta frames with the changed range instead of the full doc
+	CapDocDelta = "doc_delta"
+)
+
+// Capabilities are the doc frame options granted to a client
+type Capabilities struct {
+	BinaryDoc bool
+	DocDelta  bool
+}
+
+// NegotiateCapabilities grants the known capabilities a client asked for.
+// Clients below protocol version 2 get none. It returns the protocol version
+// to use and the granted capability names for the init response.
+func NegotiateCapabilities(version int, requested []string) (int, Capabilities, []string) {
+	if version < ProtocolVersion {
+		return 1, Capabilities{}, nil
+	}
+	var caps Capabilities
+	for _, name := range requested {
+		switch name {
+		case CapDocBinary:
+			caps.BinaryDoc = true
+		case CapDocDelta:
+			caps.DocDelta = true
+		}
+	}
+	var granted []string
+	if caps.BinaryDoc {
+		granted = append(granted, CapDocBinary)
+	}
+	if caps.DocDelta {
+		granted = append(granted, CapDocDelta)
+	}
+	return ProtocolVersion, caps, granted
+}
+
+// DocHash is the hex SHA-256 of the document text, carried by doc_delta frames
+func DocHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewDocDelta describes the change from prev to next as a single replaced
+// range, in runes like edits, found by trimming the common prefix and suffix.
+func NewDocDelta(prev, next models.DocState) models.DocDelta {
+	a, b := []rune(prev.Text), []rune(next.Text)
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	return models.DocDelta{
+		BaseVersion: prev.Version,
+		Version:     next.Version,
+		RangeStart:  start,
+		RangeEnd:    endA,
+		Text:        string(b[start:endB]),
+		Hash:        DocHash(next.Text),
+	}
+}
+
+// docFrame picks the frame for a doc update: a delta when the client takes
+// them and the previous state is known, the full doc otherwise.
+func (c *Client) docFrame(prev *models.DocState, doc models.DocState) models.WSFrame {
+	if c.caps.DocDelta && prev != nil && prev.Version < doc.Version {
+		return models.WSFrame{Type: "doc_delta", Data: NewDocDelta(*prev, doc)}
+	}
+	return models.WSFrame{Type: "doc", Data: doc}
+}
+
+// encodeFrame returns the WebSocket message type and payload for frame
+func (c *Client) encodeFrame(frame models.WSFrame) (int, []byte, error) {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !c.caps.BinaryDoc || (frame.Type != "doc" && frame.Type != "doc_delta") {
+		return websocket.TextMessage, payload, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return 0, nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, buf.Bytes(), nil
+}
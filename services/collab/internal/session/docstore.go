@@ -0,0 +1,97 @@
+package session
+
+import (
+	"time"
+
+	"collab/internal/models"
+)
+
+// docPersistInterval is the most often a room writes its document to the
+// DocStore; edits in between are covered by the next write
+const docPersistInterval = 500 * time.Millisecond
+
+// DocStore keeps room documents outside the process, so a room opened again
+// after a restart starts from the document it had.
+type DocStore interface {
+	SaveDoc(matchID string, doc models.DocSnapshot) error
+	LoadDoc(matchID string) (*models.DocSnapshot, error)
+}
+
+// SetDocStore makes every room created afterwards hydrate its document from
+// store and write it back as it changes. onError, if set, is told about
+// loads and saves that failed.
+func (h *Hub) SetDocStore(store DocStore, onError func(matchID string, err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.docStore = store
+	h.onDocError = onError
+}
+
+// loadDoc returns the persisted document of room id, if there is a store and
+// it has one
+func (h *Hub) loadDoc(id string) *models.DocSnapshot {
+	h.mu.RLock()
+	store, onError := h.docStore, h.onDocError
+	h.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+	doc, err := store.LoadDoc(id)
+	if err != nil && onError != nil {
+		onError(id, err)
+	}
+	return doc
+}
+
+// RestoreDoc seeds a room that has no document yet with a persisted one
+func (r *Room) RestoreDoc(doc models.DocSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.doc.Version != 0 || r.doc.Text != "" {
+		return
+	}
+	r.doc = models.DocState{Text: doc.Text, Version: doc.Version}
+	r.snapshotVersion = doc.Version
+	if doc.Language != "" {
+		r.language = doc.Language
+	}
+	r.resetOTBufferLocked()
+}
+
+// docChangedLocked schedules writing the document to the stores, unless a
+// write is already due
+func (r *Room) docChangedLocked() {
+	if (r.docStore == nil && r.snapshotStore == nil) || r.docWritePending {
+		return
+	}
+	r.docWritePending = true
+	r.clock.AfterFunc(docPersistInterval, r.persistDoc)
+}
+
+// persistDoc writes the document as it is now, and a snapshot of it once
+// snapshotEvery versions went by since the last one. It runs off the room's
+// lock so a slow store never holds up edits.
+func (r *Room) persistDoc() {
+	r.mu.Lock()
+	r.docWritePending = false
+	store, snapshots, onError := r.docStore, r.snapshotStore, r.onDocError
+	doc := models.DocSnapshot{Text: r.doc.Text, Version: r.doc.Version, Language: r.language}
+	snapshotDue := snapshots != nil && r.snapshotEvery > 0 && doc.Version >= r.snapshotVersion+r.snapshotEvery
+	if snapshotDue {
+		r.snapshotVersion = doc.Version
+	}
+	at := r.clock.Now()
+	r.mu.Unlock()
+
+	if store != nil {
+		if err := store.SaveDoc(r.ID, doc); err != nil && onError != nil {
+			onError(r.ID, err)
+		}
+	}
+	if snapshotDue {
+		snap := models.Snapshot{Version: doc.Version, Text: doc.Text, Language: doc.Language, Timestamp: at.UnixMilli()}
+		if err := snapshots.AddSnapshot(r.ID, snap); err != nil && onError != nil {
+			onError(r.ID, err)
+		}
+	}
+}
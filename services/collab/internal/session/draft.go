@@ -0,0 +1,61 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/models"
+)
+
+// draftRestoreTimeout bounds how long a restore waits for the partner.
+const draftRestoreTimeout = 30 * time.Second
+
+var (
+	ErrRestorePending     = errors.New("restore_pending")
+	ErrNoPendingRestore   = errors.New("no_pending_restore")
+	ErrPartnerUnavailable = errors.New("partner_unavailable")
+)
+
+type pendingRestore struct {
+	requester *Client
+	draft     models.Draft
+	expiresAt time.Time
+}
+
+// RequestDraftRestore parks a restore of requester's draft until the partner
+// confirms it. Only one restore may be pending per room.
+func (r *Room) RequestDraftRestore(requester *Client, draft models.Draft) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p := r.pendingRestore; p != nil && time.Now().Before(p.expiresAt) {
+		return ErrRestorePending
+	}
+	if len(r.clients) < 2 {
+		return ErrPartnerUnavailable
+	}
+	r.pendingRestore = &pendingRestore{
+		requester: requester,
+		draft:     draft,
+		expiresAt: time.Now().Add(draftRestoreTimeout),
+	}
+	return nil
+}
+
+// ResolveDraftRestore applies (accept) or drops the pending restore on behalf
+// of the partner. The restore replaces the whole document as one versioned
+// edit. The requester cannot confirm their own restore.
+func (r *Room) ResolveDraftRestore(responder *Client, accept bool) (requester *Client, draft models.Draft, doc models.DocState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.pendingRestore
+	if p == nil || p.requester == responder || time.Now().After(p.expiresAt) {
+		return nil, models.Draft{}, r.doc, ErrNoPendingRestore
+	}
+	r.pendingRestore = nil
+	if !accept {
+		return p.requester, p.draft, r.doc, nil
+	}
+
+	_, doc, err = r.replaceDocLocked(p.draft.Text)
+	return p.requester, p.draft, doc, err
+}
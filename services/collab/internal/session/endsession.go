@@ -0,0 +1,80 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/models"
+)
+
+// endSessionTimeout bounds how long a request to end the session waits for
+// the partner.
+const endSessionTimeout = 60 * time.Second
+
+var (
+	ErrEndPending   = errors.New("end_pending")
+	ErrNoPendingEnd = errors.New("no_pending_end")
+)
+
+type pendingEnd struct {
+	requester string
+	expiresAt time.Time
+}
+
+// RequestEndSession asks the partner to agree to ending the session. It
+// reports agreed when the session should end straight away instead: the
+// partner had already asked, or the requester asks again after the partner
+// disconnected.
+func (r *Room) RequestEndSession(requester *Client) (req models.EndSessionRequest, agreed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p := r.pendingEndLocked(); p != nil {
+		if p.requester != requester.UserID {
+			r.pendingEnd = nil
+			return models.EndSessionRequest{}, true, nil
+		}
+		if r.partnerLocked(requester.UserID) == "" {
+			r.pendingEnd = nil
+			return models.EndSessionRequest{}, true, nil
+		}
+		return models.EndSessionRequest{}, false, ErrEndPending
+	}
+	p := &pendingEnd{requester: requester.UserID, expiresAt: r.clock.Now().Add(endSessionTimeout)}
+	r.pendingEnd = p
+	return models.EndSessionRequest{UserID: p.requester, ExpiresAt: p.expiresAt.UnixMilli()}, false, nil
+}
+
+// ConfirmEndSession agrees to the pending request on behalf of the partner,
+// or of the requester once the partner has disconnected.
+func (r *Room) ConfirmEndSession(responder *Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.pendingEndLocked()
+	if p == nil || (p.requester == responder.UserID && r.partnerLocked(responder.UserID) != "") {
+		return ErrNoPendingEnd
+	}
+	r.pendingEnd = nil
+	return nil
+}
+
+// CancelEndSession withdraws (requester) or declines (partner) the pending
+// request.
+func (r *Room) CancelEndSession() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pendingEndLocked() == nil {
+		return ErrNoPendingEnd
+	}
+	r.pendingEnd = nil
+	return nil
+}
+
+// pendingEndLocked returns the request to end the session, unless there is
+// none or it timed out
+func (r *Room) pendingEndLocked() *pendingEnd {
+	if p := r.pendingEnd; p != nil && r.clock.Now().Before(p.expiresAt) {
+		return p
+	}
+	r.pendingEnd = nil
+	return nil
+}
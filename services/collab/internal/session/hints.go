@@ -0,0 +1,31 @@
+package session
+
+import "errors"
+
+var ErrHintLimitReached = errors.New("hint_limit_reached")
+
+// TakeHint spends one of limit hints the room may ask for on questionID and
+// returns how many are left. The count starts over when the room moves to
+// another question, e.g. after a reroll.
+func (r *Room) TakeHint(questionID, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if questionID != r.hintQuestion {
+		r.hintQuestion, r.hintsUsed = questionID, 0
+	}
+	if r.hintsUsed >= limit {
+		return 0, ErrHintLimitReached
+	}
+	r.hintsUsed++
+	return limit - r.hintsUsed, nil
+}
+
+// RefundHint gives a hint on questionID back after the AI service failed to
+// produce it.
+func (r *Room) RefundHint(questionID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if questionID == r.hintQuestion && r.hintsUsed > 0 {
+		r.hintsUsed--
+	}
+}
@@ -1,23 +1,48 @@
 package session
 
-import "sync"
+import (
+	"sync"
+
+	"collab/internal/metrics"
+)
 
 // Hub manages all active collaboration rooms.
 type Hub struct {
 	mu    sync.RWMutex
 	rooms map[string]*Room
+
+	docStore   DocStore // see SetDocStore
+	onDocError func(matchID string, err error)
+
+	snapshotStore SnapshotStore // see SetSnapshotStore
+	snapshotEvery int64
+
+	roomOpts []RoomOption // see SetRoomOptions
 }
 
 func NewHub() *Hub { return &Hub{rooms: make(map[string]*Room)} }
 
+// GetOrCreate returns room id, creating it if needed. A new room starts from
+// the document the DocStore has for it, loaded before the hub is locked.
 func (h *Hub) GetOrCreate(id string) *Room {
+	if r, ok := h.Get(id); ok {
+		return r
+	}
+	stored := h.loadDoc(id)
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if r, ok := h.rooms[id]; ok {
 		return r
 	}
-	r := NewRoom(id)
+	r := NewRoom(id, h.roomOpts...)
+	if stored != nil {
+		r.RestoreDoc(*stored)
+	}
+	r.docStore, r.onDocError = h.docStore, h.onDocError
+	r.snapshotStore, r.snapshotEvery = h.snapshotStore, h.snapshotEvery
 	h.rooms[id] = r
+	metrics.RoomOpened()
 	return r
 }
 
@@ -28,10 +53,18 @@ func (h *Hub) Get(id string) (*Room, bool) {
 	return r, ok
 }
 
+// Delete drops a room. Clients still attached to it stop counting as
+// connections, since the room no longer exists for them.
 func (h *Hub) Delete(id string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	r, ok := h.rooms[id]
+	if !ok {
+		return
+	}
 	delete(h.rooms, id)
+	r.detach()
+	metrics.RoomClosed()
 }
 
 func (h *Hub) GetDoc(sessionID string) (string, bool) {
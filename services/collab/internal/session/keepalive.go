@@ -0,0 +1,58 @@
+package session
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultStaleAfter is how long a client may go unheard before PruneStale
+// drops it; twice the server's ping interval
+const defaultStaleAfter = time.Minute
+
+// WithStaleAfter makes PruneStale drop connections silent for longer than d;
+// 0 keeps them until their read fails.
+func WithStaleAfter(d time.Duration) RoomOption {
+	return func(r *Room) { r.staleAfter = d }
+}
+
+// MarkSeen records that the client's connection is alive: it sent a frame or
+// answered a ping.
+func (c *Client) MarkSeen() { c.seen.Store(time.Now().UnixNano()) }
+
+func (c *Client) lastSeen() time.Time { return time.Unix(0, c.seen.Load()) }
+
+// Ping sends a WebSocket ping; the peer's pong is reported through the
+// connection's pong handler. It may be called alongside Send.
+func (c *Client) Ping(deadline time.Time) error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// PruneStale disconnects the clients not heard from for longer than the
+// room's stale period, so a connection a proxy dropped silently does not hold
+// a seat. It returns how many were dropped.
+func (r *Room) PruneStale() int {
+	r.mu.Lock()
+	var stale []*Client
+	if r.staleAfter > 0 {
+		cutoff := r.clock.Now().Add(-r.staleAfter)
+		for _, clients := range []map[*Client]struct{}{r.clients, r.spectators} {
+			for c := range clients {
+				if c.Conn != nil && c.lastSeen().Before(cutoff) {
+					stale = append(stale, c)
+				}
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, c := range stale {
+		r.Leave(c)
+		// Ends the client's read loop, whose own Leave is then a no-op
+		_ = c.Conn.Close()
+	}
+	return len(stale)
+}
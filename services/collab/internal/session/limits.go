@@ -0,0 +1,110 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/models"
+)
+
+const (
+	// defaultMaxDocBytes caps the shared document; edits that would grow it
+	// past this are rejected
+	defaultMaxDocBytes = 200 * 1024
+	// defaultEditRate is how many edit frames per second one client may send,
+	// with bursts of as many
+	defaultEditRate = 30
+)
+
+var ErrDocTooLarge = errors.New("doc_too_large")
+
+// RoomOption configures a room when it is created.
+type RoomOption func(*Room)
+
+// WithMaxDocBytes caps the document at n bytes; 0 leaves it uncapped.
+func WithMaxDocBytes(n int) RoomOption {
+	return func(r *Room) { r.maxDocBytes = n }
+}
+
+// WithEditRate limits each client to perSecond edits a second, allowing
+// bursts of as many; 0 leaves edits unlimited.
+func WithEditRate(perSecond int) RoomOption {
+	return func(r *Room) { r.editRate = perSecond }
+}
+
+// SetRoomOptions applies opts to every room created afterwards.
+func (h *Hub) SetRoomOptions(opts ...RoomOption) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.roomOpts = opts
+}
+
+// editBucket is a client's token bucket for edit frames
+type editBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// AllowEdit takes a token from c's edit bucket, reporting false when c is
+// sending edits faster than the room allows.
+func (r *Room) AllowEdit(c *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.editRate <= 0 {
+		return true
+	}
+	if r.editBuckets == nil {
+		r.editBuckets = make(map[*Client]*editBucket)
+	}
+	now := r.clock.Now()
+	b := r.editBuckets[c]
+	if b == nil {
+		b = &editBucket{tokens: float64(r.editRate), last: now}
+		r.editBuckets[c] = b
+	}
+	b.tokens = min(float64(r.editRate), b.tokens+now.Sub(b.last).Seconds()*float64(r.editRate))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// checkDocSizeLocked rejects an edit that would grow the document past
+// maxDocBytes. The size is worked out against the current document, so an
+// edit based on an older version is checked approximately. Edits that do not
+// grow the document always pass, so an oversized one can be cut down.
+func (r *Room) checkDocSizeLocked(e models.Edit) error {
+	if r.maxDocBytes <= 0 {
+		return nil
+	}
+	deleted := len(runeSlice(r.doc.Text, e.RangeStart, e.RangeEnd))
+	if len(e.Text) <= deleted {
+		return nil
+	}
+	if len(r.doc.Text)-deleted+len(e.Text) > r.maxDocBytes {
+		return ErrDocTooLarge
+	}
+	return nil
+}
+
+// runeSlice returns the runes [start, end) of s, clamped to its length
+func runeSlice(s string, start, end int) string {
+	from, to := len(s), len(s)
+	i := 0
+	for pos := range s {
+		if i == start {
+			from = pos
+		}
+		if i == end {
+			to = pos
+			break
+		}
+		i++
+	}
+	if from > to {
+		return ""
+	}
+	return s[from:to]
+}
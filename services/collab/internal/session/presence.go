@@ -0,0 +1,217 @@
+package session
+
+import (
+	"sort"
+	"time"
+
+	"collab/internal/models"
+)
+
+const (
+	// typingThrottle is the minimum gap between two typing changes relayed
+	// for the same user; a change inside the window is sent when it closes.
+	typingThrottle = time.Second
+	// typingIdleClear clears a typing indicator the client never turned off.
+	typingIdleClear = 5 * time.Second
+)
+
+// clock drives the presence timers; tests substitute a fake.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// typingState tracks one user's indicator. active is what the partner was
+// last told, want what the client last reported.
+type typingState struct {
+	active    bool
+	want      bool
+	changedAt time.Time
+	flush     func() bool // pending trailing relay
+	idle      func() bool // auto-clear timer
+	idleGen   int         // tells a stale auto-clear that lost the race to a restart
+}
+
+// SetTyping records the typing state reported by c and relays changes to the
+// partner, throttled per user. Typing frames are ephemeral: they are neither
+// kept in the run history nor recorded as activity.
+func (r *Room) SetTyping(c *Client, active bool) {
+	if c.UserID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.typing == nil {
+		r.typing = make(map[string]*typingState)
+	}
+	st := r.typing[c.UserID]
+	if st == nil {
+		st = &typingState{}
+		r.typing[c.UserID] = st
+	}
+
+	st.want = active
+	stopTimer(&st.idle)
+	if active {
+		userID, gen := c.UserID, st.idleGen+1
+		st.idleGen = gen
+		st.idle = r.clock.AfterFunc(typingIdleClear, func() { r.clearTyping(userID, st, gen) })
+	}
+
+	if st.want == st.active {
+		stopTimer(&st.flush)
+		return
+	}
+	if wait := typingThrottle - r.clock.Now().Sub(st.changedAt); wait > 0 {
+		if st.flush == nil {
+			userID := c.UserID
+			st.flush = r.clock.AfterFunc(wait, func() { r.flushTyping(userID, st) })
+		}
+		return
+	}
+	r.relayTypingLocked(c.UserID, st)
+}
+
+func (r *Room) flushTyping(userID string, st *typingState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.typing[userID] != st || st.flush == nil {
+		return
+	}
+	st.flush = nil
+	if st.want != st.active {
+		r.relayTypingLocked(userID, st)
+	}
+}
+
+// clearTyping turns the indicator off after typingIdleClear of silence, so a
+// crashed client is not shown typing forever.
+func (r *Room) clearTyping(userID string, st *typingState, gen int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.typing[userID] != st || st.idleGen != gen || st.idle == nil {
+		return
+	}
+	st.idle = nil
+	st.want = false
+	stopTimer(&st.flush)
+	if st.active {
+		r.relayTypingLocked(userID, st)
+	}
+}
+
+// dropTypingLocked forgets a user who left, telling the partner if they were
+// shown as typing.
+func (r *Room) dropTypingLocked(userID string) {
+	st := r.typing[userID]
+	if st == nil {
+		return
+	}
+	stopTimer(&st.flush)
+	stopTimer(&st.idle)
+	delete(r.typing, userID)
+	if st.active {
+		r.sendToOthersLocked(userID, models.WSFrame{Type: "typing", Data: models.Typing{UserID: userID, Active: false}})
+	}
+}
+
+func (r *Room) relayTypingLocked(userID string, st *typingState) {
+	st.active = st.want
+	st.changedAt = r.clock.Now()
+	r.sendToOthersLocked(userID, models.WSFrame{Type: "typing", Data: models.Typing{UserID: userID, Active: st.active}})
+}
+
+// sendToOthersLocked sends frame to every client of another user.
+func (r *Room) sendToOthersLocked(userID string, frame models.WSFrame) {
+	for c := range r.clients {
+		if c.UserID != userID {
+			c.Send(frame)
+		}
+	}
+	for c := range r.spectators {
+		c.Send(frame)
+	}
+}
+
+func stopTimer(stop *func() bool) {
+	if *stop != nil {
+		(*stop)()
+		*stop = nil
+	}
+}
+
+// Presence returns the typing and run state as seen by userID: their own
+// typing indicator is left out.
+func (r *Room) Presence(userID string) models.PresenceState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := models.PresenceState{Connected: r.connectedUsersLocked(), Typing: []string{}}
+	for id, st := range r.typing {
+		if st.active && id != userID {
+			state.Typing = append(state.Typing, id)
+		}
+	}
+	sort.Strings(state.Typing)
+	if r.runningBy != nil {
+		state.Run = &models.RunStatus{State: models.RunStarted, By: *r.runningBy}
+	}
+	return state
+}
+
+// ConnectedUsers lists the participants connected to the room, sorted and
+// each once.
+func (r *Room) ConnectedUsers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connectedUsersLocked()
+}
+
+// PublishOccupancy calls publish with the users connected now. The calls run
+// one at a time with the list taken inside, so the publishes of overlapping
+// joins and leaves land in order and the last one is current.
+func (r *Room) PublishOccupancy(publish func(userIDs []string)) {
+	r.occupancyMu.Lock()
+	defer r.occupancyMu.Unlock()
+	publish(r.ConnectedUsers())
+}
+
+// connectedUsersLocked lists the users with a connection to the room, each once
+func (r *Room) connectedUsersLocked() []string {
+	seen := make(map[string]bool)
+	connected := []string{}
+	for c := range r.clients {
+		if c.UserID != "" && !seen[c.UserID] {
+			seen[c.UserID] = true
+			connected = append(connected, c.UserID)
+		}
+	}
+	sort.Strings(connected)
+	return connected
+}
+
+// broadcastPresenceLocked tells every client but skip who is connected now.
+// The joining client is skipped since its init carries the same list.
+func (r *Room) broadcastPresenceLocked(skip *Client) {
+	frame := models.WSFrame{Type: "presence", Data: models.PresenceUpdate{
+		Connected: r.connectedUsersLocked(),
+		At:        r.clock.Now().UnixMilli(),
+	}}
+	for c := range r.clients {
+		if c != skip {
+			c.Send(frame)
+		}
+	}
+	for c := range r.spectators {
+		if c != skip {
+			c.Send(frame)
+		}
+	}
+}
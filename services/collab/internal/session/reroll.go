@@ -0,0 +1,64 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/models"
+)
+
+var (
+	ErrRerollPending   = errors.New("reroll_pending")
+	ErrNoPendingReroll = errors.New("no_pending_reroll")
+)
+
+type pendingReroll struct {
+	requester string
+	expiresAt time.Time
+}
+
+// ProposeReroll parks a question reroll until the partner approves it or
+// timeout passes. It reports agreed when the reroll should happen straight
+// away instead: nobody else is in the room, or the partner had proposed one
+// too.
+func (r *Room) ProposeReroll(userID string, timeout time.Duration) (proposal models.RerollProposal, agreed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.partnerLocked(userID) == "" {
+		r.pendingReroll = nil
+		return models.RerollProposal{}, true, nil
+	}
+	if p := r.pendingRerollLocked(); p != nil {
+		if p.requester == userID {
+			return models.RerollProposal{}, false, ErrRerollPending
+		}
+		r.pendingReroll = nil
+		return models.RerollProposal{}, true, nil
+	}
+	p := &pendingReroll{requester: userID, expiresAt: r.clock.Now().Add(timeout)}
+	r.pendingReroll = p
+	return models.RerollProposal{UserID: userID, ExpiresAt: p.expiresAt.UnixMilli()}, false, nil
+}
+
+// VoteReroll settles the pending reroll on behalf of the partner and returns
+// who proposed it. The proposer cannot vote on their own proposal.
+func (r *Room) VoteReroll(userID string) (requester string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.pendingRerollLocked()
+	if p == nil || userID == "" || p.requester == userID {
+		return "", ErrNoPendingReroll
+	}
+	r.pendingReroll = nil
+	return p.requester, nil
+}
+
+// pendingRerollLocked returns the proposed reroll, unless there is none or it
+// timed out
+func (r *Room) pendingRerollLocked() *pendingReroll {
+	if p := r.pendingReroll; p != nil && r.clock.Now().Before(p.expiresAt) {
+		return p
+	}
+	r.pendingReroll = nil
+	return nil
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"errors"
+
+	"collab/internal/models"
+)
+
+var (
+	ErrNotDriver     = errors.New("not_driver")
+	ErrAlreadyDriver = errors.New("already_driver")
+	ErrNoNavigator   = errors.New("no_navigator")
+	ErrRolesDisabled = errors.New("roles_disabled")
+)
+
+// roleState holds the driver and navigator of a room. Users keep their role
+// across reconnects; the first init decides whether the roles are enforced.
+type roleState struct {
+	driver     string
+	navigator  string
+	freeForAll bool
+	configured bool // set by the first ConfigureRoles
+}
+
+// assignRoleLocked makes the first participant to join the driver and the
+// second the navigator.
+func (r *Room) assignRoleLocked(userID string) {
+	switch {
+	case userID == "" || userID == r.roles.driver || userID == r.roles.navigator:
+	case r.roles.driver == "":
+		r.roles.driver = userID
+	case r.roles.navigator == "":
+		r.roles.navigator = userID
+	}
+}
+
+// ConfigureRoles turns role enforcement off for a free-for-all room. Only the
+// first call counts, so a later init cannot change the room's choice; it
+// reports whether this call decided it.
+func (r *Room) ConfigureRoles(freeForAll bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.roles.configured {
+		return false
+	}
+	r.roles.configured = true
+	r.roles.freeForAll = freeForAll
+	return true
+}
+
+// Roles returns the driver and navigator of the room.
+func (r *Room) Roles() models.Roles {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rolesLocked()
+}
+
+func (r *Room) rolesLocked() models.Roles {
+	return models.Roles{Driver: r.roles.driver, Navigator: r.roles.navigator, FreeForAll: r.roles.freeForAll}
+}
+
+// CheckDriver reports whether userID may edit: anyone in a free-for-all room,
+// otherwise only the driver. In turn-taking mode the turn decides instead,
+// see CheckTurn.
+func (r *Room) CheckDriver(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.roles.freeForAll || r.turns != nil || userID == r.roles.driver {
+		return nil
+	}
+	return ErrNotDriver
+}
+
+// RequestControl asks for the driver's seat on behalf of the navigator. When
+// the driver is not connected the navigator takes control straight away and
+// taken is set; otherwise the driver is left to grant it.
+func (r *Room) RequestControl(c *Client) (roles models.Roles, taken bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.roles.freeForAll:
+		return r.rolesLocked(), false, ErrRolesDisabled
+	case c.UserID == r.roles.driver:
+		return r.rolesLocked(), false, ErrAlreadyDriver
+	}
+	r.assignRoleLocked(c.UserID)
+	if c.UserID != r.roles.navigator {
+		return r.rolesLocked(), false, ErrNotDriver
+	}
+	if r.roles.driver != "" && r.connectedLocked(r.roles.driver) {
+		return r.rolesLocked(), false, nil
+	}
+	r.swapRolesLocked()
+	return r.rolesLocked(), true, nil
+}
+
+// GrantControl hands the driver's seat to the navigator. Only the driver may
+// grant it, whether or not it was asked for.
+func (r *Room) GrantControl(c *Client) (models.Roles, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.roles.freeForAll:
+		return r.rolesLocked(), ErrRolesDisabled
+	case c.UserID == "" || c.UserID != r.roles.driver:
+		return r.rolesLocked(), ErrNotDriver
+	case r.roles.navigator == "":
+		return r.rolesLocked(), ErrNoNavigator
+	}
+	r.swapRolesLocked()
+	return r.rolesLocked(), nil
+}
+
+func (r *Room) swapRolesLocked() {
+	r.roles.driver, r.roles.navigator = r.roles.navigator, r.roles.driver
+	r.recordActivityLocked("roles", r.roles.driver, "driver")
+}
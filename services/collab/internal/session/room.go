@@ -7,6 +7,7 @@ import (
 
 	"github.com/Jeffail/leaps/lib/text"
 
+	"collab/internal/metrics"
 	"collab/internal/models"
 )
 
@@ -14,17 +15,71 @@ import (
 type Room struct {
 	ID                string
 	mu                sync.Mutex
+	occupancyMu       sync.Mutex // orders PublishOccupancy calls, see there
 	clients           map[*Client]struct{}
 	doc               models.DocState
 	language          models.Language
+	execution         *models.ExecutionConfig
+	starterCode       map[models.Language]string
+	templateVersion   int64 // document version the last template left, 0 before any
 	otConf            text.OTBufferConfig
 	otBuffer          *text.OTBuffer
 	runHistory        []models.WSFrame
+	chat              []models.ChatMessage
+	chatReadMarks     map[string]int64
+	settings          models.RoomSettings
+	settingsChanged   bool // set once the live settings diverge from any snapshot
+	rubric            []models.RubricItem
+	rubricToggled     bool // set once a rubric item is ticked, guards RestoreRubric
+	threads           []models.Thread
+	threadsChanged    bool // set once a thread is opened, answered or resolved, guards RestoreThreadState
+	threadAIUsed      int  // assistant replies spent from the room's hint budget
+	hintQuestion      int  // question the hints in hintsUsed were for
+	hintsUsed         int  // hint_request answers spent on hintQuestion
+	pendingRestore    *pendingRestore
+	pendingMode       *pendingMode
+	pendingEnd        *pendingEnd
+	pendingReroll     *pendingReroll
+	turns             *turnState // nil in free mode
+	turnsChanged      bool       // set once the mode or turn changes, guards RestoreTurnState
+	driving           map[string]time.Duration
+	roles             roleState
+	undo              undoState
+	turnHandler       func(models.TurnState)
+	detached          bool // removed from the hub; clients no longer counted
+	activity          []models.ActivityEntry
+	typing            map[string]*typingState
+	runSeq            int
+	runningBy         *string                // user of the batch run in progress, nil when idle
+	runStarts         map[string][]time.Time // recent run starts per user, for the rate limit
+	runCooldown       time.Duration          // see WithRunCooldown
+	lastRunEnd        time.Time
+	queuedRun         *queuedRun  // run waiting for the run slot, nil when none
+	queuedStop        func() bool // stops the timer that starts queuedRun
+	clock             clock
+	lastActivity      time.Time
 	startedAt         time.Time
 	lastDisconnectAt  *time.Time
 	allDisconnected   bool
 	sessionEnded      bool
 	sessionEndHandler func(sessionID string, finalCode string, language models.Language, duration time.Duration)
+	docStore          DocStore // nil leaves the document in memory only
+	onDocError        func(matchID string, err error)
+	docWritePending   bool
+	snapshotStore     SnapshotStore // nil keeps no snapshots
+	snapshotEvery     int64
+	snapshotVersion   int64 // document version of the last snapshot
+	maxDocBytes       int   // see WithMaxDocBytes
+	editRate          int   // see WithEditRate
+	editBuckets       map[*Client]*editBucket
+	staleAfter        time.Duration // see WithStaleAfter
+	spectators        map[*Client]struct{}
+	maxSpectators     int                      // see WithMaxSpectators
+	colors            map[string]string        // cursor color per user
+	cursors           map[string]models.Cursor // last cursor per user
+	partnerGrace      time.Duration            // see WithPartnerGrace
+	graces            map[string]*graceTimer   // participants who dropped out, by user
+	abandonHandler    func(userID string)
 }
 
 const (
@@ -32,22 +87,36 @@ const (
 	maxTransformLength uint64 = 1 * 1024 * 1024
 )
 
-func NewRoom(id string) *Room {
+func NewRoom(id string, opts ...RoomOption) *Room {
 	cfg := text.NewOTBufferConfig()
 	cfg.MaxTransformLength = maxTransformLength
 	buf := text.NewOTBuffer("", cfg)
 	buf.Version = 0
 
-	return &Room{
+	r := &Room{
 		ID:              id,
 		clients:         make(map[*Client]struct{}),
 		doc:             models.DocState{Text: "", Version: 0},
 		language:        models.LangPython,
 		otConf:          cfg,
 		otBuffer:        buf,
+		chatReadMarks:   make(map[string]int64),
+		settings:        DefaultRoomSettings(),
+		clock:           realClock{},
 		startedAt:       time.Now(),
+		lastActivity:    time.Now(),
 		allDisconnected: false,
+		maxDocBytes:     defaultMaxDocBytes,
+		editRate:        defaultEditRate,
+		staleAfter:      defaultStaleAfter,
+		maxSpectators:   defaultMaxSpectators,
+		partnerGrace:    defaultPartnerGrace,
+		runCooldown:     defaultRunCooldown,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *Room) SetSessionEndHandler(handler func(sessionID string, finalCode string, language models.Language, duration time.Duration)) {
@@ -59,7 +128,16 @@ func (r *Room) SetSessionEndHandler(handler func(sessionID string, finalCode str
 func (r *Room) Join(c *Client) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, ok := r.clients[c]; !ok && !r.detached {
+		metrics.ConnectionOpened()
+	}
 	r.clients[c] = struct{}{}
+	r.assignColorLocked(c.UserID)
+	r.assignRoleLocked(c.UserID)
+	r.recordActivityLocked("join", c.UserID, "")
+	r.turnJoinedLocked(c)
+	r.broadcastPresenceLocked(c)
+	r.peerRejoinedLocked(c)
 
 	// Reset disconnect tracking if clients rejoin
 	if r.allDisconnected {
@@ -68,6 +146,19 @@ func (r *Room) Join(c *Client) {
 	}
 }
 
+// detach stops the room's clients counting towards the connection gauge once
+// the hub has dropped the room.
+func (r *Room) detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.detached {
+		return
+	}
+	r.detached = true
+	r.dropQueuedRunLocked()
+	metrics.ConnectionsClosed(len(r.clients) + len(r.spectators))
+}
+
 func (r *Room) GetClientCount() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -77,8 +168,29 @@ func (r *Room) GetClientCount() int {
 func (r *Room) Leave(c *Client) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.leaveSpectatorLocked(c) {
+		return len(r.clients)
+	}
+	if _, ok := r.clients[c]; ok && !r.detached {
+		metrics.ConnectionsClosed(1)
+	}
+	_, joined := r.clients[c]
+	if joined {
+		r.recordActivityLocked("leave", c.UserID, "")
+	}
 	delete(r.clients, c)
+	delete(r.editBuckets, c)
+	if c.UserID != "" {
+		r.dropTypingLocked(c.UserID)
+	}
+	if joined {
+		r.broadcastPresenceLocked(nil)
+		r.peerLeftLocked(c)
+	}
 	remaining := len(r.clients)
+	// A restore or mode change needs both participants present
+	r.pendingRestore = nil
+	r.turnLeftLocked(c)
 
 	// Track when all clients disconnect
 	if remaining == 0 && !r.allDisconnected && !r.sessionEnded {
@@ -97,12 +209,13 @@ func (r *Room) checkSessionEnd() {
 	time.Sleep(30 * time.Second)
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	abandoned := len(r.clients) == 0 && r.allDisconnected
+	r.mu.Unlock()
 
-	// If still no clients after 30 seconds, end the session
-	if len(r.clients) == 0 && r.allDisconnected && r.sessionEndHandler != nil {
-		duration := time.Since(r.startedAt)
-		r.sessionEndHandler(r.ID, r.doc.Text, r.language, duration)
+	// If still no clients after 30 seconds, end the session. The handler runs
+	// without r.mu held since it reads the room and removes it from the hub.
+	if abandoned {
+		r.EndSessionNow()
 	}
 }
 
@@ -115,24 +228,92 @@ func (r *Room) Snapshot() (models.DocState, models.Language) {
 func (r *Room) SetLanguage(l models.Language) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.language = l
+	if r.language != l {
+		r.language = l
+		r.docChangedLocked()
+	}
+}
+
+// SetExecutionConfig records the run constraints of the room's current question.
+func (r *Room) SetExecutionConfig(cfg *models.ExecutionConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.execution = cfg
+}
+
+func (r *Room) ExecutionConfig() *models.ExecutionConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.execution
+}
+
+// SetStarterCode records the starter code of the room's current question.
+func (r *Room) SetStarterCode(code map[models.Language]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starterCode = code
+}
+
+// StarterCode returns the question's starter code for lang, "" when it has none.
+func (r *Room) StarterCode(lang models.Language) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.starterCode[lang]
 }
 
 func (r *Room) BootstrapDoc(template string) models.DocState {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if r.doc.Text == "" && template != "" {
-		r.doc.Text = template
-		r.doc.Version++
-		r.resetOTBufferLocked()
+		r.setTemplateLocked(template)
 	}
 	return r.doc
 }
 
+// SwapTemplate replaces the document with template when nobody has edited it
+// since it was empty or since the last template went in, for when the room
+// switches language before any code was written. It also returns the
+// document it replaced, and reports whether it did.
+func (r *Room) SwapTemplate(template string) (prev, doc models.DocState, swapped bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev = r.doc
+	if template == "" || r.doc.Version != r.templateVersion || r.doc.Text == template {
+		return prev, r.doc, false
+	}
+	r.setTemplateLocked(template)
+	return prev, r.doc, true
+}
+
+func (r *Room) setTemplateLocked(template string) {
+	r.doc.Text = template
+	r.doc.Version++
+	r.templateVersion = r.doc.Version
+	r.resetOTBufferLocked()
+	r.docChangedLocked()
+}
+
 func (r *Room) ApplyEdit(e models.Edit) (bool, models.DocState, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.applyEditLocked("", e)
+}
 
+// ApplyEditFrom applies an edit of userID, which their Undo reverts, and also
+// returns the document the edit was applied to, for sending the change as a
+// delta.
+func (r *Room) ApplyEditFrom(userID string, e models.Edit) (bool, models.DocState, models.DocState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.doc
+	ok, doc, err := r.applyEditLocked(userID, e)
+	if ok {
+		r.pushUndoLocked(userID)
+	}
+	return ok, prev, doc, err
+}
+
+func (r *Room) applyEditLocked(userID string, e models.Edit) (bool, models.DocState, error) {
 	if e.BaseVersion > r.doc.Version {
 		return false, r.doc, errors.New("version_mismatch")
 	}
@@ -140,6 +321,9 @@ func (r *Room) ApplyEdit(e models.Edit) (bool, models.DocState, error) {
 	if e.RangeStart < 0 || e.RangeEnd < e.RangeStart {
 		return false, r.doc, errors.New("invalid_range")
 	}
+	if err := r.checkDocSizeLocked(e); err != nil {
+		return false, r.doc, err
+	}
 
 	ot := text.OTransform{
 		Version:  int(e.BaseVersion) + 1,
@@ -148,15 +332,23 @@ func (r *Room) ApplyEdit(e models.Edit) (bool, models.DocState, error) {
 		Insert:   e.Text,
 	}
 
-	if _, _, err := r.otBuffer.PushTransform(ot); err != nil {
+	applied, _, err := r.otBuffer.PushTransform(ot)
+	if err != nil {
 		return false, r.doc, err
 	}
+	deleted := ""
+	if runes := []rune(r.doc.Text); applied.Position+applied.Delete <= len(runes) {
+		deleted = string(runes[applied.Position : applied.Position+applied.Delete])
+	}
 
 	if _, err := r.otBuffer.FlushTransforms(&r.doc.Text, otRetentionSeconds); err != nil {
 		return false, r.doc, err
 	}
 
 	r.doc.Version = int64(r.otBuffer.GetVersion())
+	r.recordEditLocked(userID, applied.Position, deleted, applied.Insert)
+	r.docChangedLocked()
+	metrics.RecordEdit()
 
 	return true, r.doc, nil
 }
@@ -170,6 +362,27 @@ func (r *Room) Broadcast(sender *Client, frame models.WSFrame) {
 		}
 		c.Send(frame)
 	}
+	for c := range r.spectators {
+		if c != sender {
+			c.Send(frame)
+		}
+	}
+}
+
+// BroadcastDoc sends a document update to every client but sender, each in
+// the form it negotiated. prev is nil when the previous state is unknown.
+func (r *Room) BroadcastDoc(sender *Client, prev *models.DocState, doc models.DocState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for c := range r.clients {
+		if c == sender {
+			continue
+		}
+		c.SendDoc(prev, doc)
+	}
+	for c := range r.spectators {
+		c.SendDoc(prev, doc)
+	}
 }
 
 func (r *Room) BroadcastAll(frame models.WSFrame) {
@@ -178,6 +391,9 @@ func (r *Room) BroadcastAll(frame models.WSFrame) {
 	for c := range r.clients {
 		c.Send(frame)
 	}
+	for c := range r.spectators {
+		c.Send(frame)
+	}
 }
 
 func (r *Room) EndSessionNow() {
@@ -191,6 +407,7 @@ func (r *Room) EndSessionNow() {
 	lang := r.language
 	started := r.startedAt
 	r.sessionEnded = true
+	r.dropQueuedRunLocked()
 	r.mu.Unlock()
 
 	if handler != nil {
@@ -203,14 +420,90 @@ func (r *Room) resetOTBufferLocked() {
 	buf := text.NewOTBuffer(r.doc.Text, r.otConf)
 	buf.Version = int(r.doc.Version)
 	r.otBuffer = buf
+	r.resetUndoLocked()
 }
 
-func (r *Room) BeginRun() {
+var (
+	ErrRunInProgress  = errors.New("run_in_progress")
+	ErrRunRateLimited = errors.New("run_rate_limited")
+)
+
+const (
+	// runRateLimit caps the batch runs one user may start per runRateWindow
+	runRateLimit  = 10
+	runRateWindow = time.Minute
+)
+
+// StartRun begins a batch run by the given user unless one is already in
+// progress, the previous one ended less than the room's cooldown ago or the
+// user has used up their run budget. The WS and REST entry points both go
+// through it, so the guards hold across them.
+func (r *Room) StartRun(by string) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	frame := models.WSFrame{Type: "run_reset"}
+	return r.startRunLocked(by)
+}
+
+func (r *Room) startRunLocked(by string) (int, error) {
+	if r.runningBy != nil {
+		return 0, ErrRunInProgress
+	}
+	if r.cooldownLeftLocked() > 0 {
+		return 0, ErrRunCooldown
+	}
+	if r.runStarts == nil {
+		r.runStarts = make(map[string][]time.Time)
+	}
+	now := r.clock.Now()
+	recent := r.runStarts[by][:0]
+	for _, at := range r.runStarts[by] {
+		if now.Sub(at) < runRateWindow {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= runRateLimit {
+		r.runStarts[by] = recent
+		return 0, ErrRunRateLimited
+	}
+	r.runStarts[by] = append(recent, now)
+	return r.beginRunLocked(by), nil
+}
+
+// BeginRun resets the run history and announces a run by the given user,
+// without StartRun's guards. It returns the run's sequence number for EndRun.
+func (r *Room) BeginRun(by string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.beginRunLocked(by)
+}
+
+func (r *Room) beginRunLocked(by string) int {
+	r.runSeq++
+	frame := models.WSFrame{Type: "run_reset", Data: models.RunReset{Seq: r.runSeq}}
 	r.runHistory = []models.WSFrame{frame}
 	r.broadcastFrameLocked(frame)
+
+	r.runningBy = &by
+	r.broadcastFrameLocked(models.WSFrame{Type: "run_status", Data: models.RunStatus{State: models.RunStarted, By: by}})
+	return r.runSeq
+}
+
+// EndRun announces that run seq finished, unless a newer run has started
+// since, and starts the cooldown before the next one. run_status frames are
+// not kept in the run history.
+func (r *Room) EndRun(seq int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seq != r.runSeq || r.runningBy == nil {
+		return
+	}
+	by := *r.runningBy
+	r.runningBy = nil
+	r.lastRunEnd = r.clock.Now()
+	r.broadcastFrameLocked(models.WSFrame{Type: "run_status", Data: models.RunStatus{State: models.RunFinished, By: by}})
+	if r.queuedRun != nil {
+		r.queuedStop = r.clock.AfterFunc(r.cooldownLeftLocked(), r.startQueuedRun)
+	}
 }
 
 func (r *Room) RecordRunFrame(frame models.WSFrame) {
@@ -221,8 +514,19 @@ func (r *Room) RecordRunFrame(frame models.WSFrame) {
 }
 
 func (r *Room) ReplayRunHistory(c *Client) {
+	r.ReplayRunHistorySince(c, 0, 0)
+}
+
+// ReplayRunHistorySince sends the frames of the current run after the first
+// seen of run seq, or all of them when the client missed the start of the
+// current run.
+func (r *Room) ReplayRunHistorySince(c *Client, seq, seen int) {
 	r.mu.Lock()
-	history := append([]models.WSFrame(nil), r.runHistory...)
+	history := r.runHistory
+	if seq == r.runSeq && seen > 0 && seen <= len(history) {
+		history = history[seen:]
+	}
+	history = append([]models.WSFrame(nil), history...)
 	r.mu.Unlock()
 	for _, frame := range history {
 		c.Send(frame)
@@ -233,4 +537,7 @@ func (r *Room) broadcastFrameLocked(frame models.WSFrame) {
 	for client := range r.clients {
 		client.Send(frame)
 	}
+	for client := range r.spectators {
+		client.Send(frame)
+	}
 }
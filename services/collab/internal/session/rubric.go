@@ -0,0 +1,106 @@
+package session
+
+import (
+	"errors"
+
+	"collab/internal/models"
+)
+
+// ErrUnknownRubricItem rejects a rubric_check whose index is out of range.
+var ErrUnknownRubricItem = errors.New("unknown_rubric_item")
+
+// SetRubric installs the rubric of the room's current question. Ticks are
+// kept when the items are unchanged and reset otherwise, e.g. after a reroll.
+// It reports whether the rubric changed.
+func (r *Room) SetRubric(items []string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sameRubricTexts(r.rubric, items) {
+		return false
+	}
+	r.rubric = make([]models.RubricItem, len(items))
+	for i, text := range items {
+		r.rubric[i] = models.RubricItem{Text: text}
+	}
+	r.rubricToggled = false
+	return true
+}
+
+// ToggleRubric sets the checked state of one item on behalf of a user. changed
+// is false when the item already had that state, so nothing needs broadcasting.
+func (r *Room) ToggleRubric(by string, check models.RubricCheck) (models.RubricState, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if check.Index < 0 || check.Index >= len(r.rubric) {
+		return rubricStateLocked(r.rubric), false, ErrUnknownRubricItem
+	}
+	item := &r.rubric[check.Index]
+	if item.Checked == check.Checked {
+		return rubricStateLocked(r.rubric), false, nil
+	}
+	item.Checked = check.Checked
+	item.ToggledBy = by
+	item.ToggledAt = r.clock.Now().UnixMilli()
+	r.rubricToggled = true
+	return rubricStateLocked(r.rubric), true, nil
+}
+
+// Rubric returns the current checklist, or nil when the question has none.
+func (r *Room) Rubric() *models.RubricState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.rubric) == 0 {
+		return nil
+	}
+	state := rubricStateLocked(r.rubric)
+	return &state
+}
+
+// RubricResults returns a copy of the rubric items for the session record.
+func (r *Room) RubricResults() []models.RubricItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.rubric) == 0 {
+		return nil
+	}
+	return append([]models.RubricItem(nil), r.rubric...)
+}
+
+// RestoreRubric seeds the ticks from a persisted snapshot. It is a no-op when
+// the snapshot is for other items or the live rubric was already toggled.
+func (r *Room) RestoreRubric(items []models.RubricItem) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rubricToggled || len(items) != len(r.rubric) {
+		return false
+	}
+	for i := range items {
+		if items[i].Text != r.rubric[i].Text {
+			return false
+		}
+	}
+	r.rubric = append([]models.RubricItem(nil), items...)
+	return true
+}
+
+func rubricStateLocked(items []models.RubricItem) models.RubricState {
+	state := models.RubricState{Items: append([]models.RubricItem{}, items...)}
+	for _, item := range items {
+		if !item.Checked {
+			state.Unchecked++
+		}
+	}
+	return state
+}
+
+func sameRubricTexts(items []models.RubricItem, texts []string) bool {
+	if len(items) != len(texts) {
+		return false
+	}
+	for i := range items {
+		if items[i].Text != texts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/models"
+)
+
+// defaultRunCooldown is how long a room waits after a batch run ends before
+// the next one may start
+const defaultRunCooldown = 2 * time.Second
+
+var ErrRunCooldown = errors.New("run_cooldown")
+
+// WithRunCooldown makes the room wait d after a batch run before starting
+// another; 0 lets the next run start right away.
+func WithRunCooldown(d time.Duration) RoomOption {
+	return func(r *Room) { r.runCooldown = d }
+}
+
+// queuedRun is a batch run waiting for the room's run slot
+type queuedRun struct {
+	by     string
+	run    func()
+	reject func(error)
+}
+
+// QueueRun starts a batch run by the given user like StartRun, calling run on
+// its own goroutine and ending the run when it returns. While another run is
+// in progress or already waiting, the run waits for the slot instead, in
+// place of any run that waited before it, and queued is set. A waiting run
+// starts once the run before it and the cooldown after it are over; reject
+// is called instead when the user's run budget is used up by then.
+func (r *Room) QueueRun(by string, run func(), reject func(error)) (queued bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runningBy != nil || r.queuedRun != nil {
+		r.queuedRun = &queuedRun{by: by, run: run, reject: reject}
+		r.broadcastFrameLocked(models.WSFrame{Type: "run_status", Data: models.RunStatus{State: models.RunQueued, By: by}})
+		return true, nil
+	}
+	seq, err := r.startRunLocked(by)
+	if err != nil {
+		return false, err
+	}
+	go r.runAndEnd(seq, run)
+	return false, nil
+}
+
+// startQueuedRun starts the waiting run once the cooldown is over. A run that
+// got the slot in the meantime starts it again when it ends.
+func (r *Room) startQueuedRun() {
+	r.mu.Lock()
+	q := r.queuedRun
+	if q == nil || r.runningBy != nil {
+		r.mu.Unlock()
+		return
+	}
+	if r.sessionEnded || r.detached {
+		r.dropQueuedRunLocked()
+		r.mu.Unlock()
+		return
+	}
+	if left := r.cooldownLeftLocked(); left > 0 {
+		r.queuedStop = r.clock.AfterFunc(left, r.startQueuedRun)
+		r.mu.Unlock()
+		return
+	}
+	r.queuedRun, r.queuedStop = nil, nil
+	seq, err := r.startRunLocked(q.by)
+	r.mu.Unlock()
+	if err != nil {
+		q.reject(err)
+		return
+	}
+	r.runAndEnd(seq, q.run)
+}
+
+// dropQueuedRunLocked forgets the waiting run, once the room is over
+func (r *Room) dropQueuedRunLocked() {
+	if r.queuedStop != nil {
+		r.queuedStop()
+	}
+	r.queuedRun, r.queuedStop = nil, nil
+}
+
+func (r *Room) runAndEnd(seq int, run func()) {
+	defer r.EndRun(seq)
+	run()
+}
+
+func (r *Room) cooldownLeftLocked() time.Duration {
+	if r.lastRunEnd.IsZero() {
+		return 0
+	}
+	return max(r.runCooldown-r.clock.Now().Sub(r.lastRunEnd), 0)
+}
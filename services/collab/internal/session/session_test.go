@@ -1,11 +1,19 @@
 package session
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Jeffail/leaps/lib/text"
 	"github.com/gorilla/websocket"
@@ -134,6 +142,27 @@ func TestRoomBootstrapDoc(t *testing.T) {
 	}
 }
 
+func TestRoomSwapTemplate(t *testing.T) {
+	room := NewRoom("session")
+	if _, doc, swapped := room.SwapTemplate("first"); !swapped || doc.Text != "first" || doc.Version != 1 {
+		t.Fatalf("expected an empty doc to take the template, got %#v swapped=%v", doc, swapped)
+	}
+	prev, doc, swapped := room.SwapTemplate("second")
+	if !swapped || prev.Text != "first" || doc.Text != "second" || doc.Version != 2 {
+		t.Fatalf("expected the untouched template to be swapped, got %#v -> %#v swapped=%v", prev, doc, swapped)
+	}
+	if _, _, swapped := room.SwapTemplate("second"); swapped {
+		t.Fatal("swapping in the same template should do nothing")
+	}
+
+	if ok, _, err := room.ApplyEdit(models.Edit{BaseVersion: 2, RangeStart: 0, RangeEnd: 0, Text: "x"}); !ok {
+		t.Fatalf("edit failed: %v", err)
+	}
+	if _, doc, swapped := room.SwapTemplate("third"); swapped || doc.Text != "xsecond" {
+		t.Fatalf("an edited doc must be kept, got %#v swapped=%v", doc, swapped)
+	}
+}
+
 func TestRoomApplyEditSuccess(t *testing.T) {
 	room := NewRoom("r")
 	ok, doc, err := room.ApplyEdit(models.Edit{
@@ -220,18 +249,18 @@ func TestRoomBroadcast(t *testing.T) {
 	frame := models.WSFrame{Type: "chat", Data: "hello"}
 
 	c1 := NewClient(nil)
-	cap1 := newFrameCapture()
-	c1.SetSendHook(cap1.hook)
 	c2 := NewClient(nil)
-	cap2 := newFrameCapture()
-	c2.SetSendHook(cap2.hook)
 	sender := NewClient(nil)
-	sender.SetSendHook(func(models.WSFrame) { t.Fatal("sender should not receive broadcast") })
-
 	room.Join(c1)
 	room.Join(c2)
 	room.Join(sender)
 
+	cap1 := newFrameCapture()
+	c1.SetSendHook(cap1.hook)
+	cap2 := newFrameCapture()
+	c2.SetSendHook(cap2.hook)
+	sender.SetSendHook(func(models.WSFrame) { t.Fatal("sender should not receive broadcast") })
+
 	room.Broadcast(sender, frame)
 
 	if got := cap1.list(); len(got) != 1 || got[0].Type != "chat" {
@@ -247,15 +276,15 @@ func TestRoomBroadcastAll(t *testing.T) {
 	frame := models.WSFrame{Type: "ping"}
 
 	c1 := NewClient(nil)
+	c2 := NewClient(nil)
+	room.Join(c1)
+	room.Join(c2)
+
 	cap1 := newFrameCapture()
 	c1.SetSendHook(cap1.hook)
-	c2 := NewClient(nil)
 	cap2 := newFrameCapture()
 	c2.SetSendHook(cap2.hook)
 
-	room.Join(c1)
-	room.Join(c2)
-
 	room.BroadcastAll(frame)
 
 	if len(cap1.list()) != 1 || len(cap2.list()) != 1 {
@@ -267,23 +296,23 @@ func TestRoomRunHistoryLifecycle(t *testing.T) {
 	room := NewRoom("r")
 
 	c1 := NewClient(nil)
-	cap1 := newFrameCapture()
-	c1.SetSendHook(cap1.hook)
 	room.Join(c1)
-
 	c2 := NewClient(nil)
+	room.Join(c2)
+
+	cap1 := newFrameCapture()
+	c1.SetSendHook(cap1.hook)
 	cap2 := newFrameCapture()
 	c2.SetSendHook(cap2.hook)
-	room.Join(c2)
 
-	room.BeginRun()
+	room.BeginRun("u1")
 	runFrame := models.WSFrame{Type: "stdout", Data: "output"}
 	room.RecordRunFrame(runFrame)
 
-	if got := cap1.list(); len(got) != 2 || got[0].Type != "run_reset" || got[1] != runFrame {
+	if got := cap1.list(); len(got) != 3 || got[0].Type != "run_reset" || got[1].Type != "run_status" || got[2] != runFrame {
 		t.Fatalf("unexpected run history for client1: %#v", got)
 	}
-	if got := cap2.list(); len(got) != 2 || got[0].Type != "run_reset" || got[1] != runFrame {
+	if got := cap2.list(); len(got) != 3 || got[0].Type != "run_reset" || got[1].Type != "run_status" || got[2] != runFrame {
 		t.Fatalf("unexpected run history for client2: %#v", got)
 	}
 
@@ -297,6 +326,83 @@ func TestRoomRunHistoryLifecycle(t *testing.T) {
 	}
 }
 
+func TestRoomReplayRunHistorySince(t *testing.T) {
+	room := NewRoom("r")
+	room.BeginRun("u1")
+	room.RecordRunFrame(models.WSFrame{Type: "stdout", Data: "a"})
+	room.RecordRunFrame(models.WSFrame{Type: "exit"})
+
+	replay := func(seq, seen int) []string {
+		c := NewClient(nil)
+		capture := newFrameCapture()
+		c.SetSendHook(capture.hook)
+		room.ReplayRunHistorySince(c, seq, seen)
+		var types []string
+		for _, f := range capture.list() {
+			types = append(types, f.Type)
+		}
+		return types
+	}
+	if got := replay(1, 2); strings.Join(got, ",") != "exit" {
+		t.Fatalf("expected the frames after the second, got %v", got)
+	}
+	if got := replay(1, 3); len(got) != 0 {
+		t.Fatalf("expected nothing for a client that saw the whole run, got %v", got)
+	}
+	for _, stale := range [][2]int{{0, 2}, {1, 9}} {
+		if got := replay(stale[0], stale[1]); strings.Join(got, ",") != "run_reset,stdout,exit" {
+			t.Fatalf("expected the whole run for markers %v, got %v", stale, got)
+		}
+	}
+}
+
+func TestRoomChatSince(t *testing.T) {
+	room := NewRoom("r")
+	for i := 0; i < maxChatReplay+10; i++ {
+		room.AddChatMessage("u1", fmt.Sprint(i))
+	}
+	if got := room.ChatSince(55); len(got) != 5 || got[0].ID != 56 {
+		t.Fatalf("expected the messages after 55, got %+v", got)
+	}
+	if got := room.ChatSince(0); len(got) != maxChatReplay || got[0].ID != 11 {
+		t.Fatalf("expected the last %d messages, got %d from %d", maxChatReplay, len(got), got[0].ID)
+	}
+}
+
+func TestRoomChatMessageIsTruncated(t *testing.T) {
+	room := NewRoom("r")
+	if msg := room.AddChatMessage("u1", strings.Repeat("a", maxChatMessageRunes)); msg.Truncated || len(msg.Message) != maxChatMessageRunes {
+		t.Fatalf("expected a message at the limit to be kept whole, got %d truncated=%v", len(msg.Message), msg.Truncated)
+	}
+	msg := room.AddChatMessage("u1", strings.Repeat("日", maxChatMessageRunes+1))
+	if !msg.Truncated || utf8.RuneCountInString(msg.Message) != maxChatMessageRunes || !strings.HasSuffix(msg.Message, "…") {
+		t.Fatalf("expected the message cut to %d characters, got %d truncated=%v", maxChatMessageRunes, utf8.RuneCountInString(msg.Message), msg.Truncated)
+	}
+	if stored := room.ChatState().Messages[1]; stored.Message != msg.Message || !stored.Truncated {
+		t.Fatalf("expected the truncated message to be stored, got %+v", stored)
+	}
+}
+
+func TestRoomChatHistoryIsCapped(t *testing.T) {
+	room := NewRoom("r")
+	for i := 0; i < maxChatHistory+10; i++ {
+		room.AddChatMessage("u1", fmt.Sprint(i))
+	}
+	state := room.ChatState()
+	if len(state.Messages) != maxChatHistory || state.Messages[0].ID != 11 {
+		t.Fatalf("expected the last %d messages, got %d from %d", maxChatHistory, len(state.Messages), state.Messages[0].ID)
+	}
+
+	// A longer persisted history is trimmed on restore
+	state.Messages = append(state.Messages, models.ChatMessage{ID: 300, UserID: "u2", Message: "late"})
+	restored := NewRoom("r2")
+	restored.RestoreChatState(state)
+	got := restored.ChatState().Messages
+	if len(got) != maxChatHistory || got[0].ID != 12 || got[len(got)-1].ID != 300 {
+		t.Fatalf("expected the newest %d messages restored, got %d from %d", maxChatHistory, len(got), got[0].ID)
+	}
+}
+
 func TestHubLifecycle(t *testing.T) {
 	hub := NewHub()
 	roomA := hub.GetOrCreate("a")
@@ -322,3 +428,1689 @@ func TestHubLifecycle(t *testing.T) {
 		t.Fatalf("expected room to be deleted")
 	}
 }
+
+// memDocStore is a DocStore that counts its writes
+type memDocStore struct {
+	mu      sync.Mutex
+	docs    map[string]models.DocSnapshot
+	saves   int
+	loadErr error
+}
+
+func (s *memDocStore) SaveDoc(matchID string, doc models.DocSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.docs == nil {
+		s.docs = make(map[string]models.DocSnapshot)
+	}
+	s.docs[matchID] = doc
+	s.saves++
+	return nil
+}
+
+func (s *memDocStore) LoadDoc(matchID string) (*models.DocSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	doc, ok := s.docs[matchID]
+	if !ok {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+func TestRoomPersistsDocAtMostEveryInterval(t *testing.T) {
+	store := &memDocStore{}
+	hub := NewHub()
+	hub.SetDocStore(store, nil)
+	clk := newFakeClock()
+	room := hub.GetOrCreate("a")
+	room.clock = clk
+
+	for i, insert := range []string{"a", "b", "c"} {
+		if ok, _, err := room.ApplyEdit(models.Edit{BaseVersion: int64(i), RangeStart: i, RangeEnd: i, Text: insert}); !ok {
+			t.Fatalf("edit %d failed: %v", i, err)
+		}
+	}
+	if store.saves != 0 {
+		t.Fatalf("expected no write before the interval, got %d", store.saves)
+	}
+	clk.Advance(docPersistInterval)
+	want := models.DocSnapshot{Text: "abc", Version: 3, Language: models.LangPython}
+	if store.saves != 1 || store.docs["a"] != want {
+		t.Fatalf("expected one write of %+v, got %d writes of %+v", want, store.saves, store.docs["a"])
+	}
+
+	room.SetLanguage(models.LangJava)
+	clk.Advance(docPersistInterval)
+	if store.saves != 2 || store.docs["a"].Language != models.LangJava {
+		t.Fatalf("expected the language change to be written, got %d writes of %+v", store.saves, store.docs["a"])
+	}
+	clk.Advance(docPersistInterval)
+	if store.saves != 2 {
+		t.Fatalf("expected no write without a change, got %d", store.saves)
+	}
+}
+
+// memSnapshotStore is a SnapshotStore that keeps every snapshot
+type memSnapshotStore struct {
+	mu    sync.Mutex
+	snaps []models.Snapshot
+}
+
+func (s *memSnapshotStore) AddSnapshot(matchID string, snap models.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snaps = append(s.snaps, snap)
+	return nil
+}
+
+func TestRoomSnapshotsEveryFewVersions(t *testing.T) {
+	store := &memSnapshotStore{}
+	hub := NewHub()
+	hub.SetSnapshotStore(store, 3)
+	clk := newFakeClock()
+	room := hub.GetOrCreate("a")
+	room.clock = clk
+
+	edit := func(v int) {
+		t.Helper()
+		if ok, _, err := room.ApplyEdit(models.Edit{BaseVersion: int64(v), RangeStart: v, RangeEnd: v, Text: "x"}); !ok {
+			t.Fatalf("edit %d failed: %v", v, err)
+		}
+		clk.Advance(docPersistInterval)
+	}
+	for v := 0; v < 2; v++ {
+		edit(v)
+	}
+	if len(store.snaps) != 0 {
+		t.Fatalf("expected no snapshot before 3 versions, got %+v", store.snaps)
+	}
+	edit(2)
+	if len(store.snaps) != 1 || store.snaps[0].Version != 3 || store.snaps[0].Text != "xxx" || store.snaps[0].Timestamp != clk.Now().UnixMilli() {
+		t.Fatalf("expected a snapshot at version 3, got %+v", store.snaps)
+	}
+
+	// Edits coalesced into one write still count every version
+	for v := 3; v < 7; v++ {
+		if ok, _, err := room.ApplyEdit(models.Edit{BaseVersion: int64(v), RangeStart: v, RangeEnd: v, Text: "y"}); !ok {
+			t.Fatalf("edit %d failed: %v", v, err)
+		}
+	}
+	clk.Advance(docPersistInterval)
+	if len(store.snaps) != 2 || store.snaps[1].Version != 7 {
+		t.Fatalf("expected a second snapshot at version 7, got %+v", store.snaps)
+	}
+	edit(7)
+	if len(store.snaps) != 2 {
+		t.Fatalf("expected the count to start over after a snapshot, got %+v", store.snaps)
+	}
+}
+
+func TestHubHydratesRoomFromDocStore(t *testing.T) {
+	store := &memDocStore{docs: map[string]models.DocSnapshot{"a": {Text: "x = 1", Version: 4, Language: models.LangJava}}}
+	hub := NewHub()
+	hub.SetDocStore(store, nil)
+
+	room := hub.GetOrCreate("a")
+	doc, lang := room.Snapshot()
+	if doc.Text != "x = 1" || doc.Version != 4 || lang != models.LangJava {
+		t.Fatalf("expected the stored document, got %+v %s", doc, lang)
+	}
+	// Edits continue from the restored version
+	if ok, doc, err := room.ApplyEdit(models.Edit{BaseVersion: 4, RangeStart: 5, RangeEnd: 5, Text: "0"}); !ok || doc.Text != "x = 10" || doc.Version != 5 {
+		t.Fatalf("unexpected edit result %+v err=%v", doc, err)
+	}
+
+	store.loadErr = errors.New("redis down")
+	var failed []string
+	hub.SetDocStore(store, func(matchID string, err error) { failed = append(failed, matchID) })
+	if doc, _ := hub.GetOrCreate("b").Snapshot(); doc.Text != "" || doc.Version != 0 {
+		t.Fatalf("expected an empty document when the store fails, got %+v", doc)
+	}
+	if len(failed) != 1 || failed[0] != "b" {
+		t.Fatalf("expected the failed load to be reported, got %v", failed)
+	}
+}
+
+func TestRoomChatReactionsRoundTrip(t *testing.T) {
+	room := NewRoom("chat")
+	first := room.AddChatMessage("u1", "run it now?")
+	second := room.AddChatMessage("u2", "done")
+	if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("expected sequential ids, got %d and %d", first.ID, second.ID)
+	}
+
+	reactions, err := room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "add"})
+	if err != nil || len(reactions["👍"]) != 1 || reactions["👍"][0] != "u2" {
+		t.Fatalf("unexpected reactions after add: %#v err=%v", reactions, err)
+	}
+	// adding the same reaction twice is idempotent
+	reactions, _ = room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "add"})
+	if len(reactions["👍"]) != 1 {
+		t.Fatalf("expected duplicate add to be ignored, got %#v", reactions)
+	}
+	reactions, _ = room.ReactToChat("u1", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "add"})
+	if len(reactions["👍"]) != 2 {
+		t.Fatalf("expected two reactors, got %#v", reactions)
+	}
+
+	reactions, err = room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "remove"})
+	if err != nil || len(reactions["👍"]) != 1 || reactions["👍"][0] != "u1" {
+		t.Fatalf("unexpected reactions after remove: %#v err=%v", reactions, err)
+	}
+	reactions, _ = room.ReactToChat("u1", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "remove"})
+	if _, ok := reactions["👍"]; ok {
+		t.Fatalf("expected emoji dropped once nobody reacts, got %#v", reactions)
+	}
+
+	if state := room.ChatState(); len(state.Messages[0].Reactions) != 0 {
+		t.Fatalf("expected no stored reactions, got %#v", state.Messages[0].Reactions)
+	}
+}
+
+func TestRoomChatReactionValidation(t *testing.T) {
+	room := NewRoom("chat")
+	room.AddChatMessage("u1", "hi")
+
+	if _, err := room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: "🦄", Action: "add"}); err != ErrEmojiNotAllowed {
+		t.Fatalf("expected allowlist rejection, got %v", err)
+	}
+	if _, err := room.ReactToChat("u2", models.ChatReaction{MessageID: 42, Emoji: "👍", Action: "add"}); err != ErrUnknownMessage {
+		t.Fatalf("expected unknown message, got %v", err)
+	}
+	if _, err := room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: "👍", Action: "toggle"}); err != ErrInvalidReactionOp {
+		t.Fatalf("expected invalid op, got %v", err)
+	}
+
+	added := 0
+	for emoji := range allowedReactions {
+		_, err := room.ReactToChat("u2", models.ChatReaction{MessageID: 1, Emoji: emoji, Action: "add"})
+		if added < maxReactionsPerMessage {
+			if err != nil {
+				t.Fatalf("unexpected error adding %s: %v", emoji, err)
+			}
+			added++
+			continue
+		}
+		if err != ErrTooManyReactions {
+			t.Fatalf("expected distinct emoji cap, got %v", err)
+		}
+	}
+}
+
+func TestRoomChatReadMarksAreMonotonic(t *testing.T) {
+	room := NewRoom("chat")
+	for i := 0; i < 3; i++ {
+		room.AddChatMessage("u1", "msg")
+	}
+
+	if mark, changed, err := room.MarkChatRead("u2", 2); err != nil || !changed || mark != 2 {
+		t.Fatalf("expected mark to advance to 2, got %d %v %v", mark, changed, err)
+	}
+	if mark, changed, err := room.MarkChatRead("u2", 1); err != nil || changed || mark != 2 {
+		t.Fatalf("expected mark to stay at 2, got %d %v %v", mark, changed, err)
+	}
+	if _, _, err := room.MarkChatRead("u2", 9); err != ErrUnknownMessage {
+		t.Fatalf("expected unknown message, got %v", err)
+	}
+	if mark, changed, _ := room.MarkChatRead("u2", 3); !changed || mark != 3 {
+		t.Fatalf("expected mark to advance to 3, got %d %v", mark, changed)
+	}
+}
+
+func TestRoomRestoreChatState(t *testing.T) {
+	state := models.ChatState{
+		Messages: []models.ChatMessage{
+			{ID: 2, UserID: "u2", Message: "done"},
+			{ID: 1, UserID: "u1", Message: "run it?", Reactions: map[string][]string{"✅": {"u2"}}},
+		},
+		ReadMarks: map[string]int64{"u1": 2},
+	}
+
+	room := NewRoom("chat")
+	if !room.RestoreChatState(state) {
+		t.Fatalf("expected restore into empty room")
+	}
+	if room.RestoreChatState(models.ChatState{}) {
+		t.Fatalf("restore must not overwrite live chat")
+	}
+	if next := room.AddChatMessage("u1", "again"); next.ID != 3 {
+		t.Fatalf("expected ids to continue after restore, got %d", next.ID)
+	}
+
+	got := room.ChatState()
+	if got.Messages[0].ID != 1 || got.Messages[0].Reactions["✅"][0] != "u2" || got.ReadMarks["u1"] != 2 {
+		t.Fatalf("unexpected restored state: %#v", got)
+	}
+	if _, changed, _ := room.MarkChatRead("u1", 1); changed {
+		t.Fatalf("restored read mark must not move backward")
+	}
+}
+
+func TestRoomSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes map[string]any
+		key     string
+	}{
+		{"tab size outside domain", map[string]any{"tabSize": float64(3)}, "tabSize"},
+		{"fractional tab size", map[string]any{"tabSize": 4.5}, "tabSize"},
+		{"tab size as string", map[string]any{"tabSize": "4"}, "tabSize"},
+		{"auto run as string", map[string]any{"autoRunOnSave": "true"}, "autoRunOnSave"},
+		{"unknown keymap", map[string]any{"keymap": "nano"}, "keymap"},
+		{"unknown theme", map[string]any{"theme": "solarized"}, "theme"},
+		{"unknown key", map[string]any{"fontSize": float64(14)}, "fontSize"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom("settings")
+			_, _, err := room.UpdateSettings(tt.changes)
+			var settingErr *SettingError
+			if !errors.As(err, &settingErr) || settingErr.Key != tt.key || len(settingErr.Allowed) == 0 {
+				t.Fatalf("expected invalid_setting for %s, got %v", tt.key, err)
+			}
+			if room.Settings() != DefaultRoomSettings() {
+				t.Fatalf("rejected update must not change settings: %#v", room.Settings())
+			}
+		})
+	}
+
+	room := NewRoom("settings")
+	settings, changed, err := room.UpdateSettings(map[string]any{
+		"tabSize": float64(8), "autoRunOnSave": true, "keymap": "vim", "theme": "high_contrast",
+	})
+	if err != nil {
+		t.Fatalf("valid update rejected: %v", err)
+	}
+	want := models.RoomSettings{TabSize: 8, AutoRunOnSave: true, Keymap: "vim", Theme: "high_contrast"}
+	if settings != want || strings.Join(changed, ",") != "autoRunOnSave,keymap,tabSize,theme" {
+		t.Fatalf("unexpected update result: %#v changed=%v", settings, changed)
+	}
+
+	// An update mixing valid and invalid entries is rejected as a whole
+	if _, _, err := room.UpdateSettings(map[string]any{"tabSize": float64(2), "theme": "neon"}); err == nil {
+		t.Fatalf("expected mixed update to be rejected")
+	}
+	if _, changed, _ := room.UpdateSettings(map[string]any{"tabSize": float64(8)}); len(changed) != 0 {
+		t.Fatalf("re-applying a value is not a change, got %v", changed)
+	}
+	if room.Settings() != want {
+		t.Fatalf("unexpected settings: %#v", room.Settings())
+	}
+}
+
+func TestRoomRestoreSettings(t *testing.T) {
+	persisted := models.RoomSettings{TabSize: 2, Keymap: "emacs", Theme: "dark"}
+
+	room := NewRoom("settings")
+	if !room.RestoreSettings(persisted) || room.Settings() != persisted {
+		t.Fatalf("expected snapshot to seed the room, got %#v", room.Settings())
+	}
+	if _, _, err := room.UpdateSettings(map[string]any{"tabSize": float64(4)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if room.RestoreSettings(persisted) {
+		t.Fatalf("restore must not roll back live settings")
+	}
+	if NewRoom("other").Settings() != DefaultRoomSettings() {
+		t.Fatalf("new rooms start from the defaults")
+	}
+}
+
+func TestResolveDraftRestoreReplacesDoc(t *testing.T) {
+	room := NewRoom("room1")
+	alice, bob := &Client{UserID: "u1"}, &Client{UserID: "u2"}
+	room.Join(alice)
+	if err := room.RequestDraftRestore(alice, models.Draft{Text: "draft"}); !errors.Is(err, ErrPartnerUnavailable) {
+		t.Fatalf("expected partner_unavailable, got %v", err)
+	}
+	room.Join(bob)
+	if _, _, err := room.ApplyEdit(models.Edit{BaseVersion: 0, Text: "héllo wörld"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+
+	if err := room.RequestDraftRestore(alice, models.Draft{Text: "draft"}); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if err := room.RequestDraftRestore(bob, models.Draft{Text: "other"}); !errors.Is(err, ErrRestorePending) {
+		t.Fatalf("expected restore_pending, got %v", err)
+	}
+	if _, _, _, err := room.ResolveDraftRestore(alice, true); !errors.Is(err, ErrNoPendingRestore) {
+		t.Fatalf("requester must not confirm, got %v", err)
+	}
+
+	requester, _, doc, err := room.ResolveDraftRestore(bob, true)
+	if err != nil || requester != alice {
+		t.Fatalf("resolve: %v", err)
+	}
+	if doc.Text != "draft" || doc.Version != 2 {
+		t.Fatalf("expected whole doc replaced at version 2, got %#v", doc)
+	}
+	if _, _, _, err := room.ResolveDraftRestore(bob, true); !errors.Is(err, ErrNoPendingRestore) {
+		t.Fatalf("restore must apply once, got %v", err)
+	}
+}
+
+func TestRoomActivityLogFoldsAndIsBounded(t *testing.T) {
+	room := NewRoom("s1")
+	room.RecordActivity("edit", "u1", "")
+	room.RecordActivity("edit", "u1", "")
+	room.RecordActivity("edit", "u2", "")
+
+	activity := room.Activity()
+	if len(activity) != 2 || activity[0].Count != 2 || activity[1].UserID != "u2" {
+		t.Fatalf("expected consecutive events to fold, got %+v", activity)
+	}
+
+	for i := 0; i < 2*maxActivityEntries; i++ {
+		room.RecordActivity("language", "u1", fmt.Sprint(i))
+	}
+	activity = room.Activity()
+	if len(activity) != maxActivityEntries || activity[len(activity)-1].Detail != fmt.Sprint(2*maxActivityEntries-1) {
+		t.Fatalf("expected the newest %d entries, got %d ending %+v", maxActivityEntries, len(activity), activity[len(activity)-1])
+	}
+}
+
+func TestRoomDebugSnapshotOmitsContent(t *testing.T) {
+	room := NewRoom("s1")
+	room.BootstrapDoc("def secret(): pass")
+	room.AddChatMessage("u1", "hello")
+
+	snap := room.DebugSnapshot(time.Now())
+	if snap.DocBytes != len("def secret(): pass") || snap.DocVersion != 1 || snap.Run.Active {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	room.BeginRun("u1")
+	if !room.DebugSnapshot(time.Now()).Run.Active {
+		t.Fatal("expected run to be active until its exit frame")
+	}
+	room.RecordRunFrame(models.WSFrame{Type: "exit"})
+	if room.DebugSnapshot(time.Now()).Run.Active {
+		t.Fatal("expected run to finish with its exit frame")
+	}
+}
+
+// fakeClock fires AfterFunc callbacks when Advance moves past their deadline.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1_700_000_000, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		pending := !timer.done
+		timer.done = true
+		return pending
+	}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, timer := range c.timers {
+		if !timer.done && !timer.at.After(c.now) {
+			timer.done = true
+			due = append(due, timer)
+		}
+	}
+	c.mu.Unlock()
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func presenceRoom() (*Room, *fakeClock, [2]*Client, [2]*frameCapture) {
+	room := NewRoom("s1")
+	clk := newFakeClock()
+	room.clock = clk
+	var clients [2]*Client
+	var caps [2]*frameCapture
+	for i, user := range []string{"u1", "u2"} {
+		clients[i] = NewClient(nil)
+		clients[i].UserID = user
+		room.Join(clients[i])
+	}
+	// Capture from here, past the presence frames of the joins
+	for i := range clients {
+		caps[i] = newFrameCapture()
+		clients[i].SetSendHook(caps[i].hook)
+	}
+	return room, clk, clients, caps
+}
+
+func TestRoomBroadcastsPresence(t *testing.T) {
+	room := NewRoom("s1")
+	clk := newFakeClock()
+	room.clock = clk
+	alice, bob := NewClient(nil), NewClient(nil)
+	alice.UserID, bob.UserID = "u1", "u2"
+	aliceCap, bobCap := newFrameCapture(), newFrameCapture()
+	alice.SetSendHook(aliceCap.hook)
+	bob.SetSendHook(bobCap.hook)
+
+	room.Join(alice)
+	room.Join(bob)
+	want := models.PresenceUpdate{Connected: []string{"u1", "u2"}, At: clk.Now().UnixMilli()}
+	if got := aliceCap.list(); len(got) != 1 || got[0].Type != "presence" || !reflect.DeepEqual(got[0].Data, want) {
+		t.Fatalf("expected alice to see both connected, got %#v", got)
+	}
+	if got := bobCap.list(); len(got) != 0 {
+		t.Fatalf("expected the joiner to get presence with init instead, got %#v", got)
+	}
+	if got := room.Presence("u2").Connected; !reflect.DeepEqual(got, []string{"u1", "u2"}) {
+		t.Fatalf("expected both users in the init presence, got %v", got)
+	}
+
+	clk.Advance(time.Second)
+	room.Leave(bob)
+	want = models.PresenceUpdate{Connected: []string{"u1"}, At: clk.Now().UnixMilli()}
+	if got := aliceCap.list(); len(got) != 3 || !reflect.DeepEqual(got[1].Data, want) || got[2].Type != "peer_disconnected" {
+		t.Fatalf("expected alice to see bob leave, got %#v", got)
+	}
+	room.Leave(bob)
+	if got := aliceCap.list(); len(got) != 3 {
+		t.Fatalf("expected no presence for a client that already left, got %#v", got)
+	}
+}
+
+func typingStates(frames []models.WSFrame) []bool {
+	var states []bool
+	for _, f := range frames {
+		if f.Type == "typing" {
+			states = append(states, f.Data.(models.Typing).Active)
+		}
+	}
+	return states
+}
+
+func TestTypingRelayIsThrottled(t *testing.T) {
+	room, clk, c, caps := presenceRoom()
+
+	room.SetTyping(c[0], true)
+	if got := typingStates(caps[1].list()); len(got) != 1 || !got[0] {
+		t.Fatalf("expected partner to see typing, got %v", got)
+	}
+	if got := caps[0].list(); len(got) != 0 {
+		t.Fatalf("typing must not echo to the sender: %#v", got)
+	}
+
+	// Flapping inside the window is coalesced: back to active sends nothing
+	clk.Advance(250 * time.Millisecond)
+	room.SetTyping(c[0], false)
+	clk.Advance(250 * time.Millisecond)
+	room.SetTyping(c[0], true)
+	clk.Advance(time.Second)
+	if got := typingStates(caps[1].list()); len(got) != 1 {
+		t.Fatalf("expected no extra relays, got %v", got)
+	}
+
+	// Outside the window a change is relayed at once...
+	room.SetTyping(c[0], false)
+	if got := typingStates(caps[1].list()); len(got) != 2 || got[1] {
+		t.Fatalf("expected stop relayed once the window passed, got %v", got)
+	}
+	// ...inside it, the change is held until the window closes
+	clk.Advance(500 * time.Millisecond)
+	room.SetTyping(c[0], true)
+	if got := typingStates(caps[1].list()); len(got) != 2 {
+		t.Fatalf("expected change to be held back, got %v", got)
+	}
+	clk.Advance(500 * time.Millisecond)
+	if got := typingStates(caps[1].list()); len(got) != 3 || !got[2] {
+		t.Fatalf("expected held change at the end of the window, got %v", got)
+	}
+}
+
+func TestTypingAutoClearsAfterSilence(t *testing.T) {
+	room, clk, c, caps := presenceRoom()
+
+	room.SetTyping(c[0], true)
+	clk.Advance(4 * time.Second)
+	room.SetTyping(c[0], true) // still typing: the timer restarts
+	clk.Advance(4 * time.Second)
+	if got := room.Presence("u2").Typing; len(got) != 1 || got[0] != "u1" {
+		t.Fatalf("expected u1 still typing, got %v", got)
+	}
+
+	clk.Advance(time.Second)
+	if got := typingStates(caps[1].list()); len(got) != 2 || got[1] {
+		t.Fatalf("expected typing cleared after silence, got %v", got)
+	}
+	if got := room.Presence("u2").Typing; len(got) != 0 {
+		t.Fatalf("expected nobody typing, got %v", got)
+	}
+}
+
+func TestTypingClearedWhenUserLeaves(t *testing.T) {
+	room, _, c, caps := presenceRoom()
+
+	room.SetTyping(c[0], true)
+	if got := room.Presence("u1").Typing; len(got) != 0 {
+		t.Fatalf("own typing must not be reported back, got %v", got)
+	}
+	room.Leave(c[0])
+	if got := typingStates(caps[1].list()); len(got) != 2 || got[1] {
+		t.Fatalf("expected typing cleared on leave, got %v", got)
+	}
+	if got := room.Presence("u2").Typing; len(got) != 0 {
+		t.Fatalf("expected nobody typing, got %v", got)
+	}
+}
+
+func TestRunStatusPairsWithLatestRun(t *testing.T) {
+	room, _, _, caps := presenceRoom()
+
+	first := room.BeginRun("u1")
+	second := room.BeginRun("u2")
+	room.EndRun(first) // superseded run must not end the newer one
+	if p := room.Presence("u1"); p.Run == nil || p.Run.By != "u2" {
+		t.Fatalf("expected u2's run in progress, got %+v", p.Run)
+	}
+	room.EndRun(second)
+	room.EndRun(second)
+
+	var statuses []models.RunStatus
+	for _, f := range caps[0].list() {
+		if f.Type == "run_status" {
+			statuses = append(statuses, f.Data.(models.RunStatus))
+		}
+	}
+	want := []models.RunStatus{
+		{State: models.RunStarted, By: "u1"},
+		{State: models.RunStarted, By: "u2"},
+		{State: models.RunFinished, By: "u2"},
+	}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, statuses)
+	}
+	if p := room.Presence("u1"); p.Run != nil {
+		t.Fatalf("expected no run in progress, got %+v", p.Run)
+	}
+
+	replay := newFrameCapture()
+	replayClient := NewClient(nil)
+	replayClient.SetSendHook(replay.hook)
+	room.ReplayRunHistory(replayClient)
+	for _, f := range replay.list() {
+		if f.Type == "run_status" {
+			t.Fatalf("run_status must not be replayed: %#v", replay.list())
+		}
+	}
+}
+
+func TestStartRunGuards(t *testing.T) {
+	room, clk, _, _ := presenceRoom()
+
+	seq, err := room.StartRun("u1")
+	if err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	if _, err := room.StartRun("u2"); !errors.Is(err, ErrRunInProgress) {
+		t.Fatalf("expected run in progress, got %v", err)
+	}
+	room.EndRun(seq)
+	if _, err := room.StartRun("u2"); !errors.Is(err, ErrRunCooldown) {
+		t.Fatalf("expected the cooldown, got %v", err)
+	}
+	clk.Advance(defaultRunCooldown)
+
+	for i := 1; i < runRateLimit; i++ {
+		seq, err := room.StartRun("u1")
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		room.EndRun(seq)
+		clk.Advance(defaultRunCooldown)
+	}
+	if _, err := room.StartRun("u1"); !errors.Is(err, ErrRunRateLimited) {
+		t.Fatalf("expected rate limit, got %v", err)
+	}
+	// The budget is per user and refills as the window slides
+	seq, err = room.StartRun("u2")
+	if err != nil {
+		t.Fatalf("u2 should not be limited: %v", err)
+	}
+	room.EndRun(seq)
+	clk.Advance(runRateWindow)
+	if _, err := room.StartRun("u1"); err != nil {
+		t.Fatalf("expected the window to slide: %v", err)
+	}
+}
+
+func TestQueueRunKeepsOneWaitingRun(t *testing.T) {
+	room, clk, _, _ := presenceRoom()
+	release := make(chan struct{}, 2)
+	ran := make(chan string, 3)
+	run := func(name string) func() {
+		return func() {
+			ran <- name
+			<-release
+		}
+	}
+	reject := func(err error) { t.Errorf("unexpected reject: %v", err) }
+
+	if queued, err := room.QueueRun("u1", run("first"), reject); queued || err != nil {
+		t.Fatalf("expected the first run to start, got queued=%v err=%v", queued, err)
+	}
+	if name := <-ran; name != "first" {
+		t.Fatalf("expected the first run, got %s", name)
+	}
+	// The third request takes the place of the second
+	for _, name := range []string{"second", "third"} {
+		if queued, err := room.QueueRun("u2", run(name), reject); !queued || err != nil {
+			t.Fatalf("expected %s to wait, got queued=%v err=%v", name, queued, err)
+		}
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := room.StartRun("u2")
+		if errors.Is(err, ErrRunCooldown) {
+			break
+		}
+		if !errors.Is(err, ErrRunInProgress) || time.Now().After(deadline) {
+			t.Fatalf("expected the first run to end into the cooldown, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	release <- struct{}{}
+	clk.Advance(defaultRunCooldown)
+	if name := <-ran; name != "third" {
+		t.Fatalf("expected the latest waiting run, got %s", name)
+	}
+	select {
+	case name := <-ran:
+		t.Fatalf("expected no other run, got %s", name)
+	default:
+	}
+}
+
+func TestQueuedRunIsDroppedWhenSessionEnds(t *testing.T) {
+	room, clk, _, _ := presenceRoom()
+	release := make(chan struct{})
+	ran := make(chan string, 2)
+	reject := func(err error) { t.Errorf("unexpected reject: %v", err) }
+
+	_, _ = room.QueueRun("u1", func() { ran <- "first"; <-release }, reject)
+	<-ran
+	if queued, _ := room.QueueRun("u2", func() { ran <- "queued" }, reject); !queued {
+		t.Fatal("expected the second run to wait")
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := room.StartRun("u2"); errors.Is(err, ErrRunCooldown) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the first run to end into the cooldown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	room.EndSessionNow()
+	clk.Advance(defaultRunCooldown)
+	select {
+	case <-ran:
+		t.Fatal("expected the waiting run to be dropped with the session")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func applyDelta(text string, d models.DocDelta) string {
+	runes := []rune(text)
+	return string(runes[:d.RangeStart]) + d.Text + string(runes[d.RangeEnd:])
+}
+
+func TestNewDocDelta(t *testing.T) {
+	cases := []struct{ name, prev, next string }{
+		{"insert", "hello world", "hello, world"},
+		{"delete", "hello, world", "hello world"},
+		{"replace", "x = 1\ny = 2\n", "x = 1\ny = 42\n"},
+		{"append", "abc", "abcdef"},
+		{"from empty", "", "print(1)"},
+		{"to empty", "print(1)", ""},
+		{"repeated runs", "aaaa", "aaaaaa"},
+		{"unicode", "naïve 世界", "naïve 新世界"},
+		{"unchanged", "same", "same"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDocDelta(models.DocState{Text: tc.prev, Version: 3}, models.DocState{Text: tc.next, Version: 4})
+			if got := applyDelta(tc.prev, d); got != tc.next {
+				t.Fatalf("delta %+v applied to %q gives %q, want %q", d, tc.prev, got, tc.next)
+			}
+			if d.BaseVersion != 3 || d.Version != 4 || d.Hash != DocHash(tc.next) {
+				t.Fatalf("unexpected delta header: %+v", d)
+			}
+		})
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	version, caps, granted := NegotiateCapabilities(0, []string{CapDocDelta})
+	if version != 1 || caps != (Capabilities{}) || granted != nil {
+		t.Fatalf("pre-v2 clients get nothing: %d %+v %v", version, caps, granted)
+	}
+	version, caps, granted = NegotiateCapabilities(2, []string{CapDocDelta, "unknown", CapDocDelta})
+	if version != 2 || caps != (Capabilities{DocDelta: true}) || len(granted) != 1 || granted[0] != CapDocDelta {
+		t.Fatalf("unexpected grant: %d %+v %v", version, caps, granted)
+	}
+}
+
+func TestSendDocPicksFrame(t *testing.T) {
+	capture := newFrameCapture()
+	c := NewClient(nil)
+	c.SetSendHook(capture.hook)
+	prev := models.DocState{Text: "a", Version: 1}
+	next := models.DocState{Text: "ab", Version: 2}
+
+	c.SendDoc(&prev, next)
+	c.SetCapabilities(Capabilities{DocDelta: true})
+	c.SendDoc(&prev, next)
+	c.SendDoc(nil, next)
+	c.SendDoc(&next, next)
+
+	var types []string
+	for _, f := range capture.list() {
+		types = append(types, f.Type)
+	}
+	if strings.Join(types, ",") != "doc,doc_delta,doc,doc" {
+		t.Fatalf("unexpected frames: %v", types)
+	}
+}
+
+// BenchmarkDocBytesOnWire replays typing into a ~3000 line document and
+// reports the bytes each doc frame encoding puts on the wire per keystroke.
+func BenchmarkDocBytesOnWire(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "    total_%d = compute(values[%d], offset=%d)  # step %d\n", i, i, i*7, i)
+	}
+	base := sb.String()
+	keystrokes := "if total_10 > limit:\n        return total_10\n"
+
+	binaryClient := NewClient(nil)
+	binaryClient.SetCapabilities(Capabilities{BinaryDoc: true})
+
+	var full, deflated, binary, delta int
+	for i := 0; i < b.N; i++ {
+		full, deflated, binary, delta = 0, 0, 0, 0
+		prev := models.DocState{Text: base, Version: 1}
+		offset := len([]rune(base)) / 2
+		for j, r := range keystrokes {
+			runes := []rune(prev.Text)
+			next := models.DocState{
+				Text:    string(runes[:offset+j]) + string(r) + string(runes[offset+j:]),
+				Version: prev.Version + 1,
+			}
+
+			payload, _ := json.Marshal(models.WSFrame{Type: "doc", Data: next})
+			full += len(payload)
+
+			var buf bytes.Buffer
+			fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+			_, _ = fw.Write(payload)
+			_ = fw.Close()
+			deflated += buf.Len()
+
+			_, gz, _ := binaryClient.encodeFrame(models.WSFrame{Type: "doc", Data: next})
+			binary += len(gz)
+
+			d, _ := json.Marshal(models.WSFrame{Type: "doc_delta", Data: NewDocDelta(prev, next)})
+			delta += len(d)
+
+			prev = next
+		}
+	}
+	n := float64(len([]rune(keystrokes)))
+	b.ReportMetric(float64(full)/n, "json-B/key")
+	b.ReportMetric(float64(deflated)/n, "deflate-B/key")
+	b.ReportMetric(float64(binary)/n, "gzip-B/key")
+	b.ReportMetric(float64(delta)/n, "delta-B/key")
+}
+
+func turnChanges(frames []models.WSFrame) []models.TurnChanged {
+	var turns []models.TurnChanged
+	for _, f := range frames {
+		if f.Type == "turn_changed" {
+			turns = append(turns, f.Data.(models.TurnChanged))
+		}
+	}
+	return turns
+}
+
+// turnsRoom is a presence room with turn-taking enabled by u1 and confirmed by u2.
+func turnsRoom(t *testing.T, turnSec int) (*Room, *fakeClock, [2]*Client, [2]*frameCapture, *[]models.TurnState) {
+	t.Helper()
+	room, clk, clients, caps := presenceRoom()
+	var saved []models.TurnState
+	room.SetTurnStateHandler(func(state models.TurnState) { saved = append(saved, state) })
+	if _, err := room.RequestModeChange(clients[0], models.ModeSet{Mode: models.ModeTurns, TurnDurationSec: turnSec}); err != nil {
+		t.Fatalf("request turns: %v", err)
+	}
+	if _, _, err := room.ResolveModeChange(clients[1], true); err != nil {
+		t.Fatalf("confirm turns: %v", err)
+	}
+	return room, clk, clients, caps, &saved
+}
+
+func TestTurnModeNeedsPartnerConfirm(t *testing.T) {
+	room, clk, clients, caps := presenceRoom()
+
+	if _, err := room.RequestModeChange(clients[0], models.ModeSet{Mode: models.ModeTurns, TurnDurationSec: 5}); !errors.Is(err, ErrInvalidMode) {
+		t.Fatalf("expected ErrInvalidMode for a 5s turn, got %v", err)
+	}
+	if _, err := room.RequestModeChange(clients[0], models.ModeSet{Mode: models.ModeFree}); !errors.Is(err, ErrModeUnchanged) {
+		t.Fatalf("expected ErrModeUnchanged, got %v", err)
+	}
+	req, err := room.RequestModeChange(clients[0], models.ModeSet{Mode: models.ModeTurns})
+	if err != nil || req.TurnDurationSec != int(defaultTurnDuration.Seconds()) {
+		t.Fatalf("expected the default turn length, got %+v err=%v", req, err)
+	}
+	if _, err := room.RequestModeChange(clients[1], models.ModeSet{Mode: models.ModeTurns}); !errors.Is(err, ErrModePending) {
+		t.Fatalf("expected ErrModePending, got %v", err)
+	}
+	if _, _, err := room.ResolveModeChange(clients[0], true); !errors.Is(err, ErrNoPendingMode) {
+		t.Fatalf("requester must not confirm their own change, got %v", err)
+	}
+	requester, state, err := room.ResolveModeChange(clients[1], false)
+	if err != nil || requester != clients[0] || state.Mode != models.ModeFree {
+		t.Fatalf("unexpected rejection: %v %+v %v", requester, state, err)
+	}
+	if err := room.CheckTurn("u2"); err != nil {
+		t.Fatalf("free mode lets anyone edit, got %v", err)
+	}
+
+	if _, err := room.RequestModeChange(clients[0], models.ModeSet{Mode: models.ModeTurns, TurnDurationSec: 60}); err != nil {
+		t.Fatalf("request turns: %v", err)
+	}
+	_, state, err = room.ResolveModeChange(clients[1], true)
+	if err != nil || state.Mode != models.ModeTurns || state.ActiveUser != "u1" || state.EndsAt != clk.Now().Add(time.Minute).UnixMilli() {
+		t.Fatalf("unexpected turn state: %+v err=%v", state, err)
+	}
+	if err := room.CheckTurn("u2"); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("expected ErrNotYourTurn for the observer, got %v", err)
+	}
+	if err := room.CheckTurn("u1"); err != nil {
+		t.Fatalf("the driver may edit, got %v", err)
+	}
+	for i, c := range caps {
+		if turns := turnChanges(c.list()); len(turns) != 1 || turns[0].ActiveUser != "u1" || turns[0].Reason != "start" {
+			t.Fatalf("client %d: unexpected turn frames %+v", i, turns)
+		}
+	}
+
+	// a pending change is dropped when a participant leaves
+	if _, err := room.RequestModeChange(clients[1], models.ModeSet{Mode: models.ModeFree}); err != nil {
+		t.Fatalf("request free: %v", err)
+	}
+	room.Leave(clients[1])
+	room.Join(clients[1])
+	if _, _, err := room.ResolveModeChange(clients[0], true); !errors.Is(err, ErrNoPendingMode) {
+		t.Fatalf("expected the change to be dropped on leave, got %v", err)
+	}
+}
+
+func TestTurnRotatesOnTimerAndPass(t *testing.T) {
+	room, clk, clients, caps, saved := turnsRoom(t, 60)
+
+	clk.Advance(59 * time.Second)
+	if state := room.TurnState(); state.ActiveUser != "u1" {
+		t.Fatalf("turn ended early: %+v", state)
+	}
+	clk.Advance(time.Second)
+	if state := room.TurnState(); state.ActiveUser != "u2" || state.EndsAt != clk.Now().Add(time.Minute).UnixMilli() {
+		t.Fatalf("expected the timer to hand over to u2: %+v", state)
+	}
+
+	if err := room.PassTurn(clients[0]); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("expected ErrNotYourTurn, got %v", err)
+	}
+	clk.Advance(10 * time.Second)
+	if err := room.PassTurn(clients[1]); err != nil {
+		t.Fatalf("pass: %v", err)
+	}
+	// the timer of the passed turn must not fire any more
+	clk.Advance(50 * time.Second)
+	if state := room.TurnState(); state.ActiveUser != "u1" {
+		t.Fatalf("expected u1 to drive after the pass: %+v", state)
+	}
+
+	var reasons []string
+	for _, turn := range turnChanges(caps[1].list()) {
+		reasons = append(reasons, turn.ActiveUser+":"+turn.Reason)
+	}
+	if strings.Join(reasons, ",") != "u1:start,u2:timer,u1:pass" {
+		t.Fatalf("unexpected turns: %v", reasons)
+	}
+	if len(*saved) != 3 || (*saved)[2].ActiveUser != "u1" {
+		t.Fatalf("expected every turn change to reach the handler, got %+v", *saved)
+	}
+
+	driving := room.DrivingTime()
+	if driving["u1"] != 110*time.Second || driving["u2"] != 10*time.Second {
+		t.Fatalf("unexpected driving time: %v", driving)
+	}
+	if ms := room.TurnState().DrivingMs; ms["u1"] != 60_000 || ms["u2"] != 10_000 {
+		t.Fatalf("turn state should carry completed turns only: %v", ms)
+	}
+}
+
+func TestTurnAutoPassesAfterDisconnect(t *testing.T) {
+	room, clk, clients, caps, _ := turnsRoom(t, 600)
+
+	room.Leave(clients[0])
+	clk.Advance(29 * time.Second)
+	room.Join(clients[0])
+	clk.Advance(29 * time.Second)
+	if state := room.TurnState(); state.ActiveUser != "u1" {
+		t.Fatalf("a reconnect within the grace keeps the turn: %+v", state)
+	}
+
+	// the observer leaving does not matter
+	room.Leave(clients[1])
+	clk.Advance(turnAwayGrace)
+	room.Join(clients[1])
+	if state := room.TurnState(); state.ActiveUser != "u1" {
+		t.Fatalf("the observer's disconnect must not pass the turn: %+v", state)
+	}
+
+	room.Leave(clients[0])
+	clk.Advance(turnAwayGrace)
+	state := room.TurnState()
+	if state.ActiveUser != "u2" {
+		t.Fatalf("expected the turn to pass after %s away: %+v", turnAwayGrace, state)
+	}
+	if turns := turnChanges(caps[1].list()); len(turns) == 0 || turns[len(turns)-1].Reason != "disconnect" {
+		t.Fatalf("expected a disconnect turn change")
+	}
+}
+
+func TestTurnModeDisableNeedsConfirm(t *testing.T) {
+	room, clk, clients, caps, _ := turnsRoom(t, 60)
+	clk.Advance(20 * time.Second)
+
+	if _, err := room.RequestModeChange(clients[1], models.ModeSet{Mode: models.ModeFree}); err != nil {
+		t.Fatalf("request free: %v", err)
+	}
+	if err := room.CheckTurn("u2"); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("turns stay on until confirmed, got %v", err)
+	}
+	_, state, err := room.ResolveModeChange(clients[0], true)
+	if err != nil || state.Mode != models.ModeFree || state.DrivingMs["u1"] != 20_000 {
+		t.Fatalf("unexpected state after disabling: %+v err=%v", state, err)
+	}
+	if err := room.CheckTurn("u2"); err != nil {
+		t.Fatalf("free mode lets anyone edit, got %v", err)
+	}
+	before := len(turnChanges(caps[0].list()))
+	clk.Advance(time.Hour)
+	if after := len(turnChanges(caps[0].list())); after != before {
+		t.Fatalf("no turn may change once disabled")
+	}
+}
+
+func TestRestoreTurnState(t *testing.T) {
+	room, clk, _, _, _ := turnsRoom(t, 60)
+	clk.Advance(20 * time.Second)
+	snapshot := room.TurnState()
+	snapshot.DrivingMs = map[string]int64{"u2": 5_000}
+
+	// a fresh instance picks up the turn with its original deadline
+	restored, clk2, _, caps := presenceRoom()
+	clk2.Advance(clk.Now().Sub(clk2.Now()))
+	if !restored.RestoreTurnState(snapshot) {
+		t.Fatalf("expected restore to apply")
+	}
+	if state := restored.TurnState(); state.ActiveUser != "u1" || state.EndsAt != snapshot.EndsAt || state.StartedAt != snapshot.StartedAt {
+		t.Fatalf("unexpected restored state: %+v", state)
+	}
+	if err := restored.CheckTurn("u2"); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("expected turns to be enforced after restore, got %v", err)
+	}
+	if restored.RestoreTurnState(models.TurnState{Mode: models.ModeFree}) {
+		t.Fatalf("restore must not replace running turns")
+	}
+
+	clk2.Advance(40 * time.Second)
+	if state := restored.TurnState(); state.ActiveUser != "u2" || state.DrivingMs["u1"] != 60_000 || state.DrivingMs["u2"] != 5_000 {
+		t.Fatalf("expected the restored turn to end on time: %+v", state)
+	}
+	if turns := turnChanges(caps[0].list()); len(turns) != 1 || turns[0].Reason != "timer" {
+		t.Fatalf("unexpected turn frames: %+v", turns)
+	}
+}
+
+func TestThreadLifecycle(t *testing.T) {
+	room, clk, _, _ := presenceRoom()
+
+	if _, err := room.CreateThread("u1", models.ThreadCreate{Title: "  "}); !errors.Is(err, ErrInvalidThread) {
+		t.Fatalf("expected invalid_thread for a blank title, got %v", err)
+	}
+	thread, err := room.CreateThread("u1", models.ThreadCreate{Title: " Why sort? ", Body: "O(n log n) feels slow"})
+	if err != nil || thread.ID != 1 || thread.Title != "Why sort?" || thread.Author != "u1" || thread.CreatedAt != clk.Now().UnixMilli() {
+		t.Fatalf("unexpected thread: %#v, %v", thread, err)
+	}
+
+	reply, err := room.ReplyToThread("u2", models.ThreadReplyCmd{ThreadID: 1, Body: "a hash map is O(n)"}, 0)
+	if err != nil || reply.ID != 1 || reply.Author != "u2" {
+		t.Fatalf("unexpected reply: %#v, %v", reply, err)
+	}
+	if _, err := room.ReplyToThread("u2", models.ThreadReplyCmd{ThreadID: 9, Body: "?"}, 0); !errors.Is(err, ErrUnknownThread) {
+		t.Fatalf("expected unknown_thread, got %v", err)
+	}
+	if _, err := room.ReplyToThread("u2", models.ThreadReplyCmd{ThreadID: 1, Body: " "}, 0); !errors.Is(err, ErrInvalidThread) {
+		t.Fatalf("expected invalid_thread for an empty reply, got %v", err)
+	}
+
+	resolved, err := room.ResolveThread("u2", 1)
+	if err != nil || resolved.ResolvedBy != "u2" || resolved.ResolvedAt == 0 {
+		t.Fatalf("unexpected resolve: %#v, %v", resolved, err)
+	}
+	if _, err := room.ResolveThread("u1", 1); !errors.Is(err, ErrThreadResolved) {
+		t.Fatalf("expected thread_resolved, got %v", err)
+	}
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "one more"}, 0); !errors.Is(err, ErrThreadResolved) {
+		t.Fatalf("expected replies to a resolved thread to fail, got %v", err)
+	}
+	// An answer requested before the thread was resolved still lands
+	if _, err := room.PostThreadReply(1, models.ThreadAuthorAssistant, "late answer"); err != nil {
+		t.Fatalf("expected server reply on a resolved thread, got %v", err)
+	}
+
+	_, _ = room.CreateThread("u2", models.ThreadCreate{Title: "edge cases"})
+	summary := room.ThreadSummary()
+	if summary.Total != 2 || summary.Resolved != 1 || summary.Unresolved != 1 || summary.Replies != 2 || summary.AIReplies != 1 {
+		t.Fatalf("unexpected summary: %#v", summary)
+	}
+	if summary.Threads[0] != (models.ThreadOutcome{ID: 1, Resolved: true, Replies: 2}) {
+		t.Fatalf("unexpected thread outcome: %#v", summary.Threads[0])
+	}
+	if NewRoom("empty").ThreadSummary() != nil {
+		t.Fatalf("expected no summary without threads")
+	}
+}
+
+func TestThreadCaps(t *testing.T) {
+	room := NewRoom("caps")
+
+	if _, err := room.CreateThread("u1", models.ThreadCreate{Title: strings.Repeat("t", maxThreadTitle+1)}); !errors.Is(err, ErrThreadTooLong) {
+		t.Fatalf("expected thread_too_long for the title, got %v", err)
+	}
+	if _, err := room.CreateThread("u1", models.ThreadCreate{Title: "t", Body: strings.Repeat("é", maxThreadBody+1)}); !errors.Is(err, ErrThreadTooLong) {
+		t.Fatalf("expected thread_too_long for the body, got %v", err)
+	}
+	for i := 0; i < maxThreads; i++ {
+		if _, err := room.CreateThread("u1", models.ThreadCreate{Title: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("thread %d: %v", i, err)
+		}
+	}
+	if _, err := room.CreateThread("u1", models.ThreadCreate{Title: "one too many"}); !errors.Is(err, ErrTooManyThreads) {
+		t.Fatalf("expected too_many_threads, got %v", err)
+	}
+
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: strings.Repeat("b", maxThreadBody+1)}, 0); !errors.Is(err, ErrThreadTooLong) {
+		t.Fatalf("expected thread_too_long for a reply, got %v", err)
+	}
+	for i := 0; i < maxThreadReplies-1; i++ {
+		if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "r"}, 0); err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+	}
+	// Asking the assistant needs room for its answer too
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "r", AskAI: true}, 5); !errors.Is(err, ErrTooManyReplies) {
+		t.Fatalf("expected too_many_replies when the answer would not fit, got %v", err)
+	}
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "last"}, 0); err != nil {
+		t.Fatalf("last reply: %v", err)
+	}
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "r"}, 0); !errors.Is(err, ErrTooManyReplies) {
+		t.Fatalf("expected too_many_replies, got %v", err)
+	}
+	if _, err := room.PostThreadReply(1, models.ThreadAuthorSystem, "r"); !errors.Is(err, ErrTooManyReplies) {
+		t.Fatalf("expected server replies to respect the cap, got %v", err)
+	}
+	if reply, _ := room.PostThreadReply(2, models.ThreadAuthorAssistant, strings.Repeat("a", maxThreadBody+10)); len(reply.Body) != maxThreadBody {
+		t.Fatalf("expected long assistant replies to be truncated, got %d runes", len(reply.Body))
+	}
+}
+
+func TestThreadAIBudget(t *testing.T) {
+	room := NewRoom("budget")
+	_, _ = room.CreateThread("u1", models.ThreadCreate{Title: "help"})
+	ask := models.ThreadReplyCmd{ThreadID: 1, Body: "assistant?", AskAI: true}
+
+	for i := 0; i < 2; i++ {
+		if reply, err := room.ReplyToThread("u1", ask, 2); err != nil || !reply.AskAI {
+			t.Fatalf("ask %d: %#v, %v", i, reply, err)
+		}
+	}
+	if _, err := room.ReplyToThread("u1", ask, 2); !errors.Is(err, ErrHintBudgetExhausted) {
+		t.Fatalf("expected hint_budget_exhausted, got %v", err)
+	}
+	room.RefundThreadAI()
+	if _, err := room.ReplyToThread("u1", ask, 2); err != nil {
+		t.Fatalf("expected a refunded ask to be allowed, got %v", err)
+	}
+	if used := room.ThreadState().AIRepliesUsed; used != 2 {
+		t.Fatalf("expected 2 assistant replies spent, got %d", used)
+	}
+}
+
+func TestRoomRestoreThreadState(t *testing.T) {
+	source := NewRoom("threads")
+	_, _ = source.CreateThread("u1", models.ThreadCreate{Title: "first", Body: "b"})
+	_, _ = source.CreateThread("u2", models.ThreadCreate{Title: "second"})
+	_, _ = source.ReplyToThread("u2", models.ThreadReplyCmd{ThreadID: 1, Body: "ask", AskAI: true}, 3)
+	_, _ = source.ResolveThread("u1", 2)
+
+	// Round-trip the snapshot through its persisted encoding
+	data, err := json.Marshal(source.ThreadState())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var state models.ThreadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	room := NewRoom("threads")
+	if !room.RestoreThreadState(state) {
+		t.Fatalf("expected restore into empty room")
+	}
+	if room.RestoreThreadState(models.ThreadState{}) {
+		t.Fatalf("restore must not overwrite live threads")
+	}
+	got := room.ThreadState()
+	if len(got.Threads) != 2 || got.AIRepliesUsed != 1 || !got.Threads[1].Resolved || got.Threads[0].Replies[0].Body != "ask" {
+		t.Fatalf("unexpected restored state: %#v", got)
+	}
+	if next, _ := room.CreateThread("u1", models.ThreadCreate{Title: "third"}); next.ID != 3 {
+		t.Fatalf("expected ids to continue after restore, got %d", next.ID)
+	}
+	if _, err := room.ReplyToThread("u1", models.ThreadReplyCmd{ThreadID: 1, Body: "again", AskAI: true}, 1); !errors.Is(err, ErrHintBudgetExhausted) {
+		t.Fatalf("expected the restored spend to count against the budget, got %v", err)
+	}
+
+	// Copies handed out do not alias the room's threads
+	threads := room.Threads()
+	threads[0].Replies[0].Body = "changed"
+	if thread, _ := room.Thread(1); thread.Replies[0].Body != "ask" {
+		t.Fatalf("expected Threads to return a copy")
+	}
+}
+
+func TestEndSessionNeedsPartner(t *testing.T) {
+	room, clk, c, _ := presenceRoom()
+
+	req, agreed, err := room.RequestEndSession(c[0])
+	if err != nil || agreed || req.UserID != "u1" || req.ExpiresAt != clk.Now().Add(endSessionTimeout).UnixMilli() {
+		t.Fatalf("expected a pending request, got %+v agreed=%v err=%v", req, agreed, err)
+	}
+	if _, _, err := room.RequestEndSession(c[0]); !errors.Is(err, ErrEndPending) {
+		t.Fatalf("expected end_pending while the partner decides, got %v", err)
+	}
+	if err := room.ConfirmEndSession(c[0]); !errors.Is(err, ErrNoPendingEnd) {
+		t.Fatalf("the requester must not confirm while the partner is here, got %v", err)
+	}
+	if err := room.ConfirmEndSession(c[1]); err != nil {
+		t.Fatalf("expected the partner to confirm, got %v", err)
+	}
+	if err := room.ConfirmEndSession(c[1]); !errors.Is(err, ErrNoPendingEnd) {
+		t.Fatalf("expected the request used up, got %v", err)
+	}
+
+	// Asking when the partner already has counts as agreeing
+	_, _, _ = room.RequestEndSession(c[1])
+	if _, agreed, err := room.RequestEndSession(c[0]); !agreed || err != nil {
+		t.Fatalf("expected agreement, got agreed=%v err=%v", agreed, err)
+	}
+}
+
+func TestEndSessionCancelAndExpiry(t *testing.T) {
+	room, clk, c, _ := presenceRoom()
+
+	_, _, _ = room.RequestEndSession(c[0])
+	if err := room.CancelEndSession(); err != nil {
+		t.Fatalf("expected the request cancelled, got %v", err)
+	}
+	if err := room.ConfirmEndSession(c[1]); !errors.Is(err, ErrNoPendingEnd) {
+		t.Fatalf("expected nothing to confirm after cancel, got %v", err)
+	}
+
+	_, _, _ = room.RequestEndSession(c[0])
+	clk.Advance(endSessionTimeout)
+	if err := room.ConfirmEndSession(c[1]); !errors.Is(err, ErrNoPendingEnd) {
+		t.Fatalf("expected the request to lapse, got %v", err)
+	}
+	if err := room.CancelEndSession(); !errors.Is(err, ErrNoPendingEnd) {
+		t.Fatalf("expected nothing to cancel, got %v", err)
+	}
+}
+
+func TestEndSessionAloneAfterPartnerLeft(t *testing.T) {
+	room, _, c, _ := presenceRoom()
+
+	_, _, _ = room.RequestEndSession(c[0])
+	room.Leave(c[1])
+	if _, agreed, err := room.RequestEndSession(c[0]); !agreed || err != nil {
+		t.Fatalf("expected asking again alone to end the session, got agreed=%v err=%v", agreed, err)
+	}
+
+	_, _, _ = room.RequestEndSession(c[0])
+	if err := room.ConfirmEndSession(c[0]); err != nil {
+		t.Fatalf("expected the requester to confirm alone, got %v", err)
+	}
+}
+
+func TestRoomRejectsEditsGrowingDocPastLimit(t *testing.T) {
+	room := NewRoom("limits", WithMaxDocBytes(8))
+	if ok, _, err := room.ApplyEdit(models.Edit{BaseVersion: 0, RangeStart: 0, RangeEnd: 0, Text: "héllo"}); !ok {
+		t.Fatalf("edit failed: %v", err)
+	}
+	// 6 bytes in 5 runes: replacing "llo" with "p!!!" makes 7 bytes, one more 9
+	if ok, doc, err := room.ApplyEdit(models.Edit{BaseVersion: 1, RangeStart: 2, RangeEnd: 5, Text: "p!!!"}); !ok || doc.Text != "hép!!!" {
+		t.Fatalf("expected the edit to fit, got %+v err=%v", doc, err)
+	}
+	if ok, doc, err := room.ApplyEdit(models.Edit{BaseVersion: 2, RangeStart: 6, RangeEnd: 6, Text: "!!"}); ok || !errors.Is(err, ErrDocTooLarge) || doc.Version != 2 {
+		t.Fatalf("expected doc_too_large, got ok=%v %+v err=%v", ok, doc, err)
+	}
+
+	// A document already over the limit can still be cut down
+	room = NewRoom("restored", WithMaxDocBytes(4))
+	room.RestoreDoc(models.DocSnapshot{Text: "0123456789", Version: 3})
+	if ok, doc, err := room.ApplyEdit(models.Edit{BaseVersion: 3, RangeStart: 0, RangeEnd: 5, Text: "x"}); !ok || doc.Text != "x56789" {
+		t.Fatalf("expected a shrinking edit to pass, got %+v err=%v", doc, err)
+	}
+	if ok, _, err := NewRoom("unlimited", WithMaxDocBytes(0)).ApplyEdit(models.Edit{Text: strings.Repeat("a", 300*1024)}); !ok {
+		t.Fatalf("expected no cap with 0, got %v", err)
+	}
+}
+
+func TestRoomEditRateIsATokenBucket(t *testing.T) {
+	room := NewRoom("rate", WithEditRate(3))
+	clk := newFakeClock()
+	room.clock = clk
+	alice, bob := NewClient(nil), NewClient(nil)
+
+	for i := 0; i < 3; i++ {
+		if !room.AllowEdit(alice) {
+			t.Fatalf("expected edit %d of the burst to pass", i)
+		}
+	}
+	if room.AllowEdit(alice) {
+		t.Fatal("expected the fourth edit in a burst to be dropped")
+	}
+	if !room.AllowEdit(bob) {
+		t.Fatal("expected each client to have its own bucket")
+	}
+	clk.Advance(time.Second/3 + time.Millisecond)
+	if !room.AllowEdit(alice) || room.AllowEdit(alice) {
+		t.Fatal("expected one edit to be refilled after a third of a second")
+	}
+	clk.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		if !room.AllowEdit(alice) {
+			t.Fatalf("expected the bucket to refill up to its burst, edit %d dropped", i)
+		}
+	}
+	if room.AllowEdit(alice) {
+		t.Fatal("expected the refill to stop at the burst size")
+	}
+}
+
+func TestRoomSpectatorsWatchWithoutTakingASeat(t *testing.T) {
+	room := NewRoom("watch", WithMaxSpectators(1))
+	var got []string
+	alice, mentor := NewClient(nil), NewClient(nil)
+	alice.UserID, mentor.UserID = "alice", "mentor"
+	alice.SetSendHook(func(models.WSFrame) {})
+	mentor.SetSendHook(func(f models.WSFrame) { got = append(got, f.Type) })
+
+	room.Join(alice)
+	if err := room.JoinSpectator(mentor); err != nil {
+		t.Fatalf("expected the spectator to join, got %v", err)
+	}
+	if err := room.JoinSpectator(NewClient(nil)); !errors.Is(err, ErrSpectatorsFull) {
+		t.Fatalf("expected the spectator cap to hold, got %v", err)
+	}
+	if room.GetClientCount() != 1 || room.SpectatorCount() != 1 {
+		t.Fatalf("expected 1 participant and 1 spectator, got %d and %d", room.GetClientCount(), room.SpectatorCount())
+	}
+	if p := room.Presence(""); len(p.Connected) != 1 || p.Connected[0] != "alice" {
+		t.Fatalf("expected spectators left out of presence, got %v", p.Connected)
+	}
+
+	_, prev, doc, _ := room.ApplyEditFrom("u1", models.Edit{Text: "x"})
+	room.BroadcastDoc(alice, &prev, doc)
+	room.Broadcast(alice, models.WSFrame{Type: "chat"})
+	if len(got) != 2 || got[0] != "doc" || got[1] != "chat" {
+		t.Fatalf("expected the spectator to get the room's broadcasts, got %v", got)
+	}
+
+	if remaining := room.Leave(mentor); remaining != 1 || room.SpectatorCount() != 0 {
+		t.Fatalf("expected the spectator to leave without touching the seats, got %d", remaining)
+	}
+	if NewRoom("closed", WithMaxSpectators(0)).JoinSpectator(mentor) == nil {
+		t.Fatal("expected 0 to turn spectating off")
+	}
+}
+
+func TestRoomCursorsAreClampedToTheDocument(t *testing.T) {
+	room := NewRoom("cursors")
+	alice, bob := NewClient(nil), NewClient(nil)
+	alice.UserID, bob.UserID = "alice", "bob"
+	alice.SetSendHook(func(models.WSFrame) {})
+	bob.SetSendHook(func(models.WSFrame) {})
+	room.Join(alice)
+	room.Join(bob)
+	room.RestoreDoc(models.DocSnapshot{Text: "héllo", Version: 1})
+
+	c, err := room.UpdateCursor("alice", models.Cursor{Pos: -3, SelStart: 10, SelEnd: 4})
+	if err != nil || c != (models.Cursor{UserID: "alice", Pos: 0, SelStart: 4, SelEnd: 5, Color: room.Color("alice")}) {
+		t.Fatalf("unexpected cursor %+v err=%v", c, err)
+	}
+	if _, err := room.UpdateCursor("alice", models.Cursor{UserID: "bob"}); !errors.Is(err, ErrCursorSpoofed) {
+		t.Fatalf("expected a spoofed cursor to be refused, got %v", err)
+	}
+	if _, err := room.UpdateCursor("", models.Cursor{}); !errors.Is(err, ErrCursorSpoofed) {
+		t.Fatalf("expected an anonymous cursor to be refused, got %v", err)
+	}
+
+	// Stored cursors follow the document when it shrinks
+	_, _ = room.ReplaceDoc("hi")
+	if cursors := room.Cursors("bob"); len(cursors) != 1 || cursors[0].SelStart != 2 || cursors[0].SelEnd != 2 {
+		t.Fatalf("expected alice's cursor clamped to the new document, got %+v", cursors)
+	}
+	if cursors := room.Cursors("alice"); len(cursors) != 0 {
+		t.Fatalf("expected a user's own cursor left out, got %+v", cursors)
+	}
+
+	// Colors are distinct and survive a reconnect
+	colors := room.Color("alice") + room.Color("bob")
+	room.Leave(alice)
+	room.Join(alice)
+	if room.Color("alice") == room.Color("bob") || room.Color("alice")+room.Color("bob") != colors {
+		t.Fatalf("expected stable distinct colors, got %q and %q", room.Color("alice"), room.Color("bob"))
+	}
+}
+
+func TestRoomPartnerGrace(t *testing.T) {
+	room, clk, c, caps := presenceRoom()
+	room.partnerGrace = time.Minute
+	var abandoned []string
+	room.SetAbandonHandler(func(userID string) { abandoned = append(abandoned, userID) })
+	peerFrames := func(cap *frameCapture) []models.WSFrame {
+		var frames []models.WSFrame
+		for _, f := range cap.list() {
+			if strings.HasPrefix(f.Type, "peer_") {
+				frames = append(frames, f)
+			}
+		}
+		return frames
+	}
+
+	// Back within the grace period: the partner hears both, nothing ends
+	room.Leave(c[1])
+	got := peerFrames(caps[0])
+	want := models.PeerDisconnected{UserID: "u2", GraceMs: 60000, RejoinBy: clk.Now().Add(time.Minute).UnixMilli()}
+	if len(got) != 1 || got[0].Type != "peer_disconnected" || got[0].Data != want {
+		t.Fatalf("expected peer_disconnected, got %#v", got)
+	}
+	clk.Advance(30 * time.Second)
+	room.Join(c[1])
+	if got := peerFrames(caps[0]); len(got) != 2 || got[1].Type != "peer_rejoined" {
+		t.Fatalf("expected peer_rejoined, got %#v", got)
+	}
+	if got := peerFrames(caps[1]); len(got) != 0 {
+		t.Fatalf("expected the rejoining user to hear nothing, got %#v", got)
+	}
+	clk.Advance(time.Hour)
+	if len(abandoned) != 0 {
+		t.Fatalf("expected the rejoin to cancel the grace period, got %v", abandoned)
+	}
+
+	// Gone for good: the abandon handler runs once the grace period lapses
+	room.Leave(c[1])
+	clk.Advance(time.Minute - time.Second)
+	if len(abandoned) != 0 {
+		t.Fatal("expected the grace period to hold")
+	}
+	clk.Advance(time.Second)
+	if len(abandoned) != 1 || abandoned[0] != "u2" {
+		t.Fatalf("expected u2 to be abandoned, got %v", abandoned)
+	}
+}
+
+func TestRoomRolesDriverAndNavigator(t *testing.T) {
+	room, _, clients, _ := presenceRoom()
+	u1, u2 := clients[0], clients[1]
+
+	// First to join drives, and a second init cannot change the room's choice
+	if !room.ConfigureRoles(false) || room.ConfigureRoles(true) {
+		t.Fatalf("expected only the first init to configure the roles")
+	}
+	if roles := room.Roles(); roles.Driver != "u1" || roles.Navigator != "u2" || roles.FreeForAll {
+		t.Fatalf("unexpected roles: %+v", roles)
+	}
+	if room.CheckDriver("u1") != nil || !errors.Is(room.CheckDriver("u2"), ErrNotDriver) {
+		t.Fatalf("expected only the driver to edit")
+	}
+
+	// With the driver connected the navigator has to ask
+	if _, taken, err := room.RequestControl(u2); err != nil || taken {
+		t.Fatalf("expected a request for control, got taken=%v err=%v", taken, err)
+	}
+	if _, _, err := room.RequestControl(u1); !errors.Is(err, ErrAlreadyDriver) {
+		t.Fatalf("expected ErrAlreadyDriver, got %v", err)
+	}
+	if _, err := room.GrantControl(u2); !errors.Is(err, ErrNotDriver) {
+		t.Fatalf("expected only the driver to grant control, got %v", err)
+	}
+	if roles, err := room.GrantControl(u1); err != nil || roles.Driver != "u2" || roles.Navigator != "u1" {
+		t.Fatalf("expected the roles to swap, got %+v err=%v", roles, err)
+	}
+	if room.CheckDriver("u2") != nil || room.CheckDriver("u1") == nil {
+		t.Fatalf("expected the new driver to edit")
+	}
+
+	// Roles survive a reconnect; a driver who is gone leaves the seat free
+	room.Leave(u2)
+	u2 = NewClient(nil)
+	u2.UserID = "u2"
+	room.Join(u2)
+	if roles := room.Roles(); roles.Driver != "u2" {
+		t.Fatalf("expected u2 to stay the driver, got %+v", roles)
+	}
+	room.Leave(u2)
+	if roles, taken, err := room.RequestControl(u1); err != nil || !taken || roles.Driver != "u1" {
+		t.Fatalf("expected control to be taken from an absent driver, got %+v taken=%v err=%v", roles, taken, err)
+	}
+}
+
+func TestRoomRolesFreeForAllAndTurns(t *testing.T) {
+	room, _, clients, _ := presenceRoom()
+	room.ConfigureRoles(true)
+	if room.CheckDriver("u2") != nil {
+		t.Fatalf("expected everyone to edit in a free-for-all room")
+	}
+	if _, _, err := room.RequestControl(clients[1]); !errors.Is(err, ErrRolesDisabled) {
+		t.Fatalf("expected ErrRolesDisabled, got %v", err)
+	}
+
+	// In turn-taking mode the turn decides, not the role
+	room, _, clients, _ = presenceRoom()
+	room.ConfigureRoles(false)
+	if _, err := room.RequestModeChange(clients[1], models.ModeSet{Mode: models.ModeTurns}); err != nil {
+		t.Fatalf("request turns: %v", err)
+	}
+	if _, _, err := room.ResolveModeChange(clients[0], true); err != nil {
+		t.Fatalf("accept turns: %v", err)
+	}
+	if room.CheckDriver("u2") != nil || room.CheckTurn("u2") != nil || room.CheckTurn("u1") == nil {
+		t.Fatalf("expected the navigator with the turn to edit")
+	}
+}
+
+func TestRoomUndoRedo(t *testing.T) {
+	room := NewRoom("r")
+	edit := func(userID string, base int64, start, end int, text string) {
+		t.Helper()
+		if ok, _, _, err := room.ApplyEditFrom(userID, models.Edit{BaseVersion: base, RangeStart: start, RangeEnd: end, Text: text}); !ok {
+			t.Fatalf("edit %q: %v", text, err)
+		}
+	}
+	expectDoc := func(prev, doc models.DocState, err error, wantPrev int64, want string) {
+		t.Helper()
+		if err != nil || prev.Version != wantPrev || doc.Version != wantPrev+1 || doc.Text != want {
+			t.Fatalf("expected %q at version %d, got %+v (from %d) err=%v", want, wantPrev+1, doc, prev.Version, err)
+		}
+	}
+
+	if _, _, err := room.Undo("u1"); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected nothing to undo, got %v", err)
+	}
+	edit("u1", 0, 0, 0, "print(1)")
+	edit("u1", 1, 6, 7, "42")
+
+	prev, doc, err := room.Undo("u1")
+	expectDoc(prev, doc, err, 2, "print(1)")
+	prev, doc, err = room.Undo("u1")
+	expectDoc(prev, doc, err, 3, "")
+	if _, _, err := room.Undo("u1"); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected nothing left to undo, got %v", err)
+	}
+
+	prev, doc, err = room.Redo("u1")
+	expectDoc(prev, doc, err, 4, "print(1)")
+	prev, doc, err = room.Redo("u1")
+	expectDoc(prev, doc, err, 5, "print(42)")
+	if _, _, err := room.Redo("u1"); !errors.Is(err, ErrNothingToRedo) {
+		t.Fatalf("expected nothing left to redo, got %v", err)
+	}
+
+	// A new edit ends what could be redone
+	_, _, _ = room.Undo("u1")
+	edit("u1", 7, 0, 0, "# ")
+	if _, _, err := room.Redo("u1"); !errors.Is(err, ErrNothingToRedo) {
+		t.Fatalf("expected a new edit to clear redo, got %v", err)
+	}
+	if d, _ := room.Snapshot(); d.Text != "# print(1)" {
+		t.Fatalf("unexpected document %q", d.Text)
+	}
+}
+
+func TestRoomUndoIsScopedToOwnEdits(t *testing.T) {
+	room := NewRoom("r")
+	_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: 0, Text: "a = 1\n"})
+	// u2 types after u1's line, from a version that had not seen it
+	_, _, _, _ = room.ApplyEditFrom("u2", models.Edit{BaseVersion: 0, Text: "b = 2\n"})
+	_, _, _, _ = room.ApplyEditFrom("u2", models.Edit{BaseVersion: 2, RangeStart: 12, RangeEnd: 12, Text: "c = 3\n"})
+
+	// u1's undo skips the partner's later edits, which sit outside its text
+	_, doc, err := room.Undo("u1")
+	if err != nil || doc.Text != "b = 2\nc = 3\n" {
+		t.Fatalf("expected only u1's line undone, got %q err=%v", doc.Text, err)
+	}
+	_, doc, err = room.Undo("u2")
+	if err != nil || doc.Text != "b = 2\n" {
+		t.Fatalf("expected u2's last line undone, got %q err=%v", doc.Text, err)
+	}
+	_, doc, err = room.Redo("u1")
+	if err != nil || doc.Text != "a = 1\nb = 2\n" {
+		t.Fatalf("expected u1's line back, got %q err=%v", doc.Text, err)
+	}
+}
+
+func TestRoomUndoConflict(t *testing.T) {
+	room := NewRoom("r")
+	_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: 0, Text: "total = 0"})
+	_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: 1, RangeStart: 5, RangeEnd: 5, Text: "_sum"})
+	// The partner changes the text of u1's last edit
+	_, _, _, _ = room.ApplyEditFrom("u2", models.Edit{BaseVersion: 2, RangeStart: 6, RangeEnd: 9, Text: "count"})
+
+	if _, doc, err := room.Undo("u1"); !errors.Is(err, ErrUndoConflict) || doc.Text != "total_count = 0" || doc.Version != 3 {
+		t.Fatalf("expected undo_conflict and the document untouched, got %+v err=%v", doc, err)
+	}
+	// The conflicting edit is dropped and undo moves on, to an edit the
+	// partner also changed
+	if _, _, err := room.Undo("u1"); !errors.Is(err, ErrUndoConflict) {
+		t.Fatalf("expected the earlier edit to conflict too, got %v", err)
+	}
+	if _, _, err := room.Undo("u1"); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected nothing left to undo, got %v", err)
+	}
+
+	// Replacing the whole document conflicts with everything before it
+	_, _, _, _ = room.ApplyEditFrom("u2", models.Edit{BaseVersion: 3, RangeStart: 0, RangeEnd: 0, Text: "# "})
+	if _, err := room.ReplaceDoc("restored"); err != nil {
+		t.Fatalf("ReplaceDoc: %v", err)
+	}
+	if _, _, err := room.Undo("u2"); !errors.Is(err, ErrUndoConflict) {
+		t.Fatalf("expected undo across a restore to conflict, got %v", err)
+	}
+}
+
+func TestRoomUndoKeepsEditThatDoesNotFit(t *testing.T) {
+	room := NewRoom("r", WithMaxDocBytes(8))
+	_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: 0, Text: "abcdef"})
+	_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: 1, RangeStart: 0, RangeEnd: 3})
+	_, _, _, _ = room.ApplyEditFrom("u2", models.Edit{BaseVersion: 2, RangeStart: 3, RangeEnd: 3, Text: "12345"})
+
+	// Putting "abc" back would take the document past the cap
+	if _, doc, err := room.Undo("u1"); !errors.Is(err, ErrDocTooLarge) || doc.Text != "def12345" {
+		t.Fatalf("expected doc_too_large and the document untouched, got %+v err=%v", doc, err)
+	}
+	if _, _, err := room.Undo("u2"); err != nil {
+		t.Fatalf("undo u2: %v", err)
+	}
+	if _, doc, err := room.Undo("u1"); err != nil || doc.Text != "abcdef" {
+		t.Fatalf("expected the deletion undone once it fits, got %q err=%v", doc.Text, err)
+	}
+}
+
+func TestRoomUndoLogIsBounded(t *testing.T) {
+	room := NewRoom("r")
+	for v := int64(0); v < maxUndoLog+10; v++ {
+		_, _, _, _ = room.ApplyEditFrom("u1", models.Edit{BaseVersion: v, RangeStart: int(v), RangeEnd: int(v), Text: "x"})
+	}
+	if len(room.undo.log) != maxUndoLog || len(room.undo.undo["u1"]) != maxUndoLog {
+		t.Fatalf("expected the last %d edits kept, got %d logged and %d undoable", maxUndoLog, len(room.undo.log), len(room.undo.undo["u1"]))
+	}
+	// Undos are edits too and push the oldest edits out of the log
+	var err error
+	for err == nil {
+		_, _, err = room.Undo("u1")
+	}
+	if d, _ := room.Snapshot(); !errors.Is(err, ErrNothingToUndo) || len(d.Text) < 10 {
+		t.Fatalf("expected the edits before the log to stay, got %q err=%v", d.Text, err)
+	}
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"sort"
+
+	"collab/internal/models"
+)
+
+// settingDomains lists the values each room setting accepts.
+var settingDomains = map[string][]any{
+	"tabSize":       {2, 4, 8},
+	"autoRunOnSave": {false, true},
+	"keymap":        {"normal", "vim", "emacs"},
+	"theme":         {"light", "dark", "high_contrast"},
+}
+
+// SettingError rejects a settings update. Allowed is the domain of Key, or
+// the known keys when Key is not a setting.
+type SettingError struct {
+	Key     string
+	Allowed []any
+}
+
+func (e *SettingError) Error() string { return "invalid_setting" }
+
+// DefaultRoomSettings are the settings of a new room.
+func DefaultRoomSettings() models.RoomSettings {
+	return models.RoomSettings{TabSize: 4, AutoRunOnSave: false, Keymap: "normal", Theme: "light"}
+}
+
+// Settings returns the room's current settings.
+func (r *Room) Settings() models.RoomSettings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.settings
+}
+
+// UpdateSettings applies a partial update (setting key to JSON value). The
+// update is validated as a whole, so an invalid entry changes nothing;
+// concurrent updates are last-writer-wins. It returns the resulting settings
+// and the keys whose value changed, sorted.
+func (r *Room) UpdateSettings(changes map[string]any) (models.RoomSettings, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := r.settings
+	for key, value := range changes {
+		if !applySetting(&next, key, value) {
+			if domain, known := settingDomains[key]; known {
+				return r.settings, nil, &SettingError{Key: key, Allowed: domain}
+			}
+			return r.settings, nil, &SettingError{Key: key, Allowed: settingKeys()}
+		}
+	}
+
+	var changed []string
+	for key := range changes {
+		if settingValue(next, key) != settingValue(r.settings, key) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	r.settings = next
+	if len(changed) > 0 {
+		r.settingsChanged = true
+	}
+	return next, changed, nil
+}
+
+// RestoreSettings seeds the room from a persisted snapshot. It is a no-op once
+// the room's settings have been changed, so a live room is never rolled back.
+func (r *Room) RestoreSettings(settings models.RoomSettings) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.settingsChanged {
+		return false
+	}
+	r.settings = settings
+	return true
+}
+
+// applySetting validates value against the key's domain and stores it in s.
+// JSON numbers arrive as float64.
+func applySetting(s *models.RoomSettings, key string, value any) bool {
+	switch key {
+	case "tabSize":
+		n, ok := value.(float64)
+		if !ok || !inDomain(key, int(n)) || float64(int(n)) != n {
+			return false
+		}
+		s.TabSize = int(n)
+	case "autoRunOnSave":
+		b, ok := value.(bool)
+		if !ok {
+			return false
+		}
+		s.AutoRunOnSave = b
+	case "keymap":
+		v, ok := value.(string)
+		if !ok || !inDomain(key, v) {
+			return false
+		}
+		s.Keymap = v
+	case "theme":
+		v, ok := value.(string)
+		if !ok || !inDomain(key, v) {
+			return false
+		}
+		s.Theme = v
+	default:
+		return false
+	}
+	return true
+}
+
+func settingValue(s models.RoomSettings, key string) any {
+	switch key {
+	case "tabSize":
+		return s.TabSize
+	case "autoRunOnSave":
+		return s.AutoRunOnSave
+	case "keymap":
+		return s.Keymap
+	case "theme":
+		return s.Theme
+	}
+	return nil
+}
+
+func inDomain(key string, value any) bool {
+	for _, allowed := range settingDomains[key] {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func settingKeys() []any {
+	keys := make([]string, 0, len(settingDomains))
+	for key := range settingDomains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]any, len(keys))
+	for i, key := range keys {
+		out[i] = key
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"unicode/utf8"
+
+	"collab/internal/models"
+)
+
+// SnapshotStore keeps the recent snapshots of room documents, so users can
+// go back to an earlier point of the session.
+type SnapshotStore interface {
+	AddSnapshot(matchID string, snap models.Snapshot) error
+}
+
+// SetSnapshotStore makes every room created afterwards write a snapshot of its
+// document to store each time it gained every versions. Failed writes go to
+// the DocStore's onError. every <= 0 turns snapshots off.
+func (h *Hub) SetSnapshotStore(store SnapshotStore, every int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshotStore = store
+	h.snapshotEvery = every
+}
+
+// ReplaceDoc swaps the whole document for text as a single edit on top of
+// the current version, so every client can apply it like any other.
+func (r *Room) ReplaceDoc(text string) (models.DocState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, doc, err := r.replaceDocLocked(text)
+	return doc, err
+}
+
+func (r *Room) replaceDocLocked(text string) (bool, models.DocState, error) {
+	return r.applyEditLocked("", models.Edit{
+		BaseVersion: r.doc.Version,
+		RangeStart:  0,
+		RangeEnd:    utf8.RuneCountInString(r.doc.Text),
+		Text:        text,
+	})
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"errors"
+
+	"collab/internal/metrics"
+)
+
+// defaultMaxSpectators is how many spectators may watch a room at once
+const defaultMaxSpectators = 5
+
+var ErrSpectatorsFull = errors.New("spectators_full")
+
+// WithMaxSpectators lets at most n spectators watch the room at once; 0 turns
+// spectating off.
+func WithMaxSpectators(n int) RoomOption {
+	return func(r *Room) { r.maxSpectators = n }
+}
+
+// JoinSpectator adds c as a read-only spectator. Spectators get the room's
+// broadcasts but are not participants: they do not count towards the two
+// seats, take turns, show up in presence or keep the session alive.
+func (r *Room) JoinSpectator(c *Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.spectators[c]; ok {
+		return nil
+	}
+	if len(r.spectators) >= r.maxSpectators {
+		return ErrSpectatorsFull
+	}
+	if r.spectators == nil {
+		r.spectators = make(map[*Client]struct{})
+	}
+	if !r.detached {
+		metrics.ConnectionOpened()
+	}
+	r.spectators[c] = struct{}{}
+	r.assignColorLocked(c.UserID)
+	return nil
+}
+
+// SpectatorCount returns how many spectators are watching the room.
+func (r *Room) SpectatorCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.spectators)
+}
+
+// leaveSpectatorLocked removes c if it is a spectator, reporting whether it was.
+func (r *Room) leaveSpectatorLocked(c *Client) bool {
+	if _, ok := r.spectators[c]; !ok {
+		return false
+	}
+	if !r.detached {
+		metrics.ConnectionsClosed(1)
+	}
+	delete(r.spectators, c)
+	return true
+}
@@ -0,0 +1,258 @@
+package session
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"collab/internal/models"
+)
+
+const (
+	// maxThreads bounds the discussion threads kept (and persisted) per room.
+	maxThreads = 20
+	// maxThreadReplies bounds the replies of one thread, assistant replies included.
+	maxThreadReplies = 50
+	maxThreadTitle   = 200  // runes
+	maxThreadBody    = 4000 // runes, of a thread's opening body or a reply
+)
+
+var (
+	ErrInvalidThread       = errors.New("invalid_thread")
+	ErrThreadTooLong       = errors.New("thread_too_long")
+	ErrTooManyThreads      = errors.New("too_many_threads")
+	ErrTooManyReplies      = errors.New("too_many_replies")
+	ErrUnknownThread       = errors.New("unknown_thread")
+	ErrThreadResolved      = errors.New("thread_resolved")
+	ErrHintBudgetExhausted = errors.New("hint_budget_exhausted")
+)
+
+// CreateThread opens a thread under the next server-assigned id.
+func (r *Room) CreateThread(author string, create models.ThreadCreate) (models.Thread, error) {
+	title := strings.TrimSpace(create.Title)
+	if title == "" {
+		return models.Thread{}, ErrInvalidThread
+	}
+	if utf8.RuneCountInString(title) > maxThreadTitle || utf8.RuneCountInString(create.Body) > maxThreadBody {
+		return models.Thread{}, ErrThreadTooLong
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.threads) >= maxThreads {
+		return models.Thread{}, ErrTooManyThreads
+	}
+	var id int64 = 1
+	if n := len(r.threads); n > 0 {
+		id = r.threads[n-1].ID + 1
+	}
+	thread := models.Thread{
+		ID:        id,
+		Title:     title,
+		Body:      create.Body,
+		Author:    author,
+		CreatedAt: r.clock.Now().UnixMilli(),
+		Replies:   []models.ThreadReply{},
+	}
+	r.threads = append(r.threads, thread)
+	r.threadsChanged = true
+	return copyThread(thread), nil
+}
+
+// ReplyToThread appends a participant's reply to an open thread. A reply that
+// asks the assistant spends one of aiBudget assistant replies per room and
+// needs room for the answer as well; RefundThreadAI gives the spend back when
+// no answer comes.
+func (r *Room) ReplyToThread(author string, cmd models.ThreadReplyCmd, aiBudget int) (models.ThreadReply, error) {
+	if strings.TrimSpace(cmd.Body) == "" {
+		return models.ThreadReply{}, ErrInvalidThread
+	}
+	if utf8.RuneCountInString(cmd.Body) > maxThreadBody {
+		return models.ThreadReply{}, ErrThreadTooLong
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	thread := r.threadLocked(cmd.ThreadID)
+	if thread == nil {
+		return models.ThreadReply{}, ErrUnknownThread
+	}
+	if thread.Resolved {
+		return models.ThreadReply{}, ErrThreadResolved
+	}
+	needed := 1
+	if cmd.AskAI {
+		needed = 2
+	}
+	if len(thread.Replies)+needed > maxThreadReplies {
+		return models.ThreadReply{}, ErrTooManyReplies
+	}
+	if cmd.AskAI {
+		if r.threadAIUsed >= aiBudget {
+			return models.ThreadReply{}, ErrHintBudgetExhausted
+		}
+		r.threadAIUsed++
+	}
+	return r.appendReplyLocked(thread, author, cmd.Body, cmd.AskAI), nil
+}
+
+// PostThreadReply appends a reply the server posts on behalf of the assistant
+// or itself. It lands even if the thread was resolved in the meantime, since
+// the request for it was made while the thread was open. body is truncated to
+// the reply size limit.
+func (r *Room) PostThreadReply(threadID int64, author, body string) (models.ThreadReply, error) {
+	if utf8.RuneCountInString(body) > maxThreadBody {
+		body = string([]rune(body)[:maxThreadBody])
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	thread := r.threadLocked(threadID)
+	if thread == nil {
+		return models.ThreadReply{}, ErrUnknownThread
+	}
+	if len(thread.Replies) >= maxThreadReplies {
+		return models.ThreadReply{}, ErrTooManyReplies
+	}
+	return r.appendReplyLocked(thread, author, body, false), nil
+}
+
+// RefundThreadAI returns an assistant reply to the room's budget after the
+// assistant failed to answer.
+func (r *Room) RefundThreadAI() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.threadAIUsed > 0 {
+		r.threadAIUsed--
+	}
+}
+
+// ResolveThread closes a thread for either participant.
+func (r *Room) ResolveThread(by string, threadID int64) (models.ThreadResolved, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	thread := r.threadLocked(threadID)
+	if thread == nil {
+		return models.ThreadResolved{}, ErrUnknownThread
+	}
+	if thread.Resolved {
+		return models.ThreadResolved{}, ErrThreadResolved
+	}
+	thread.Resolved = true
+	thread.ResolvedBy = by
+	thread.ResolvedAt = r.clock.Now().UnixMilli()
+	r.threadsChanged = true
+	return models.ThreadResolved{ThreadID: thread.ID, ResolvedBy: by, ResolvedAt: thread.ResolvedAt}, nil
+}
+
+// Thread returns a copy of one thread, e.g. to give the assistant its context.
+func (r *Room) Thread(threadID int64) (models.Thread, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	thread := r.threadLocked(threadID)
+	if thread == nil {
+		return models.Thread{}, false
+	}
+	return copyThread(*thread), true
+}
+
+// Threads returns a copy of the room's threads for init, or nil when there
+// are none.
+func (r *Room) Threads() []models.Thread {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.threads) == 0 {
+		return nil
+	}
+	return r.threadsLocked()
+}
+
+// ThreadState returns a copy of the room's threads and hint budget spend for
+// persistence.
+func (r *Room) ThreadState() models.ThreadState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return models.ThreadState{Threads: r.threadsLocked(), AIRepliesUsed: r.threadAIUsed}
+}
+
+// RestoreThreadState seeds the room from a persisted snapshot. It is a no-op
+// once the room holds threads or they changed, so a live room is never rolled
+// back.
+func (r *Room) RestoreThreadState(state models.ThreadState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.threadsChanged || len(r.threads) > 0 {
+		return false
+	}
+	sort.Slice(state.Threads, func(i, j int) bool { return state.Threads[i].ID < state.Threads[j].ID })
+	r.threads = state.Threads
+	for i := range r.threads {
+		if r.threads[i].Replies == nil {
+			r.threads[i].Replies = []models.ThreadReply{}
+		}
+	}
+	r.threadAIUsed = state.AIRepliesUsed
+	return true
+}
+
+// ThreadSummary counts the room's threads for the session record, or returns
+// nil when none were opened.
+func (r *Room) ThreadSummary() *models.ThreadSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.threads) == 0 {
+		return nil
+	}
+	summary := &models.ThreadSummary{
+		Total:   len(r.threads),
+		Threads: make([]models.ThreadOutcome, len(r.threads)),
+	}
+	for i, thread := range r.threads {
+		if thread.Resolved {
+			summary.Resolved++
+		} else {
+			summary.Unresolved++
+		}
+		summary.Replies += len(thread.Replies)
+		for _, reply := range thread.Replies {
+			if reply.Author == models.ThreadAuthorAssistant {
+				summary.AIReplies++
+			}
+		}
+		summary.Threads[i] = models.ThreadOutcome{ID: thread.ID, Resolved: thread.Resolved, Replies: len(thread.Replies)}
+	}
+	return summary
+}
+
+func (r *Room) appendReplyLocked(thread *models.Thread, author, body string, askAI bool) models.ThreadReply {
+	var id int64 = 1
+	if n := len(thread.Replies); n > 0 {
+		id = thread.Replies[n-1].ID + 1
+	}
+	reply := models.ThreadReply{ID: id, Author: author, Body: body, SentAt: r.clock.Now().UnixMilli(), AskAI: askAI}
+	thread.Replies = append(thread.Replies, reply)
+	r.threadsChanged = true
+	return reply
+}
+
+func (r *Room) threadLocked(id int64) *models.Thread {
+	i := sort.Search(len(r.threads), func(i int) bool { return r.threads[i].ID >= id })
+	if i < len(r.threads) && r.threads[i].ID == id {
+		return &r.threads[i]
+	}
+	return nil
+}
+
+func (r *Room) threadsLocked() []models.Thread {
+	threads := make([]models.Thread, len(r.threads))
+	for i, thread := range r.threads {
+		threads[i] = copyThread(thread)
+	}
+	return threads
+}
+
+func copyThread(thread models.Thread) models.Thread {
+	thread.Replies = append([]models.ThreadReply{}, thread.Replies...)
+	return thread
+}
@@ -0,0 +1,360 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"collab/internal/metrics"
+	"collab/internal/models"
+)
+
+const (
+	defaultTurnDuration = 10 * time.Minute
+	minTurnDuration     = time.Minute
+	maxTurnDuration     = time.Hour
+	// modeChangeTimeout bounds how long a mode change waits for the partner.
+	modeChangeTimeout = 30 * time.Second
+	// turnAwayGrace is how long a disconnected active user keeps their turn.
+	turnAwayGrace = 30 * time.Second
+)
+
+var (
+	ErrInvalidMode     = errors.New("invalid_mode")
+	ErrModeUnchanged   = errors.New("mode_unchanged")
+	ErrModePending     = errors.New("mode_pending")
+	ErrNoPendingMode   = errors.New("no_pending_mode")
+	ErrPartnerRequired = errors.New("partner_required")
+	ErrNotYourTurn     = errors.New("not_your_turn")
+	ErrTurnsDisabled   = errors.New("turns_disabled")
+)
+
+type pendingMode struct {
+	requester *Client
+	set       models.ModeSet
+	expiresAt time.Time
+}
+
+// turnState is the rotation of turn-taking mode. gen changes with every turn,
+// so a timer armed for an earlier turn does nothing when it fires.
+type turnState struct {
+	duration  time.Duration
+	users     [2]string
+	active    int // index into users
+	startedAt time.Time
+	endsAt    time.Time
+	gen       int
+	timer     func() bool // ends the turn at endsAt
+	away      func() bool // passes the turn of a disconnected active user
+}
+
+// SetTurnStateHandler registers a callback for every change of the turn
+// state, e.g. to persist it. It runs without the room lock held.
+func (r *Room) SetTurnStateHandler(handler func(models.TurnState)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.turnHandler = handler
+}
+
+// RequestModeChange parks a switch to set.Mode until the partner confirms it.
+// Only one change may be pending per room, and both participants must be
+// connected.
+func (r *Room) RequestModeChange(requester *Client, set models.ModeSet) (models.ModeRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch set.Mode {
+	case models.ModeTurns:
+		if set.TurnDurationSec == 0 {
+			set.TurnDurationSec = int(defaultTurnDuration.Seconds())
+		}
+		d := time.Duration(set.TurnDurationSec) * time.Second
+		if d < minTurnDuration || d > maxTurnDuration {
+			return models.ModeRequest{}, ErrInvalidMode
+		}
+		if r.turns != nil {
+			return models.ModeRequest{}, ErrModeUnchanged
+		}
+	case models.ModeFree:
+		set.TurnDurationSec = 0
+		if r.turns == nil {
+			return models.ModeRequest{}, ErrModeUnchanged
+		}
+	default:
+		return models.ModeRequest{}, ErrInvalidMode
+	}
+	if p := r.pendingMode; p != nil && r.clock.Now().Before(p.expiresAt) {
+		return models.ModeRequest{}, ErrModePending
+	}
+	if r.partnerLocked(requester.UserID) == "" {
+		return models.ModeRequest{}, ErrPartnerRequired
+	}
+	r.pendingMode = &pendingMode{
+		requester: requester,
+		set:       set,
+		expiresAt: r.clock.Now().Add(modeChangeTimeout),
+	}
+	return models.ModeRequest{UserID: requester.UserID, Mode: set.Mode, TurnDurationSec: set.TurnDurationSec}, nil
+}
+
+// ResolveModeChange applies (accept) or drops the pending mode change on
+// behalf of the partner. The requester drives the first turn.
+func (r *Room) ResolveModeChange(responder *Client, accept bool) (*Client, models.TurnState, error) {
+	r.mu.Lock()
+	p := r.pendingMode
+	if p == nil || responder.UserID == "" || p.requester.UserID == responder.UserID || r.clock.Now().After(p.expiresAt) {
+		state := r.turnStateLocked()
+		r.mu.Unlock()
+		return nil, state, ErrNoPendingMode
+	}
+	r.pendingMode = nil
+	if !accept {
+		state := r.turnStateLocked()
+		r.mu.Unlock()
+		return p.requester, state, nil
+	}
+
+	now := r.clock.Now()
+	if p.set.Mode == models.ModeTurns {
+		r.turns = &turnState{
+			duration: time.Duration(p.set.TurnDurationSec) * time.Second,
+			users:    [2]string{p.requester.UserID, responder.UserID},
+		}
+		r.beginTurnLocked(now, "start")
+	} else {
+		r.endTurnLocked(now, "disabled")
+		r.turns = nil
+	}
+	r.turnsChanged = true
+	state, notify := r.turnStateLocked(), r.turnHandler
+	r.mu.Unlock()
+
+	if notify != nil {
+		notify(state)
+	}
+	return p.requester, state, nil
+}
+
+// PassTurn hands the turn to the partner before the timer elapses. Only the
+// active user may pass.
+func (r *Room) PassTurn(c *Client) error {
+	r.mu.Lock()
+	if r.turns == nil {
+		r.mu.Unlock()
+		return ErrTurnsDisabled
+	}
+	if c.UserID != r.turns.users[r.turns.active] {
+		r.mu.Unlock()
+		return ErrNotYourTurn
+	}
+	r.rotateTurnLocked("pass")
+	r.notifyTurnsUnlock()
+	return nil
+}
+
+// CheckTurn reports whether userID may edit and run code: anyone in free
+// mode, only the active user in turn-taking mode.
+func (r *Room) CheckTurn(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.turns != nil && userID != r.turns.users[r.turns.active] {
+		return ErrNotYourTurn
+	}
+	return nil
+}
+
+// TurnState returns the room's editing mode and turn for init and snapshots.
+func (r *Room) TurnState() models.TurnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.turnStateLocked()
+}
+
+// DrivingTime returns how long each user has been the active user, including
+// the turn in progress.
+func (r *Room) DrivingTime() map[string]time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	driving := make(map[string]time.Duration, len(r.driving))
+	for user, d := range r.driving {
+		driving[user] = d
+	}
+	if t := r.turns; t != nil {
+		driving[t.users[t.active]] += r.clock.Now().Sub(t.startedAt)
+	}
+	return driving
+}
+
+// RestoreTurnState seeds the room from a persisted snapshot, resuming the turn
+// in progress with its original deadline. It is a no-op once turns are running
+// or have changed in this room, so a live room is never rolled back.
+func (r *Room) RestoreTurnState(state models.TurnState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.turnsChanged || r.turns != nil {
+		return false
+	}
+	r.driving = make(map[string]time.Duration, len(state.DrivingMs))
+	for user, ms := range state.DrivingMs {
+		r.driving[user] = time.Duration(ms) * time.Millisecond
+	}
+	if state.Mode != models.ModeTurns || len(state.Users) != 2 || state.TurnDurationSec <= 0 {
+		return true
+	}
+
+	t := &turnState{
+		duration:  time.Duration(state.TurnDurationSec) * time.Second,
+		users:     [2]string{state.Users[0], state.Users[1]},
+		startedAt: time.UnixMilli(state.StartedAt),
+		endsAt:    time.UnixMilli(state.EndsAt),
+	}
+	if state.ActiveUser == t.users[1] {
+		t.active = 1
+	}
+	r.turns = t
+	r.armTurnTimersLocked(r.clock.Now())
+	return true
+}
+
+// turnJoinedLocked keeps the turn of an active user who reconnected in time.
+func (r *Room) turnJoinedLocked(c *Client) {
+	if t := r.turns; t != nil && c.UserID == t.users[t.active] {
+		stopTimer(&t.away)
+	}
+}
+
+// turnLeftLocked drops any pending mode change and starts the grace period of
+// an active user whose last connection closed. c must already be removed from
+// the room.
+func (r *Room) turnLeftLocked(c *Client) {
+	r.pendingMode = nil
+	if t := r.turns; t != nil && c.UserID == t.users[t.active] && !r.connectedLocked(c.UserID) {
+		r.armAwayLocked()
+	}
+}
+
+func (r *Room) rotateTurnLocked(reason string) {
+	now := r.clock.Now()
+	r.endTurnLocked(now, reason)
+	r.turns.active = 1 - r.turns.active
+	r.beginTurnLocked(now, reason)
+	r.turnsChanged = true
+}
+
+// endTurnLocked credits the turn in progress to its user.
+func (r *Room) endTurnLocked(now time.Time, reason string) {
+	t := r.turns
+	if t == nil {
+		return
+	}
+	stopTimer(&t.timer)
+	stopTimer(&t.away)
+	elapsed := now.Sub(t.startedAt)
+	if r.driving == nil {
+		r.driving = make(map[string]time.Duration)
+	}
+	r.driving[t.users[t.active]] += elapsed
+	metrics.ObserveTurn(reason, elapsed)
+}
+
+func (r *Room) beginTurnLocked(now time.Time, reason string) {
+	t := r.turns
+	t.startedAt = now
+	t.endsAt = now.Add(t.duration)
+	r.armTurnTimersLocked(now)
+
+	active := t.users[t.active]
+	r.recordActivityLocked("turn", active, reason)
+	r.broadcastFrameLocked(models.WSFrame{
+		Type: "turn_changed",
+		Data: models.TurnChanged{ActiveUser: active, EndsAt: t.endsAt.UnixMilli(), Reason: reason},
+	})
+}
+
+// armTurnTimersLocked schedules the end of the current turn and, when its
+// user is not connected, the disconnect auto-pass.
+func (r *Room) armTurnTimersLocked(now time.Time) {
+	t := r.turns
+	t.gen++
+	gen := t.gen
+	stopTimer(&t.timer)
+	stopTimer(&t.away)
+	t.timer = r.clock.AfterFunc(max(t.endsAt.Sub(now), 0), func() { r.turnExpired(gen, "timer") })
+	if !r.connectedLocked(t.users[t.active]) {
+		r.armAwayLocked()
+	}
+}
+
+func (r *Room) armAwayLocked() {
+	t := r.turns
+	gen := t.gen
+	stopTimer(&t.away)
+	t.away = r.clock.AfterFunc(turnAwayGrace, func() { r.turnExpired(gen, "disconnect") })
+}
+
+// turnExpired rotates the turn armed as gen, unless it already ended or its
+// user came back before the disconnect grace ran out.
+func (r *Room) turnExpired(gen int, reason string) {
+	r.mu.Lock()
+	t := r.turns
+	if t == nil || t.gen != gen || r.sessionEnded {
+		r.mu.Unlock()
+		return
+	}
+	if reason == "disconnect" && r.connectedLocked(t.users[t.active]) {
+		r.mu.Unlock()
+		return
+	}
+	r.rotateTurnLocked(reason)
+	r.notifyTurnsUnlock()
+}
+
+// notifyTurnsUnlock releases the room lock and hands the new turn state to
+// the registered handler.
+func (r *Room) notifyTurnsUnlock() {
+	state, notify := r.turnStateLocked(), r.turnHandler
+	r.mu.Unlock()
+	if notify != nil {
+		notify(state)
+	}
+}
+
+func (r *Room) turnStateLocked() models.TurnState {
+	state := models.TurnState{Mode: models.ModeFree}
+	if len(r.driving) > 0 {
+		state.DrivingMs = make(map[string]int64, len(r.driving))
+		for user, d := range r.driving {
+			state.DrivingMs[user] = d.Milliseconds()
+		}
+	}
+	if t := r.turns; t != nil {
+		state.Mode = models.ModeTurns
+		state.TurnDurationSec = int(t.duration.Seconds())
+		state.Users = []string{t.users[0], t.users[1]}
+		state.ActiveUser = t.users[t.active]
+		state.StartedAt = t.startedAt.UnixMilli()
+		state.EndsAt = t.endsAt.UnixMilli()
+	}
+	return state
+}
+
+// partnerLocked returns the user ID of a connected participant other than
+// userID, or "" when there is none.
+func (r *Room) partnerLocked(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	for c := range r.clients {
+		if c.UserID != "" && c.UserID != userID {
+			return c.UserID
+		}
+	}
+	return ""
+}
+
+func (r *Room) connectedLocked(userID string) bool {
+	for c := range r.clients {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
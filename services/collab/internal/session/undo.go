@@ -0,0 +1,203 @@
+package session
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"collab/internal/models"
+)
+
+// maxUndoLog is how many applied edits a room remembers for undo and redo
+const maxUndoLog = 200
+
+var (
+	ErrUndoConflict  = errors.New("undo_conflict")
+	ErrNothingToUndo = errors.New("nothing_to_undo")
+	ErrNothingToRedo = errors.New("nothing_to_redo")
+)
+
+// undoEntry is an applied edit as the OT buffer applied it, after it was
+// transformed against the edits its author had not seen.
+type undoEntry struct {
+	userID   string // "" for edits the server made, like restores
+	version  int64  // document version the edit produced
+	pos      int    // in runes
+	deleted  string
+	inserted string
+	reverts  int64 // version of the entry this undo or redo reverted, 0 for edits
+}
+
+// undoState is the room's log of the last maxUndoLog edits, in the order they
+// were applied, and the versions of the entries each user may undo and redo.
+// A user's undo and redo only ever revert that user's own edits.
+type undoState struct {
+	log  []undoEntry
+	undo map[string][]int64
+	redo map[string][]int64
+}
+
+// recordEditLocked logs an applied edit
+func (r *Room) recordEditLocked(userID string, pos int, deleted, inserted string) {
+	r.undo.log = append(r.undo.log, undoEntry{
+		userID: userID, version: r.doc.Version, pos: pos, deleted: deleted, inserted: inserted,
+	})
+	if len(r.undo.log) > maxUndoLog {
+		r.undo.log = r.undo.log[len(r.undo.log)-maxUndoLog:]
+	}
+}
+
+// pushUndoLocked makes the edit just logged the next one userID undoes. A
+// new edit of the user's own ends what they could redo.
+func (r *Room) pushUndoLocked(userID string) {
+	if userID == "" {
+		return
+	}
+	if r.undo.undo == nil {
+		r.undo.undo = make(map[string][]int64)
+		r.undo.redo = make(map[string][]int64)
+	}
+	r.undo.undo[userID] = r.keptVersionsLocked(append(r.undo.undo[userID], r.doc.Version))
+	delete(r.undo.redo, userID)
+}
+
+// resetUndoLocked forgets every edit, for when the document is replaced
+// outside of edits and the logged positions no longer mean anything
+func (r *Room) resetUndoLocked() {
+	r.undo = undoState{}
+}
+
+// keptVersionsLocked drops the versions that fell out of the log
+func (r *Room) keptVersionsLocked(versions []int64) []int64 {
+	if len(r.undo.log) == 0 {
+		return nil
+	}
+	oldest := r.undo.log[0].version
+	for i, v := range versions {
+		if v >= oldest {
+			return versions[i:]
+		}
+	}
+	return nil
+}
+
+// Undo reverts the last edit of userID that is not undone yet, as a new edit
+// on top of the current version. The edit is dropped with ErrUndoConflict
+// when another edit has since changed the text it inserted or the place it
+// deleted from, so the next Undo moves on to the edit before it.
+func (r *Room) Undo(userID string) (prev, doc models.DocState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revertLocked(userID, false)
+}
+
+// Redo reapplies the edit the last Undo of userID reverted, as long as the
+// user has made no edit since.
+func (r *Room) Redo(userID string) (prev, doc models.DocState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revertLocked(userID, true)
+}
+
+// revertLocked reverts the top entry of userID's undo stack, or of their
+// redo stack when redo is set, and puts the revert on the other stack.
+func (r *Room) revertLocked(userID string, redo bool) (models.DocState, models.DocState, error) {
+	from, to, empty := r.undo.undo, r.undo.redo, ErrNothingToUndo
+	if redo {
+		from, to, empty = r.undo.redo, r.undo.undo, ErrNothingToRedo
+	}
+	stack := r.keptVersionsLocked(from[userID])
+	if userID == "" || len(stack) == 0 {
+		return r.doc, r.doc, empty
+	}
+	version := stack[len(stack)-1]
+
+	entry, start, end, ok := r.currentRangeLocked(version)
+	if !ok {
+		from[userID] = stack[:len(stack)-1]
+		return r.doc, r.doc, ErrUndoConflict
+	}
+	prev := r.doc
+	applied, doc, err := r.applyEditLocked(userID, models.Edit{
+		BaseVersion: r.doc.Version,
+		RangeStart:  start,
+		RangeEnd:    end,
+		Text:        entry.deleted,
+	})
+	if !applied {
+		// The edit stays to undo, say once the document has room for it
+		from[userID] = stack
+		return prev, doc, err
+	}
+	from[userID] = stack[:len(stack)-1]
+	r.undo.log[len(r.undo.log)-1].reverts = version
+	to[userID] = r.keptVersionsLocked(append(to[userID], r.doc.Version))
+	return prev, doc, nil
+}
+
+// currentRangeLocked follows the text the logged edit at version inserted
+// through every edit applied after it, and returns the edit with where that
+// text is now. It fails when a later edit touched that text, or deleted
+// across the spot where the edit only deleted text.
+func (r *Room) currentRangeLocked(version int64) (entry undoEntry, start, end int, ok bool) {
+	i := len(r.undo.log) - 1
+	for i >= 0 && r.undo.log[i].version != version {
+		i--
+	}
+	if i < 0 {
+		return undoEntry{}, 0, 0, false
+	}
+	entry = r.undo.log[i]
+	start = entry.pos
+	end = start + utf8.RuneCountInString(entry.inserted)
+	for _, later := range cancelReverts(r.undo.log[i+1:]) {
+		deleted := utf8.RuneCountInString(later.deleted)
+		switch {
+		case later.pos+deleted <= start:
+			shift := utf8.RuneCountInString(later.inserted) - deleted
+			start += shift
+			end += shift
+		case later.pos >= end:
+		default:
+			return entry, 0, 0, false
+		}
+	}
+	return entry, start, end, true
+}
+
+// cancelReverts drops from ops every edit that a later one of ops reverted,
+// along with the revert, and moves the edits in between to where they would
+// be had the reverted edit never been made. An undo followed by its redo, or
+// an edit followed by its undo, then no longer reads as a change.
+func cancelReverts(ops []undoEntry) []undoEntry {
+	out := make([]undoEntry, 0, len(ops))
+	for _, op := range ops {
+		k := len(out) - 1
+		for k >= 0 && (op.reverts == 0 || out[k].version != op.reverts) {
+			k--
+		}
+		if k < 0 {
+			out = append(out, op)
+			continue
+		}
+		reverted := out[k]
+		start := reverted.pos
+		end := start + utf8.RuneCountInString(reverted.inserted)
+		delta := utf8.RuneCountInString(reverted.inserted) - utf8.RuneCountInString(reverted.deleted)
+		between := out[k+1:]
+		for j := range between {
+			deleted := utf8.RuneCountInString(between[j].deleted)
+			switch {
+			case between[j].pos+deleted <= start:
+				shift := utf8.RuneCountInString(between[j].inserted) - deleted
+				start += shift
+				end += shift
+			case between[j].pos >= end:
+				between[j].pos -= delta
+			}
+			// Nothing in between overlaps the reverted edit, or the revert
+			// would have been refused
+		}
+		out = append(out[:k], between...)
+	}
+	return out
+}
@@ -2,12 +2,17 @@ package utils
 
 import (
 	"errors"
+	"log"
 	"os"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret []byte
+var (
+	// jwtSecret is the legacy single secret, still accepted for tokens without a kid
+	jwtSecret     []byte
+	roomTokenKeys *RoomTokenKeys
+)
 
 func init() {
 	secret := os.Getenv("JWT_SECRET")
@@ -15,23 +20,39 @@ func init() {
 		secret = "your-secret-key" // Default for development
 	}
 	jwtSecret = []byte(secret)
+
+	keys, err := LoadRoomTokenKeys(jwtSecret)
+	if err != nil {
+		log.Printf("Invalid room token key set, accepting legacy tokens only: %v", err)
+		keys, _ = NewRoomTokenKeys(nil, "", jwtSecret)
+	}
+	roomTokenKeys = keys
 }
 
+// Roles a room token grants. Tokens issued before roles existed carry none
+// and are participant tokens.
+const (
+	RoleParticipant = "participant"
+	RoleSpectator   = "spectator"
+)
+
 // RoomTokenClaims represents the claims in a room access token
 type RoomTokenClaims struct {
 	MatchId string `json:"matchId"`
 	UserId  string `json:"userId"`
+	Role    string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// ValidateRoomToken validates a JWT token and returns the claims
+// IsSpectator reports whether the token only lets its holder watch the room
+func (c *RoomTokenClaims) IsSpectator() bool {
+	return c.Role == RoleSpectator
+}
+
+// ValidateRoomToken validates a JWT token signed with any key in the room token
+// key set (or the legacy secret when it has no kid) and returns the claims
 func ValidateRoomToken(tokenString string) (*RoomTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &RoomTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &RoomTokenClaims{}, roomTokenKeys.Keyfunc)
 
 	if err != nil {
 		return nil, err
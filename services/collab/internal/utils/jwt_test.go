@@ -10,10 +10,21 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// useLegacySecret swaps in a key set that only knows the legacy secret
+func useLegacySecret(t *testing.T, secret []byte) {
+	t.Helper()
+	prevSecret, prevKeys := jwtSecret, roomTokenKeys
+	t.Cleanup(func() { jwtSecret, roomTokenKeys = prevSecret, prevKeys })
+	jwtSecret = secret
+	keys, err := NewRoomTokenKeys(nil, "", secret)
+	if err != nil {
+		t.Fatalf("build key set: %v", err)
+	}
+	roomTokenKeys = keys
+}
+
 func TestValidateRoomTokenSuccess(t *testing.T) {
-	prev := jwtSecret
-	t.Cleanup(func() { jwtSecret = prev })
-	jwtSecret = []byte("secret-key")
+	useLegacySecret(t, []byte("secret-key"))
 
 	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &RoomTokenClaims{
 		MatchId: "match-1",
@@ -33,9 +44,7 @@ func TestValidateRoomTokenSuccess(t *testing.T) {
 }
 
 func TestValidateRoomTokenInvalid(t *testing.T) {
-	prev := jwtSecret
-	t.Cleanup(func() { jwtSecret = prev })
-	jwtSecret = []byte("secret-a")
+	useLegacySecret(t, []byte("secret-a"))
 
 	badToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &RoomTokenClaims{
 		MatchId: "m",
@@ -51,10 +60,7 @@ func TestValidateRoomTokenInvalid(t *testing.T) {
 }
 
 func TestValidateRoomTokenUnexpectedMethod(t *testing.T) {
-	prev := jwtSecret
-	t.Cleanup(func() { jwtSecret = prev })
-	jwSecret := []byte("secret-a")
-	jwtSecret = jwSecret
+	useLegacySecret(t, []byte("secret-a"))
 
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
@@ -75,9 +81,7 @@ func TestValidateRoomTokenUnexpectedMethod(t *testing.T) {
 }
 
 func TestValidateRoomTokenExpired(t *testing.T) {
-	prev := jwtSecret
-	t.Cleanup(func() { jwtSecret = prev })
-	jwtSecret = []byte("secret-b")
+	useLegacySecret(t, []byte("secret-b"))
 
 	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &RoomTokenClaims{
 		MatchId: "m",
@@ -125,3 +129,41 @@ func TestLoggerMethods(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateRoomTokenWithKeyIDs(t *testing.T) {
+	useLegacySecret(t, []byte("legacy"))
+	keys, err := NewRoomTokenKeys([]RoomTokenKey{
+		{Kid: "2024-06", Secret: "new-secret"},
+		{Kid: "2024-01", Secret: "old-secret"},
+	}, "", jwtSecret)
+	if err != nil {
+		t.Fatalf("build key set: %v", err)
+	}
+	roomTokenKeys = keys
+
+	signWith := func(kid, secret string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &RoomTokenClaims{MatchId: "m", UserId: "u"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return s
+	}
+
+	for _, tok := range []string{
+		signWith("2024-06", "new-secret"),
+		signWith("2024-01", "old-secret"),
+		signWith("", "legacy"),
+	} {
+		if _, err := ValidateRoomToken(tok); err != nil {
+			t.Fatalf("expected token to validate, got %v", err)
+		}
+	}
+
+	if _, err := ValidateRoomToken(signWith("2023-01", "new-secret")); err == nil || !strings.Contains(err.Error(), ErrUnknownKid.Error()) {
+		t.Fatalf("expected unknown kid rejection, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"log"
 	"os"
 )
@@ -10,7 +11,11 @@ type Logger struct {
 }
 
 func NewLogger() *Logger {
-	return &Logger{l: log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)}
+	return NewLoggerTo(os.Stdout)
+}
+
+func NewLoggerTo(w io.Writer) *Logger {
+	return &Logger{l: log.New(w, "", log.LstdFlags|log.Lshortfile)}
 }
 
 func (lg *Logger) Info(msg string, kv ...any)  { lg.l.Println(append([]any{"INFO:", msg}, kv...)...) }
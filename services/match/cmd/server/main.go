@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,6 +13,7 @@ import (
 	"match/internal/match_management"
 	"match/internal/metrics"
 	"match/internal/routers"
+	"match/internal/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -51,6 +53,22 @@ func main() {
 
 	mm := match_management.NewMatchManager(jwtSecret, rdb, pubSubClient)
 
+	// Room tokens are signed with the newest key in ROOM_TOKEN_KEYS (or
+	// ROOM_TOKEN_SIGNING_KID); JWT_SECRET remains the legacy fallback
+	tokenKeys, err := utils.LoadRoomTokenKeys(jwtSecret)
+	if err != nil {
+		log.Fatalf("invalid room token key configuration: %v", err)
+	}
+	mm.SetTokenKeys(tokenKeys)
+	mm.SetAdminToken(strings.TrimSpace(os.Getenv("MATCH_ADMIN_TOKEN")))
+
+	// Stage timeouts and Elo bands; PUT /api/v1/match/admin/config overrides them at runtime
+	matchConfig, err := match_management.LoadMatchConfig()
+	if err != nil {
+		log.Fatalf("invalid matchmaking configuration: %v", err)
+	}
+	mm.SetConfig(matchConfig)
+
 	// Start background processes
 	go mm.StartMatchmakingLoop()
 	go mm.StartPendingMatchExpirationLoop()
@@ -240,18 +240,11 @@ func (em *EloManager) publishEloUpdate(update *models.EloUpdate) {
 	}
 }
 
-// CheckEloCompatibility checks if two users are within the Elo range for a given stage
-func CheckEloCompatibility(elo1, elo2 float64, stage int) bool {
-	diff := math.Abs(elo1 - elo2)
-
-	switch stage {
-	case 1:
-		return diff <= 100
-	case 2:
-		return diff <= 200
-	case 3:
-		return diff <= 300
-	default:
+// CheckEloCompatibility checks if two users are within the config's Elo band for a given stage
+func CheckEloCompatibility(elo1, elo2 float64, stage int, cfg models.MatchConfig) bool {
+	band, ok := cfg.EloBand(stage)
+	if !ok {
 		return true // Stage 4 or higher, no Elo restriction
 	}
+	return math.Abs(elo1-elo2) <= band
 }
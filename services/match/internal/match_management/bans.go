@@ -0,0 +1,265 @@
+package match_management
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"match/internal/models"
+	"match/internal/utils"
+)
+
+const banKeyPrefix = "matchmaking_ban:"
+
+func banKey(userId string) string {
+	return banKeyPrefix + userId
+}
+
+// --- Ban Records ---
+
+// banUser stores a ban for the user. Cooldowns carry a Redis TTL so they lift
+// on their own; a zero duration bans permanently.
+func (mm *MatchManager) banUser(userId, reason string, duration time.Duration) (*models.MatchmakingBan, error) {
+	now := time.Now().UTC()
+	ban := &models.MatchmakingBan{UserID: userId, Reason: reason, CreatedAt: now}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	banJSON, err := json.Marshal(ban)
+	if err != nil {
+		return nil, err
+	}
+	if err := mm.rdb.Set(mm.ctx, banKey(userId), banJSON, duration).Err(); err != nil {
+		return nil, err
+	}
+	return withRemaining(ban, now), nil
+}
+
+// getBan returns the user's active ban, or nil if the user is not banned
+func (mm *MatchManager) getBan(userId string) (*models.MatchmakingBan, error) {
+	banJSON, err := mm.rdb.Get(mm.ctx, banKey(userId)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ban models.MatchmakingBan
+	if err := json.Unmarshal([]byte(banJSON), &ban); err != nil {
+		return nil, fmt.Errorf("failed to parse ban for %s: %w", userId, err)
+	}
+	now := time.Now()
+	if ban.ExpiresAt != nil && !ban.ExpiresAt.After(now) {
+		return nil, nil // TTL not yet reaped
+	}
+	return withRemaining(&ban, now), nil
+}
+
+// activeBan is getBan for enforcement paths: a lookup failure is logged and
+// treated as no ban so a Redis hiccup does not lock everyone out
+func (mm *MatchManager) activeBan(userId string) *models.MatchmakingBan {
+	ban, err := mm.getBan(userId)
+	if err != nil {
+		log.Printf("[Instance %s] Failed to check ban for user %s: %v", mm.instanceID, userId, err)
+		return nil
+	}
+	return ban
+}
+
+func (mm *MatchManager) liftBan(userId string) (bool, error) {
+	n, err := mm.rdb.Del(mm.ctx, banKey(userId)).Result()
+	return n > 0, err
+}
+
+func (mm *MatchManager) listBans() ([]models.MatchmakingBan, error) {
+	keys, err := mm.rdb.Keys(mm.ctx, banKeyPrefix+"*").Result()
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]models.MatchmakingBan, 0, len(keys))
+	for _, key := range keys {
+		ban, err := mm.getBan(strings.TrimPrefix(key, banKeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			bans = append(bans, *ban)
+		}
+	}
+	return bans, nil
+}
+
+func withRemaining(ban *models.MatchmakingBan, now time.Time) *models.MatchmakingBan {
+	if ban.ExpiresAt != nil {
+		remaining := int64(ban.ExpiresAt.Sub(now).Round(time.Second) / time.Second)
+		ban.RemainingSeconds = &remaining
+	}
+	return ban
+}
+
+// --- Enforcement ---
+
+// evictBanned removes a banned user from the queues and tells them why.
+// It reports whether the user was banned.
+func (mm *MatchManager) evictBanned(userId, category, difficulty string) bool {
+	ban := mm.activeBan(userId)
+	if ban == nil {
+		return false
+	}
+	log.Printf("[Instance %s] Enforcement: evicting banned user %s from queue (reason: %s)", mm.instanceID, userId, ban.Reason)
+	mm.removeUser(userId, category, difficulty)
+	mm.sendToUser(userId, bannedMessage(ban))
+	return true
+}
+
+// voidBannedMatch cancels a pending match in which either user is banned.
+// Banned users are removed from matchmaking and the other user is re-queued.
+// It returns the ban of the requesting user (if any) and whether the match
+// was voided.
+func (mm *MatchManager) voidBannedMatch(pending *models.PendingMatch, requester string) (*models.MatchmakingBan, bool) {
+	ban1 := mm.activeBan(pending.User1)
+	ban2 := mm.activeBan(pending.User2)
+	if ban1 == nil && ban2 == nil {
+		return nil, false
+	}
+
+	matchID := pending.MatchId
+	mm.rdb.Del(mm.ctx, fmt.Sprintf("pending_match:%s", matchID))
+	mm.rdb.Del(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, pending.User1))
+	mm.rdb.Del(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, pending.User2))
+
+	sides := []struct {
+		user, category, difficulty string
+		ban                        *models.MatchmakingBan
+	}{
+		{pending.User1, pending.User1Cat, pending.User1Diff, ban1},
+		{pending.User2, pending.User2Cat, pending.User2Diff, ban2},
+	}
+	var requesterBan *models.MatchmakingBan
+	for _, s := range sides {
+		if s.ban != nil {
+			log.Printf("[Instance %s] Enforcement: voiding match %s, user %s is banned (reason: %s)", mm.instanceID, matchID, s.user, s.ban.Reason)
+			mm.removeUser(s.user, s.category, s.difficulty)
+			mm.sendToUser(s.user, bannedMessage(s.ban))
+			if s.user == requester {
+				requesterBan = s.ban
+			}
+			continue
+		}
+		mm.requeueUser(s.user, s.category, s.difficulty)
+		mm.sendToUser(s.user, map[string]interface{}{
+			"type":    "requeued",
+			"message": "The match was cancelled. You have been re-queued.",
+		})
+	}
+	return requesterBan, true
+}
+
+func bannedMessage(ban *models.MatchmakingBan) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "banned",
+		"message":   "You have been removed from matchmaking",
+		"reason":    ban.Reason,
+		"expiresAt": ban.ExpiresAt,
+	}
+}
+
+func writeBanned(w http.ResponseWriter, ban *models.MatchmakingBan) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.BannedResp{
+		Error:     "matchmaking_banned",
+		ExpiresAt: ban.ExpiresAt,
+		Reason:    ban.Reason,
+	})
+}
+
+// --- Admin: Matchmaking Bans ---
+
+// CreateBanHandler bans a user from matchmaking for durationSec seconds, or
+// permanently. A new ban replaces any existing one.
+func (mm *MatchManager) CreateBanHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	var req models.BanReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "invalid json"})
+		return
+	}
+	req.UserID = strings.TrimSpace(req.UserID)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.UserID == "" || req.Reason == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "userId and reason required"})
+		return
+	}
+	if req.Permanent == (req.DurationSec > 0) || req.DurationSec < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "exactly one of durationSec or permanent required"})
+		return
+	}
+
+	ban, err := mm.banUser(req.UserID, req.Reason, time.Duration(req.DurationSec)*time.Second)
+	if err != nil {
+		log.Printf("[Instance %s] Failed to ban user %s: %v", mm.instanceID, req.UserID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to ban user"})
+		return
+	}
+	if req.Permanent {
+		log.Printf("[Instance %s] Enforcement: banned user %s permanently (reason: %s)", mm.instanceID, req.UserID, req.Reason)
+	} else {
+		log.Printf("[Instance %s] Enforcement: banned user %s for %ds (reason: %s)", mm.instanceID, req.UserID, req.DurationSec, req.Reason)
+	}
+	utils.WriteJSON(w, http.StatusCreated, models.Resp{OK: true, Info: ban})
+}
+
+// DeleteBanHandler lifts the ban of the user given by ?userId=
+func (mm *MatchManager) DeleteBanHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "userId required"})
+		return
+	}
+	lifted, err := mm.liftBan(userId)
+	if err != nil {
+		log.Printf("[Instance %s] Failed to lift ban for user %s: %v", mm.instanceID, userId, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to lift ban"})
+		return
+	}
+	if !lifted {
+		utils.WriteJSON(w, http.StatusNotFound, models.Resp{OK: false, Info: "not banned"})
+		return
+	}
+	log.Printf("[Instance %s] Enforcement: lifted ban for user %s", mm.instanceID, userId)
+	utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: "ban lifted"})
+}
+
+// ListBansHandler returns every active ban with its reason and remaining time
+func (mm *MatchManager) ListBansHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	bans, err := mm.listBans()
+	if err != nil {
+		log.Printf("[Instance %s] Failed to list bans: %v", mm.instanceID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to list bans"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: bans})
+}
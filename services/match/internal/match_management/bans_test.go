@@ -0,0 +1,256 @@
+package match_management
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/models"
+)
+
+// collectUserMessages subscribes to user message channels and returns a
+// function that drains the messages of the given type received so far
+func collectUserMessages(t *testing.T, rdb *redis.Client, msgType string) func() map[string]map[string]interface{} {
+	t.Helper()
+	sub := rdb.PSubscribe(context.Background(), "user:*:message")
+	t.Cleanup(func() { sub.Close() })
+	_, err := sub.Receive(context.Background())
+	require.NoError(t, err)
+	ch := sub.Channel()
+
+	return func() map[string]map[string]interface{} {
+		got := make(map[string]map[string]interface{})
+		for {
+			select {
+			case msg := <-ch:
+				var data map[string]interface{}
+				_ = json.Unmarshal([]byte(msg.Payload), &data)
+				if data["type"] == msgType {
+					got[msg.Channel[5:len(msg.Channel)-8]] = data
+				}
+			case <-time.After(200 * time.Millisecond):
+				return got
+			}
+		}
+	}
+}
+
+func joinAs(mm *MatchManager, userId string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.JoinReq{UserID: userId, Category: "arrays", Difficulty: "easy"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/match/join", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	mm.JoinHandler(w, req)
+	return w
+}
+
+func TestJoinHandler_RejectsBannedUser(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	ban, err := mm.banUser("user1", "repeated reports", time.Hour)
+	require.NoError(t, err)
+
+	w := joinAs(mm, "user1")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var resp models.BannedResp
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "matchmaking_banned", resp.Error)
+	assert.Equal(t, "repeated reports", resp.Reason)
+	require.NotNil(t, resp.ExpiresAt)
+	assert.True(t, resp.ExpiresAt.Equal(*ban.ExpiresAt))
+
+	exists, _ := rdb.Exists(context.Background(), "user:user1").Result()
+	assert.Zero(t, exists, "banned user must not be queued")
+
+	// Permanent bans report no expiry
+	_, err = mm.banUser("user2", "sandbox abuse", 0)
+	require.NoError(t, err)
+	w = joinAs(mm, "user2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"expiresAt":null`)
+}
+
+func TestBanExpiryLiftsBan(t *testing.T) {
+	mr, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	_, err := mm.banUser("user1", "cooldown", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, joinAs(mm, "user1").Code)
+
+	mr.FastForward(time.Minute + time.Second)
+	assert.Equal(t, http.StatusOK, joinAs(mm, "user1").Code)
+}
+
+func TestMatchmakingTick_EvictsBannedUsers(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	ctx := context.Background()
+	now := float64(time.Now().Unix())
+
+	queueUser(t, rdb, "banned", "arrays", "easy", now-10)
+	queueUser(t, rdb, "user2", "arrays", "easy", now-5)
+	_, err := mm.banUser("banned", "sandbox abuse", 0)
+	require.NoError(t, err)
+	drain := collectUserMessages(t, rdb, "banned")
+
+	mm.matchmakingTick()
+
+	notified := drain()
+	require.Contains(t, notified, "banned")
+	assert.Equal(t, "sandbox abuse", notified["banned"]["reason"])
+	assert.NotContains(t, notified, "user2")
+
+	exists, _ := rdb.Exists(ctx, "user:banned").Result()
+	assert.Zero(t, exists)
+	for _, queue := range []string{"queue:arrays:easy", "queue:arrays", "queue:all"} {
+		members, _ := rdb.ZRange(ctx, queue, 0, -1).Result()
+		assert.Equal(t, []string{"user2"}, members, queue)
+	}
+}
+
+func TestTryMatch_SkipsBannedUsers(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	now := float64(time.Now().Unix())
+
+	queueUser(t, rdb, "banned", "arrays", "easy", now-10)
+	queueUser(t, rdb, "user2", "arrays", "easy", now-5)
+	_, err := mm.banUser("banned", "repeated reports", time.Hour)
+	require.NoError(t, err)
+
+	// A fresh join would have been paired with the banned user at the head of the queue
+	assert.Equal(t, http.StatusOK, joinAs(mm, "user3").Code)
+
+	pendingKeys, _ := rdb.Keys(context.Background(), "pending_match:*").Result()
+	require.Len(t, pendingKeys, 1)
+	var pending models.PendingMatch
+	require.NoError(t, json.Unmarshal([]byte(rdb.Get(context.Background(), pendingKeys[0]).Val()), &pending))
+	assert.ElementsMatch(t, []string{"user2", "user3"}, []string{pending.User1, pending.User2})
+}
+
+func TestHandshakeHandler_BanVoidsPendingMatch(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	ctx := context.Background()
+	now := float64(time.Now().Unix())
+
+	queueUser(t, rdb, "user1", "arrays", "easy", now-30)
+	queueUser(t, rdb, "user2", "arrays", "easy", now-20)
+	mm.createPendingMatch("user1", "user2", "arrays", "easy", "arrays", "easy", 1)
+	pendingKeys, _ := rdb.Keys(ctx, "pending_match:*").Result()
+	require.Len(t, pendingKeys, 1)
+	matchID := pendingKeys[0][len("pending_match:"):]
+
+	_, err := mm.banUser("user2", "repeated reports", time.Hour)
+	require.NoError(t, err)
+	requeued := collectUserMessages(t, rdb, "requeued")
+
+	handshake := func(userId string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.HandshakeReq{UserID: userId, MatchId: matchID, Accept: true})
+		w := httptest.NewRecorder()
+		mm.HandshakeHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/match/handshake", bytes.NewBuffer(body)))
+		return w
+	}
+
+	// The innocent partner accepting finds the match voided and is re-queued
+	assert.Equal(t, http.StatusConflict, handshake("user1").Code)
+	assert.Contains(t, requeued(), "user1")
+
+	exists, _ := rdb.Exists(ctx, "pending_match:"+matchID, "handshake:"+matchID+":user1", "handshake:"+matchID+":user2").Result()
+	assert.Zero(t, exists, "voided match must be cleaned up")
+	_, err = rdb.ZScore(ctx, "queue:arrays:easy", "user1").Result()
+	assert.NoError(t, err, "partner should be back in the queue")
+	_, err = rdb.ZScore(ctx, "queue:arrays:easy", "user2").Result()
+	assert.Equal(t, redis.Nil, err, "banned user must not be re-queued")
+	joinedAt, _ := rdb.HGet(ctx, "user:user1", "joined_at").Float64()
+	assert.Equal(t, now-30, joinedAt, "partner keeps their place")
+}
+
+func TestHandshakeHandler_BannedRequester(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	mm.createPendingMatch("user1", "user2", "arrays", "easy", "arrays", "easy", 1)
+	pendingKeys, _ := rdb.Keys(context.Background(), "pending_match:*").Result()
+	require.Len(t, pendingKeys, 1)
+	_, err := mm.banUser("user1", "sandbox abuse", 0)
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(models.HandshakeReq{UserID: "user1", MatchId: pendingKeys[0][len("pending_match:"):], Accept: true})
+	w := httptest.NewRecorder()
+	mm.HandshakeHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/match/handshake", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "matchmaking_banned")
+}
+
+func TestBanAdminHandlers(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	call := func(handler http.HandlerFunc, method, target, body, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	const admin = "Bearer admin-token"
+
+	// Disabled until an admin token is configured
+	assert.Equal(t, http.StatusUnauthorized, call(mm.ListBansHandler, http.MethodGet, "/admin/bans", "", admin).Code)
+	mm.SetAdminToken("admin-token")
+	assert.Equal(t, http.StatusUnauthorized, call(mm.CreateBanHandler, http.MethodPost, "/admin/bans", `{}`, "Bearer wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(mm.DeleteBanHandler, http.MethodDelete, "/admin/bans?userId=user1", "", "").Code)
+
+	for _, body := range []string{
+		`not json`,
+		`{"userId":"user1","durationSec":60}`,
+		`{"reason":"spam","permanent":true}`,
+		`{"userId":"user1","reason":"spam"}`,
+		`{"userId":"user1","reason":"spam","durationSec":60,"permanent":true}`,
+		`{"userId":"user1","reason":"spam","durationSec":-5}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, call(mm.CreateBanHandler, http.MethodPost, "/admin/bans", body, admin).Code, body)
+	}
+
+	w := call(mm.CreateBanHandler, http.MethodPost, "/admin/bans", `{"userId":"user1","reason":"repeated reports","durationSec":600}`, admin)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = call(mm.CreateBanHandler, http.MethodPost, "/admin/bans", `{"userId":"user2","reason":"sandbox abuse","permanent":true}`, admin)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = call(mm.ListBansHandler, http.MethodGet, "/admin/bans", "", admin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		OK   bool                    `json:"ok"`
+		Info []models.MatchmakingBan `json:"info"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Info, 2)
+	bans := map[string]models.MatchmakingBan{}
+	for _, b := range list.Info {
+		bans[b.UserID] = b
+	}
+	assert.Equal(t, "repeated reports", bans["user1"].Reason)
+	require.NotNil(t, bans["user1"].RemainingSeconds)
+	assert.InDelta(t, 600, *bans["user1"].RemainingSeconds, 2)
+	assert.Equal(t, "sandbox abuse", bans["user2"].Reason)
+	assert.Nil(t, bans["user2"].ExpiresAt)
+	assert.Nil(t, bans["user2"].RemainingSeconds)
+
+	assert.Equal(t, http.StatusBadRequest, call(mm.DeleteBanHandler, http.MethodDelete, "/admin/bans", "", admin).Code)
+	assert.Equal(t, http.StatusOK, call(mm.DeleteBanHandler, http.MethodDelete, "/admin/bans?userId=user1", "", admin).Code)
+	assert.Equal(t, http.StatusNotFound, call(mm.DeleteBanHandler, http.MethodDelete, "/admin/bans?userId=user1", "", admin).Code)
+	assert.Equal(t, http.StatusOK, joinAs(mm, "user1").Code)
+}
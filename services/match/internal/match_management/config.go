@@ -0,0 +1,212 @@
+package match_management
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"match/internal/models"
+	"match/internal/utils"
+)
+
+const (
+	// Hash holding the config set through the admin endpoint, with fields
+	// "version" and "config", so every instance applies the same values
+	matchConfigKey = "match_config"
+	// Counter handing out config versions
+	matchConfigVersionKey = "match_config:version"
+)
+
+// LoadMatchConfig reads the MATCH_* environment variables over the defaults.
+// MATCH_REGIONS is a comma separated region list and MATCH_REGION_ADJACENCY a
+// comma separated list of adjacent pairs, e.g. "us-east:eu-west".
+func LoadMatchConfig() (models.MatchConfig, error) {
+	cfg := models.DefaultMatchConfig()
+	ints := []struct {
+		name string
+		dst  *int64
+	}{
+		{"MATCH_STAGE1_TIMEOUT_SEC", &cfg.Stage1TimeoutSec},
+		{"MATCH_STAGE2_TIMEOUT_SEC", &cfg.Stage2TimeoutSec},
+		{"MATCH_STAGE3_TIMEOUT_SEC", &cfg.Stage3TimeoutSec},
+		{"MATCH_HANDSHAKE_TIMEOUT_SEC", &cfg.HandshakeTimeoutSec},
+	}
+	for _, v := range ints {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %q is not a whole number of seconds", v.name, raw)
+		}
+		*v.dst = n
+	}
+	floats := []struct {
+		name string
+		dst  *float64
+	}{
+		{"MATCH_ELO_BAND_STAGE1", &cfg.EloBandStage1},
+		{"MATCH_ELO_BAND_STAGE2", &cfg.EloBandStage2},
+		{"MATCH_ELO_BAND_STAGE3", &cfg.EloBandStage3},
+	}
+	for _, v := range floats {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %q is not a number", v.name, raw)
+		}
+		*v.dst = f
+	}
+	if raw := strings.TrimSpace(os.Getenv("MATCH_REGIONS")); raw != "" {
+		cfg.Regions = nil
+		for _, r := range strings.Split(raw, ",") {
+			if r = normalizeRegion(r); r != "" {
+				cfg.Regions = append(cfg.Regions, r)
+			}
+		}
+	}
+	if raw, ok := os.LookupEnv("MATCH_REGION_ADJACENCY"); ok {
+		adjacency, err := parseRegionAdjacency(raw)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.RegionAdjacency = adjacency
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// parseRegionAdjacency parses "a:b,c:d" into an adjacency map. An empty value
+// means no regions are adjacent.
+func parseRegionAdjacency(raw string) (map[string][]string, error) {
+	adjacency := make(map[string][]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		a, b, ok := strings.Cut(pair, ":")
+		a, b = normalizeRegion(a), normalizeRegion(b)
+		if !ok || a == "" || b == "" {
+			return nil, fmt.Errorf("MATCH_REGION_ADJACENCY: %q is not a region:region pair", strings.TrimSpace(pair))
+		}
+		adjacency[a] = append(adjacency[a], b)
+	}
+	return adjacency, nil
+}
+
+// Config returns the config in effect on this instance
+func (mm *MatchManager) Config() models.MatchConfig {
+	return *mm.config.Load()
+}
+
+// SetConfig replaces this instance's config without sharing it, e.g. with the
+// values loaded from the environment at startup
+func (mm *MatchManager) SetConfig(cfg models.MatchConfig) {
+	mm.config.Store(&cfg)
+}
+
+// UpdateConfig validates cfg, stores it in Redis under a new version and
+// applies it here. Other instances pick it up on their next matchmaking tick.
+func (mm *MatchManager) UpdateConfig(cfg models.MatchConfig) (models.MatchConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	version, err := mm.rdb.Incr(mm.ctx, matchConfigVersionKey).Result()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Version = version
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if err := mm.rdb.HSet(mm.ctx, matchConfigKey, "version", version, "config", cfgJSON).Err(); err != nil {
+		return cfg, err
+	}
+	mm.SetConfig(cfg)
+	log.Printf("[Instance %s] Match config updated to version %d", mm.instanceID, version)
+	return cfg, nil
+}
+
+// refreshConfig applies the config stored in Redis when its version differs
+// from the one in effect. Only the version is read unless it changed.
+func (mm *MatchManager) refreshConfig() {
+	version, err := mm.rdb.HGet(mm.ctx, matchConfigKey, "version").Int64()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("[Instance %s] Failed to read match config version: %v", mm.instanceID, err)
+		}
+		return
+	}
+	if version == mm.Config().Version {
+		return
+	}
+	cfgJSON, err := mm.rdb.HGet(mm.ctx, matchConfigKey, "config").Result()
+	if err != nil {
+		log.Printf("[Instance %s] Failed to read match config: %v", mm.instanceID, err)
+		return
+	}
+	var cfg models.MatchConfig
+	if err := json.Unmarshal([]byte(cfgJSON), &cfg); err != nil {
+		log.Printf("[Instance %s] Failed to parse match config: %v", mm.instanceID, err)
+		return
+	}
+	cfg = cfg.WithRegionDefaults()
+	if err := cfg.Validate(); err != nil {
+		log.Printf("[Instance %s] Ignoring invalid match config version %d: %v", mm.instanceID, cfg.Version, err)
+		return
+	}
+	mm.SetConfig(cfg)
+	log.Printf("[Instance %s] Applied match config version %d", mm.instanceID, cfg.Version)
+}
+
+// --- Admin: Matchmaking Config ---
+
+// GetConfigHandler returns the config in effect on this instance
+func (mm *MatchManager) GetConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: mm.Config()})
+}
+
+// UpdateConfigHandler replaces the config on every instance. Pending matches
+// keep the handshake deadline they were created with. Omitted regions and
+// adjacency fall back to the defaults.
+func (mm *MatchManager) UpdateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	var cfg models.MatchConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "invalid json"})
+		return
+	}
+	cfg = cfg.WithRegionDefaults()
+	if err := cfg.Validate(); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: err.Error()})
+		return
+	}
+	updated, err := mm.UpdateConfig(cfg)
+	if err != nil {
+		log.Printf("[Instance %s] Failed to update match config: %v", mm.instanceID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to update config"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: updated})
+}
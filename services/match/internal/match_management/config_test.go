@@ -0,0 +1,211 @@
+package match_management
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/elo"
+	"match/internal/models"
+)
+
+func TestLoadMatchConfig_Defaults(t *testing.T) {
+	cfg, err := LoadMatchConfig()
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultMatchConfig(), cfg)
+}
+
+func TestLoadMatchConfig_FromEnv(t *testing.T) {
+	t.Setenv("MATCH_STAGE1_TIMEOUT_SEC", "30")
+	t.Setenv("MATCH_STAGE2_TIMEOUT_SEC", "60")
+	t.Setenv("MATCH_STAGE3_TIMEOUT_SEC", "90")
+	t.Setenv("MATCH_HANDSHAKE_TIMEOUT_SEC", "45")
+	t.Setenv("MATCH_ELO_BAND_STAGE1", "50")
+	t.Setenv("MATCH_ELO_BAND_STAGE2", "150.5")
+	t.Setenv("MATCH_ELO_BAND_STAGE3", "400")
+
+	cfg, err := LoadMatchConfig()
+	require.NoError(t, err)
+	assert.Equal(t, models.MatchConfig{
+		Stage1TimeoutSec:    30,
+		Stage2TimeoutSec:    60,
+		Stage3TimeoutSec:    90,
+		HandshakeTimeoutSec: 45,
+		EloBandStage1:       50,
+		EloBandStage2:       150.5,
+		EloBandStage3:       400,
+		Regions:             models.DefaultRegions(),
+		RegionAdjacency:     models.DefaultRegionAdjacency(),
+	}, cfg)
+}
+
+func TestLoadMatchConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"not a number", map[string]string{"MATCH_STAGE1_TIMEOUT_SEC": "soon"}, "MATCH_STAGE1_TIMEOUT_SEC"},
+		{"bad band", map[string]string{"MATCH_ELO_BAND_STAGE2": "wide"}, "MATCH_ELO_BAND_STAGE2"},
+		{"not increasing", map[string]string{"MATCH_STAGE2_TIMEOUT_SEC": "350"}, "must increase"},
+		{"equal stages", map[string]string{"MATCH_STAGE1_TIMEOUT_SEC": "200"}, "must increase"},
+		{"zero timeout", map[string]string{"MATCH_STAGE1_TIMEOUT_SEC": "0"}, "must be positive"},
+		{"zero handshake", map[string]string{"MATCH_HANDSHAKE_TIMEOUT_SEC": "0"}, "handshake timeout"},
+		{"negative band", map[string]string{"MATCH_ELO_BAND_STAGE3": "-1"}, "elo bands"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadMatchConfig()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestCheckEloCompatibility_UsesConfigBands(t *testing.T) {
+	cfg := models.DefaultMatchConfig()
+	assert.True(t, elo.CheckEloCompatibility(1500, 1590, 1, cfg))
+
+	cfg.EloBandStage1 = 50
+	assert.False(t, elo.CheckEloCompatibility(1500, 1590, 1, cfg))
+	assert.True(t, elo.CheckEloCompatibility(1500, 1590, 2, cfg))
+	assert.True(t, elo.CheckEloCompatibility(1500, 3000, 4, cfg))
+}
+
+func TestMatchmakingTick_ShortenedStageTimeouts(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	start := time.Now()
+	mm.now = func() time.Time { return start.Add(15 * time.Second) }
+
+	rdb.HSet(context.Background(), "user:u1", "category", "arrays", "difficulty", "easy", "joined_at", float64(start.Unix()), "stage", 1)
+
+	mm.matchmakingTick()
+	stage, _ := rdb.HGet(context.Background(), "user:u1", "stage").Int()
+	assert.Equal(t, 1, stage, "default stage 1 timeout should not have passed")
+
+	cfg := models.DefaultMatchConfig()
+	cfg.Stage1TimeoutSec, cfg.Stage2TimeoutSec, cfg.Stage3TimeoutSec = 10, 20, 30
+	mm.SetConfig(cfg)
+
+	mm.matchmakingTick()
+	stage, _ = rdb.HGet(context.Background(), "user:u1", "stage").Int()
+	assert.Equal(t, 2, stage)
+
+	mm.now = func() time.Time { return start.Add(25 * time.Second) }
+	mm.matchmakingTick()
+	stage, _ = rdb.HGet(context.Background(), "user:u1", "stage").Int()
+	assert.Equal(t, 3, stage)
+
+	mm.now = func() time.Time { return start.Add(35 * time.Second) }
+	mm.matchmakingTick()
+	exists, _ := rdb.Exists(context.Background(), "user:u1").Result()
+	assert.Zero(t, exists, "user should time out after the shortened stage 3")
+}
+
+func TestUpdateConfig_PropagatesAcrossInstances(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm1 := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	mm2 := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	cfg := models.DefaultMatchConfig()
+	cfg.Stage1TimeoutSec = 50
+	cfg.EloBandStage1 = 75
+	updated, err := mm1.UpdateConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), updated.Version)
+	assert.Equal(t, updated, mm1.Config())
+	assert.Equal(t, models.DefaultMatchConfig(), mm2.Config())
+
+	mm2.matchmakingTick()
+	assert.Equal(t, updated, mm2.Config())
+
+	// a second update gets a new version and replaces the first everywhere
+	cfg.EloBandStage1 = 80
+	updated, err = mm2.UpdateConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+	mm1.matchmakingTick()
+	assert.Equal(t, float64(80), mm1.Config().EloBandStage1)
+}
+
+func TestConfigChange_DoesNotMovePendingMatchExpiry(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	start := time.Now()
+	mm.now = func() time.Time { return start }
+
+	for _, u := range []string{"u1", "u2"} {
+		rdb.HSet(context.Background(), "user:"+u, "category", "arrays", "difficulty", "easy", "joined_at", float64(start.Unix()), "stage", 1)
+	}
+	mm.createPendingMatch("u1", "u2", "arrays", "easy", "arrays", "easy", 1)
+	keys, _ := rdb.Keys(context.Background(), "pending_match:*").Result()
+	require.Len(t, keys, 1)
+
+	cfg := models.DefaultMatchConfig()
+	cfg.HandshakeTimeoutSec = 2
+	_, err := mm.UpdateConfig(cfg)
+	require.NoError(t, err)
+
+	// past the new handshake timeout but within the one the match was created with
+	mm.now = func() time.Time { return start.Add(10 * time.Second) }
+	mm.expirePendingMatches()
+	exists, _ := rdb.Exists(context.Background(), keys[0]).Result()
+	assert.Equal(t, int64(1), exists, "pending match must keep its original deadline")
+
+	mm.now = func() time.Time { return start.Add(21 * time.Second) }
+	mm.expirePendingMatches()
+	exists, _ = rdb.Exists(context.Background(), keys[0]).Result()
+	assert.Zero(t, exists)
+}
+
+func TestConfigHandlers(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	mm.SetAdminToken("admin")
+
+	put := func(token string, body any) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/match/admin/config", bytes.NewBuffer(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mm.UpdateConfigHandler(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, put("wrong", models.DefaultMatchConfig()).Code)
+
+	bad := models.DefaultMatchConfig()
+	bad.Stage3TimeoutSec = 150
+	w := put("admin", bad)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, models.DefaultMatchConfig(), mm.Config())
+
+	good := models.DefaultMatchConfig()
+	good.HandshakeTimeoutSec = 30
+	w = put("admin", good)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/match/admin/config", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	mm.GetConfigHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		OK   bool               `json:"ok"`
+		Info models.MatchConfig `json:"info"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(30), resp.Info.HandshakeTimeoutSec)
+	assert.Equal(t, int64(1), resp.Info.Version)
+}
@@ -0,0 +1,107 @@
+package match_management
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"match/internal/metrics"
+)
+
+const connWriteWait = 10 * time.Second
+
+// userConn is a user's WebSocket on this instance. gorilla/websocket allows a
+// single writer at a time, so every write goes through send.
+type userConn struct {
+	conn *websocket.Conn
+	gen  uint64 // distinguishes successive connections of the same user
+
+	writeMu sync.Mutex
+}
+
+// send writes one JSON message to the connection
+func (c *userConn) send(data interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(connWriteWait))
+	return c.conn.WriteJSON(data)
+}
+
+// supersede tells the client a newer connection took over, then closes it
+func (c *userConn) supersede() {
+	if err := c.send(map[string]interface{}{
+		"type":    "superseded",
+		"message": "Connected from another tab or device",
+	}); err == nil {
+		c.writeMu.Lock()
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "superseded"),
+			time.Now().Add(connWriteWait))
+		c.writeMu.Unlock()
+	}
+	c.conn.Close()
+}
+
+// --- Register WebSocket Connection (local to this instance) ---
+// A user has at most one connection per instance: an existing one is
+// superseded and closed. The returned generation is passed back to
+// UnregisterConnection.
+func (mm *MatchManager) RegisterConnection(userId string, conn *websocket.Conn) uint64 {
+	mm.mu.Lock()
+	mm.connGen++
+	c := &userConn{conn: conn, gen: mm.connGen}
+	old := mm.connections[userId]
+	mm.connections[userId] = c
+	mm.mu.Unlock()
+
+	if old != nil {
+		old.supersede()
+		log.Printf("[Instance %s] Superseded WebSocket connection %d for user %s", mm.instanceID, old.gen, userId)
+	} else {
+		metrics.RegisteredConnections.Inc()
+	}
+	log.Printf("[Instance %s] Registered WebSocket connection %d for user %s", mm.instanceID, c.gen, userId)
+	return c.gen
+}
+
+// --- Unregister WebSocket Connection ---
+// Only removes the user's connection if it is still generation gen, so the
+// read loop of a superseded connection cannot evict its successor.
+func (mm *MatchManager) UnregisterConnection(userId string, gen uint64) {
+	if mm.removeConnection(userId, gen) {
+		log.Printf("[Instance %s] Unregistered WebSocket connection %d for user %s", mm.instanceID, gen, userId)
+	}
+}
+
+func (mm *MatchManager) removeConnection(userId string, gen uint64) bool {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	c, ok := mm.connections[userId]
+	if !ok || c.gen != gen {
+		return false
+	}
+	delete(mm.connections, userId)
+	metrics.RegisteredConnections.Dec()
+	return true
+}
+
+// deliverLocal writes a message to the user's connection on this instance,
+// if there is one. A connection that fails to write is dropped.
+func (mm *MatchManager) deliverLocal(userId string, data interface{}) bool {
+	mm.mu.Lock()
+	c, ok := mm.connections[userId]
+	mm.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if err := c.send(data); err != nil {
+		log.Printf("[Instance %s] Error sending to user %s: %v", mm.instanceID, userId, err)
+		mm.removeConnection(userId, c.gen)
+		c.conn.Close()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package match_management
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/metrics"
+)
+
+func serveWs(t *testing.T, mm *MatchManager) func(userId string) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(mm.WsHandler))
+	t.Cleanup(srv.Close)
+	return func(userId string) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?userId=" + userId
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func connectionGen(mm *MatchManager, userId string) uint64 {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if c, ok := mm.connections[userId]; ok {
+		return c.gen
+	}
+	return 0
+}
+
+func TestSecondConnectionSupersedesFirst(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	dial := serveWs(t, mm)
+	before := testutil.ToFloat64(metrics.RegisteredConnections)
+
+	first := dial("u1")
+	assert.Eventually(t, func() bool { return connectionGen(mm, "u1") != 0 }, time.Second, 5*time.Millisecond)
+	firstGen := connectionGen(mm, "u1")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RegisteredConnections))
+
+	second := dial("u1")
+	assert.Equal(t, "superseded", readMessage(t, first)["type"])
+	// the first socket is closed by the server, so its read loop has exited too
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := first.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error %v", err)
+
+	secondGen := connectionGen(mm, "u1")
+	assert.Greater(t, secondGen, firstGen)
+	mm.UnregisterConnection("u1", firstGen) // a late stale unregister is a no-op
+	assert.Equal(t, secondGen, connectionGen(mm, "u1"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RegisteredConnections))
+
+	assert.True(t, mm.deliverLocal("u1", map[string]interface{}{"type": "match_found"}))
+	assert.Equal(t, "match_found", readMessage(t, second)["type"])
+
+	second.Close()
+	assert.Eventually(t, func() bool { return connectionGen(mm, "u1") == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, before, testutil.ToFloat64(metrics.RegisteredConnections))
+}
+
+func TestConcurrentSendsAreSerialized(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	conn := serveWs(t, mm)("u1")
+	assert.Eventually(t, func() bool { return connectionGen(mm, "u1") != 0 }, time.Second, 5*time.Millisecond)
+
+	const senders, perSender = 8, 25
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				mm.deliverLocal("u1", map[string]interface{}{"type": "queue_update", "n": j})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < senders*perSender; i++ {
+		assert.Equal(t, "queue_update", readMessage(t, conn)["type"])
+	}
+}
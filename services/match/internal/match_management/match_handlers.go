@@ -1,10 +1,12 @@
 package match_management
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -30,13 +32,13 @@ func (mm *MatchManager) WsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Register connection to this instance
-	mm.RegisterConnection(userId, conn)
+	gen := mm.RegisterConnection(userId, conn)
 	log.Printf("[Instance %s] WebSocket connected for user: %s", mm.instanceID, userId)
 
 	// Keep connection alive and handle disconnection
 	for {
 		if _, _, err := conn.NextReader(); err != nil {
-			mm.UnregisterConnection(userId)
+			mm.UnregisterConnection(userId, gen)
 			conn.Close()
 			log.Printf("[Instance %s] User %s disconnected", mm.instanceID, userId)
 			break
@@ -65,6 +67,13 @@ func (mm *MatchManager) JoinHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Banned users are kept out of matchmaking
+	if ban := mm.activeBan(req.UserID); ban != nil {
+		log.Printf("[Instance %s] Enforcement: rejected join from banned user %s (reason: %s)", mm.instanceID, req.UserID, ban.Reason)
+		writeBanned(w, ban)
+		return
+	}
+
 	// Check if user is already in a room (from Redis)
 	roomId, err := mm.GetRoomForUser(req.UserID)
 	if err == nil && roomId != "" {
@@ -72,6 +81,12 @@ func (mm *MatchManager) JoinHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	region, err := joinRegion(req.Region, mm.Config())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: err.Error()})
+		return
+	}
+
 	// Add user to queues
 	now := float64(time.Now().Unix())
 	userKey := fmt.Sprintf("user:%s", req.UserID)
@@ -80,6 +95,7 @@ func (mm *MatchManager) JoinHandler(w http.ResponseWriter, r *http.Request) {
 	if err := mm.rdb.HSet(mm.ctx, userKey, map[string]interface{}{
 		"category":   req.Category,
 		"difficulty": req.Difficulty,
+		"region":     region,
 		"joined_at":  now,
 		"stage":      1,
 	}).Err(); err != nil {
@@ -99,7 +115,7 @@ func (mm *MatchManager) JoinHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[Instance %s] Failed to add to all queue: %v", mm.instanceID, err)
 	}
 
-	log.Printf("[Instance %s] User %s joined queue: category=%s, difficulty=%s", mm.instanceID, req.UserID, req.Category, req.Difficulty)
+	log.Printf("[Instance %s] User %s joined queue: category=%s, difficulty=%s, region=%s", mm.instanceID, req.UserID, req.Category, req.Difficulty, region)
 
 	// Try immediate match
 	mm.tryMatchStage(req.Category, req.Difficulty, 1)
@@ -166,9 +182,14 @@ func (mm *MatchManager) CheckHandler(w http.ResponseWriter, r *http.Request) {
 	// Check Redis for room assignment
 	roomId, err := mm.GetRoomForUser(userId)
 	if err != nil || roomId == "" {
+		// Not in a room yet - report queue feedback if the user is queued
+		queue, err := mm.GetQueueStatus(userId)
+		if err != nil {
+			log.Printf("[Instance %s] Failed to get queue status for %s: %v", mm.instanceID, userId, err)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(models.CheckResp{InRoom: false})
+		json.NewEncoder(w).Encode(models.CheckResp{InRoom: false, Queue: queue})
 		return
 	}
 
@@ -305,6 +326,16 @@ func (mm *MatchManager) HandshakeHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A ban applied while the match was pending voids it
+	if ban, voided := mm.voidBannedMatch(&pending, req.UserID); voided {
+		if ban != nil {
+			writeBanned(w, ban)
+			return
+		}
+		utils.WriteJSON(w, http.StatusConflict, models.Resp{OK: false, Info: "match cancelled"})
+		return
+	}
+
 	if !req.Accept {
 		// User rejected the match
 		log.Printf("[Instance %s] User %s rejected match %s", mm.instanceID, req.UserID, req.MatchId)
@@ -408,3 +439,34 @@ func (mm *MatchManager) SessionFeedbackHandler(w http.ResponseWriter, r *http.Re
 		Info: eloUpdates,
 	})
 }
+
+// --- Admin: Room Token Keys ---
+// TokenKeysHandler reports which key signs new room tokens and which key ids
+// are accepted. Secrets are never included.
+func (mm *MatchManager) TokenKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+	if mm.tokenKeys == nil {
+		utils.WriteJSON(w, http.StatusServiceUnavailable, models.Resp{OK: false, Info: "no room token keys configured"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.Resp{
+		OK: true,
+		Info: models.TokenKeysInfo{
+			SigningKid:     mm.tokenKeys.SigningKid(),
+			VerifierKids:   mm.tokenKeys.KeyIDs(),
+			LegacyFallback: mm.tokenKeys.LegacyFallback(),
+		},
+	})
+}
+
+func (mm *MatchManager) authorizedAdmin(r *http.Request) bool {
+	if mm.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(mm.adminToken)) == 1
+}
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"match/internal/models"
+	"match/internal/utils"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -872,3 +874,65 @@ func TestWsHandler_WithUserId(t *testing.T) {
 	// The actual upgrade failure is expected in test environment
 	// The handler should return before upgrade fails, so status should be BadRequest or similar
 }
+
+func TestTokenKeysHandler(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	keys, err := utils.NewRoomTokenKeys([]utils.RoomTokenKey{
+		{Kid: "2024-06", Secret: "new-secret"},
+		{Kid: "2024-01", Secret: "old-secret"},
+	}, "", []byte("test-secret"))
+	assert.NoError(t, err)
+	mm.SetTokenKeys(keys)
+
+	call := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/match/admin/token-keys", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		mm.TokenKeysHandler(w, req)
+		return w
+	}
+
+	// Disabled until an admin token is configured
+	assert.Equal(t, http.StatusUnauthorized, call("Bearer anything").Code)
+
+	mm.SetAdminToken("admin-token")
+	assert.Equal(t, http.StatusUnauthorized, call("").Code)
+	assert.Equal(t, http.StatusUnauthorized, call("Bearer wrong").Code)
+
+	w := call("Bearer admin-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret")
+
+	var resp struct {
+		OK   bool                 `json:"ok"`
+		Info models.TokenKeysInfo `json:"info"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "2024-06", resp.Info.SigningKid)
+	assert.Equal(t, []string{"2024-06", "2024-01"}, resp.Info.VerifierKids)
+	assert.True(t, resp.Info.LegacyFallback)
+}
+
+func TestCreatePendingMatch_SignsTokensWithActiveKid(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	keys, err := utils.NewRoomTokenKeys([]utils.RoomTokenKey{{Kid: "2024-06", Secret: "new-secret"}}, "", nil)
+	assert.NoError(t, err)
+	mm.SetTokenKeys(keys)
+
+	mm.createPendingMatch("u1", "u2", "arrays", "easy", "arrays", "easy", 1)
+
+	pendingKeys, _ := rdb.Keys(context.Background(), "pending_match:*").Result()
+	assert.Len(t, pendingKeys, 1)
+	var pending models.PendingMatch
+	assert.NoError(t, json.Unmarshal([]byte(rdb.Get(context.Background(), pendingKeys[0]).Val()), &pending))
+
+	for _, token := range []string{pending.Token1, pending.Token2} {
+		parsed, err := jwt.Parse(token, keys.Keyfunc)
+		assert.NoError(t, err)
+		assert.Equal(t, "2024-06", parsed.Header["kid"])
+	}
+}
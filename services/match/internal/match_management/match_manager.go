@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,16 +16,17 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"match/internal/elo"
+	"match/internal/metrics"
 	"match/internal/models"
 	"match/internal/utils"
 )
 
 const (
-	MatchHandshakeTimeout = 20
-	STAGE1_TIMEOUT        = 100
-	STAGE2_TIMEOUT        = 200
-	STAGE3_TIMEOUT        = 300
-	RoomExpiration        = 2 * time.Hour
+	RoomExpiration = 2 * time.Hour
+
+	// Extra lifetime of pending match and handshake keys past the deadline,
+	// so the expiration loop still finds them
+	pendingMatchGrace = 5 * time.Second
 )
 
 type MatchManager struct {
@@ -35,16 +37,26 @@ type MatchManager struct {
 	upgrader  websocket.Upgrader
 
 	// Only store LOCAL WebSocket connections (not shared between instances)
-	connections map[string]*websocket.Conn
+	connections map[string]*userConn
+	connGen     uint64 // last connection generation handed out
 	mu          sync.Mutex
 
 	jwtSecret []byte
+	tokenKeys *utils.RoomTokenKeys
+
+	// Bearer token guarding admin endpoints; empty disables them
+	adminToken string
 
 	// Instance ID for debugging
 	instanceID string
 
 	// Elo rating manager
 	eloManager *elo.EloManager
+
+	// Stage timeouts and Elo bands, swapped atomically on reload (see config.go)
+	config atomic.Pointer[models.MatchConfig]
+
+	now func() time.Time
 }
 
 func NewMatchManager(secret []byte, rdb *redis.Client, pubSubClient *redis.Client) *MatchManager {
@@ -72,6 +84,12 @@ func NewMatchManager(secret []byte, rdb *redis.Client, pubSubClient *redis.Clien
 		log.Printf("subClient connected successfully")
 	}
 
+	// Until SetTokenKeys is called, tokens are signed with the legacy secret
+	tokenKeys, err := utils.NewRoomTokenKeys(nil, "", secret)
+	if err != nil {
+		log.Printf("WARNING: no room token secret configured: %v", err)
+	}
+
 	mm := &MatchManager{
 		ctx:       context.Background(),
 		rdb:       rdb,
@@ -80,11 +98,14 @@ func NewMatchManager(secret []byte, rdb *redis.Client, pubSubClient *redis.Clien
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
-		connections: make(map[string]*websocket.Conn),
+		connections: make(map[string]*userConn),
 		jwtSecret:   secret,
+		tokenKeys:   tokenKeys,
 		instanceID:  uuid.New().String()[:8], // Short ID for logging
 		eloManager:  elo.NewEloManager(rdb),
+		now:         time.Now,
 	}
+	mm.SetConfig(models.DefaultMatchConfig())
 
 	// Start background subscribers
 	go mm.subscribeToUserMessages()
@@ -95,6 +116,16 @@ func NewMatchManager(secret []byte, rdb *redis.Client, pubSubClient *redis.Clien
 	return mm
 }
 
+// SetTokenKeys replaces the key set used to sign room tokens
+func (mm *MatchManager) SetTokenKeys(keys *utils.RoomTokenKeys) {
+	mm.tokenKeys = keys
+}
+
+// SetAdminToken enables the admin endpoints behind the given bearer token
+func (mm *MatchManager) SetAdminToken(token string) {
+	mm.adminToken = token
+}
+
 // --- Redis Pub/Sub for WebSocket Messages ---
 // This allows any instance to send messages to users connected to any other instance
 func (mm *MatchManager) subscribeToUserMessages() {
@@ -112,27 +143,14 @@ func (mm *MatchManager) subscribeToUserMessages() {
 		userId := msg.Channel[5 : len(msg.Channel)-8] // Remove "user:" and ":message"
 		log.Printf("[Instance %s] Received message for user %s", mm.instanceID, userId)
 
-		mm.mu.Lock()
-		conn, ok := mm.connections[userId]
-		mm.mu.Unlock()
-
-		if ok {
-			// This user is connected to THIS instance, forward the message
-			var data interface{}
-			if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
-				log.Printf("[Instance %s] Failed to parse message for user %s: %v", mm.instanceID, userId, err)
-				continue
-			}
-
-			if err := conn.WriteJSON(data); err != nil {
-				log.Printf("[Instance %s] Error sending to user %s: %v", mm.instanceID, userId, err)
-				mm.mu.Lock()
-				delete(mm.connections, userId)
-				mm.mu.Unlock()
-				conn.Close()
-			} else {
-				log.Printf("[Instance %s] Delivered message to user %s", mm.instanceID, userId)
-			}
+		// This user may be connected to THIS instance
+		var data interface{}
+		if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
+			log.Printf("[Instance %s] Failed to parse message for user %s: %v", mm.instanceID, userId, err)
+			continue
+		}
+		if mm.deliverLocal(userId, data) {
+			log.Printf("[Instance %s] Delivered message to user %s", mm.instanceID, userId)
 		}
 		// If user not connected to this instance, ignore (another instance will handle it)
 	}
@@ -159,26 +177,6 @@ func (mm *MatchManager) subscribeToRedis() {
 	}
 }
 
-// Handle session_ended events to clean up match service state
-func (mm *MatchManager) handleSessionEndedEvent(payload string) {
-	var event struct {
-		MatchID string `json:"matchId"`
-		User1   string `json:"user1"`
-		User2   string `json:"user2"`
-	}
-	if err := json.Unmarshal([]byte(payload), &event); err != nil {
-		log.Printf("[Instance %s] Failed to parse session_ended event: %v", mm.instanceID, err)
-		return
-	}
-
-	// Clean up Redis state (shared across all instances)
-	mm.rdb.Del(mm.ctx, fmt.Sprintf("user_room:%s", event.User1))
-	mm.rdb.Del(mm.ctx, fmt.Sprintf("user_room:%s", event.User2))
-	mm.rdb.Del(mm.ctx, fmt.Sprintf("room:%s", event.MatchID))
-
-	log.Printf("[Instance %s] Cleaned up match service state for ended session %s", mm.instanceID, event.MatchID)
-}
-
 // --- Matchmaking Loop ---
 func (mm *MatchManager) StartMatchmakingLoop() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -187,42 +185,56 @@ func (mm *MatchManager) StartMatchmakingLoop() {
 	log.Printf("[Instance %s] Started matchmaking loop", mm.instanceID)
 
 	for range ticker.C {
-		keys, _ := mm.rdb.Keys(mm.ctx, "user:*").Result()
-		for _, key := range keys {
-			user, _ := mm.rdb.HGetAll(mm.ctx, key).Result()
-			if len(user) == 0 {
-				continue
-			}
+		mm.matchmakingTick()
+	}
+}
+
+// matchmakingTick runs one pass of the matchmaking loop over every queued user
+func (mm *MatchManager) matchmakingTick() {
+	mm.refreshConfig()
+	cfg := mm.Config()
 
-			userId := key[5:]
-			category := user["category"]
-			difficulty := user["difficulty"]
-			stage, _ := strconv.Atoi(user["stage"])
-			joinedAt, _ := strconv.ParseFloat(user["joined_at"], 64)
-			elapsed := time.Now().Unix() - int64(joinedAt)
-
-			switch stage {
-			case 1:
-				if elapsed > STAGE1_TIMEOUT {
-					mm.rdb.HSet(mm.ctx, key, "stage", 2)
-					mm.tryMatchStage(category, difficulty, 2)
-				}
-			case 2:
-				if elapsed > STAGE2_TIMEOUT {
-					mm.rdb.HSet(mm.ctx, key, "stage", 3)
-					mm.tryMatchStage(category, difficulty, 3)
-				}
-			case 3:
-				if elapsed > STAGE3_TIMEOUT {
-					mm.removeUser(userId, category, difficulty)
-					mm.sendToUser(userId, map[string]interface{}{
-						"type":    "timeout",
-						"message": "Matchmaking timed out",
-					})
-				}
+	keys, _ := mm.rdb.Keys(mm.ctx, "user:*").Result()
+	for _, key := range keys {
+		user, _ := mm.rdb.HGetAll(mm.ctx, key).Result()
+		if len(user) == 0 {
+			continue
+		}
+
+		userId := key[5:]
+		category := user["category"]
+		difficulty := user["difficulty"]
+		stage, _ := strconv.Atoi(user["stage"])
+		joinedAt, _ := strconv.ParseFloat(user["joined_at"], 64)
+		elapsed := mm.now().Unix() - int64(joinedAt)
+
+		if mm.evictBanned(userId, category, difficulty) {
+			continue
+		}
+
+		switch stage {
+		case 1:
+			if elapsed > cfg.Stage1TimeoutSec {
+				mm.rdb.HSet(mm.ctx, key, "stage", 2)
+				mm.tryMatchStage(category, difficulty, 2)
+			}
+		case 2:
+			if elapsed > cfg.Stage2TimeoutSec {
+				mm.rdb.HSet(mm.ctx, key, "stage", 3)
+				mm.tryMatchStage(category, difficulty, 3)
+			}
+		case 3:
+			if elapsed > cfg.Stage3TimeoutSec {
+				mm.removeUser(userId, category, difficulty)
+				mm.sendToUser(userId, map[string]interface{}{
+					"type":    "timeout",
+					"message": "Matchmaking timed out",
+				})
 			}
 		}
 	}
+
+	mm.pushQueueUpdates()
 }
 
 // --- Pending Match Expiration Loop ---
@@ -234,25 +246,31 @@ func (mm *MatchManager) StartPendingMatchExpirationLoop() {
 	log.Printf("[Instance %s] Started pending match expiration loop", mm.instanceID)
 
 	for range ticker.C {
-		// Find all pending matches in Redis
-		pendingKeys, _ := mm.rdb.Keys(mm.ctx, "pending_match:*").Result()
+		mm.expirePendingMatches()
+	}
+}
 
-		for _, pendingKey := range pendingKeys {
-			pendingJSON, err := mm.rdb.Get(mm.ctx, pendingKey).Result()
-			if err != nil {
-				continue // Already expired or deleted
-			}
+// expirePendingMatches handles every pending match past its own deadline. The
+// deadline is fixed when the match is created, so config changes do not move it.
+func (mm *MatchManager) expirePendingMatches() {
+	// Find all pending matches in Redis
+	pendingKeys, _ := mm.rdb.Keys(mm.ctx, "pending_match:*").Result()
 
-			var pending models.PendingMatch
-			if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
-				log.Printf("[Instance %s] Failed to parse pending match: %v", mm.instanceID, err)
-				continue
-			}
+	for _, pendingKey := range pendingKeys {
+		pendingJSON, err := mm.rdb.Get(mm.ctx, pendingKey).Result()
+		if err != nil {
+			continue // Already expired or deleted
+		}
 
-			// Check if expired
-			if time.Now().After(pending.ExpiresAt) {
-				mm.handleExpiredMatch(&pending)
-			}
+		var pending models.PendingMatch
+		if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
+			log.Printf("[Instance %s] Failed to parse pending match: %v", mm.instanceID, err)
+			continue
+		}
+
+		// Check if expired
+		if mm.now().After(pending.ExpiresAt) {
+			mm.handleExpiredMatch(&pending)
 		}
 	}
 }
@@ -377,22 +395,33 @@ func (mm *MatchManager) tryMatch(users []string, stage int, allowRecentMatch boo
 	type userInfo struct {
 		category   string
 		difficulty string
+		region     string
 		elo        float64
 	}
 
+	cfg := mm.Config()
+
 	// Preload user info once to avoid repeated Redis calls
 	userDataMap := make(map[string]userInfo, len(users))
+	eligible := make([]string, 0, len(users))
 
 	for _, u := range users {
 		data, _ := mm.rdb.HGetAll(mm.ctx, fmt.Sprintf("user:%s", u)).Result()
+		// Banned users still sitting in a queue are evicted, never matched
+		if mm.evictBanned(u, data["category"], data["difficulty"]) {
+			continue
+		}
+		eligible = append(eligible, u)
 		eloData, _ := mm.eloManager.GetUserElo(u)
 
 		userDataMap[u] = userInfo{
 			category:   data["category"],
 			difficulty: data["difficulty"],
+			region:     storedRegion(data["region"]),
 			elo:        eloData.EloRating,
 		}
 	}
+	users = eligible
 
 	// Compare users
 	for i := 0; i < len(users)-1; i++ {
@@ -404,7 +433,12 @@ func (mm *MatchManager) tryMatch(users []string, stage int, allowRecentMatch boo
 			u2Info := userDataMap[u2]
 
 			// Check Elo compatibility
-			if !elo.CheckEloCompatibility(u1Info.elo, u2Info.elo, stage) {
+			if !elo.CheckEloCompatibility(u1Info.elo, u2Info.elo, stage, cfg) {
+				continue
+			}
+
+			// Region preference relaxes with the stage like category and difficulty
+			if !cfg.RegionsCompatible(u1Info.region, u2Info.region, stage) {
 				continue
 			}
 
@@ -454,6 +488,16 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 		finalDiff = utils.GetAverageDifficulty(diff1, diff2)
 	}
 
+	// Regions are read before the users leave the queue
+	region, pairing := pairRegion(mm.userRegion(u1), mm.userRegion(u2), mm.Config())
+	metrics.RegionPairings.WithLabelValues(strconv.Itoa(stage), pairing).Inc()
+
+	// Feed the actual waits into the rolling averages used for queue estimates
+	now := mm.now()
+	mm.recordWaitSample(u1, cat1, diff1, now)
+	mm.recordWaitSample(u2, cat2, diff2, now)
+	mm.forgetQueueUpdate(u1, u2)
+
 	// Remove users from their respective queues
 	mm.rdb.ZRem(mm.ctx, fmt.Sprintf("queue:%s:%s", cat1, diff1), u1)
 	mm.rdb.ZRem(mm.ctx, fmt.Sprintf("queue:%s", cat1), u1)
@@ -464,8 +508,9 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 	mm.rdb.ZRem(mm.ctx, "queue:all", u2)
 
 	matchID := uuid.New().String()
-	token1, _ := utils.GenerateRoomToken(matchID, u1, mm.jwtSecret)
-	token2, _ := utils.GenerateRoomToken(matchID, u2, mm.jwtSecret)
+	handshakeTimeout := time.Duration(mm.Config().HandshakeTimeoutSec) * time.Second
+	token1, _ := utils.GenerateRoomToken(matchID, u1, utils.RoleParticipant, mm.tokenKeys)
+	token2, _ := utils.GenerateRoomToken(matchID, u2, utils.RoleParticipant, mm.tokenKeys)
 
 	pending := &models.PendingMatch{
 		MatchId:    matchID,
@@ -477,20 +522,21 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 		User1Diff:  diff1,
 		User2Cat:   cat2,
 		User2Diff:  diff2,
+		Region:     region,
 		Token1:     token1,
 		Token2:     token2,
 		Handshakes: make(map[string]bool),
-		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(MatchHandshakeTimeout * time.Second),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(handshakeTimeout),
 	}
 
 	// Store in Redis with expiration (shared across all instances)
 	pendingJSON, _ := json.Marshal(pending)
-	mm.rdb.Set(mm.ctx, fmt.Sprintf("pending_match:%s", matchID), pendingJSON, (MatchHandshakeTimeout+5)*time.Second)
+	mm.rdb.Set(mm.ctx, fmt.Sprintf("pending_match:%s", matchID), pendingJSON, handshakeTimeout+pendingMatchGrace)
 
 	// Create handshake tracking keys
-	mm.rdb.Set(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, u1), "pending", (MatchHandshakeTimeout+5)*time.Second)
-	mm.rdb.Set(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, u2), "pending", (MatchHandshakeTimeout+5)*time.Second)
+	mm.rdb.Set(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, u1), "pending", handshakeTimeout+pendingMatchGrace)
+	mm.rdb.Set(mm.ctx, fmt.Sprintf("handshake:%s:%s", matchID, u2), "pending", handshakeTimeout+pendingMatchGrace)
 
 	// Notify both users (via Redis pub/sub, works across instances)
 	mm.sendToUser(u1, map[string]interface{}{
@@ -498,7 +544,7 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 		"matchId":    matchID,
 		"category":   finalCat,
 		"difficulty": finalDiff,
-		"expiresIn":  MatchHandshakeTimeout,
+		"expiresIn":  int64(handshakeTimeout / time.Second),
 	})
 
 	mm.sendToUser(u2, map[string]interface{}{
@@ -506,7 +552,7 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 		"matchId":    matchID,
 		"category":   finalCat,
 		"difficulty": finalDiff,
-		"expiresIn":  MatchHandshakeTimeout,
+		"expiresIn":  int64(handshakeTimeout / time.Second),
 	})
 }
 
@@ -514,23 +560,31 @@ func (mm *MatchManager) createPendingMatch(u1, u2, cat1, diff1, cat2, diff2 stri
 func (mm *MatchManager) HandleMatchAccept(matchID, userId string) error {
 	log.Printf("[Instance %s] User %s accepted match %s", mm.instanceID, userId, matchID)
 
-	// Mark handshake as accepted in Redis
+	// Get pending match from Redis
+	pendingKey := fmt.Sprintf("pending_match:%s", matchID)
+	pendingJSON, pendingErr := mm.rdb.Get(mm.ctx, pendingKey).Result()
+
+	var pending models.PendingMatch
+	var parseErr error
+	if pendingErr == nil {
+		parseErr = json.Unmarshal([]byte(pendingJSON), &pending)
+	}
+
+	// Mark handshake as accepted in Redis, kept as long as the match's own deadline
+	ttl := time.Duration(mm.Config().HandshakeTimeoutSec)*time.Second + pendingMatchGrace
+	if pendingErr == nil && parseErr == nil {
+		ttl = max(pending.ExpiresAt.Sub(mm.now()), 0) + pendingMatchGrace
+	}
 	key := fmt.Sprintf("handshake:%s:%s", matchID, userId)
-	err := mm.rdb.Set(mm.ctx, key, "accepted", (MatchHandshakeTimeout+5)*time.Second).Err()
-	if err != nil {
+	if err := mm.rdb.Set(mm.ctx, key, "accepted", ttl).Err(); err != nil {
 		return fmt.Errorf("failed to mark handshake: %w", err)
 	}
 
-	// Get pending match from Redis
-	pendingKey := fmt.Sprintf("pending_match:%s", matchID)
-	pendingJSON, err := mm.rdb.Get(mm.ctx, pendingKey).Result()
-	if err != nil {
-		return fmt.Errorf("pending match not found: %w", err)
+	if pendingErr != nil {
+		return fmt.Errorf("pending match not found: %w", pendingErr)
 	}
-
-	var pending models.PendingMatch
-	if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
-		return fmt.Errorf("failed to parse pending match: %w", err)
+	if parseErr != nil {
+		return fmt.Errorf("failed to parse pending match: %w", parseErr)
 	}
 
 	// Check if both users accepted
@@ -567,6 +621,7 @@ func (mm *MatchManager) finalizeMatch(pending *models.PendingMatch) {
 		Token1:     pending.Token1,
 		Token2:     pending.Token2,
 		CreatedAt:  time.Now().Format(time.RFC3339),
+		Region:     pending.Region,
 	}
 
 	roomJSON, _ := json.Marshal(roomInfo)
@@ -597,6 +652,7 @@ func (mm *MatchManager) finalizeMatch(pending *models.PendingMatch) {
 		"user2":      pending.User2,
 		"category":   pending.Category,
 		"difficulty": pending.Difficulty,
+		"region":     pending.Region,
 	}
 	eventJSON, _ := json.Marshal(matchEvent)
 	mm.pubClient.Publish(mm.ctx, "matches", eventJSON)
@@ -607,6 +663,12 @@ func (mm *MatchManager) finalizeMatch(pending *models.PendingMatch) {
 	mm.recordMatch(pending.User1, pending.User2)
 }
 
+// userRegion returns the region a queued user joined with
+func (mm *MatchManager) userRegion(userId string) string {
+	region, _ := mm.rdb.HGet(mm.ctx, fmt.Sprintf("user:%s", userId), "region").Result()
+	return storedRegion(region)
+}
+
 // --- Match History Management ---
 
 // hasRecentMatch checks if two users have matched within the last 24 hours
@@ -652,6 +714,7 @@ func (mm *MatchManager) removeUser(userId, category, difficulty string) {
 	mm.rdb.ZRem(mm.ctx, fmt.Sprintf("queue:%s:%s", category, difficulty), userId)
 	mm.rdb.ZRem(mm.ctx, fmt.Sprintf("queue:%s", category), userId)
 	mm.rdb.ZRem(mm.ctx, "queue:all", userId)
+	mm.forgetQueueUpdate(userId)
 }
 
 // --- Send To User (via Redis Pub/Sub) ---
@@ -674,22 +737,6 @@ func (mm *MatchManager) sendToUser(userId string, data interface{}) {
 	}
 }
 
-// --- Register WebSocket Connection (local to this instance) ---
-func (mm *MatchManager) RegisterConnection(userId string, conn *websocket.Conn) {
-	mm.mu.Lock()
-	mm.connections[userId] = conn
-	mm.mu.Unlock()
-	log.Printf("[Instance %s] Registered WebSocket connection for user %s", mm.instanceID, userId)
-}
-
-// --- Unregister WebSocket Connection ---
-func (mm *MatchManager) UnregisterConnection(userId string) {
-	mm.mu.Lock()
-	delete(mm.connections, userId)
-	mm.mu.Unlock()
-	log.Printf("[Instance %s] Unregistered WebSocket connection for user %s", mm.instanceID, userId)
-}
-
 // --- Get Room for User (from Redis) ---
 func (mm *MatchManager) GetRoomForUser(userId string) (string, error) {
 	return mm.rdb.Get(mm.ctx, fmt.Sprintf("user_room:%s", userId)).Result()
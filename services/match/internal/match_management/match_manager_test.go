@@ -698,6 +698,6 @@ func TestUnregisterConnection(t *testing.T) {
 	// but we can verify the function exists and doesn't panic
 
 	// Unregister non-existent connection should not panic
-	mm.UnregisterConnection(userId)
+	mm.UnregisterConnection(userId, 1)
 	assert.True(t, true)
 }
@@ -0,0 +1,194 @@
+package match_management
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"match/internal/models"
+)
+
+const (
+	// Number of recent match formation waits kept per (category, difficulty)
+	WaitSampleWindow = 20
+
+	// Hash of the last queue_update payload pushed to each user (shared across instances)
+	queueUpdateLastKey = "queue_update:last"
+)
+
+func waitSamplesKey(category, difficulty string) string {
+	return fmt.Sprintf("wait_samples:%s:%s", category, difficulty)
+}
+
+// --- Wait Samples ---
+
+// recordWaitSample stores how long a user waited before being matched in the
+// rolling window for the user's own (category, difficulty) queue
+func (mm *MatchManager) recordWaitSample(userId, category, difficulty string, now time.Time) {
+	joinedAt, err := mm.rdb.HGet(mm.ctx, fmt.Sprintf("user:%s", userId), "joined_at").Float64()
+	if err != nil {
+		return
+	}
+	wait := now.Unix() - int64(joinedAt)
+	if wait < 0 {
+		wait = 0
+	}
+
+	key := waitSamplesKey(category, difficulty)
+	pipe := mm.rdb.TxPipeline()
+	pipe.LPush(mm.ctx, key, wait)
+	pipe.LTrim(mm.ctx, key, 0, WaitSampleWindow-1)
+	if _, err := pipe.Exec(mm.ctx); err != nil {
+		log.Printf("[Instance %s] Failed to record wait sample for %s: %v", mm.instanceID, userId, err)
+	}
+}
+
+// averageWait returns the mean of the recorded samples rounded to seconds,
+// or nil when the queue has no history yet
+func averageWait(samples []string) *int {
+	total, count := 0.0, 0
+	for _, s := range samples {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		total += v
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	avg := int(math.Round(total / float64(count)))
+	return &avg
+}
+
+// --- Queue Status ---
+
+// computeQueueStatuses returns the queue position, estimated wait, stage and
+// region scope of every queued user. Redis round trips are pipelined so the cost is linear in
+// the size of the queue.
+func (mm *MatchManager) computeQueueStatuses() (map[string]models.QueueStatus, error) {
+	users, err := mm.rdb.ZRange(mm.ctx, "queue:all", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]models.QueueStatus, len(users))
+	if len(users) == 0 {
+		return statuses, nil
+	}
+
+	pipe := mm.rdb.Pipeline()
+	userCmds := make([]*redis.MapStringStringCmd, len(users))
+	for i, u := range users {
+		userCmds[i] = pipe.HGetAll(mm.ctx, fmt.Sprintf("user:%s", u))
+	}
+	if _, err := pipe.Exec(mm.ctx); err != nil {
+		return nil, err
+	}
+
+	pipe = mm.rdb.Pipeline()
+	rankCmds := make([]*redis.IntCmd, len(users))
+	sampleCmds := make(map[string]*redis.StringSliceCmd)
+	for i, u := range users {
+		data := userCmds[i].Val()
+		if len(data) == 0 {
+			continue
+		}
+		key := waitSamplesKey(data["category"], data["difficulty"])
+		rankCmds[i] = pipe.ZRank(mm.ctx, fmt.Sprintf("queue:%s:%s", data["category"], data["difficulty"]), u)
+		if _, ok := sampleCmds[key]; !ok {
+			sampleCmds[key] = pipe.LRange(mm.ctx, key, 0, -1)
+		}
+	}
+	// redis.Nil is expected for users missing from their primary queue
+	if _, err := pipe.Exec(mm.ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, u := range users {
+		if rankCmds[i] == nil {
+			continue
+		}
+		rank, err := rankCmds[i].Result()
+		if err != nil {
+			continue
+		}
+		data := userCmds[i].Val()
+		stage, _ := strconv.Atoi(data["stage"])
+		region := storedRegion(data["region"])
+		statuses[u] = models.QueueStatus{
+			Position:             int(rank) + 1,
+			EstimatedWaitSeconds: averageWait(sampleCmds[waitSamplesKey(data["category"], data["difficulty"])].Val()),
+			Stage:                stage,
+			Region:               region,
+			RegionScope:          models.RegionScope(region, stage),
+		}
+	}
+	return statuses, nil
+}
+
+// GetQueueStatus returns the queue feedback for a single user, or nil if the
+// user is not queued
+func (mm *MatchManager) GetQueueStatus(userId string) (*models.QueueStatus, error) {
+	data, err := mm.rdb.HGetAll(mm.ctx, fmt.Sprintf("user:%s", userId)).Result()
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	rank, err := mm.rdb.ZRank(mm.ctx, fmt.Sprintf("queue:%s:%s", data["category"], data["difficulty"]), userId).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	samples, _ := mm.rdb.LRange(mm.ctx, waitSamplesKey(data["category"], data["difficulty"]), 0, -1).Result()
+	stage, _ := strconv.Atoi(data["stage"])
+	region := storedRegion(data["region"])
+	return &models.QueueStatus{
+		Position:             int(rank) + 1,
+		EstimatedWaitSeconds: averageWait(samples),
+		Stage:                stage,
+		Region:               region,
+		RegionScope:          models.RegionScope(region, stage),
+	}, nil
+}
+
+// pushQueueUpdates sends a queue_update to every queued user whose feedback
+// changed since the last push
+func (mm *MatchManager) pushQueueUpdates() {
+	statuses, err := mm.computeQueueStatuses()
+	if err != nil {
+		log.Printf("[Instance %s] Failed to compute queue statuses: %v", mm.instanceID, err)
+		return
+	}
+	if len(statuses) == 0 {
+		return
+	}
+
+	lastSent, _ := mm.rdb.HGetAll(mm.ctx, queueUpdateLastKey).Result()
+	for userId, status := range statuses {
+		payload, _ := json.Marshal(status)
+		if lastSent[userId] == string(payload) {
+			continue
+		}
+		mm.rdb.HSet(mm.ctx, queueUpdateLastKey, userId, string(payload))
+		mm.sendToUser(userId, map[string]interface{}{
+			"type":                 "queue_update",
+			"position":             status.Position,
+			"estimatedWaitSeconds": status.EstimatedWaitSeconds,
+			"stage":                status.Stage,
+			"region":               status.Region,
+			"regionScope":          status.RegionScope,
+		})
+	}
+}
+
+// forgetQueueUpdate clears the last pushed feedback so a re-queued user gets a fresh update
+func (mm *MatchManager) forgetQueueUpdate(userIds ...string) {
+	mm.rdb.HDel(mm.ctx, queueUpdateLastKey, userIds...)
+}
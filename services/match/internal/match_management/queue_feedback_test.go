@@ -0,0 +1,142 @@
+package match_management
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/models"
+)
+
+// queueUser seeds a queued user the same way JoinHandler does
+func queueUser(t *testing.T, rdb *redis.Client, userId, category, difficulty string, joinedAt float64) {
+	t.Helper()
+	ctx := context.Background()
+	rdb.HSet(ctx, fmt.Sprintf("user:%s", userId), map[string]interface{}{
+		"category":   category,
+		"difficulty": difficulty,
+		"joined_at":  joinedAt,
+		"stage":      1,
+	})
+	rdb.ZAdd(ctx, fmt.Sprintf("queue:%s:%s", category, difficulty), redis.Z{Score: joinedAt, Member: userId})
+	rdb.ZAdd(ctx, fmt.Sprintf("queue:%s", category), redis.Z{Score: joinedAt, Member: userId})
+	rdb.ZAdd(ctx, "queue:all", redis.Z{Score: joinedAt, Member: userId})
+}
+
+// collectQueueUpdates subscribes to user message channels and returns a
+// function that drains queue_update messages received so far
+func collectQueueUpdates(t *testing.T, rdb *redis.Client) func() map[string]map[string]interface{} {
+	t.Helper()
+	sub := rdb.PSubscribe(context.Background(), "user:*:message")
+	t.Cleanup(func() { sub.Close() })
+	_, err := sub.Receive(context.Background())
+	require.NoError(t, err)
+	ch := sub.Channel()
+
+	return func() map[string]map[string]interface{} {
+		got := make(map[string]map[string]interface{})
+		for {
+			select {
+			case msg := <-ch:
+				var data map[string]interface{}
+				_ = json.Unmarshal([]byte(msg.Payload), &data)
+				if data["type"] == "queue_update" {
+					got[msg.Channel[5:len(msg.Channel)-8]] = data
+				}
+			case <-time.After(200 * time.Millisecond):
+				return got
+			}
+		}
+	}
+}
+
+func TestAverageWait(t *testing.T) {
+	assert.Nil(t, averageWait(nil))
+	assert.Nil(t, averageWait([]string{"not-a-number"}))
+
+	avg := averageWait([]string{"10", "20", "31"})
+	require.NotNil(t, avg)
+	assert.Equal(t, 20, *avg) // 61/3 = 20.33 rounds to 20
+}
+
+func TestRecordWaitSample_KeepsRollingWindow(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	ctx := context.Background()
+
+	now := time.Now()
+	for i := 0; i < WaitSampleWindow+5; i++ {
+		rdb.HSet(ctx, "user:u1", "joined_at", float64(now.Unix()-int64(i)))
+		mm.recordWaitSample("u1", "arrays", "easy", now)
+	}
+
+	samples := rdb.LRange(ctx, waitSamplesKey("arrays", "easy"), 0, -1).Val()
+	assert.Len(t, samples, WaitSampleWindow)
+	assert.Equal(t, fmt.Sprint(WaitSampleWindow+4), samples[0], "newest sample should be first")
+}
+
+func TestPushQueueUpdates(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	ctx := context.Background()
+	drain := collectQueueUpdates(t, rdb)
+
+	// Historical waits for arrays/easy average to 40s; graphs/hard has no history.
+	rdb.RPush(ctx, waitSamplesKey("arrays", "easy"), 30, 50)
+
+	now := float64(time.Now().Unix())
+	queueUser(t, rdb, "alice", "arrays", "easy", now-30)
+	queueUser(t, rdb, "bob", "arrays", "easy", now-20)
+	queueUser(t, rdb, "carol", "graphs", "hard", now-10)
+
+	mm.pushQueueUpdates()
+	updates := drain()
+
+	require.Len(t, updates, 3)
+	assert.Equal(t, float64(1), updates["alice"]["position"])
+	assert.Equal(t, float64(2), updates["bob"]["position"])
+	assert.Equal(t, float64(40), updates["bob"]["estimatedWaitSeconds"])
+	assert.Equal(t, float64(1), updates["bob"]["stage"])
+	assert.Equal(t, float64(1), updates["carol"]["position"])
+	assert.Contains(t, updates["carol"], "estimatedWaitSeconds")
+	assert.Nil(t, updates["carol"]["estimatedWaitSeconds"], "cold-start queue should report null")
+
+	// Nothing changed, so the next tick must not push anything.
+	mm.pushQueueUpdates()
+	assert.Empty(t, drain())
+
+	// Alice leaves; only bob's position changes.
+	mm.removeUser("alice", "arrays", "easy")
+	mm.pushQueueUpdates()
+	updates = drain()
+	require.Len(t, updates, 1)
+	assert.Equal(t, float64(1), updates["bob"]["position"])
+}
+
+func TestCheckHandler_ReportsQueueStatus(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	now := float64(time.Now().Unix())
+	queueUser(t, rdb, "alice", "arrays", "easy", now-30)
+	queueUser(t, rdb, "bob", "arrays", "easy", now-20)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/match/check?userId=bob", nil)
+	w := httptest.NewRecorder()
+	mm.CheckHandler(w, req)
+
+	var resp models.CheckResp
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.InRoom)
+	require.NotNil(t, resp.Queue)
+	assert.Equal(t, 2, resp.Queue.Position)
+	assert.Nil(t, resp.Queue.EstimatedWaitSeconds)
+}
@@ -0,0 +1,55 @@
+package match_management
+
+import (
+	"fmt"
+	"strings"
+
+	"match/internal/metrics"
+	"match/internal/models"
+)
+
+// normalizeRegion trims and lowercases a region
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.TrimSpace(region))
+}
+
+// joinRegion resolves the region of a join request, or returns an error for a
+// region that is not configured
+func joinRegion(region string, cfg models.MatchConfig) (string, error) {
+	region = normalizeRegion(region)
+	if region == "" {
+		return models.RegionAny, nil
+	}
+	if !cfg.KnownRegion(region) {
+		return "", fmt.Errorf("unknown region %q, expected one of %s or %s",
+			region, strings.Join(cfg.Regions, ", "), models.RegionAny)
+	}
+	return region, nil
+}
+
+// storedRegion reads a region from a user hash; users queued before regions
+// existed have none and match anywhere
+func storedRegion(region string) string {
+	if region == "" {
+		return models.RegionAny
+	}
+	return region
+}
+
+// pairRegion picks the region recorded for a match and how the pair's regions
+// relate. The region of the longer waiting user (r1) wins when they differ.
+func pairRegion(r1, r2 string, cfg models.MatchConfig) (region, pairing string) {
+	switch {
+	case r1 == models.RegionAny && r2 == models.RegionAny:
+		return models.RegionAny, metrics.RegionPairingAny
+	case r1 == models.RegionAny:
+		return r2, metrics.RegionPairingAny
+	case r2 == models.RegionAny:
+		return r1, metrics.RegionPairingAny
+	case r1 == r2:
+		return r1, metrics.RegionPairingSame
+	case cfg.RegionsAdjacent(r1, r2):
+		return r1, metrics.RegionPairingAdjacent
+	}
+	return r1, metrics.RegionPairingCross
+}
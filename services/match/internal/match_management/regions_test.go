@@ -0,0 +1,207 @@
+package match_management
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/models"
+)
+
+// queueRegionUser queues a user who joined with a region preference
+func queueRegionUser(t *testing.T, rdb *redis.Client, userId, region string, joinedAt float64) {
+	t.Helper()
+	queueUser(t, rdb, userId, "arrays", "easy", joinedAt)
+	rdb.HSet(context.Background(), "user:"+userId, "region", region)
+}
+
+func pendingMatches(t *testing.T, rdb *redis.Client) []models.PendingMatch {
+	t.Helper()
+	keys, _ := rdb.Keys(context.Background(), "pending_match:*").Result()
+	var matches []models.PendingMatch
+	for _, key := range keys {
+		raw, err := rdb.Get(context.Background(), key).Result()
+		require.NoError(t, err)
+		var pending models.PendingMatch
+		require.NoError(t, json.Unmarshal([]byte(raw), &pending))
+		matches = append(matches, pending)
+	}
+	return matches
+}
+
+func TestTryMatchStage_RegionRelaxesWithStage(t *testing.T) {
+	tests := []struct {
+		name       string
+		r1, r2     string
+		stage      int
+		wantRegion string // "" when no match is expected
+	}{
+		{"same region at stage 1", "us-east", "us-east", 1, "us-east"},
+		{"different regions at stage 1", "us-east", "eu-west", 1, ""},
+		{"adjacent regions at stage 2", "eu-west", "us-east", 2, "eu-west"},
+		{"distant regions at stage 2", "ap-southeast", "eu-west", 2, ""},
+		{"distant regions at stage 3", "ap-southeast", "eu-west", 3, "ap-southeast"},
+		{"no preference at stage 1", models.RegionAny, "eu-west", 1, "eu-west"},
+		{"neither has a preference", models.RegionAny, models.RegionAny, 1, models.RegionAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb, pubSubClient := setupTestRedis(t)
+			mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+			now := float64(time.Now().Unix())
+			queueRegionUser(t, rdb, "user1", tt.r1, now-30)
+			queueRegionUser(t, rdb, "user2", tt.r2, now-20)
+
+			mm.tryMatchStage("arrays", "easy", tt.stage)
+
+			matches := pendingMatches(t, rdb)
+			if tt.wantRegion == "" {
+				assert.Empty(t, matches)
+				return
+			}
+			require.Len(t, matches, 1)
+			assert.Equal(t, tt.wantRegion, matches[0].Region)
+		})
+	}
+}
+
+func TestTryMatchStage_CustomAdjacency(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	cfg := models.DefaultMatchConfig()
+	cfg.RegionAdjacency = map[string][]string{"ap-southeast": {"eu-west"}}
+	mm.SetConfig(cfg)
+
+	now := float64(time.Now().Unix())
+	queueRegionUser(t, rdb, "user1", "us-east", now-30)
+	queueRegionUser(t, rdb, "user2", "eu-west", now-20)
+	mm.tryMatchStage("arrays", "easy", 2)
+	assert.Empty(t, pendingMatches(t, rdb), "us-east and eu-west are no longer adjacent")
+
+	queueRegionUser(t, rdb, "user3", "ap-southeast", now-10)
+	mm.tryMatchStage("arrays", "easy", 2)
+	matches := pendingMatches(t, rdb)
+	require.Len(t, matches, 1)
+	assert.ElementsMatch(t, []string{"user2", "user3"}, []string{matches[0].User1, matches[0].User2})
+}
+
+func TestFinalizeMatch_RecordsRegion(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	now := float64(time.Now().Unix())
+	queueRegionUser(t, rdb, "user1", "eu-west", now-30)
+	queueRegionUser(t, rdb, "user2", "eu-west", now-20)
+
+	mm.tryMatchStage("arrays", "easy", 1)
+	matches := pendingMatches(t, rdb)
+	require.Len(t, matches, 1)
+	require.NoError(t, mm.HandleMatchAccept(matches[0].MatchId, "user1"))
+	require.NoError(t, mm.HandleMatchAccept(matches[0].MatchId, "user2"))
+
+	room, err := mm.GetRoomInfo(matches[0].MatchId)
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west", room.Region)
+}
+
+func TestJoinHandler_Region(t *testing.T) {
+	tests := []struct {
+		name       string
+		region     string
+		wantStatus int
+		wantStored string
+	}{
+		{"configured region", " EU-West ", http.StatusOK, "eu-west"},
+		{"no preference", "", http.StatusOK, models.RegionAny},
+		{"explicit any", "any", http.StatusOK, models.RegionAny},
+		{"unknown region", "mars-north", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb, pubSubClient := setupTestRedis(t)
+			mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+			body, _ := json.Marshal(models.JoinReq{UserID: "user123", Category: "arrays", Difficulty: "easy", Region: tt.region})
+			w := httptest.NewRecorder()
+			mm.JoinHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/match/join", bytes.NewBuffer(body)))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			stored, _ := rdb.HGet(context.Background(), "user:user123", "region").Result()
+			assert.Equal(t, tt.wantStored, stored)
+			if tt.wantStatus != http.StatusOK {
+				var resp models.Resp
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Contains(t, resp.Info, "unknown region")
+			}
+		})
+	}
+}
+
+func TestLoadMatchConfig_Regions(t *testing.T) {
+	t.Setenv("MATCH_REGIONS", "ap-southeast, ap-northeast,us-west")
+	t.Setenv("MATCH_REGION_ADJACENCY", "ap-southeast:ap-northeast, ap-northeast:us-west")
+
+	cfg, err := LoadMatchConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ap-southeast", "ap-northeast", "us-west"}, cfg.Regions)
+	assert.True(t, cfg.RegionsAdjacent("us-west", "ap-northeast"))
+	assert.False(t, cfg.RegionsAdjacent("us-west", "ap-southeast"))
+	assert.True(t, cfg.RegionsCompatible("ap-southeast", "ap-northeast", 2))
+	assert.False(t, cfg.RegionsCompatible("ap-southeast", "ap-northeast", 1))
+	assert.True(t, cfg.RegionsCompatible("ap-southeast", "us-west", 3))
+}
+
+func TestLoadMatchConfig_InvalidRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"reserved name", map[string]string{"MATCH_REGIONS": "us-east,any"}, "region"},
+		{"duplicate", map[string]string{"MATCH_REGIONS": "us-east,us-east"}, "region"},
+		{"malformed pair", map[string]string{"MATCH_REGION_ADJACENCY": "us-east"}, "MATCH_REGION_ADJACENCY"},
+		{"unknown adjacent region", map[string]string{"MATCH_REGION_ADJACENCY": "us-east:mars-north"}, "mars-north"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadMatchConfig()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestQueueStatus_RegionScopeWidensWithStage(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	now := float64(time.Now().Unix())
+	queueRegionUser(t, rdb, "user1", "us-east", now-30)
+	queueUser(t, rdb, "legacy", "arrays", "easy", now-20)
+
+	status, err := mm.GetQueueStatus("user1")
+	require.NoError(t, err)
+	assert.Equal(t, "us-east", status.Region)
+	assert.Equal(t, models.RegionScopeSame, status.RegionScope)
+
+	rdb.HSet(context.Background(), "user:user1", "stage", 2)
+	status, err = mm.GetQueueStatus("user1")
+	require.NoError(t, err)
+	assert.Equal(t, models.RegionScopeAdjacent, status.RegionScope)
+
+	// Users queued before regions existed match anywhere
+	statuses, err := mm.computeQueueStatuses()
+	require.NoError(t, err)
+	assert.Equal(t, models.RegionAny, statuses["legacy"].Region)
+	assert.Equal(t, models.RegionScopeAny, statuses["legacy"].RegionScope)
+	assert.Equal(t, models.RegionScopeAdjacent, statuses["user1"].RegionScope)
+}
@@ -0,0 +1,228 @@
+package match_management
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"match/internal/metrics"
+	"match/internal/models"
+	"match/internal/utils"
+)
+
+const (
+	sessionEndedDLQKey       = "session_ended_dlq"
+	sessionEndedDLQMax       = 500
+	sessionEndedProcessedTTL = 24 * time.Hour
+)
+
+// Results of handling a session_ended event, used as the metric label
+const (
+	sessionEndedProcessed = "processed"
+	sessionEndedDuplicate = "duplicate"
+	sessionEndedInvalid   = "invalid"
+)
+
+// delIfEquals deletes KEYS[1] only while it still holds ARGV[1], so a stale
+// event cannot evict a user from the room they joined since
+var delIfEquals = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func sessionEndedProcessedKey(matchID string) string {
+	return fmt.Sprintf("session_ended_processed:%s", matchID)
+}
+
+// Handle session_ended events to clean up match service state. Invalid
+// payloads are dead-lettered for inspection and replay.
+func (mm *MatchManager) handleSessionEndedEvent(payload string) {
+	result, err := mm.processSessionEnded(payload)
+	if err != nil {
+		log.Printf("[Instance %s] Invalid session_ended event: %v", mm.instanceID, err)
+		mm.deadLetterSessionEnded(payload, err)
+	}
+	// Counted once handled, dead letter included, so waiting on the metric
+	// sees the event's effects
+	metrics.SessionEndedEvents.WithLabelValues(result).Inc()
+}
+
+// processSessionEnded validates and applies one session_ended payload. Events
+// are processed at most once per match; repeats are acknowledged as duplicates.
+func (mm *MatchManager) processSessionEnded(payload string) (string, error) {
+	event, err := parseSessionEnded(payload)
+	if err != nil {
+		return sessionEndedInvalid, err
+	}
+
+	first, err := mm.rdb.SetNX(mm.ctx, sessionEndedProcessedKey(event.MatchID), time.Now().Unix(), sessionEndedProcessedTTL).Result()
+	if err != nil {
+		// Cleanup below is guarded, so processing twice is safe if Redis hiccups
+		log.Printf("[Instance %s] Failed to mark session %s as processed: %v", mm.instanceID, event.MatchID, err)
+	} else if !first {
+		log.Printf("[Instance %s] Ignoring duplicate session_ended event for %s", mm.instanceID, event.MatchID)
+		return sessionEndedDuplicate, nil
+	}
+
+	// Clean up Redis state (shared across all instances)
+	for _, user := range []string{event.User1, event.User2} {
+		key := fmt.Sprintf("user_room:%s", user)
+		deleted, err := delIfEquals.Run(mm.ctx, mm.rdb, []string{key}, event.MatchID).Int()
+		if err != nil {
+			log.Printf("[Instance %s] Failed to clear room of user %s: %v", mm.instanceID, user, err)
+		} else if deleted == 0 {
+			log.Printf("[Instance %s] Kept room of user %s: no longer in session %s", mm.instanceID, user, event.MatchID)
+		}
+	}
+	mm.rdb.Del(mm.ctx, fmt.Sprintf("room:%s", event.MatchID))
+
+	log.Printf("[Instance %s] Cleaned up match service state for ended session %s", mm.instanceID, event.MatchID)
+	return sessionEndedProcessed, nil
+}
+
+func parseSessionEnded(payload string) (*models.SessionEndedEvent, error) {
+	var event models.SessionEndedEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return nil, fmt.Errorf("failed to parse session_ended event: %w", err)
+	}
+	var missing []string
+	if event.MatchID == "" {
+		missing = append(missing, "matchId")
+	}
+	if event.User1 == "" {
+		missing = append(missing, "user1")
+	}
+	if event.User2 == "" {
+		missing = append(missing, "user2")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("session_ended event missing %s", strings.Join(missing, ", "))
+	}
+	return &event, nil
+}
+
+// --- Dead Letters ---
+
+// deadLetterSessionEnded keeps the newest sessionEndedDLQMax invalid payloads
+func (mm *MatchManager) deadLetterSessionEnded(payload string, cause error) {
+	entry, err := json.Marshal(models.DeadLetter{
+		ID:         uuid.New().String(),
+		Payload:    payload,
+		Error:      cause.Error(),
+		ReceivedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("[Instance %s] Failed to encode dead letter: %v", mm.instanceID, err)
+		return
+	}
+	pipe := mm.rdb.TxPipeline()
+	pipe.LPush(mm.ctx, sessionEndedDLQKey, entry)
+	pipe.LTrim(mm.ctx, sessionEndedDLQKey, 0, sessionEndedDLQMax-1)
+	if _, err := pipe.Exec(mm.ctx); err != nil {
+		log.Printf("[Instance %s] Failed to dead-letter session_ended event: %v", mm.instanceID, err)
+	}
+}
+
+// listDeadLetters returns the raw and decoded entries, newest first
+func (mm *MatchManager) listDeadLetters() ([]string, []models.DeadLetter, error) {
+	raw, err := mm.rdb.LRange(mm.ctx, sessionEndedDLQKey, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	letters := make([]models.DeadLetter, 0, len(raw))
+	for _, entry := range raw {
+		var letter models.DeadLetter
+		if err := json.Unmarshal([]byte(entry), &letter); err != nil {
+			letter = models.DeadLetter{Payload: entry, Error: "unreadable dead letter"}
+		}
+		letters = append(letters, letter)
+	}
+	return raw, letters, nil
+}
+
+var errDeadLetterNotFound = errors.New("dead letter not found")
+
+// replayDeadLetter reprocesses a dead letter, with payload replacing the
+// stored one when set. The entry is removed once the event is accepted; a
+// payload that is still invalid leaves it in place.
+func (mm *MatchManager) replayDeadLetter(id, payload string) (string, error) {
+	raw, letters, err := mm.listDeadLetters()
+	if err != nil {
+		return "", err
+	}
+	for i, letter := range letters {
+		if letter.ID != id {
+			continue
+		}
+		if payload == "" {
+			payload = letter.Payload
+		}
+		result, err := mm.processSessionEnded(payload)
+		metrics.SessionEndedEvents.WithLabelValues(result).Inc()
+		if err != nil {
+			return result, err
+		}
+		mm.rdb.LRem(mm.ctx, sessionEndedDLQKey, 1, raw[i])
+		log.Printf("[Instance %s] Replayed dead letter %s: %s", mm.instanceID, id, result)
+		return result, nil
+	}
+	return "", errDeadLetterNotFound
+}
+
+// --- Admin: session_ended Dead Letters ---
+
+// ListDeadLettersHandler returns the dead-lettered session_ended payloads
+func (mm *MatchManager) ListDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	_, letters, err := mm.listDeadLetters()
+	if err != nil {
+		log.Printf("[Instance %s] Failed to list dead letters: %v", mm.instanceID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to list dead letters"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: letters})
+}
+
+// ReplayDeadLetterHandler reprocesses a dead letter by id
+func (mm *MatchManager) ReplayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !mm.authorizedAdmin(r) {
+		utils.WriteJSON(w, http.StatusUnauthorized, models.Resp{OK: false, Info: "unauthorized"})
+		return
+	}
+
+	var req models.ReplayReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "invalid json"})
+		return
+	}
+	if req.ID == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, models.Resp{OK: false, Info: "id required"})
+		return
+	}
+
+	result, err := mm.replayDeadLetter(req.ID, req.Payload)
+	switch {
+	case errors.Is(err, errDeadLetterNotFound):
+		utils.WriteJSON(w, http.StatusNotFound, models.Resp{OK: false, Info: err.Error()})
+	case result == sessionEndedInvalid:
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, models.Resp{OK: false, Info: err.Error()})
+	case err != nil:
+		log.Printf("[Instance %s] Failed to replay dead letter %s: %v", mm.instanceID, req.ID, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, models.Resp{OK: false, Info: "failed to replay dead letter"})
+	default:
+		utils.WriteJSON(w, http.StatusOK, models.Resp{OK: true, Info: result})
+	}
+}
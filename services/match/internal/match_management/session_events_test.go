@@ -0,0 +1,183 @@
+package match_management
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"match/internal/metrics"
+	"match/internal/models"
+)
+
+// publishSessionEnded publishes an event once the match manager is listening
+// and waits until it has been handled
+func publishSessionEnded(t *testing.T, mr *miniredis.Miniredis, rdb *redis.Client, payload string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub("session_ended")["session_ended"] > 0
+	}, 2*time.Second, 10*time.Millisecond, "match manager never subscribed")
+
+	before := sessionEndedTotal()
+	require.NoError(t, rdb.Publish(context.Background(), "session_ended", payload).Err())
+	require.Eventually(t, func() bool {
+		return sessionEndedTotal() > before
+	}, 2*time.Second, 10*time.Millisecond, "event never handled")
+}
+
+func sessionEndedTotal() float64 {
+	return sessionEndedCount(sessionEndedProcessed) + sessionEndedCount(sessionEndedDuplicate) + sessionEndedCount(sessionEndedInvalid)
+}
+
+func sessionEndedCount(result string) float64 {
+	return testutil.ToFloat64(metrics.SessionEndedEvents.WithLabelValues(result))
+}
+
+func seedRoom(rdb *redis.Client, matchID, user1, user2 string) {
+	ctx := context.Background()
+	rdb.Set(ctx, "user_room:"+user1, matchID, RoomExpiration)
+	rdb.Set(ctx, "user_room:"+user2, matchID, RoomExpiration)
+	rdb.Set(ctx, "room:"+matchID, `{"matchId":"`+matchID+`"}`, RoomExpiration)
+}
+
+func sessionEndedPayload(matchID, user1, user2 string) string {
+	payload, _ := json.Marshal(models.SessionEndedEvent{MatchID: matchID, User1: user1, User2: user2})
+	return string(payload)
+}
+
+func deadLetters(t *testing.T, mm *MatchManager) []models.DeadLetter {
+	t.Helper()
+	_, letters, err := mm.listDeadLetters()
+	require.NoError(t, err)
+	return letters
+}
+
+func TestSessionEnded_ProcessesOnce(t *testing.T) {
+	mr, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	ctx := context.Background()
+
+	seedRoom(rdb, "m1", "user1", "user2")
+	processed, duplicates := sessionEndedCount(sessionEndedProcessed), sessionEndedCount(sessionEndedDuplicate)
+
+	publishSessionEnded(t, mr, rdb, sessionEndedPayload("m1", "user1", "user2"))
+	assert.Equal(t, processed+1, sessionEndedCount(sessionEndedProcessed))
+	assert.False(t, mr.Exists("user_room:user1"))
+	assert.False(t, mr.Exists("user_room:user2"))
+	assert.False(t, mr.Exists("room:m1"))
+	assert.True(t, mr.Exists("session_ended_processed:m1"))
+	assert.InDelta(t, sessionEndedProcessedTTL, mr.TTL("session_ended_processed:m1"), float64(time.Second))
+
+	// A duplicate is acknowledged without touching state again
+	rdb.Set(ctx, "room:m1", "recreated", RoomExpiration)
+	publishSessionEnded(t, mr, rdb, sessionEndedPayload("m1", "user1", "user2"))
+	assert.Equal(t, duplicates+1, sessionEndedCount(sessionEndedDuplicate))
+	assert.True(t, mr.Exists("room:m1"))
+	assert.Empty(t, deadLetters(t, mm))
+}
+
+func TestSessionEnded_KeepsNewerRoomPointers(t *testing.T) {
+	mr, rdb, pubSubClient := setupTestRedis(t)
+	NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	// user1 already moved on to a new room before the old event arrived
+	seedRoom(rdb, "m-old", "user1", "user2")
+	rdb.Set(context.Background(), "user_room:user1", "m-new", RoomExpiration)
+
+	publishSessionEnded(t, mr, rdb, sessionEndedPayload("m-old", "user1", "user2"))
+
+	room, err := rdb.Get(context.Background(), "user_room:user1").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "m-new", room)
+	assert.False(t, mr.Exists("user_room:user2"))
+	assert.False(t, mr.Exists("room:m-old"))
+}
+
+func TestSessionEnded_DeadLettersInvalidEvents(t *testing.T) {
+	mr, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+	invalid := sessionEndedCount(sessionEndedInvalid)
+
+	publishSessionEnded(t, mr, rdb, "not json")
+	publishSessionEnded(t, mr, rdb, `{"matchId":"m1","user1":"user1"}`)
+
+	assert.Equal(t, invalid+2, sessionEndedCount(sessionEndedInvalid))
+	letters := deadLetters(t, mm)
+	require.Len(t, letters, 2)
+	assert.Equal(t, `{"matchId":"m1","user1":"user1"}`, letters[0].Payload)
+	assert.Contains(t, letters[0].Error, "user2")
+	assert.Equal(t, "not json", letters[1].Payload)
+	assert.Contains(t, letters[1].Error, "failed to parse")
+	assert.NotEmpty(t, letters[1].ID)
+	assert.False(t, letters[1].ReceivedAt.IsZero())
+	assert.False(t, mr.Exists("session_ended_processed:m1"))
+}
+
+func TestSessionEnded_DeadLetterQueueIsCapped(t *testing.T) {
+	_, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	for i := 0; i < sessionEndedDLQMax+5; i++ {
+		mm.handleSessionEndedEvent("{}")
+	}
+	assert.Equal(t, int64(sessionEndedDLQMax), rdb.LLen(context.Background(), sessionEndedDLQKey).Val())
+}
+
+func TestDeadLetterAdminHandlers(t *testing.T) {
+	mr, rdb, pubSubClient := setupTestRedis(t)
+	mm := NewMatchManager([]byte("test-secret"), rdb, pubSubClient)
+
+	call := func(handler http.HandlerFunc, method, body, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/session-ended/dlq", bytes.NewBufferString(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	const admin = "Bearer admin-token"
+
+	assert.Equal(t, http.StatusUnauthorized, call(mm.ListDeadLettersHandler, http.MethodGet, "", admin).Code)
+	mm.SetAdminToken("admin-token")
+	assert.Equal(t, http.StatusUnauthorized, call(mm.ReplayDeadLetterHandler, http.MethodPost, `{"id":"x"}`, "").Code)
+
+	seedRoom(rdb, "m1", "user1", "user2")
+	mm.handleSessionEndedEvent(`{"matchId":"m1","user1":"user1"}`)
+
+	w := call(mm.ListDeadLettersHandler, http.MethodGet, "", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Info []models.DeadLetter `json:"info"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Info, 1)
+	id := list.Info[0].ID
+
+	assert.Equal(t, http.StatusBadRequest, call(mm.ReplayDeadLetterHandler, http.MethodPost, `{}`, admin).Code)
+	assert.Equal(t, http.StatusNotFound, call(mm.ReplayDeadLetterHandler, http.MethodPost, `{"id":"missing"}`, admin).Code)
+
+	// Replaying the stored payload fails again and keeps the entry
+	assert.Equal(t, http.StatusUnprocessableEntity, call(mm.ReplayDeadLetterHandler, http.MethodPost, `{"id":"`+id+`"}`, admin).Code)
+	assert.Len(t, deadLetters(t, mm), 1)
+	assert.True(t, mr.Exists("user_room:user1"))
+
+	// A corrected payload is processed and the entry removed
+	body, _ := json.Marshal(models.ReplayReq{ID: id, Payload: sessionEndedPayload("m1", "user1", "user2")})
+	w = call(mm.ReplayDeadLetterHandler, http.MethodPost, string(body), admin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), sessionEndedProcessed)
+	assert.Empty(t, deadLetters(t, mm))
+	assert.False(t, mr.Exists("user_room:user1"))
+	assert.False(t, mr.Exists("user_room:user2"))
+	assert.False(t, mr.Exists("room:m1"))
+}
@@ -46,6 +46,37 @@ var (
 		Help:      "Size of HTTP requests in bytes",
 		Buckets:   prometheus.ExponentialBuckets(200, 2, 8),
 	}, []string{"service", "method", "path", "status"})
+
+	// SessionEndedEvents counts session_ended events by result: processed,
+	// duplicate or invalid
+	SessionEndedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "match_session_ended_events_total",
+		Help:      "Total number of session_ended events handled by the match service",
+	}, []string{"result"})
+
+	// RegionPairings counts formed matches by stage and by how the pair's
+	// regions relate: same, adjacent, cross or any (a user without preference)
+	RegionPairings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "match_region_pairings_total",
+		Help:      "Total number of matches formed, by stage and region relation of the pair",
+	}, []string{"stage", "pairing"})
+
+	// RegisteredConnections is the number of users with a WebSocket on this instance
+	RegisteredConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "peerprep",
+		Name:      "match_ws_connections",
+		Help:      "Current number of user WebSocket connections registered on this match instance",
+	})
+)
+
+// Region relations of a matched pair, see RegionPairings
+const (
+	RegionPairingSame     = "same"
+	RegionPairingAdjacent = "adjacent"
+	RegionPairingCross    = "cross"
+	RegionPairingAny      = "any"
 )
 
 type responseRecorder struct {
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -11,10 +14,15 @@ const (
 	DifficultyHard   = "hard"
 )
 
+// RegionAny is the region of users without a preference. It is compatible
+// with every region at every stage.
+const RegionAny = "any"
+
 type JoinReq struct {
 	UserID     string `json:"userId"`
 	Category   string `json:"category"`
 	Difficulty string `json:"difficulty"`
+	Region     string `json:"region,omitempty"` // one of MatchConfig.Regions, or "any" (default)
 }
 
 type Resp struct {
@@ -32,6 +40,7 @@ type RoomInfo struct {
 	Token1     string `json:"token1"`
 	Token2     string `json:"token2"`
 	CreatedAt  string `json:"createdAt"`
+	Region     string `json:"region,omitempty"` // common region of the pair, "any" when neither had a preference
 }
 
 type PendingMatch struct {
@@ -44,6 +53,7 @@ type PendingMatch struct {
 	User1Diff  string
 	User2Cat   string
 	User2Diff  string
+	Region     string
 	Token1     string
 	Token2     string
 	Handshakes map[string]bool
@@ -58,9 +68,241 @@ type HandshakeReq struct {
 }
 
 type CheckResp struct {
-	InRoom     bool   `json:"inRoom"`
-	RoomId     string `json:"roomId,omitempty"`
-	Category   string `json:"category,omitempty"`
-	Difficulty string `json:"difficulty,omitempty"`
-	Token      string `json:"token,omitempty"`
+	InRoom     bool         `json:"inRoom"`
+	RoomId     string       `json:"roomId,omitempty"`
+	Category   string       `json:"category,omitempty"`
+	Difficulty string       `json:"difficulty,omitempty"`
+	Token      string       `json:"token,omitempty"`
+	Queue      *QueueStatus `json:"queue,omitempty"`
+}
+
+// Region scopes: how far from their own region a queued user may currently be
+// matched, relaxed with the stage
+const (
+	RegionScopeSame     = "same"
+	RegionScopeAdjacent = "adjacent"
+	RegionScopeAny      = "any"
+)
+
+// QueueStatus is the feedback shown to a queued user. EstimatedWaitSeconds is
+// null while the (category, difficulty) queue has no match history.
+type QueueStatus struct {
+	Position             int    `json:"position"`
+	EstimatedWaitSeconds *int   `json:"estimatedWaitSeconds"`
+	Stage                int    `json:"stage"`
+	Region               string `json:"region"`
+	RegionScope          string `json:"regionScope"`
+}
+
+// TokenKeysInfo describes the room token key set without exposing secrets
+type TokenKeysInfo struct {
+	SigningKid     string   `json:"signingKid"`
+	VerifierKids   []string `json:"verifierKids"`
+	LegacyFallback bool     `json:"legacyFallback"`
+}
+
+// MatchmakingBan keeps a user out of matchmaking. ExpiresAt is nil for a
+// permanent ban; RemainingSeconds is filled in when the ban is read.
+type MatchmakingBan struct {
+	UserID           string     `json:"userId"`
+	Reason           string     `json:"reason"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	RemainingSeconds *int64     `json:"remainingSeconds,omitempty"`
+}
+
+// BanReq applies a cooldown of DurationSec seconds, or a permanent ban
+type BanReq struct {
+	UserID      string `json:"userId"`
+	DurationSec int64  `json:"durationSec"`
+	Permanent   bool   `json:"permanent"`
+	Reason      string `json:"reason"`
+}
+
+// BannedResp is returned with 403 when a banned user tries to enter matchmaking
+type BannedResp struct {
+	Error     string     `json:"error"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Reason    string     `json:"reason"`
+}
+
+// SessionEndedEvent is published by the collab service when a session ends
+type SessionEndedEvent struct {
+	MatchID string `json:"matchId"`
+	User1   string `json:"user1"`
+	User2   string `json:"user2"`
+}
+
+// DeadLetter is a session_ended payload that could not be processed
+type DeadLetter struct {
+	ID         string    `json:"id"`
+	Payload    string    `json:"payload"`
+	Error      string    `json:"error"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// ReplayReq replays a dead letter, optionally with a corrected payload
+type ReplayReq struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload,omitempty"`
+}
+
+// MatchConfig holds the matchmaking timings, Elo bands and regions. A queued
+// user moves to the next stage once they have waited longer than that stage's
+// timeout; pairs match at a stage when their Elo differs by at most its band.
+// Regions must be identical at stage 1, identical or adjacent at stage 2 and
+// are ignored at stage 3. RegionAdjacency is symmetric: listing b under a
+// also makes a adjacent to b. Version is assigned when the config is stored
+// for all instances.
+type MatchConfig struct {
+	Stage1TimeoutSec    int64               `json:"stage1TimeoutSec"`
+	Stage2TimeoutSec    int64               `json:"stage2TimeoutSec"`
+	Stage3TimeoutSec    int64               `json:"stage3TimeoutSec"`
+	HandshakeTimeoutSec int64               `json:"handshakeTimeoutSec"`
+	EloBandStage1       float64             `json:"eloBandStage1"`
+	EloBandStage2       float64             `json:"eloBandStage2"`
+	EloBandStage3       float64             `json:"eloBandStage3"`
+	Regions             []string            `json:"regions"`
+	RegionAdjacency     map[string][]string `json:"regionAdjacency"`
+	Version             int64               `json:"version"`
+}
+
+// DefaultMatchConfig returns the built-in timings, bands and regions
+func DefaultMatchConfig() MatchConfig {
+	return MatchConfig{
+		Stage1TimeoutSec:    100,
+		Stage2TimeoutSec:    200,
+		Stage3TimeoutSec:    300,
+		HandshakeTimeoutSec: 20,
+		EloBandStage1:       100,
+		EloBandStage2:       200,
+		EloBandStage3:       300,
+		Regions:             DefaultRegions(),
+		RegionAdjacency:     DefaultRegionAdjacency(),
+	}
+}
+
+// DefaultRegions returns the built-in region list
+func DefaultRegions() []string {
+	return []string{"ap-southeast", "us-east", "eu-west"}
+}
+
+// DefaultRegionAdjacency returns the built-in adjacency map
+func DefaultRegionAdjacency() map[string][]string {
+	return map[string][]string{"us-east": {"eu-west"}}
+}
+
+// WithRegionDefaults fills in the default regions and adjacency when they are
+// missing, e.g. from a config stored before regions existed. An empty, non-nil
+// adjacency map is kept and means no regions are adjacent.
+func (c MatchConfig) WithRegionDefaults() MatchConfig {
+	if len(c.Regions) == 0 {
+		c.Regions = DefaultRegions()
+	}
+	if c.RegionAdjacency == nil {
+		c.RegionAdjacency = DefaultRegionAdjacency()
+	}
+	return c
+}
+
+// KnownRegion reports whether region is "any" or one of the configured regions
+func (c MatchConfig) KnownRegion(region string) bool {
+	if region == RegionAny {
+		return true
+	}
+	for _, r := range c.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionsAdjacent reports whether a and b are adjacent in either direction
+func (c MatchConfig) RegionsAdjacent(a, b string) bool {
+	for _, r := range c.RegionAdjacency[a] {
+		if r == b {
+			return true
+		}
+	}
+	for _, r := range c.RegionAdjacency[b] {
+		if r == a {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionsCompatible reports whether users in regions a and b may be matched
+// at stage
+func (c MatchConfig) RegionsCompatible(a, b string, stage int) bool {
+	if a == RegionAny || b == RegionAny || a == b {
+		return true
+	}
+	switch stage {
+	case 1:
+		return false
+	case 2:
+		return c.RegionsAdjacent(a, b)
+	}
+	return true
+}
+
+// RegionScope describes how far from region a user may be matched at stage
+func RegionScope(region string, stage int) string {
+	switch {
+	case region == RegionAny || stage >= 3:
+		return RegionScopeAny
+	case stage == 2:
+		return RegionScopeAdjacent
+	}
+	return RegionScopeSame
+}
+
+// Validate requires positive values and strictly increasing stage timeouts
+func (c MatchConfig) Validate() error {
+	var errs []error
+	if c.Stage1TimeoutSec <= 0 || c.Stage2TimeoutSec <= 0 || c.Stage3TimeoutSec <= 0 {
+		errs = append(errs, errors.New("stage timeouts must be positive"))
+	} else if c.Stage1TimeoutSec >= c.Stage2TimeoutSec || c.Stage2TimeoutSec >= c.Stage3TimeoutSec {
+		errs = append(errs, errors.New("stage timeouts must increase from stage 1 to stage 3"))
+	}
+	if c.HandshakeTimeoutSec <= 0 {
+		errs = append(errs, errors.New("handshake timeout must be positive"))
+	}
+	if c.EloBandStage1 <= 0 || c.EloBandStage2 <= 0 || c.EloBandStage3 <= 0 {
+		errs = append(errs, errors.New("elo bands must be positive"))
+	}
+	if len(c.Regions) == 0 {
+		errs = append(errs, errors.New("at least one region is required"))
+	}
+	seen := make(map[string]bool, len(c.Regions))
+	for _, r := range c.Regions {
+		if r == "" || r == RegionAny || r != strings.ToLower(strings.TrimSpace(r)) || seen[r] {
+			errs = append(errs, fmt.Errorf("invalid region %q", r))
+		}
+		seen[r] = true
+	}
+	for r, adjacent := range c.RegionAdjacency {
+		for _, a := range append([]string{r}, adjacent...) {
+			if !seen[a] {
+				errs = append(errs, fmt.Errorf("region adjacency names unknown region %q", a))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EloBand returns the allowed Elo difference at stage, or 0 and false when the
+// stage has no restriction
+func (c MatchConfig) EloBand(stage int) (float64, bool) {
+	switch stage {
+	case 1:
+		return c.EloBandStage1, true
+	case 2:
+		return c.EloBandStage2, true
+	case 3:
+		return c.EloBandStage3, true
+	}
+	return 0, false
 }
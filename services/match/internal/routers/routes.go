@@ -15,6 +15,14 @@ func MatchRoutes(r *chi.Mux, mm *matchManager.MatchManager) {
 		r.Post("/handshake", mm.HandshakeHandler)
 		r.Post("/session/feedback", mm.SessionFeedbackHandler)
 		r.HandleFunc("/ws", mm.WsHandler)
+		r.Get("/admin/token-keys", mm.TokenKeysHandler)
+		r.Post("/admin/bans", mm.CreateBanHandler)
+		r.Get("/admin/bans", mm.ListBansHandler)
+		r.Delete("/admin/bans", mm.DeleteBanHandler)
+		r.Get("/admin/session-ended/dlq", mm.ListDeadLettersHandler)
+		r.Post("/admin/session-ended/dlq/replay", mm.ReplayDeadLetterHandler)
+		r.Get("/admin/config", mm.GetConfigHandler)
+		r.Put("/admin/config", mm.UpdateConfigHandler)
 
 		r.Options("/join", mm.JoinHandler)
 		r.Options("/cancel", mm.CancelHandler)
@@ -67,6 +67,42 @@ func TestMatchRoutes(t *testing.T) {
 			path:           "/api/v1/match/ws",
 			expectedStatus: http.StatusBadRequest, // Will fail upgrade, but route exists
 		},
+		{
+			name:           "Admin token keys endpoint exists",
+			method:         http.MethodGet,
+			path:           "/api/v1/match/admin/token-keys",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin bans endpoint exists",
+			method:         http.MethodGet,
+			path:           "/api/v1/match/admin/bans",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin session_ended DLQ endpoint exists",
+			method:         http.MethodGet,
+			path:           "/api/v1/match/admin/session-ended/dlq",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin session_ended replay endpoint exists",
+			method:         http.MethodPost,
+			path:           "/api/v1/match/admin/session-ended/dlq/replay",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin config endpoint exists",
+			method:         http.MethodGet,
+			path:           "/api/v1/match/admin/config",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin config update endpoint exists",
+			method:         http.MethodPut,
+			path:           "/api/v1/match/admin/config",
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:           "Non-existent endpoint returns 404",
 			method:         http.MethodGet,
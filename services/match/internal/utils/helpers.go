@@ -26,16 +26,24 @@ func EnableCORS(w http.ResponseWriter) {
 }
 
 // --- JWT Helper ---
-func GenerateRoomToken(matchId, userId string, jwtSecret []byte) (string, error) {
+
+// Roles a room token grants. Spectators may watch a room but not edit it.
+const (
+	RoleParticipant = "participant"
+	RoleSpectator   = "spectator"
+)
+
+// GenerateRoomToken signs a room token for role with the active key of the key set
+func GenerateRoomToken(matchId, userId, role string, keys *RoomTokenKeys) (string, error) {
 	claims := jwt.MapClaims{
 		"matchId": matchId,
 		"userId":  userId,
+		"role":    role,
 		"exp":     time.Now().Add(24 * time.Hour).Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return keys.Sign(claims)
 }
 
 func GetDifficultyToInt(diff string) int {
@@ -0,0 +1,133 @@
+package utils
+
+// This file is kept identical in the match, collab and voice services so that
+// room tokens are signed and parsed the same way everywhere. Change all copies
+// together.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKid      = errors.New("unknown room token key id")
+	ErrNoLegacySecret  = errors.New("token has no key id and no legacy secret is configured")
+	ErrInvalidKeyEntry = errors.New("room token keys need a non-empty, unique kid and secret")
+)
+
+// RoomTokenKey is one entry of the ROOM_TOKEN_KEYS set.
+type RoomTokenKey struct {
+	Kid    string `json:"kid"`
+	Secret string `json:"secret"`
+}
+
+// RoomTokenKeys signs room tokens with the active key and verifies tokens
+// signed by any listed key. Tokens without a kid header fall back to the
+// legacy single secret (JWT_SECRET) while services migrate.
+//
+// Rotation: add the new key to every service's set, redeploy, point
+// ROOM_TOKEN_SIGNING_KID at it on the signer, then drop the old key once
+// tokens signed with it have expired.
+type RoomTokenKeys struct {
+	keys       map[string][]byte
+	order      []string // as configured, newest first
+	signingKid string
+	legacy     []byte
+}
+
+// NewRoomTokenKeys builds a key set. Keys are ordered newest first; the newest
+// signs unless signingKid names another listed key. With no keys at all,
+// tokens are signed and verified with the legacy secret only.
+func NewRoomTokenKeys(keys []RoomTokenKey, signingKid string, legacySecret []byte) (*RoomTokenKeys, error) {
+	ks := &RoomTokenKeys{keys: make(map[string][]byte, len(keys)), legacy: legacySecret}
+	for _, k := range keys {
+		if k.Kid == "" || k.Secret == "" {
+			return nil, ErrInvalidKeyEntry
+		}
+		if _, dup := ks.keys[k.Kid]; dup {
+			return nil, ErrInvalidKeyEntry
+		}
+		ks.keys[k.Kid] = []byte(k.Secret)
+		ks.order = append(ks.order, k.Kid)
+	}
+
+	switch {
+	case signingKid != "":
+		if _, ok := ks.keys[signingKid]; !ok {
+			return nil, fmt.Errorf("signing kid %q: %w", signingKid, ErrUnknownKid)
+		}
+		ks.signingKid = signingKid
+	case len(ks.order) > 0:
+		ks.signingKid = ks.order[0]
+	case len(legacySecret) == 0:
+		return nil, errors.New("no room token keys or legacy secret configured")
+	}
+	return ks, nil
+}
+
+// ParseRoomTokenKeys decodes the JSON form used by ROOM_TOKEN_KEYS.
+func ParseRoomTokenKeys(raw string) ([]RoomTokenKey, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var keys []RoomTokenKey
+	if err := json.Unmarshal([]byte(raw), &keys); err != nil {
+		return nil, fmt.Errorf("invalid ROOM_TOKEN_KEYS: %w", err)
+	}
+	return keys, nil
+}
+
+// LoadRoomTokenKeys reads ROOM_TOKEN_KEYS and ROOM_TOKEN_SIGNING_KID, using
+// legacySecret for tokens minted before key ids were introduced.
+func LoadRoomTokenKeys(legacySecret []byte) (*RoomTokenKeys, error) {
+	keys, err := ParseRoomTokenKeys(os.Getenv("ROOM_TOKEN_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	return NewRoomTokenKeys(keys, strings.TrimSpace(os.Getenv("ROOM_TOKEN_SIGNING_KID")), legacySecret)
+}
+
+// Sign issues an HS256 token with the active key, stamping its kid header.
+func (ks *RoomTokenKeys) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if ks.signingKid == "" {
+		return token.SignedString(ks.legacy)
+	}
+	token.Header["kid"] = ks.signingKid
+	return token.SignedString(ks.keys[ks.signingKid])
+}
+
+// Keyfunc resolves the verification key for a parsed token; pass it to
+// jwt.ParseWithClaims.
+func (ks *RoomTokenKeys) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, errors.New("unexpected signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(ks.legacy) == 0 {
+			return nil, ErrNoLegacySecret
+		}
+		return ks.legacy, nil
+	}
+	secret, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKid
+	}
+	return secret, nil
+}
+
+// SigningKid returns the kid new tokens are signed with, empty when signing
+// with the legacy secret.
+func (ks *RoomTokenKeys) SigningKid() string { return ks.signingKid }
+
+// KeyIDs returns the kids accepted for verification, newest first.
+func (ks *RoomTokenKeys) KeyIDs() []string { return append([]string(nil), ks.order...) }
+
+// LegacyFallback reports whether tokens without a kid are still accepted.
+func (ks *RoomTokenKeys) LegacyFallback() bool { return len(ks.legacy) > 0 }
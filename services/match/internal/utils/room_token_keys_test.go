@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustKeys(t *testing.T, keys []RoomTokenKey, signingKid string, legacy []byte) *RoomTokenKeys {
+	t.Helper()
+	ks, err := NewRoomTokenKeys(keys, signingKid, legacy)
+	require.NoError(t, err)
+	return ks
+}
+
+func verify(ks *RoomTokenKeys, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, ks.Keyfunc)
+	return claims, err
+}
+
+func sign(t *testing.T, ks *RoomTokenKeys, matchId string) string {
+	t.Helper()
+	token, err := GenerateRoomToken(matchId, "user-1", RoleParticipant, ks)
+	require.NoError(t, err)
+	return token
+}
+
+func kidOf(t *testing.T, token string) interface{} {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	return parsed.Header["kid"]
+}
+
+var (
+	oldKey = RoomTokenKey{Kid: "2024-01", Secret: "old-secret"}
+	newKey = RoomTokenKey{Kid: "2024-06", Secret: "new-secret"}
+)
+
+func TestRoomTokenKeys_SignsWithNewestKey(t *testing.T) {
+	ks := mustKeys(t, []RoomTokenKey{newKey, oldKey}, "", nil)
+	token := sign(t, ks, "m1")
+
+	assert.Equal(t, "2024-06", kidOf(t, token))
+	claims, err := verify(ks, token)
+	require.NoError(t, err)
+	assert.Equal(t, "m1", claims["matchId"])
+	assert.Equal(t, []string{"2024-06", "2024-01"}, ks.KeyIDs())
+}
+
+func TestRoomTokenKeys_VerifiesOldAndNewKeys(t *testing.T) {
+	verifier := mustKeys(t, []RoomTokenKey{newKey, oldKey}, "", nil)
+
+	for _, signer := range []*RoomTokenKeys{
+		mustKeys(t, []RoomTokenKey{oldKey}, "", nil),
+		mustKeys(t, []RoomTokenKey{newKey}, "", nil),
+	} {
+		_, err := verify(verifier, sign(t, signer, "m1"))
+		assert.NoError(t, err, "kid %s should verify", signer.SigningKid())
+	}
+}
+
+func TestRoomTokenKeys_RejectsUnknownKid(t *testing.T) {
+	signer := mustKeys(t, []RoomTokenKey{{Kid: "rogue", Secret: "new-secret"}}, "", nil)
+	verifier := mustKeys(t, []RoomTokenKey{newKey}, "", []byte("legacy"))
+
+	_, err := verify(verifier, sign(t, signer, "m1"))
+	assert.ErrorIs(t, err, ErrUnknownKid)
+}
+
+func TestRoomTokenKeys_LegacyFallback(t *testing.T) {
+	legacySigner := mustKeys(t, nil, "", []byte("legacy"))
+	token := sign(t, legacySigner, "m1")
+	assert.Nil(t, kidOf(t, token), "legacy tokens carry no kid")
+
+	withLegacy := mustKeys(t, []RoomTokenKey{newKey}, "", []byte("legacy"))
+	_, err := verify(withLegacy, token)
+	assert.NoError(t, err)
+
+	withoutLegacy := mustKeys(t, []RoomTokenKey{newKey}, "", nil)
+	_, err = verify(withoutLegacy, token)
+	assert.ErrorIs(t, err, ErrNoLegacySecret)
+}
+
+func TestRoomTokenKeys_InvalidConfig(t *testing.T) {
+	_, err := NewRoomTokenKeys([]RoomTokenKey{newKey, newKey}, "", nil)
+	assert.ErrorIs(t, err, ErrInvalidKeyEntry)
+
+	_, err = NewRoomTokenKeys([]RoomTokenKey{{Kid: "x"}}, "", nil)
+	assert.ErrorIs(t, err, ErrInvalidKeyEntry)
+
+	_, err = NewRoomTokenKeys([]RoomTokenKey{newKey}, "missing", nil)
+	assert.ErrorIs(t, err, ErrUnknownKid)
+
+	_, err = NewRoomTokenKeys(nil, "", nil)
+	assert.Error(t, err)
+
+	_, err = ParseRoomTokenKeys("not json")
+	assert.Error(t, err)
+}
+
+func TestLoadRoomTokenKeysFromEnv(t *testing.T) {
+	t.Setenv("ROOM_TOKEN_KEYS", `[{"kid":"2024-06","secret":"new-secret"},{"kid":"2024-01","secret":"old-secret"}]`)
+	t.Setenv("ROOM_TOKEN_SIGNING_KID", "2024-01")
+
+	ks, err := LoadRoomTokenKeys([]byte("legacy"))
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01", ks.SigningKid())
+	assert.Equal(t, []string{"2024-06", "2024-01"}, ks.KeyIDs())
+	assert.True(t, ks.LegacyFallback())
+}
+
+// Walks the documented rotation: legacy -> old key -> add new key everywhere
+// -> flip signing -> retire old key. Tokens minted in each phase must keep
+// working until the key that signed them is removed.
+func TestRoomTokenKeys_RotationSequence(t *testing.T) {
+	legacy := []byte("legacy")
+
+	// Phase 0: legacy single secret everywhere.
+	signer := mustKeys(t, nil, "", legacy)
+	legacyToken := sign(t, signer, "m0")
+
+	// Phase 1: both services gain the first keyed entry; legacy still accepted.
+	signer = mustKeys(t, []RoomTokenKey{oldKey}, "", legacy)
+	verifier := mustKeys(t, []RoomTokenKey{oldKey}, "", legacy)
+	oldToken := sign(t, signer, "m1")
+	for _, tok := range []string{legacyToken, oldToken} {
+		_, err := verify(verifier, tok)
+		require.NoError(t, err)
+	}
+
+	// Phase 2: new key added to both sets, signer pinned to the old key until
+	// every verifier has been redeployed.
+	signer = mustKeys(t, []RoomTokenKey{newKey, oldKey}, oldKey.Kid, legacy)
+	verifier = mustKeys(t, []RoomTokenKey{newKey, oldKey}, "", legacy)
+	assert.Equal(t, oldKey.Kid, kidOf(t, sign(t, signer, "m2")))
+
+	// Phase 3: flip signing to the new key; old tokens still verify.
+	signer = mustKeys(t, []RoomTokenKey{newKey, oldKey}, "", legacy)
+	newToken := sign(t, signer, "m3")
+	assert.Equal(t, newKey.Kid, kidOf(t, newToken))
+	for _, tok := range []string{oldToken, newToken} {
+		_, err := verify(verifier, tok)
+		require.NoError(t, err)
+	}
+
+	// Phase 4: old key and legacy secret retired.
+	verifier = mustKeys(t, []RoomTokenKey{newKey}, "", nil)
+	_, err := verify(verifier, newToken)
+	require.NoError(t, err)
+	_, err = verify(verifier, oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKid)
+	_, err = verify(verifier, legacyToken)
+	assert.ErrorIs(t, err, ErrNoLegacySecret)
+}
+
+func TestGenerateRoomTokenExpiry(t *testing.T) {
+	ks := mustKeys(t, []RoomTokenKey{newKey}, "", nil)
+	claims, err := verify(ks, sign(t, ks, "m1"))
+	require.NoError(t, err)
+	exp, err := claims.GetExpirationTime()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), exp.Time, time.Minute)
+}
+
+func TestGenerateRoomTokenRole(t *testing.T) {
+	ks := mustKeys(t, []RoomTokenKey{newKey}, "", nil)
+	token, err := GenerateRoomToken("m1", "mentor-1", RoleSpectator, ks)
+	require.NoError(t, err)
+	claims, err := verify(ks, token)
+	require.NoError(t, err)
+	assert.Equal(t, RoleSpectator, claims["role"])
+	assert.Equal(t, "mentor-1", claims["userId"])
+}
@@ -8,6 +8,8 @@ import (
 	"syscall"
 	"time"
 
+	"peerprep/question/internal/auth"
+	"peerprep/question/internal/calibration"
 	"peerprep/question/internal/handlers"
 	"peerprep/question/internal/metrics"
 	"peerprep/question/internal/repositories"
@@ -38,8 +40,26 @@ func main() {
 
 	// initialise handlers
 	questionHandler := handlers.NewQuestionHandler(questionRepo)
+	calibrationHandler := handlers.NewCalibrationHandler(questionRepo)
 	healthHandler := handlers.NewHealthHandler()
 
+	// difficulty calibration from session results
+	calibrationCfg, err := calibration.ConfigFromEnv()
+	if err != nil {
+		logger.Fatal("invalid calibration config", zap.Error(err))
+	}
+	jobCtx, stopJobs := context.WithCancel(ctx)
+	defer stopJobs()
+	go calibration.NewJob(questionRepo, calibrationCfg, logger).Start(jobCtx)
+
+	keyAuth, err := auth.LoadKeyAuth(logger)
+	if err != nil {
+		logger.Fatal("invalid QUESTION_API_KEYS", zap.Error(err))
+	}
+	if keyAuth.KeyCount() == 0 {
+		logger.Warn("QUESTION_API_KEYS is not set; create/update/delete requests will be rejected")
+	}
+
 	router := chi.NewRouter()
 
 	// CORS middleware
@@ -47,14 +67,14 @@ func main() {
 		// AllowedOrigins:   []string{"*"},
 		AllowedOrigins:   []string{"http://localhost:5173", "https://d1z9c2graxigrz.cloudfront.net/"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization", auth.HeaderAPIKey},
 		AllowCredentials: true,
 	}))
 
 	router.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer, middleware.Timeout(60*time.Second), metrics.Middleware("question"))
 
 	router.Handle("/api/v1/questions/metrics", metrics.Handler())
-	routers.QuestionRoutes(router, questionHandler, healthHandler)
+	routers.QuestionRoutes(router, questionHandler, calibrationHandler, healthHandler, keyAuth)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"peerprep/question/internal/models"
+	"peerprep/question/internal/utils"
+)
+
+// HeaderAPIKey carries the calling service's key
+const HeaderAPIKey = "X-API-Key"
+
+var ErrInvalidKeyEntry = errors.New("api keys must be name:key pairs with unique, non-empty names")
+
+// APIKey is one named entry of QUESTION_API_KEYS. The name identifies the
+// calling service in logs so keys can be attributed and rotated per client.
+type APIKey struct {
+	Name string
+	Key  string
+}
+
+type clientNameKey struct{}
+
+// ParseAPIKeys decodes the comma-separated name:key pairs of QUESTION_API_KEYS
+func ParseAPIKeys(raw string) ([]APIKey, error) {
+	var keys []APIKey
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" || seen[name] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidKeyEntry, name)
+		}
+		seen[name] = true
+		keys = append(keys, APIKey{Name: name, Key: key})
+	}
+	return keys, nil
+}
+
+// KeyAuth authenticates service-to-service calls by X-API-Key
+type KeyAuth struct {
+	keys     []APIKey
+	readAuth bool
+	logger   *zap.Logger
+}
+
+func NewKeyAuth(keys []APIKey, readAuth bool, logger *zap.Logger) *KeyAuth {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &KeyAuth{keys: keys, readAuth: readAuth, logger: logger}
+}
+
+// LoadKeyAuth reads QUESTION_API_KEYS and QUESTION_READ_AUTH
+func LoadKeyAuth(logger *zap.Logger) (*KeyAuth, error) {
+	keys, err := ParseAPIKeys(os.Getenv("QUESTION_API_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	readAuth := strings.EqualFold(strings.TrimSpace(os.Getenv("QUESTION_READ_AUTH")), "true")
+	return NewKeyAuth(keys, readAuth, logger), nil
+}
+
+// KeyCount returns the number of configured keys
+func (a *KeyAuth) KeyCount() int { return len(a.keys) }
+
+// ReadAuth reports whether read endpoints require a key
+func (a *KeyAuth) ReadAuth() bool { return a.readAuth }
+
+// lookup returns the name of the key matching presented. Every configured key
+// is compared so timing does not reveal which one matched.
+func (a *KeyAuth) lookup(presented string) (string, bool) {
+	name := ""
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(k.Key)) == 1 {
+			name = k.Name
+		}
+	}
+	return name, name != ""
+}
+
+// RequireKey rejects requests without a valid key (mutating endpoints)
+func (a *KeyAuth) RequireKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := r.Header.Get(HeaderAPIKey)
+		if presented == "" {
+			a.reject(w, r, "missing api key")
+			return
+		}
+		name, ok := a.lookup(presented)
+		if !ok {
+			a.reject(w, r, "invalid api key")
+			return
+		}
+		a.logger.Info("authenticated request",
+			zap.String("client", name),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientNameKey{}, name)))
+	})
+}
+
+// ReadKey applies RequireKey only when QUESTION_READ_AUTH is enabled.
+// Open reads still recognise a valid key, so handlers can tell admin callers
+// apart with ClientName.
+func (a *KeyAuth) ReadKey(next http.Handler) http.Handler {
+	if !a.readAuth {
+		return a.identify(next)
+	}
+	return a.RequireKey(next)
+}
+
+// identify records the client of a valid key and lets every request through
+func (a *KeyAuth) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if presented := r.Header.Get(HeaderAPIKey); presented != "" {
+			if name, ok := a.lookup(presented); ok {
+				r = r.WithContext(context.WithValue(r.Context(), clientNameKey{}, name))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *KeyAuth) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	a.logger.Warn("rejected request",
+		zap.String("reason", reason),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	)
+	utils.JSON(w, http.StatusUnauthorized, models.ErrorResponse{
+		Code:    "unauthorized",
+		Message: "a valid " + HeaderAPIKey + " header is required",
+	})
+}
+
+// ClientName returns the authenticated client's key name, if any
+func ClientName(ctx context.Context) string {
+	name, _ := ctx.Value(clientNameKey{}).(string)
+	return name
+}
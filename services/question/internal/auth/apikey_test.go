@@ -0,0 +1,115 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"peerprep/question/internal/auth"
+	"peerprep/question/internal/models"
+)
+
+func newAuth(t *testing.T, readAuth bool) (*auth.KeyAuth, *observer.ObservedLogs) {
+	t.Helper()
+	keys, err := auth.ParseAPIKeys("collab:collab-secret, match:match-secret")
+	if err != nil {
+		t.Fatalf("ParseAPIKeys error: %v", err)
+	}
+	core, logs := observer.New(zapcore.InfoLevel)
+	return auth.NewKeyAuth(keys, readAuth, zap.New(core)), logs
+}
+
+func serve(h http.Handler, method, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/questions/", nil)
+	if key != "" {
+		req.Header.Set(auth.HeaderAPIKey, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(auth.ClientName(r.Context())))
+})
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := auth.ParseAPIKeys("")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected no keys, got %v, %v", keys, err)
+	}
+	for _, raw := range []string{"collab", "collab:", ":secret", "a:x,a:y"} {
+		if _, err := auth.ParseAPIKeys(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestRequireKey(t *testing.T) {
+	a, logs := newAuth(t, false)
+	h := a.RequireKey(okHandler)
+
+	rec := serve(h, http.MethodPost, "match-secret")
+	if rec.Code != http.StatusOK || rec.Body.String() != "match" {
+		t.Fatalf("expected match to be authenticated, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	for _, key := range []string{"", "wrong", "match-secret "} {
+		rec := serve(h, http.MethodPost, key)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for key %q, got %d", key, rec.Code)
+		}
+		var body models.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "unauthorized" {
+			t.Fatalf("expected error envelope, got %q", rec.Body.String())
+		}
+	}
+
+	accepted := logs.FilterMessage("authenticated request").All()
+	if len(accepted) != 1 || accepted[0].ContextMap()["client"] != "match" {
+		t.Fatalf("expected request log attributed to match, got %#v", accepted)
+	}
+	if n := logs.FilterMessage("rejected request").Len(); n != 3 {
+		t.Fatalf("expected 3 rejections logged, got %d", n)
+	}
+}
+
+func TestReadKeyToggle(t *testing.T) {
+	open, _ := newAuth(t, false)
+	if rec := serve(open.ReadKey(okHandler), http.MethodGet, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected open reads, got %d", rec.Code)
+	}
+	if rec := serve(open.ReadKey(okHandler), http.MethodGet, "wrong"); rec.Code != http.StatusOK || rec.Body.String() != "" {
+		t.Fatalf("expected open reads to ignore a bad key, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := serve(open.ReadKey(okHandler), http.MethodGet, "match-secret"); rec.Code != http.StatusOK || rec.Body.String() != "match" {
+		t.Fatalf("expected open reads to identify match, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	locked, _ := newAuth(t, true)
+	if rec := serve(locked.ReadKey(okHandler), http.MethodGet, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected locked reads to need a key, got %d", rec.Code)
+	}
+	if rec := serve(locked.ReadKey(okHandler), http.MethodGet, "collab-secret"); rec.Code != http.StatusOK || rec.Body.String() != "collab" {
+		t.Fatalf("expected collab read to pass, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoadKeyAuth(t *testing.T) {
+	t.Setenv("QUESTION_API_KEYS", "collab:one")
+	t.Setenv("QUESTION_READ_AUTH", "true")
+	a, err := auth.LoadKeyAuth(nil)
+	if err != nil || a.KeyCount() != 1 || !a.ReadAuth() {
+		t.Fatalf("unexpected config: %v", err)
+	}
+
+	t.Setenv("QUESTION_API_KEYS", "broken")
+	if _, err := auth.LoadKeyAuth(nil); err == nil {
+		t.Fatalf("expected malformed keys to fail")
+	}
+}
@@ -0,0 +1,266 @@
+// Package calibration compares questions' real session outcomes against the
+// other questions of the same authored difficulty and suggests a difficulty
+// for the ones that sit outside their band.
+package calibration
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"peerprep/question/internal/models"
+)
+
+// Config sets the evaluation window and the thresholds beyond which a
+// question's metrics point at another difficulty
+type Config struct {
+	Window     time.Duration // length of one evaluation window
+	MinSamples int           // questions with fewer sessions in a window are never flagged
+
+	// a median solve time ranked at or above SlowPercentile among the solves of
+	// the same difficulty points harder, at or below FastPercentile easier
+	SlowPercentile float64
+	FastPercentile float64
+	// a completion rate this far below the difficulty's rate points harder,
+	// this far above it easier
+	CompletionMargin float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Window:           7 * 24 * time.Hour,
+		MinSamples:       20,
+		SlowPercentile:   0.8,
+		FastPercentile:   0.2,
+		CompletionMargin: 0.2,
+	}
+}
+
+// ConfigFromEnv reads CALIBRATION_WINDOW, CALIBRATION_MIN_SAMPLES,
+// CALIBRATION_SLOW_PERCENTILE, CALIBRATION_FAST_PERCENTILE and
+// CALIBRATION_COMPLETION_MARGIN over the defaults
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if raw := strings.TrimSpace(os.Getenv("CALIBRATION_WINDOW")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid CALIBRATION_WINDOW %q", raw)
+		}
+		cfg.Window = d
+	}
+	if raw := strings.TrimSpace(os.Getenv("CALIBRATION_MIN_SAMPLES")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid CALIBRATION_MIN_SAMPLES %q", raw)
+		}
+		cfg.MinSamples = n
+	}
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"CALIBRATION_SLOW_PERCENTILE", &cfg.SlowPercentile},
+		{"CALIBRATION_FAST_PERCENTILE", &cfg.FastPercentile},
+		{"CALIBRATION_COMPLETION_MARGIN", &cfg.CompletionMargin},
+	} {
+		raw := strings.TrimSpace(os.Getenv(f.name))
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || v > 1 {
+			return cfg, fmt.Errorf("invalid %s %q, must be between 0 and 1", f.name, raw)
+		}
+		*f.dst = v
+	}
+	if cfg.FastPercentile >= cfg.SlowPercentile {
+		return cfg, fmt.Errorf("CALIBRATION_FAST_PERCENTILE must be below CALIBRATION_SLOW_PERCENTILE")
+	}
+	return cfg, nil
+}
+
+// outcomes of a question, or of all questions of one difficulty, in a window
+type stats struct {
+	sessions int
+	solved   int
+	solveSec []float64 // sorted once all results are added
+}
+
+func (s *stats) add(r models.SessionResult) {
+	s.sessions++
+	if r.Solved() {
+		s.solved++
+		s.solveSec = append(s.solveSec, float64(r.DurationSec))
+	}
+}
+
+func (s *stats) completionRate() float64 {
+	if s.sessions == 0 {
+		return 0
+	}
+	return float64(s.solved) / float64(s.sessions)
+}
+
+// Percentile returns the p-th percentile (0-1) of sorted values, linearly
+// interpolated between the closest ranks
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// PercentileRank returns the share of sorted values below x, counting values
+// equal to x as half below
+func PercentileRank(sorted []float64, x float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	below := sort.SearchFloat64s(sorted, x)
+	equal := sort.SearchFloat64s(sorted, math.Nextafter(x, math.Inf(1))) - below
+	return (float64(below) + float64(equal)/2) / float64(len(sorted))
+}
+
+// step moves d one difficulty up (dir > 0) or down, staying within Easy-Hard
+func step(d models.Difficulty, dir int) models.Difficulty {
+	order := []models.Difficulty{models.Easy, models.Medium, models.Hard}
+	i := models.DifficultyRank(d) + dir
+	if models.DifficultyRank(d) < 0 || i < 0 || i >= len(order) {
+		return d
+	}
+	return order[i]
+}
+
+// evaluate computes a question's window metrics against its difficulty's
+// corpus and where they point. signals counts the metrics (solve time,
+// completion) agreeing with the candidate.
+func evaluate(label models.Difficulty, q, corpus *stats, cfg Config) (cal models.Calibration, signals int) {
+	cal = models.Calibration{
+		Candidate:            label,
+		SampleSize:           q.sessions,
+		CompletionRate:       q.completionRate(),
+		CorpusCompletionRate: corpus.completionRate(),
+	}
+	if len(q.solveSec) > 0 {
+		cal.MedianSolveSec = Percentile(q.solveSec, 0.5)
+		cal.SolvePercentile = PercentileRank(corpus.solveSec, cal.MedianSolveSec)
+	}
+	if q.sessions < cfg.MinSamples {
+		cal.InsufficientData = true
+		return cal, 0
+	}
+
+	harder, easier := 0, 0
+	if len(q.solveSec) > 0 {
+		if cal.SolvePercentile >= cfg.SlowPercentile {
+			harder++
+		}
+		if cal.SolvePercentile <= cfg.FastPercentile {
+			easier++
+		}
+	}
+	if cal.CompletionRate <= cal.CorpusCompletionRate-cfg.CompletionMargin {
+		harder++
+	}
+	if cal.CompletionRate >= cal.CorpusCompletionRate+cfg.CompletionMargin {
+		easier++
+	}
+	switch {
+	case harder > easier:
+		cal.Candidate, signals = step(label, 1), harder
+	case easier > harder:
+		cal.Candidate, signals = step(label, -1), easier
+	}
+	if cal.Candidate == label {
+		signals = 0
+	}
+	return cal, signals
+}
+
+// calibrate derives a question's calibration for the window ending at
+// windowEnd. The suggestion only moves to a candidate two consecutive
+// windows agree on; a gap in the windows starts over from the label.
+func calibrate(q models.Question, qs, corpus *stats, windowEnd time.Time, cfg Config) models.Calibration {
+	cal, signals := evaluate(q.Difficulty, qs, corpus, cfg)
+	cal.WindowEnd = windowEnd
+
+	prev := q.Calibration
+	consecutive := prev != nil && prev.WindowEnd.Equal(windowEnd.Add(-cfg.Window))
+	cal.SuggestedDifficulty = q.Difficulty
+	if consecutive && !cal.InsufficientData {
+		if prev.SuggestedDifficulty != "" {
+			cal.SuggestedDifficulty = prev.SuggestedDifficulty
+		}
+		if prev.Candidate == cal.Candidate {
+			cal.SuggestedDifficulty = cal.Candidate
+		}
+	}
+
+	// full weight from five times the minimum sample size; a suggestion away
+	// from the label is further weighted by how many metrics back it now
+	cal.Confidence = math.Min(1, float64(cal.SampleSize)/float64(5*cfg.MinSamples))
+	if cal.InsufficientData {
+		cal.Confidence = 0
+	} else if cal.SuggestedDifficulty != q.Difficulty {
+		if cal.Candidate != cal.SuggestedDifficulty {
+			signals = 0
+		}
+		cal.Confidence *= 0.5 + 0.25*float64(signals)
+	}
+	cal.Confidence = math.Round(cal.Confidence*100) / 100
+	return cal
+}
+
+// Calibrate computes the calibration of every question with a known
+// difficulty from the session results of the window ending at windowEnd
+func Calibrate(questions []models.Question, results []models.SessionResult, windowEnd time.Time, cfg Config) map[int]models.Calibration {
+	labels := make(map[int]models.Difficulty, len(questions))
+	for _, q := range questions {
+		labels[q.ID] = q.Difficulty
+	}
+	perQuestion := make(map[int]*stats)
+	perDifficulty := make(map[models.Difficulty]*stats)
+	for _, r := range results {
+		label, ok := labels[r.QuestionID]
+		if !ok {
+			continue
+		}
+		if perQuestion[r.QuestionID] == nil {
+			perQuestion[r.QuestionID] = &stats{}
+		}
+		if perDifficulty[label] == nil {
+			perDifficulty[label] = &stats{}
+		}
+		perQuestion[r.QuestionID].add(r)
+		perDifficulty[label].add(r)
+	}
+	for _, s := range perQuestion {
+		sort.Float64s(s.solveSec)
+	}
+	for _, s := range perDifficulty {
+		sort.Float64s(s.solveSec)
+	}
+
+	out := make(map[int]models.Calibration, len(questions))
+	for _, q := range questions {
+		if models.DifficultyRank(q.Difficulty) < 0 {
+			continue
+		}
+		qs, corpus := perQuestion[q.ID], perDifficulty[q.Difficulty]
+		if qs == nil {
+			qs = &stats{}
+		}
+		if corpus == nil {
+			corpus = &stats{}
+		}
+		out[q.ID] = calibrate(q, qs, corpus, windowEnd, cfg)
+	}
+	return out
+}
@@ -0,0 +1,321 @@
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"peerprep/question/internal/handlers"
+	"peerprep/question/internal/models"
+)
+
+type memStore struct {
+	questions map[int]*models.Question
+	results   []models.SessionResult
+}
+
+func newMemStore(questions ...models.Question) *memStore {
+	s := &memStore{questions: make(map[int]*models.Question)}
+	for i := range questions {
+		s.questions[questions[i].ID] = &questions[i]
+	}
+	return s
+}
+
+func (s *memStore) GetAll() ([]models.Question, error) {
+	var out []models.Question
+	for _, q := range s.questions {
+		out = append(out, *q)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (s *memStore) GetByID(id int) (*models.Question, error) {
+	q, ok := s.questions[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return q, nil
+}
+
+func (s *memStore) GetSessionResults(from, to time.Time) ([]models.SessionResult, error) {
+	var out []models.SessionResult
+	for _, r := range s.results {
+		if !r.RecordedAt.Before(from) && r.RecordedAt.Before(to) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) SetCalibration(id int, cal *models.Calibration) error {
+	c := *cal
+	s.questions[id].Calibration = &c
+	return nil
+}
+
+func (s *memStore) RecordSessionResult(r *models.SessionResult) error {
+	s.results = append(s.results, *r)
+	return nil
+}
+
+var errNotFound = errors.New("question not found")
+
+// seed records n results for question id inside the window ending at windowEnd
+func (s *memStore) seed(id int, windowEnd time.Time, n int, outcome models.SessionOutcome, verdict string, durationSec int) {
+	for i := 0; i < n; i++ {
+		s.results = append(s.results, models.SessionResult{
+			QuestionID:  id,
+			Outcome:     outcome,
+			Verdict:     verdict,
+			DurationSec: durationSec,
+			RecordedAt:  windowEnd.Add(-time.Duration(i+1) * time.Minute),
+		})
+	}
+}
+
+const week = 7 * 24 * time.Hour
+
+// seedWindow gives every question its usual outcomes in the window ending at
+// windowEnd. Easy 2 is slow and rarely finished compared with the other Easy
+// questions, Hard 4 is finished by everyone and quickly.
+func (s *memStore) seedWindow(windowEnd time.Time, slowEasy bool) {
+	s.seed(1, windowEnd, 28, models.OutcomeCompleted, models.VerdictPassed, 300)
+	s.seed(1, windowEnd, 2, models.OutcomeAbandoned, "", 1800)
+
+	if slowEasy {
+		s.seed(2, windowEnd, 10, models.OutcomeCompleted, models.VerdictPassed, 1300)
+		s.seed(2, windowEnd, 10, models.OutcomeAbandoned, "", 1800)
+		s.seed(2, windowEnd, 10, models.OutcomeRerolled, "", 240)
+	} else {
+		s.seed(2, windowEnd, 28, models.OutcomeCompleted, "", 300)
+		s.seed(2, windowEnd, 2, models.OutcomeCompleted, models.VerdictFailed, 1800)
+	}
+
+	// as slow as Easy 2 but below the sample floor
+	s.seed(3, windowEnd, 2, models.OutcomeCompleted, models.VerdictPassed, 1500)
+	s.seed(3, windowEnd, 8, models.OutcomeAbandoned, "", 1800)
+
+	s.seed(4, windowEnd, 25, models.OutcomeCompleted, models.VerdictPassed, 300)
+	s.seed(5, windowEnd, 10, models.OutcomeCompleted, models.VerdictPassed, 2400)
+	s.seed(5, windowEnd, 15, models.OutcomeAbandoned, "", 2700)
+}
+
+func newCalibrationStore() *memStore {
+	return newMemStore(
+		models.Question{ID: 1, Title: "Two Sum", Difficulty: models.Easy},
+		models.Question{ID: 2, Title: "Valid Anagram", Difficulty: models.Easy},
+		models.Question{ID: 3, Title: "Climbing Stairs", Difficulty: models.Easy},
+		models.Question{ID: 4, Title: "Trapping Rain Water", Difficulty: models.Hard},
+		models.Question{ID: 5, Title: "Median of Two Arrays", Difficulty: models.Hard},
+	)
+}
+
+func newTestJob(store Store, now *time.Time) *Job {
+	job := NewJob(store, DefaultConfig(), nil)
+	job.now = func() time.Time { return *now }
+	return job
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4}
+	for _, tc := range []struct {
+		p, want float64
+	}{{0, 1}, {0.5, 2.5}, {0.9, 3.7}, {1, 4}} {
+		if got := Percentile(values, tc.p); math.Abs(got-tc.want) > 1e-9 {
+			t.Fatalf("Percentile(%v) = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if got := Percentile(nil, 0.5); got != 0 {
+		t.Fatalf("expected 0 for no values, got %v", got)
+	}
+
+	ranks := []float64{1, 2, 2, 3}
+	for _, tc := range []struct {
+		x, want float64
+	}{{0, 0}, {1, 0.125}, {2, 0.5}, {2.5, 0.75}, {3, 0.875}, {9, 1}} {
+		if got := PercentileRank(ranks, tc.x); got != tc.want {
+			t.Fatalf("PercentileRank(%v) = %v, want %v", tc.x, got, tc.want)
+		}
+	}
+}
+
+func TestCalibrateMetrics(t *testing.T) {
+	store := newCalibrationStore()
+	windowEnd := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	store.seedWindow(windowEnd, true)
+	questions, _ := store.GetAll()
+	results, _ := store.GetSessionResults(windowEnd.Add(-week), windowEnd)
+
+	cals := Calibrate(questions, results, windowEnd, DefaultConfig())
+
+	// Easy corpus: 40 solves (28 x 300s, 10 x 1300s, 2 x 1500s) in 70 sessions
+	slow := cals[2]
+	if slow.SampleSize != 30 || slow.MedianSolveSec != 1300 {
+		t.Fatalf("unexpected metrics for question 2: %+v", slow)
+	}
+	if slow.SolvePercentile != (28+5)/40.0 {
+		t.Fatalf("expected the median to rank at 0.825, got %v", slow.SolvePercentile)
+	}
+	if math.Abs(slow.CompletionRate-10/30.0) > 1e-9 || math.Abs(slow.CorpusCompletionRate-40/70.0) > 1e-9 {
+		t.Fatalf("unexpected completion rates: %+v", slow)
+	}
+	if slow.Candidate != models.Medium || slow.SuggestedDifficulty != models.Easy {
+		t.Fatalf("one window must not move the suggestion: %+v", slow)
+	}
+
+	if c := cals[1]; c.Candidate != models.Easy || c.SolvePercentile != 14/40.0 {
+		t.Fatalf("question 1 already sits at the bottom of Easy: %+v", c)
+	}
+	if c := cals[3]; !c.InsufficientData || c.Candidate != models.Easy || c.Confidence != 0 {
+		t.Fatalf("question 3 is below the sample floor: %+v", c)
+	}
+	// finished by everyone against 70% for Hard, but only in the 36th percentile
+	if c := cals[4]; c.Candidate != models.Medium || c.CorpusCompletionRate != 0.7 {
+		t.Fatalf("expected question 4 to point at Medium: %+v", c)
+	}
+	if c := cals[5]; c.Candidate != models.Hard {
+		t.Fatalf("Hard has no harder difficulty: %+v", c)
+	}
+}
+
+func TestJobHysteresis(t *testing.T) {
+	store := newCalibrationStore()
+	w1 := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC) // a multiple of the week window
+	now := w1.Add(time.Hour)
+	job := newTestJob(store, &now)
+
+	store.seedWindow(w1, true)
+	if n, err := job.RunOnce(); err != nil || n != 5 {
+		t.Fatalf("expected 5 questions calibrated, got %d, %v", n, err)
+	}
+	if report := fetchReport(t, store); report.Total != 0 {
+		t.Fatalf("nothing is flagged after one window: %+v", report)
+	}
+	if n, _ := job.RunOnce(); n != 0 {
+		t.Fatalf("a window must only be evaluated once, wrote %d", n)
+	}
+
+	w2 := w1.Add(week)
+	now = w2.Add(time.Minute)
+	store.seedWindow(w2, true)
+	if _, err := job.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	report := fetchReport(t, store)
+	if report.Total != 2 || report.Items[0].ID != 2 || report.Items[1].ID != 4 {
+		t.Fatalf("expected questions 2 and 4 flagged, got %+v", report)
+	}
+	if c := report.Items[0].Calibration; c.SuggestedDifficulty != models.Medium || c.Confidence != 0.3 || c.SampleSize != 30 {
+		t.Fatalf("unexpected calibration of question 2: %+v", c)
+	}
+	if c := report.Items[1].Calibration; c.SuggestedDifficulty != models.Medium || c.Confidence != 0.19 {
+		t.Fatalf("unexpected calibration of question 4: %+v", c)
+	}
+	if q := store.questions[2]; q.Difficulty != models.Easy {
+		t.Fatalf("the authored difficulty must not change, got %s", q.Difficulty)
+	}
+	if c := store.questions[3].Calibration; !c.InsufficientData || c.SuggestedDifficulty != models.Easy {
+		t.Fatalf("question 3 must never be flagged: %+v", c)
+	}
+
+	// one normal window keeps the suggestion, a second one clears it
+	w3 := w2.Add(week)
+	now = w3
+	store.seedWindow(w3, false)
+	job.RunOnce()
+	if c := store.questions[2].Calibration; c.SuggestedDifficulty != models.Medium || c.Candidate != models.Easy || c.Confidence != 0.15 {
+		t.Fatalf("expected the suggestion to hold for a window: %+v", c)
+	}
+	w4 := w3.Add(week)
+	now = w4
+	store.seedWindow(w4, false)
+	job.RunOnce()
+	if c := store.questions[2].Calibration; c.SuggestedDifficulty != models.Easy {
+		t.Fatalf("expected the suggestion to clear: %+v", c)
+	}
+
+	// a skipped window starts the count over
+	w6 := w4.Add(2 * week)
+	now = w6
+	store.seedWindow(w6, true)
+	job.RunOnce()
+	if c := store.questions[4].Calibration; c.SuggestedDifficulty != models.Hard || c.Candidate != models.Medium {
+		t.Fatalf("windows around a gap are not consecutive: %+v", c)
+	}
+}
+
+func TestSampleFloor(t *testing.T) {
+	store := newMemStore(
+		models.Question{ID: 1, Difficulty: models.Medium},
+		models.Question{ID: 2, Difficulty: models.Medium},
+	)
+	w1 := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	now := w1
+	job := newTestJob(store, &now)
+	for i := 0; i < 3; i++ {
+		end := w1.Add(time.Duration(i) * week)
+		now = end
+		store.seed(1, end, 19, models.OutcomeRerolled, "", 60)
+		store.seed(2, end, 40, models.OutcomeCompleted, "", 600)
+		job.RunOnce()
+	}
+	c := store.questions[1].Calibration
+	if !c.InsufficientData || c.SampleSize != 19 || c.SuggestedDifficulty != models.Medium || c.Badge(models.Medium) != models.BadgeInsufficientData {
+		t.Fatalf("19 sessions must never be flagged: %+v", c)
+	}
+
+	job.cfg.MinSamples = 19
+	now = now.Add(week)
+	store.seed(1, now, 19, models.OutcomeRerolled, "", 60)
+	store.seed(2, now, 40, models.OutcomeCompleted, "", 600)
+	job.RunOnce()
+	if c := store.questions[1].Calibration; c.InsufficientData || c.Candidate != models.Hard {
+		t.Fatalf("expected question 1 to count once the floor is met: %+v", c)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	cfg, err := ConfigFromEnv()
+	if err != nil || cfg != DefaultConfig() {
+		t.Fatalf("expected defaults, got %+v, %v", cfg, err)
+	}
+
+	t.Setenv("CALIBRATION_WINDOW", "336h")
+	t.Setenv("CALIBRATION_MIN_SAMPLES", "50")
+	t.Setenv("CALIBRATION_SLOW_PERCENTILE", "0.9")
+	cfg, err = ConfigFromEnv()
+	if err != nil || cfg.Window != 2*week || cfg.MinSamples != 50 || cfg.SlowPercentile != 0.9 {
+		t.Fatalf("unexpected config: %+v, %v", cfg, err)
+	}
+
+	t.Setenv("CALIBRATION_FAST_PERCENTILE", "0.95")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatalf("expected overlapping percentiles to be rejected")
+	}
+	t.Setenv("CALIBRATION_FAST_PERCENTILE", "")
+	t.Setenv("CALIBRATION_MIN_SAMPLES", "0")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatalf("expected a zero sample floor to be rejected")
+	}
+}
+
+func fetchReport(t *testing.T, store *memStore) models.CalibrationReportResponse {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handlers.NewCalibrationHandler(store).CalibrationReportHandler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/questions/calibration/report", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("report returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var report models.CalibrationReportResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	return report
+}
@@ -0,0 +1,103 @@
+package calibration
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"peerprep/question/internal/models"
+)
+
+// how often the job checks whether a window has completed
+const checkInterval = time.Hour
+
+// Store is the part of the question repository the job needs
+type Store interface {
+	GetAll() ([]models.Question, error)
+	GetSessionResults(from, to time.Time) ([]models.SessionResult, error)
+	SetCalibration(id int, cal *models.Calibration) error
+}
+
+// Job calibrates all questions once per completed window. Windows are
+// aligned to multiples of Config.Window, so restarts neither skip nor
+// repeat one.
+type Job struct {
+	store  Store
+	cfg    Config
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+func NewJob(store Store, cfg Config, logger *zap.Logger) *Job {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Job{store: store, cfg: cfg, logger: logger, now: time.Now}
+}
+
+// Config returns the job's thresholds
+func (j *Job) Config() Config { return j.cfg }
+
+// Start runs the job until ctx is done
+func (j *Job) Start(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.RunOnce(); err != nil {
+			j.logger.Error("question calibration failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce calibrates the questions not yet evaluated for the last completed
+// window and returns how many were written
+func (j *Job) RunOnce() (int, error) {
+	windowEnd := j.now().UTC().Truncate(j.cfg.Window)
+	windowStart := windowEnd.Add(-j.cfg.Window)
+
+	questions, err := j.store.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	pending := false
+	for _, q := range questions {
+		if q.Calibration == nil || q.Calibration.WindowEnd.Before(windowEnd) {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return 0, nil
+	}
+
+	results, err := j.store.GetSessionResults(windowStart, windowEnd)
+	if err != nil {
+		return 0, err
+	}
+	computedAt := j.now().UTC()
+	written := 0
+	calibrations := Calibrate(questions, results, windowEnd, j.cfg)
+	for _, q := range questions {
+		cal, ok := calibrations[q.ID]
+		if !ok || (q.Calibration != nil && !q.Calibration.WindowEnd.Before(windowEnd)) {
+			continue
+		}
+		cal.ComputedAt = computedAt
+		if err := j.store.SetCalibration(q.ID, &cal); err != nil {
+			return written, err
+		}
+		written++
+	}
+	j.logger.Info("calibrated questions",
+		zap.Time("window_end", windowEnd),
+		zap.Int("questions", written),
+		zap.Int("results", len(results)),
+	)
+	return written, nil
+}
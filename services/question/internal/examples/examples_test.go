@@ -0,0 +1,147 @@
+package examples_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"peerprep/question/internal/examples"
+	"peerprep/question/internal/models"
+)
+
+func twoSumish() *models.Question {
+	return &models.Question{
+		ID: 7,
+		ExampleGenerator: &models.ExampleGenerator{
+			Count: 3,
+			Params: []models.ExampleParam{
+				{Name: "nums", Type: models.ParamIntArray, Min: 1, Max: 100, MinLength: 5, MaxLength: 10},
+				{Name: "k", Type: models.ParamInt, Min: 1, Max: 5},
+			},
+			Output: "sum(nums) % k == 0 ? max(nums) : sorted(nums)[nums.length - 1] - min(nums) * k",
+		},
+		TestCases: []models.TestCase{{Input: "static", Output: "static"}},
+	}
+}
+
+func TestApplyDeterministicPerSeed(t *testing.T) {
+	a, b := twoSumish(), twoSumish()
+	if err := examples.Apply(a, "match-1"); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	if err := examples.Apply(b, "match-1"); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	if !reflect.DeepEqual(a.TestCases, b.TestCases) {
+		t.Fatalf("same seed generated different examples:\n%+v\n%+v", a.TestCases, b.TestCases)
+	}
+	if len(a.TestCases) != 3 || !strings.HasPrefix(a.TestCases[0].Input, "nums = [") || !strings.Contains(a.TestCases[0].Input, "\nk = ") {
+		t.Fatalf("unexpected examples: %+v", a.TestCases)
+	}
+}
+
+func TestApplyVariesAcrossSeeds(t *testing.T) {
+	base := twoSumish()
+	if err := examples.Apply(base, "match-1"); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	for _, seed := range []string{"match-2", "match-3", "match-4"} {
+		q := twoSumish()
+		if err := examples.Apply(q, seed); err != nil {
+			t.Fatalf("Apply error: %v", err)
+		}
+		if !reflect.DeepEqual(q.TestCases, base.TestCases) {
+			return
+		}
+	}
+	t.Fatal("every seed generated the same examples")
+}
+
+func TestApplyEvaluatesOutput(t *testing.T) {
+	q := &models.Question{ExampleGenerator: &models.ExampleGenerator{
+		Count:  1,
+		Params: []models.ExampleParam{{Name: "n", Type: models.ParamInt, Min: 6, Max: 6}},
+		Output: "Math.max(n * 2 - 1, 3) / 2",
+	}}
+	if err := examples.Apply(q, "s"); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	if got := q.TestCases[0]; got.Input != "n = 6" || got.Output != "5.5" {
+		t.Fatalf("unexpected example: %+v", got)
+	}
+}
+
+func TestApplyShufflesStaticExamples(t *testing.T) {
+	static := make([]models.TestCase, 8)
+	for i := range static {
+		static[i] = models.TestCase{Input: string(rune('a' + i))}
+	}
+	q := &models.Question{ShuffleExamples: true, TestCases: append([]models.TestCase(nil), static...)}
+	if err := examples.Apply(q, "match-1"); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	if len(q.TestCases) != len(static) || reflect.DeepEqual(q.TestCases, static) {
+		t.Fatalf("examples not shuffled: %+v", q.TestCases)
+	}
+}
+
+func TestApplyFailureLeavesQuestionUntouched(t *testing.T) {
+	q := &models.Question{
+		ExampleGenerator: &models.ExampleGenerator{
+			Count:  2,
+			Params: []models.ExampleParam{{Name: "n", Type: models.ParamInt, Min: 0, Max: 0}},
+			Output: "10 / n",
+		},
+		TestCases: []models.TestCase{{Input: "static", Output: "static"}},
+	}
+	if err := examples.Apply(q, "match-1"); err == nil {
+		t.Fatal("expected division by zero error")
+	}
+	if len(q.TestCases) != 1 || q.TestCases[0].Input != "static" {
+		t.Fatalf("static examples modified: %+v", q.TestCases)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if details := examples.Validate(twoSumish().ExampleGenerator); len(details) != 0 {
+		t.Fatalf("expected valid generator, got %+v", details)
+	}
+	if details := examples.Validate(nil); details != nil {
+		t.Fatalf("expected nil generator to be valid, got %+v", details)
+	}
+
+	param := func(name string) []models.ExampleParam {
+		return []models.ExampleParam{{Name: name, Type: models.ParamInt, Min: 1, Max: 10}}
+	}
+	tests := []struct {
+		name  string
+		gen   models.ExampleGenerator
+		field string
+	}{
+		{"unparsable output", models.ExampleGenerator{Count: 1, Params: param("n"), Output: "n +"}, "exampleGenerator.output"},
+		{"unknown identifier", models.ExampleGenerator{Count: 1, Params: param("n"), Output: "m * 2"}, "exampleGenerator.output"},
+		{"unknown function", models.ExampleGenerator{Count: 1, Params: param("n"), Output: "eval(n)"}, "exampleGenerator.output"},
+		{"always fails", models.ExampleGenerator{Count: 1, Params: param("n"), Output: "n / (n - n)"}, "exampleGenerator.output"},
+		{"type error", models.ExampleGenerator{Count: 1, Params: param("n"), Output: "n[0]"}, "exampleGenerator.output"},
+		{"too many examples", models.ExampleGenerator{Count: 11, Params: param("n"), Output: "n"}, "exampleGenerator.count"},
+		{"no params", models.ExampleGenerator{Count: 1, Output: "1"}, "exampleGenerator.params"},
+		{"reserved name", models.ExampleGenerator{Count: 1, Params: param("Math"), Output: "1"}, "exampleGenerator.params[0].name"},
+		{"inverted range", models.ExampleGenerator{Count: 1, Params: []models.ExampleParam{
+			{Name: "n", Type: models.ParamInt, Min: 10, Max: 1},
+		}, Output: "n"}, "exampleGenerator.params[0].min"},
+		{"huge array", models.ExampleGenerator{Count: 1, Params: []models.ExampleParam{
+			{Name: "a", Type: models.ParamIntArray, Min: 1, Max: 10, MinLength: 1, MaxLength: 100000},
+		}, Output: "sum(a)"}, "exampleGenerator.params[0].minLength"},
+		{"unknown type", models.ExampleGenerator{Count: 1, Params: []models.ExampleParam{
+			{Name: "s", Type: "string", Min: 1, Max: 10},
+		}, Output: "1"}, "exampleGenerator.params[0].type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := examples.Validate(&tt.gen)
+			if len(details) == 0 || details[0].Field != tt.field {
+				t.Fatalf("expected error on %s, got %+v", tt.field, details)
+			}
+		})
+	}
+}
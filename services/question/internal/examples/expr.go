@@ -0,0 +1,652 @@
+package examples
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The output expression language is a small, side-effect free subset of
+// JavaScript expressions:
+//
+//	literals     42, 1.5, true, false
+//	parameters   nums, target
+//	arrays       nums[i], nums.length
+//	operators    unary - !, * / %, + -, < <= > >=, == != === !==, && ||, c ? a : b
+//	functions    sum(a) min(a) max(a) count(a, x) sorted(a) reversed(a) abs(x) floor(x)
+//	             Math.min(x, ...) Math.max(x, ...) Math.abs(x) Math.floor(x)
+//
+// Values are numbers (float64), booleans and arrays of numbers. There are no
+// loops or user-defined functions, and evaluation is metered by a step budget,
+// so every evaluation terminates.
+
+const (
+	maxExprLength = 500
+	evalBudget    = 100000
+)
+
+var (
+	errBudget  = errors.New("expression exceeds evaluation budget")
+	errDivZero = errors.New("division by zero")
+)
+
+type expr interface {
+	eval(env map[string]any, budget *int) (any, error)
+}
+
+// parseExpr parses src, allowing only the given parameter names.
+func parseExpr(src string, params map[string]bool) (expr, error) {
+	if len(src) > maxExprLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxExprLength)
+	}
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, params: params}
+	e, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	return e, nil
+}
+
+func evalExpr(e expr, env map[string]any) (any, error) {
+	budget := evalBudget
+	return e.eval(env, &budget)
+}
+
+// --- Lexer ---
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	num  float64
+}
+
+// operators, longest first so "===" wins over "=="
+var operators = []string{"===", "!==", "==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", "[", "]", ",", "."}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", src[i:j])
+			}
+			toks = append(toks, token{kind: tokNum, text: src[i:j], num: n})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, token{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// --- Parser ---
+
+type parser struct {
+	toks   []token
+	pos    int
+	params map[string]bool
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return fmt.Errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (p *parser) ternary() (expr, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond, then, otherwise}, nil
+}
+
+// binary operator precedence levels, loosest first
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"===", "!==", "==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(precedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op, left, right}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	if op, ok := p.accept("-", "!"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op, operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("["); ok {
+			index, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{e, index}
+			continue
+		}
+		if _, ok := p.accept("."); ok {
+			if t := p.next(); t.kind != tokIdent || t.text != "length" {
+				return nil, errors.New("only .length is supported")
+			}
+			e = &callExpr{"len", []expr{e}}
+			continue
+		}
+		return e, nil
+	}
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		return literal{t.num}, nil
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "Math":
+			if err := p.expect("."); err != nil {
+				return nil, err
+			}
+			name := p.next()
+			if _, ok := mathFuncs[name.text]; !ok || name.kind != tokIdent {
+				return nil, fmt.Errorf("unknown function Math.%s", name.text)
+			}
+			return p.call(mathFuncs[name.text])
+		}
+		if _, ok := functions[t.text]; ok {
+			return p.call(t.text)
+		}
+		if !p.params[t.text] {
+			return nil, fmt.Errorf("unknown identifier %q", t.text)
+		}
+		return ident(t.text), nil
+	case tokEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *parser) call(name string) (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	if _, ok := p.accept(")"); ok {
+		return &callExpr{name, args}, nil
+	}
+	for {
+		arg, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(")"); ok {
+			return &callExpr{name, args}, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// --- Evaluation ---
+
+type literal struct{ v any }
+
+func (l literal) eval(map[string]any, *int) (any, error) { return l.v, nil }
+
+type ident string
+
+func (i ident) eval(env map[string]any, budget *int) (any, error) {
+	if err := spend(budget, 1); err != nil {
+		return nil, err
+	}
+	v, ok := env[string(i)]
+	if !ok {
+		return nil, fmt.Errorf("unknown identifier %q", string(i))
+	}
+	return v, nil
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+func (u *unaryExpr) eval(env map[string]any, budget *int) (any, error) {
+	v, err := u.operand.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "!" {
+		b, err := asBool(v)
+		return !b, err
+	}
+	n, err := asNum(v)
+	return -n, err
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (b *binaryExpr) eval(env map[string]any, budget *int) (any, error) {
+	if err := spend(budget, 1); err != nil {
+		return nil, err
+	}
+	lv, err := b.left.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuit logic
+	if b.op == "&&" || b.op == "||" {
+		l, err := asBool(lv)
+		if err != nil {
+			return nil, err
+		}
+		if (b.op == "&&") != l {
+			return l, nil
+		}
+		rv, err := b.right.eval(env, budget)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(rv)
+	}
+
+	rv, err := b.right.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==", "===":
+		return equal(lv, rv)
+	case "!=", "!==":
+		eq, err := equal(lv, rv)
+		return !eq, err
+	}
+
+	l, err := asNum(lv)
+	if err != nil {
+		return nil, err
+	}
+	r, err := asNum(rv)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errDivZero
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errDivZero
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", b.op)
+}
+
+type condExpr struct {
+	cond, then, otherwise expr
+}
+
+func (c *condExpr) eval(env map[string]any, budget *int) (any, error) {
+	v, err := c.cond.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+	b, err := asBool(v)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return c.then.eval(env, budget)
+	}
+	return c.otherwise.eval(env, budget)
+}
+
+type indexExpr struct {
+	array, index expr
+}
+
+func (ix *indexExpr) eval(env map[string]any, budget *int) (any, error) {
+	av, err := ix.array.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+	arr, err := asArray(av)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := ix.index.eval(env, budget)
+	if err != nil {
+		return nil, err
+	}
+	i, err := asNum(iv)
+	if err != nil {
+		return nil, err
+	}
+	if i != math.Trunc(i) || i < 0 || int(i) >= len(arr) {
+		return nil, fmt.Errorf("index %v out of range", i)
+	}
+	return arr[int(i)], nil
+}
+
+// functions maps each function name to the number of arguments it takes;
+// -1 means one or more numbers
+var functions = map[string]int{
+	"len": 1, "sum": 1, "min": -1, "max": -1, "count": 2,
+	"sorted": 1, "reversed": 1, "abs": 1, "floor": 1,
+}
+
+var mathFuncs = map[string]string{"min": "min", "max": "max", "abs": "abs", "floor": "floor"}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+func (c *callExpr) eval(env map[string]any, budget *int) (any, error) {
+	if arity := functions[c.name]; (arity >= 0 && len(c.args) != arity) || len(c.args) == 0 {
+		return nil, fmt.Errorf("%s: wrong number of arguments", c.name)
+	}
+	args := make([]any, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(env, budget)
+		if err != nil {
+			return nil, err
+		}
+		if arr, ok := v.([]float64); ok {
+			if err := spend(budget, len(arr)); err != nil {
+				return nil, err
+			}
+		}
+		args[i] = v
+	}
+
+	switch c.name {
+	case "len":
+		arr, err := asArray(args[0])
+		return float64(len(arr)), err
+	case "sum":
+		arr, err := asArray(args[0])
+		total := 0.0
+		for _, n := range arr {
+			total += n
+		}
+		return total, err
+	case "min", "max":
+		nums, err := numbers(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("%s of empty array", c.name)
+		}
+		best := nums[0]
+		for _, n := range nums[1:] {
+			if (c.name == "min" && n < best) || (c.name == "max" && n > best) {
+				best = n
+			}
+		}
+		return best, nil
+	case "count":
+		arr, err := asArray(args[0])
+		if err != nil {
+			return nil, err
+		}
+		x, err := asNum(args[1])
+		n := 0.0
+		for _, v := range arr {
+			if v == x {
+				n++
+			}
+		}
+		return n, err
+	case "sorted":
+		arr, err := asArray(args[0])
+		out := append([]float64(nil), arr...)
+		sort.Float64s(out)
+		return out, err
+	case "reversed":
+		arr, err := asArray(args[0])
+		out := make([]float64, len(arr))
+		for i, v := range arr {
+			out[len(arr)-1-i] = v
+		}
+		return out, err
+	case "abs":
+		n, err := asNum(args[0])
+		return math.Abs(n), err
+	case "floor":
+		n, err := asNum(args[0])
+		return math.Floor(n), err
+	}
+	return nil, fmt.Errorf("unknown function %q", c.name)
+}
+
+// numbers flattens min/max arguments: a single array, or one or more numbers
+func numbers(args []any) ([]float64, error) {
+	if len(args) == 1 {
+		if arr, ok := args[0].([]float64); ok {
+			return arr, nil
+		}
+	}
+	nums := make([]float64, len(args))
+	for i, a := range args {
+		n, err := asNum(a)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+func spend(budget *int, n int) error {
+	*budget -= n
+	if *budget < 0 {
+		return errBudget
+	}
+	return nil
+}
+
+func asNum(v any) (float64, error) {
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %s", typeName(v))
+	}
+	return n, nil
+}
+
+func asBool(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+func asArray(v any) ([]float64, error) {
+	arr, ok := v.([]float64)
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %s", typeName(v))
+	}
+	return arr, nil
+}
+
+func equal(a, b any) (bool, error) {
+	switch av := a.(type) {
+	case float64:
+		bv, err := asNum(b)
+		return av == bv, err
+	case bool:
+		bv, err := asBool(b)
+		return av == bv, err
+	}
+	return false, fmt.Errorf("cannot compare %s", typeName(a))
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []float64:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
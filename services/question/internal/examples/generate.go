@@ -0,0 +1,200 @@
+package examples
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"peerprep/question/internal/models"
+)
+
+// bounds on generator definitions, so serving a question stays cheap
+const (
+	MaxCount    = 10
+	MaxParams   = 8
+	MaxAbsValue = 1_000_000_000
+	MaxArrayLen = 1000
+)
+
+const (
+	countField  = "exampleGenerator.count"
+	paramsField = "exampleGenerator.params"
+	outputField = "exampleGenerator.output"
+	dryRunSeeds = 3
+)
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// names the expression language claims for itself
+var reserved = map[string]bool{"true": true, "false": true, "Math": true, "length": true}
+
+// Validate checks the generator definition and returns one detail per invalid field
+// a definition that validates is parsed and dry-run generated, so it is
+// expected to generate at serve time too
+func Validate(gen *models.ExampleGenerator) []models.ValidationErrorDetail {
+	if gen == nil {
+		return nil
+	}
+
+	var details []models.ValidationErrorDetail
+	fail := func(field, reason string) {
+		details = append(details, models.ValidationErrorDetail{Field: field, Reason: reason})
+	}
+
+	if gen.Count < 1 || gen.Count > MaxCount {
+		fail(countField, fmt.Sprintf("must be between 1 and %d", MaxCount))
+	}
+	if len(gen.Params) == 0 || len(gen.Params) > MaxParams {
+		fail(paramsField, fmt.Sprintf("must have between 1 and %d params", MaxParams))
+	}
+
+	names := make(map[string]bool, len(gen.Params))
+	for i, p := range gen.Params {
+		field := fmt.Sprintf("%s[%d]", paramsField, i)
+		switch {
+		case !identPattern.MatchString(p.Name) || reserved[p.Name] || isFunction(p.Name):
+			fail(field+".name", "must be an identifier that is not a reserved word or function name")
+		case names[p.Name]:
+			fail(field+".name", "duplicate param: "+p.Name)
+		}
+		names[p.Name] = true
+
+		if p.Type != models.ParamInt && p.Type != models.ParamIntArray {
+			fail(field+".type", "must be one of: int, int_array")
+		}
+		if p.Min > p.Max {
+			fail(field+".min", "must not exceed max")
+		}
+		if p.Min < -MaxAbsValue || p.Max > MaxAbsValue {
+			fail(field, fmt.Sprintf("values must be between %d and %d", -MaxAbsValue, MaxAbsValue))
+		}
+		if p.Type == models.ParamIntArray && (p.MinLength < 0 || p.MinLength > p.MaxLength || p.MaxLength > MaxArrayLen) {
+			fail(field+".minLength", fmt.Sprintf("must satisfy 0 <= minLength <= maxLength <= %d", MaxArrayLen))
+		}
+	}
+
+	e, err := parseExpr(gen.Output, names)
+	if err != nil {
+		fail(outputField, err.Error())
+	}
+	if len(details) > 0 {
+		return details
+	}
+
+	// generation has to succeed for arbitrary seeds; try a few
+	for i := 0; i < dryRunSeeds; i++ {
+		if _, err := generate(gen, e, newRand("dry-run-"+strconv.Itoa(i))); err != nil {
+			fail(outputField, "generation failed: "+err.Error())
+			break
+		}
+	}
+	return details
+}
+
+// Apply randomizes the question's examples for the given seed: generated
+// examples replace the static ones, then ShuffleExamples shuffles them
+// the same seed and question always produce the same examples; on error the
+// question is left untouched
+func Apply(q *models.Question, seed string) error {
+	if q.ExampleGenerator == nil && !q.ShuffleExamples {
+		return nil
+	}
+
+	rng := newRand(seed + ":" + strconv.Itoa(q.ID))
+	cases := q.TestCases
+	if gen := q.ExampleGenerator; gen != nil {
+		names := make(map[string]bool, len(gen.Params))
+		for _, p := range gen.Params {
+			names[p.Name] = true
+		}
+		e, err := parseExpr(gen.Output, names)
+		if err != nil {
+			return fmt.Errorf("parse output expression: %w", err)
+		}
+		if cases, err = generate(gen, e, rng); err != nil {
+			return err
+		}
+	}
+
+	if q.ShuffleExamples {
+		cases = append([]models.TestCase(nil), cases...)
+		rng.Shuffle(len(cases), func(i, j int) { cases[i], cases[j] = cases[j], cases[i] })
+	}
+	q.TestCases = cases
+	return nil
+}
+
+func newRand(seed string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+func generate(gen *models.ExampleGenerator, e expr, rng *rand.Rand) ([]models.TestCase, error) {
+	cases := make([]models.TestCase, 0, gen.Count)
+	for i := 0; i < gen.Count; i++ {
+		env := make(map[string]any, len(gen.Params))
+		lines := make([]string, 0, len(gen.Params))
+		for _, p := range gen.Params {
+			v := randomValue(p, rng)
+			env[p.Name] = v
+			lines = append(lines, p.Name+" = "+render(v))
+		}
+
+		out, err := evalExpr(e, env)
+		if err != nil {
+			return nil, fmt.Errorf("example %d: %w", i+1, err)
+		}
+		cases = append(cases, models.TestCase{
+			Input:  strings.Join(lines, "\n"),
+			Output: render(out),
+		})
+	}
+	return cases, nil
+}
+
+func randomValue(p models.ExampleParam, rng *rand.Rand) any {
+	if p.Type == models.ParamInt {
+		return randomInt(p.Min, p.Max, rng)
+	}
+	n := p.MinLength + rng.Intn(p.MaxLength-p.MinLength+1)
+	arr := make([]float64, n)
+	for i := range arr {
+		arr[i] = randomInt(p.Min, p.Max, rng)
+	}
+	return arr
+}
+
+func randomInt(min, max int64, rng *rand.Rand) float64 {
+	return float64(min + rng.Int63n(max-min+1))
+}
+
+// render formats a value the way the examples show it: integers without a
+// decimal point and arrays as [1, 2, 3]
+func render(v any) string {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []float64:
+		parts := make([]string, len(v))
+		for i, n := range v {
+			parts[i] = render(n)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func isFunction(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"peerprep/question/internal/models"
+	"peerprep/question/internal/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CalibrationRepo interface {
+	GetAll() ([]models.Question, error)
+	GetByID(int) (*models.Question, error)
+	RecordSessionResult(*models.SessionResult) error
+}
+
+// CalibrationHandler collects session results and reports the questions
+// whose results suggest another difficulty (see package calibration)
+type CalibrationHandler struct {
+	repo CalibrationRepo
+	now  func() time.Time
+}
+
+func NewCalibrationHandler(r CalibrationRepo) *CalibrationHandler {
+	return &CalibrationHandler{repo: r, now: time.Now}
+}
+
+// RecordSessionResultHandler stores how a session with the question ended
+func (handler *CalibrationHandler) RecordSessionResultHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_id",
+			Message: "Invalid question ID",
+		})
+		return
+	}
+
+	var result models.SessionResult
+	if err := json.NewDecoder(request.Body).Decode(&result); err != nil {
+		utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request payload",
+		})
+		return
+	}
+	if details := result.Validate(); len(details) > 0 {
+		utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+			Code:    "validation_failed",
+			Message: "Invalid session result",
+			Details: details,
+		})
+		return
+	}
+
+	if _, err := handler.repo.GetByID(id); err != nil {
+		utils.JSON(writer, http.StatusNotFound, models.ErrorResponse{
+			Code:    "question_not_found",
+			Message: "Question not found",
+		})
+		return
+	}
+
+	result.QuestionID = id
+	result.RecordedAt = handler.now().UTC()
+	if err := handler.repo.RecordSessionResult(&result); err != nil {
+		utils.JSON(writer, http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "internal_error",
+			Message: "Failed to record session result",
+		})
+		return
+	}
+	utils.JSON(writer, http.StatusCreated, result)
+}
+
+// CalibrationReportHandler lists the questions whose suggested difficulty
+// differs from their label, most confident first
+func (handler *CalibrationHandler) CalibrationReportHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	questions, err := handler.repo.GetAll()
+	if err != nil {
+		utils.JSON(writer, http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "internal_error",
+			Message: "Failed to fetch questions",
+		})
+		return
+	}
+
+	items := []models.CalibrationReportItem{}
+	for _, q := range questions {
+		cal := q.Calibration
+		if cal == nil || cal.InsufficientData || cal.SuggestedDifficulty == q.Difficulty {
+			continue
+		}
+		items = append(items, models.CalibrationReportItem{
+			ID:          q.ID,
+			Title:       q.Title,
+			Difficulty:  q.Difficulty,
+			Calibration: *cal,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Calibration.Confidence != items[j].Calibration.Confidence {
+			return items[i].Calibration.Confidence > items[j].Calibration.Confidence
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	utils.JSON(writer, http.StatusOK, models.CalibrationReportResponse{Total: len(items), Items: items})
+}
@@ -0,0 +1,123 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"peerprep/question/internal/auth"
+	"peerprep/question/internal/handlers"
+	"peerprep/question/internal/models"
+)
+
+type fakeCalibrationRepo struct {
+	fakeRepo
+	recorded []models.SessionResult
+}
+
+func (f *fakeCalibrationRepo) RecordSessionResult(r *models.SessionResult) error {
+	f.recorded = append(f.recorded, *r)
+	return nil
+}
+
+// POST /questions/{id}/session-results
+func TestRecordSessionResult(t *testing.T) {
+	repo := &fakeCalibrationRepo{fakeRepo: fakeRepo{
+		getByIDFn: func(id int) (*models.Question, error) {
+			if id != 7 {
+				return nil, errors.New("question not found")
+			}
+			return &models.Question{ID: 7, Difficulty: models.Easy}, nil
+		},
+	}}
+	h := handlers.NewCalibrationHandler(repo)
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions/{id}/session-results", h.RecordSessionResultHandler)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("/api/v1/questions/7/session-results", `{"matchId":"m1","outcome":"completed","verdict":"passed","durationSec":640,"questionId":99}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.recorded) != 1 {
+		t.Fatalf("expected one result recorded, got %d", len(repo.recorded))
+	}
+	got := repo.recorded[0]
+	if got.QuestionID != 7 || got.MatchID != "m1" || got.DurationSec != 640 || !got.Solved() || time.Since(got.RecordedAt) > time.Minute {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	rr = post("/api/v1/questions/7/session-results", `{"outcome":"gave_up","verdict":"maybe","durationSec":-1}`)
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusBadRequest || len(body.Details) != 3 {
+		t.Fatalf("expected three validation details, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := post("/api/v1/questions/8/session-results", `{"outcome":"rerolled","durationSec":30}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown question, got %d", rr.Code)
+	}
+	if len(repo.recorded) != 1 {
+		t.Fatalf("rejected results must not be recorded")
+	}
+}
+
+// GET /questions lists calibration badges for service keys only
+func TestGetQuestions_CalibrationBadge(t *testing.T) {
+	repo := &fakeRepo{
+		getAllFn: func() ([]models.Question, error) {
+			return []models.Question{
+				{ID: 1, Difficulty: models.Easy, Calibration: &models.Calibration{SuggestedDifficulty: models.Medium}},
+				{ID: 2, Difficulty: models.Hard, Calibration: &models.Calibration{SuggestedDifficulty: models.Hard}},
+				{ID: 3, Difficulty: models.Hard, Calibration: &models.Calibration{SuggestedDifficulty: models.Hard, InsufficientData: true}},
+				{ID: 4, Difficulty: models.Medium},
+			}, nil
+		},
+	}
+	keys, _ := auth.ParseAPIKeys("admin:secret")
+	r := chi.NewRouter()
+	r.With(auth.NewKeyAuth(keys, false, nil).ReadKey).Get("/api/v1/questions", handlers.NewQuestionHandler(repo).GetQuestionsHandler)
+
+	list := func(key string) []map[string]any {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/questions", nil)
+		if key != "" {
+			req.Header.Set(auth.HeaderAPIKey, key)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var got struct {
+			Items []map[string]any `json:"items"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("bad JSON: %v", err)
+		}
+		return got.Items
+	}
+
+	for _, item := range list("") {
+		if _, ok := item["calibrationBadge"]; ok {
+			t.Fatalf("public lists must not carry badges: %v", item)
+		}
+		if _, ok := item["calibration"]; ok {
+			t.Fatalf("calibration must not be served in lists: %v", item)
+		}
+	}
+
+	want := []any{models.BadgeHarder, models.BadgeCalibrated, models.BadgeInsufficientData, nil}
+	for i, item := range list("secret") {
+		if item["calibrationBadge"] != want[i] {
+			t.Fatalf("question %v: expected badge %v, got %v", item["id"], want[i], item["calibrationBadge"])
+		}
+	}
+}
@@ -3,10 +3,14 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"peerprep/question/internal/auth"
+	"peerprep/question/internal/examples"
+	"peerprep/question/internal/markdown"
 	"peerprep/question/internal/models"
 	"peerprep/question/internal/utils"
 
@@ -50,6 +54,8 @@ func (handler *QuestionHandler) GetQuestionsHandler(writer http.ResponseWriter,
 			return
 		}
 
+		addCalibrationBadges(request, questions)
+
 		response := models.QuestionsResponse{
 			Total:      len(questions),
 			Items:      questions,
@@ -120,6 +126,8 @@ func (handler *QuestionHandler) GetQuestionsHandler(writer http.ResponseWriter,
 		return
 	}
 
+	addCalibrationBadges(request, questions)
+
 	// pagination metadata
 	totalPages, hasNext, hasPrev := models.CalculatePaginationMeta(page, limit, total)
 
@@ -148,6 +156,11 @@ func (handler *QuestionHandler) CreateQuestionHandler(writer http.ResponseWriter
 		return
 	}
 
+	if !validateExecution(writer, &question) || !validateExamples(writer, &question) || !validateRubric(writer, &question) {
+		return
+	}
+	sanitizeDescription(&question)
+
 	created, err := handler.repo.Create(&question)
 	if err != nil {
 		utils.JSON(writer, http.StatusInternalServerError, models.ErrorResponse{
@@ -183,6 +196,7 @@ func (handler *QuestionHandler) GetQuestionByIDHandler(writer http.ResponseWrite
 		return
 	}
 
+	randomizeExamples(question, request.URL.Query().Get("seed"))
 	utils.JSON(writer, http.StatusOK, question)
 }
 
@@ -208,6 +222,11 @@ func (handler *QuestionHandler) UpdateQuestionHandler(writer http.ResponseWriter
 		return
 	}
 
+	if !validateExecution(writer, &question) || !validateExamples(writer, &question) || !validateRubric(writer, &question) {
+		return
+	}
+	sanitizeDescription(&question)
+
 	updated, err := handler.repo.Update(id, &question)
 	if err != nil {
 		utils.JSON(writer, http.StatusInternalServerError, models.ErrorResponse{
@@ -220,6 +239,62 @@ func (handler *QuestionHandler) UpdateQuestionHandler(writer http.ResponseWriter
 	utils.JSON(writer, http.StatusOK, updated)
 }
 
+// GetOriginalDescriptionHandler returns a question's description as authored,
+// next to the sanitized one that is normally served
+func (handler *QuestionHandler) GetOriginalDescriptionHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_id",
+			Message: "Invalid question ID",
+		})
+		return
+	}
+
+	question, err := handler.repo.GetByID(id)
+	if err != nil {
+		utils.JSON(writer, http.StatusNotFound, models.ErrorResponse{
+			Code:    "question_not_found",
+			Message: "Question not found",
+		})
+		return
+	}
+
+	original := question.PromptMarkdownOriginal
+	if original == "" {
+		// stored before descriptions were sanitized
+		original = question.PromptMarkdown
+	}
+	utils.JSON(writer, http.StatusOK, models.OriginalDescriptionResponse{
+		ID:                     question.ID,
+		PromptMarkdown:         question.PromptMarkdown,
+		PromptMarkdownOriginal: original,
+	})
+}
+
+// PreviewHandler sanitizes and renders a description without storing anything,
+// so authors can check the formatting before saving
+func (handler *QuestionHandler) PreviewHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	var body models.PreviewRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request payload",
+		})
+		return
+	}
+
+	res := markdown.Sanitize(body.PromptMarkdown)
+	utils.JSON(writer, http.StatusOK, models.PreviewResponse{
+		PromptMarkdown: res.Markdown,
+		HTML:           markdown.RenderHTML(res.Markdown),
+		Escaped:        res.Escaped,
+	})
+}
+
 func (handler *QuestionHandler) DeleteQuestionHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "application/json")
 	idStr := chi.URLParam(request, "id")
@@ -299,5 +374,85 @@ func (handler *QuestionHandler) GetRandomQuestionHandler(writer http.ResponseWri
 		return
 	}
 
+	randomizeExamples(question, request.URL.Query().Get("seed"))
 	utils.JSON(writer, http.StatusOK, question)
 }
+
+// validateExecution rejects questions with malformed execution metadata
+// returns false once an error response has been written
+func validateExecution(writer http.ResponseWriter, question *models.Question) bool {
+	details := question.Execution.Validate()
+	if len(details) == 0 {
+		return true
+	}
+	utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+		Code:    "validation_failed",
+		Message: "Invalid execution metadata",
+		Details: details,
+	})
+	return false
+}
+
+// validateRubric rejects rubrics with too few, too many, empty or overlong items
+// returns false once an error response has been written
+func validateRubric(writer http.ResponseWriter, question *models.Question) bool {
+	details := question.Rubric.Validate()
+	if len(details) == 0 {
+		return true
+	}
+	utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+		Code:    "validation_failed",
+		Message: "Invalid rubric",
+		Details: details,
+	})
+	return false
+}
+
+// validateExamples rejects example generators that do not parse or generate
+// returns false once an error response has been written
+func validateExamples(writer http.ResponseWriter, question *models.Question) bool {
+	details := examples.Validate(question.ExampleGenerator)
+	if len(details) == 0 {
+		return true
+	}
+	utils.JSON(writer, http.StatusBadRequest, models.ErrorResponse{
+		Code:    "validation_failed",
+		Message: "Invalid example generator",
+		Details: details,
+	})
+	return false
+}
+
+// sanitizeDescription keeps the authored description and replaces the served
+// one with its sanitized form
+func sanitizeDescription(question *models.Question) {
+	res := markdown.Sanitize(question.PromptMarkdown)
+	if res.Escaped {
+		log.Printf("sanitizing the description of question %q failed, storing it escaped", question.Title)
+	}
+	question.PromptMarkdownOriginal = question.PromptMarkdown
+	question.PromptMarkdown = res.Markdown
+}
+
+// addCalibrationBadges marks questions whose session outcomes suggest another
+// difficulty, for callers with a service key only
+func addCalibrationBadges(request *http.Request, questions []models.Question) {
+	if auth.ClientName(request.Context()) == "" {
+		return
+	}
+	for i := range questions {
+		questions[i].CalibrationBadge = questions[i].Calibration.Badge(questions[i].Difficulty)
+	}
+}
+
+// randomizeExamples generates the question's examples for the caller's seed
+// (collab passes the match id, so both participants see the same examples)
+// without a seed, or if generation fails, the static examples are served
+func randomizeExamples(question *models.Question, seed string) {
+	if seed == "" {
+		return
+	}
+	if err := examples.Apply(question, seed); err != nil {
+		log.Printf("example generation failed for question %d (seed %q), serving static examples: %v", question.ID, seed, err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -158,6 +160,107 @@ func TestCreateQuestion_BadJSON(t *testing.T) {
 }
 
 // GET /questions/{id} (found)
+func TestCreateQuestion_ExecutionMetadata(t *testing.T) {
+	var stored *models.Question
+	repo := &fakeRepo{
+		createFn: func(q *models.Question) (*models.Question, error) {
+			stored = q
+			q.ID = 102
+			return q, nil
+		},
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+
+	body := bytes.NewBufferString(`{"title":"Graph","execution":{"allowedLanguages":["python"],"limits":{"wallTimeMs":20000,"memoryMb":1024}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/questions", body)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stored == nil || stored.Execution == nil || stored.Execution.Limits == nil {
+		t.Fatalf("expected execution metadata to be stored, got %+v", stored)
+	}
+	if stored.Execution.AllowedLanguages[0] != "python" || stored.Execution.Limits.WallTimeMs != 20000 {
+		t.Fatalf("unexpected execution metadata: %+v", stored.Execution)
+	}
+}
+
+func TestCreateQuestion_InvalidExecutionMetadata(t *testing.T) {
+	repo := &fakeRepo{} // createFn must not be reached
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+	r.Put("/api/v1/questions/{id}", h.UpdateQuestionHandler)
+
+	payload := `{"title":"Graph","execution":{"allowedLanguages":["python","cobol","python"],"limits":{"wallTimeMs":90000,"memoryMb":-1}}}`
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewBufferString(payload)),
+		httptest.NewRequest(http.MethodPut, "/api/v1/questions/1", bytes.NewBufferString(payload)),
+	} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("bad JSON: %v", err)
+		}
+		if resp.Code != "validation_failed" || len(resp.Details) != 4 {
+			t.Fatalf("unexpected error response: %+v", resp)
+		}
+	}
+}
+
+func TestCreateQuestion_ExecutionArgsAndEnv(t *testing.T) {
+	var stored *models.Question
+	repo := &fakeRepo{
+		createFn: func(q *models.Question) (*models.Question, error) {
+			stored = q
+			return q, nil
+		},
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+
+	valid := `{"title":"CLI","execution":{"args":["--mode=fast"],"env":{"DEBUG":"1"}}}`
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewBufferString(valid)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stored.Execution.Args[0] != "--mode=fast" || stored.Execution.Env["DEBUG"] != "1" {
+		t.Fatalf("unexpected execution metadata: %+v", stored.Execution)
+	}
+
+	invalid := `{"title":"CLI","execution":{"args":["` + strings.Repeat("a", 257) + `"],"env":{"PATH":"/tmp","SANDBOX_X":"1","lower":"1"}}}`
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewBufferString(invalid)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	var fields []string
+	for _, d := range resp.Details {
+		fields = append(fields, d.Field)
+	}
+	if got := strings.Join(fields, ","); got != "execution.args[0],execution.env.PATH,execution.env.SANDBOX_X,execution.env.lower" {
+		t.Fatalf("unexpected validation details: %s", got)
+	}
+}
+
 func TestGetQuestionByID_Found(t *testing.T) {
 	repo := &fakeRepo{
 		getByIDFn: func(id int) (*models.Question, error) {
@@ -302,3 +405,240 @@ func TestGetRandomQuestion_WithFilters(t *testing.T) {
 		t.Fatalf("unexpected random question: %+v", got)
 	}
 }
+
+func generatedQuestion(id int) *models.Question {
+	return &models.Question{
+		ID: id, Title: "Max Element", Difficulty: models.Easy,
+		ExampleGenerator: &models.ExampleGenerator{
+			Count:  2,
+			Params: []models.ExampleParam{{Name: "nums", Type: models.ParamIntArray, Min: 1, Max: 100, MinLength: 5, MaxLength: 10}},
+			Output: "max(nums)",
+		},
+		TestCases: []models.TestCase{{Input: "nums = [1, 2]", Output: "2"}},
+	}
+}
+
+// GET /questions/{id}?seed= and /questions/random?seed=
+func TestGetQuestion_SeededExamples(t *testing.T) {
+	repo := &fakeRepo{
+		getByIDFn: func(id int) (*models.Question, error) { return generatedQuestion(id), nil },
+		randomFn:  func([]string, string) (*models.Question, error) { return generatedQuestion(5), nil },
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/questions/random", h.GetRandomQuestionHandler)
+	r.Get("/api/v1/questions/{id}", h.GetQuestionByIDHandler)
+
+	get := func(path string) models.Question {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var got models.Question
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("bad JSON: %v", err)
+		}
+		return got
+	}
+
+	// both participants, and a reroll back to the same question, see the same examples
+	random := get("/api/v1/questions/random?seed=match-1")
+	byID := get("/api/v1/questions/5?seed=match-1")
+	if len(random.TestCases) != 2 || random.TestCases[0].Input == "nums = [1, 2]" {
+		t.Fatalf("expected generated examples, got %+v", random.TestCases)
+	}
+	if !reflect.DeepEqual(random.TestCases, byID.TestCases) {
+		t.Fatalf("same seed served different examples:\n%+v\n%+v", random.TestCases, byID.TestCases)
+	}
+
+	if other := get("/api/v1/questions/5?seed=match-2"); reflect.DeepEqual(other.TestCases, byID.TestCases) {
+		t.Fatalf("different seeds served identical examples: %+v", other.TestCases)
+	}
+	if static := get("/api/v1/questions/5"); len(static.TestCases) != 1 || static.TestCases[0].Input != "nums = [1, 2]" {
+		t.Fatalf("expected static examples without a seed, got %+v", static.TestCases)
+	}
+}
+
+func TestGetQuestion_GenerationFailureServesStaticExamples(t *testing.T) {
+	repo := &fakeRepo{
+		getByIDFn: func(id int) (*models.Question, error) {
+			q := generatedQuestion(id)
+			q.ExampleGenerator.Output = "nums[20]" // out of range for every generated array
+			return q, nil
+		},
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/questions/{id}", h.GetQuestionByIDHandler)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/questions/5?seed=match-1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var got models.Question
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if len(got.TestCases) != 1 || got.TestCases[0].Input != "nums = [1, 2]" {
+		t.Fatalf("expected static examples, got %+v", got.TestCases)
+	}
+}
+
+func TestCreateQuestion_InvalidExampleGenerator(t *testing.T) {
+	repo := &fakeRepo{} // createFn must not be reached
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+
+	payload := `{"title":"Max","exampleGenerator":{"count":2,"params":[{"name":"nums","type":"int_array","min":1,"max":9,"minLength":1,"maxLength":5}],"output":"require('fs')"}}`
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewBufferString(payload)))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if resp.Code != "validation_failed" || len(resp.Details) != 1 || resp.Details[0].Field != "exampleGenerator.output" {
+		t.Fatalf("unexpected error response: %+v", resp)
+	}
+}
+
+func TestCreateQuestion_SanitizesDescription(t *testing.T) {
+	var stored *models.Question
+	repo := &fakeRepo{
+		createFn: func(q *models.Question) (*models.Question, error) {
+			q.ID = 102
+			stored = q
+			return q, nil
+		},
+		getByIDFn: func(id int) (*models.Question, error) { return stored, nil },
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+	r.Get("/api/v1/questions/{id}", h.GetQuestionByIDHandler)
+	r.Get("/api/v1/questions/{id}/original", h.GetOriginalDescriptionHandler)
+
+	authored := `Find x<sup>2</sup> <img src=x onerror=alert(1)> [docs](javascript:alert(1))`
+	payload, _ := json.Marshal(map[string]string{"title": "Squares", "prompt_markdown": authored})
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewReader(payload)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stored.PromptMarkdown != "Find x<sup>2</sup>  docs" || stored.PromptMarkdownOriginal != authored {
+		t.Fatalf("unexpected stored descriptions: %q / %q", stored.PromptMarkdown, stored.PromptMarkdownOriginal)
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/questions/102", nil))
+	if bytes.Contains(rr.Body.Bytes(), []byte("onerror")) {
+		t.Fatalf("original description served by default: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/questions/102/original", nil))
+	var original models.OriginalDescriptionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &original); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if original.PromptMarkdownOriginal != authored || original.PromptMarkdown != stored.PromptMarkdown {
+		t.Fatalf("unexpected original response: %+v", original)
+	}
+}
+
+func TestPreview_RoundTrip(t *testing.T) {
+	repo := &fakeRepo{
+		createFn: func(q *models.Question) (*models.Question, error) {
+			t.Fatal("preview must not create a question")
+			return nil, nil
+		},
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions/preview", h.PreviewHandler)
+
+	payload := `{"prompt_markdown":"# Sum\n\nReturn **a + b**. <script>alert(1)</script>[x](javascript:alert(1))"}`
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions/preview", bytes.NewBufferString(payload)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var got models.PreviewResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	want := models.PreviewResponse{
+		PromptMarkdown: "# Sum\n\nReturn **a + b**. x",
+		HTML:           "<h1>Sum</h1>\n<p>Return <strong>a + b</strong>. x</p>\n",
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions/preview", bytes.NewBufferString(`{"prompt_markdown":1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad payload, got %d", rr.Code)
+	}
+}
+
+func TestCreateQuestion_Rubric(t *testing.T) {
+	var stored *models.Question
+	repo := &fakeRepo{
+		createFn: func(q *models.Question) (*models.Question, error) {
+			stored = q
+			q.ID = 103
+			return q, nil
+		},
+	}
+	h := handlers.NewQuestionHandler(repo)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/questions", h.CreateQuestionHandler)
+	post := func(payload string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/questions", bytes.NewBufferString(payload)))
+		return rr
+	}
+
+	rr := post(`{"title":"Sort","rubric":["handles empty input","O(n log n) or better","explains approach"]}`)
+	if rr.Code != http.StatusCreated || len(stored.Rubric) != 3 || stored.Rubric[1] != "O(n log n) or better" {
+		t.Fatalf("expected the rubric to be stored, got %d %+v", rr.Code, stored)
+	}
+
+	long := strings.Repeat("x", models.MaxRubricItemLen+1)
+	cases := map[string][]string{
+		`{"title":"Sort","rubric":["one","two"]}`:                                   {"rubric"},
+		`{"title":"Sort","rubric":["1","2","3","4","5","6","7","8","9","10","11"]}`: {"rubric"},
+		`{"title":"Sort","rubric":["ok","  ","` + long + `"]}`:                      {"rubric[1]", "rubric[2]"},
+	}
+	for payload, fields := range cases {
+		stored = nil
+		rr := post(payload)
+		var resp models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("bad JSON: %v", err)
+		}
+		if rr.Code != http.StatusBadRequest || stored != nil || resp.Code != "validation_failed" || len(resp.Details) != len(fields) {
+			t.Fatalf("%s: unexpected %d %+v", payload, rr.Code, resp)
+		}
+		for i, field := range fields {
+			if resp.Details[i].Field != field {
+				t.Fatalf("%s: expected %s, got %+v", payload, field, resp.Details)
+			}
+		}
+	}
+}
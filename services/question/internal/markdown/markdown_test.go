@@ -0,0 +1,179 @@
+package markdown_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"peerprep/question/internal/markdown"
+)
+
+// each fixture must not survive sanitizing and rendering in any executable form
+var xssFixtures = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+	`<img src=x onerror=alert(1)>`,
+	`<img src=x onerror=alert(1)//`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<td onmouseover="alert(1)">cell</td>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`[click](javascript:alert(1))`,
+	`[click](JaVaScRiPt:alert(1))`,
+	`[click](jav&#x09;ascript:alert(1))`,
+	`[click]( javascript:alert(1) )`,
+	`[click](<javascript:alert(1)>)`,
+	`[click](vbscript:msgbox(1))`,
+	`[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+	`![x](javascript:alert(1))`,
+	`![x](data:text/html,<script>alert(1)</script>)`,
+	`[x][ref]` + "\n\n" + `[ref]: javascript:alert(1)`,
+	`<javascript:alert(1)>`,
+	`[a](http://ok.example "x\" onmouseover=\"alert(1)")`,
+	`<!-- <script>alert(1)</script> -->`,
+	`<<script>script>alert(1)<</script>/script>`,
+	`<math><mi xlink:href="javascript:alert(1)">x</mi></math>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<style>body{background:red}</style>`,
+	`<form action="javascript:alert(1)"><button>x</button></form>`,
+	`[nested [link](javascript:alert(1))](http://ok.example)`,
+}
+
+var (
+	// tags the sanitizer or renderer may emit
+	safeTags      = regexp.MustCompile(`^</?(table|thead|tbody|tr|th|td|sup|sub|br|p|h[1-6]|hr|ul|ol|li|blockquote|pre|code|strong|em|a|img)\b`)
+	openTag       = regexp.MustCompile(`(?i)</?[a-z][^<>]*>?`)
+	safeAttr      = regexp.MustCompile(`\s(href|src|alt|title|rel|class)="[^"<>]*"`)
+	unsafeTargets = regexp.MustCompile(`(?i)(href="|src="|\]\(\s*<?|\]:\s*<?|<)(javascript|vbscript|data:text)`)
+)
+
+func assertInert(t *testing.T, label, out string) {
+	t.Helper()
+	for _, tag := range openTag.FindAllString(out, -1) {
+		if !safeTags.MatchString(strings.ToLower(tag)) {
+			t.Errorf("%s emits tag %q:\n%s", label, tag, out)
+		}
+		if bare := safeAttr.ReplaceAllString(tag, ""); strings.ContainsAny(strings.TrimSuffix(bare, ">"), " =\"'") {
+			t.Errorf("%s emits unexpected attributes in %q", label, tag)
+		}
+	}
+	if unsafeTargets.MatchString(out) {
+		t.Errorf("%s keeps an executable attribute or target:\n%s", label, out)
+	}
+}
+
+func TestSanitizeNeutralisesXSSFixtures(t *testing.T) {
+	for _, fixture := range xssFixtures {
+		res := markdown.Sanitize(fixture)
+		assertInert(t, "sanitized "+fixture, res.Markdown)
+		assertInert(t, "rendered "+fixture, markdown.RenderHTML(res.Markdown))
+	}
+}
+
+func TestSanitizeKeepsAllowlistedTagsWithoutAttributes(t *testing.T) {
+	res := markdown.Sanitize(`<table class="x"><tr><td onclick="alert(1)">1</td></tr></table> x<sup>2</sup> H<sub>2</sub>O<br/><span>kept text</span>`)
+	want := `<table><tr><td>1</td></tr></table> x<sup>2</sup> H<sub>2</sub>O<br>kept text`
+	if res.Markdown != want {
+		t.Fatalf("got %q, want %q", res.Markdown, want)
+	}
+}
+
+func TestSanitizeRewritesLinks(t *testing.T) {
+	cases := map[string]string{
+		`[docs](https://example.com/a?b=1 "Docs")`: `[docs](https://example.com/a?b=1 "Docs")`,
+		`[mail](mailto:a@example.com)`:             `[mail](mailto:a@example.com)`,
+		`[rel](/questions/2)`:                      `[rel](/questions/2)`,
+		`[bad](javascript:alert(1))`:               `bad`,
+		`[**bold** bad](ftp://example.com)`:        `**bold** bad`,
+		`<https://example.com>`:                    `<https://example.com>`,
+		`<a@example.com>`:                          `<a@example.com>`,
+		`a < b and b > c`:                          `a < b and b > c`,
+		`if x<y then`:                              `if x&lt;y then`,
+	}
+	for in, want := range cases {
+		if got := markdown.Sanitize(in).Markdown; got != want {
+			t.Errorf("Sanitize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSanitizeLeavesCodeUntouched(t *testing.T) {
+	in := "Use `<script>` tags:\n\n```html\n<script>alert(1)</script>\n[x](javascript:y)\n```\n\nafter <b>bold</b>"
+	want := "Use `<script>` tags:\n\n```html\n<script>alert(1)</script>\n[x](javascript:y)\n```\n\nafter bold"
+	if got := markdown.Sanitize(in).Markdown; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	html := markdown.RenderHTML(want)
+	if !strings.Contains(html, "&lt;script&gt;alert(1)&lt;/script&gt;") || strings.Contains(html, "<script") {
+		t.Fatalf("code block not escaped when rendered: %s", html)
+	}
+}
+
+func TestSanitizeCapsEmbeddedImages(t *testing.T) {
+	small := "![dot](data:image/png;base64,iVBORw0KGgo=)"
+	if got := markdown.Sanitize(small).Markdown; got != small {
+		t.Fatalf("small image rewritten to %q", got)
+	}
+	big := "![huge](data:image/png;base64," + strings.Repeat("A", markdown.MaxImageDataBytes) + ")"
+	if got := markdown.Sanitize(big).Markdown; got != "huge" {
+		t.Fatalf("oversized image kept: %.60q", got)
+	}
+	if got := markdown.Sanitize("![svg](data:image/svg+xml;base64,PHN2Zz4=)").Markdown; got != "svg" {
+		t.Fatalf("svg data image kept: %q", got)
+	}
+}
+
+func TestSanitizeFallsBackToEscapingInvalidInput(t *testing.T) {
+	res := markdown.Sanitize("bad \xff <script>alert(1)</script> *x*")
+	if !res.Escaped {
+		t.Fatal("expected the escaped fallback for invalid UTF-8")
+	}
+	if strings.Contains(res.Markdown, "<script") || !strings.Contains(res.Markdown, `\*x\*`) {
+		t.Fatalf("unexpected fallback output %q", res.Markdown)
+	}
+}
+
+func TestSanitizeIsDeterministicAndIdempotent(t *testing.T) {
+	for _, fixture := range xssFixtures {
+		once := markdown.Sanitize(fixture).Markdown
+		if again := markdown.Sanitize(fixture).Markdown; again != once {
+			t.Fatalf("non-deterministic output for %q", fixture)
+		}
+		if twice := markdown.Sanitize(once).Markdown; twice != once {
+			t.Errorf("sanitizing %q twice changed %q to %q", fixture, once, twice)
+		}
+	}
+}
+
+// BenchmarkSanitizeLargeInput sanitizes and renders 512KB of markdown full of
+// unclosed brackets and tags
+func BenchmarkSanitizeLargeInput(b *testing.B) {
+	var sb strings.Builder
+	for sb.Len() < 512*1024 {
+		sb.WriteString("Some **text** with [a link](https://example.com) and `code` <b>tag</b> <<<[[[(((\n")
+	}
+	input := sb.String()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		markdown.RenderHTML(markdown.Sanitize(input).Markdown)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	md := "# Two Sum\n\nGiven `nums`, return **indices**.\n\n- one\n- two\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\nSee [docs](https://example.com)."
+	got := markdown.RenderHTML(md)
+	for _, want := range []string{
+		"<h1>Two Sum</h1>",
+		"<p>Given <code>nums</code>, return <strong>indices</strong>.</p>",
+		"<ul>\n<li>one</li>\n<li>two</li>\n</ul>",
+		"<th>a</th><th>b</th>",
+		"<td>1</td><td>2</td>",
+		`<a href="https://example.com" rel="nofollow noopener">docs</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered HTML missing %q:\n%s", want, got)
+		}
+	}
+}
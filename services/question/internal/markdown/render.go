@@ -0,0 +1,205 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingPattern   = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	hrPattern        = regexp.MustCompile(`^ {0,3}([-*_])([ \t]*[-*_]){2,}[ \t]*$`)
+	bulletPattern    = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	orderedPattern   = regexp.MustCompile(`^ {0,3}\d{1,9}[.)][ \t]+(.*)$`)
+	quotePattern     = regexp.MustCompile(`^ {0,3}>[ ]?(.*)$`)
+	tableSepPattern  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	strongPattern    = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	emPattern        = regexp.MustCompile(`\*([^*\n]+)\*|\b_([^_\n]+)_\b`)
+	keptTagPattern   = regexp.MustCompile(`&lt;(/?)(table|thead|tbody|tr|th|td|sup|sub|br)&gt;`)
+	autolinkRendered = regexp.MustCompile(`&lt;((?:https?|mailto):[^\s&]*)&gt;`)
+)
+
+// RenderHTML renders sanitized Markdown (see Sanitize) to HTML for previews.
+// It covers the subset question descriptions use: headings, paragraphs, lists,
+// block quotes, fenced code, pipe tables, emphasis, code spans, links and
+// images. All text is escaped; the only markup in the output is generated here.
+func RenderHTML(md string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n"))
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			fence := fencePattern.FindStringSubmatch(line)[1]
+			lang := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), fence[:1]))
+			if lang != "" {
+				b.WriteString(`<pre><code class="language-` + html.EscapeString(strings.Fields(lang)[0]) + `">`)
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimLeft(lines[i], " "), fence) {
+					i++
+					break
+				}
+				b.WriteString(html.EscapeString(lines[i]) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+
+		case headingPattern.MatchString(line):
+			m := headingPattern.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case hrPattern.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case quotePattern.MatchString(line):
+			var inner []string
+			for ; i < len(lines) && quotePattern.MatchString(lines[i]); i++ {
+				inner = append(inner, quotePattern.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, inner)
+			b.WriteString("</blockquote>\n")
+
+		case bulletPattern.MatchString(line), orderedPattern.MatchString(line):
+			pattern, tag := bulletPattern, "ul"
+			if !bulletPattern.MatchString(line) {
+				pattern, tag = orderedPattern, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && pattern.MatchString(lines[i]); i++ {
+				b.WriteString("<li>" + renderInline(pattern.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+			}
+			b.WriteString("</" + tag + ">\n")
+
+		case strings.Contains(line, "|") && i+1 < len(lines) && tableSepPattern.MatchString(lines[i+1]):
+			b.WriteString("<table>\n<thead>\n<tr>")
+			for _, cell := range tableCells(line) {
+				b.WriteString("<th>" + renderInline(cell) + "</th>")
+			}
+			b.WriteString("</tr>\n</thead>\n<tbody>\n")
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				b.WriteString("<tr>")
+				for _, cell := range tableCells(lines[i]) {
+					b.WriteString("<td>" + renderInline(cell) + "</td>")
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</tbody>\n</table>\n")
+
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			if len(para) == 0 {
+				// a line startsBlock claims but no case above handled
+				para = append(para, strings.TrimSpace(lines[i]))
+				i++
+			}
+			b.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+		}
+	}
+}
+
+func startsBlock(line string) bool {
+	return fencePattern.MatchString(line) || headingPattern.MatchString(line) || hrPattern.MatchString(line) ||
+		quotePattern.MatchString(line) || bulletPattern.MatchString(line) || orderedPattern.MatchString(line)
+}
+
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// renderInline renders code spans, links, images and emphasis in s
+func renderInline(s string) string {
+	var b strings.Builder
+	forEachCodeSpan(s, func(s string, code bool) {
+		if code {
+			n := backtickRun(s)
+			b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(s[n:len(s)-n])) + "</code>")
+			return
+		}
+		b.WriteString(renderText(s))
+	})
+	return b.String()
+}
+
+// renderText renders the inline constructs outside code spans
+func renderText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|~\"<>", s[i+1]) >= 0 {
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		}
+		if s[i] == '[' || (s[i] == '!' && i+1 < len(s) && s[i+1] == '[') {
+			if l, ok := parseLink(s[i:]); ok {
+				b.WriteString(renderLink(l))
+				i += l.length
+				continue
+			}
+		}
+		next := strings.IndexAny(s[i+1:], "\\[!")
+		if next < 0 {
+			next = len(s)
+		} else {
+			next += i + 1
+		}
+		b.WriteString(renderPlain(s[i:next]))
+		i = next
+	}
+	return b.String()
+}
+
+func renderLink(l link) string {
+	dest := html.EscapeString(l.dest)
+	title := ""
+	if l.title != "" {
+		title = ` title="` + html.EscapeString(l.title) + `"`
+	}
+	if l.image {
+		if !safeImage(l.dest) {
+			return html.EscapeString(l.text)
+		}
+		return `<img src="` + dest + `" alt="` + html.EscapeString(l.text) + `"` + title + `>`
+	}
+	if !safeLink(l.dest) {
+		return renderText(l.text)
+	}
+	return `<a href="` + dest + `"` + title + ` rel="nofollow noopener">` + renderText(l.text) + `</a>`
+}
+
+// renderPlain escapes text, then restores allowlisted tags, autolinks,
+// emphasis and hard line breaks
+func renderPlain(s string) string {
+	// entities written by the author are kept as entities, not double escaped
+	out := html.EscapeString(html.UnescapeString(s))
+	out = keptTagPattern.ReplaceAllString(out, "<$1$2>")
+	out = autolinkRendered.ReplaceAllStringFunc(out, func(m string) string {
+		target := m[len("&lt;") : len(m)-len("&gt;")]
+		return `<a href="` + target + `" rel="nofollow noopener">` + target + `</a>`
+	})
+	out = strongPattern.ReplaceAllString(out, "<strong>$1$2</strong>")
+	out = emPattern.ReplaceAllString(out, "<em>$1$2</em>")
+	return strings.ReplaceAll(out, "  \n", "<br>\n")
+}
@@ -0,0 +1,456 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// embedded data: images larger than this (encoded) are dropped
+const MaxImageDataBytes = 64 * 1024
+
+// raw HTML tags kept in descriptions; their attributes are always dropped
+var allowedTags = map[string]bool{
+	"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+	"sup": true, "sub": true, "br": true,
+}
+
+// tags dropped together with their content, since it is never meant as text
+var droppedContainers = []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea", "title", "svg", "math"}
+
+var (
+	commentPattern   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	containerPattern = map[string]*regexp.Regexp{}
+	tagPattern       = regexp.MustCompile(`</?([A-Za-z][A-Za-z0-9-]*)(?:[\s/][^<>]*)?>`)
+	autolinkPattern  = regexp.MustCompile(`<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\s]*)>`)
+	emailPattern     = regexp.MustCompile(`<[^\s<>@:]+@[^\s<>]+>`)
+	tagStartPattern  = regexp.MustCompile(`<([A-Za-z/!?])`)
+	refDefPattern    = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:[ \t]*(<[^>]*>|\S+)`)
+	fencePattern     = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	dataImagePattern = regexp.MustCompile(`^data:image/(png|jpeg|jpg|gif|webp);base64,[A-Za-z0-9+/=\s]*$`)
+)
+
+func init() {
+	for _, name := range droppedContainers {
+		containerPattern[name] = regexp.MustCompile(`(?is)<` + name + `\b[^>]*>.*?</` + name + `\s*>`)
+	}
+}
+
+// Result is a sanitized description
+type Result struct {
+	Markdown string `json:"markdown"`
+	// set when sanitizing failed and the whole body was escaped instead
+	Escaped bool `json:"escaped"`
+}
+
+// Sanitize makes a Markdown description safe to hand to a permissive renderer:
+// raw HTML outside allowedTags is stripped, links other than http(s), mailto
+// and relative ones lose their target, and oversized data: images are dropped.
+// Code blocks and spans are left untouched. The result is deterministic; if
+// sanitizing fails the whole body is escaped rather than returned as is.
+func Sanitize(src string) (res Result) {
+	defer func() {
+		if recover() != nil {
+			res = Result{Markdown: EscapeAll(src), Escaped: true}
+		}
+	}()
+	if !utf8.ValidString(src) {
+		return Result{Markdown: EscapeAll(strings.ToValidUTF8(src, "\uFFFD")), Escaped: true}
+	}
+	return Result{Markdown: sanitize(src)}
+}
+
+// EscapeAll neutralises every Markdown and HTML construct in s, so it renders
+// as literal text
+func EscapeAll(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '&':
+			b.WriteString("&amp;")
+		case r < utf8.RuneSelf && strings.ContainsRune("\\`*_{}[]()#+-.!|~\"", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func sanitize(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	src = strings.ReplaceAll(src, "\x00", "\uFFFD")
+
+	// fenced code passes through verbatim, everything between is sanitized
+	var out, text []string
+	flush := func() {
+		if len(text) > 0 {
+			out = append(out, sanitizeText(strings.Join(text, "\n")))
+			text = nil
+		}
+	}
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		m := fencePattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			text = append(text, lines[i])
+			continue
+		}
+		flush()
+		out = append(out, lines[i])
+		for i++; i < len(lines); i++ {
+			out = append(out, lines[i])
+			if strings.HasPrefix(strings.TrimLeft(lines[i], " "), m[1]) {
+				break
+			}
+		}
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+// sanitizeText handles a run of lines outside fenced code
+func sanitizeText(text string) string {
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if m := refDefPattern.FindStringSubmatch(line); m != nil {
+			if !safeLink(strings.Trim(m[1], "<>")) {
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	text = strings.Join(kept, "\n")
+
+	var b strings.Builder
+	forEachCodeSpan(text, func(s string, code bool) {
+		if code {
+			b.WriteString(s)
+		} else {
+			b.WriteString(sanitizeInline(s))
+		}
+	})
+	return b.String()
+}
+
+// forEachCodeSpan splits s into inline code spans and the text around them
+func forEachCodeSpan(s string, fn func(s string, code bool)) {
+	for {
+		open := strings.IndexByte(s, '`')
+		if open < 0 {
+			fn(s, false)
+			return
+		}
+		n := backtickRun(s[open:])
+		end := closingBackticks(s[open+n:], n)
+		if end < 0 {
+			fn(s[:open+n], false)
+			s = s[open+n:]
+			continue
+		}
+		fn(s[:open], false)
+		fn(s[open:open+n+end+n], true)
+		s = s[open+n+end+n:]
+	}
+}
+
+func backtickRun(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// closingBackticks finds a run of exactly n backticks in s
+func closingBackticks(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := backtickRun(s[i:])
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+func sanitizeInline(s string) string {
+	s = commentPattern.ReplaceAllString(s, "")
+	for _, name := range droppedContainers {
+		s = containerPattern[name].ReplaceAllString(s, "")
+	}
+	s = rewriteLinks(s)
+	s = autolinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if safeLink(m[1:len(m)-1]) && linkScheme(m[1:len(m)-1]) != "" {
+			return m
+		}
+		return m[1 : len(m)-1]
+	})
+	s = tagPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if emailPattern.MatchString(m) {
+			return m
+		}
+		name := strings.ToLower(tagPattern.FindStringSubmatch(m)[1])
+		if !allowedTags[name] {
+			return ""
+		}
+		if strings.HasPrefix(m, "</") {
+			return "</" + name + ">"
+		}
+		return "<" + name + ">"
+	})
+	// anything still looking like markup is shown as text
+	return escapeTagStarts(s)
+}
+
+// escapeTagStarts escapes '<' where a renderer could start a tag, keeping
+// the tags and autolinks sanitizeInline let through
+func escapeTagStarts(s string) string {
+	var b strings.Builder
+	for {
+		loc := tagStartPattern.FindStringIndex(s)
+		if loc == nil {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:loc[0]])
+		rest := s[loc[0]:]
+		if kept := keptMarkup(rest); kept > 0 {
+			b.WriteString(rest[:kept])
+			s = rest[kept:]
+			continue
+		}
+		b.WriteString("&lt;")
+		s = rest[1:]
+	}
+}
+
+// keptMarkup returns the length of the allowed tag or autolink s starts with
+func keptMarkup(s string) int {
+	if loc := autolinkPattern.FindStringIndex(s); loc != nil && loc[0] == 0 {
+		return loc[1]
+	}
+	if loc := emailPattern.FindStringIndex(s); loc != nil && loc[0] == 0 {
+		return loc[1]
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return 0
+	}
+	name := strings.TrimPrefix(s[1:end], "/")
+	if allowedTags[name] {
+		return end + 1
+	}
+	return 0
+}
+
+// rewriteLinks drops unsafe link targets and oversized images
+func rewriteLinks(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\\' && i+1 < len(s) {
+			b.WriteString(s[i : i+2])
+			i += 2
+			continue
+		}
+		image := s[i] == '!' && i+1 < len(s) && s[i+1] == '['
+		if s[i] != '[' && !image {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		l, ok := parseLink(s[i:])
+		if !ok {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		if l.image {
+			if safeImage(l.dest) {
+				b.WriteString("![" + l.text + "](" + encodeDest(l.dest) + l.titleSuffix() + ")")
+			} else {
+				b.WriteString(l.text)
+			}
+		} else {
+			text := rewriteLinks(l.text)
+			if safeLink(l.dest) {
+				b.WriteString("[" + text + "](" + encodeDest(l.dest) + l.titleSuffix() + ")")
+			} else {
+				b.WriteString(text)
+			}
+		}
+		i += l.length
+	}
+	return b.String()
+}
+
+type link struct {
+	image  bool
+	text   string
+	dest   string
+	title  string
+	length int // bytes consumed from the source
+}
+
+func (l link) titleSuffix() string {
+	if l.title == "" {
+		return ""
+	}
+	return ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(l.title) + `"`
+}
+
+// parseLink reads an inline link or image, [text](dest "title"), at the start of s
+func parseLink(s string) (link, bool) {
+	var l link
+	i := 0
+	if s[0] == '!' {
+		l.image = true
+		i = 1
+	}
+	depth := 0
+	start := i + 1
+	for i < len(s) {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		i++
+		if depth == 0 {
+			break
+		}
+	}
+	if depth != 0 || i >= len(s) || s[i] != '(' {
+		return link{}, false
+	}
+	l.text = s[start : i-1]
+	i++
+
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+		i++
+	}
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexAny(s[i+1:], ">\n")
+		if end < 0 || s[i+1+end] != '>' {
+			return link{}, false
+		}
+		l.dest = s[i+1 : i+1+end]
+		i += end + 2
+	} else {
+		parens, begin := 0, i
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				continue
+			}
+			if c == ' ' || c == '\t' || c == '\n' || (c == ')' && parens == 0) {
+				break
+			}
+			if c == '(' {
+				parens++
+			} else if c == ')' {
+				parens--
+			}
+		}
+		l.dest = s[begin:i]
+	}
+
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+		i++
+	}
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := i + 1
+		for end < len(s) && s[end] != closer {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return link{}, false
+		}
+		l.title = unescapeTitle(s[i+1 : end])
+		i = end + 1
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+			i++
+		}
+	}
+	if i >= len(s) || s[i] != ')' {
+		return link{}, false
+	}
+	l.length = i + 1
+	return l, true
+}
+
+// unescapeTitle removes the backslash escapes of a link title
+func unescapeTitle(t string) string {
+	var b strings.Builder
+	for i := 0; i < len(t); i++ {
+		if t[i] == '\\' && i+1 < len(t) {
+			i++
+		}
+		b.WriteByte(t[i])
+	}
+	return b.String()
+}
+
+// encodeDest escapes what would let a destination break out of the link syntax
+func encodeDest(dest string) string {
+	return strings.NewReplacer(" ", "%20", "\n", "%0A", "\t", "%09", "<", "%3C", ">", "%3E", "(", "%28", ")", "%29").Replace(dest)
+}
+
+// linkScheme returns the lowercased scheme of a URL, or "" for a relative one.
+// Entities and the control characters browsers ignore are removed first, so
+// "jav&#x09;ascript:" is still recognised.
+func linkScheme(raw string) string {
+	u := html.UnescapeString(raw)
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	i := strings.IndexAny(u, ":/?#")
+	if i <= 0 || u[i] != ':' {
+		return ""
+	}
+	return strings.ToLower(u[:i])
+}
+
+func safeLink(dest string) bool {
+	switch linkScheme(dest) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func safeImage(dest string) bool {
+	switch linkScheme(dest) {
+	case "", "http", "https":
+		return true
+	case "data":
+		return len(dest) <= MaxImageDataBytes && dataImagePattern.MatchString(strings.ToLower(dest))
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package models
+
+import "time"
+
+// how a session with a question ended
+type SessionOutcome string
+
+const (
+	OutcomeCompleted SessionOutcome = "completed" // the pair ended the session on this question
+	OutcomeAbandoned SessionOutcome = "abandoned" // the session ended without a finished attempt
+	OutcomeRerolled  SessionOutcome = "rerolled"  // the pair rerolled away from this question
+)
+
+// optional verdict of a completed session, e.g. from the final test run
+const (
+	VerdictPassed = "passed"
+	VerdictFailed = "failed"
+)
+
+// one session's result for a question, reported by collab
+type SessionResult struct {
+	QuestionID  int            `json:"questionId" bson:"question_id"`
+	MatchID     string         `json:"matchId,omitempty" bson:"match_id,omitempty"`
+	Outcome     SessionOutcome `json:"outcome" bson:"outcome"`
+	Verdict     string         `json:"verdict,omitempty" bson:"verdict,omitempty"`
+	DurationSec int            `json:"durationSec" bson:"duration_sec"` // time on the question, until the end or the reroll
+	RecordedAt  time.Time      `json:"recordedAt" bson:"recorded_at"`
+}
+
+// Solved reports whether the session counts as a solve: completed without a failing verdict
+func (s SessionResult) Solved() bool {
+	return s.Outcome == OutcomeCompleted && s.Verdict != VerdictFailed
+}
+
+// Validate checks a reported result and returns one detail per invalid field
+func (s SessionResult) Validate() []ValidationErrorDetail {
+	var details []ValidationErrorDetail
+	switch s.Outcome {
+	case OutcomeCompleted, OutcomeAbandoned, OutcomeRerolled:
+	default:
+		details = append(details, ValidationErrorDetail{Field: "outcome", Reason: "must be one of: completed, abandoned, rerolled"})
+	}
+	if s.Verdict != "" && s.Verdict != VerdictPassed && s.Verdict != VerdictFailed {
+		details = append(details, ValidationErrorDetail{Field: "verdict", Reason: "must be passed or failed"})
+	}
+	if s.DurationSec < 0 {
+		details = append(details, ValidationErrorDetail{Field: "durationSec", Reason: "must not be negative"})
+	}
+	return details
+}
+
+// difficulty suggested by the session results of the last evaluated window
+// the authored difficulty is never changed automatically
+type Calibration struct {
+	SuggestedDifficulty Difficulty `json:"suggestedDifficulty" bson:"suggested_difficulty"`
+	Confidence          float64    `json:"confidence" bson:"confidence"` // 0-1, grows with the sample size and the agreeing signals
+	SampleSize          int        `json:"sampleSize" bson:"sample_size"`
+	InsufficientData    bool       `json:"insufficientData" bson:"insufficient_data"` // below the minimum sample size, never flagged
+	ComputedAt          time.Time  `json:"computedAt" bson:"computed_at"`
+
+	// window metrics behind the suggestion
+	MedianSolveSec       float64 `json:"medianSolveSec" bson:"median_solve_sec"`
+	SolvePercentile      float64 `json:"solvePercentile" bson:"solve_percentile"` // rank of the median among solves of the same difficulty
+	CompletionRate       float64 `json:"completionRate" bson:"completion_rate"`
+	CorpusCompletionRate float64 `json:"corpusCompletionRate" bson:"corpus_completion_rate"`
+
+	// Candidate is where the window's metrics pointed; a suggestion only moves
+	// once two consecutive windows agree on it
+	Candidate Difficulty `json:"candidate" bson:"candidate"`
+	WindowEnd time.Time  `json:"windowEnd" bson:"window_end"`
+}
+
+// badges shown next to questions in admin lists
+const (
+	BadgeCalibrated       = "calibrated"
+	BadgeEasier           = "easier"
+	BadgeHarder           = "harder"
+	BadgeInsufficientData = "insufficient_data"
+)
+
+// Badge summarises the calibration against the authored label, empty if
+// the question was never calibrated
+func (c *Calibration) Badge(label Difficulty) string {
+	switch {
+	case c == nil:
+		return ""
+	case c.InsufficientData:
+		return BadgeInsufficientData
+	case DifficultyRank(c.SuggestedDifficulty) > DifficultyRank(label):
+		return BadgeHarder
+	case DifficultyRank(c.SuggestedDifficulty) < DifficultyRank(label):
+		return BadgeEasier
+	default:
+		return BadgeCalibrated
+	}
+}
+
+// DifficultyRank orders difficulties from 0 (Easy) to 2 (Hard), -1 if unknown
+func DifficultyRank(d Difficulty) int {
+	switch d {
+	case Easy:
+		return 0
+	case Medium:
+		return 1
+	case Hard:
+		return 2
+	}
+	return -1
+}
+
+// a question whose suggested difficulty differs from its label
+type CalibrationReportItem struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Difficulty  Difficulty  `json:"difficulty"`
+	Calibration Calibration `json:"calibration"`
+}
+
+// response of GET /questions/calibration/report
+type CalibrationReportResponse struct {
+	Total int                     `json:"total"`
+	Items []CalibrationReportItem `json:"items"`
+}
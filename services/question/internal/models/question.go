@@ -1,17 +1,41 @@
 package models
 
-import "time"
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
 type Question struct {
 	ID             int        `json:"id" bson:"id"`                 // int uuid
 	Title          string     `json:"title" bson:"title"`           // question title
 	Difficulty     Difficulty `json:"difficulty" bson:"difficulty"` // enum
 	TopicTags      []string   `json:"topic_tags,omitempty" bson:"topic_tags,omitempty" validate:"max=10"`
-	PromptMarkdown string     `json:"prompt_markdown" bson:"prompt_markdown"`
+	PromptMarkdown string     `json:"prompt_markdown" bson:"prompt_markdown"` // sanitized on save, see internal/markdown
 	Constraints    string     `json:"constraints,omitempty" bson:"constraints,omitempty"`
 	TestCases      []TestCase `json:"test_cases,omitempty" bson:"test_cases,omitempty"`
 	ImageURLs      []string   `json:"image_urls,omitempty" bson:"image_urls,omitempty" validate:"max=5"` // optional; need to validate urls when used
 
+	Execution *ExecutionConfig `json:"execution,omitempty" bson:"execution,omitempty"` // optional run constraints applied by collab
+
+	// optional self-assessment checklist pairs tick off before ending a session
+	Rubric Rubric `json:"rubric,omitempty" bson:"rubric,omitempty"`
+
+	// optional randomization of the examples (test cases) served with a ?seed=
+	ShuffleExamples  bool              `json:"shuffleExamples,omitempty" bson:"shuffle_examples,omitempty"`
+	ExampleGenerator *ExampleGenerator `json:"exampleGenerator,omitempty" bson:"example_generator,omitempty"`
+
+	// the description as authored, before sanitizing; only served to admins
+	PromptMarkdownOriginal string `json:"-" bson:"prompt_markdown_original,omitempty"`
+
+	// written by the calibration job only; served to admins through the
+	// calibration report and as CalibrationBadge in admin lists
+	Calibration      *Calibration `json:"-" bson:"calibration,omitempty"`
+	CalibrationBadge string       `json:"calibrationBadge,omitempty" bson:"-"`
+
 	Status           Status     `json:"status,omitempty" bson:"status,omitempty"` // active or deprecated. read the struct for more deets
 	Author           string     `json:"author,omitempty" bson:"author,omitempty"`
 	CreatedAt        time.Time  `json:"created_at" bson:"created_at"`
@@ -44,3 +68,183 @@ type TestCase struct {
 	Output      string `json:"output" bson:"output" validate:"required"`
 	Description string `json:"description,omitempty" bson:"description,omitempty"` // optional test case description
 }
+
+// example parameter types
+const (
+	ParamInt      = "int"
+	ParamIntArray = "int_array"
+)
+
+// generates Count examples from random parameter values
+// each example's input lists the parameters as "name = value" lines and its
+// output is the Output expression evaluated over them (see package examples)
+type ExampleGenerator struct {
+	Count  int            `json:"count" bson:"count"`
+	Params []ExampleParam `json:"params" bson:"params"`
+	Output string         `json:"output" bson:"output"`
+}
+
+// a generated parameter: an int in [Min, Max], or an int_array of such values
+// whose length is in [MinLength, MaxLength]
+type ExampleParam struct {
+	Name      string `json:"name" bson:"name"`
+	Type      string `json:"type" bson:"type"`
+	Min       int64  `json:"min" bson:"min"`
+	Max       int64  `json:"max" bson:"max"`
+	MinLength int    `json:"minLength,omitempty" bson:"min_length,omitempty"`
+	MaxLength int    `json:"maxLength,omitempty" bson:"max_length,omitempty"`
+}
+
+// supported sandbox languages, kept in sync with the collab/sandbox services
+var SupportedLanguages = []string{"python", "java", "cpp", "javascript", "go", "rust"}
+
+// upper bounds a question may request; collab clamps to its own ceilings too
+const (
+	MaxExecutionWallTimeMs int64 = 30000
+	MaxExecutionMemoryMb   int64 = 2048
+)
+
+// run argument and environment rules, kept in sync with the sandbox service
+const (
+	MaxExecutionArgs        = 16
+	MaxExecutionArgLen      = 256
+	MaxExecutionEnvValueLen = 1024
+)
+
+var (
+	envKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	deniedEnv     = map[string]bool{"PATH": true, "LD_PRELOAD": true, "HOME": true}
+)
+
+// optional execution constraints for a question
+// rooms using the question only offer AllowedLanguages and default runs to Limits
+// Args are passed to every run and Env set on it; users can add to but not override them
+type ExecutionConfig struct {
+	AllowedLanguages []string          `json:"allowedLanguages,omitempty" bson:"allowed_languages,omitempty"`
+	Limits           *ExecutionLimits  `json:"limits,omitempty" bson:"limits,omitempty"`
+	Args             []string          `json:"args,omitempty" bson:"args,omitempty"`
+	Env              map[string]string `json:"env,omitempty" bson:"env,omitempty"`
+}
+
+type ExecutionLimits struct {
+	WallTimeMs int64 `json:"wallTimeMs,omitempty" bson:"wall_time_ms,omitempty"`
+	MemoryMb   int64 `json:"memoryMb,omitempty" bson:"memory_mb,omitempty"`
+}
+
+// Validate checks the execution metadata and returns one detail per invalid field
+func (e *ExecutionConfig) Validate() []ValidationErrorDetail {
+	if e == nil {
+		return nil
+	}
+
+	var details []ValidationErrorDetail
+	seen := make(map[string]bool, len(e.AllowedLanguages))
+	for _, lang := range e.AllowedLanguages {
+		if !isSupportedLanguage(lang) {
+			details = append(details, ValidationErrorDetail{
+				Field:  "execution.allowedLanguages",
+				Reason: "unsupported language: " + lang,
+			})
+			continue
+		}
+		if seen[lang] {
+			details = append(details, ValidationErrorDetail{
+				Field:  "execution.allowedLanguages",
+				Reason: "duplicate language: " + lang,
+			})
+		}
+		seen[lang] = true
+	}
+
+	if e.Limits != nil {
+		if e.Limits.WallTimeMs < 0 || e.Limits.WallTimeMs > MaxExecutionWallTimeMs {
+			details = append(details, ValidationErrorDetail{
+				Field:  "execution.limits.wallTimeMs",
+				Reason: "must be between 0 and 30000",
+			})
+		}
+		if e.Limits.MemoryMb < 0 || e.Limits.MemoryMb > MaxExecutionMemoryMb {
+			details = append(details, ValidationErrorDetail{
+				Field:  "execution.limits.memoryMb",
+				Reason: "must be between 0 and 2048",
+			})
+		}
+	}
+
+	if len(e.Args) > MaxExecutionArgs {
+		details = append(details, ValidationErrorDetail{
+			Field:  "execution.args",
+			Reason: "at most 16 arguments are allowed",
+		})
+	}
+	for i, arg := range e.Args {
+		if len(arg) > MaxExecutionArgLen {
+			details = append(details, ValidationErrorDetail{
+				Field:  "execution.args[" + strconv.Itoa(i) + "]",
+				Reason: "must be at most 256 characters",
+			})
+		}
+	}
+	keys := make([]string, 0, len(e.Env))
+	for key := range e.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := "execution.env." + key
+		switch {
+		case !envKeyPattern.MatchString(key):
+			details = append(details, ValidationErrorDetail{Field: field, Reason: "name must match [A-Z_][A-Z0-9_]*"})
+		case deniedEnv[key] || strings.HasPrefix(key, "SANDBOX_"):
+			details = append(details, ValidationErrorDetail{Field: field, Reason: "variable may not be set"})
+		case len(e.Env[key]) > MaxExecutionEnvValueLen:
+			details = append(details, ValidationErrorDetail{Field: field, Reason: "value must be at most 1024 bytes"})
+		}
+	}
+
+	return details
+}
+
+func isSupportedLanguage(lang string) bool {
+	for _, supported := range SupportedLanguages {
+		if lang == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// rubric size bounds; a question without a rubric is also valid
+const (
+	MinRubricItems   = 3
+	MaxRubricItems   = 10
+	MaxRubricItemLen = 200
+)
+
+// acceptance criteria such as "handles empty input" or "O(n log n) or better"
+type Rubric []string
+
+// Validate checks the rubric and returns one detail per invalid field
+func (r Rubric) Validate() []ValidationErrorDetail {
+	if len(r) == 0 {
+		return nil
+	}
+
+	var details []ValidationErrorDetail
+	if len(r) < MinRubricItems || len(r) > MaxRubricItems {
+		details = append(details, ValidationErrorDetail{
+			Field:  "rubric",
+			Reason: "must have between 3 and 10 items",
+		})
+	}
+	for i, item := range r {
+		field := "rubric[" + strconv.Itoa(i) + "]"
+		switch {
+		case strings.TrimSpace(item) == "":
+			details = append(details, ValidationErrorDetail{Field: field, Reason: "must not be empty"})
+		case utf8.RuneCountInString(item) > MaxRubricItemLen:
+			details = append(details, ValidationErrorDetail{Field: field, Reason: "must be at most 200 characters"})
+		}
+	}
+	return details
+}
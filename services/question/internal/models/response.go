@@ -40,3 +40,22 @@ type ValidationErrorDetail struct {
 	Field  string `json:"field"`
 	Reason string `json:"reason"`
 }
+
+// request body of POST /questions/preview
+type PreviewRequest struct {
+	PromptMarkdown string `json:"prompt_markdown"`
+}
+
+// sanitized description as it would be stored, and its rendering
+type PreviewResponse struct {
+	PromptMarkdown string `json:"prompt_markdown"`
+	HTML           string `json:"html"`
+	Escaped        bool   `json:"escaped"` // sanitizing failed and the whole body was escaped
+}
+
+// response of GET /questions/{id}/original
+type OriginalDescriptionResponse struct {
+	ID                     int    `json:"id"`
+	PromptMarkdown         string `json:"prompt_markdown"`
+	PromptMarkdownOriginal string `json:"prompt_markdown_original"`
+}
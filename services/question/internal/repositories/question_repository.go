@@ -19,8 +19,9 @@ import (
 // TODO: update all the methods to interact with the actual database
 
 type QuestionRepository struct {
-	col    *mongo.Collection
-	logger *zap.Logger
+	col     *mongo.Collection
+	results *mongo.Collection // session results reported by collab, see RecordSessionResult
+	logger  *zap.Logger
 }
 
 // Creates a new MongoDB-backed repository
@@ -86,7 +87,21 @@ func NewQuestionRepository(ctx context.Context) (*QuestionRepository, error) {
 		logger.Error("Failed to create compound index on 'status', 'difficulty', 'topic_tags'", zap.Error(err))
 	}
 
-	return &QuestionRepository{col: col, logger: logger}, nil
+	resultsColName := os.Getenv("QUESTION_RESULTS_COLLECTION")
+	if resultsColName == "" {
+		resultsColName = "session_results"
+	}
+	results := db.Collection(resultsColName)
+
+	// calibration reads the results of one window at a time
+	_, err = results.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"recorded_at": 1},
+	})
+	if err != nil {
+		logger.Error("Failed to create index on 'recorded_at'", zap.Error(err))
+	}
+
+	return &QuestionRepository{col: col, results: results, logger: logger}, nil
 }
 
 // Get all questions
@@ -267,6 +282,48 @@ func (r *QuestionRepository) GetRandom(topics []string, difficulty string) (*mod
 	return &picked, nil
 }
 
+// Record a session's result for a question
+func (r *QuestionRepository) RecordSessionResult(result *models.SessionResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.results.InsertOne(ctx, result)
+	return err
+}
+
+// Get the session results recorded in [from, to)
+func (r *QuestionRepository) GetSessionResults(from, to time.Time) ([]models.SessionResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := r.results.Find(ctx, bson.M{"recorded_at": bson.M{"$gte": from, "$lt": to}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []models.SessionResult
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Set a question's calibration without touching the authored fields
+func (r *QuestionRepository) SetCalibration(id int, cal *models.Calibration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.col.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"calibration": cal}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 var (
 	ErrNotFound       = errors.New("question not found")
 	ErrNotImplemented = errors.New("not implemented")
@@ -1,19 +1,33 @@
 package routers
 
 import (
+	"peerprep/question/internal/auth"
 	"peerprep/question/internal/handlers"
 
 	"github.com/go-chi/chi/v5"
 )
 
-func QuestionRoutes(r *chi.Mux, questionHandler *handlers.QuestionHandler, healthHandler *handlers.HealthHandler) {
+func QuestionRoutes(r *chi.Mux, questionHandler *handlers.QuestionHandler, calibrationHandler *handlers.CalibrationHandler, healthHandler *handlers.HealthHandler, keyAuth *auth.KeyAuth) {
 	r.Route("/api/v1/questions", func(r chi.Router) {
-		r.Get("/", questionHandler.GetQuestionsHandler)
-		r.Post("/", questionHandler.CreateQuestionHandler)
-		r.Get("/{id}", questionHandler.GetQuestionByIDHandler)
-		r.Put("/{id}", questionHandler.UpdateQuestionHandler)
-		r.Delete("/{id}", questionHandler.DeleteQuestionHandler)
-		r.Get("/random", questionHandler.GetRandomQuestionHandler)
+		// reads are open unless QUESTION_READ_AUTH is set
+		r.Group(func(r chi.Router) {
+			r.Use(keyAuth.ReadKey)
+			r.Get("/", questionHandler.GetQuestionsHandler)
+			r.Get("/{id}", questionHandler.GetQuestionByIDHandler)
+			r.Get("/random", questionHandler.GetRandomQuestionHandler)
+			r.Post("/preview", questionHandler.PreviewHandler)
+		})
+
+		// mutations always need a service key
+		r.Group(func(r chi.Router) {
+			r.Use(keyAuth.RequireKey)
+			r.Post("/", questionHandler.CreateQuestionHandler)
+			r.Put("/{id}", questionHandler.UpdateQuestionHandler)
+			r.Delete("/{id}", questionHandler.DeleteQuestionHandler)
+			r.Get("/{id}/original", questionHandler.GetOriginalDescriptionHandler)
+			r.Post("/{id}/session-results", calibrationHandler.RecordSessionResultHandler)
+			r.Get("/calibration/report", calibrationHandler.CalibrationReportHandler)
+		})
 
 		r.Get("/healthz", healthHandler.HealthzHandler)
 		r.Get("/readyz", healthHandler.ReadyzHandler)
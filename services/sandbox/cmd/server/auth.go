@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the request body, keyed with
+// SANDBOX_SHARED_SECRET, that the services calling the sandbox sign runs with
+const signatureHeader = "X-Sandbox-Signature"
+
+var (
+	sharedSecret string
+	// requireSignature is set by setupRunAuth unless SANDBOX_AUTH_DISABLED
+	// turns the check off for local development
+	requireSignature bool
+)
+
+// setupRunAuth reads the shared secret that runs must be signed with. Without
+// one every run is refused, so a deployment that forgot it fails closed.
+func setupRunAuth() {
+	sharedSecret = os.Getenv("SANDBOX_SHARED_SECRET")
+	requireSignature = os.Getenv("SANDBOX_AUTH_DISABLED") != "true"
+	switch {
+	case !requireSignature:
+		log.Printf("sandbox request signatures are not checked (SANDBOX_AUTH_DISABLED)")
+	case sharedSecret == "":
+		log.Printf("SANDBOX_SHARED_SECRET is not set; every run will be refused")
+	}
+}
+
+// signBody is the signature of body under secret
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRequest checks the signature of r's body, answering 401 when it is
+// missing or does not match. The body is read whole and put back for the
+// handler to decode.
+func signedRequest(w http.ResponseWriter, r *http.Request) bool {
+	if !requireSignature {
+		return true
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_request"})
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !validSignature(r.Header.Get(signatureHeader), body) {
+		unauthorized(w)
+		return false
+	}
+	return true
+}
+
+// validSignature reports whether signature is the signature of body under
+// the shared secret
+func validSignature(signature string, body []byte) bool {
+	got, err := hex.DecodeString(signature)
+	want, _ := hex.DecodeString(signBody(sharedSecret, body))
+	return sharedSecret != "" && err == nil && hmac.Equal(got, want)
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: "unauthorized"})
+}
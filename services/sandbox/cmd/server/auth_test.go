@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"sandbox/internal/runtime"
+)
+
+func useSharedSecret(t *testing.T, secret string) {
+	t.Helper()
+	origSecret, origRequire, origExec := sharedSecret, requireSignature, executeFn
+	sharedSecret, requireSignature = secret, true
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+	t.Cleanup(func() { sharedSecret, requireSignature, executeFn = origSecret, origRequire, origExec })
+}
+
+func signedRun(body, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(body))
+	if signature != "" {
+		req.Header.Set(signatureHeader, signature)
+	}
+	rec := httptest.NewRecorder()
+	runHandler(rec, req)
+	return rec
+}
+
+func TestRunHandlerChecksSignature(t *testing.T) {
+	useSharedSecret(t, "shh")
+	body := `{"language":"python","code":"print(1)"}`
+
+	if rec := signedRun(body, signBody("shh", []byte(body))); rec.Code != http.StatusOK {
+		t.Fatalf("expected a signed run to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tampered := `{"language":"python","code":"print(2)"}`
+	if rec := signedRun(tampered, signBody("shh", []byte(body))); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a tampered body, got %d", rec.Code)
+	}
+	if rec := signedRun(body, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a signature, got %d", rec.Code)
+	}
+	if rec := signedRun(body, signBody("other", []byte(body))); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for another secret, got %d", rec.Code)
+	}
+}
+
+func TestRunHandlerRefusesRunsWithoutSecret(t *testing.T) {
+	useSharedSecret(t, "")
+	body := `{"language":"python","code":"print(1)"}`
+	if rec := signedRun(body, signBody("", []byte(body))); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when no secret is configured, got %d", rec.Code)
+	}
+}
+
+func TestBatchRunHandlerChecksSignature(t *testing.T) {
+	useSharedSecret(t, "shh")
+	rec := httptest.NewRecorder()
+	batchRunHandler(rec, httptest.NewRequest(http.MethodPost, "/run/batch", bytes.NewBufferString(`[{"language":"python","code":"1"}]`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unsigned batch, got %d", rec.Code)
+	}
+}
+
+func TestInteractiveHandlerChecksSignature(t *testing.T) {
+	useSharedSecret(t, "shh")
+	stubInteractive(t)
+	srv := httptest.NewServer(http.HandlerFunc(interactiveHandler))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	init := `{"type":"init","language":"python","code":"print(input())"}`
+
+	dial := func(frame, signature string) map[string]interface{} {
+		t.Helper()
+		header := http.Header{}
+		header.Set(signatureHeader, signature)
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		_ = conn.WriteJSON(map[string]string{"type": "kill"})
+		return readEvent(t, conn)
+	}
+
+	if evt := dial(init, signBody("shh", []byte(init))); evt["type"] != "exit" {
+		t.Fatalf("expected a signed session to run, got %v", evt)
+	}
+	tampered := `{"type":"init","language":"python","code":"print(2)"}`
+	if evt := dial(tampered, signBody("shh", []byte(init))); evt["type"] != "error" || evt["data"] != "unauthorized" {
+		t.Fatalf("expected unauthorized for a tampered init, got %v", evt)
+	}
+	if evt := dial(init, signBody("other", []byte(init))); evt["type"] != "error" || evt["data"] != "unauthorized" {
+		t.Fatalf("expected unauthorized for another secret, got %v", evt)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 on an unsigned upgrade, got %v %v", resp, err)
+	}
+}
+
+func TestSetupRunAuth(t *testing.T) {
+	origSecret, origRequire := sharedSecret, requireSignature
+	defer func() { sharedSecret, requireSignature = origSecret, origRequire }()
+
+	t.Setenv("SANDBOX_SHARED_SECRET", "shh")
+	t.Setenv("SANDBOX_AUTH_DISABLED", "")
+	setupRunAuth()
+	if !requireSignature || sharedSecret != "shh" {
+		t.Fatalf("expected signatures to be required, got require=%v secret=%q", requireSignature, sharedSecret)
+	}
+
+	t.Setenv("SANDBOX_AUTH_DISABLED", "true")
+	setupRunAuth()
+	if requireSignature {
+		t.Fatalf("expected SANDBOX_AUTH_DISABLED to skip the check")
+	}
+	if rec := signedRun(`{"language":"python","code":"print(1)"}`, ""); rec.Code == http.StatusUnauthorized {
+		t.Fatalf("expected an unsigned run to pass with the check disabled")
+	}
+}
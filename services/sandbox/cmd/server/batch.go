@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+// maxBatchSize bounds the runs of one POST /run/batch
+const maxBatchSize = 10
+
+// batchResult is the outcome of one run of a batch. Error is the entry's own
+// failure: an invalid request, a run that could not be admitted, or the
+// run's result error.
+type batchResult struct {
+	Index int `json:"index"`
+	runResponse
+	Error   string               `json:"error,omitempty"`
+	Details []runtime.FieldError `json:"details,omitempty"`
+}
+
+// batchRunHandler runs an array of run requests concurrently and answers
+// with their results in request order. Every run takes its own slot of
+// runLimiter, so a batch runs no wider than the limiter allows, and one
+// failing run does not fail the others.
+func batchRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+
+	if !signedRequest(w, r) {
+		return
+	}
+	var reqs []runRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil || len(reqs) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_request"})
+		return
+	}
+	if len(reqs) > maxBatchSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "batch_too_large"})
+		return
+	}
+
+	results := make([]batchResult, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runBatchEntry(r, i, req)
+		}()
+	}
+	wg.Wait()
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+func runBatchEntry(r *http.Request, index int, req runRequest) batchResult {
+	res := batchResult{Index: index}
+	limits, inv, status, errResp := resolveRun(r, req)
+	if status != 0 {
+		res.Error, res.Details = errResp.Error, errResp.Details
+		return res
+	}
+	if _, code := acquireRun(r.Context()); code != "" {
+		res.Error = code
+		return res
+	}
+	defer runLimiter.Release()
+
+	lang := runtime.Language(req.Language)
+	started := time.Now()
+	result, err := executeFn(r.Context(), lang, req.Code, limits, inv)
+	recordAudit(r, lang, req.source(), limits, result, err, started)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	runWatchdog.RecordRun(result.Error)
+	res.runResponse = runResponse{Result: result, Limits: limitsToConfig(limits), Args: inv.Args, Env: inv.Env}
+	res.Error = result.Error
+	return res
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+func TestBatchRunHandlerKeepsRequestOrder(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	executeFn = func(_ context.Context, _ runtime.Language, code string, _ runtime.Limits, _ runtime.Invocation) (runtime.Result, error) {
+		// Later entries finish first
+		var n int
+		fmt.Sscanf(code, "print(%d)", &n)
+		time.Sleep(time.Duration(5-n) * 5 * time.Millisecond)
+		return runtime.Result{Stdout: fmt.Sprintf("%d\n", n)}, nil
+	}
+
+	var entries []string
+	for i := 0; i < 5; i++ {
+		entries = append(entries, fmt.Sprintf(`{"language":"python","code":"print(%d)"}`, i))
+	}
+	rec := httptest.NewRecorder()
+	batchRunHandler(rec, httptest.NewRequest(http.MethodPost, "/run/batch", strings.NewReader("["+strings.Join(entries, ",")+"]")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []batchResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil || len(results) != 5 {
+		t.Fatalf("expected five results, got %v (err=%v)", results, err)
+	}
+	for i, res := range results {
+		if res.Index != i || res.Stdout != fmt.Sprintf("%d\n", i) || res.Error != "" {
+			t.Fatalf("expected result %d in place, got %+v", i, res)
+		}
+	}
+}
+
+func TestBatchRunHandlerRejectsOversizedBatch(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		t.Fatal("an oversized batch must not run")
+		return runtime.Result{}, nil
+	}
+
+	entries := strings.Repeat(`{"language":"python","code":""},`, maxBatchSize+1)
+	rec := httptest.NewRecorder()
+	batchRunHandler(rec, httptest.NewRequest(http.MethodPost, "/run/batch", strings.NewReader("["+strings.TrimSuffix(entries, ",")+"]")))
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "batch_too_large") {
+		t.Fatalf("expected 413 batch_too_large, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	batchRunHandler(rec, httptest.NewRequest(http.MethodPost, "/run/batch", strings.NewReader("[]")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an empty batch to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestBatchRunHandlerReportsFailuresPerEntry(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	executeFn = func(_ context.Context, lang runtime.Language, code string, _ runtime.Limits, _ runtime.Invocation) (runtime.Result, error) {
+		if lang != runtime.LangPython {
+			return runtime.Result{}, errors.New("unsupported_language")
+		}
+		if code == "crash" {
+			return runtime.Result{Exit: runtime.ExitInfo{Code: -1}, Error: "sandbox_error"}, nil
+		}
+		return runtime.Result{Stdout: "ok\n"}, nil
+	}
+
+	payload := `[
+		{"language":"python","code":"print('ok')"},
+		{"language":"cobol","code":""},
+		{"language":"python","code":"crash"},
+		{"language":"python","code":"","env":{"PATH":"/evil"}}
+	]`
+	rec := httptest.NewRecorder()
+	batchRunHandler(rec, httptest.NewRequest(http.MethodPost, "/run/batch", strings.NewReader(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected partial failures to keep the batch 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []batchResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil || len(results) != 4 {
+		t.Fatalf("expected four results, got %v (err=%v)", results, err)
+	}
+	if results[0].Error != "" || results[0].Stdout != "ok\n" {
+		t.Fatalf("expected the first entry to succeed, got %+v", results[0])
+	}
+	if results[1].Error != "unsupported_language" {
+		t.Fatalf("expected the unknown language rejected, got %+v", results[1])
+	}
+	if results[2].Error != "sandbox_error" {
+		t.Fatalf("expected the run's own error, got %+v", results[2])
+	}
+	if results[3].Error != "invalid_invocation" || len(results[3].Details) != 1 {
+		t.Fatalf("expected the denied env rejected with details, got %+v", results[3])
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// runIDHeader carries the ID of a batch run, which POST /run/{runId}/cancel
+// takes
+const runIDHeader = "X-Run-Id"
+
+// runRegistry tracks the batch runs in progress by ID so that they can be
+// cancelled. Cancelling a run's context makes the runtime kill its container
+// and end the run with a cancelled exit.
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]context.CancelFunc
+}
+
+var activeRuns = &runRegistry{runs: make(map[string]context.CancelFunc)}
+
+// start registers a run under a new ID and returns the context it must run
+// with; done unregisters it and must be called once the run is over.
+func (r *runRegistry) start(parent context.Context) (id string, ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(parent)
+	id = newRunID()
+	r.mu.Lock()
+	r.runs[id] = cancel
+	r.mu.Unlock()
+	return id, ctx, func() {
+		r.mu.Lock()
+		delete(r.runs, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the run with the given ID, reporting whether it was still
+// in progress
+func (r *runRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	cancel, ok := r.runs[id]
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// cancelAll cancels every run in progress
+func (r *runRegistry) cancelAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.runs {
+		cancel()
+	}
+}
+
+func newRunID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// cancelRunHandler kills the container of the run named in the path. The
+// run's own request then finishes with exit code -1 and cancelled set.
+func cancelRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if !activeRuns.cancel(r.PathValue("runId")) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "run_not_found"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "cancelled"})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sandbox/internal/runtime"
+)
+
+func TestCancelRunHandlerCancelsRunInProgress(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	started := make(chan struct{})
+	executeFn = func(ctx context.Context, _ runtime.Language, _ string, _ runtime.Limits, _ runtime.Invocation) (runtime.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return runtime.Result{Exit: runtime.ExitInfo{Code: -1, Cancelled: true}}, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run/{runId}/cancel", cancelRunHandler)
+	finished := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"language":"python","code":""}`)))
+		finished <- rec
+	}()
+	<-started
+
+	activeRuns.mu.Lock()
+	var runID string
+	for id := range activeRuns.runs {
+		runID = id
+	}
+	activeRuns.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/run/"+runID+"/cancel", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the run to be cancelled, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	run := <-finished
+	if run.Header().Get(runIDHeader) != runID || !strings.Contains(run.Body.String(), `"exit":{"code":-1,"timedOut":false,"cancelled":true}`) {
+		t.Fatalf("expected a cancelled exit for run %s, got %v %s", runID, run.Header(), run.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/run/"+runID+"/cancel", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "run_not_found") {
+		t.Fatalf("expected a finished run to be gone, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCancelRunHandlerRejectsGet(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run/{runId}/cancel", cancelRunHandler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/run/abc/cancel", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
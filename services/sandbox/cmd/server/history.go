@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"sandbox/internal/history"
+)
+
+// runStore keeps the run history when SANDBOX_HISTORY_DB is enabled
+var runStore *history.Store
+
+type runListResponse struct {
+	Runs []history.Run `json:"runs"`
+	// NextBefore, when set, is the before= of the next page
+	NextBefore uint `json:"nextBefore,omitempty"`
+}
+
+// initDatabase connects to the Postgres database named by the POSTGRES_*
+// environment variables
+func initDatabase() (*gorm.DB, error) {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		envOr("POSTGRES_HOST", "localhost"),
+		envOr("POSTGRES_USER", "postgres"),
+		envOr("POSTGRES_PASSWORD", "postgres"),
+		envOr("POSTGRES_DB", "postgres"),
+		envOr("POSTGRES_PORT", "5432"),
+		envOr("POSTGRES_SSLMODE", "disable"))
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// setupHistory opens the run history store when SANDBOX_HISTORY_DB=true. A
+// database that cannot be reached leaves the history disabled rather than
+// keeping the sandbox from starting.
+func setupHistory() *history.Store {
+	runStore = nil
+	if os.Getenv("SANDBOX_HISTORY_DB") != "true" {
+		return nil
+	}
+	db, err := initDatabase()
+	if err != nil {
+		log.Printf("run history disabled: %v", err)
+		return nil
+	}
+	store, err := history.NewStore(db)
+	if err != nil {
+		log.Printf("run history disabled: failed to migrate database: %v", err)
+		return nil
+	}
+	runStore = store
+	return store
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// runHistoryHandler serves GET /runs, the stored runs newest first. A page
+// holds up to limit runs; nextBefore continues from its last one.
+func runHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !historyRequest(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	filter := history.Filter{Language: q.Get("language")}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_since"})
+			return
+		}
+		filter.Since = since
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || before == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_before"})
+			return
+		}
+		filter.Before = uint(before)
+	}
+
+	runs, err := runStore.List(r.Context(), filter)
+	if err != nil {
+		log.Printf("run history query failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "history_query_failed"})
+		return
+	}
+	resp := runListResponse{Runs: runs}
+	if filter.Limit > 0 && len(runs) == filter.Limit {
+		resp.NextBefore = runs[len(runs)-1].ID
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// runRecordHandler serves GET /runs/{id}
+func runRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if !historyRequest(w, r) {
+		return
+	}
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "run_not_found"})
+		return
+	}
+	run, err := runStore.Get(r.Context(), uint(id))
+	if errors.Is(err, history.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "run_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("run history lookup failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "history_query_failed"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(run)
+}
+
+// historyRequest answers the request itself unless it is an admin's GET and
+// the history is enabled
+func historyRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return false
+	}
+	if !authorizedAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "unauthorized"})
+		return false
+	}
+	if runStore == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "history_unavailable"})
+		return false
+	}
+	return true
+}
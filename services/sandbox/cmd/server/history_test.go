@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"sandbox/internal/audit"
+	"sandbox/internal/history"
+	"sandbox/internal/runtime"
+)
+
+func useHistoryStore(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	store, err := history.NewStore(db)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	origStore, origToken := runStore, adminToken
+	runStore, adminToken = store, "secret"
+	t.Cleanup(func() { runStore, adminToken = origStore, origToken })
+	return db
+}
+
+func getHistory(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if id, ok := strings.CutPrefix(req.URL.Path, "/runs/"); ok {
+		req.SetPathValue("id", id)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestRunHistoryHandlers(t *testing.T) {
+	useHistoryStore(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, lang := range []string{"python", "java", "python"} {
+		rec := audit.Record{Timestamp: base.Add(time.Duration(i) * time.Hour), Language: lang, Service: "collab"}
+		if err := runStore.Write(context.Background(), rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	rec := getHistory(runHistoryHandler, "/runs?language=python&since=2025-01-01T00:00:00Z&limit=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var page runListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Runs) != 1 || page.Runs[0].ID != 3 || page.NextBefore != 3 {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	rec = getHistory(runHistoryHandler, "/runs?language=python&limit=1&before=3")
+	page = runListResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Runs) != 1 || page.Runs[0].ID != 1 {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+
+	rec = getHistory(runRecordHandler, "/runs/2")
+	var run history.Run
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || run.ID != 2 || run.Language != "java" || run.Service != "collab" {
+		t.Fatalf("unexpected run %d %+v", rec.Code, run)
+	}
+
+	for _, query := range []string{"since=yesterday", "limit=0", "before=x"} {
+		if rec := getHistory(runHistoryHandler, "/runs?"+query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d", query, rec.Code)
+		}
+	}
+	for _, id := range []string{"99", "abc"} {
+		if rec := getHistory(runRecordHandler, "/runs/"+id); rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for run %q, got %d", id, rec.Code)
+		}
+	}
+}
+
+func TestRunHistoryRequiresAdminAndStore(t *testing.T) {
+	useHistoryStore(t)
+	rec := httptest.NewRecorder()
+	runHistoryHandler(rec, httptest.NewRequest(http.MethodGet, "/runs", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+
+	runStore = nil
+	if rec := getHistory(runHistoryHandler, "/runs"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a store, got %d", rec.Code)
+	}
+}
+
+func TestRunSucceedsWhenHistoryDatabaseIsDown(t *testing.T) {
+	db := useHistoryStore(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("db: %v", err)
+	}
+	sqlDB.Close()
+
+	origExec, origLogger := executeFn, auditLogger
+	defer func() { executeFn, auditLogger = origExec, origLogger }()
+	auditLogger = audit.NewLogger(1, nil, runStore)
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(`{"language":"python","code":"print(1)"}`)))
+	auditLogger.Close()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the run to succeed despite the database, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
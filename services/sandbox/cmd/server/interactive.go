@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sandbox/internal/metrics"
+	"sandbox/internal/runtime"
+)
+
+const (
+	interactiveInitTimeout  = 10 * time.Second
+	interactiveWriteTimeout = 10 * time.Second
+	interactiveMaxMessage   = 256 * 1024
+	interactiveStderrHead   = 4096
+)
+
+// interactiveSession is the part of runtime.InteractiveSession the handler
+// drives; tests substitute their own.
+type interactiveSession interface {
+	WriteStdin(p []byte) error
+	CloseStdin() error
+	Kill()
+	Done() <-chan struct{}
+	Exit() runtime.InteractiveExit
+}
+
+var startInteractiveFn = func(ctx context.Context, lang runtime.Language, code string, opts runtime.InteractiveOptions, emit func(runtime.Event)) (interactiveSession, error) {
+	s, err := runtime.StartInteractive(ctx, lang, code, opts, emit)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Only other services reach the sandbox, so any origin is accepted.
+var interactiveUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// interactiveMessage is a client frame: init (language, code, preset, limits),
+// stdin (data, eof) or kill.
+type interactiveMessage struct {
+	Type     string        `json:"type"`
+	Language string        `json:"language,omitempty"`
+	Code     string        `json:"code,omitempty"`
+	Preset   string        `json:"preset,omitempty"`
+	Limits   *limitsConfig `json:"limits,omitempty"`
+	Data     string        `json:"data,omitempty"`
+	EOF      bool          `json:"eof,omitempty"`
+}
+
+// interactiveHandler runs one program per connection with a live stdin.
+// Output streams back as stdout/stderr events and the connection closes
+// after the exit event. The signature header of the upgrade covers the init
+// frame, which is checked once it arrives.
+func interactiveHandler(w http.ResponseWriter, r *http.Request) {
+	signature := r.Header.Get(signatureHeader)
+	if requireSignature && signature == "" {
+		unauthorized(w)
+		return
+	}
+	conn, err := interactiveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	defer conn.Close()
+	conn.SetReadLimit(interactiveMaxMessage)
+
+	var writeMu sync.Mutex
+	var stderr strings.Builder
+	send := func(evt runtime.Event) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if evt.Type == "stderr" && stderr.Len() < interactiveStderrHead {
+			stderr.WriteString(evt.Data.(string))
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(interactiveWriteTimeout))
+		_ = conn.WriteJSON(evt)
+	}
+
+	var init interactiveMessage
+	_ = conn.SetReadDeadline(time.Now().Add(interactiveInitTimeout))
+	_, data, err := conn.ReadMessage()
+	if err == nil && requireSignature && !validSignature(signature, data) {
+		send(runtime.Event{Type: "error", Data: "unauthorized"})
+		return
+	}
+	if err != nil || json.Unmarshal(data, &init) != nil || init.Type != "init" {
+		send(runtime.Event{Type: "error", Data: "invalid_request"})
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	lang := runtime.Language(init.Language)
+	requested := limitsFromConfig(init.Limits)
+	limits, err := runtime.ResolveLimits(lang, init.Preset, requested)
+	if err != nil {
+		send(runtime.Event{Type: "error", Data: err.Error()})
+		return
+	}
+	if !networkAllowed(r, limits) {
+		send(runtime.Event{Type: "error", Data: "network_not_allowed"})
+		return
+	}
+	// The wall time of a session is how long someone may keep typing, so it
+	// is not capped by the batch presets
+	limits.WallTime = requested.WallTime
+	if runWatchdog.Degraded() {
+		metrics.IncWatchdogRejected()
+		send(runtime.Event{Type: "error", Data: "sandbox_unavailable"})
+		return
+	}
+	started := time.Now()
+	sess, err := startInteractiveFn(r.Context(), lang, init.Code, runtime.InteractiveOptions{
+		Limits:         limits,
+		MaxOutputBytes: limits.MaxOutputBytes,
+		Limiter:        runLimiter,
+	}, send)
+	if err != nil {
+		send(runtime.Event{Type: "error", Data: runtime.ErrorCode(err)})
+		return
+	}
+
+	go func() {
+		for {
+			var msg interactiveMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				sess.Kill() // client went away
+				return
+			}
+			switch msg.Type {
+			case "stdin":
+				if msg.Data != "" {
+					if err := sess.WriteStdin([]byte(msg.Data)); err != nil && !errors.Is(err, runtime.ErrSessionClosed) {
+						send(runtime.Event{Type: "error", Data: "stdin_failed"})
+					}
+				}
+				if msg.EOF {
+					_ = sess.CloseStdin()
+				}
+			case "kill":
+				sess.Kill()
+			default:
+				send(runtime.Event{Type: "error", Data: "unknown_type"})
+			}
+		}
+	}()
+
+	<-sess.Done()
+	exit := sess.Exit()
+	writeMu.Lock()
+	result := runtime.Result{
+		Stderr: stderr.String(),
+		Exit:   runtime.ExitInfo{Code: exit.Code, TimedOut: exit.TimedOut},
+		Error:  exit.Error,
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	writeMu.Unlock()
+	runWatchdog.RecordRun(exit.Error)
+	recordAudit(r, lang, init.Code, limits, result, nil, started)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sandbox/internal/audit"
+	"sandbox/internal/runtime"
+)
+
+// echoSession echoes stdin back as stdout and exits on EOF or kill.
+type echoSession struct {
+	emit func(runtime.Event)
+	done chan struct{}
+	once sync.Once
+	exit runtime.InteractiveExit
+}
+
+func (s *echoSession) WriteStdin(p []byte) error {
+	s.emit(runtime.Event{Type: "stdout", Data: string(p)})
+	return nil
+}
+
+func (s *echoSession) CloseStdin() error {
+	s.finish(runtime.InteractiveExit{Code: 0})
+	return nil
+}
+
+func (s *echoSession) Kill() { s.finish(runtime.InteractiveExit{Code: -1, Reason: runtime.StopKilled}) }
+
+func (s *echoSession) Done() <-chan struct{} { return s.done }
+
+func (s *echoSession) Exit() runtime.InteractiveExit { return s.exit }
+
+func (s *echoSession) finish(exit runtime.InteractiveExit) {
+	s.once.Do(func() {
+		s.exit = exit
+		s.emit(runtime.Event{Type: "exit", Data: exit})
+		close(s.done)
+	})
+}
+
+func dialInteractive(t *testing.T) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(interactiveHandler))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var evt map[string]interface{}
+	if err := conn.ReadJSON(&evt); err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	return evt
+}
+
+func stubInteractive(t *testing.T) *runtime.InteractiveOptions {
+	t.Helper()
+	orig := startInteractiveFn
+	t.Cleanup(func() { startInteractiveFn = orig })
+	var got runtime.InteractiveOptions
+	startInteractiveFn = func(_ context.Context, lang runtime.Language, _ string, opts runtime.InteractiveOptions, emit func(runtime.Event)) (interactiveSession, error) {
+		if lang != runtime.LangPython {
+			return nil, runtime.ErrDockerUnavailable
+		}
+		got = opts
+		return &echoSession{emit: emit, done: make(chan struct{})}, nil
+	}
+	return &got
+}
+
+func TestInteractiveHandlerStdinRoundTrip(t *testing.T) {
+	opts := stubInteractive(t)
+	origLogger := auditLogger
+	defer func() { auditLogger = origLogger }()
+	sink := &captureSink{records: make(chan audit.Record, 1)}
+	auditLogger = audit.NewLogger(1, nil, sink)
+	conn := dialInteractive(t)
+
+	if err := conn.WriteJSON(map[string]interface{}{
+		"type": "init", "language": "python", "code": "print(input())",
+		"limits": map[string]int64{"wallTimeMs": 30000},
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "stdin", "data": "hello\n"}); err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	if evt := readEvent(t, conn); evt["type"] != "stdout" || evt["data"] != "hello\n" {
+		t.Fatalf("expected echoed stdout, got %v", evt)
+	}
+	pyDefaults := runtime.ProfileFor(runtime.LangPython).Defaults()
+	if opts.Limits.WallTime != 30*time.Second || opts.Limiter != runLimiter ||
+		opts.Limits.MemoryB != pyDefaults.MemoryB || opts.MaxOutputBytes != pyDefaults.MaxOutputBytes {
+		t.Fatalf("unexpected session options: %+v", *opts)
+	}
+
+	_ = conn.WriteJSON(map[string]interface{}{"type": "stdin", "eof": true})
+	evt := readEvent(t, conn)
+	if evt["type"] != "exit" || evt["data"].(map[string]interface{})["code"] != float64(0) {
+		t.Fatalf("expected exit, got %v", evt)
+	}
+	var rest map[string]interface{}
+	if err := conn.ReadJSON(&rest); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected normal close after exit, got %v", err)
+	}
+
+	select {
+	case rec := <-sink.records:
+		if rec.Language != "python" || rec.CodeHash != audit.HashCode("print(input())") || rec.ExitCode != 0 {
+			t.Fatalf("unexpected audit record: %+v", rec)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected audit record to be written")
+	}
+}
+
+func TestInteractiveHandlerKill(t *testing.T) {
+	stubInteractive(t)
+	conn := dialInteractive(t)
+
+	_ = conn.WriteJSON(map[string]string{"type": "init", "language": "python"})
+	_ = conn.WriteJSON(map[string]string{"type": "kill"})
+	evt := readEvent(t, conn)
+	if evt["type"] != "exit" || evt["data"].(map[string]interface{})["reason"] != runtime.StopKilled {
+		t.Fatalf("expected killed exit, got %v", evt)
+	}
+}
+
+func TestInteractiveHandlerRejectsBadInit(t *testing.T) {
+	stubInteractive(t)
+
+	conn := dialInteractive(t)
+	_ = conn.WriteJSON(map[string]string{"type": "stdin", "data": "x"})
+	if evt := readEvent(t, conn); evt["type"] != "error" || evt["data"] != "invalid_request" {
+		t.Fatalf("expected invalid_request, got %v", evt)
+	}
+
+	conn = dialInteractive(t)
+	_ = conn.WriteJSON(map[string]string{"type": "init", "language": "java"})
+	if evt := readEvent(t, conn); evt["type"] != "error" || evt["data"] != "sandbox_unavailable" {
+		t.Fatalf("expected start error, got %v", evt)
+	}
+}
+
+func TestRunHandlerWaitsForLimiter(t *testing.T) {
+	origLimiter := runLimiter
+	defer func() { runLimiter = origLimiter }()
+	runLimiter = runtime.NewLimiter(1)
+	_ = runLimiter.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"language":"python","code":""}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	runHandler(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "sandbox_busy") {
+		t.Fatalf("expected sandbox_busy, got %d %s", rec.Code, rec.Body.String())
+	}
+	runLimiter.Release()
+	if runLimiter.InUse() != 0 {
+		t.Fatalf("rejected run must not hold a slot")
+	}
+}
+
+func TestRunHandlerCapsConcurrentRuns(t *testing.T) {
+	origLimiter, origExecute := runLimiter, executeFn
+	defer func() { runLimiter, executeFn = origLimiter, origExecute }()
+	runLimiter = runtime.NewQueuedLimiter(2, 16, time.Minute)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"language":"python","code":""}`))
+			rec := httptest.NewRecorder()
+			runHandler(rec, req)
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("expected every queued run to finish, got %d", code)
+		}
+	}
+	if peak != 2 {
+		t.Fatalf("expected at most 2 runs at once, peak was %d", peak)
+	}
+	if runLimiter.InUse() != 0 || runLimiter.Queued() != 0 {
+		t.Fatalf("expected an idle limiter, in use=%d queued=%d", runLimiter.InUse(), runLimiter.Queued())
+	}
+}
+
+func TestRunHandlerRejectsRunsPastQueueTimeout(t *testing.T) {
+	origLimiter := runLimiter
+	defer func() { runLimiter = origLimiter }()
+	runLimiter = runtime.NewQueuedLimiter(1, 1, 20*time.Millisecond)
+	_ = runLimiter.Acquire(context.Background())
+	defer runLimiter.Release()
+
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"language":"python","code":""}`))
+	rec := httptest.NewRecorder()
+	runHandler(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "queue_timeout") {
+		t.Fatalf("expected queue_timeout, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStatsHandlerReportsInFlightAndQueued(t *testing.T) {
+	origLimiter := runLimiter
+	defer func() { runLimiter = origLimiter }()
+	runLimiter = runtime.NewQueuedLimiter(1, 4, time.Minute)
+	_ = runLimiter.Acquire(context.Background())
+
+	acquired := make(chan struct{})
+	go func() {
+		_ = runLimiter.Acquire(context.Background())
+		close(acquired)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for runLimiter.Queued() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"inFlight":1,"queued":1,"capacity":1}` {
+		t.Fatalf("unexpected stats %s", body)
+	}
+
+	runLimiter.Release()
+	<-acquired
+	runLimiter.Release()
+}
+
+func TestSetupLanguageDefaults(t *testing.T) {
+	orig := runtime.ProfileFor(runtime.LangJava).Defaults()
+	t.Cleanup(func() { runtime.SetLanguageDefaults(runtime.LangJava, orig) })
+
+	t.Setenv("SANDBOX_JAVA_MEMORY_MB", "1024")
+	t.Setenv("SANDBOX_JAVA_WALL_TIME_SEC", "20")
+	t.Setenv("SANDBOX_JAVA_CPUS", "1.5")
+	t.Setenv("SANDBOX_PYTHON_MEMORY_MB", "nope")
+	setupLanguageDefaults()
+
+	java := runtime.ProfileFor(runtime.LangJava).Defaults()
+	if java.MemoryB != 1024<<20 || java.WallTime != 20*time.Second || java.NanoCPUs != 1_500_000_000 {
+		t.Fatalf("expected java defaults from the environment, got %+v", java)
+	}
+	if python := runtime.ProfileFor(runtime.LangPython).Defaults(); python.MemoryB != 256<<20 {
+		t.Fatalf("expected python to keep its default memory, got %+v", python)
+	}
+}
+
+func TestSetupImages(t *testing.T) {
+	t.Cleanup(func() { runtime.SetImageConfig(runtime.ImageConfig{}) })
+
+	t.Setenv("SANDBOX_IMAGE_JAVA", "eclipse-temurin:21-jdk")
+	t.Setenv("SANDBOX_IMAGE_REGISTRY", "mirror.internal")
+	setupImages()
+
+	if got := runtime.ImageFor(runtime.LangJava); got != "mirror.internal/eclipse-temurin:21-jdk" {
+		t.Fatalf("expected the java image from the environment, got %q", got)
+	}
+	if got := runtime.ImageFor(runtime.LangCPP); got != "mirror.internal/gcc:13" {
+		t.Fatalf("expected cpp to keep its default image on the mirror, got %q", got)
+	}
+}
+
+func TestSetupLimiter(t *testing.T) {
+	origLimiter := runLimiter
+	defer func() { runLimiter = origLimiter }()
+
+	t.Setenv("SANDBOX_MAX_CONCURRENT", "7")
+	setupLimiter()
+	if runLimiter.Capacity() != 7 {
+		t.Fatalf("expected capacity 7, got %d", runLimiter.Capacity())
+	}
+	t.Setenv("SANDBOX_MAX_CONCURRENT", "nope")
+	setupLimiter()
+	if runLimiter.Capacity() != defaultMaxConcurrent {
+		t.Fatalf("expected default capacity, got %d", runLimiter.Capacity())
+	}
+
+	t.Setenv("SANDBOX_MAX_CONCURRENT", "1")
+	t.Setenv("SANDBOX_MAX_QUEUED", "1")
+	t.Setenv("SANDBOX_QUEUE_TIMEOUT_SEC", "1")
+	setupLimiter()
+	_ = runLimiter.Acquire(context.Background())
+	if err := runLimiter.Acquire(context.Background()); !errors.Is(err, runtime.ErrQueueTimeout) {
+		t.Fatalf("expected queue timeout, got %v", err)
+	}
+}
@@ -2,12 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
+	"sandbox/internal/audit"
 	"sandbox/internal/metrics"
 	"sandbox/internal/runtime"
 )
@@ -15,26 +24,105 @@ import (
 var (
 	executeFn      = runtime.Execute
 	warmImagesFn   = runtime.WarmImages
-	listenAndServe = http.ListenAndServe
+	checkReadyFn   = runtime.CheckReady
+	listenAndServe = func(srv *http.Server) error { return srv.ListenAndServe() }
 	logFatalf      = log.Fatalf
+
+	reapContainersFn   = runtime.ReapContainers
+	removeContainersFn = runtime.RemoveContainers
+
+	auditLogger  *audit.Logger
+	auditQuerier audit.Querier
+	adminToken   string
+
+	// runLimiter is shared by batch runs and interactive sessions
+	runLimiter = runtime.NewLimiter(defaultMaxConcurrent)
 )
 
-const imageWarmupTimeout = 2 * time.Minute
+const (
+	imageWarmupTimeout = 2 * time.Minute
+	readinessTimeout   = 2 * time.Second
+	shutdownTimeout    = 30 * time.Second
+	cleanupTimeout     = 10 * time.Second
+	defaultAuditPath   = "/tmp/sandbox-audit.jsonl"
+	defaultRedisAddr   = "redis:6379"
+
+	defaultMaxConcurrent = 4
+	defaultMaxQueued     = 16
+	defaultQueueTimeout  = 10 * time.Second
+)
 
 type runRequest struct {
 	Language string        `json:"language"`
 	Code     string        `json:"code"`
+	Preset   string        `json:"preset,omitempty"`
 	Limits   *limitsConfig `json:"limits,omitempty"`
+
+	// appended to the exec command, set on it and fed to it; see
+	// runtime.Invocation
+	Args  []string          `json:"args,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+	Stdin string            `json:"stdin,omitempty"`
+
+	// Files is a multi-file submission; Code is shorthand for a single
+	// entry file and must be empty when Files is set
+	Files []runtime.SourceFile `json:"files,omitempty"`
+
+	// CollectFiles are workspace files returned as the result's artifacts
+	CollectFiles []string `json:"collectFiles,omitempty"`
 }
 
+// source is what the audit log hashes: the code, or every file's path and
+// content in order
+func (req runRequest) source() string {
+	if len(req.Files) == 0 {
+		return req.Code
+	}
+	var b strings.Builder
+	for _, f := range req.Files {
+		b.WriteString(f.Path + "\n" + f.Content + "\n")
+	}
+	return b.String()
+}
+
+// limitsConfig carries limits over the wire, both as request overrides (zero
+// fields are left to the preset) and as resolved values in responses
 type limitsConfig struct {
-	WallTimeMs  int64 `json:"wallTimeMs"`
-	MemoryBytes int64 `json:"memoryBytes"`
-	NanoCPUs    int64 `json:"nanoCPUs"`
+	WallTimeMs     int64 `json:"wallTimeMs"`
+	MemoryBytes    int64 `json:"memoryBytes"`
+	NanoCPUs       int64 `json:"nanoCPUs"`
+	PidsLimit      int64 `json:"pidsLimit,omitempty"`
+	MaxOutputBytes int   `json:"maxOutputBytes,omitempty"`
+	// AllowNetwork needs the admin token; see networkAllowed
+	AllowNetwork bool `json:"allowNetwork,omitempty"`
+}
+
+// runResponse is the execution result together with the limits, arguments
+// and environment it ran with
+type runResponse struct {
+	runtime.Result
+	Limits limitsConfig      `json:"limits"`
+	Args   []string          `json:"args,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+}
+
+type languageInfo struct {
+	Language    string                  `json:"language"`
+	DisplayName string                  `json:"displayName"`
+	Image       string                  `json:"image"`
+	Compiled    bool                    `json:"compiled"`
+	Defaults    limitsConfig            `json:"defaults"`
+	Presets     map[string]limitsConfig `json:"presets"`
+}
+
+type languagesResponse struct {
+	Languages []languageInfo `json:"languages"`
+	Maxima    limitsConfig   `json:"maxima"`
 }
 
 type errorResponse struct {
-	Error string `json:"error"`
+	Error   string               `json:"error"`
+	Details []runtime.FieldError `json:"details,omitempty"`
 }
 
 func main() {
@@ -43,15 +131,89 @@ func main() {
 		addr = v
 	}
 
+	setupMetrics()
+	setupImages()
+	reapLeftoverContainers()
 	warmSandboxImages()
+	setupRunAuth()
+	setupAudit()
+	setupLimiter()
+	setupLanguageDefaults()
+	setupPool(context.Background())
+	setupResultCache()
+	setupWatchdog()
+	go runWatchdog.Run(context.Background())
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/run", runHandler)
+	mux.HandleFunc("/run/interactive", interactiveHandler)
+	mux.HandleFunc("/run/tests", runTestsHandler)
+	mux.HandleFunc("/run/stream", streamHandler)
+	mux.HandleFunc("/run/batch", batchRunHandler)
+	mux.HandleFunc("/run/{runId}/cancel", cancelRunHandler)
+	mux.HandleFunc("/languages", languagesHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/admin/audit", auditHandler)
+	mux.HandleFunc("/runs", runHistoryHandler)
+	mux.HandleFunc("/runs/{id}", runRecordHandler)
+	mux.HandleFunc("/admin/watchdog", watchdogHandler)
 	mux.Handle("/metrics", metrics.Handler())
 
+	server := &http.Server{Addr: addr, Handler: metrics.Middleware("sandbox")(mux)}
+	shutdownChan := make(chan os.Signal, 1)
+	signal.Notify(shutdownChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(shutdownChan)
+
 	log.Printf("sandbox service listening on %s", addr)
-	if err := listenAndServe(addr, metrics.Middleware("sandbox")(mux)); err != nil {
-		logFatalf("sandbox server failed: %v", err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- listenAndServe(server) }()
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logFatalf("sandbox server failed: %v", err)
+		}
+		return
+	case <-shutdownChan:
+	}
+	shutdown(server)
+}
+
+// shutdown stops taking requests and waits up to shutdownTimeout for the runs
+// in progress. It then cancels whatever is still running and removes every
+// container the process has left, so none outlives it.
+func shutdown(server *http.Server) {
+	log.Printf("sandbox service shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("sandbox shutdown did not finish in time: %v", err)
+	}
+	activeRuns.cancelAll()
+
+	cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cleanupCancel()
+	if removed, err := removeContainersFn(cleanupCtx); err != nil {
+		log.Printf("sandbox container cleanup failed: %v", err)
+	} else if removed > 0 {
+		log.Printf("removed %d sandbox containers on shutdown", removed)
+	}
+	log.Printf("sandbox service exited")
+}
+
+// reapLeftoverContainers removes the containers a previous process left
+// behind when it crashed, before this one starts any
+func reapLeftoverContainers() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	removed, err := reapContainersFn(ctx)
+	if err != nil {
+		log.Printf("sandbox container reaping failed: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("removed %d leftover sandbox containers", removed)
 	}
 }
 
@@ -62,38 +224,465 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !signedRequest(w, r) {
+		return
+	}
+	req, limits, inv, ok := decodeRun(w, r)
+	if !ok {
+		return
+	}
+	lang := runtime.Language(req.Language)
+
+	if !admitRun(r.Context(), w) {
+		return
+	}
+	defer runLimiter.Release()
+
+	runID, ctx, done := activeRuns.start(r.Context())
+	defer done()
+	w.Header().Set(runIDHeader, runID)
+
+	started := time.Now()
+	result, err := executeFn(ctx, lang, req.Code, limits, inv)
+	recordAudit(r, lang, req.source(), limits, result, err, started)
+	if err == nil {
+		runWatchdog.RecordRun(result.Error)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := json.NewEncoder(w).Encode(runResponse{Result: result, Limits: limitsToConfig(limits), Args: inv.Args, Env: inv.Env}); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+// decodeRun reads a run request and resolves its limits and invocation,
+// writing the error response when the request is invalid
+func decodeRun(w http.ResponseWriter, r *http.Request) (runRequest, runtime.Limits, runtime.Invocation, bool) {
 	var req runRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_request"})
+		return req, runtime.Limits{}, runtime.Invocation{}, false
+	}
+	limits, inv, status, errResp := resolveRun(r, req)
+	if status != 0 {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errResp)
+		return req, runtime.Limits{}, runtime.Invocation{}, false
+	}
+	return req, limits, inv, true
+}
+
+// resolveRun resolves the limits and invocation of req. An invalid request
+// gets a non-zero status and the error to answer with.
+func resolveRun(r *http.Request, req runRequest) (runtime.Limits, runtime.Invocation, int, errorResponse) {
+	limits, err := runtime.ResolveLimits(runtime.Language(req.Language), req.Preset, limitsFromConfig(req.Limits))
+	if err != nil {
+		return runtime.Limits{}, runtime.Invocation{}, http.StatusBadRequest, errorResponse{Error: err.Error()}
+	}
+	if !networkAllowed(r, limits) {
+		return runtime.Limits{}, runtime.Invocation{}, http.StatusForbidden, errorResponse{Error: "network_not_allowed"}
+	}
+	inv := runtime.Invocation{Args: req.Args, Env: req.Env, Stdin: req.Stdin, Files: req.Files, CollectFiles: req.CollectFiles}
+	var details []runtime.FieldError
+	var invErr *runtime.InvocationError
+	if errors.As(inv.Validate(), &invErr) {
+		details = append(details, invErr.Fields...)
+	}
+	if errors.As(runtime.ValidateFiles(runtime.Language(req.Language), req.Files), &invErr) {
+		details = append(details, invErr.Fields...)
+	}
+	if len(req.Files) > 0 && req.Code != "" {
+		details = append(details, runtime.FieldError{Field: "code", Reason: "must be empty when files are set"})
+	}
+	if len(details) > 0 {
+		return runtime.Limits{}, runtime.Invocation{}, http.StatusBadRequest, errorResponse{Error: "invalid_invocation", Details: details}
+	}
+	return limits, inv, 0, errorResponse{}
+}
+
+// admitRun takes a slot of runLimiter for a run, writing the error response
+// when it cannot, see acquireRun. Admitted runs must release the slot.
+func admitRun(ctx context.Context, w http.ResponseWriter) bool {
+	if status, code := acquireRun(ctx); status != 0 {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: code})
+		return false
+	}
+	return true
+}
+
+// acquireRun takes a slot of runLimiter, or returns the status and error code
+// to fail the run with: right away while the watchdog holds the daemon
+// degraded, instead of burning the caller's timeout on a run that cannot
+// succeed, or once waiting fails. A full queue or a wait past the queue
+// timeout is 429 so that callers back off.
+func acquireRun(ctx context.Context) (int, string) {
+	if runWatchdog.Degraded() {
+		metrics.IncWatchdogRejected()
+		return http.StatusServiceUnavailable, "sandbox_unavailable"
+	}
+	if err := runLimiter.Acquire(ctx); err != nil {
+		status, code := http.StatusServiceUnavailable, "sandbox_busy"
+		switch {
+		case errors.Is(err, runtime.ErrSandboxDegraded):
+			metrics.IncWatchdogRejected()
+			code = "sandbox_unavailable"
+		case errors.Is(err, runtime.ErrQueueFull), errors.Is(err, runtime.ErrQueueTimeout):
+			status, code = http.StatusTooManyRequests, err.Error()
+		}
+		return status, code
+	}
+	return 0, ""
+}
+
+type statsResponse struct {
+	InFlight int `json:"inFlight"`
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+}
+
+// statsHandler reports how many runs and interactive sessions hold a slot
+// of runLimiter and how many are waiting for one
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statsResponse{
+		InFlight: runLimiter.InUse(),
+		Queued:   runLimiter.Queued(),
+		Capacity: runLimiter.Capacity(),
+	})
+}
+
+// readyzHandler reports whether the service can take runs: the watchdog
+// has not found the daemon degraded, the daemon answers and every language's
+// image is present
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if report := runWatchdog.Report(); report.State == runtime.WatchdogDegraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": string(report.State), "reason": report.Reason})
 		return
 	}
 
-	lang := runtime.Language(req.Language)
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := checkReadyFn(ctx); err != nil {
+		resp := errorResponse{Error: "sandbox_unavailable"}
+		var missing *runtime.MissingImagesError
+		if errors.As(err, &missing) {
+			resp.Error = missing.Error()
+			for _, lang := range missing.Languages {
+				resp.Details = append(resp.Details, runtime.FieldError{Field: string(lang), Reason: "image not pulled"})
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// healthzHandler reports the process alive; it deliberately checks nothing
+// else so that a daemon outage does not get the service restarted
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// languagesHandler lists the supported languages with their default limits
+// and presets, and the ceilings that apply to any override.
+func languagesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+
+	resp := languagesResponse{Maxima: limitsToConfig(runtime.MaxLimits)}
+	for _, lang := range runtime.Languages() {
+		spec, err := runtime.SpecFor(lang)
+		if err != nil {
+			continue
+		}
+		profile := runtime.ProfileFor(lang)
+		info := languageInfo{
+			Language:    string(lang),
+			DisplayName: spec.DisplayName,
+			Image:       runtime.ImageFor(lang),
+			Compiled:    len(spec.CompileCmd) > 0,
+			Defaults:    limitsToConfig(profile.Defaults()),
+			Presets:     make(map[string]limitsConfig, len(runtime.Presets)),
+		}
+		for _, preset := range runtime.Presets {
+			info.Presets[preset] = limitsToConfig(profile[preset])
+		}
+		resp.Languages = append(resp.Languages, info)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func limitsFromConfig(cfg *limitsConfig) runtime.Limits {
 	limits := runtime.Limits{}
-	if req.Limits != nil {
-		if req.Limits.WallTimeMs > 0 {
-			limits.WallTime = time.Duration(req.Limits.WallTimeMs) * time.Millisecond
+	if cfg != nil {
+		if cfg.WallTimeMs > 0 {
+			limits.WallTime = time.Duration(cfg.WallTimeMs) * time.Millisecond
 		}
-		limits.MemoryB = req.Limits.MemoryBytes
-		limits.NanoCPUs = req.Limits.NanoCPUs
+		limits.MemoryB = cfg.MemoryBytes
+		limits.NanoCPUs = cfg.NanoCPUs
+		limits.PidsLimit = cfg.PidsLimit
+		limits.MaxOutputBytes = cfg.MaxOutputBytes
+		limits.AllowNetwork = cfg.AllowNetwork
 	}
+	return limits
+}
+
+// networkAllowed reports whether the caller may run with limits: containers
+// are cut off from the network unless an admin asks otherwise
+func networkAllowed(r *http.Request, limits runtime.Limits) bool {
+	return !limits.AllowNetwork || authorizedAdmin(r)
+}
 
-	ctx := r.Context()
-	result, err := executeFn(ctx, lang, req.Code, limits)
+func limitsToConfig(limits runtime.Limits) limitsConfig {
+	return limitsConfig{
+		WallTimeMs:     limits.WallTime.Milliseconds(),
+		MemoryBytes:    limits.MemoryB,
+		NanoCPUs:       limits.NanoCPUs,
+		PidsLimit:      limits.PidsLimit,
+		MaxOutputBytes: limits.MaxOutputBytes,
+		AllowNetwork:   limits.AllowNetwork,
+	}
+}
+
+// setupLimiter sizes the execution limiter from SANDBOX_MAX_CONCURRENT and
+// bounds its queue to SANDBOX_MAX_QUEUED runs waiting at most
+// SANDBOX_QUEUE_TIMEOUT_SEC each; unset or invalid values keep the defaults.
+func setupLimiter() {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SANDBOX_MAX_CONCURRENT")))
+	if err != nil || n <= 0 {
+		n = defaultMaxConcurrent
+	}
+	maxQueued := envInt("SANDBOX_MAX_QUEUED")
+	if maxQueued <= 0 {
+		maxQueued = defaultMaxQueued
+	}
+	maxWait := envSeconds("SANDBOX_QUEUE_TIMEOUT_SEC")
+	if maxWait <= 0 {
+		maxWait = defaultQueueTimeout
+	}
+	runLimiter = runtime.NewQueuedLimiter(n, maxQueued, maxWait)
+}
+
+// setupImages overrides the image of each language from SANDBOX_IMAGE_<LANG>,
+// e.g. SANDBOX_IMAGE_PYTHON=python:3.12-slim, and prefixes every image that
+// names no registry with SANDBOX_IMAGE_REGISTRY, e.g. mirror.internal:5000.
+// It must run before the images are warmed.
+func setupImages() {
+	cfg := runtime.ImageConfig{
+		Registry: strings.TrimSpace(os.Getenv("SANDBOX_IMAGE_REGISTRY")),
+		Images:   make(map[runtime.Language]string),
+	}
+	for _, lang := range runtime.Languages() {
+		if image := strings.TrimSpace(os.Getenv("SANDBOX_IMAGE_" + strings.ToUpper(string(lang)))); image != "" {
+			cfg.Images[lang] = image
+		}
+	}
+	runtime.SetImageConfig(cfg)
+}
+
+// setupLanguageDefaults overrides the default limits of each language from
+// SANDBOX_<LANG>_WALL_TIME_SEC, SANDBOX_<LANG>_MEMORY_MB and SANDBOX_<LANG>_CPUS,
+// e.g. SANDBOX_JAVA_MEMORY_MB=1024; unset or invalid values keep the built-in
+// defaults. It must run before setupPool so the pool warms at these limits.
+func setupLanguageDefaults() {
+	for _, lang := range runtime.Languages() {
+		prefix := "SANDBOX_" + strings.ToUpper(string(lang)) + "_"
+		overrides := runtime.Limits{
+			WallTime: envSeconds(prefix + "WALL_TIME_SEC"),
+			MemoryB:  int64(envInt(prefix+"MEMORY_MB")) * 1024 * 1024,
+		}
+		if cpus, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv(prefix+"CPUS")), 64); err == nil && cpus > 0 {
+			overrides.NanoCPUs = int64(cpus * 1e9)
+		}
+		runtime.SetLanguageDefaults(lang, overrides)
+	}
+}
+
+// setupMetrics exports the runtime's runs, containers and image pulls
+func setupMetrics() {
+	runtime.SetObserver(runtime.RunObserver{
+		OnRun: func(lang runtime.Language, outcome string, took time.Duration) {
+			metrics.RecordRun(string(lang), outcome, took)
+		},
+		OnContainers: metrics.AddContainersInFlight,
+		OnImagePull:  metrics.IncImagePull,
+	})
+}
+
+// setupPool keeps SANDBOX_POOL_SIZE containers per language warm at the
+// default limits, each serving up to SANDBOX_POOL_MAX_RUNS runs. The pool is
+// off unless a size is set.
+func setupPool(ctx context.Context) {
+	size := envInt("SANDBOX_POOL_SIZE")
+	if size <= 0 {
+		return
+	}
+	pool, err := runtime.NewPool(runtime.PoolConfig{
+		Size:    size,
+		MaxRuns: envInt("SANDBOX_POOL_MAX_RUNS"),
+		OnLease: metrics.IncPoolLease,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+		log.Printf("sandbox pool disabled: %v", err)
 		return
 	}
+	for _, lang := range runtime.Languages() {
+		_ = pool.Warm(lang, runtime.ProfileFor(lang).Defaults())
+	}
+	runtime.SetPool(pool)
+	go pool.Run(ctx)
+	log.Printf("sandbox pool keeping %d containers per language warm", size)
+}
+
+// setupResultCache serves a run identical to one of the last
+// SANDBOX_RESULT_CACHE_SIZE runs, finished within SANDBOX_RESULT_CACHE_TTL_SEC,
+// from that run's result. Unset values take the cache defaults; a size of 0
+// turns the cache off.
+func setupResultCache() {
+	runtime.SetResultCache(nil)
+	if v, ok := os.LookupEnv("SANDBOX_RESULT_CACHE_SIZE"); ok && strings.TrimSpace(v) == "0" {
+		return
+	}
+	runtime.SetResultCache(runtime.NewResultCache(runtime.ResultCacheConfig{
+		Size:     envInt("SANDBOX_RESULT_CACHE_SIZE"),
+		TTL:      envSeconds("SANDBOX_RESULT_CACHE_TTL_SEC"),
+		OnLookup: metrics.IncResultCacheLookup,
+	}))
+}
 
+// setupAudit wires the execution audit log from the environment. The JSONL file
+// is always written; the Redis stream is opt-in and, when enabled, also serves
+// admin queries since it aggregates every sandbox instance. The run history
+// database, when enabled, is written the same way.
+func setupAudit() {
+	adminToken = strings.TrimSpace(os.Getenv("SANDBOX_ADMIN_TOKEN"))
+	auditQuerier = nil
+
+	var sinks []audit.Sink
+	path := os.Getenv("SANDBOX_AUDIT_PATH")
+	if path == "" {
+		path = defaultAuditPath
+	}
+	maxBytes, _ := strconv.ParseInt(os.Getenv("SANDBOX_AUDIT_MAX_BYTES"), 10, 64)
+	fileSink, err := audit.NewFileSink(path, maxBytes)
+	if err != nil {
+		log.Printf("audit file disabled: %v", err)
+	} else {
+		sinks = append(sinks, fileSink)
+		auditQuerier = fileSink
+	}
+
+	if os.Getenv("SANDBOX_AUDIT_REDIS") == "true" {
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = defaultRedisAddr
+		}
+		rdb := redis.NewClient(&redis.Options{Addr: addr})
+		redisSink := audit.NewRedisSink(rdb, os.Getenv("SANDBOX_AUDIT_STREAM"), 0)
+		sinks = append(sinks, redisSink)
+		auditQuerier = redisSink
+	}
+
+	if store := setupHistory(); store != nil {
+		sinks = append(sinks, store)
+	}
+
+	auditLogger = audit.NewLogger(0, metrics.IncAuditDropped, sinks...)
+}
+
+func recordAudit(r *http.Request, lang runtime.Language, code string, limits runtime.Limits, result runtime.Result, execErr error, started time.Time) {
+	if execErr != nil {
+		result = runtime.Result{Exit: runtime.ExitInfo{Code: -1}, Error: execErr.Error()}
+	}
+	rec := audit.NewRecord(lang, code, limits, result, started, time.Since(started))
+	rec.Service = r.Header.Get("X-Requesting-Service")
+	rec.RequestID = r.Header.Get("X-Request-Id")
+	auditLogger.Log(rec)
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if result.Error != "" {
-		w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
 	}
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		log.Printf("failed to encode response: %v", err)
+	if !authorizedAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "unauthorized"})
+		return
+	}
+	if auditQuerier == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "audit_unavailable"})
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Language: q.Get("language"),
+		CodeHash: q.Get("codeHash"),
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_since"})
+			return
+		}
+		filter.Since = since
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := auditQuerier.Query(r.Context(), filter)
+	if err != nil {
+		log.Printf("audit query failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "audit_query_failed"})
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": records})
+}
+
+func authorizedAdmin(r *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
 	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
 }
 
 func warmSandboxImages() {
@@ -11,9 +11,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"sandbox/internal/audit"
 	"sandbox/internal/runtime"
 )
 
@@ -61,7 +64,7 @@ func TestRunHandlerExecuteError(t *testing.T) {
 	var capturedCode string
 	var capturedLimits runtime.Limits
 
-	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits) (runtime.Result, error) {
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
 		capturedLang = lang
 		capturedCode = code
 		capturedLimits = limits
@@ -95,11 +98,119 @@ func TestRunHandlerExecuteError(t *testing.T) {
 	}
 }
 
+func TestRunHandlerInvocation(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+
+	var captured runtime.Invocation
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
+		captured = inv
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+
+	payload := `{"language":"cpp","code":"int main(){}","args":["--mode=fast","input.txt"],"env":{"DEBUG":"1"},"stdin":"3 4\n","collectFiles":["out.csv"]}`
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Join(captured.Args, " ") != "--mode=fast input.txt" || captured.Env["DEBUG"] != "1" || captured.Stdin != "3 4\n" ||
+		!reflect.DeepEqual(captured.CollectFiles, []string{"out.csv"}) {
+		t.Fatalf("invocation not passed to execute: %+v", captured)
+	}
+	var resp runResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if strings.Join(resp.Args, " ") != "--mode=fast input.txt" || resp.Env["DEBUG"] != "1" {
+		t.Fatalf("expected the effective args and env echoed, got %+v", resp)
+	}
+
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		t.Fatal("an invalid invocation must not run")
+		return runtime.Result{}, nil
+	}
+	payload = `{"language":"python","code":"print()","env":{"LD_PRELOAD":"x.so","DOCKER_HOST":"tcp://x","debug":"1"},"collectFiles":["/etc/passwd"]}`
+	rec = httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	var errResp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&errResp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if errResp.Error != "invalid_invocation" || len(errResp.Details) != 4 || errResp.Details[0].Field != "env.DOCKER_HOST" ||
+		errResp.Details[1].Field != "env.LD_PRELOAD" || errResp.Details[2].Field != "env.debug" || errResp.Details[3].Field != "collectFiles[0]" {
+		t.Fatalf("expected field-level errors, got %+v", errResp)
+	}
+}
+
+func TestRunHandlerFiles(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+
+	var captured runtime.Invocation
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
+		captured = inv
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+	payload := `{"language":"java","files":[{"path":"Main.java","content":"class Main {}"},{"path":"util/Helper.java","content":"class Helper {}"}]}`
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(captured.Files) != 2 || captured.Files[1].Path != "util/Helper.java" {
+		t.Fatalf("files not passed to execute: %+v", captured.Files)
+	}
+
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		t.Fatal("an invalid submission must not run")
+		return runtime.Result{}, nil
+	}
+	payload = `{"language":"java","code":"class Main {}","files":[{"path":"Main.java"},{"path":"../../etc/cron.d/x"}]}`
+	rec = httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	var errResp errorResponse
+	_ = json.NewDecoder(rec.Body).Decode(&errResp)
+	if rec.Code != http.StatusBadRequest || errResp.Error != "invalid_invocation" || len(errResp.Details) != 2 ||
+		errResp.Details[0].Field != "files[1].path" || errResp.Details[1].Field != "code" {
+		t.Fatalf("expected the traversal and the extra code rejected, got %d %+v", rec.Code, errResp)
+	}
+}
+
+func TestRunHandlerNetworkNeedsAdmin(t *testing.T) {
+	origExecute, origToken := executeFn, adminToken
+	defer func() { executeFn, adminToken = origExecute, origToken }()
+	adminToken = "secret"
+	var captured runtime.Limits
+	executeFn = func(_ context.Context, _ runtime.Language, _ string, limits runtime.Limits, _ runtime.Invocation) (runtime.Result, error) {
+		captured = limits
+		return runtime.Result{}, nil
+	}
+	payload := `{"language":"python","code":"","limits":{"allowNetwork":true}}`
+
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "network_not_allowed") {
+		t.Fatalf("expected 403 network_not_allowed, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	runHandler(rec, req)
+	if rec.Code != http.StatusOK || !captured.AllowNetwork || !strings.Contains(rec.Body.String(), `"allowNetwork":true`) {
+		t.Fatalf("expected the admin's run to have network, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
 func TestRunHandlerSuccessWithErrorMessage(t *testing.T) {
 	orig := executeFn
 	defer func() { executeFn = orig }()
 
-	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits) (runtime.Result, error) {
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
 		return runtime.Result{
 			Stdout: "out",
 			Error:  "compile_error",
@@ -131,7 +242,7 @@ func TestRunHandlerSuccessNoError(t *testing.T) {
 	orig := executeFn
 	defer func() { executeFn = orig }()
 
-	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits) (runtime.Result, error) {
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
 		return runtime.Result{
 			Stdout: "out",
 			Stderr: "",
@@ -160,7 +271,7 @@ func TestRunHandlerEncodeFailure(t *testing.T) {
 	orig := executeFn
 	defer func() { executeFn = orig }()
 
-	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits) (runtime.Result, error) {
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
 		return runtime.Result{Exit: runtime.ExitInfo{Code: 0}}, nil
 	}
 
@@ -183,15 +294,28 @@ func TestMainFunction(t *testing.T) {
 	origWarm := warmImagesFn
 	origListen := listenAndServe
 	origFatal := logFatalf
+	origReady := checkReadyFn
+	origReap := reapContainersFn
+	origSecret, origRequire := sharedSecret, requireSignature
 	defer func() {
 		executeFn = origExec
 		warmImagesFn = origWarm
 		listenAndServe = origListen
 		logFatalf = origFatal
+		checkReadyFn = origReady
+		reapContainersFn = origReap
+		sharedSecret, requireSignature = origSecret, origRequire
+		runtime.SetResultCache(nil)
 		os.Unsetenv("SANDBOX_HTTP_ADDR")
 	}()
+	checkReadyFn = func(context.Context) error { return nil }
+	var reaps int
+	reapContainersFn = func(context.Context) (int, error) {
+		reaps++
+		return 0, nil
+	}
 
-	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits) (runtime.Result, error) {
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
 		return runtime.Result{}, nil
 	}
 
@@ -205,10 +329,10 @@ func TestMainFunction(t *testing.T) {
 		return nil
 	}
 
-	listenAndServe = func(addr string, handler http.Handler) error {
-		addrs = append(addrs, addr)
-		served = handler
-		return nil
+	listenAndServe = func(srv *http.Server) error {
+		addrs = append(addrs, srv.Addr)
+		served = srv.Handler
+		return http.ErrServerClosed
 	}
 
 	logFatalf = func(format string, args ...interface{}) {
@@ -233,6 +357,14 @@ func TestMainFunction(t *testing.T) {
 		t.Fatalf("expected method not allowed, got %d", rec.Code)
 	}
 
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec = httptest.NewRecorder()
+		served.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to answer 200, got %d", path, rec.Code)
+		}
+	}
+
 	os.Unsetenv("SANDBOX_HTTP_ADDR")
 	served = nil
 
@@ -242,7 +374,7 @@ func TestMainFunction(t *testing.T) {
 		t.Fatalf("expected default addr :8090, got %v", addrs)
 	}
 
-	listenAndServe = func(string, http.Handler) error {
+	listenAndServe = func(*http.Server) error {
 		return errors.New("boom")
 	}
 
@@ -254,6 +386,33 @@ func TestMainFunction(t *testing.T) {
 	if warmCalls < 3 {
 		t.Fatalf("expected warm images to be invoked for each main call, got %d", warmCalls)
 	}
+	if reaps != 3 {
+		t.Fatalf("expected leftover containers to be reaped on every start, got %d", reaps)
+	}
+	if len(fatalMessages) != 1 {
+		t.Fatalf("expected a closed server not to be fatal, got %v", fatalMessages)
+	}
+}
+
+func TestShutdownCancelsRunsAndRemovesContainers(t *testing.T) {
+	orig := removeContainersFn
+	defer func() { removeContainersFn = orig }()
+	var cleanups int
+	removeContainersFn = func(context.Context) (int, error) {
+		cleanups++
+		return 1, nil
+	}
+
+	_, ctx, done := activeRuns.start(context.Background())
+	defer done()
+	shutdown(&http.Server{})
+
+	if ctx.Err() == nil {
+		t.Fatalf("expected the run in progress to be cancelled")
+	}
+	if cleanups != 1 {
+		t.Fatalf("expected the remaining containers to be removed once, got %d", cleanups)
+	}
 }
 
 type failingWriter struct {
@@ -274,3 +433,284 @@ func (f *failingWriter) Write(p []byte) (int, error) {
 func (f *failingWriter) WriteHeader(status int) {
 	f.status = status
 }
+
+type stubQuerier struct {
+	filter  audit.Filter
+	records []audit.Record
+}
+
+func (s *stubQuerier) Query(_ context.Context, f audit.Filter) ([]audit.Record, error) {
+	s.filter = f
+	return s.records, nil
+}
+
+func TestAuditHandlerRequiresAdminToken(t *testing.T) {
+	origToken, origQuerier := adminToken, auditQuerier
+	defer func() { adminToken, auditQuerier = origToken, origQuerier }()
+
+	adminToken = "secret"
+	auditQuerier = &stubQuerier{}
+
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		auditHandler(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %q, got %d", header, rec.Code)
+		}
+	}
+
+	adminToken = ""
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	auditHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected admin endpoint disabled without a configured token, got %d", rec.Code)
+	}
+}
+
+func TestAuditHandlerFilters(t *testing.T) {
+	origToken, origQuerier := adminToken, auditQuerier
+	defer func() { adminToken, auditQuerier = origToken, origQuerier }()
+
+	hash := audit.HashCode("print(1)")
+	stub := &stubQuerier{records: []audit.Record{{Language: "python", CodeHash: hash}}}
+	adminToken = "secret"
+	auditQuerier = stub
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?since=2025-01-01T00:00:00Z&language=python&limit=5&codeHash="+hash, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	auditHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	want := audit.Filter{
+		Since:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Language: "python",
+		CodeHash: hash,
+		Limit:    5,
+	}
+	if !stub.filter.Since.Equal(want.Since) || stub.filter.Language != want.Language ||
+		stub.filter.CodeHash != want.CodeHash || stub.filter.Limit != want.Limit {
+		t.Fatalf("unexpected filter: %+v", stub.filter)
+	}
+	var resp struct {
+		Entries []audit.Record `json:"entries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].CodeHash != hash {
+		t.Fatalf("unexpected entries: %+v", resp.Entries)
+	}
+
+	for _, query := range []string{"since=yesterday", "limit=-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		auditHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d", query, rec.Code)
+		}
+	}
+}
+
+type captureSink struct {
+	records chan audit.Record
+}
+
+func (c *captureSink) Write(_ context.Context, rec audit.Record) error {
+	c.records <- rec
+	return nil
+}
+
+func TestRunHandlerWritesAuditRecord(t *testing.T) {
+	origExec, origLogger := executeFn, auditLogger
+	defer func() { executeFn, auditLogger = origExec, origLogger }()
+
+	sink := &captureSink{records: make(chan audit.Record, 1)}
+	auditLogger = audit.NewLogger(1, nil, sink)
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
+		return runtime.Result{Stderr: "boom", Exit: runtime.ExitInfo{Code: 2}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(`{"language":"python","code":"print(1)"}`))
+	req.Header.Set("X-Requesting-Service", "collab")
+	req.Header.Set("X-Request-Id", "req-1")
+	runHandler(httptest.NewRecorder(), req)
+
+	select {
+	case rec := <-sink.records:
+		if rec.Service != "collab" || rec.RequestID != "req-1" || rec.CodeHash != audit.HashCode("print(1)") ||
+			rec.ExitCode != 2 || rec.StderrHead != "boom" {
+			t.Fatalf("unexpected audit record: %+v", rec)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected audit record to be written")
+	}
+}
+
+func TestRunHandlerResolvesPresetAndEchoesLimits(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+
+	var captured runtime.Limits
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
+		captured = limits
+		return runtime.Result{Stdout: "ok"}, nil
+	}
+
+	payload := `{"language":"java","code":"class Main {}","preset":"strict","limits":{"memoryBytes":4294967296,"pidsLimit":64}}`
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	want, _ := runtime.ResolveLimits(runtime.LangJava, runtime.PresetStrict, runtime.Limits{PidsLimit: 64})
+	want.MemoryB = runtime.MaxLimits.MemoryB
+	if captured != want {
+		t.Fatalf("expected limits %+v, got %+v", want, captured)
+	}
+
+	var resp runResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Stdout != "ok" || resp.Limits != limitsToConfig(want) {
+		t.Fatalf("expected resolved limits echoed, got %+v", resp)
+	}
+}
+
+func TestRunHandlerRejectsUnknownPreset(t *testing.T) {
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	executeFn = func(ctx context.Context, lang runtime.Language, code string, limits runtime.Limits, inv runtime.Invocation) (runtime.Result, error) {
+		t.Fatal("execute should not be called")
+		return runtime.Result{}, nil
+	}
+
+	rec := httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(`{"language":"python","code":"1","preset":"turbo"}`)))
+	if rec.Code != http.StatusBadRequest || !bytes.Contains(rec.Body.Bytes(), []byte("unknown_preset")) {
+		t.Fatalf("expected unknown_preset, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLanguagesHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	languagesHandler(rec, httptest.NewRequest(http.MethodPost, "/languages", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	languagesHandler(rec, httptest.NewRequest(http.MethodGet, "/languages", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp languagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Maxima != limitsToConfig(runtime.MaxLimits) {
+		t.Fatalf("unexpected maxima: %+v", resp.Maxima)
+	}
+	if len(resp.Languages) != 6 || resp.Languages[0].Language != "python" || resp.Languages[5].Language != "rust" {
+		t.Fatalf("unexpected languages: %+v", resp.Languages)
+	}
+	for _, info := range resp.Languages {
+		profile := runtime.ProfileFor(runtime.Language(info.Language))
+		if info.Defaults != limitsToConfig(profile.Defaults()) || info.Defaults != info.Presets[runtime.PresetDefault] {
+			t.Fatalf("unexpected %s defaults: %+v", info.Language, info.Defaults)
+		}
+		if len(info.Presets) != 3 || info.Presets[runtime.PresetStrict] != limitsToConfig(profile[runtime.PresetStrict]) {
+			t.Fatalf("unexpected %s presets: %+v", info.Language, info.Presets)
+		}
+	}
+	if resp.Languages[1].Defaults.MemoryBytes <= resp.Languages[0].Defaults.MemoryBytes {
+		t.Fatalf("expected java to default to more memory than python: %+v", resp.Languages)
+	}
+	for _, info := range resp.Languages {
+		if _, err := runtime.SpecFor(runtime.Language(info.Language)); err != nil {
+			t.Fatalf("unsupported language %q listed", info.Language)
+		}
+	}
+	if cpp := resp.Languages[2]; cpp.DisplayName != "C++" || cpp.Image != "gcc:13" || !cpp.Compiled {
+		t.Fatalf("unexpected cpp entry: %+v", cpp)
+	}
+	if python := resp.Languages[0]; python.DisplayName != "Python" || python.Compiled {
+		t.Fatalf("unexpected python entry: %+v", python)
+	}
+
+	rec = httptest.NewRecorder()
+	languagesHandler(rec, httptest.NewRequest(http.MethodGet, "/languages", nil))
+	var raw struct {
+		Languages []map[string]json.RawMessage `json:"languages"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&raw); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, key := range []string{"language", "displayName", "image", "compiled", "defaults", "presets"} {
+		if _, ok := raw.Languages[0][key]; !ok {
+			t.Fatalf("expected %q on every language, got %v", key, raw.Languages[0])
+		}
+	}
+}
+
+func TestReadyzHandlerChecksDaemon(t *testing.T) {
+	orig := checkReadyFn
+	defer func() { checkReadyFn = orig }()
+
+	cases := []struct {
+		name    string
+		err     error
+		code    int
+		want    string
+		details int
+	}{
+		{name: "ready", code: http.StatusOK},
+		{name: "daemon down", err: runtime.ErrDockerUnavailable, code: http.StatusServiceUnavailable, want: "sandbox_unavailable"},
+		{name: "daemon erroring", err: errors.New("500 internal"), code: http.StatusServiceUnavailable, want: "sandbox_unavailable"},
+		{
+			name:    "images missing",
+			err:     &runtime.MissingImagesError{Languages: []runtime.Language{runtime.LangJava, runtime.LangGo}},
+			code:    http.StatusServiceUnavailable,
+			want:    "images_missing",
+			details: 2,
+		},
+	}
+	for _, tc := range cases {
+		checkReadyFn = func(context.Context) error { return tc.err }
+		rec := httptest.NewRecorder()
+		readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.code, rec.Code)
+		}
+		if tc.want == "" {
+			continue
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Error != tc.want || len(resp.Details) != tc.details {
+			t.Fatalf("%s: unexpected body %+v (%v)", tc.name, resp, err)
+		}
+	}
+}
+
+func TestHealthzHandlerIgnoresDaemon(t *testing.T) {
+	orig := checkReadyFn
+	defer func() { checkReadyFn = orig }()
+	checkReadyFn = func(context.Context) error { return runtime.ErrDockerUnavailable }
+
+	rec := httptest.NewRecorder()
+	healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
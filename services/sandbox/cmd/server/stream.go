@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+var executeStreamFn = runtime.ExecuteStream
+
+// streamHandler runs a program like runHandler but sends its events as
+// Server-Sent Events while it runs, one per stdout or stderr chunk, and ends
+// the stream after the exit event. The first event, run, carries the run's ID
+// for cancelling it. Requests that are rejected before the run starts get the
+// usual JSON error response.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "streaming_unsupported"})
+		return
+	}
+
+	if !signedRequest(w, r) {
+		return
+	}
+	req, limits, inv, ok := decodeRun(w, r)
+	if !ok {
+		return
+	}
+	lang := runtime.Language(req.Language)
+
+	if !admitRun(r.Context(), w) {
+		return
+	}
+	defer runLimiter.Release()
+
+	runID, ctx, done := activeRuns.start(r.Context())
+	defer done()
+	w.Header().Set(runIDHeader, runID)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keep proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(evt runtime.Event) {
+		data, _ := json.Marshal(evt.Data)
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data)
+		flusher.Flush()
+	}
+	send(runtime.Event{Type: "run", Data: map[string]string{"runId": runID}})
+
+	started := time.Now()
+	result, err := executeStreamFn(ctx, lang, req.Code, limits, inv, send)
+	recordAudit(r, lang, req.source(), limits, result, err, started)
+	if err != nil {
+		send(runtime.Event{Type: "error", Data: err.Error()})
+		send(runtime.Event{Type: "exit", Data: runtime.ExitInfo{Code: -1}})
+		return
+	}
+	runWatchdog.RecordRun(result.Error)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+// readSSE reads one SSE event as "type data"
+func readSSE(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var typ, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return typ + " " + data
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamHandlerSendsEventsAsTheyHappen(t *testing.T) {
+	orig := executeStreamFn
+	defer func() { executeStreamFn = orig }()
+	proceed := make(chan struct{})
+	executeStreamFn = func(_ context.Context, _ runtime.Language, _ string, _ runtime.Limits, inv runtime.Invocation, emit func(runtime.Event)) (runtime.Result, error) {
+		emit(runtime.Event{Type: "stdout", Data: "tick 1\n"})
+		select {
+		case <-proceed:
+		case <-time.After(time.Second):
+			t.Errorf("the first event did not reach the client while the program ran")
+		}
+		emit(runtime.Event{Type: "stderr", Data: "warn " + inv.Stdin})
+		emit(runtime.Event{Type: "exit", Data: runtime.ExitInfo{Code: 0}})
+		return runtime.Result{}, nil
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(streamHandler))
+	defer server.Close()
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"language":"python","code":"","stdin":"x"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	body := bufio.NewReader(resp.Body)
+	runID := resp.Header.Get(runIDHeader)
+	if got := readSSE(t, body); runID == "" || got != `run {"runId":"`+runID+`"}` {
+		t.Fatalf("expected the run's ID first, got %q (header %q)", got, runID)
+	}
+	if got := readSSE(t, body); got != `stdout "tick 1\n"` {
+		t.Fatalf("unexpected first event %q", got)
+	}
+	close(proceed)
+	if got := readSSE(t, body); got != `stderr "warn x"` {
+		t.Fatalf("unexpected second event %q", got)
+	}
+	if got := readSSE(t, body); got != `exit {"code":0,"timedOut":false}` {
+		t.Fatalf("unexpected last event %q", got)
+	}
+	if _, err := body.ReadByte(); err == nil {
+		t.Fatalf("expected the stream to end after exit")
+	}
+}
+
+func TestStreamHandlerErrors(t *testing.T) {
+	orig := executeStreamFn
+	defer func() { executeStreamFn = orig }()
+	executeStreamFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation, func(runtime.Event)) (runtime.Result, error) {
+		return runtime.Result{}, errors.New("unsupported_language")
+	}
+
+	rec := httptest.NewRecorder()
+	streamHandler(rec, httptest.NewRequest(http.MethodPost, "/run/stream", bytes.NewBufferString(`{"language":"python","args":["`+strings.Repeat("a", runtime.MaxArgLen+1)+`"]}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_invocation") {
+		t.Fatalf("expected the invalid invocation as JSON, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	streamHandler(rec, httptest.NewRequest(http.MethodPost, "/run/stream", bytes.NewBufferString(`{"language":"cobol","code":""}`)))
+	body := bufio.NewReader(rec.Body)
+	if got := readSSE(t, body); !strings.HasPrefix(got, "run ") {
+		t.Fatalf("unexpected event %q", got)
+	}
+	if got := readSSE(t, body); got != `error "unsupported_language"` {
+		t.Fatalf("unexpected event %q", got)
+	}
+	if got := readSSE(t, body); got != `exit {"code":-1,"timedOut":false}` {
+		t.Fatalf("unexpected event %q", got)
+	}
+}
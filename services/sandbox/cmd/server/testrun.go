@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+var runTestsFn = runtime.RunTests
+
+type testRunRequest struct {
+	Language string             `json:"language"`
+	Code     string             `json:"code"`
+	Preset   string             `json:"preset,omitempty"`
+	Limits   *limitsConfig      `json:"limits,omitempty"`
+	Tests    []runtime.TestCase `json:"tests"`
+}
+
+// testRunResponse is the per-case results together with the limits every
+// case ran with
+type testRunResponse struct {
+	runtime.TestRunResult
+	Limits limitsConfig `json:"limits"`
+}
+
+// runTestsHandler judges a submission against test cases: it compiles once
+// and runs the program per case in one container, holding a single slot of
+// runLimiter for the whole submission.
+func runTestsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+
+	if !signedRequest(w, r) {
+		return
+	}
+	var req testRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "invalid_request"})
+		return
+	}
+
+	lang := runtime.Language(req.Language)
+	limits, err := runtime.ResolveLimits(lang, req.Preset, limitsFromConfig(req.Limits))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+		return
+	}
+	if !networkAllowed(r, limits) {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "network_not_allowed"})
+		return
+	}
+	var invErr *runtime.InvocationError
+	if errors.As(runtime.ValidateTestCases(req.Tests), &invErr) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: invErr.Error(), Details: invErr.Fields})
+		return
+	}
+
+	ctx := r.Context()
+	if !admitRun(ctx, w) {
+		return
+	}
+	defer runLimiter.Release()
+
+	started := time.Now()
+	result, err := runTestsFn(ctx, lang, req.Code, limits, req.Tests)
+	recordAudit(r, lang, req.Code, limits, testRunAuditResult(result), err, started)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+		return
+	}
+	runWatchdog.RecordRun(result.Error)
+
+	if err := json.NewEncoder(w).Encode(testRunResponse{TestRunResult: result, Limits: limitsToConfig(limits)}); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+// testRunAuditResult summarises a test run for the audit log by its first
+// failing case, or the compiler output when it did not build
+func testRunAuditResult(result runtime.TestRunResult) runtime.Result {
+	audited := runtime.Result{Error: result.Error}
+	if result.CompileError != "" {
+		audited.Exit.Code = 1
+		audited.Stderr = result.CompileError
+		return audited
+	}
+	for _, tc := range result.Cases {
+		if !tc.Passed {
+			audited.Exit = tc.Exit
+			audited.Stderr = tc.Stderr
+			break
+		}
+	}
+	return audited
+}
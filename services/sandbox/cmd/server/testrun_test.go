@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sandbox/internal/audit"
+	"sandbox/internal/runtime"
+)
+
+func postTests(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	runTestsHandler(rec, httptest.NewRequest(http.MethodPost, "/run/tests", bytes.NewBufferString(body)))
+	return rec
+}
+
+func TestRunTestsHandlerJudgesCases(t *testing.T) {
+	origRun, origLogger := runTestsFn, auditLogger
+	defer func() { runTestsFn, auditLogger = origRun, origLogger }()
+	sink := &captureSink{records: make(chan audit.Record, 1)}
+	auditLogger = audit.NewLogger(1, nil, sink)
+
+	var gotCases []runtime.TestCase
+	var gotLimits runtime.Limits
+	runTestsFn = func(_ context.Context, lang runtime.Language, _ string, limits runtime.Limits, cases []runtime.TestCase) (runtime.TestRunResult, error) {
+		gotCases, gotLimits = cases, limits
+		return runtime.TestRunResult{
+			Cases: []runtime.TestCaseResult{
+				{Passed: true, ActualOutput: "3\n", Exit: runtime.ExitInfo{Code: 0}},
+				{ActualOutput: "", Stderr: "Traceback", Exit: runtime.ExitInfo{Code: 1}},
+			},
+			Passed: 1,
+			Total:  2,
+		}, nil
+	}
+
+	rec := postTests(t, `{"language":"python","code":"print(sum(map(int,input().split())))","preset":"strict",
+		"tests":[{"input":"1 2\n","expectedOutput":"3"},{"input":"x\n","expectedOutput":"0"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(gotCases) != 2 || gotCases[0].Input != "1 2\n" || gotCases[1].ExpectedOutput != "0" {
+		t.Fatalf("expected the cases to reach the runner, got %+v", gotCases)
+	}
+	strict, _ := runtime.ResolveLimits(runtime.LangPython, runtime.PresetStrict, runtime.Limits{})
+	if gotLimits != strict {
+		t.Fatalf("expected the strict preset, got %+v", gotLimits)
+	}
+
+	var resp testRunResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Passed != 1 || resp.Total != 2 || len(resp.Cases) != 2 || resp.Limits.WallTimeMs != strict.WallTime.Milliseconds() {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	select {
+	case r := <-sink.records:
+		if r.ExitCode != 1 || r.StderrHead != "Traceback" {
+			t.Fatalf("expected the first failing case to be audited, got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected an audit record")
+	}
+}
+
+func TestRunTestsHandlerRejectsBadRequests(t *testing.T) {
+	origRun := runTestsFn
+	defer func() { runTestsFn = origRun }()
+	runTestsFn = func(context.Context, runtime.Language, string, runtime.Limits, []runtime.TestCase) (runtime.TestRunResult, error) {
+		t.Fatalf("runner must not be called")
+		return runtime.TestRunResult{}, nil
+	}
+
+	rec := httptest.NewRecorder()
+	runTestsHandler(rec, httptest.NewRequest(http.MethodGet, "/run/tests", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+
+	rec = postTests(t, `{"language":"python","code":"","tests":[]}`)
+	var resp errorResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusBadRequest || resp.Error != "invalid_invocation" || len(resp.Details) != 1 || resp.Details[0].Field != "tests" {
+		t.Fatalf("expected the empty test list to be rejected, got %d %+v", rec.Code, resp)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox/internal/metrics"
+	"sandbox/internal/runtime"
+)
+
+// runWatchdog guards batch runs and interactive sessions against a degraded
+// Docker daemon; it only probes once started by main
+var runWatchdog = runtime.NewWatchdog(runtime.DefaultWatchdogConfig())
+
+// setupWatchdog configures the watchdog from SANDBOX_WATCHDOG_*; unset or
+// invalid values keep the defaults. It must run after setupLimiter so that
+// waiting runs are drained from the limiter in use.
+func setupWatchdog() {
+	cfg := runtime.WatchdogConfig{
+		ProbeInterval:    envSeconds("SANDBOX_WATCHDOG_INTERVAL_SEC"),
+		ProbeTimeout:     envSeconds("SANDBOX_WATCHDOG_PROBE_TIMEOUT_SEC"),
+		ProbeFailures:    envInt("SANDBOX_WATCHDOG_PROBE_FAILURES"),
+		FailureWindow:    envInt("SANDBOX_WATCHDOG_FAILURE_WINDOW"),
+		MinRuns:          envInt("SANDBOX_WATCHDOG_MIN_RUNS"),
+		RecoverSuccesses: envInt("SANDBOX_WATCHDOG_RECOVER_SUCCESSES"),
+		RemediateTimeout: envSeconds("SANDBOX_WATCHDOG_REMEDIATE_TIMEOUT_SEC"),
+		Limiter:          runLimiter,
+		OnTransition:     logWatchdogTransition,
+		OnProbe:          func(p runtime.ProbeResult) { metrics.IncWatchdogProbe(p.OK) },
+	}
+	cfg.FailureRate, _ = strconv.ParseFloat(strings.TrimSpace(os.Getenv("SANDBOX_WATCHDOG_FAILURE_RATE")), 64)
+	if cmd := strings.TrimSpace(os.Getenv("SANDBOX_WATCHDOG_REMEDIATE_CMD")); cmd != "" {
+		cfg.Remediate = remediationCommand(cmd)
+	}
+	runWatchdog = runtime.NewWatchdog(cfg)
+}
+
+func logWatchdogTransition(tr runtime.WatchdogTransition) {
+	log.Printf("sandbox watchdog: %s -> %s (%s)", tr.From, tr.To, tr.Reason)
+	metrics.RecordWatchdogTransition(string(tr.To), tr.Reason, tr.To == runtime.WatchdogDegraded)
+}
+
+// remediationCommand runs cmd through the shell, e.g. to restart the daemon
+func remediationCommand(cmd string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		log.Printf("sandbox watchdog: running remediation")
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", cmd).CombinedOutput()
+		if err != nil {
+			log.Printf("sandbox watchdog: remediation failed: %v: %s", err, strings.TrimSpace(string(out)))
+			return fmt.Errorf("remediation: %w", err)
+		}
+		log.Printf("sandbox watchdog: remediation finished")
+		return nil
+	}
+}
+
+func envInt(name string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	return n
+}
+
+func envSeconds(name string) time.Duration {
+	return time.Duration(envInt(name)) * time.Second
+}
+
+// watchdogHandler reports the watchdog state, recent probes and run failure
+// window to admins
+func watchdogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "method_not_allowed"})
+		return
+	}
+	if !authorizedAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(errorResponse{Error: "unauthorized"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(runWatchdog.Report())
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+// useWatchdog swaps in a watchdog that degrades after two runs failing in the
+// sandbox, draining runLimiter
+func useWatchdog(t *testing.T) {
+	t.Helper()
+	origWatchdog, origLimiter := runWatchdog, runLimiter
+	t.Cleanup(func() { runWatchdog, runLimiter = origWatchdog, origLimiter })
+	runLimiter = runtime.NewLimiter(1)
+	runWatchdog = runtime.NewWatchdog(runtime.WatchdogConfig{
+		FailureWindow: 2, MinRuns: 2, FailureRate: 1, Limiter: runLimiter,
+	})
+}
+
+func postRun(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(`{"language":"python","code":"print(1)"}`))
+	rec := httptest.NewRecorder()
+	runHandler(rec, req)
+	return rec
+}
+
+func TestRunHandlerFailsFastWhileDegraded(t *testing.T) {
+	useWatchdog(t)
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	calls := 0
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		calls++
+		return runtime.Result{Exit: runtime.ExitInfo{Code: -1}, Error: "sandbox_error"}, nil
+	}
+
+	postRun(t)
+	postRun(t)
+	if !runWatchdog.Degraded() {
+		t.Fatalf("expected two sandbox failures to degrade the service")
+	}
+
+	rec := postRun(t)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Error != "sandbox_unavailable" {
+		t.Fatalf("expected sandbox_unavailable, got %+v (%v)", resp, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the degraded run to be refused without executing, got %d executions", calls)
+	}
+
+	rec = httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !bytes.Contains(rec.Body.Bytes(), []byte(runtime.WatchdogReasonFailureRate)) {
+		t.Fatalf("expected readyz to report degraded, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRunHandlerDrainsQueuedRunsOnDegrade(t *testing.T) {
+	useWatchdog(t)
+	orig := executeFn
+	defer func() { executeFn = orig }()
+	executeFn = func(context.Context, runtime.Language, string, runtime.Limits, runtime.Invocation) (runtime.Result, error) {
+		return runtime.Result{}, nil
+	}
+
+	// Hold the only slot so the next run queues
+	if err := runLimiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer runLimiter.Release()
+	queued := make(chan *httptest.ResponseRecorder, 1)
+	go func() { queued <- postRun(t) }()
+	time.Sleep(20 * time.Millisecond)
+
+	runWatchdog.RecordRun("sandbox_unavailable")
+	runWatchdog.RecordRun("sandbox_unavailable")
+
+	select {
+	case rec := <-queued:
+		var resp errorResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusServiceUnavailable || resp.Error != "sandbox_unavailable" {
+			t.Fatalf("expected the queued run to fail with sandbox_unavailable, got %d %+v", rec.Code, resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("queued run was not drained")
+	}
+}
+
+func TestWatchdogHandler(t *testing.T) {
+	useWatchdog(t)
+	origToken := adminToken
+	defer func() { adminToken = origToken }()
+	adminToken = "secret"
+
+	rec := httptest.NewRecorder()
+	watchdogHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/watchdog", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the admin token, got %d", rec.Code)
+	}
+
+	runWatchdog.RecordRun("")
+	runWatchdog.RecordRun("sandbox_error")
+	req := httptest.NewRequest(http.MethodGet, "/admin/watchdog", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	watchdogHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var report runtime.WatchdogReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.State != runtime.WatchdogHealthy || report.Window.Runs != 2 || report.Window.Failures != 1 ||
+		report.Window.Rate != 0.5 || report.Thresholds.MinRuns != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestSetupWatchdogReadsEnv(t *testing.T) {
+	origWatchdog := runWatchdog
+	defer func() { runWatchdog = origWatchdog }()
+	t.Setenv("SANDBOX_WATCHDOG_INTERVAL_SEC", "5")
+	t.Setenv("SANDBOX_WATCHDOG_PROBE_FAILURES", "4")
+	t.Setenv("SANDBOX_WATCHDOG_FAILURE_RATE", "0.8")
+	t.Setenv("SANDBOX_WATCHDOG_MIN_RUNS", "oops")
+	t.Setenv("SANDBOX_WATCHDOG_RECOVER_SUCCESSES", "6")
+
+	setupWatchdog()
+
+	got := runWatchdog.Report().Thresholds
+	want := runtime.WatchdogThresholds{
+		ProbeIntervalMs:  5000,
+		ProbeFailures:    4,
+		FailureRate:      0.8,
+		MinRuns:          runtime.DefaultWatchdogConfig().MinRuns,
+		RecoverSuccesses: 6,
+	}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"sandbox/internal/runtime"
+)
+
+const (
+	stderrHeadLimit   = 200
+	defaultBufferSize = 256
+	defaultQueryLimit = 100
+)
+
+// Record is a single execution entry. The submitted code is never stored,
+// only its SHA-256 hash.
+type Record struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Service    string    `json:"service,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Language   string    `json:"language"`
+	CodeHash   string    `json:"codeHash"`
+	Limits     Limits    `json:"limits"`
+	ExitCode   int       `json:"exitCode"`
+	TimedOut   bool      `json:"timedOut"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	StderrHead string    `json:"stderrHead,omitempty"`
+}
+
+type Limits struct {
+	WallTimeMs  int64 `json:"wallTimeMs"`
+	MemoryBytes int64 `json:"memoryBytes"`
+	NanoCPUs    int64 `json:"nanoCPUs"`
+}
+
+// Filter narrows down a query over recent records. Zero values match everything.
+type Filter struct {
+	Since    time.Time
+	Language string
+	CodeHash string
+	Limit    int
+}
+
+// Sink persists audit records.
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+}
+
+// Querier reads back recent audit records, newest first.
+type Querier interface {
+	Query(ctx context.Context, f Filter) ([]Record, error)
+}
+
+// HashCode returns the hex-encoded SHA-256 digest of the submitted code.
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRecord builds the audit entry for a finished execution. A program that
+// did not compile keeps the head of the compiler's output instead of stderr.
+func NewRecord(lang runtime.Language, code string, limits runtime.Limits, result runtime.Result, started time.Time, elapsed time.Duration) Record {
+	stderr := result.Stderr
+	if result.Stage == runtime.StageCompile {
+		stderr = result.CompileOutput
+	}
+	return Record{
+		Timestamp: started.UTC(),
+		Language:  string(lang),
+		CodeHash:  HashCode(code),
+		Limits: Limits{
+			WallTimeMs:  limits.WallTime.Milliseconds(),
+			MemoryBytes: limits.MemoryB,
+			NanoCPUs:    limits.NanoCPUs,
+		},
+		ExitCode:   result.Exit.Code,
+		TimedOut:   result.Exit.TimedOut,
+		Truncated:  result.OutputTruncated,
+		Error:      result.Error,
+		DurationMs: elapsed.Milliseconds(),
+		StderrHead: truncate(stderr, stderrHeadLimit),
+	}
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+func (f Filter) matches(rec Record) bool {
+	if !f.Since.IsZero() && rec.Timestamp.Before(f.Since) {
+		return false
+	}
+	if f.Language != "" && rec.Language != f.Language {
+		return false
+	}
+	if f.CodeHash != "" && rec.CodeHash != f.CodeHash {
+		return false
+	}
+	return true
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return defaultQueryLimit
+	}
+	return f.Limit
+}
+
+// Logger fans records out to its sinks from a background goroutine so that
+// the execution path never waits on disk or Redis. When the buffer is full the
+// record is dropped and onDrop is invoked.
+type Logger struct {
+	entries chan Record
+	sinks   []Sink
+	onDrop  func()
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewLogger(bufferSize int, onDrop func(), sinks ...Sink) *Logger {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	if onDrop == nil {
+		onDrop = func() {}
+	}
+	l := &Logger{
+		entries: make(chan Record, bufferSize),
+		sinks:   sinks,
+		onDrop:  onDrop,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log enqueues rec without blocking.
+func (l *Logger) Log(rec Record) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.entries <- rec:
+	default:
+		l.onDrop()
+	}
+}
+
+// Close flushes queued records and stops the background writer.
+func (l *Logger) Close() {
+	l.once.Do(func() {
+		close(l.entries)
+		<-l.done
+	})
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for rec := range l.entries {
+		for _, sink := range l.sinks {
+			if err := sink.Write(context.Background(), rec); err != nil {
+				log.Printf("audit: write failed: %v", err)
+			}
+		}
+	}
+}
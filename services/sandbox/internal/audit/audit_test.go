@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"sandbox/internal/runtime"
+)
+
+func TestNewRecordHashesCodeAndTruncatesStderr(t *testing.T) {
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	result := runtime.Result{
+		Stderr:          strings.Repeat("e", 500),
+		Exit:            runtime.ExitInfo{Code: 137, TimedOut: true},
+		OutputTruncated: true,
+	}
+	limits := runtime.Limits{WallTime: 2 * time.Second, MemoryB: 1024, NanoCPUs: 5}
+
+	rec := NewRecord(runtime.LangPython, "print('hi')", limits, result, started, 1500*time.Millisecond)
+
+	if len(rec.CodeHash) != 64 || rec.CodeHash != HashCode("print('hi')") {
+		t.Fatalf("unexpected hash %q", rec.CodeHash)
+	}
+	if HashCode("print('hi')") == HashCode("print('bye')") {
+		t.Fatalf("expected distinct hashes for distinct code")
+	}
+	if len(rec.StderrHead) != stderrHeadLimit {
+		t.Fatalf("expected stderr truncated to %d, got %d", stderrHeadLimit, len(rec.StderrHead))
+	}
+	if rec.Language != "python" || rec.ExitCode != 137 || !rec.TimedOut || !rec.Truncated || rec.DurationMs != 1500 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if rec.Limits != (Limits{WallTimeMs: 2000, MemoryBytes: 1024, NanoCPUs: 5}) {
+		t.Fatalf("unexpected limits: %+v", rec.Limits)
+	}
+	if !rec.Timestamp.Equal(started) {
+		t.Fatalf("unexpected timestamp %v", rec.Timestamp)
+	}
+}
+
+func TestFileSinkRotatesAtThreshold(t *testing.T) {
+	rec := Record{Language: "python", CodeHash: HashCode("a")}
+	line, _ := json.Marshal(rec)
+	threshold := int64(2*(len(line)+1) + 10)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, threshold)
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	defer sink.Close()
+
+	// Two records fit per generation, so the sixth write leaves the first two
+	// behind once the file has rotated twice.
+	for i := 0; i < 6; i++ {
+		if err := sink.Write(context.Background(), rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat current: %v", err)
+	}
+	if info.Size() > threshold {
+		t.Fatalf("expected current file under threshold, got %d bytes", info.Size())
+	}
+
+	records, err := sink.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected records from both generations, got %d", len(records))
+	}
+}
+
+type slowSink struct {
+	delay  time.Duration
+	writes int32
+}
+
+func (s *slowSink) Write(context.Context, Record) error {
+	time.Sleep(s.delay)
+	atomic.AddInt32(&s.writes, 1)
+	return nil
+}
+
+func TestLoggerNeverBlocksOnSlowSink(t *testing.T) {
+	var dropped int32
+	sink := &slowSink{delay: 200 * time.Millisecond}
+	logger := NewLogger(1, func() { atomic.AddInt32(&dropped, 1) }, sink)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		logger.Log(Record{Language: "python"})
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("Log blocked for %s", elapsed)
+	}
+	if atomic.LoadInt32(&dropped) == 0 {
+		t.Fatalf("expected drops when buffer is saturated")
+	}
+
+	logger.Close()
+	if got := atomic.LoadInt32(&sink.writes) + atomic.LoadInt32(&dropped); got != 10 {
+		t.Fatalf("expected every record to be written or dropped, got %d", got)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, 0)
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	defer sink.Close()
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	miner := HashCode("while True: mine()")
+	entries := []Record{
+		{Timestamp: base, Language: "python", CodeHash: miner},
+		{Timestamp: base.Add(time.Minute), Language: "cpp", CodeHash: HashCode("int main(){}")},
+		{Timestamp: base.Add(2 * time.Minute), Language: "python", CodeHash: miner},
+		{Timestamp: base.Add(3 * time.Minute), Language: "python", CodeHash: HashCode("print(1)")},
+	}
+	for _, rec := range entries {
+		if err := sink.Write(context.Background(), rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	mr := miniredis.RunT(t)
+	redisSink := NewRedisSink(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", 0)
+	for _, rec := range entries {
+		if err := redisSink.Write(context.Background(), rec); err != nil {
+			t.Fatalf("redis write: %v", err)
+		}
+	}
+
+	for name, querier := range map[string]Querier{"file": sink, "redis": redisSink} {
+		t.Run(name, func(t *testing.T) {
+			byHash, err := querier.Query(context.Background(), Filter{CodeHash: miner})
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if len(byHash) != 2 || !byHash[0].Timestamp.After(byHash[1].Timestamp) {
+				t.Fatalf("expected two matches newest first, got %+v", byHash)
+			}
+
+			byLang, _ := querier.Query(context.Background(), Filter{Language: "cpp"})
+			if len(byLang) != 1 || byLang[0].Language != "cpp" {
+				t.Fatalf("unexpected language filter result: %+v", byLang)
+			}
+
+			recent, _ := querier.Query(context.Background(), Filter{Since: base.Add(90 * time.Second), Limit: 1})
+			if len(recent) != 1 || !recent[0].Timestamp.Equal(base.Add(3*time.Minute)) {
+				t.Fatalf("unexpected since/limit result: %+v", recent)
+			}
+		})
+	}
+}
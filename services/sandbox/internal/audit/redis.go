@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultStream       = "sandbox:audit"
+	defaultStreamMaxLen = 10000
+)
+
+// RedisSink publishes records to a capped Redis stream so a central collector
+// can consume them.
+type RedisSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisSink(rdb *redis.Client, stream string, maxLen int64) *RedisSink {
+	if stream == "" {
+		stream = DefaultStream
+	}
+	if maxLen <= 0 {
+		maxLen = defaultStreamMaxLen
+	}
+	return &RedisSink{rdb: rdb, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisSink) Write(ctx context.Context, rec Record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"record":   string(payload),
+			"codeHash": rec.CodeHash,
+		},
+	}).Err()
+}
+
+func (s *RedisSink) Query(ctx context.Context, f Filter) ([]Record, error) {
+	start := "-"
+	if !f.Since.IsZero() {
+		start = strconv.FormatInt(f.Since.UnixMilli(), 10)
+	}
+	msgs, err := s.rdb.XRevRange(ctx, s.stream, "+", start).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := f.limit()
+	records := make([]Record, 0, limit)
+	for _, msg := range msgs {
+		raw, ok := msg.Values["record"].(string)
+		if !ok {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+			continue
+		}
+		if !f.matches(rec) {
+			continue
+		}
+		records = append(records, rec)
+		if len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
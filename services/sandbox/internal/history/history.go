@@ -0,0 +1,115 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"sandbox/internal/audit"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ErrNotFound is returned by Get for an unknown run
+var ErrNotFound = errors.New("run not found")
+
+// Run is the stored metadata of one execution. Like the audit log it keeps
+// only the hash of the submitted code.
+type Run struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	StartedAt  time.Time `gorm:"index;not null" json:"startedAt"`
+	Language   string    `gorm:"size:32;index;not null" json:"language"`
+	CodeHash   string    `gorm:"size:64;not null" json:"codeHash"`
+	ExitCode   int       `json:"exitCode"`
+	DurationMs int64     `json:"durationMs"`
+	TimedOut   bool      `json:"timedOut"`
+	Truncated  bool      `json:"truncated"`
+	Service    string    `gorm:"size:64" json:"service,omitempty"`
+}
+
+// TableName keeps the table apart from those of other services sharing the
+// database
+func (Run) TableName() string {
+	return "sandbox_runs"
+}
+
+// Filter narrows down List. Zero values match everything; Before pages
+// backwards from the run with that ID.
+type Filter struct {
+	Language string
+	Since    time.Time
+	Before   uint
+	Limit    int
+}
+
+// Store persists runs in a SQL database. It is an audit.Sink, so runs reach
+// it through the audit logger's background writer and an unavailable
+// database never holds up or fails a run.
+type Store struct {
+	db *gorm.DB
+}
+
+// NewStore migrates the runs table and returns a store over db
+func NewStore(db *gorm.DB) (*Store, error) {
+	if err := db.AutoMigrate(&Run{}); err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Write stores the run rec describes
+func (s *Store) Write(ctx context.Context, rec audit.Record) error {
+	run := Run{
+		StartedAt:  rec.Timestamp,
+		Language:   rec.Language,
+		CodeHash:   rec.CodeHash,
+		ExitCode:   rec.ExitCode,
+		DurationMs: rec.DurationMs,
+		TimedOut:   rec.TimedOut,
+		Truncated:  rec.Truncated,
+		Service:    rec.Service,
+	}
+	return s.db.WithContext(ctx).Create(&run).Error
+}
+
+// List returns the runs matching f, newest first
+func (s *Store) List(ctx context.Context, f Filter) ([]Run, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	query := s.db.WithContext(ctx).Model(&Run{})
+	if f.Language != "" {
+		query = query.Where("language = ?", f.Language)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("started_at >= ?", f.Since)
+	}
+	if f.Before > 0 {
+		query = query.Where("id < ?", f.Before)
+	}
+	runs := []Run{}
+	if err := query.Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Get returns the run with id, or ErrNotFound
+func (s *Store) Get(ctx context.Context, id uint) (Run, error) {
+	var run Run
+	err := s.db.WithContext(ctx).First(&run, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Run{}, ErrNotFound
+	}
+	return run, err
+}
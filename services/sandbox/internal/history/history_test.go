@@ -0,0 +1,106 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"sandbox/internal/audit"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestStoreWritesAuditRecords(t *testing.T) {
+	store := newTestStore(t)
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := audit.Record{
+		Timestamp:  started,
+		Service:    "collab",
+		Language:   "java",
+		CodeHash:   audit.HashCode("class Main {}"),
+		ExitCode:   137,
+		TimedOut:   true,
+		Truncated:  true,
+		DurationMs: 2500,
+	}
+	if err := store.Write(context.Background(), rec); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	runs, err := store.List(context.Background(), Filter{})
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("expected one run, got %+v err=%v", runs, err)
+	}
+	got, err := store.Get(context.Background(), runs[0].ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Language != "java" || got.CodeHash != rec.CodeHash || got.ExitCode != 137 || !got.TimedOut ||
+		!got.Truncated || got.DurationMs != 2500 || got.Service != "collab" || !got.StartedAt.Equal(started) {
+		t.Fatalf("unexpected run %+v", got)
+	}
+
+	if _, err := store.Get(context.Background(), got.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreListFiltersAndPages(t *testing.T) {
+	store := newTestStore(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, lang := range []string{"python", "java", "python", "python", "cpp"} {
+		rec := audit.Record{Timestamp: base.Add(time.Duration(i) * time.Hour), Language: lang, CodeHash: audit.HashCode(lang)}
+		if err := store.Write(context.Background(), rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	runs, err := store.List(context.Background(), Filter{Language: "python"})
+	if err != nil || len(runs) != 3 || runs[0].ID != 4 || runs[2].ID != 1 {
+		t.Fatalf("expected the python runs newest first, got %+v err=%v", runs, err)
+	}
+
+	runs, err = store.List(context.Background(), Filter{Since: base.Add(2 * time.Hour)})
+	if err != nil || len(runs) != 3 || runs[2].ID != 3 {
+		t.Fatalf("expected the runs since the third, got %+v err=%v", runs, err)
+	}
+
+	page, err := store.List(context.Background(), Filter{Limit: 2})
+	if err != nil || len(page) != 2 || page[0].ID != 5 || page[1].ID != 4 {
+		t.Fatalf("unexpected first page %+v err=%v", page, err)
+	}
+	page, err = store.List(context.Background(), Filter{Limit: 2, Before: page[1].ID})
+	if err != nil || len(page) != 2 || page[0].ID != 3 || page[1].ID != 2 {
+		t.Fatalf("unexpected second page %+v err=%v", page, err)
+	}
+}
+
+func TestStoreWriteFailsWhenDatabaseIsGone(t *testing.T) {
+	store := newTestStore(t)
+	sqlDB, err := store.db.DB()
+	if err != nil {
+		t.Fatalf("db: %v", err)
+	}
+	sqlDB.Close()
+
+	if err := store.Write(context.Background(), audit.Record{Language: "python"}); err == nil {
+		t.Fatalf("expected the write to fail on a closed database")
+	}
+}
@@ -46,6 +46,73 @@ var (
 		Help:      "Size of HTTP requests in bytes",
 		Buckets:   prometheus.ExponentialBuckets(200, 2, 8),
 	}, []string{"service", "method", "path", "status"})
+
+	auditDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_audit_dropped_total",
+		Help:      "Number of execution audit records dropped because the writer was saturated",
+	})
+
+	watchdogDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_watchdog_degraded",
+		Help:      "1 while the watchdog holds the sandbox degraded, 0 otherwise",
+	})
+
+	watchdogTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_watchdog_transitions_total",
+		Help:      "Number of watchdog state transitions by target state and reason",
+	}, []string{"state", "reason"})
+
+	watchdogProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_watchdog_probes_total",
+		Help:      "Number of Docker daemon health probes by result",
+	}, []string{"result"})
+
+	watchdogRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_watchdog_rejected_total",
+		Help:      "Number of runs refused or drained while the sandbox was degraded",
+	})
+
+	poolLeases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_pool_leases_total",
+		Help:      "Number of container leases by whether a warm container was available",
+	}, []string{"result"})
+
+	resultCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_result_cache_lookups_total",
+		Help:      "Number of runs looked up in the result cache by whether a recent identical run answered them",
+	}, []string{"result"})
+
+	runs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_runs_total",
+		Help:      "Number of sandbox runs by language and outcome",
+	}, []string{"language", "outcome"})
+
+	runDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_run_duration_seconds",
+		Help:      "Duration of sandbox runs in seconds, including container setup",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"language"})
+
+	containersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_containers_in_flight",
+		Help:      "Current number of containers held by runs and interactive sessions",
+	})
+
+	imagePulls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peerprep",
+		Name:      "sandbox_image_pulls_total",
+		Help:      "Number of sandbox image pulls by result",
+	}, []string{"result"})
 )
 
 type responseRecorder struct {
@@ -118,6 +185,78 @@ func Middleware(service string) func(http.Handler) http.Handler {
 	}
 }
 
+// IncAuditDropped counts an execution audit record that could not be queued.
+func IncAuditDropped() {
+	auditDropped.Inc()
+}
+
+// RecordWatchdogTransition counts a watchdog transition and tracks whether the
+// sandbox is degraded.
+func RecordWatchdogTransition(state, reason string, degraded bool) {
+	watchdogTransitions.WithLabelValues(state, reason).Inc()
+	if degraded {
+		watchdogDegraded.Set(1)
+	} else {
+		watchdogDegraded.Set(0)
+	}
+}
+
+// IncWatchdogProbe counts a daemon health probe.
+func IncWatchdogProbe(ok bool) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	watchdogProbes.WithLabelValues(result).Inc()
+}
+
+// IncWatchdogRejected counts a run refused because the sandbox is degraded.
+func IncWatchdogRejected() {
+	watchdogRejected.Inc()
+}
+
+// IncPoolLease counts a container lease, hit when a warm one was idle.
+func IncPoolLease(hit bool) {
+	result := "hit"
+	if !hit {
+		result = "miss"
+	}
+	poolLeases.WithLabelValues(result).Inc()
+}
+
+// IncResultCacheLookup counts a run looked up in the result cache, hit when
+// it was answered from it.
+func IncResultCacheLookup(hit bool) {
+	result := "hit"
+	if !hit {
+		result = "miss"
+	}
+	resultCacheLookups.WithLabelValues(result).Inc()
+}
+
+// RecordRun counts a finished run and, when it got as far as running, its
+// duration.
+func RecordRun(language, outcome string, took time.Duration) {
+	runs.WithLabelValues(language, outcome).Inc()
+	if took > 0 {
+		runDuration.WithLabelValues(language).Observe(took.Seconds())
+	}
+}
+
+// AddContainersInFlight tracks containers taken (+1) and handed back (-1).
+func AddContainersInFlight(delta int) {
+	containersInFlight.Add(float64(delta))
+}
+
+// IncImagePull counts a pull of a sandbox image.
+func IncImagePull(ok bool) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	imagePulls.WithLabelValues(result).Inc()
+}
+
 // Handler exposes the default Prometheus metrics endpoint.
 func Handler() http.Handler {
 	return promhttp.Handler()
@@ -0,0 +1,68 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// Bounds on the files a run may collect from its workspace
+const (
+	MaxCollectFiles  = 16
+	MaxArtifactBytes = 256 * 1024 // content of all the artifacts of a run
+)
+
+// Artifact is a file the program left in its workspace, collected once it
+// has run. Content is base64; a file that could not be collected has only
+// Error, such as not_found or too_large.
+type Artifact struct {
+	Path    string `json:"path"`
+	Size    int    `json:"size"`
+	Content string `json:"content,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// validateCollectFiles checks the files a run asks to collect: few enough
+// and every one inside the workspace
+func validateCollectFiles(paths []string) []FieldError {
+	var fields []FieldError
+	if len(paths) > MaxCollectFiles {
+		fields = append(fields, FieldError{Field: "collectFiles", Reason: "at most 16 files may be collected"})
+	}
+	for i, p := range paths {
+		if _, ok := workspacePath(p); !ok || len(p) > MaxFilePathLen {
+			fields = append(fields, FieldError{Field: "collectFiles[" + strconv.Itoa(i) + "]", Reason: "must be a file inside /workspace"})
+		}
+	}
+	return fields
+}
+
+// collectArtifacts reads the files of s.collect from the workspace once the
+// program has run. The workspace is a tmpfs, which docker cp cannot see, so
+// every file is read by an exec like the submission is written; reading past
+// what is left of MaxArtifactBytes tells a file that is too large.
+func (s *Sandbox) collectArtifacts(ctx context.Context, cid string) {
+	remaining := MaxArtifactBytes
+	for _, p := range s.collect {
+		rel, _ := workspacePath(p)
+		artifact := Artifact{Path: p}
+		var content bytes.Buffer
+		read := []string{"/bin/sh", "-c", fmt.Sprintf("head -c %d %s", remaining+1, shellQuote(workspaceDir+"/"+rel))}
+		code, err := s.execCommand(ctx, cid, read, nil, nil, func(b []byte) { content.Write(b) }, func([]byte) {})
+		switch {
+		case err != nil:
+			artifact.Error = "read_failed"
+		case code != 0:
+			artifact.Error = "not_found"
+		case content.Len() > remaining:
+			artifact.Error = "too_large"
+		default:
+			remaining -= content.Len()
+			artifact.Size = content.Len()
+			artifact.Content = base64.StdEncoding.EncodeToString(content.Bytes())
+		}
+		s.artifacts = append(s.artifacts, artifact)
+	}
+}
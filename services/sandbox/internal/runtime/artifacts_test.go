@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func readArtifact(limit, path string) []string {
+	return []string{"/bin/sh", "-c", "head -c " + limit + " '/workspace/" + path + "'"}
+}
+
+func TestExecuteCollectsArtifacts(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{expectCmd: []string{"python3", "main.py"}, inspect: types.ContainerExecInspect{ExitCode: 1}},
+			{expectCmd: readArtifact("262145", "out.csv"), stdout: "a,b\n1,2\n"},
+			{expectCmd: readArtifact("262137", "missing.txt"), inspect: types.ContainerExecInspect{ExitCode: 1}},
+			{expectCmd: readArtifact("262137", "logs/run.log"), stdout: ""},
+		},
+	}
+	useFakeDocker(t, client)
+
+	inv := Invocation{CollectFiles: []string{"out.csv", "missing.txt", "/workspace/logs/run.log"}}
+	res, err := Execute(context.Background(), LangPython, "code", Limits{}, inv)
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	want := []Artifact{
+		{Path: "out.csv", Size: 8, Content: base64.StdEncoding.EncodeToString([]byte("a,b\n1,2\n"))},
+		{Path: "missing.txt", Error: "not_found"},
+		{Path: "/workspace/logs/run.log"},
+	}
+	if !reflect.DeepEqual(res.Artifacts, want) {
+		t.Fatalf("expected artifacts %+v, got %+v", want, res.Artifacts)
+	}
+	if res.Exit.Code != 1 {
+		t.Fatalf("expected the program's exit code to stand, got %+v", res.Exit)
+	}
+}
+
+func TestExecuteCapsArtifactSize(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{expectCmd: []string{"python3", "main.py"}},
+			{expectCmd: readArtifact("262145", "big.bin"), stdout: strings.Repeat("x", MaxArtifactBytes+1)},
+			{expectCmd: readArtifact("262145", "small.txt"), stdout: strings.Repeat("y", MaxArtifactBytes-10)},
+			{expectCmd: readArtifact("11", "rest.txt"), stdout: strings.Repeat("z", 11)},
+		},
+	}
+	useFakeDocker(t, client)
+
+	inv := Invocation{CollectFiles: []string{"big.bin", "small.txt", "rest.txt"}}
+	res, err := Execute(context.Background(), LangPython, "code", Limits{}, inv)
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if len(res.Artifacts) != 3 {
+		t.Fatalf("expected three artifacts, got %+v", res.Artifacts)
+	}
+	if got := res.Artifacts[0]; got.Error != "too_large" || got.Content != "" {
+		t.Fatalf("expected the oversized file to be refused, got %+v", got)
+	}
+	if got := res.Artifacts[1]; got.Error != "" || got.Size != MaxArtifactBytes-10 {
+		t.Fatalf("expected the file within the cap to be kept, got size %d error %q", got.Size, got.Error)
+	}
+	if got := res.Artifacts[2]; got.Error != "too_large" {
+		t.Fatalf("expected the cap to cover all artifacts together, got %+v", got)
+	}
+}
+
+func TestValidateCollectFiles(t *testing.T) {
+	for _, p := range []string{"../etc/passwd", "/etc/passwd", "/workspace/", "out/../../x", ""} {
+		var invErr *InvocationError
+		if !errors.As(Invocation{CollectFiles: []string{p}}.Validate(), &invErr) ||
+			len(invErr.Fields) != 1 || invErr.Fields[0].Field != "collectFiles[0]" {
+			t.Fatalf("expected %q to be rejected, got %+v", p, invErr)
+		}
+	}
+
+	var invErr *InvocationError
+	if !errors.As(Invocation{CollectFiles: make([]string, MaxCollectFiles+1)}.Validate(), &invErr) || invErr.Fields[0].Field != "collectFiles" {
+		t.Fatalf("expected too many files to be rejected, got %+v", invErr)
+	}
+
+	ok := Invocation{CollectFiles: []string{"out.csv", "/workspace/out/result.json", "./notes.txt"}}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("expected workspace paths to pass, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ResultCacheConfig sizes the cache of recent results
+type ResultCacheConfig struct {
+	// Size is the number of results kept; the least recently used goes first
+	Size int
+	// TTL is how long a result is served again after its run finished
+	TTL time.Duration
+	// OnLookup, if set, is told whether a run was answered from the cache
+	OnLookup func(hit bool)
+}
+
+// DefaultResultCacheConfig keeps the last 256 results for 30 seconds, long
+// enough for both users of a session pressing Run on the same code
+func DefaultResultCacheConfig() ResultCacheConfig {
+	return ResultCacheConfig{Size: 256, TTL: 30 * time.Second}
+}
+
+// ResultCache answers a run identical to a recent one with that run's result
+// instead of starting a container. Runs are identical when their language,
+// code, invocation and resolved limits are; only results the sandbox
+// completed, without an error or cancellation, are kept.
+type ResultCache struct {
+	cfg ResultCacheConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[cacheKey]*list.Element
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	result  Result
+	expires time.Time
+}
+
+var activeCache *ResultCache
+
+// SetResultCache makes Execute serve identical runs from c; nil, the default,
+// runs every request.
+func SetResultCache(c *ResultCache) {
+	activeCache = c
+}
+
+// NewResultCache returns an empty cache; zero fields of cfg take their
+// defaults.
+func NewResultCache(cfg ResultCacheConfig) *ResultCache {
+	def := DefaultResultCacheConfig()
+	if cfg.Size <= 0 {
+		cfg.Size = def.Size
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = def.TTL
+	}
+	return &ResultCache{
+		cfg:     cfg,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// cacheKey identifies a run by everything that decides its result
+type cacheKey [sha256.Size]byte
+
+func resultKey(lang Language, code string, limits Limits, inv Invocation) cacheKey {
+	b, _ := json.Marshal(struct {
+		Lang       Language
+		Code       string
+		Limits     Limits
+		Invocation Invocation
+	}{lang, code, limits, inv})
+	return sha256.Sum256(b)
+}
+
+// get returns the result cached under key, marked as cached
+func (c *ResultCache) get(key cacheKey) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && c.now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if c.cfg.OnLookup != nil {
+		c.cfg.OnLookup(ok)
+	}
+	if !ok {
+		return Result{}, false
+	}
+	c.order.MoveToFront(el)
+	result := el.Value.(*cacheEntry).result
+	result.Cached = true
+	return result, true
+}
+
+// put keeps result under key if it is worth serving again
+func (c *ResultCache) put(key cacheKey, result Result) {
+	if result.Error != "" || result.Exit.Cancelled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, result: result, expires: c.now().Add(c.cfg.TTL)})
+	for c.order.Len() > c.cfg.Size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *ResultCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
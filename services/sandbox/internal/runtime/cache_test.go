@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func useResultCache(t *testing.T, cfg ResultCacheConfig) *ResultCache {
+	t.Helper()
+	c := NewResultCache(cfg)
+	SetResultCache(c)
+	t.Cleanup(func() { SetResultCache(nil) })
+	return c
+}
+
+func TestExecuteServesIdenticalRunFromCache(t *testing.T) {
+	var lookups []bool
+	useResultCache(t, ResultCacheConfig{OnLookup: func(hit bool) { lookups = append(lookups, hit) }})
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{expectCmd: []string{"python3", "main.py"}, stdout: "hi\n"},
+		},
+	}
+	dials := 0
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) {
+		dials++
+		return client, nil
+	}
+	t.Cleanup(func() { newDockerClient = orig })
+
+	inv := Invocation{Stdin: "x"}
+	first, err := Execute(context.Background(), LangPython, "print('hi')", Limits{}, inv)
+	if err != nil || first.Cached || first.Stdout != "hi\n" {
+		t.Fatalf("unexpected first result %+v err=%v", first, err)
+	}
+
+	var streamed []Event
+	second, err := ExecuteStream(context.Background(), LangPython, "print('hi')", Limits{}, inv, func(e Event) { streamed = append(streamed, e) })
+	if err != nil || !second.Cached || second.Stdout != "hi\n" {
+		t.Fatalf("expected the cached result, got %+v err=%v", second, err)
+	}
+	if dials != 1 || len(client.executed) != 4 {
+		t.Fatalf("expected the second run to skip docker, got %d clients and %d execs", dials, len(client.executed))
+	}
+	if len(streamed) != len(first.Events) || streamed[len(streamed)-1].Type != "exit" {
+		t.Fatalf("expected the cached events to be replayed, got %+v", streamed)
+	}
+	if len(lookups) != 2 || lookups[0] || !lookups[1] {
+		t.Fatalf("expected a miss then a hit, got %v", lookups)
+	}
+}
+
+func TestResultCacheKeepsOnlyCompletedRuns(t *testing.T) {
+	c := NewResultCache(ResultCacheConfig{})
+	failed, cancelled := resultKey(LangPython, "a", Limits{}, Invocation{}), resultKey(LangPython, "b", Limits{}, Invocation{})
+	c.put(failed, Result{Error: "sandbox_unavailable"})
+	c.put(cancelled, Result{Exit: ExitInfo{Cancelled: true}})
+	if _, ok := c.get(failed); ok {
+		t.Fatalf("expected a failed run not to be cached")
+	}
+	if _, ok := c.get(cancelled); ok {
+		t.Fatalf("expected a cancelled run not to be cached")
+	}
+}
+
+func TestResultCacheExpiresAndEvicts(t *testing.T) {
+	c := NewResultCache(ResultCacheConfig{Size: 2, TTL: time.Second})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	a := resultKey(LangPython, "a", Limits{}, Invocation{})
+	b := resultKey(LangPython, "b", Limits{}, Invocation{})
+	d := resultKey(LangPython, "d", Limits{}, Invocation{})
+	c.put(a, Result{Stdout: "a"})
+	c.put(b, Result{Stdout: "b"})
+	if _, ok := c.get(a); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	c.put(d, Result{Stdout: "d"})
+	if _, ok := c.get(b); ok {
+		t.Fatalf("expected the least recently used entry to be evicted")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.get(a); ok {
+		t.Fatalf("expected a to expire after the TTL")
+	}
+}
+
+func TestResultKeyCoversRun(t *testing.T) {
+	base := resultKey(LangPython, "code", Limits{WallTime: time.Second}, Invocation{Stdin: "1"})
+	for name, key := range map[string]cacheKey{
+		"language": resultKey(LangJavaScript, "code", Limits{WallTime: time.Second}, Invocation{Stdin: "1"}),
+		"code":     resultKey(LangPython, "code2", Limits{WallTime: time.Second}, Invocation{Stdin: "1"}),
+		"limits":   resultKey(LangPython, "code", Limits{WallTime: 2 * time.Second}, Invocation{Stdin: "1"}),
+		"stdin":    resultKey(LangPython, "code", Limits{WallTime: time.Second}, Invocation{Stdin: "2"}),
+		"args":     resultKey(LangPython, "code", Limits{WallTime: time.Second}, Invocation{Stdin: "1", Args: []string{"-v"}}),
+	} {
+		if key == base {
+			t.Fatalf("expected the %s to change the key", name)
+		}
+	}
+	if resultKey(LangPython, "code", Limits{WallTime: time.Second}, Invocation{Stdin: "1"}) != base {
+		t.Fatalf("expected identical runs to share a key")
+	}
+}
@@ -0,0 +1,128 @@
+package runtime
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bounds on a multi-file submission
+const (
+	MaxFiles       = 32
+	MaxFilePathLen = 256
+)
+
+const workspaceDir = "/workspace"
+
+// SourceFile is one file of a submission; Path is relative to the workspace,
+// or absolute under it
+type SourceFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// ValidateFiles checks a multi-file submission for lang: every path must stay
+// inside the workspace and be unique, and the language's entry file must be
+// among them. It returns an *InvocationError listing every violation, or nil;
+// no files at all is valid.
+func ValidateFiles(lang Language, files []SourceFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	var fields []FieldError
+	if len(files) > MaxFiles {
+		fields = append(fields, FieldError{Field: "files", Reason: "at most 32 files are allowed"})
+	}
+	seen := make(map[string]bool, len(files))
+	for i, f := range files {
+		field := "files[" + strconv.Itoa(i) + "].path"
+		rel, ok := workspacePath(f.Path)
+		switch {
+		case len(f.Path) > MaxFilePathLen:
+			fields = append(fields, FieldError{Field: field, Reason: "must be at most 256 characters"})
+		case !ok:
+			fields = append(fields, FieldError{Field: field, Reason: "must be a file inside /workspace"})
+		case seen[rel]:
+			fields = append(fields, FieldError{Field: field, Reason: "duplicate path"})
+		}
+		seen[rel] = true
+	}
+	if spec, _, _, _, err := langSpec(lang); err == nil && !seen[spec.FileName] {
+		fields = append(fields, FieldError{Field: "files", Reason: "must include " + spec.FileName})
+	}
+	if len(fields) > 0 {
+		return &InvocationError{Fields: fields}
+	}
+	return nil
+}
+
+// workspacePath returns p relative to the workspace, failing for paths that
+// are empty, name a directory or climb out of the workspace through ".." or
+// an absolute path elsewhere
+func workspacePath(p string) (string, bool) {
+	if strings.HasPrefix(p, "/") {
+		var ok bool
+		if p, ok = strings.CutPrefix(p, workspaceDir+"/"); !ok {
+			return "", false
+		}
+	}
+	if p == "" || strings.HasSuffix(p, "/") || strings.Contains(p, "\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	p = path.Clean(p)
+	return p, p != "." && !strings.HasPrefix(p, "/")
+}
+
+// submissionFiles returns files with workspace-relative paths, or code as
+// the language's single entry file when there are none
+func submissionFiles(fileName, code string, files []SourceFile) []SourceFile {
+	if len(files) == 0 {
+		return []SourceFile{{Path: fileName, Content: code}}
+	}
+	out := make([]SourceFile, len(files))
+	for i, f := range files {
+		rel, _ := workspacePath(f.Path)
+		out[i] = SourceFile{Path: rel, Content: f.Content}
+	}
+	return out
+}
+
+// expandBuild expands the wildcards of every command but the last, which
+// runs the program and takes no source files
+func expandBuild(cmds [][]string, files []SourceFile) {
+	for i := range cmds[:len(cmds)-1] {
+		cmds[i] = expandSources(cmds[i], files)
+	}
+}
+
+// expandSources replaces every argument of cmd holding a wildcard, such as
+// *.java, with the submitted files whose name matches it, in path order. An
+// argument nothing matches is kept, so the compiler reports it.
+func expandSources(cmd []string, files []SourceFile) []string {
+	out := make([]string, 0, len(cmd))
+	for _, arg := range cmd {
+		if !strings.Contains(arg, "*") {
+			out = append(out, arg)
+			continue
+		}
+		var matches []string
+		for _, f := range files {
+			if ok, _ := path.Match(arg, path.Base(f.Path)); ok {
+				matches = append(matches, f.Path)
+			}
+		}
+		if len(matches) == 0 {
+			out = append(out, arg)
+			continue
+		}
+		sort.Strings(matches)
+		out = append(out, matches...)
+	}
+	return out
+}
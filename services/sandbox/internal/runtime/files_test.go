@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestExecuteMultiFileJava(t *testing.T) {
+	// Each file is written with mkdir, cat and chmod
+	copies := []*fakeExecCall{
+		{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/Main.java'"}},
+		{},
+		{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace/util'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/util/Helper.java'"}},
+		{},
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: append(copies,
+			&fakeExecCall{expectCmd: []string{"javac", "Main.java", "util/Helper.java"}},
+			&fakeExecCall{expectCmd: []string{"java", "Main"}, stdout: "42\n"},
+		),
+	}
+	useFakeDocker(t, client)
+
+	files := []SourceFile{
+		{Path: "/workspace/Main.java", Content: "public class Main {}"},
+		{Path: "util/Helper.java", Content: "package util; public class Helper {}"},
+	}
+	res, err := Execute(context.Background(), LangJava, "", Limits{}, Invocation{Files: files})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result error %q err=%v", res.Error, err)
+	}
+	if res.Stdout != "42\n" || res.Exit.Code != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if got := copies[1].stdin.String(); got != "public class Main {}" {
+		t.Fatalf("expected Main.java's content to be written, got %q", got)
+	}
+}
+
+func TestValidateFilesRejectsPathsOutsideWorkspace(t *testing.T) {
+	files := []SourceFile{
+		{Path: "Main.java"},
+		{Path: "../etc/passwd"},
+		{Path: "/etc/passwd"},
+		{Path: "/workspace/../root/x"},
+		{Path: "util/../../x"},
+		{Path: "util/"},
+		{Path: "./Main.java"},
+	}
+	var invErr *InvocationError
+	if !errors.As(ValidateFiles(LangJava, files), &invErr) {
+		t.Fatalf("expected an invocation error")
+	}
+	want := []FieldError{
+		{Field: "files[1].path", Reason: "must be a file inside /workspace"},
+		{Field: "files[2].path", Reason: "must be a file inside /workspace"},
+		{Field: "files[3].path", Reason: "must be a file inside /workspace"},
+		{Field: "files[4].path", Reason: "must be a file inside /workspace"},
+		{Field: "files[5].path", Reason: "must be a file inside /workspace"},
+		{Field: "files[6].path", Reason: "duplicate path"},
+	}
+	if !reflect.DeepEqual(invErr.Fields, want) {
+		t.Fatalf("unexpected field errors:\n got %+v\nwant %+v", invErr.Fields, want)
+	}
+
+	res, err := Execute(context.Background(), LangJava, "", Limits{}, Invocation{Files: files})
+	if !errors.As(err, &invErr) || len(res.Events) != 0 {
+		t.Fatalf("expected the run to be rejected before starting, got %+v err=%v", res, err)
+	}
+}
+
+func TestValidateFilesRequiresEntryFile(t *testing.T) {
+	var invErr *InvocationError
+	err := ValidateFiles(LangPython, []SourceFile{{Path: "helper.py"}})
+	if !errors.As(err, &invErr) || invErr.Fields[0] != (FieldError{Field: "files", Reason: "must include main.py"}) {
+		t.Fatalf("expected the missing entry file to be reported, got %v", err)
+	}
+	if err := ValidateFiles(LangPython, nil); err != nil {
+		t.Fatalf("no files must be valid, got %v", err)
+	}
+}
+
+func TestExpandSourcesKeepsUnmatchedWildcards(t *testing.T) {
+	cmd := []string{"g++", "-O2", "*.cpp", "-o", "main"}
+	files := []SourceFile{{Path: "main.cpp"}, {Path: "lib/b.cpp"}, {Path: "lib/a.cpp"}, {Path: "lib/a.h"}}
+	if got := expandSources(cmd, files); !reflect.DeepEqual(got, []string{"g++", "-O2", "lib/a.cpp", "lib/b.cpp", "main.cpp", "-o", "main"}) {
+		t.Fatalf("unexpected expansion %v", got)
+	}
+	if got := expandSources([]string{"javac", "*.java"}, files); !reflect.DeepEqual(got, []string{"javac", "*.java"}) {
+		t.Fatalf("expected the unmatched wildcard to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,50 @@
+package runtime
+
+import "strings"
+
+// ImageConfig names the image every language runs in, e.g. to pull from a
+// registry mirror or pin digests
+type ImageConfig struct {
+	// Registry, if set, is prefixed to every image that names no registry of
+	// its own, such as the defaults
+	Registry string
+	// Images replaces the default image of the languages it lists
+	Images map[Language]string
+}
+
+var defaultImages = map[Language]string{
+	LangPython:     "python:3.11-slim",
+	LangJava:       "eclipse-temurin:17-jdk",
+	LangCPP:        "gcc:13",
+	LangJavaScript: "node:20-slim",
+	LangGo:         "golang:1.22-alpine",
+	LangRust:       "rust:1.78-slim",
+}
+
+var imageConfig ImageConfig
+
+// SetImageConfig makes later runs, warmups and readiness checks use the
+// images of cfg. It is meant for configuration at startup and must not race
+// with runs.
+func SetImageConfig(cfg ImageConfig) {
+	imageConfig = cfg
+}
+
+// ImageFor returns the image lang runs in, or "" for an unsupported language
+func ImageFor(lang Language) string {
+	image := defaultImages[lang]
+	if override := imageConfig.Images[lang]; override != "" {
+		image = override
+	}
+	if image == "" || imageConfig.Registry == "" || hasRegistry(image) {
+		return image
+	}
+	return strings.TrimSuffix(imageConfig.Registry, "/") + "/" + image
+}
+
+// hasRegistry reports whether image starts with a registry host, which like
+// Docker it tells from a repository by a dot, a port or being localhost
+func hasRegistry(image string) bool {
+	host, _, ok := strings.Cut(image, "/")
+	return ok && (strings.ContainsAny(host, ".:") || host == "localhost")
+}
@@ -0,0 +1,63 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestImageForFallsBackToDefaults(t *testing.T) {
+	t.Cleanup(func() { SetImageConfig(ImageConfig{}) })
+	SetImageConfig(ImageConfig{Images: map[Language]string{LangPython: "python:3.12-slim@sha256:abc"}})
+
+	if got := ImageFor(LangPython); got != "python:3.12-slim@sha256:abc" {
+		t.Fatalf("expected the python override, got %q", got)
+	}
+	if got := ImageFor(LangJava); got != "eclipse-temurin:17-jdk" {
+		t.Fatalf("expected java to keep its default, got %q", got)
+	}
+	if got := ImageFor(Language("cobol")); got != "" {
+		t.Fatalf("expected no image for an unsupported language, got %q", got)
+	}
+}
+
+func TestImageForPrefixesRegistry(t *testing.T) {
+	t.Cleanup(func() { SetImageConfig(ImageConfig{}) })
+	SetImageConfig(ImageConfig{
+		Registry: "mirror.internal:5000/",
+		Images: map[Language]string{
+			LangCPP: "gcc:14",
+			LangGo:  "ghcr.io/acme/golang:1.22",
+		},
+	})
+
+	for lang, want := range map[Language]string{
+		LangPython: "mirror.internal:5000/python:3.11-slim",
+		LangCPP:    "mirror.internal:5000/gcc:14",
+		LangGo:     "ghcr.io/acme/golang:1.22",
+	} {
+		if got := ImageFor(lang); got != want {
+			t.Fatalf("expected %s to run in %q, got %q", lang, want, got)
+		}
+	}
+}
+
+func TestExecuteCreatesConfiguredImage(t *testing.T) {
+	t.Cleanup(func() { SetImageConfig(ImageConfig{}) })
+	SetImageConfig(ImageConfig{Images: map[Language]string{LangPython: "registry.local/python:3.12"}})
+
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue:  []*fakeExecCall{{}, {}, {}, {}},
+	}
+	useFakeDocker(t, client)
+
+	if _, err := Execute(context.Background(), LangPython, "print(1)", Limits{}, Invocation{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if client.cfg == nil || client.cfg.Image != "registry.local/python:3.12" {
+		t.Fatalf("expected the container to be created from the configured image, got %+v", client.cfg)
+	}
+}
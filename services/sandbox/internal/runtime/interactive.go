@@ -0,0 +1,369 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// DefaultInteractiveWallTime bounds a session when the client sets no
+	// wall time; people type much slower than batch input arrives.
+	DefaultInteractiveWallTime = 5 * time.Minute
+	DefaultIdleTimeout         = 60 * time.Second
+	DefaultMaxOutputBytes      = 1 << 20
+)
+
+// Reasons an interactive session was stopped before the program exited
+const (
+	StopKilled      = "killed"
+	StopIdle        = "idle_timeout"
+	StopOutputLimit = "output_limit"
+	StopTimeout     = "timeout"
+)
+
+var ErrSessionClosed = errors.New("interactive session closed")
+
+type InteractiveOptions struct {
+	Limits         Limits
+	IdleTimeout    time.Duration // no stdin or output for this long stops the session
+	MaxOutputBytes int           // combined stdout and stderr, compile output included
+	Limiter        *Limiter      // slot held for the whole session when set
+}
+
+// InteractiveExit is the data of the final "exit" event of a session.
+type InteractiveExit struct {
+	Code     int    `json:"code"`
+	TimedOut bool   `json:"timedOut"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InteractiveSession runs a program with a live stdin. Output is delivered as
+// stdout/stderr events and the session always ends with an exit event, all
+// from a single goroutine, so emit needs no locking of its own.
+type InteractiveSession struct {
+	sbx         *Sandbox
+	emit        func(Event)
+	idleTimeout time.Duration
+	maxOutput   int
+	cancel      context.CancelFunc
+	release     func() // frees the limiter slot
+
+	ready chan struct{} // program exec attached, stdin writable
+	done  chan struct{}
+
+	writeMu     sync.Mutex // serializes writes to the hijacked stdin
+	stdin       net.Conn
+	stdinClosed bool
+
+	mu         sync.Mutex
+	attach     *types.HijackedResponse // current exec stream, closed to unblock reads on stop
+	stopReason string
+	exit       InteractiveExit
+
+	lastActivity atomic.Int64
+	written      int
+	outputFull   bool
+}
+
+// StartInteractive prepares a container for code and runs it with stdin
+// attached. Compile steps run first with their output streamed; stdin written
+// before the program starts is held until it does. ctx bounds the session
+// together with the wall time, and waiting for a limiter slot.
+func StartInteractive(ctx context.Context, lang Language, code string, opts InteractiveOptions, emit func(Event)) (*InteractiveSession, error) {
+	_, image, fileName, cmds, err := langSpec(lang)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limits.WallTime <= 0 {
+		opts.Limits.WallTime = DefaultInteractiveWallTime
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.MaxOutputBytes <= 0 {
+		opts.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+
+	sbx, err := NewSandbox(image, withLanguageDefaults(lang, opts.Limits))
+	if err != nil {
+		return nil, err
+	}
+
+	release := func() {}
+	if opts.Limiter != nil {
+		if err := opts.Limiter.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		release = opts.Limiter.Release
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, sbx.limits.WallTime)
+	s := &InteractiveSession{
+		sbx:         sbx,
+		emit:        emit,
+		idleTimeout: opts.IdleTimeout,
+		maxOutput:   opts.MaxOutputBytes,
+		cancel:      cancel,
+		release:     release,
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	expandBuild(cmds, submissionFiles(fileName, code, nil))
+	go s.run(runCtx, fileName, []byte(code), cmds)
+	return s, nil
+}
+
+// WriteStdin sends p to the program, waiting for it to start if needed.
+func (s *InteractiveSession) WriteStdin(p []byte) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	case <-s.ready:
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.stdinClosed {
+		return ErrSessionClosed
+	}
+	s.touch()
+	_, err := s.stdin.Write(p)
+	return err
+}
+
+// CloseStdin signals EOF to the program.
+func (s *InteractiveSession) CloseStdin() error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	case <-s.ready:
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.stdinClosed {
+		return nil
+	}
+	s.stdinClosed = true
+	if closer, ok := s.stdin.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+// Kill stops the program early. The exit event still follows.
+func (s *InteractiveSession) Kill() {
+	s.stop(StopKilled)
+}
+
+// Done is closed once the exit event has been emitted and the container is gone.
+func (s *InteractiveSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Exit returns the final status; valid once Done is closed.
+func (s *InteractiveSession) Exit() InteractiveExit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exit
+}
+
+func (s *InteractiveSession) run(ctx context.Context, fileName string, code []byte, cmds [][]string) {
+	defer close(s.done)
+	defer s.release() // before done, so a finished session no longer counts
+	defer s.cancel()
+
+	cid, err := s.sbx.startContainer(ctx)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	defer s.sbx.removeContainer(cid)
+	observer.containers(1)
+	defer observer.containers(-1)
+
+	// The watcher kills the container on stop, wall time or idleness; it must
+	// be gone before the exit event so nothing touches the container after.
+	programDone := make(chan struct{})
+	var watcher sync.WaitGroup
+	watcher.Add(1)
+	go func() {
+		defer watcher.Done()
+		s.watch(ctx, cid, programDone)
+	}()
+	finish := func(code int, err error) {
+		close(programDone)
+		watcher.Wait()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.finish(code)
+	}
+
+	if err := s.sbx.copyFile(ctx, cid, "/workspace/"+fileName, code, 0600); err != nil {
+		finish(-1, translateDockerErr(err))
+		return
+	}
+
+	for i, cmd := range cmds {
+		last := i == len(cmds)-1
+		execID, attach, err := s.sbx.execAttach(ctx, cid, cmd, nil, last)
+		if err != nil {
+			finish(-1, err)
+			return
+		}
+		s.setAttach(&attach)
+		if last {
+			s.stdin = attach.Conn
+			s.touch()
+			close(s.ready)
+		}
+		_, _ = stdcopy.StdCopy(s.output("stdout"), s.output("stderr"), attach.Reader)
+		attach.Close()
+		s.setAttach(nil)
+
+		ir, err := s.sbx.cli.ContainerExecInspect(context.Background(), execID)
+		if err != nil {
+			finish(-1, translateDockerErr(err))
+			return
+		}
+		if ir.ExitCode != 0 || last {
+			finish(ir.ExitCode, nil)
+			return
+		}
+	}
+	finish(0, nil)
+}
+
+// watch enforces the wall time and idle timeout until the program finishes.
+func (s *InteractiveSession) watch(ctx context.Context, cid string, programDone <-chan struct{}) {
+	tick := s.idleTimeout / 4
+	if tick > time.Second {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-programDone:
+			return
+		case <-ticker.C:
+			if s.idle() {
+				s.stop(StopIdle)
+			}
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				s.stop(StopTimeout)
+			} else {
+				s.stop(StopKilled)
+			}
+			_ = s.sbx.cli.ContainerKill(context.Background(), cid, "SIGKILL")
+			s.mu.Lock()
+			if s.attach != nil {
+				s.attach.Close()
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// stop records the first reason the session was stopped and cancels it.
+func (s *InteractiveSession) stop(reason string) {
+	s.mu.Lock()
+	if s.stopReason == "" {
+		s.stopReason = reason
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func (s *InteractiveSession) setAttach(attach *types.HijackedResponse) {
+	s.mu.Lock()
+	s.attach = attach
+	stopped := s.stopReason != ""
+	s.mu.Unlock()
+	if stopped && attach != nil {
+		// Stopped between execs; the watcher has already gone
+		attach.Close()
+	}
+}
+
+// idle reports whether the running program has seen no I/O for idleTimeout.
+// Compilation does not count, only the program itself.
+func (s *InteractiveSession) idle() bool {
+	select {
+	case <-s.ready:
+	default:
+		return false
+	}
+	return time.Since(time.Unix(0, s.lastActivity.Load())) >= s.idleTimeout
+}
+
+func (s *InteractiveSession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// output streams program output, stopping the session once the cap is hit.
+func (s *InteractiveSession) output(stream string) writerFunc {
+	return func(p []byte) {
+		if s.outputFull {
+			return
+		}
+		if remaining := s.maxOutput - s.written; len(p) > remaining {
+			p = p[:remaining]
+			s.outputFull = true
+		}
+		s.written += len(p)
+		if len(p) > 0 {
+			s.touch()
+			s.emit(Event{Type: stream, Data: string(p)})
+		}
+		if s.outputFull {
+			s.stop(StopOutputLimit)
+		}
+	}
+}
+
+func (s *InteractiveSession) finish(code int) {
+	s.mu.Lock()
+	exit := InteractiveExit{Code: code, Reason: s.stopReason}
+	if exit.Reason != "" {
+		exit.Code = -1
+		exit.TimedOut = exit.Reason == StopTimeout
+	}
+	s.exit = exit
+	s.mu.Unlock()
+	s.emit(Event{Type: "exit", Data: exit})
+}
+
+// fail ends the session on a setup error. Errors caused by a stop are
+// reported as the stop instead.
+func (s *InteractiveSession) fail(err error) {
+	s.mu.Lock()
+	stopped := s.stopReason != ""
+	s.mu.Unlock()
+	if stopped {
+		s.finish(-1)
+		return
+	}
+	msg := mapSandboxError(err)
+	s.emit(Event{Type: "error", Data: msg})
+	s.mu.Lock()
+	s.exit = InteractiveExit{Code: -1, Error: msg}
+	s.mu.Unlock()
+	s.emit(Event{Type: "exit", Data: s.Exit()})
+}
+
+// ErrorCode maps an execution error onto the codes reported to clients.
+func ErrorCode(err error) string {
+	return mapSandboxError(err)
+}
@@ -0,0 +1,304 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// pythonSetupCalls are the execs copyFile issues before running main.py
+func pythonSetupCalls() []*fakeExecCall {
+	return []*fakeExecCall{
+		{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.py'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.py'"}},
+	}
+}
+
+func useFakeDocker(t *testing.T, client *fakeDockerClient) {
+	t.Helper()
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	t.Cleanup(func() { newDockerClient = orig })
+}
+
+// eventLog collects session events; emit runs on the session goroutine.
+type eventLog chan Event
+
+func (l eventLog) emit(e Event) { l <- e }
+
+func (l eventLog) next(t *testing.T) Event {
+	t.Helper()
+	select {
+	case e := <-l:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for session event")
+		return Event{}
+	}
+}
+
+func waitDone(t *testing.T, s *InteractiveSession) {
+	t.Helper()
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("session did not finish")
+	}
+}
+
+func echoClient(t *testing.T) *fakeDockerClient {
+	return &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: append(pythonSetupCalls(), &fakeExecCall{
+			expectCmd: []string{"python3", "main.py"},
+			echo:      true,
+		}),
+	}
+}
+
+func TestInteractiveStdinRoundTrip(t *testing.T) {
+	client := echoClient(t)
+	useFakeDocker(t, client)
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "print(input())", InteractiveOptions{}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	for _, line := range []string{"42\n", "7\n"} {
+		if err := s.WriteStdin([]byte(line)); err != nil {
+			t.Fatalf("write stdin: %v", err)
+		}
+		if e := events.next(t); e.Type != "stdout" || e.Data != line {
+			t.Fatalf("expected stdout %q, got %+v", line, e)
+		}
+	}
+	if err := s.CloseStdin(); err != nil {
+		t.Fatalf("close stdin: %v", err)
+	}
+
+	e := events.next(t)
+	if e.Type != "exit" || e.Data != (InteractiveExit{Code: 0}) {
+		t.Fatalf("expected clean exit, got %+v", e)
+	}
+	waitDone(t, s)
+	if !client.removed {
+		t.Fatalf("expected container removal")
+	}
+	if got := client.executed[3].stdin.String(); got != "42\n7\n" {
+		t.Fatalf("unexpected stdin seen by program: %q", got)
+	}
+	if err := s.WriteStdin([]byte("late")); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed after exit, got %v", err)
+	}
+}
+
+func TestInteractiveKill(t *testing.T) {
+	client := echoClient(t)
+	useFakeDocker(t, client)
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := s.WriteStdin([]byte("hi\n")); err != nil {
+		t.Fatalf("write stdin: %v", err)
+	}
+	events.next(t)
+	s.Kill()
+	waitDone(t, s)
+
+	if got := s.Exit(); got.Reason != StopKilled || got.Code != -1 || got.TimedOut {
+		t.Fatalf("unexpected exit: %+v", got)
+	}
+	if e := events.next(t); e.Type != "exit" {
+		t.Fatalf("expected exit event, got %+v", e)
+	}
+	if len(client.killCalls) != 1 || client.killCalls[0] != "SIGKILL" || !client.removed {
+		t.Fatalf("expected container kill and removal, kills=%v removed=%v", client.killCalls, client.removed)
+	}
+}
+
+func TestInteractiveIdleReaper(t *testing.T) {
+	client := echoClient(t)
+	useFakeDocker(t, client)
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{IdleTimeout: 50 * time.Millisecond}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitDone(t, s)
+
+	if got := s.Exit(); got.Reason != StopIdle {
+		t.Fatalf("expected idle timeout, got %+v", got)
+	}
+	if len(client.killCalls) != 1 {
+		t.Fatalf("expected idle session to be killed, got %v", client.killCalls)
+	}
+}
+
+func TestInteractiveWallTime(t *testing.T) {
+	useFakeDocker(t, echoClient(t))
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{Limits: Limits{WallTime: 50 * time.Millisecond}}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitDone(t, s)
+	if got := s.Exit(); !got.TimedOut || got.Reason != StopTimeout {
+		t.Fatalf("expected wall time exit, got %+v", got)
+	}
+}
+
+func TestInteractiveOutputCap(t *testing.T) {
+	useFakeDocker(t, echoClient(t))
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{MaxOutputBytes: 4}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	_ = s.WriteStdin([]byte("abcdefgh"))
+	if e := events.next(t); e.Data != "abcd" {
+		t.Fatalf("expected output truncated to the cap, got %+v", e)
+	}
+	waitDone(t, s)
+	if got := s.Exit(); got.Reason != StopOutputLimit {
+		t.Fatalf("expected output limit exit, got %+v", got)
+	}
+}
+
+func TestInteractiveCompileFailure(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.cpp'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.cpp'"}},
+			{
+				expectCmd: []string{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"},
+				stderr:    "error: expected ';'\n",
+				inspect:   types.ContainerExecInspect{ExitCode: 1},
+			},
+		},
+	}
+	useFakeDocker(t, client)
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangCPP, "int main(){}", InteractiveOptions{}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := s.WriteStdin([]byte("x")); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected stdin to be refused, got %v", err)
+	}
+	if e := events.next(t); e.Type != "stderr" || !strings.Contains(e.Data.(string), "expected") {
+		t.Fatalf("expected compiler output, got %+v", e)
+	}
+	if got := s.Exit(); got.Code != 1 || got.Reason != "" {
+		t.Fatalf("unexpected exit: %+v", got)
+	}
+}
+
+func TestInteractiveSetupError(t *testing.T) {
+	useFakeDocker(t, &fakeDockerClient{t: t, createErr: errors.New("boom")})
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitDone(t, s)
+	if e := events.next(t); e.Type != "error" || e.Data != "sandbox_error" {
+		t.Fatalf("expected error event, got %+v", e)
+	}
+	if got := s.Exit(); got.Error != "sandbox_error" || got.Code != -1 {
+		t.Fatalf("unexpected exit: %+v", got)
+	}
+}
+
+func TestInteractiveUnsupportedLanguage(t *testing.T) {
+	if _, err := StartInteractive(context.Background(), Language("cobol"), "", InteractiveOptions{}, func(Event) {}); ErrorCode(err) != "unsupported_language" {
+		t.Fatalf("expected unsupported_language, got %v", err)
+	}
+}
+
+func TestInteractiveLimiterAccounting(t *testing.T) {
+	useFakeDocker(t, echoClient(t))
+	limiter := NewLimiter(1)
+	events := make(eventLog, 16)
+
+	s, err := StartInteractive(context.Background(), LangPython, "", InteractiveOptions{Limiter: limiter}, events.emit)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if limiter.InUse() != 1 {
+		t.Fatalf("expected session to hold a slot, in use=%d", limiter.InUse())
+	}
+
+	// A second execution waits for the slot and gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := StartInteractive(ctx, LangPython, "", InteractiveOptions{Limiter: limiter}, events.emit); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait for a slot, got %v", err)
+	}
+
+	s.Kill()
+	waitDone(t, s)
+	if limiter.InUse() != 0 {
+		t.Fatalf("expected slot to be released, in use=%d", limiter.InUse())
+	}
+}
+
+func TestLimiterBoundsQueue(t *testing.T) {
+	l := NewQueuedLimiter(1, 1, 50*time.Millisecond)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- l.Acquire(context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for l.Queued() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	if err := <-waited; !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected the waiter to time out, got %v", err)
+	}
+	if l.Queued() != 0 || l.InUse() != 1 {
+		t.Fatalf("expected only the first slot taken, queued=%d in use=%d", l.Queued(), l.InUse())
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(0)
+	if l.Capacity() != 1 {
+		t.Fatalf("expected minimum capacity 1, got %d", l.Capacity())
+	}
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled acquire, got %v", err)
+	}
+	l.Release()
+	if l.InUse() != 0 {
+		t.Fatalf("expected no slots in use")
+	}
+}
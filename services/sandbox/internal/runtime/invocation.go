@@ -0,0 +1,119 @@
+package runtime
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bounds on the arguments, environment and input a run may set
+const (
+	MaxArgs        = 16
+	MaxArgLen      = 256
+	MaxEnvVars     = 20
+	MaxEnvValueLen = 1024
+	MaxStdinBytes  = 1 << 20
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// deniedEnv could change how the sandbox itself behaves; SANDBOX_* and
+// DOCKER_* are reserved as well.
+var deniedEnv = map[string]bool{"PATH": true, "LD_PRELOAD": true, "HOME": true}
+
+// Invocation is how the program is started: Args are appended to the
+// language's exec command (never the compile command), Env is set on it and
+// Stdin is its standard input, followed by EOF.
+type Invocation struct {
+	Args  []string          `json:"args,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+	Stdin string            `json:"stdin,omitempty"`
+
+	// Files, when set, are the submission in place of the code; see
+	// ValidateFiles
+	Files []SourceFile `json:"files,omitempty"`
+
+	// CollectFiles are read back from the workspace once the program has
+	// run, as the result's artifacts
+	CollectFiles []string `json:"collectFiles,omitempty"`
+}
+
+// FieldError describes one rejected argument or variable
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// InvocationError rejects an Invocation, with one entry per invalid field
+type InvocationError struct {
+	Fields []FieldError
+}
+
+func (e *InvocationError) Error() string { return "invalid_invocation" }
+
+// Validate checks inv against the argument and environment rules and returns
+// an *InvocationError listing every violation, or nil.
+func (inv Invocation) Validate() error {
+	var fields []FieldError
+	if len(inv.Args) > MaxArgs {
+		fields = append(fields, FieldError{Field: "args", Reason: "at most 16 arguments are allowed"})
+	}
+	for i, arg := range inv.Args {
+		if len(arg) > MaxArgLen {
+			fields = append(fields, FieldError{Field: "args[" + strconv.Itoa(i) + "]", Reason: "must be at most 256 characters"})
+		}
+	}
+	if len(inv.Env) > MaxEnvVars {
+		fields = append(fields, FieldError{Field: "env", Reason: "at most 20 variables are allowed"})
+	}
+	for _, key := range inv.envKeys() {
+		field := "env." + key
+		switch {
+		case !envKeyPattern.MatchString(key):
+			fields = append(fields, FieldError{Field: field, Reason: "name must match [A-Z_][A-Z0-9_]*"})
+		case deniedEnv[key] || strings.HasPrefix(key, "SANDBOX_") || strings.HasPrefix(key, "DOCKER_"):
+			fields = append(fields, FieldError{Field: field, Reason: "variable may not be set"})
+		case len(inv.Env[key]) > MaxEnvValueLen:
+			fields = append(fields, FieldError{Field: field, Reason: "value must be at most 1024 bytes"})
+		}
+	}
+	if len(inv.Stdin) > MaxStdinBytes {
+		fields = append(fields, FieldError{Field: "stdin", Reason: "must be at most 1048576 bytes"})
+	}
+	fields = append(fields, validateCollectFiles(inv.CollectFiles)...)
+	if len(fields) > 0 {
+		return &InvocationError{Fields: fields}
+	}
+	return nil
+}
+
+// command returns cmd with the arguments appended
+func (inv Invocation) command(cmd []string) []string {
+	if len(inv.Args) == 0 {
+		return cmd
+	}
+	return append(append([]string(nil), cmd...), inv.Args...)
+}
+
+// environ returns Env as KEY=VALUE pairs in key order
+func (inv Invocation) environ() []string {
+	keys := inv.envKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+	env := make([]string, len(keys))
+	for i, key := range keys {
+		env[i] = key + "=" + inv.Env[key]
+	}
+	return env
+}
+
+func (inv Invocation) envKeys() []string {
+	keys := make([]string, 0, len(inv.Env))
+	for key := range inv.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,126 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors returned by Acquire on a limiter with a bounded queue
+var (
+	ErrQueueFull    = errors.New("queue_full")
+	ErrQueueTimeout = errors.New("queue_timeout")
+)
+
+// Limiter caps how many executions (batch runs and interactive sessions) hold
+// a container at once. Callers that find every slot taken wait in a queue,
+// which may be bounded in length and in how long a caller waits.
+type Limiter struct {
+	slots    chan struct{}
+	maxQueue int
+	maxWait  time.Duration
+
+	mu       sync.Mutex
+	queued   int
+	drain    chan struct{} // closed by Drain to wake the current waiters
+	drainErr error
+}
+
+// NewLimiter returns a limiter with n slots and an unbounded queue; n <= 0
+// means one slot.
+func NewLimiter(n int) *Limiter {
+	return NewQueuedLimiter(n, 0, 0)
+}
+
+// NewQueuedLimiter returns a limiter with n slots where at most maxQueue
+// callers wait, each for at most maxWait; zero leaves either unbounded.
+func NewQueuedLimiter(n, maxQueue int, maxWait time.Duration) *Limiter {
+	if n <= 0 {
+		n = 1
+	}
+	return &Limiter{
+		slots:    make(chan struct{}, n),
+		maxQueue: maxQueue,
+		maxWait:  maxWait,
+		drain:    make(chan struct{}),
+	}
+}
+
+// Acquire takes a free slot or waits in the queue until one is freed. It
+// fails with ErrQueueFull when the queue is full, ErrQueueTimeout after
+// waiting maxWait, ctx's error once ctx is done, or the error passed to Drain
+// while waiting.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.maxQueue > 0 && l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		return ErrQueueFull
+	}
+	l.queued++
+	drain := l.drain
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		timer := time.NewTimer(l.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return ErrQueueTimeout
+	case <-drain:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.drainErr
+	}
+}
+
+// Drain fails every caller currently waiting in Acquire with err. Slots
+// already held and later calls to Acquire are unaffected.
+func (l *Limiter) Drain(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.drainErr = err
+	close(l.drain)
+	l.drain = make(chan struct{})
+}
+
+// Release frees a slot taken by Acquire.
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+// InUse reports how many slots are taken.
+func (l *Limiter) InUse() int {
+	return len(l.slots)
+}
+
+// Queued reports how many callers are waiting in Acquire.
+func (l *Limiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// Capacity reports the total number of slots.
+func (l *Limiter) Capacity() int {
+	return cap(l.slots)
+}
@@ -0,0 +1,179 @@
+package runtime
+
+import (
+	"errors"
+	"time"
+)
+
+// Limit presets selectable by callers. PresetDefault is the language's
+// default limits.
+const (
+	PresetStrict   = "strict"
+	PresetDefault  = "default"
+	PresetGenerous = "generous"
+)
+
+var Presets = []string{PresetStrict, PresetDefault, PresetGenerous}
+
+var ErrUnknownPreset = errors.New("unknown_preset")
+
+const mib = 1024 * 1024
+
+// MaxLimits are the absolute ceilings; resolved limits never exceed them,
+// whatever the preset or overrides ask for.
+var MaxLimits = Limits{
+	WallTime:       30 * time.Second,
+	MemoryB:        2048 * mib,
+	NanoCPUs:       2_000_000_000,
+	PidsLimit:      512,
+	MaxOutputBytes: 8 * mib,
+}
+
+// LimitProfile holds the limits of one language, keyed by preset
+type LimitProfile map[string]Limits
+
+// Defaults are the limits applied when a request sets none
+func (p LimitProfile) Defaults() Limits {
+	return p[PresetDefault]
+}
+
+// limitProfiles size each runtime: the JVM needs headroom for javac and its
+// own threads, while Python and C++ solutions fit in 256MB. Node reserves more
+// for V8 and its worker threads, and go build compiles the standard library
+// packages it needs on a cold cache with many threads. rustc is slow and
+// memory hungry when optimizing.
+var limitProfiles = map[Language]LimitProfile{
+	LangPython: {
+		PresetStrict:   {WallTime: 5 * time.Second, MemoryB: 64 * mib, NanoCPUs: 500_000_000, PidsLimit: 32, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 10 * time.Second, MemoryB: 256 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 20 * time.Second, MemoryB: 512 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 128, MaxOutputBytes: 4 * mib},
+	},
+	LangJava: {
+		PresetStrict:   {WallTime: 10 * time.Second, MemoryB: 512 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 128, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 15 * time.Second, MemoryB: 768 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 256, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 30 * time.Second, MemoryB: 1536 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 512, MaxOutputBytes: 4 * mib},
+	},
+	LangCPP: {
+		PresetStrict:   {WallTime: 5 * time.Second, MemoryB: 128 * mib, NanoCPUs: 500_000_000, PidsLimit: 32, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 10 * time.Second, MemoryB: 256 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 20 * time.Second, MemoryB: 1024 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 128, MaxOutputBytes: 4 * mib},
+	},
+	LangJavaScript: {
+		PresetStrict:   {WallTime: 5 * time.Second, MemoryB: 128 * mib, NanoCPUs: 500_000_000, PidsLimit: 32, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 10 * time.Second, MemoryB: 256 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 20 * time.Second, MemoryB: 768 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 128, MaxOutputBytes: 4 * mib},
+	},
+	LangGo: {
+		PresetStrict:   {WallTime: 10 * time.Second, MemoryB: 256 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 15 * time.Second, MemoryB: 512 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 128, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 30 * time.Second, MemoryB: 1024 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 256, MaxOutputBytes: 4 * mib},
+	},
+	LangRust: {
+		PresetStrict:   {WallTime: 10 * time.Second, MemoryB: 256 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 256 * 1024},
+		PresetDefault:  {WallTime: 15 * time.Second, MemoryB: 512 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 128, MaxOutputBytes: 1 * mib},
+		PresetGenerous: {WallTime: 30 * time.Second, MemoryB: 1024 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 256, MaxOutputBytes: 4 * mib},
+	},
+}
+
+// fallbackProfile serves languages without a profile; Execute rejects them
+// anyway, but the limits echoed back stay meaningful.
+var fallbackProfile = LimitProfile{
+	PresetStrict:   {WallTime: 5 * time.Second, MemoryB: 128 * mib, NanoCPUs: 500_000_000, PidsLimit: 32, MaxOutputBytes: 256 * 1024},
+	PresetDefault:  {WallTime: 10 * time.Second, MemoryB: 512 * mib, NanoCPUs: 1_000_000_000, PidsLimit: 64, MaxOutputBytes: 1 * mib},
+	PresetGenerous: {WallTime: 20 * time.Second, MemoryB: 1024 * mib, NanoCPUs: 2_000_000_000, PidsLimit: 128, MaxOutputBytes: 4 * mib},
+}
+
+// Languages lists the supported languages in display order
+func Languages() []Language {
+	return []Language{LangPython, LangJava, LangCPP, LangJavaScript, LangGo, LangRust}
+}
+
+// ProfileFor returns the limit profile of lang
+func ProfileFor(lang Language) LimitProfile {
+	if p, ok := limitProfiles[lang]; ok {
+		return p
+	}
+	return fallbackProfile
+}
+
+// SetLanguageDefaults replaces the positive fields of overrides in lang's
+// default preset, clamped to MaxLimits. It is meant for configuration at
+// startup and must not race with runs.
+func SetLanguageDefaults(lang Language, overrides Limits) {
+	profile, ok := limitProfiles[lang]
+	if !ok {
+		return
+	}
+	def := profile[PresetDefault]
+	if overrides.WallTime > 0 {
+		def.WallTime = overrides.WallTime
+	}
+	if overrides.MemoryB > 0 {
+		def.MemoryB = overrides.MemoryB
+	}
+	if overrides.NanoCPUs > 0 {
+		def.NanoCPUs = overrides.NanoCPUs
+	}
+	profile[PresetDefault] = ClampLimits(def)
+}
+
+// withLanguageDefaults fills the wall time, memory, CPUs and process count
+// that limits leaves zero from lang's defaults, so a caller that sets nothing
+// runs with the same limits as one asking for PresetDefault
+func withLanguageDefaults(lang Language, limits Limits) Limits {
+	def := ProfileFor(lang).Defaults()
+	if limits.WallTime <= 0 {
+		limits.WallTime = def.WallTime
+	}
+	if limits.MemoryB == 0 {
+		limits.MemoryB = def.MemoryB
+	}
+	if limits.NanoCPUs == 0 {
+		limits.NanoCPUs = def.NanoCPUs
+	}
+	if limits.PidsLimit == 0 {
+		limits.PidsLimit = def.PidsLimit
+	}
+	return limits
+}
+
+// ResolveLimits expands preset (the default preset when empty) for lang,
+// applies the positive fields of overrides and its AllowNetwork on top and
+// clamps the result to MaxLimits.
+func ResolveLimits(lang Language, preset string, overrides Limits) (Limits, error) {
+	if preset == "" {
+		preset = PresetDefault
+	}
+	limits, ok := ProfileFor(lang)[preset]
+	if !ok {
+		return Limits{}, ErrUnknownPreset
+	}
+
+	if overrides.WallTime > 0 {
+		limits.WallTime = overrides.WallTime
+	}
+	if overrides.MemoryB > 0 {
+		limits.MemoryB = overrides.MemoryB
+	}
+	if overrides.NanoCPUs > 0 {
+		limits.NanoCPUs = overrides.NanoCPUs
+	}
+	if overrides.PidsLimit > 0 {
+		limits.PidsLimit = overrides.PidsLimit
+	}
+	if overrides.MaxOutputBytes > 0 {
+		limits.MaxOutputBytes = overrides.MaxOutputBytes
+	}
+	limits.AllowNetwork = overrides.AllowNetwork
+	return ClampLimits(limits), nil
+}
+
+// ClampLimits caps every field of limits at MaxLimits
+func ClampLimits(limits Limits) Limits {
+	limits.WallTime = min(limits.WallTime, MaxLimits.WallTime)
+	limits.MemoryB = min(limits.MemoryB, MaxLimits.MemoryB)
+	limits.NanoCPUs = min(limits.NanoCPUs, MaxLimits.NanoCPUs)
+	limits.PidsLimit = min(limits.PidsLimit, MaxLimits.PidsLimit)
+	limits.MaxOutputBytes = min(limits.MaxOutputBytes, MaxLimits.MaxOutputBytes)
+	return limits
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestResolveLimitsAppliesLanguageDefaults(t *testing.T) {
+	py, err := ResolveLimits(LangPython, "", Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	java, err := ResolveLimits(LangJava, "", Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if py != ProfileFor(LangPython).Defaults() || java != ProfileFor(LangJava).Defaults() {
+		t.Fatalf("expected language defaults, got %+v and %+v", py, java)
+	}
+	if py.MemoryB != 256*mib || java.MemoryB <= 512*mib {
+		t.Fatalf("unexpected default memory: python %d, java %d", py.MemoryB, java.MemoryB)
+	}
+	for _, l := range []Limits{py, java} {
+		if l.WallTime <= 0 || l.NanoCPUs <= 0 || l.PidsLimit <= 0 || l.MaxOutputBytes <= 0 {
+			t.Fatalf("expected every limit to be set, got %+v", l)
+		}
+	}
+}
+
+func TestExecuteAppliesLanguageDefaults(t *testing.T) {
+	tests := []struct {
+		lang     Language
+		wallTime time.Duration
+		memoryB  int64
+	}{
+		{LangPython, 10 * time.Second, 256 * mib},
+		{LangJava, 15 * time.Second, 768 * mib},
+		{LangCPP, 10 * time.Second, 256 * mib},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.lang), func(t *testing.T) {
+			limits := withLanguageDefaults(tt.lang, Limits{})
+			if limits.WallTime != tt.wallTime || limits.MemoryB != tt.memoryB || limits.NanoCPUs != 1_000_000_000 {
+				t.Fatalf("unexpected effective limits %+v", limits)
+			}
+
+			// The run stops at container creation; the host config is all we need
+			client := &fakeDockerClient{t: t, createErr: errors.New("stop")}
+			useFakeDocker(t, client)
+			if _, err := Execute(context.Background(), tt.lang, "", Limits{}, Invocation{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client.hostCfg == nil || client.hostCfg.Memory != tt.memoryB || client.hostCfg.NanoCPUs != 1_000_000_000 {
+				t.Fatalf("expected %d bytes and one CPU on the container, got %+v", tt.memoryB, client.hostCfg)
+			}
+		})
+	}
+}
+
+func TestSetLanguageDefaultsOverridesDefaultPreset(t *testing.T) {
+	orig := ProfileFor(LangJava).Defaults()
+	t.Cleanup(func() { limitProfiles[LangJava][PresetDefault] = orig })
+
+	SetLanguageDefaults(LangJava, Limits{MemoryB: 1024 * mib, WallTime: time.Hour})
+	got := withLanguageDefaults(LangJava, Limits{})
+	if got.MemoryB != 1024*mib || got.WallTime != MaxLimits.WallTime || got.NanoCPUs != orig.NanoCPUs {
+		t.Fatalf("expected overridden and clamped defaults, got %+v", got)
+	}
+	if strict := ProfileFor(LangJava)[PresetStrict]; strict.MemoryB != 512*mib {
+		t.Fatalf("other presets must keep their limits, got %+v", strict)
+	}
+}
+
+func TestResolveLimitsExpandsPresets(t *testing.T) {
+	strict, err := ResolveLimits(LangCPP, PresetStrict, Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	generous, err := ResolveLimits(LangCPP, PresetGenerous, Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strict != limitProfiles[LangCPP][PresetStrict] || generous != limitProfiles[LangCPP][PresetGenerous] {
+		t.Fatalf("presets not expanded: %+v %+v", strict, generous)
+	}
+	if strict.MemoryB >= generous.MemoryB || strict.WallTime >= generous.WallTime {
+		t.Fatalf("expected strict to be tighter than generous: %+v %+v", strict, generous)
+	}
+
+	if _, err := ResolveLimits(LangCPP, "turbo", Limits{}); !errors.Is(err, ErrUnknownPreset) {
+		t.Fatalf("expected ErrUnknownPreset, got %v", err)
+	}
+}
+
+func TestResolveLimitsLayersOverridesOnPreset(t *testing.T) {
+	got, err := ResolveLimits(LangPython, PresetStrict, Limits{MemoryB: 96 * mib, PidsLimit: 16})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := limitProfiles[LangPython][PresetStrict]
+	want.MemoryB = 96 * mib
+	want.PidsLimit = 16
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestResolveLimitsClampsToMaxima(t *testing.T) {
+	got, err := ResolveLimits(LangJava, PresetGenerous, Limits{
+		WallTime:       time.Hour,
+		MemoryB:        64 * 1024 * mib,
+		NanoCPUs:       16_000_000_000,
+		PidsLimit:      100_000,
+		MaxOutputBytes: 1 << 30,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != MaxLimits {
+		t.Fatalf("expected limits clamped to %+v, got %+v", MaxLimits, got)
+	}
+	for _, lang := range Languages() {
+		for _, preset := range Presets {
+			if l := ProfileFor(lang)[preset]; ClampLimits(l) != l {
+				t.Fatalf("%s/%s preset exceeds the maxima: %+v", lang, preset, l)
+			}
+		}
+	}
+}
+
+func TestExecuteAppliesPidsAndOutputLimits(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{inspect: types.ContainerExecInspect{ExitCode: 0}},
+			{inspect: types.ContainerExecInspect{ExitCode: 0}},
+			{inspect: types.ContainerExecInspect{ExitCode: 0}},
+			{
+				expectCmd: []string{"python3", "main.py"},
+				inspect:   types.ContainerExecInspect{ExitCode: 0},
+				stdout:    "0123456789",
+				stderr:    "abcdef",
+			},
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	res, err := Execute(context.Background(), LangPython, "print()", Limits{PidsLimit: 8, MaxOutputBytes: 12}, Invocation{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.hostCfg == nil || client.hostCfg.Resources.PidsLimit == nil || *client.hostCfg.Resources.PidsLimit != 8 {
+		t.Fatalf("expected pids limit 8 on the container, got %+v", client.hostCfg)
+	}
+	if len(res.Stdout)+len(res.Stderr) != 12 || !res.OutputTruncated {
+		t.Fatalf("expected output capped at 12 bytes, got %q %q (truncated=%v)", res.Stdout, res.Stderr, res.OutputTruncated)
+	}
+}
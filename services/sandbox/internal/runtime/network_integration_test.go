@@ -0,0 +1,29 @@
+//go:build integration
+
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags integration ./internal/runtime (needs a Docker
+// daemon and network access to pull gcc:13)
+func TestIntegrationContainerHasNoNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := WarmImages(ctx, LangCPP); err != nil {
+		t.Skipf("docker unavailable: %v", err)
+	}
+
+	code := `#include <cstdlib>
+int main() { return std::system("curl -sS --max-time 5 -o /dev/null http://example.com") == 0 ? 0 : 1; }`
+	res, err := Execute(ctx, LangCPP, code, Limits{WallTime: 30 * time.Second}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected error %q err=%v (stderr %q)", res.Error, err, res.Stderr)
+	}
+	if res.Exit.Code != 1 {
+		t.Fatalf("expected curl to fail without a network, exit %d stderr %q", res.Exit.Code, res.Stderr)
+	}
+}
@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Outcomes of a run as reported to RunObserver.OnRun. A run whose program
+// exits non-zero still succeeded as far as the sandbox is concerned.
+const (
+	RunSuccess             = "success"
+	RunTimeout             = "timeout"
+	RunSandboxError        = "sandbox_error"
+	RunUnsupportedLanguage = "unsupported_language"
+	RunCancelled           = "cancelled"
+)
+
+// RunObserver is told about the runs of Execute, the containers in use and
+// image pulls, e.g. to export them as metrics. Every field is optional.
+type RunObserver struct {
+	OnRun func(lang Language, outcome string, took time.Duration)
+	// OnContainers is told +1 when a run or interactive session takes a
+	// container and -1 when it hands it back
+	OnContainers func(delta int)
+	OnImagePull  func(ok bool)
+}
+
+var observer RunObserver
+
+// SetObserver installs o for every later run; it is meant to be called once
+// at startup, before any run.
+func SetObserver(o RunObserver) {
+	observer = o
+}
+
+func (o RunObserver) run(lang Language, outcome string, took time.Duration) {
+	if o.OnRun != nil {
+		o.OnRun(lang, outcome, took)
+	}
+}
+
+func (o RunObserver) containers(delta int) {
+	if o.OnContainers != nil {
+		o.OnContainers(delta)
+	}
+}
+
+func (o RunObserver) imagePull(ok bool) {
+	if o.OnImagePull != nil {
+		o.OnImagePull(ok)
+	}
+}
+
+// runOutcome classifies a finished run. The wall time cancels runCtx, so a
+// run that failed once it expired, and not because the caller gave up, has
+// timed out.
+func runOutcome(ctx, runCtx context.Context, result Result, runErr error) string {
+	switch {
+	case result.Exit.Cancelled:
+		return RunCancelled
+	case result.Exit.TimedOut:
+		return RunTimeout
+	case runErr != nil && ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		return RunTimeout
+	case runErr != nil:
+		return RunSandboxError
+	}
+	return RunSuccess
+}
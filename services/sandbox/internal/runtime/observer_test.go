@@ -0,0 +1,77 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"sandbox/internal/metrics"
+)
+
+// scrapeMetric returns the value of series on the metrics endpoint, 0 when
+// it has not been exported yet
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("bad value for %s: %v", series, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestExecuteExportsRunMetrics(t *testing.T) {
+	SetObserver(RunObserver{
+		OnRun: func(lang Language, outcome string, took time.Duration) {
+			metrics.RecordRun(string(lang), outcome, took)
+		},
+		OnContainers: metrics.AddContainersInFlight,
+		OnImagePull:  metrics.IncImagePull,
+	})
+	defer SetObserver(RunObserver{})
+
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue:  []*fakeExecCall{{}, {}, {}, {stdout: "hi\n"}},
+	}
+	useFakeDocker(t, client)
+
+	const success = `peerprep_sandbox_runs_total{language="python",outcome="success"}`
+	const unsupported = `peerprep_sandbox_runs_total{language="cobol",outcome="unsupported_language"}`
+	const durations = `peerprep_sandbox_run_duration_seconds_count{language="python"}`
+	before := map[string]float64{}
+	for _, series := range []string{success, unsupported, durations} {
+		before[series] = scrapeMetric(t, series)
+	}
+
+	if _, err := Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if _, err := Execute(context.Background(), Language("cobol"), "", Limits{}, Invocation{}); err == nil {
+		t.Fatalf("expected an unsupported language to fail")
+	}
+
+	for _, series := range []string{success, unsupported, durations} {
+		if got := scrapeMetric(t, series); got != before[series]+1 {
+			t.Fatalf("expected %s to go up by one from %v, got %v", series, before[series], got)
+		}
+	}
+	if got := scrapeMetric(t, "peerprep_sandbox_containers_in_flight"); got != 0 {
+		t.Fatalf("expected the container to be handed back, got %v in flight", got)
+	}
+}
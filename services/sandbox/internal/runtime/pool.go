@@ -0,0 +1,293 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PoolConfig sizes the warm container pool
+type PoolConfig struct {
+	// Size is the number of idle containers kept per warmed image and limits
+	Size int
+	// MaxRuns recycles a container after it has served this many runs
+	MaxRuns int
+	// RefillInterval is how often the pool is topped up besides after every
+	// lease, so a failed refill is retried
+	RefillInterval time.Duration
+	// ResetTimeout bounds wiping a container between runs
+	ResetTimeout time.Duration
+	// OnLease, if set, is told whether a lease was served from the pool
+	OnLease func(hit bool)
+}
+
+// DefaultPoolConfig keeps two warm containers per image, each serving up to
+// 50 runs
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		Size:           2,
+		MaxRuns:        50,
+		RefillInterval: 5 * time.Second,
+		ResetTimeout:   5 * time.Second,
+	}
+}
+
+// resetCommand kills whatever a run left behind and wipes its files, so the
+// next run cannot see them
+const resetCommand = "kill -KILL -1 2>/dev/null; find /workspace /tmp -mindepth 1 -delete"
+
+// Pool keeps idle containers warm for the images and limits it was told to
+// Warm. A run leases a container and hands it back afterwards; the container
+// is wiped and reused unless the run failed in the sandbox or the container
+// has served MaxRuns runs. Runs with other limits get a fresh container that
+// is removed afterwards.
+type Pool struct {
+	cfg    PoolConfig
+	cli    dockerClient
+	refill chan struct{}
+
+	mu     sync.Mutex
+	warm   map[poolKey]bool
+	idle   map[poolKey][]*pooledContainer
+	closed bool
+}
+
+// poolKey is what a container is created with and so what a run must match
+// to reuse it
+type poolKey struct {
+	image        string
+	memoryB      int64
+	nanoCPUs     int64
+	pidsLimit    int64
+	allowNetwork bool
+}
+
+func poolKeyFor(image string, limits Limits) poolKey {
+	return poolKey{
+		image:        image,
+		memoryB:      limits.MemoryB,
+		nanoCPUs:     limits.NanoCPUs,
+		pidsLimit:    limits.PidsLimit,
+		allowNetwork: limits.AllowNetwork,
+	}
+}
+
+type pooledContainer struct {
+	id   string
+	key  poolKey
+	runs int
+}
+
+var activePool *Pool
+
+// SetPool makes Execute and RunTests lease containers from p; nil, the
+// default, starts a fresh container for every run.
+func SetPool(p *Pool) {
+	activePool = p
+}
+
+// NewPool returns an empty pool; zero fields of cfg take their defaults.
+func NewPool(cfg PoolConfig) (*Pool, error) {
+	def := DefaultPoolConfig()
+	if cfg.Size <= 0 {
+		cfg.Size = def.Size
+	}
+	if cfg.MaxRuns <= 0 {
+		cfg.MaxRuns = def.MaxRuns
+	}
+	if cfg.RefillInterval <= 0 {
+		cfg.RefillInterval = def.RefillInterval
+	}
+	if cfg.ResetTimeout <= 0 {
+		cfg.ResetTimeout = def.ResetTimeout
+	}
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, translateDockerErr(err)
+	}
+	return &Pool{
+		cfg:    cfg,
+		cli:    cli,
+		refill: make(chan struct{}, 1),
+		warm:   make(map[poolKey]bool),
+		idle:   make(map[poolKey][]*pooledContainer),
+	}, nil
+}
+
+// Warm keeps containers for lang with limits ready, filling up on the next
+// refill.
+func (p *Pool) Warm(lang Language, limits Limits) error {
+	_, image, _, _, err := langSpec(lang)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.warm[poolKeyFor(image, withDefaultLimits(withLanguageDefaults(lang, limits)))] = true
+	p.mu.Unlock()
+	p.requestRefill()
+	return nil
+}
+
+// Run tops the pool up until ctx ends, then removes the idle containers.
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.RefillInterval)
+	defer ticker.Stop()
+	for {
+		p.fill(ctx)
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return
+		case <-p.refill:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close removes the idle containers; containers leased at the time are
+// removed when handed back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	var idle []*pooledContainer
+	for key, containers := range p.idle {
+		idle = append(idle, containers...)
+		delete(p.idle, key)
+	}
+	p.mu.Unlock()
+	for _, c := range idle {
+		p.discard(c)
+	}
+}
+
+// Idle returns the number of idle containers across all keys
+func (p *Pool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, containers := range p.idle {
+		n += len(containers)
+	}
+	return n
+}
+
+// lease hands s a container for its image and limits, warm if one is idle.
+// fresh reports whether the container has not served a run before.
+func (p *Pool) lease(ctx context.Context, s *Sandbox) (cid string, fresh bool, release func(healthy bool), err error) {
+	key := poolKeyFor(s.image, s.limits)
+	p.mu.Lock()
+	var c *pooledContainer
+	if idle := p.idle[key]; len(idle) > 0 {
+		c = idle[len(idle)-1]
+		p.idle[key] = idle[:len(idle)-1]
+	}
+	p.mu.Unlock()
+	if p.cfg.OnLease != nil {
+		p.cfg.OnLease(c != nil)
+	}
+
+	if c == nil {
+		id, err := s.startContainer(ctx)
+		if err != nil {
+			return "", false, nil, err
+		}
+		c = &pooledContainer{id: id, key: key}
+	} else {
+		p.requestRefill()
+	}
+	return c.id, c.runs == 0, func(healthy bool) { p.release(c, healthy) }, nil
+}
+
+// release takes a container back after a run, wiping it for reuse or
+// removing it
+func (p *Pool) release(c *pooledContainer, healthy bool) {
+	c.runs++
+	p.mu.Lock()
+	keep := healthy && c.runs < p.cfg.MaxRuns && p.warm[c.key] && !p.closed
+	p.mu.Unlock()
+	if !keep || p.reset(c) != nil {
+		p.discard(c)
+		p.requestRefill()
+		return
+	}
+	p.put(c)
+}
+
+func (p *Pool) reset(c *pooledContainer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.ResetTimeout)
+	defer cancel()
+	return p.sandbox(c.key).runCommand(ctx, c.id, resetCommand)
+}
+
+// fill starts containers until every warmed key has Size idle ones. It stops
+// at the first failure, leaving the rest to the next refill.
+func (p *Pool) fill(ctx context.Context) {
+	for _, key := range p.keysToFill() {
+		for p.missing(key) > 0 {
+			id, err := p.sandbox(key).startContainer(ctx)
+			if err != nil {
+				return
+			}
+			p.put(&pooledContainer{id: id, key: key})
+		}
+	}
+}
+
+func (p *Pool) keysToFill() []poolKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]poolKey, 0, len(p.warm))
+	for key := range p.warm {
+		if len(p.idle[key]) < p.cfg.Size {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (p *Pool) missing(key poolKey) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0
+	}
+	return p.cfg.Size - len(p.idle[key])
+}
+
+// put adds c to the idle containers, removing it if the pool is full or closed
+func (p *Pool) put(c *pooledContainer) {
+	p.mu.Lock()
+	full := p.closed || len(p.idle[c.key]) >= p.cfg.Size
+	if !full {
+		p.idle[c.key] = append(p.idle[c.key], c)
+	}
+	p.mu.Unlock()
+	if full {
+		p.discard(c)
+	}
+}
+
+func (p *Pool) discard(c *pooledContainer) {
+	p.sandbox(c.key).removeContainer(c.id)
+}
+
+func (p *Pool) requestRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// sandbox returns a Sandbox creating containers for key on the pool's client
+func (p *Pool) sandbox(key poolKey) *Sandbox {
+	return &Sandbox{
+		cli:   p.cli,
+		image: key.image,
+		limits: Limits{
+			MemoryB:      key.memoryB,
+			NanoCPUs:     key.nanoCPUs,
+			PidsLimit:    key.pidsLimit,
+			AllowNetwork: key.allowNetwork,
+		},
+	}
+}
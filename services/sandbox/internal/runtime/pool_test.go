@@ -0,0 +1,146 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// usePool installs a pool on client warming python at the default limits
+func usePool(t *testing.T, client *fakeDockerClient, cfg PoolConfig) *Pool {
+	t.Helper()
+	useFakeDocker(t, client)
+	pool, err := NewPool(cfg)
+	if err != nil {
+		t.Fatalf("new pool: %v", err)
+	}
+	if err := pool.Warm(LangPython, Limits{}); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	SetPool(pool)
+	t.Cleanup(func() { SetPool(nil) })
+	pool.fill(context.Background())
+	return pool
+}
+
+// pythonRun queues the execs of one python run ending with program
+func pythonRun(program *fakeExecCall) []*fakeExecCall {
+	return []*fakeExecCall{
+		{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.py'"}},
+		{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.py'"}},
+		program,
+	}
+}
+
+func resetExec() *fakeExecCall {
+	return &fakeExecCall{expectCmd: []string{"/bin/sh", "-c", resetCommand}}
+}
+
+func TestPoolReusesWarmContainer(t *testing.T) {
+	client := &fakeDockerClient{t: t, uniqueIDs: true}
+	client.stats.MemoryStats.MaxUsage = 14 << 20
+	pool := usePool(t, client, PoolConfig{Size: 1})
+	if client.created != 1 || pool.Idle() != 1 {
+		t.Fatalf("expected one warm container, created %d idle %d", client.created, pool.Idle())
+	}
+
+	for i := 0; i < 2; i++ {
+		client.execQueue = append(pythonRun(&fakeExecCall{stdout: "hi\n"}), resetExec())
+		res, err := Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
+		if err != nil || res.Error != "" || res.Stdout != "hi\n" {
+			t.Fatalf("run %d: unexpected result %+v err=%v", i, res, err)
+		}
+		if got := res.Usage.PeakMemoryBytes; (i == 0) != (got != 0) {
+			t.Fatalf("run %d: expected memory only for the container's first run, got %d", i, got)
+		}
+	}
+
+	if client.created != 1 || len(client.removedIDs) != 0 || pool.Idle() != 1 {
+		t.Fatalf("expected the warm container to serve both runs, created %d removed %v", client.created, client.removedIDs)
+	}
+	for _, call := range client.executed {
+		if call.gotContainer != "c1" {
+			t.Fatalf("expected every exec in c1, got %q for %v", call.gotContainer, call.gotCmd)
+		}
+	}
+}
+
+func TestPoolDiscardsPoisonedContainer(t *testing.T) {
+	client := &fakeDockerClient{t: t, uniqueIDs: true}
+	pool := usePool(t, client, PoolConfig{Size: 1})
+
+	client.execQueue = pythonRun(&fakeExecCall{inspectErr: errors.New("exec vanished")})
+	res, _ := Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
+	if res.Error != "sandbox_error" {
+		t.Fatalf("expected the run to fail in the sandbox, got %+v", res)
+	}
+	if !reflect.DeepEqual(client.removedIDs, []string{"c1"}) || pool.Idle() != 0 {
+		t.Fatalf("expected c1 to be removed without a reset, removed %v idle %d", client.removedIDs, pool.Idle())
+	}
+
+	// The next run gets a fresh container rather than the poisoned one
+	client.execQueue = append(pythonRun(&fakeExecCall{}), resetExec())
+	if _, err := Execute(context.Background(), LangPython, "", Limits{}, Invocation{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if client.created != 2 || client.executed[len(client.executed)-1].gotContainer != "c2" {
+		t.Fatalf("expected a fresh c2, created %d", client.created)
+	}
+	pool.fill(context.Background())
+	if pool.Idle() != 1 {
+		t.Fatalf("expected the pool to be topped up, idle %d", pool.Idle())
+	}
+}
+
+func TestPoolRecyclesContainers(t *testing.T) {
+	client := &fakeDockerClient{t: t, uniqueIDs: true}
+	pool := usePool(t, client, PoolConfig{Size: 1, MaxRuns: 2})
+
+	// A failed reset discards the container
+	client.execQueue = append(pythonRun(&fakeExecCall{}), &fakeExecCall{
+		expectCmd: []string{"/bin/sh", "-c", resetCommand},
+		inspect:   types.ContainerExecInspect{ExitCode: 1},
+	})
+	_, _ = Execute(context.Background(), LangPython, "", Limits{}, Invocation{})
+	if !reflect.DeepEqual(client.removedIDs, []string{"c1"}) {
+		t.Fatalf("expected c1 to be removed after its reset failed, removed %v", client.removedIDs)
+	}
+
+	// MaxRuns retires a container after its last run without resetting it
+	pool.fill(context.Background())
+	client.execQueue = append(pythonRun(&fakeExecCall{}), resetExec())
+	_, _ = Execute(context.Background(), LangPython, "", Limits{}, Invocation{})
+	client.execQueue = pythonRun(&fakeExecCall{})
+	_, _ = Execute(context.Background(), LangPython, "", Limits{}, Invocation{})
+	if !reflect.DeepEqual(client.removedIDs, []string{"c1", "c2"}) || len(client.execQueue) != 0 {
+		t.Fatalf("expected c2 to be retired after two runs, removed %v", client.removedIDs)
+	}
+
+	// Runs with limits that are not warmed get a container of their own
+	pool.fill(context.Background())
+	client.execQueue = pythonRun(&fakeExecCall{})
+	_, _ = Execute(context.Background(), LangPython, "", Limits{MemoryB: 64 << 20}, Invocation{})
+	if client.created != 4 || client.removedIDs[len(client.removedIDs)-1] != "c4" || pool.Idle() != 1 {
+		t.Fatalf("expected a throwaway container, created %d removed %v idle %d", client.created, client.removedIDs, pool.Idle())
+	}
+
+	pool.Close()
+	if pool.Idle() != 0 || client.removedIDs[len(client.removedIDs)-1] != "c3" {
+		t.Fatalf("expected Close to remove the idle container, removed %v", client.removedIDs)
+	}
+}
+
+func TestPoolDiscardsContainerKilledForOutput(t *testing.T) {
+	client := &fakeDockerClient{t: t, uniqueIDs: true}
+	pool := usePool(t, client, PoolConfig{Size: 1})
+
+	client.execQueue = pythonRun(&fakeExecCall{stdout: "0123456789"})
+	res, _ := Execute(context.Background(), LangPython, "", Limits{MaxOutputBytes: 4}, Invocation{})
+	if !res.OutputTruncated || !reflect.DeepEqual(client.removedIDs, []string{"c1"}) || pool.Idle() != 0 {
+		t.Fatalf("expected the killed container to be removed, removed %v idle %d", client.removedIDs, pool.Idle())
+	}
+}
@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerLabel marks every container the sandbox creates, so that those a
+// crashed process left behind can be found and removed
+const ContainerLabel = "peerprep.sandbox"
+
+// liveContainers are the containers this process has started and not yet
+// removed
+var liveContainers = struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+func trackContainer(cid string) {
+	liveContainers.mu.Lock()
+	liveContainers.ids[cid] = true
+	liveContainers.mu.Unlock()
+}
+
+func untrackContainer(cid string) {
+	liveContainers.mu.Lock()
+	delete(liveContainers.ids, cid)
+	liveContainers.mu.Unlock()
+}
+
+// ReapContainers removes every labeled container, left over from a previous
+// process that crashed or was killed before cleaning up. It is meant for
+// startup, before any run, and returns how many containers it removed.
+func ReapContainers(ctx context.Context) (int, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return 0, translateDockerErr(err)
+	}
+	if closer, ok := cli.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	leftovers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ContainerLabel+"=1")),
+	})
+	if err != nil {
+		return 0, translateDockerErr(err)
+	}
+	removed := 0
+	for _, c := range leftovers {
+		if err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// RemoveContainers force-removes the containers this process still has, such
+// as those of runs that outlived shutdown and the idle ones of the pool. It
+// returns how many it removed.
+func RemoveContainers(ctx context.Context) (int, error) {
+	liveContainers.mu.Lock()
+	ids := make([]string, 0, len(liveContainers.ids))
+	for id := range liveContainers.ids {
+		ids = append(ids, id)
+	}
+	liveContainers.mu.Unlock()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return 0, translateDockerErr(err)
+	}
+	if closer, ok := cli.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	removed := 0
+	for _, id := range ids {
+		if err := cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true}); err == nil {
+			untrackContainer(id)
+			removed++
+		}
+	}
+	return removed, nil
+}
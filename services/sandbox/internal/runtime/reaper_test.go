@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestReapContainersRemovesLabeledContainers(t *testing.T) {
+	client := &fakeDockerClient{
+		t:      t,
+		listed: []types.Container{{ID: "left1"}, {ID: "left2"}},
+	}
+	useFakeDocker(t, client)
+
+	removed, err := ReapContainers(context.Background())
+	if err != nil || removed != 2 {
+		t.Fatalf("expected both leftovers removed, got %d err=%v", removed, err)
+	}
+	if !reflect.DeepEqual(client.removedIDs, []string{"left1", "left2"}) {
+		t.Fatalf("unexpected removals %v", client.removedIDs)
+	}
+	if got := client.listFilters.Get("label"); !reflect.DeepEqual(got, []string{"peerprep.sandbox=1"}) {
+		t.Fatalf("expected only labeled containers to be listed, got %v", got)
+	}
+}
+
+func TestRemoveContainersRemovesTrackedContainers(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+	}
+	useFakeDocker(t, client)
+	liveContainers.mu.Lock()
+	liveContainers.ids = make(map[string]bool) // whatever earlier tests left
+	liveContainers.mu.Unlock()
+
+	sbx := &Sandbox{cli: client, image: "image", limits: withDefaultLimits(Limits{})}
+	cid, err := sbx.startContainer(context.Background())
+	if err != nil {
+		t.Fatalf("start container: %v", err)
+	}
+	if client.cfg.Labels[ContainerLabel] != "1" {
+		t.Fatalf("expected the container to be labeled, got %v", client.cfg.Labels)
+	}
+
+	removed, err := RemoveContainers(context.Background())
+	if err != nil || removed != 1 || !reflect.DeepEqual(client.removedIDs, []string{cid}) {
+		t.Fatalf("expected the running container removed, got %d %v err=%v", removed, client.removedIDs, err)
+	}
+	if removed, _ := RemoveContainers(context.Background()); removed != 0 {
+		t.Fatalf("expected nothing left to remove, got %d", removed)
+	}
+}
@@ -1,10 +1,13 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"path"
 	"strings"
 	"time"
@@ -20,27 +23,42 @@ import (
 type Language string
 
 const (
-	LangPython Language = "python"
-	LangJava   Language = "java"
-	LangCPP    Language = "cpp"
+	LangPython     Language = "python"
+	LangJava       Language = "java"
+	LangCPP        Language = "cpp"
+	LangJavaScript Language = "javascript"
+	LangGo         Language = "go"
+	LangRust       Language = "rust"
 )
 
 type LanguageSpec struct {
-	FileName   string
-	RunCmd     []string
-	CompileCmd []string
-	ExecCmd    []string
+	DisplayName string
+	FileName    string
+	RunCmd      []string
+	CompileCmd  []string
+	ExecCmd     []string
 }
 
 type Limits struct {
-	WallTime time.Duration
-	MemoryB  int64
-	NanoCPUs int64
+	WallTime       time.Duration
+	MemoryB        int64
+	NanoCPUs       int64
+	PidsLimit      int64 // 0 leaves the process count unlimited
+	MaxOutputBytes int   // combined stdout and stderr kept; 0 takes DefaultMaxOutputBytes
+	// AllowNetwork attaches the container to the default bridge network
+	// instead of none; only for trusted callers
+	AllowNetwork bool
 }
 
 type ExitInfo struct {
 	Code     int  `json:"code"`
 	TimedOut bool `json:"timedOut"`
+	// Cancelled is set when the caller gave up on the run, e.g. through the
+	// cancel endpoint, and the container was killed
+	Cancelled bool `json:"cancelled,omitempty"`
+	// OOMKilled is set when the kernel killed the program for going over its
+	// memory limit, which otherwise only shows as exit code 137
+	OOMKilled bool `json:"oomKilled,omitempty"`
 }
 
 type Event struct {
@@ -48,14 +66,43 @@ type Event struct {
 	Data interface{} `json:"data"`
 }
 
+// Usage is what the program used while it ran. DurationMs covers the program
+// alone, not container setup or compilation; PeakMemoryBytes is the
+// container's high-water mark, so it includes the compiler for compiled
+// languages, and is left out when the daemon does not report it or the
+// container came from the pool having served earlier runs.
+type Usage struct {
+	DurationMs      int64 `json:"durationMs"`
+	PeakMemoryBytes int64 `json:"peakMemoryBytes,omitempty"`
+}
+
+// Truncation is the data of the truncated event, sent once output reached
+// MaxOutputBytes and the program was killed
+type Truncation struct {
+	LimitBytes int `json:"limitBytes"`
+}
+
 type Result struct {
-	Stdout string   `json:"stdout"`
-	Stderr string   `json:"stderr"`
-	Exit   ExitInfo `json:"exit"`
-	Events []Event  `json:"events"`
-	Error  string   `json:"error,omitempty"`
+	Stdout          string   `json:"stdout"`
+	Stderr          string   `json:"stderr"`
+	Exit            ExitInfo `json:"exit"`
+	Usage           *Usage   `json:"usage,omitempty"`
+	Events          []Event  `json:"events"`
+	Error           string   `json:"error,omitempty"`
+	OutputTruncated bool     `json:"outputTruncated,omitempty"`
+	// Stage is StageCompile when the program did not build; it then never
+	// ran, Exit is the compiler's and CompileOutput holds what it printed
+	Stage         string `json:"stage,omitempty"`
+	CompileOutput string `json:"compileOutput,omitempty"`
+	// Artifacts are the files of Invocation.CollectFiles, in its order
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// Cached is set on a result served again from the ResultCache
+	Cached bool `json:"cached,omitempty"`
 }
 
+// StageCompile is the Result stage of a program that failed to compile
+const StageCompile = "compile"
+
 type dockerClient interface {
 	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
 	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
@@ -63,16 +110,34 @@ type dockerClient interface {
 	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
 	ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error
 	ContainerKill(ctx context.Context, containerID string, signal string) error
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
 	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
 	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
 	ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error
 	ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error)
+	ContainerStatsOneShot(ctx context.Context, containerID string) (types.ContainerStats, error)
+	Ping(ctx context.Context) (types.Ping, error)
 }
 
 type Sandbox struct {
 	cli    dockerClient
 	image  string
 	limits Limits
+	env    []string // set on the last command of Run, the program itself
+	stdin  []byte   // fed to the last command of Run, which reads EOF after it
+	usage  *Usage   // set by Run once the program itself has run
+	pool   *Pool    // leases containers when set, see SetPool
+	cid    string   // container of the current Run
+	killed bool     // the current Run's container was killed, see kill
+	oom    bool     // the current Run's program was OOM-killed, see oomKilled
+
+	// set when a compile command of the current Run failed, see buildCommand
+	compileFailed bool
+	compileOutput string
+
+	collect   []string   // read back by Run once the program has run
+	artifacts []Artifact // of collect, see collectArtifacts
 }
 
 var newDockerClient = func() (dockerClient, error) {
@@ -81,11 +146,18 @@ var newDockerClient = func() (dockerClient, error) {
 
 var ErrDockerUnavailable = errors.New("docker daemon unreachable")
 
+// ErrSandboxDegraded fails runs while the watchdog holds the service degraded
+var ErrSandboxDegraded = errors.New("sandbox degraded")
+
 func NewSandbox(image string, limits Limits) (*Sandbox, error) {
 	cli, err := newDockerClient()
 	if err != nil {
 		return nil, translateDockerErr(err)
 	}
+	return &Sandbox{cli: cli, image: image, limits: withDefaultLimits(limits), pool: activePool}, nil
+}
+
+func withDefaultLimits(limits Limits) Limits {
 	if limits.WallTime <= 0 {
 		limits.WallTime = 10 * time.Second
 	}
@@ -95,56 +167,143 @@ func NewSandbox(image string, limits Limits) (*Sandbox, error) {
 	if limits.NanoCPUs == 0 {
 		limits.NanoCPUs = 1_000_000_000
 	}
-	return &Sandbox{cli: cli, image: image, limits: limits}, nil
+	if limits.MaxOutputBytes <= 0 {
+		limits.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+	return limits
+}
+
+// Execute compiles and runs code; the wall time, memory, CPUs and process
+// count limits leaves zero take lang's defaults.
+func Execute(ctx context.Context, lang Language, code string, limits Limits, inv Invocation) (Result, error) {
+	return ExecuteStream(ctx, lang, code, limits, inv, nil)
 }
 
-func Execute(ctx context.Context, lang Language, code string, limits Limits) (Result, error) {
+// ExecuteStream runs like Execute and hands every event of the result to
+// emit, if set, as it happens. exit is always the last event.
+func ExecuteStream(ctx context.Context, lang Language, code string, limits Limits, inv Invocation, emit func(Event)) (Result, error) {
+	started := time.Now()
 	_, image, fileName, cmds, err := langSpec(lang)
 	if err != nil {
+		observer.run(lang, RunUnsupportedLanguage, 0)
 		return Result{}, err
 	}
+	if err := inv.Validate(); err != nil {
+		return Result{}, err
+	}
+	if err := ValidateFiles(lang, inv.Files); err != nil {
+		return Result{}, err
+	}
+	files := submissionFiles(fileName, code, inv.Files)
+	expandBuild(cmds, files)
+	cmds[len(cmds)-1] = inv.command(cmds[len(cmds)-1])
+
+	cache := activeCache
+	var key cacheKey
+	if cache != nil {
+		key = resultKey(lang, code, withLanguageDefaults(lang, limits), inv)
+		if cached, ok := cache.get(key); ok {
+			for _, evt := range cached.Events {
+				if emit != nil {
+					emit(evt)
+				}
+			}
+			return cached, nil
+		}
+	}
 
-	sbx, err := NewSandbox(image, limits)
+	var result Result
+	record := func(evt Event) {
+		result.Events = append(result.Events, evt)
+		if emit != nil {
+			emit(evt)
+		}
+	}
+
+	sbx, err := NewSandbox(image, withLanguageDefaults(lang, limits))
 	if err != nil {
 		msg := mapSandboxError(err)
-		res := Result{Error: msg, Exit: ExitInfo{Code: -1, TimedOut: false}}
-		res.Events = append(res.Events, Event{Type: "error", Data: msg})
-		res.Events = append(res.Events, Event{Type: "exit", Data: res.Exit})
-		return res, nil
+		result.Error, result.Exit = msg, ExitInfo{Code: -1, TimedOut: false}
+		record(Event{Type: "error", Data: msg})
+		record(Event{Type: "exit", Data: result.Exit})
+		observer.run(lang, RunSandboxError, time.Since(started))
+		return result, nil
+	}
+
+	sbx.env = inv.environ()
+	sbx.collect = inv.CollectFiles
+	if inv.Stdin != "" {
+		sbx.stdin = []byte(inv.Stdin)
 	}
 
 	runCtx, cancel := context.WithTimeout(ctx, sbx.limits.WallTime)
 	defer cancel()
 
 	var stdoutBuf, stderrBuf strings.Builder
-	result := Result{Events: make([]Event, 0, len(cmds)*2+1)}
+	result.Events = make([]Event, 0, len(cmds)*2+1)
+
+	// Output past MaxOutputBytes is dropped and the program killed, so a
+	// runaway print loop neither fills memory nor burns the wall time
+	written := 0
+	capture := func(buf *strings.Builder, typ string) func([]byte) {
+		return func(p []byte) {
+			if result.OutputTruncated {
+				return
+			}
+			if max := sbx.limits.MaxOutputBytes; written+len(p) > max {
+				p = p[:max-written]
+				result.OutputTruncated = true
+			}
+			if len(p) > 0 {
+				written += len(p)
+				chunk := string(p)
+				buf.WriteString(chunk)
+				record(Event{Type: typ, Data: chunk})
+			}
+			if result.OutputTruncated {
+				record(Event{Type: "truncated", Data: Truncation{LimitBytes: sbx.limits.MaxOutputBytes}})
+				sbx.kill()
+			}
+		}
+	}
 
-	exit, timedOut, runErr := sbx.Run(
+	exit, timedOut, runErr := sbx.RunFiles(
 		runCtx,
-		fileName,
-		[]byte(code),
+		files,
 		cmds,
-		func(p []byte) {
-			chunk := string(p)
-			stdoutBuf.WriteString(chunk)
-			result.Events = append(result.Events, Event{Type: "stdout", Data: chunk})
-		},
-		func(p []byte) {
-			chunk := string(p)
-			stderrBuf.WriteString(chunk)
-			result.Events = append(result.Events, Event{Type: "stderr", Data: chunk})
-		},
+		capture(&stdoutBuf, "stdout"),
+		capture(&stderrBuf, "stderr"),
 	)
 
 	result.Stdout = stdoutBuf.String()
 	result.Stderr = stderrBuf.String()
-	result.Exit = ExitInfo{Code: exit, TimedOut: timedOut}
-	result.Events = append(result.Events, Event{Type: "exit", Data: result.Exit})
-
+	result.Exit = ExitInfo{Code: exit, TimedOut: timedOut, OOMKilled: sbx.oom}
+	result.Artifacts = sbx.artifacts
+	if sbx.compileFailed {
+		result.Stage, result.CompileOutput = StageCompile, sbx.compileOutput
+		record(Event{Type: "compile_error", Data: sbx.compileOutput})
+	}
+	if sbx.usage != nil {
+		result.Usage = sbx.usage
+		record(Event{Type: "usage", Data: *sbx.usage})
+	}
+	if runErr != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// Not a sandbox failure: Run has killed the container on its way out
+		result.Exit = ExitInfo{Code: -1, Cancelled: true}
+		runErr = nil
+	}
 	if runErr != nil {
 		msg := mapSandboxError(runErr)
 		result.Error = msg
-		result.Events = append(result.Events, Event{Type: "error", Data: msg})
+		record(Event{Type: "error", Data: msg})
+	} else if result.Exit.OOMKilled {
+		result.Error = "memory_limit_exceeded"
+		record(Event{Type: "error", Data: result.Error})
+	}
+	record(Event{Type: "exit", Data: result.Exit})
+	observer.run(lang, runOutcome(ctx, runCtx, result, runErr), time.Since(started))
+	if cache != nil {
+		cache.put(key, result)
 	}
 
 	return result, nil
@@ -152,77 +311,280 @@ func Execute(ctx context.Context, lang Language, code string, limits Limits) (Re
 
 func (s *Sandbox) Run(ctx context.Context, fileName string, code []byte, cmds [][]string,
 	onStdout func([]byte), onStderr func([]byte)) (exit int, timedOut bool, err error) {
+	return s.RunFiles(ctx, []SourceFile{{Path: fileName, Content: string(code)}}, cmds, onStdout, onStderr)
+}
+
+// RunFiles is Run for a submission of several files, written to the
+// workspace at their relative paths before the first command.
+func (s *Sandbox) RunFiles(ctx context.Context, files []SourceFile, cmds [][]string,
+	onStdout func([]byte), onStderr func([]byte)) (exit int, timedOut bool, err error) {
+
+	cid, fresh, release, err := s.leaseContainer(ctx)
+	if err != nil {
+		return -1, false, err
+	}
+	s.cid, s.killed, s.oom = cid, false, false
+	s.compileFailed, s.compileOutput = false, ""
+	s.artifacts = nil
+	defer func() { release(err == nil && !s.killed && !s.oom) }()
+
+	for _, f := range files {
+		if err := s.copyFile(ctx, cid, workspaceDir+"/"+f.Path, []byte(f.Content), 0600); err != nil {
+			_ = s.cli.ContainerKill(context.Background(), cid, "SIGKILL")
+			return -1, false, translateDockerErr(err)
+		}
+	}
+
+	for i, cmd := range cmds {
+		last := i == len(cmds)-1
+		var env []string
+		var stdin []byte
+		if last {
+			env, stdin = s.env, s.stdin
+		}
+		started := time.Now()
+		var code int
+		if last {
+			code, err = s.execCommand(ctx, cid, cmd, env, stdin, onStdout, onStderr)
+		} else {
+			code, err = s.buildCommand(ctx, cid, cmd, onStdout, onStderr)
+		}
+		if err != nil {
+			_ = s.cli.ContainerKill(context.Background(), cid, "SIGKILL")
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// Out of wall time. The output read until then, including
+				// what was still buffered when the exec was closed, has
+				// been passed on already.
+				s.killed = true
+				return -1, true, nil
+			}
+			return -1, false, err
+		}
+		if last {
+			s.usage = &Usage{DurationMs: time.Since(started).Milliseconds()}
+			if fresh {
+				s.usage.PeakMemoryBytes = s.peakMemory(cid)
+			}
+			s.collectArtifacts(ctx, cid)
+		}
+
+		if code == timeoutExitCode {
+			s.oom = s.oomKilled(cid)
+		}
+		if code != 0 {
+			return code, false, nil
+		}
+		if i == len(cmds)-1 {
+			return 0, false, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// execCommand runs cmd in the container to completion, streaming its output
+// and feeding it stdin when there is any, and returns its exit code. The
+// output stops when ctx ends, once what was already received has been
+// passed on, so a program outliving it cannot hold the caller; execCommand
+// then fails with the context's error.
+func (s *Sandbox) execCommand(ctx context.Context, cid string, cmd, env []string, stdin []byte,
+	onStdout, onStderr func([]byte)) (int, error) {
 
+	withStdin := len(stdin) > 0
+	execID, attach, err := s.execAttach(ctx, cid, cmd, env, withStdin)
+	if err != nil {
+		return -1, translateDockerErr(err)
+	}
+	stopWatching := context.AfterFunc(ctx, attach.Close)
+	defer stopWatching()
+
+	// stdin is written alongside reading the output so a program that
+	// prints before it has read all of its input cannot stall the run
+	stdinDone := make(chan struct{})
+	if withStdin {
+		go func() {
+			defer close(stdinDone)
+			writeStdin(attach.Conn, stdin)
+		}()
+	} else {
+		close(stdinDone)
+	}
+	_, _ = stdcopy.StdCopy(writerFunc(onStdout), writerFunc(onStderr), attach.Reader)
+	attach.Close()
+	<-stdinDone
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	ir, err := s.cli.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return -1, translateDockerErr(err)
+	}
+	return ir.ExitCode, nil
+}
+
+// buildCommand runs a compile command of Run with its output held back until
+// it is over. A failed build keeps the output, capped at MaxOutputBytes, as
+// the Run's compile output instead of passing it on; a successful one passes
+// it on, e.g. warnings.
+func (s *Sandbox) buildCommand(ctx context.Context, cid string, cmd []string,
+	onStdout func([]byte), onStderr func([]byte)) (int, error) {
+
+	var stdout, stderr, combined bytes.Buffer
+	keep := func(bufs ...*bytes.Buffer) func([]byte) {
+		return func(p []byte) {
+			for _, buf := range bufs {
+				if room := s.limits.MaxOutputBytes - buf.Len(); room > 0 {
+					buf.Write(p[:min(len(p), room)])
+				}
+			}
+		}
+	}
+	code, err := s.execCommand(ctx, cid, cmd, nil, nil, keep(&stdout, &combined), keep(&stderr, &combined))
+	if err != nil {
+		return code, err
+	}
+	if code != 0 {
+		s.compileFailed, s.compileOutput = true, combined.String()
+		return code, nil
+	}
+	if stdout.Len() > 0 && onStdout != nil {
+		onStdout(stdout.Bytes())
+	}
+	if stderr.Len() > 0 && onStderr != nil {
+		onStderr(stderr.Bytes())
+	}
+	return code, nil
+}
+
+// peakMemory returns the container's memory high-water mark, or 0 when the
+// daemon cannot tell. cgroup v2 hosts report no maximum, so the usage after
+// the run stands in for it there.
+func (s *Sandbox) peakMemory(cid string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stats, err := s.cli.ContainerStatsOneShot(ctx, cid)
+	if err != nil {
+		return 0
+	}
+	defer stats.Body.Close()
+	var snapshot types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&snapshot); err != nil {
+		return 0
+	}
+	if peak := snapshot.MemoryStats.MaxUsage; peak > 0 {
+		return int64(peak)
+	}
+	return int64(snapshot.MemoryStats.Usage)
+}
+
+// oomKilled reports whether the kernel OOM-killed a process of the container,
+// which then exits with 137 just like one killed by timeout. A container that
+// has been OOM-killed is not reused.
+func (s *Sandbox) oomKilled(cid string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	info, err := s.cli.ContainerInspect(ctx, cid)
+	if err != nil || info.ContainerJSONBase == nil || info.State == nil {
+		return false
+	}
+	return info.State.OOMKilled
+}
+
+// startContainer creates and starts an idle, network-less container for the
+// sandbox image. Callers own the container and must removeContainer it.
+func (s *Sandbox) startContainer(ctx context.Context) (string, error) {
 	if err := s.ensureImage(ctx); err != nil {
-		return -1, false, translateDockerErr(err)
+		return "", translateDockerErr(err)
 	}
 
+	// The image is read-only and the program runs without capabilities or
+	// network; it may only write to the tmpfs mounts, which count towards its
+	// memory limit
 	hostCfg := &container.HostConfig{
 		NetworkMode:    "none",
-		ReadonlyRootfs: false,
+		ReadonlyRootfs: true,
+		Tmpfs:          sandboxTmpfs,
+		CapDrop:        []string{"ALL"},
 		Resources: container.Resources{
 			Memory:   s.limits.MemoryB,
 			NanoCPUs: s.limits.NanoCPUs,
 		},
 		SecurityOpt: []string{"no-new-privileges"},
 	}
+	if s.limits.AllowNetwork {
+		hostCfg.NetworkMode = "bridge"
+	}
+	if s.limits.PidsLimit > 0 {
+		pids := s.limits.PidsLimit
+		hostCfg.Resources.PidsLimit = &pids
+	}
 
+	// sleep is PID 1 so that wiping a pooled container, which kills every
+	// other process, leaves it running
 	conf := &container.Config{
 		Image:        s.image,
-		Cmd:          []string{"/bin/sh", "-c", "sleep infinity"},
+		Cmd:          []string{"sleep", "infinity"},
 		Tty:          false,
 		AttachStdout: false,
 		AttachStderr: false,
 		WorkingDir:   "/workspace",
-		Env:          []string{"PYTHONDONTWRITEBYTECODE=1"},
+		// the Go toolchain caches builds under HOME, which is read-only
+		Env:    []string{"PYTHONDONTWRITEBYTECODE=1", "GOCACHE=/tmp/go-build", "GOPATH=/tmp/go"},
+		Labels: map[string]string{ContainerLabel: "1"},
 	}
 
 	create, err := s.cli.ContainerCreate(ctx, conf, hostCfg, nil, nil, "")
 	if err != nil {
-		return -1, false, translateDockerErr(err)
+		return "", translateDockerErr(err)
 	}
-	cid := create.ID
-	defer func() {
-		_ = s.cli.ContainerRemove(context.Background(), cid, types.ContainerRemoveOptions{Force: true})
-	}()
-
-	if err := s.cli.ContainerStart(ctx, cid, types.ContainerStartOptions{}); err != nil {
-		return -1, false, translateDockerErr(err)
-	}
-
-	if err := s.copyFile(ctx, cid, "/workspace/"+fileName, code, 0600); err != nil {
-		_ = s.cli.ContainerKill(context.Background(), cid, "SIGKILL")
-		return -1, false, translateDockerErr(err)
+	trackContainer(create.ID)
+	if err := s.cli.ContainerStart(ctx, create.ID, types.ContainerStartOptions{}); err != nil {
+		s.removeContainer(create.ID)
+		return "", translateDockerErr(err)
 	}
+	return create.ID, nil
+}
 
-	for i, cmd := range cmds {
-		execID, attachCloser, err := s.execStart(ctx, cid, cmd)
-		if err != nil {
-			_ = s.cli.ContainerKill(context.Background(), cid, "SIGKILL")
-			return -1, false, translateDockerErr(err)
-		}
+// sandboxTmpfs are the writable mounts of a container. Programs are built in
+// and run from /workspace, so neither mount is noexec.
+var sandboxTmpfs = map[string]string{
+	"/workspace": "rw,exec,nosuid,nodev,size=64m",
+	"/tmp":       "rw,exec,nosuid,nodev,size=256m",
+}
 
-		_, _ = stdcopy.StdCopy(
-			writerFunc(onStdout),
-			writerFunc(onStderr),
-			attachCloser.Reader,
-		)
-		attachCloser.Close()
+// kill stops the program of the current Run by killing its container, which
+// is then not reused
+func (s *Sandbox) kill() {
+	s.killed = true
+	_ = s.cli.ContainerKill(context.Background(), s.cid, "SIGKILL")
+}
 
-		ir, ierr := s.cli.ContainerExecInspect(ctx, execID)
-		if ierr != nil {
-			_ = s.cli.ContainerKill(context.Background(), cid, "SIGKILL")
-			return -1, false, translateDockerErr(ierr)
-		}
+// leaseContainer provides the container for a run: a warm one from the pool
+// when there is a pool, otherwise a fresh one. release hands it back, healthy
+// unless the run failed in the sandbox. fresh reports whether the container
+// has not served a run before.
+func (s *Sandbox) leaseContainer(ctx context.Context) (cid string, fresh bool, release func(healthy bool), err error) {
+	var done func(healthy bool)
+	if s.pool != nil {
+		cid, fresh, done, err = s.pool.lease(ctx, s)
+	} else if cid, err = s.startContainer(ctx); err == nil {
+		fresh, done = true, func(bool) { s.removeContainer(cid) }
+	}
+	if err != nil {
+		return "", false, nil, err
+	}
+	observer.containers(1)
+	return cid, fresh, func(healthy bool) {
+		observer.containers(-1)
+		done(healthy)
+	}, nil
+}
 
-		if ir.ExitCode != 0 {
-			return ir.ExitCode, false, nil
-		}
-		if i == len(cmds)-1 {
-			return 0, false, nil
-		}
+func (s *Sandbox) removeContainer(cid string) {
+	// One that could not be removed stays tracked for RemoveContainers
+	if err := s.cli.ContainerRemove(context.Background(), cid, types.ContainerRemoveOptions{Force: true}); err == nil {
+		untrackContainer(cid)
 	}
-	return 0, false, nil
 }
 
 func (s *Sandbox) ensureImage(ctx context.Context) error {
@@ -234,6 +596,7 @@ func (s *Sandbox) ensureImage(ctx context.Context) error {
 		pullCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 		defer cancel()
 		reader, pullErr := s.cli.ImagePull(pullCtx, s.image, types.ImagePullOptions{})
+		observer.imagePull(pullErr == nil)
 		if pullErr != nil {
 			return translateDockerErr(pullErr)
 		}
@@ -244,9 +607,10 @@ func (s *Sandbox) ensureImage(ctx context.Context) error {
 	return translateDockerErr(err)
 }
 
-func (s *Sandbox) execStart(ctx context.Context, containerID string, cmd []string) (execID string, attach types.HijackedResponse, err error) {
+func (s *Sandbox) execStart(ctx context.Context, containerID string, cmd []string, env []string) (execID string, attach types.HijackedResponse, err error) {
 	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
 		Cmd:          cmd,
+		Env:          env,
 		WorkingDir:   "/workspace",
 		AttachStdout: true,
 		AttachStderr: true,
@@ -266,6 +630,45 @@ func (s *Sandbox) execStart(ctx context.Context, containerID string, cmd []strin
 	return execResp.ID, attach, nil
 }
 
+// execAttach starts cmd like execStart, additionally attaching stdin when
+// withStdin is set. The hijacked connection stays open for the caller.
+func (s *Sandbox) execAttach(ctx context.Context, containerID string, cmd []string, env []string, withStdin bool) (string, types.HijackedResponse, error) {
+	if !withStdin {
+		return s.execStart(ctx, containerID, cmd, env)
+	}
+	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cmd,
+		Env:          env,
+		WorkingDir:   "/workspace",
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+	})
+	if err != nil {
+		return "", types.HijackedResponse{}, translateDockerErr(err)
+	}
+	attach, err := s.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: false})
+	if err != nil {
+		return "", types.HijackedResponse{}, translateDockerErr(err)
+	}
+	if err := s.cli.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{Tty: false}); err != nil {
+		attach.Close()
+		return "", types.HijackedResponse{}, translateDockerErr(err)
+	}
+	return execResp.ID, attach, nil
+}
+
+// writeStdin sends payload to an attached exec and closes its input. A
+// program that exits without reading everything makes the write fail, which
+// is not an error of the run.
+func writeStdin(conn net.Conn, payload []byte) {
+	_, _ = conn.Write(payload)
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = closer.CloseWrite()
+	}
+}
+
 func (s *Sandbox) copyFile(ctx context.Context, cid, absPath string, content []byte, mode int64) error {
 	if absPath == "" || !strings.HasPrefix(absPath, "/") {
 		return fmt.Errorf("invalid path %q", absPath)
@@ -289,7 +692,7 @@ func shellQuote(s string) string {
 }
 
 func (s *Sandbox) runCommand(ctx context.Context, cid, cmd string) error {
-	execID, attach, err := s.execStart(ctx, cid, []string{"/bin/sh", "-c", cmd})
+	execID, attach, err := s.execStart(ctx, cid, []string{"/bin/sh", "-c", cmd}, nil)
 	if err != nil {
 		return err
 	}
@@ -361,38 +764,82 @@ func translateDockerErr(err error) error {
 	return err
 }
 
+// SpecFor returns the spec of lang, failing for an unsupported language
+func SpecFor(lang Language) (LanguageSpec, error) {
+	spec, _, _, _, err := langSpec(lang)
+	return spec, err
+}
+
 func langSpec(lang Language) (LanguageSpec, string, string, [][]string, error) {
 	switch lang {
 	case LangPython:
 		return LanguageSpec{
-				FileName: "main.py",
-				RunCmd:   []string{"python3", "main.py"},
+				DisplayName: "Python",
+				FileName:    "main.py",
+				RunCmd:      []string{"python3", "main.py"},
 			},
-			"python:3.11-slim",
+			ImageFor(lang),
 			"main.py",
 			[][]string{{"python3", "main.py"}},
 			nil
 
 	case LangJava:
 		return LanguageSpec{
-				FileName:   "Main.java",
-				CompileCmd: []string{"javac", "Main.java"},
-				ExecCmd:    []string{"/bin/sh", "-c", "java Main"},
+				DisplayName: "Java",
+				FileName:    "Main.java",
+				CompileCmd:  []string{"javac", "*.java"},
+				ExecCmd:     []string{"java", "Main"},
 			},
-			"eclipse-temurin:17-jdk",
+			ImageFor(lang),
 			"Main.java",
-			[][]string{{"javac", "Main.java"}, {"/bin/sh", "-c", "java Main"}},
+			[][]string{{"javac", "*.java"}, {"java", "Main"}},
 			nil
 
 	case LangCPP:
 		return LanguageSpec{
-				FileName:   "main.cpp",
-				CompileCmd: []string{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"},
-				ExecCmd:    []string{"./main"},
+				DisplayName: "C++",
+				FileName:    "main.cpp",
+				CompileCmd:  []string{"g++", "-O2", "-std=c++17", "*.cpp", "-o", "main"},
+				ExecCmd:     []string{"./main"},
 			},
-			"gcc:13",
+			ImageFor(lang),
 			"main.cpp",
-			[][]string{{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"}, {"./main"}},
+			[][]string{{"g++", "-O2", "-std=c++17", "*.cpp", "-o", "main"}, {"./main"}},
+			nil
+
+	case LangJavaScript:
+		return LanguageSpec{
+				DisplayName: "JavaScript",
+				FileName:    "main.js",
+				RunCmd:      []string{"node", "main.js"},
+			},
+			ImageFor(lang),
+			"main.js",
+			[][]string{{"node", "main.js"}},
+			nil
+
+	case LangGo:
+		return LanguageSpec{
+				DisplayName: "Go",
+				FileName:    "main.go",
+				CompileCmd:  []string{"go", "build", "-o", "app", "*.go"},
+				ExecCmd:     []string{"./app"},
+			},
+			ImageFor(lang),
+			"main.go",
+			[][]string{{"go", "build", "-o", "app", "*.go"}, {"./app"}},
+			nil
+
+	case LangRust:
+		return LanguageSpec{
+				DisplayName: "Rust",
+				FileName:    "main.rs",
+				CompileCmd:  []string{"rustc", "-O", "main.rs", "-o", "app"},
+				ExecCmd:     []string{"./app"},
+			},
+			ImageFor(lang),
+			"main.rs",
+			[][]string{{"rustc", "-O", "main.rs", "-o", "app"}, {"./app"}},
 			nil
 	default:
 		return LanguageSpec{}, "", "", nil, errors.New("unsupported_language")
@@ -403,12 +850,15 @@ func mapSandboxError(err error) string {
 	if err == nil {
 		return ""
 	}
-	if errors.Is(err, ErrDockerUnavailable) {
+	if errors.Is(err, ErrDockerUnavailable) || errors.Is(err, ErrSandboxDegraded) {
 		return "sandbox_unavailable"
 	}
 	if err.Error() == "unsupported_language" {
 		return "unsupported_language"
 	}
+	if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout) {
+		return err.Error()
+	}
 	return "sandbox_error"
 }
 
@@ -417,7 +867,7 @@ func WarmImages(ctx context.Context, langs ...Language) error {
 		ctx = context.Background()
 	}
 	if len(langs) == 0 {
-		langs = []Language{LangPython, LangJava, LangCPP}
+		langs = Languages()
 	}
 	for _, lang := range langs {
 		if err := warmImage(ctx, lang); err != nil {
@@ -427,6 +877,44 @@ func WarmImages(ctx context.Context, langs ...Language) error {
 	return nil
 }
 
+// MissingImagesError fails CheckReady while images of supported languages
+// have not been pulled
+type MissingImagesError struct {
+	Languages []Language
+}
+
+func (e *MissingImagesError) Error() string { return "images_missing" }
+
+// CheckReady reports whether runs can start: the daemon answers a ping and
+// the image of every supported language is present, else a
+// *MissingImagesError. Failing to reach the daemon is ErrDockerUnavailable.
+func CheckReady(ctx context.Context) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return translateDockerErr(err)
+	}
+	if closer, ok := cli.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	if _, err := cli.Ping(ctx); err != nil {
+		return translateDockerErr(err)
+	}
+	var missing []Language
+	for _, lang := range Languages() {
+		_, image, _, _, _ := langSpec(lang)
+		if _, _, err := cli.ImageInspectWithRaw(ctx, image); err != nil {
+			if !client.IsErrNotFound(err) {
+				return translateDockerErr(err)
+			}
+			missing = append(missing, lang)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingImagesError{Languages: missing}
+	}
+	return nil
+}
+
 func warmImage(ctx context.Context, lang Language) error {
 	_, image, _, _, err := langSpec(lang)
 	if err != nil {
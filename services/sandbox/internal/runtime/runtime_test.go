@@ -5,18 +5,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
@@ -56,6 +60,39 @@ func TestLangSpec(t *testing.T) {
 		t.Fatalf("expected cpp to have compile+exec commands: %v", cmds)
 	}
 
+	spec, image, fileName, cmds, err = langSpec(LangJavaScript)
+	if err != nil {
+		t.Fatalf("expected no error: %v", err)
+	}
+	if spec.FileName != "main.js" || image != "node:20-slim" || fileName != "main.js" {
+		t.Fatalf("unexpected javascript spec: %+v image=%s file=%s", spec, image, fileName)
+	}
+	if len(cmds) != 1 || !reflect.DeepEqual(cmds[0], []string{"node", "main.js"}) {
+		t.Fatalf("unexpected javascript commands: %v", cmds)
+	}
+
+	spec, image, fileName, cmds, err = langSpec(LangGo)
+	if err != nil {
+		t.Fatalf("expected no error: %v", err)
+	}
+	if spec.FileName != "main.go" || image != "golang:1.22-alpine" || fileName != "main.go" {
+		t.Fatalf("unexpected go spec: %+v image=%s file=%s", spec, image, fileName)
+	}
+	if len(cmds) != 2 || !reflect.DeepEqual(cmds[0], []string{"go", "build", "-o", "app", "*.go"}) || !reflect.DeepEqual(cmds[1], []string{"./app"}) {
+		t.Fatalf("expected go to have compile+exec commands: %v", cmds)
+	}
+
+	spec, image, fileName, cmds, err = langSpec(LangRust)
+	if err != nil {
+		t.Fatalf("expected no error: %v", err)
+	}
+	if spec.FileName != "main.rs" || spec.DisplayName != "Rust" || image != "rust:1.78-slim" || fileName != "main.rs" {
+		t.Fatalf("unexpected rust spec: %+v image=%s file=%s", spec, image, fileName)
+	}
+	if len(cmds) != 2 || !reflect.DeepEqual(cmds[0], []string{"rustc", "-O", "main.rs", "-o", "app"}) || !reflect.DeepEqual(cmds[1], []string{"./app"}) {
+		t.Fatalf("expected rust to have compile+exec commands: %v", cmds)
+	}
+
 	_, _, _, _, err = langSpec(Language("unknown"))
 	if err == nil || err.Error() != "unsupported_language" {
 		t.Fatalf("expected unsupported_language error, got %v", err)
@@ -271,7 +308,7 @@ func TestExecStartCreateError(t *testing.T) {
 	}
 	sbx := &Sandbox{cli: client, image: "image", limits: Limits{}}
 	call := client.execQueue[0]
-	_, _, err := sbx.execStart(context.Background(), "cid", []string{"noop"})
+	_, _, err := sbx.execStart(context.Background(), "cid", []string{"noop"}, nil)
 	if !errors.Is(err, call.createErr) {
 		t.Fatalf("expected create error, got %v", err)
 	}
@@ -290,7 +327,7 @@ func TestExecStartAttachError(t *testing.T) {
 	}
 	sbx := &Sandbox{cli: client, image: "image", limits: Limits{}}
 	call := client.execQueue[0]
-	_, _, err := sbx.execStart(context.Background(), "cid", []string{"noop"})
+	_, _, err := sbx.execStart(context.Background(), "cid", []string{"noop"}, nil)
 	if !errors.Is(err, call.attachErr) {
 		t.Fatalf("expected attach error, got %v", err)
 	}
@@ -309,7 +346,7 @@ func TestExecStartStartErrorClosesAttach(t *testing.T) {
 	}
 	sbx := &Sandbox{cli: client, image: "image", limits: Limits{}}
 	call := client.execQueue[0]
-	_, attach, err := sbx.execStart(context.Background(), "cid", []string{"noop"})
+	_, attach, err := sbx.execStart(context.Background(), "cid", []string{"noop"}, nil)
 	if !errors.Is(err, call.startErr) {
 		t.Fatalf("expected start error, got %v", err)
 	}
@@ -703,11 +740,652 @@ func TestSandboxRunNoCommands(t *testing.T) {
 }
 
 func TestExecuteUnsupportedLanguage(t *testing.T) {
-	if _, err := Execute(context.Background(), Language("nope"), "code", Limits{}); err == nil {
+	if _, err := Execute(context.Background(), Language("nope"), "code", Limits{}, Invocation{}); err == nil {
 		t.Fatalf("expected error for unsupported language")
 	}
 }
 
+func TestExecuteAppliesInvocationToExecCommand(t *testing.T) {
+	compile := &fakeExecCall{
+		expectCmd: []string{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+	}
+	program := &fakeExecCall{
+		expectCmd: []string{"./main", "--mode=fast", "input.txt"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.cpp'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.cpp'"}},
+			compile,
+			program,
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	inv := Invocation{Args: []string{"--mode=fast", "input.txt"}, Env: map[string]string{"DEBUG": "1", "A_B": "x"}}
+	res, err := Execute(context.Background(), LangCPP, "int main(){}", Limits{}, inv)
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if len(compile.gotEnv) != 0 {
+		t.Fatalf("compile step must not get the run environment, got %v", compile.gotEnv)
+	}
+	if !reflect.DeepEqual(program.gotEnv, []string{"A_B=x", "DEBUG=1"}) {
+		t.Fatalf("unexpected program env %v", program.gotEnv)
+	}
+}
+
+func TestExecuteRustCompilesThenRuns(t *testing.T) {
+	compile := &fakeExecCall{
+		expectCmd: []string{"rustc", "-O", "main.rs", "-o", "app"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+	}
+	program := &fakeExecCall{
+		expectCmd: []string{"./app", "--mode=fast"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+		stdout:    "Hello from Rust!\n",
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.rs'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.rs'"}},
+			compile,
+			program,
+		},
+	}
+	useFakeDocker(t, client)
+
+	inv := Invocation{Args: []string{"--mode=fast"}, Env: map[string]string{"DEBUG": "1"}}
+	res, err := Execute(context.Background(), LangRust, "fn main() {}", Limits{}, inv)
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Stdout != "Hello from Rust!\n" || res.Exit.Code != 0 || res.Stage != "" {
+		t.Fatalf("unexpected output %q exit %+v stage %q", res.Stdout, res.Exit, res.Stage)
+	}
+	if len(compile.gotEnv) != 0 || !reflect.DeepEqual(program.gotEnv, []string{"DEBUG=1"}) {
+		t.Fatalf("expected only the program to get the environment, got compile=%v program=%v", compile.gotEnv, program.gotEnv)
+	}
+	if client.cfg.Image != "rust:1.78-slim" {
+		t.Fatalf("expected the rust image, got %q", client.cfg.Image)
+	}
+}
+
+func TestExecuteRustStopsOnCompileError(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{
+				expectCmd: []string{"rustc", "-O", "main.rs", "-o", "app"},
+				inspect:   types.ContainerExecInspect{ExitCode: 1},
+				stderr:    "error: expected `;`, found `}`\n",
+			},
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, err := Execute(context.Background(), LangRust, "fn main() { let x = 1 }", Limits{}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Stage != StageCompile || res.CompileOutput != "error: expected `;`, found `}`\n" || res.Exit.Code != 1 {
+		t.Fatalf("expected the compile failure to be reported, got %+v", res)
+	}
+	if len(client.execQueue) != 0 || len(client.executed) != 4 {
+		t.Fatalf("expected ./app not to run after a failed build, executed %d", len(client.executed))
+	}
+}
+
+func TestExecuteSuccessJavaScript(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.js'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.js'"}},
+			{
+				expectCmd: []string{"node", "main.js"},
+				inspect:   types.ContainerExecInspect{ExitCode: 0},
+				stdout:    "1\n",
+			},
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	res, err := Execute(context.Background(), LangJavaScript, "console.log(1)", Limits{}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Stdout != "1\n" || res.Exit.Code != 0 {
+		t.Fatalf("unexpected output %q exit %+v", res.Stdout, res.Exit)
+	}
+	if got := client.executed[1].stdin.String(); got != "console.log(1)" {
+		t.Fatalf("expected the script to be written to main.js, got %q", got)
+	}
+}
+
+func TestExecuteReportsUsage(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.py'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.py'"}},
+			{
+				expectCmd: []string{"python3", "main.py"},
+				inspect:   types.ContainerExecInspect{ExitCode: 3},
+				stdout:    "hi\n",
+			},
+		},
+	}
+	client.stats.MemoryStats.MaxUsage = 14 << 20
+	client.stats.MemoryStats.Usage = 9 << 20
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	res, err := Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
+	if err != nil || res.Exit.Code != 3 {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Usage == nil || res.Usage.PeakMemoryBytes != 14<<20 || res.Usage.DurationMs < 0 {
+		t.Fatalf("expected the high-water mark to be reported, got %+v", res.Usage)
+	}
+	n := len(res.Events)
+	if n < 2 || res.Events[n-2].Type != "usage" || res.Events[n-1].Type != "exit" {
+		t.Fatalf("expected a usage event right before exit, got %+v", res.Events)
+	}
+	if usage, ok := res.Events[n-2].Data.(Usage); !ok || usage != *res.Usage {
+		t.Fatalf("expected the usage event to carry the result's usage, got %+v", res.Events[n-2].Data)
+	}
+
+	// Without a maximum, as on cgroup v2, the usage after the run stands in
+	client.stats.MemoryStats.MaxUsage = 0
+	client.execQueue = []*fakeExecCall{{}, {}, {}, {}}
+	res, _ = Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
+	if res.Usage == nil || res.Usage.PeakMemoryBytes != 9<<20 {
+		t.Fatalf("expected the current usage as fallback, got %+v", res.Usage)
+	}
+
+	// Stats failing leaves the memory out but still reports the duration
+	client.statsErr = errors.New("stats unavailable")
+	client.execQueue = []*fakeExecCall{{}, {}, {}, {}}
+	res, _ = Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
+	if res.Usage == nil || res.Usage.PeakMemoryBytes != 0 {
+		t.Fatalf("expected usage without memory, got %+v", res.Usage)
+	}
+}
+
+func TestStartContainerIsolatesProgram(t *testing.T) {
+	// The run stops at container creation; the host config is all we need
+	client := &fakeDockerClient{t: t, createErr: errors.New("stop")}
+	useFakeDocker(t, client)
+	if _, err := Execute(context.Background(), LangCPP, "", Limits{}, Invocation{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := client.hostCfg
+	if cfg == nil {
+		t.Fatalf("expected a container to be created")
+	}
+	if cfg.NetworkMode != "none" || !reflect.DeepEqual(cfg.CapDrop, strslice.StrSlice{"ALL"}) ||
+		!reflect.DeepEqual(cfg.SecurityOpt, []string{"no-new-privileges"}) {
+		t.Fatalf("expected no network, capabilities or privilege escalation, got %+v", cfg)
+	}
+	if !cfg.ReadonlyRootfs || !strings.Contains(cfg.Tmpfs["/workspace"], "exec") || cfg.Tmpfs["/tmp"] == "" {
+		t.Fatalf("expected a read-only root with writable tmpfs mounts, got %v %v", cfg.ReadonlyRootfs, cfg.Tmpfs)
+	}
+	if cfg.PidsLimit == nil || *cfg.PidsLimit != ProfileFor(LangCPP).Defaults().PidsLimit {
+		t.Fatalf("expected the language's process limit, got %v", cfg.PidsLimit)
+	}
+
+	if _, err := Execute(context.Background(), LangCPP, "", Limits{AllowNetwork: true}, Invocation{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.hostCfg.NetworkMode != "bridge" || !reflect.DeepEqual(client.hostCfg.CapDrop, strslice.StrSlice{"ALL"}) {
+		t.Fatalf("expected only the network to be opened, got %+v", client.hostCfg)
+	}
+}
+
+func TestExecuteCancelledMidRun(t *testing.T) {
+	program := &fakeExecCall{echo: true, attached: make(chan struct{})}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue:  []*fakeExecCall{{}, {}, {}, program},
+	}
+	useFakeDocker(t, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-program.attached
+		cancel()
+	}()
+	res, err := Execute(ctx, LangPython, "input()", Limits{}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result error %q err=%v", res.Error, err)
+	}
+	if res.Exit != (ExitInfo{Code: -1, Cancelled: true}) {
+		t.Fatalf("expected a cancelled exit, got %+v", res.Exit)
+	}
+	if len(client.killCalls) != 1 {
+		t.Fatalf("expected the container to be killed, got %v", client.killCalls)
+	}
+	if last := res.Events[len(res.Events)-1]; last.Type != "exit" || last.Data != res.Exit {
+		t.Fatalf("expected the cancelled exit last, got %+v", last)
+	}
+}
+
+func TestExecuteKeepsOutputOfTimedOutRun(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue:  []*fakeExecCall{{}, {}, {}, {chunks: []string{"first\n", "second\n"}}},
+	}
+	useFakeDocker(t, client)
+
+	res, err := Execute(context.Background(), LangPython, "while True: pass", Limits{WallTime: 100 * time.Millisecond}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result error %q err=%v", res.Error, err)
+	}
+	if res.Exit != (ExitInfo{Code: -1, TimedOut: true}) {
+		t.Fatalf("expected a timed out exit, got %+v", res.Exit)
+	}
+	if res.Stdout != "first\nsecond\n" {
+		t.Fatalf("expected the output printed before the deadline, got %q", res.Stdout)
+	}
+	want := []Event{
+		{Type: "stdout", Data: "first\n"},
+		{Type: "stdout", Data: "second\n"},
+		{Type: "exit", Data: res.Exit},
+	}
+	if !reflect.DeepEqual(res.Events, want) {
+		t.Fatalf("expected both chunks before the exit, got %+v", res.Events)
+	}
+	if len(client.killCalls) != 1 || len(client.removedIDs) != 1 {
+		t.Fatalf("expected the container to be killed and removed, got kills=%v removed=%v", client.killCalls, client.removedIDs)
+	}
+}
+
+func TestExecuteKillsProgramPastOutputLimit(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{
+				inspect: types.ContainerExecInspect{ExitCode: 137},
+				stdout:  strings.Repeat("y\n", 3<<20),
+				stderr:  "never seen",
+			},
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, err := Execute(context.Background(), LangPython, "while True: print('y')", Limits{}, Invocation{})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result error %q err=%v", res.Error, err)
+	}
+	if len(res.Stdout) != DefaultMaxOutputBytes || res.Stderr != "" || !res.OutputTruncated {
+		t.Fatalf("expected stdout capped at %d bytes, got %d (stderr %q, truncated=%v)", DefaultMaxOutputBytes, len(res.Stdout), res.Stderr, res.OutputTruncated)
+	}
+	if len(client.killCalls) != 1 {
+		t.Fatalf("expected the program to be killed once, got %v", client.killCalls)
+	}
+	var truncated []Event
+	for _, evt := range res.Events {
+		if evt.Type == "truncated" {
+			truncated = append(truncated, evt)
+		}
+	}
+	if len(truncated) != 1 || truncated[0].Data != (Truncation{LimitBytes: DefaultMaxOutputBytes}) {
+		t.Fatalf("expected one truncated event, got %+v", truncated)
+	}
+	if last := res.Events[len(res.Events)-1]; last.Type != "exit" {
+		t.Fatalf("expected exit to stay the last event, got %+v", last)
+	}
+}
+
+func TestExecuteReportsOOMKill(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		oom  bool
+		want ExitInfo
+		err  string
+	}{
+		{name: "oom", oom: true, want: ExitInfo{Code: 137, OOMKilled: true}, err: "memory_limit_exceeded"},
+		{name: "killed otherwise", want: ExitInfo{Code: 137}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeDockerClient{
+				t:          t,
+				createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+				execQueue: []*fakeExecCall{
+					{}, {}, {},
+					{inspect: types.ContainerExecInspect{ExitCode: 137}},
+				},
+				oomKilled: tc.oom,
+			}
+			useFakeDocker(t, client)
+
+			res, err := Execute(context.Background(), LangPython, "x = [0] * 10**10", Limits{}, Invocation{})
+			if err != nil || res.Error != tc.err {
+				t.Fatalf("expected result error %q, got %q err=%v", tc.err, res.Error, err)
+			}
+			if res.Exit != tc.want || client.inspectCalls != 1 {
+				t.Fatalf("expected exit %+v after one inspect, got %+v (%d inspects)", tc.want, res.Exit, client.inspectCalls)
+			}
+			var errEvents int
+			for _, evt := range res.Events {
+				if evt.Type == "error" {
+					errEvents++
+				}
+			}
+			if tc.oom != (errEvents == 1) {
+				t.Fatalf("unexpected error events in %+v", res.Events)
+			}
+		})
+	}
+}
+
+func TestExecuteStreamEmitsEventsInOrder(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{stdout: "1\n", stderr: "oops\n", inspectErr: errors.New("exec vanished")},
+		},
+	}
+	useFakeDocker(t, client)
+
+	var emitted []Event
+	res, err := ExecuteStream(context.Background(), LangPython, "", Limits{}, Invocation{}, func(evt Event) {
+		emitted = append(emitted, evt)
+	})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if !reflect.DeepEqual(emitted, res.Events) {
+		t.Fatalf("expected the emitted events to match the result, got %+v vs %+v", emitted, res.Events)
+	}
+	var kinds []string
+	for _, evt := range emitted {
+		kinds = append(kinds, evt.Type)
+	}
+	if strings.Join(kinds, ",") != "stdout,stderr,error,exit" {
+		t.Fatalf("expected exit to come last, got %v", kinds)
+	}
+}
+
+func TestExecuteGoStopsOnCompileError(t *testing.T) {
+	build := &fakeExecCall{
+		expectCmd: []string{"go", "build", "-o", "app", "main.go"},
+		inspect:   types.ContainerExecInspect{ExitCode: 1},
+		stderr:    "./main.go:3:1: syntax error\n",
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.go'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.go'"}},
+			build,
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	res, err := Execute(context.Background(), LangGo, "package main\nfunc", Limits{}, Invocation{Args: []string{"x"}})
+	if err != nil || res.Error != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Exit.Code != 1 || res.Stage != StageCompile || res.CompileOutput != "./main.go:3:1: syntax error\n" || res.Stderr != "" {
+		t.Fatalf("expected the compile failure to be reported, got exit %+v stage %q compile output %q stderr %q", res.Exit, res.Stage, res.CompileOutput, res.Stderr)
+	}
+	if len(client.execQueue) != 0 || len(client.executed) != 4 {
+		t.Fatalf("expected ./app not to run after a failed build, executed %d", len(client.executed))
+	}
+	if res.Usage != nil || client.statsCalls != 0 {
+		t.Fatalf("expected no usage when the program never ran, got %+v", res.Usage)
+	}
+}
+
+func TestExecuteReportsCompileStage(t *testing.T) {
+	copies := func() []*fakeExecCall { return []*fakeExecCall{{}, {}, {}} }
+	eventTypes := func(events []Event) []string {
+		var got []string
+		for _, evt := range events {
+			got = append(got, evt.Type)
+		}
+		return got
+	}
+
+	t.Run("java compile failure", func(t *testing.T) {
+		client := &fakeDockerClient{
+			t:          t,
+			createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+			execQueue: append(copies(), &fakeExecCall{
+				expectCmd: []string{"javac", "Main.java"},
+				inspect:   types.ContainerExecInspect{ExitCode: 1},
+				stdout:    "1 error\n",
+				stderr:    "Main.java:1: error: ';' expected\n",
+			}),
+		}
+		useFakeDocker(t, client)
+
+		res, err := Execute(context.Background(), LangJava, "class Main {", Limits{}, Invocation{})
+		if err != nil || res.Error != "" {
+			t.Fatalf("a compile failure is not a sandbox error, got %+v err=%v", res, err)
+		}
+		if res.Stage != StageCompile || res.Exit.Code != 1 || res.Stdout != "" || res.Stderr != "" {
+			t.Fatalf("expected a compile stage failure apart from the program's output, got %+v", res)
+		}
+		if !strings.Contains(res.CompileOutput, "';' expected") || !strings.Contains(res.CompileOutput, "1 error") {
+			t.Fatalf("expected the compiler's output, got %q", res.CompileOutput)
+		}
+		if got := eventTypes(res.Events); !reflect.DeepEqual(got, []string{"compile_error", "exit"}) {
+			t.Fatalf("unexpected events %v", got)
+		}
+	})
+
+	t.Run("cpp compiles then fails at runtime", func(t *testing.T) {
+		client := &fakeDockerClient{
+			t:          t,
+			createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+			execQueue: append(copies(),
+				&fakeExecCall{
+					expectCmd: []string{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"},
+					stderr:    "main.cpp:2: warning: unused variable\n",
+				},
+				&fakeExecCall{
+					expectCmd: []string{"./main"},
+					inspect:   types.ContainerExecInspect{ExitCode: 3},
+					stderr:    "boom\n",
+				},
+			),
+		}
+		useFakeDocker(t, client)
+
+		res, err := Execute(context.Background(), LangCPP, "int main() { return 3; }", Limits{}, Invocation{})
+		if err != nil || res.Error != "" {
+			t.Fatalf("unexpected result %+v err=%v", res, err)
+		}
+		if res.Stage != "" || res.CompileOutput != "" || res.Exit.Code != 3 {
+			t.Fatalf("expected a runtime failure, got %+v", res)
+		}
+		if res.Stderr != "main.cpp:2: warning: unused variable\nboom\n" {
+			t.Fatalf("expected the warning ahead of the program's stderr, got %q", res.Stderr)
+		}
+	})
+
+	t.Run("python has no compile stage", func(t *testing.T) {
+		client := &fakeDockerClient{
+			t:          t,
+			createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+			execQueue: append(copies(), &fakeExecCall{
+				expectCmd: []string{"python3", "main.py"},
+				inspect:   types.ContainerExecInspect{ExitCode: 1},
+				stderr:    "SyntaxError: invalid syntax\n",
+			}),
+		}
+		useFakeDocker(t, client)
+
+		res, err := Execute(context.Background(), LangPython, "def", Limits{}, Invocation{})
+		if err != nil || res.Stage != "" || res.CompileOutput != "" {
+			t.Fatalf("expected no compile stage, got %+v err=%v", res, err)
+		}
+		if res.Stderr != "SyntaxError: invalid syntax\n" {
+			t.Fatalf("expected python's errors on stderr, got %q", res.Stderr)
+		}
+		for _, typ := range eventTypes(res.Events) {
+			if typ == "compile_error" {
+				t.Fatalf("unexpected compile_error event in %+v", res.Events)
+			}
+		}
+	})
+}
+
+func TestExecuteFeedsStdinToProgram(t *testing.T) {
+	compile := &fakeExecCall{
+		expectCmd: []string{"javac", "Main.java"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+	}
+	program := &fakeExecCall{
+		expectCmd: []string{"java", "Main"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+		stdout:    "7\n",
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/Main.java'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/Main.java'"}},
+			compile,
+			program,
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	inv := Invocation{Env: map[string]string{"DEBUG": "1"}, Stdin: "3\n4\n"}
+	res, err := Execute(context.Background(), LangJava, "class Main {}", Limits{}, inv)
+	if err != nil || res.Error != "" || res.Stdout != "7\n" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if compile.gotStdin || compile.stdin.Len() != 0 {
+		t.Fatalf("compile step must not get the run's stdin")
+	}
+	if !program.gotStdin || program.stdin.String() != "3\n4\n" {
+		t.Fatalf("expected stdin to reach the program, got attached=%v %q", program.gotStdin, program.stdin.String())
+	}
+	if !program.conn.closeWrite {
+		t.Fatalf("expected stdin to be closed after writing")
+	}
+	if !reflect.DeepEqual(program.gotEnv, []string{"DEBUG=1"}) {
+		t.Fatalf("expected the env to be kept with stdin attached, got %v", program.gotEnv)
+	}
+}
+
+func TestExecuteWithoutStdinLeavesItDetached(t *testing.T) {
+	program := &fakeExecCall{
+		expectCmd: []string{"python3", "main.py"},
+		inspect:   types.ContainerExecInspect{ExitCode: 0},
+	}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/main.py'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/main.py'"}},
+			program,
+		},
+	}
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	defer func() { newDockerClient = orig }()
+
+	if _, err := Execute(context.Background(), LangPython, "input()", Limits{}, Invocation{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if program.gotStdin {
+		t.Fatalf("expected no stdin to be attached when none is given")
+	}
+}
+
+func TestExecuteRejectsInvalidInvocation(t *testing.T) {
+	_, err := Execute(context.Background(), LangPython, "code", Limits{}, Invocation{Env: map[string]string{"PATH": "/tmp"}})
+	var invErr *InvocationError
+	if !errors.As(err, &invErr) {
+		t.Fatalf("expected an invocation error, got %v", err)
+	}
+}
+
+func TestInvocationValidate(t *testing.T) {
+	tooMany := make([]string, MaxArgs+1)
+	tooManyEnv := make(map[string]string, MaxEnvVars+1)
+	for i := 0; i <= MaxEnvVars; i++ {
+		tooManyEnv[fmt.Sprintf("VAR_%d", i)] = "1"
+	}
+	cases := map[string]struct {
+		inv   Invocation
+		field string
+	}{
+		"too many args":  {Invocation{Args: tooMany}, "args"},
+		"long arg":       {Invocation{Args: []string{"ok", strings.Repeat("a", MaxArgLen+1)}}, "args[1]"},
+		"lowercase key":  {Invocation{Env: map[string]string{"debug": "1"}}, "env.debug"},
+		"leading digit":  {Invocation{Env: map[string]string{"1X": "1"}}, "env.1X"},
+		"path":           {Invocation{Env: map[string]string{"PATH": "/tmp"}}, "env.PATH"},
+		"ld preload":     {Invocation{Env: map[string]string{"LD_PRELOAD": "x.so"}}, "env.LD_PRELOAD"},
+		"home":           {Invocation{Env: map[string]string{"HOME": "/"}}, "env.HOME"},
+		"sandbox prefix": {Invocation{Env: map[string]string{"SANDBOX_TOKEN": "x"}}, "env.SANDBOX_TOKEN"},
+		"docker prefix":  {Invocation{Env: map[string]string{"DOCKER_HOST": "tcp://x"}}, "env.DOCKER_HOST"},
+		"too many vars":  {Invocation{Env: tooManyEnv}, "env"},
+		"long value":     {Invocation{Env: map[string]string{"DATA": strings.Repeat("v", MaxEnvValueLen+1)}}, "env.DATA"},
+		"long stdin":     {Invocation{Stdin: strings.Repeat("x", MaxStdinBytes+1)}, "stdin"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var invErr *InvocationError
+			if !errors.As(tc.inv.Validate(), &invErr) || len(invErr.Fields) != 1 || invErr.Fields[0].Field != tc.field {
+				t.Fatalf("expected a single %s error, got %+v", tc.field, invErr)
+			}
+		})
+	}
+
+	ok := Invocation{
+		Args:  append(make([]string, MaxArgs-1), strings.Repeat("a", MaxArgLen)),
+		Env:   map[string]string{"_X": "", "DEBUG_2": strings.Repeat("v", MaxEnvValueLen)},
+		Stdin: strings.Repeat("x", MaxStdinBytes),
+	}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("expected boundary values to pass, got %v", err)
+	}
+}
+
 func TestExecuteSandboxUnavailable(t *testing.T) {
 	orig := newDockerClient
 	newDockerClient = func() (dockerClient, error) {
@@ -715,7 +1393,7 @@ func TestExecuteSandboxUnavailable(t *testing.T) {
 	}
 	defer func() { newDockerClient = orig }()
 
-	res, err := Execute(context.Background(), LangPython, "code", Limits{})
+	res, err := Execute(context.Background(), LangPython, "code", Limits{}, Invocation{})
 	if err != nil {
 		t.Fatalf("expected nil error, got %v", err)
 	}
@@ -762,7 +1440,7 @@ func TestExecuteSuccessAndErrorEvent(t *testing.T) {
 	}
 	defer func() { newDockerClient = orig }()
 
-	res, err := Execute(context.Background(), LangPython, "print('hi')", Limits{})
+	res, err := Execute(context.Background(), LangPython, "print('hi')", Limits{}, Invocation{})
 	if err != nil {
 		t.Fatalf("unexpected execute error: %v", err)
 	}
@@ -790,7 +1468,7 @@ func TestExecuteSuccessAndErrorEvent(t *testing.T) {
 	newDockerClient = func() (dockerClient, error) {
 		return client, nil
 	}
-	res, err = Execute(context.Background(), LangPython, "print()", Limits{})
+	res, err = Execute(context.Background(), LangPython, "print()", Limits{}, Invocation{})
 	if err != nil {
 		t.Fatalf("unexpected execute error: %v", err)
 	}
@@ -864,17 +1542,40 @@ type fakeDockerClient struct {
 	createErr  error
 	startErr   error
 	removed    bool
+	cfg        *container.Config // of the last create
+	hostCfg    *container.HostConfig
+
+	// uniqueIDs numbers created containers c1, c2, ... instead of createResp.ID
+	uniqueIDs  bool
+	created    int
+	removedIDs []string
 
 	execQueue []*fakeExecCall
 	executed  []*fakeExecCall
 	execMap   map[string]*fakeExecCall
 
 	killCalls []string
+
+	oomKilled bool
+
+	listed       []types.Container // returned by ContainerList
+	listFilters  filters.Args
+	inspectCalls int
+
+	pingErr error
+	pings   int
+
+	stats      types.StatsJSON
+	statsErr   error
+	statsCalls int
 }
 
 type fakeExecCall struct {
-	expectCmd []string
-	gotCmd    []string
+	expectCmd    []string
+	gotContainer string
+	gotCmd       []string
+	gotEnv       []string
+	gotStdin     bool // exec created with stdin attached
 
 	createErr  error
 	attachErr  error
@@ -888,6 +1589,15 @@ type fakeExecCall struct {
 	stdin    bytes.Buffer
 	conn     *fakeConn
 	writeErr error
+
+	// echo scripts an interactive program that writes every stdin chunk back
+	// to stdout and exits once stdin is closed
+	echo bool
+	// attached, if set, is closed once the exec is attached
+	attached chan struct{}
+	// chunks scripts a program that prints each chunk to stdout in turn and
+	// then hangs until the exec is closed
+	chunks []string
 }
 
 func (f *fakeDockerClient) ImageInspectWithRaw(context.Context, string) (types.ImageInspect, []byte, error) {
@@ -902,15 +1612,29 @@ func (f *fakeDockerClient) ImagePull(context.Context, string, types.ImagePullOpt
 	return io.NopCloser(strings.NewReader("ok")), nil
 }
 
-func (f *fakeDockerClient) ContainerCreate(context.Context, *container.Config, *container.HostConfig, *network.NetworkingConfig, *specs.Platform, string) (container.ContainerCreateCreatedBody, error) {
-	return f.createResp, f.createErr
+func (f *fakeDockerClient) ContainerCreate(_ context.Context, cfg *container.Config, hostCfg *container.HostConfig, _ *network.NetworkingConfig, _ *specs.Platform, _ string) (container.ContainerCreateCreatedBody, error) {
+	f.cfg, f.hostCfg = cfg, hostCfg
+	resp := f.createResp
+	if f.createErr == nil {
+		f.created++
+		if f.uniqueIDs {
+			resp.ID = fmt.Sprintf("c%d", f.created)
+		}
+	}
+	return resp, f.createErr
 }
 
-func (f *fakeDockerClient) ContainerRemove(context.Context, string, types.ContainerRemoveOptions) error {
+func (f *fakeDockerClient) ContainerRemove(_ context.Context, containerID string, _ types.ContainerRemoveOptions) error {
 	f.removed = true
+	f.removedIDs = append(f.removedIDs, containerID)
 	return nil
 }
 
+func (f *fakeDockerClient) ContainerList(_ context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	f.listFilters = options.Filters
+	return f.listed, nil
+}
+
 func (f *fakeDockerClient) ContainerStart(context.Context, string, types.ContainerStartOptions) error {
 	return f.startErr
 }
@@ -920,6 +1644,14 @@ func (f *fakeDockerClient) ContainerKill(ctx context.Context, containerID string
 	return nil
 }
 
+func (f *fakeDockerClient) ContainerInspect(_ context.Context, containerID string) (types.ContainerJSON, error) {
+	f.inspectCalls++
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		ID:    containerID,
+		State: &types.ContainerState{OOMKilled: f.oomKilled},
+	}}, nil
+}
+
 func (f *fakeDockerClient) ensureExecMap() {
 	if f.execMap == nil {
 		f.execMap = make(map[string]*fakeExecCall)
@@ -940,6 +1672,8 @@ func (f *fakeDockerClient) nextExec(config types.ExecConfig) (*fakeExecCall, str
 	call := f.execQueue[0]
 	f.execQueue = f.execQueue[1:]
 	call.gotCmd = append([]string(nil), config.Cmd...)
+	call.gotEnv = append([]string(nil), config.Env...)
+	call.gotStdin = config.AttachStdin
 	if len(call.expectCmd) > 0 && !reflect.DeepEqual(call.expectCmd, config.Cmd) {
 		f.fail("expected cmd %v, got %v", call.expectCmd, config.Cmd)
 	}
@@ -953,6 +1687,7 @@ func (f *fakeDockerClient) ContainerExecCreate(ctx context.Context, container st
 	if err != nil {
 		return types.IDResponse{}, err
 	}
+	call.gotContainer = container
 	f.ensureExecMap()
 	f.execMap[id] = call
 	return types.IDResponse{ID: id}, nil
@@ -968,6 +1703,26 @@ func (f *fakeDockerClient) ContainerExecAttach(ctx context.Context, execID strin
 	}
 	conn := &fakeConn{buf: &call.stdin, call: call}
 	call.conn = conn
+	if call.attached != nil {
+		defer close(call.attached)
+	}
+	if call.echo {
+		pr, pw := io.Pipe()
+		conn.echo = pw
+		return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(pr)}, nil
+	}
+	if call.chunks != nil {
+		pr, pw := io.Pipe()
+		conn.echo = pw
+		go func() {
+			for _, chunk := range call.chunks {
+				if _, err := pw.Write(singleStream(1, chunk)); err != nil {
+					return
+				}
+			}
+		}()
+		return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(pr)}, nil
+	}
 	data := muxStreams(call.stdout, call.stderr)
 	return types.HijackedResponse{
 		Conn:   conn,
@@ -991,14 +1746,33 @@ func (f *fakeDockerClient) ContainerExecInspect(ctx context.Context, execID stri
 	if call.inspectErr != nil {
 		return types.ContainerExecInspect{}, call.inspectErr
 	}
+	if err := ctx.Err(); err != nil {
+		return types.ContainerExecInspect{}, err
+	}
 	return call.inspect, nil
 }
 
+func (f *fakeDockerClient) ContainerStatsOneShot(ctx context.Context, containerID string) (types.ContainerStats, error) {
+	f.statsCalls++
+	if f.statsErr != nil {
+		return types.ContainerStats{}, f.statsErr
+	}
+	body, _ := json.Marshal(f.stats)
+	return types.ContainerStats{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeDockerClient) Ping(context.Context) (types.Ping, error) {
+	f.pings++
+	return types.Ping{}, f.pingErr
+}
+
 type fakeConn struct {
+	mu         sync.Mutex
 	buf        *bytes.Buffer
 	closed     bool
 	closeWrite bool
 	call       *fakeExecCall
+	echo       *io.PipeWriter
 }
 
 func (c *fakeConn) Read([]byte) (int, error) {
@@ -1010,13 +1784,21 @@ func (c *fakeConn) Write(p []byte) (int, error) {
 		return 0, c.call.writeErr
 	}
 	if c.buf != nil {
-		return c.buf.Write(p)
+		c.buf.Write(p)
+	}
+	if c.echo != nil {
+		return c.echo.Write(singleStream(1, string(p)))
 	}
 	return len(p), nil
 }
 
 func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.closed = true
+	if c.echo != nil {
+		c.echo.Close()
+	}
 	return nil
 }
 
@@ -1037,7 +1819,12 @@ func (c *fakeConn) SetWriteDeadline(time.Time) error {
 	return nil
 }
 func (c *fakeConn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.closeWrite = true
+	if c.echo != nil {
+		c.echo.Close()
+	}
 	return nil
 }
 
@@ -1077,8 +1864,8 @@ func TestWarmImagesPullsDefaults(t *testing.T) {
 	if err := WarmImages(context.Background()); err != nil {
 		t.Fatalf("warm images error: %v", err)
 	}
-	if len(clients) != 3 {
-		t.Fatalf("expected three warmup clients, got %d", len(clients))
+	if len(clients) != len(Languages()) {
+		t.Fatalf("expected a warmup client per language, got %d", len(clients))
 	}
 	for i, c := range clients {
 		if !c.imagePulled {
@@ -1098,3 +1885,25 @@ func TestWarmImagesPropagatesErrors(t *testing.T) {
 		t.Fatalf("expected docker unavailable error, got %v", err)
 	}
 }
+
+func TestCheckReady(t *testing.T) {
+	fake := &fakeDockerClient{t: t}
+	useFakeDocker(t, fake)
+	if err := CheckReady(context.Background()); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+
+	fake.imageInspectErr = errdefs.NotFound(errors.New("missing"))
+	var missing *MissingImagesError
+	if err := CheckReady(context.Background()); !errors.As(err, &missing) || !reflect.DeepEqual(missing.Languages, Languages()) {
+		t.Fatalf("expected every image to be reported missing, got %v", err)
+	}
+
+	fake.pingErr = client.ErrorConnectionFailed("unix:///var/run/docker.sock")
+	if err := CheckReady(context.Background()); !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("expected ErrDockerUnavailable, got %v", err)
+	}
+	if fake.pings != 3 {
+		t.Fatalf("expected a ping per check, got %d", fake.pings)
+	}
+}
@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds on a test run
+const (
+	MaxTestCases = 50
+	// testCaseGrace is how long a case may outlive its wall time before the
+	// container is given up on; the in-container timeout normally ends it first
+	testCaseGrace = 2 * time.Second
+)
+
+// TestCase is one input fed to the program and the output it must print
+type TestCase struct {
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expectedOutput"`
+}
+
+// TestCaseResult is the outcome of one case. Passed compares the output with
+// trailing whitespace of every line and trailing blank lines ignored.
+type TestCaseResult struct {
+	Passed          bool     `json:"passed"`
+	ActualOutput    string   `json:"actualOutput"`
+	Stderr          string   `json:"stderr,omitempty"`
+	DurationMs      int64    `json:"durationMs"`
+	Exit            ExitInfo `json:"exit"`
+	OutputTruncated bool     `json:"outputTruncated,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// TestRunResult holds one result per case, in order. When the program does
+// not compile no case runs and CompileError holds the compiler's output.
+type TestRunResult struct {
+	Cases        []TestCaseResult `json:"cases"`
+	Passed       int              `json:"passed"`
+	Total        int              `json:"total"`
+	CompileError string           `json:"compileError,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// ValidateTestCases checks the number of cases and the size of their input
+// and returns an *InvocationError listing every violation, or nil.
+func ValidateTestCases(cases []TestCase) error {
+	var fields []FieldError
+	if len(cases) == 0 || len(cases) > MaxTestCases {
+		fields = append(fields, FieldError{Field: "tests", Reason: "between 1 and 50 test cases are required"})
+	}
+	for i, tc := range cases {
+		if len(tc.Input) > MaxStdinBytes {
+			fields = append(fields, FieldError{Field: "tests[" + strconv.Itoa(i) + "].input", Reason: "must be at most 1048576 bytes"})
+		}
+	}
+	if len(fields) > 0 {
+		return &InvocationError{Fields: fields}
+	}
+	return nil
+}
+
+// RunTests compiles code once and runs the program once per case in the same
+// container, feeding each case's input to stdin. Every case gets the full
+// wall time of limits and MaxOutputBytes applies per case.
+func RunTests(ctx context.Context, lang Language, code string, limits Limits, cases []TestCase) (TestRunResult, error) {
+	_, image, fileName, cmds, err := langSpec(lang)
+	if err != nil {
+		return TestRunResult{}, err
+	}
+	if err := ValidateTestCases(cases); err != nil {
+		return TestRunResult{}, err
+	}
+
+	result := TestRunResult{Cases: make([]TestCaseResult, 0, len(cases)), Total: len(cases)}
+	sbx, err := NewSandbox(image, withLanguageDefaults(lang, limits))
+	if err != nil {
+		result.Error = mapSandboxError(err)
+		return result, nil
+	}
+
+	cid, _, release, err := sbx.leaseContainer(ctx)
+	if err != nil {
+		result.Error = mapSandboxError(err)
+		return result, nil
+	}
+	defer func() { release(result.Error == "") }()
+
+	if err := sbx.copyFile(ctx, cid, "/workspace/"+fileName, []byte(code), 0600); err != nil {
+		result.Error = mapSandboxError(translateDockerErr(err))
+		return result, nil
+	}
+
+	expandBuild(cmds, submissionFiles(fileName, code, nil))
+	build, program := cmds[:len(cmds)-1], cmds[len(cmds)-1]
+	for _, cmd := range build {
+		var out strings.Builder
+		collect := func(p []byte) { out.Write(p) }
+		buildCtx, cancel := context.WithTimeout(ctx, sbx.limits.WallTime)
+		exitCode, err := sbx.execCommand(buildCtx, cid, cmd, nil, nil, collect, collect)
+		cancel()
+		if err != nil {
+			result.Error = mapSandboxError(err)
+			return result, nil
+		}
+		if exitCode != 0 {
+			result.CompileError = out.String()
+			return result, nil
+		}
+	}
+
+	program = append(timeoutCommand(sbx.limits.WallTime), program...)
+	for i, tc := range cases {
+		caseResult, err := sbx.runTestCase(ctx, cid, program, tc)
+		if err != nil {
+			// The container cannot be trusted with the remaining cases
+			_ = sbx.cli.ContainerKill(context.Background(), cid, "SIGKILL")
+			result.Error = mapSandboxError(err)
+			for range cases[i:] {
+				result.Cases = append(result.Cases, TestCaseResult{Exit: ExitInfo{Code: -1}, Error: result.Error})
+			}
+			break
+		}
+		if caseResult.Passed {
+			result.Passed++
+		}
+		result.Cases = append(result.Cases, caseResult)
+	}
+	return result, nil
+}
+
+// runTestCase runs program once with the case's input. It fails only when the
+// sandbox itself does, including a program that outlives the in-container
+// timeout.
+func (s *Sandbox) runTestCase(ctx context.Context, cid string, program []string, tc TestCase) (TestCaseResult, error) {
+	var stdout, stderr strings.Builder
+	var res TestCaseResult
+	written := 0
+	capture := func(buf *strings.Builder) func([]byte) {
+		return func(p []byte) {
+			if max := s.limits.MaxOutputBytes; max > 0 && written+len(p) > max {
+				p = p[:max-written]
+				res.OutputTruncated = true
+			}
+			written += len(p)
+			buf.Write(p)
+		}
+	}
+
+	caseCtx, cancel := context.WithTimeout(ctx, s.limits.WallTime+testCaseGrace)
+	defer cancel()
+	started := time.Now()
+	code, err := s.execCommand(caseCtx, cid, program, s.env, []byte(tc.Input), capture(&stdout), capture(&stderr))
+	elapsed := time.Since(started)
+	if err != nil {
+		if errors.Is(caseCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			err = errTestCaseStuck
+		}
+		return TestCaseResult{}, err
+	}
+
+	res.ActualOutput = stdout.String()
+	res.Stderr = stderr.String()
+	res.DurationMs = elapsed.Milliseconds()
+	res.Exit = ExitInfo{Code: code, TimedOut: code == timeoutExitCode && elapsed >= s.limits.WallTime}
+	res.Passed = code == 0 && !res.OutputTruncated && normalizeOutput(res.ActualOutput) == normalizeOutput(tc.ExpectedOutput)
+	return res, nil
+}
+
+// timeoutExitCode is what a program killed by timeout -s KILL exits with
+const timeoutExitCode = 128 + 9
+
+var errTestCaseStuck = errors.New("test case outlived its timeout")
+
+// timeoutCommand bounds a case from inside the container, which unlike
+// killing the container leaves it usable for the next case
+func timeoutCommand(wall time.Duration) []string {
+	return []string{"timeout", "-s", "KILL", strconv.FormatFloat(wall.Seconds(), 'f', -1, 64)}
+}
+
+// normalizeOutput drops carriage returns, trailing whitespace on each line
+// and trailing blank lines, which judges conventionally ignore
+func normalizeOutput(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestRunTestsCompilesOnceAndJudgesEachCase(t *testing.T) {
+	program := []string{"timeout", "-s", "KILL", "2", "java", "Main"}
+	first := &fakeExecCall{expectCmd: program, inspect: types.ContainerExecInspect{ExitCode: 0}, stdout: "3  \r\n\n\n"}
+	second := &fakeExecCall{expectCmd: program, inspect: types.ContainerExecInspect{ExitCode: 0}, stdout: "4\n"}
+	crashed := &fakeExecCall{expectCmd: program, inspect: types.ContainerExecInspect{ExitCode: 1}, stderr: "Exception\n"}
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{expectCmd: []string{"/bin/sh", "-c", "mkdir -p '/workspace'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "cat > '/workspace/Main.java'"}},
+			{expectCmd: []string{"/bin/sh", "-c", "chmod 600 '/workspace/Main.java'"}},
+			{expectCmd: []string{"javac", "Main.java"}},
+			first, second, crashed,
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, err := RunTests(context.Background(), LangJava, "class Main {}", Limits{WallTime: 2 * time.Second}, []TestCase{
+		{Input: "1 2\n", ExpectedOutput: "3\n"},
+		{Input: "2 3\n", ExpectedOutput: "5"},
+		{Input: "", ExpectedOutput: ""},
+	})
+	if err != nil || res.Error != "" || res.CompileError != "" {
+		t.Fatalf("unexpected result %+v err=%v", res, err)
+	}
+	if res.Total != 3 || res.Passed != 1 || len(res.Cases) != 3 {
+		t.Fatalf("expected one of three cases to pass, got %+v", res)
+	}
+	if !res.Cases[0].Passed || res.Cases[0].ActualOutput != "3  \r\n\n\n" {
+		t.Fatalf("expected trailing whitespace to be ignored, got %+v", res.Cases[0])
+	}
+	if res.Cases[1].Passed || res.Cases[1].ActualOutput != "4\n" {
+		t.Fatalf("expected a wrong answer, got %+v", res.Cases[1])
+	}
+	if res.Cases[2].Passed || res.Cases[2].Exit.Code != 1 || res.Cases[2].Stderr != "Exception\n" {
+		t.Fatalf("expected a non-zero exit to fail the case, got %+v", res.Cases[2])
+	}
+	if first.stdin.String() != "1 2\n" || !first.gotStdin || crashed.gotStdin {
+		t.Fatalf("expected each case's input on stdin, got %q", first.stdin.String())
+	}
+	if len(client.execQueue) != 0 || !client.removed {
+		t.Fatalf("expected every exec to run and the container to be removed")
+	}
+}
+
+func TestRunTestsReportsCompileError(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{
+				expectCmd: []string{"g++", "-O2", "-std=c++17", "main.cpp", "-o", "main"},
+				inspect:   types.ContainerExecInspect{ExitCode: 1},
+				stderr:    "main.cpp:1:1: error\n",
+			},
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, err := RunTests(context.Background(), LangCPP, "int main(", Limits{}, []TestCase{{ExpectedOutput: "1"}})
+	if err != nil || res.CompileError != "main.cpp:1:1: error\n" {
+		t.Fatalf("expected the compiler output, got %+v err=%v", res, err)
+	}
+	if len(res.Cases) != 0 || res.Passed != 0 || res.Total != 1 {
+		t.Fatalf("expected no case to run, got %+v", res)
+	}
+}
+
+func TestRunTestsReportsTimedOutCase(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{inspect: types.ContainerExecInspect{ExitCode: timeoutExitCode}},
+			{inspect: types.ContainerExecInspect{ExitCode: 0}, stdout: "ok\n"},
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, err := RunTests(context.Background(), LangPython, "", Limits{WallTime: time.Nanosecond}, []TestCase{
+		{ExpectedOutput: "ok"}, {ExpectedOutput: "ok"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := res.Cases[0]; got.Passed || !got.Exit.TimedOut {
+		t.Fatalf("expected the first case to time out, got %+v", got)
+	}
+	if !res.Cases[1].Passed {
+		t.Fatalf("expected the next case to run in the same container, got %+v", res.Cases[1])
+	}
+}
+
+func TestRunTestsSandboxFailureFailsRemainingCases(t *testing.T) {
+	client := &fakeDockerClient{
+		t:          t,
+		createResp: container.ContainerCreateCreatedBody{ID: "cid"},
+		execQueue: []*fakeExecCall{
+			{}, {}, {},
+			{inspect: types.ContainerExecInspect{ExitCode: 0}, stdout: "1\n"},
+			{inspectErr: errors.New("exec vanished")},
+		},
+	}
+	useFakeDocker(t, client)
+
+	res, _ := RunTests(context.Background(), LangPython, "", Limits{}, []TestCase{
+		{ExpectedOutput: "1"}, {ExpectedOutput: "1"}, {ExpectedOutput: "1"},
+	})
+	if res.Error != "sandbox_error" || res.Passed != 1 || len(res.Cases) != 3 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Cases[1].Error != "sandbox_error" || res.Cases[2].Error != "sandbox_error" {
+		t.Fatalf("expected the remaining cases to carry the error, got %+v", res.Cases)
+	}
+	if len(client.killCalls) == 0 {
+		t.Fatalf("expected the container to be killed")
+	}
+}
+
+func TestValidateTestCases(t *testing.T) {
+	var invErr *InvocationError
+	if !errors.As(ValidateTestCases(nil), &invErr) || invErr.Fields[0].Field != "tests" {
+		t.Fatalf("expected no cases to be rejected, got %v", invErr)
+	}
+	cases := []TestCase{{}, {Input: strings.Repeat("x", MaxStdinBytes+1)}}
+	if !errors.As(ValidateTestCases(cases), &invErr) || invErr.Fields[0].Field != "tests[1].input" {
+		t.Fatalf("expected the oversized input to be rejected, got %v", invErr)
+	}
+	if err := ValidateTestCases(make([]TestCase, MaxTestCases)); err != nil {
+		t.Fatalf("expected %d cases to be accepted, got %v", MaxTestCases, err)
+	}
+}
+
+func TestNormalizeOutput(t *testing.T) {
+	cases := map[string]string{
+		"1 2 3\n":         "1 2 3",
+		"a \t\r\nb  \n\n": "a\nb",
+		"\n\nx":           "\n\nx",
+		"":                "",
+	}
+	for in, want := range cases {
+		if got := normalizeOutput(in); got != want {
+			t.Errorf("normalizeOutput(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if !reflect.DeepEqual(timeoutCommand(1500*time.Millisecond), []string{"timeout", "-s", "KILL", "1.5"}) {
+		t.Fatalf("unexpected timeout command %v", timeoutCommand(1500*time.Millisecond))
+	}
+}
@@ -0,0 +1,380 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WatchdogState is whether the sandbox is taking runs
+type WatchdogState string
+
+const (
+	WatchdogHealthy  WatchdogState = "healthy"
+	WatchdogDegraded WatchdogState = "degraded"
+)
+
+// Reasons for a watchdog transition
+const (
+	WatchdogReasonProbeFailures = "probe_failures"
+	WatchdogReasonFailureRate   = "failure_rate"
+	WatchdogReasonRecovered     = "recovered"
+)
+
+// maxProbeHistory bounds the probe results kept for the admin report
+const maxProbeHistory = 20
+
+// WatchdogConfig sets when the watchdog degrades the service and when it
+// lets it recover.
+type WatchdogConfig struct {
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+	// ProbeFailures consecutive failed daemon probes degrade the service
+	ProbeFailures int
+	// FailureRate is the share of sandbox failures among the last
+	// FailureWindow runs that degrades the service, once at least MinRuns
+	// runs are in the window
+	FailureRate   float64
+	FailureWindow int
+	MinRuns       int
+	// RecoverSuccesses consecutive successful probes clear the degraded state
+	RecoverSuccesses int
+
+	// Remediate runs once each time the service degrades; nil does nothing
+	Remediate        func(ctx context.Context) error
+	RemediateTimeout time.Duration
+
+	// Limiter, when set, has its waiting runs drained with ErrSandboxDegraded
+	// as the service degrades
+	Limiter *Limiter
+
+	OnTransition func(WatchdogTransition)
+	OnProbe      func(ProbeResult)
+}
+
+// DefaultWatchdogConfig returns the thresholds used when none are configured
+func DefaultWatchdogConfig() WatchdogConfig {
+	return WatchdogConfig{
+		ProbeInterval:    10 * time.Second,
+		ProbeTimeout:     3 * time.Second,
+		ProbeFailures:    3,
+		FailureRate:      0.5,
+		FailureWindow:    20,
+		MinRuns:          10,
+		RecoverSuccesses: 3,
+		RemediateTimeout: time.Minute,
+	}
+}
+
+// WatchdogTransition is a change of the watchdog state
+type WatchdogTransition struct {
+	From   WatchdogState `json:"from"`
+	To     WatchdogState `json:"to"`
+	Reason string        `json:"reason"`
+	At     time.Time     `json:"at"`
+}
+
+// ProbeResult is the outcome of one daemon probe
+type ProbeResult struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RemediationResult is the outcome of the last remediation hook run
+type RemediationResult struct {
+	At    time.Time `json:"at"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+// FailureWindow is the rolling record of recent run outcomes
+type FailureWindow struct {
+	Size     int     `json:"size"`
+	Runs     int     `json:"runs"`
+	Failures int     `json:"failures"`
+	Rate     float64 `json:"rate"`
+}
+
+// WatchdogThresholds are the configured limits, as reported
+type WatchdogThresholds struct {
+	ProbeIntervalMs  int64   `json:"probeIntervalMs"`
+	ProbeFailures    int     `json:"probeFailures"`
+	FailureRate      float64 `json:"failureRate"`
+	MinRuns          int     `json:"minRuns"`
+	RecoverSuccesses int     `json:"recoverSuccesses"`
+}
+
+// WatchdogReport is the watchdog state served to admins
+type WatchdogReport struct {
+	State                     WatchdogState      `json:"state"`
+	Reason                    string             `json:"reason,omitempty"`
+	Since                     time.Time          `json:"since"`
+	ConsecutiveProbeFailures  int                `json:"consecutiveProbeFailures"`
+	ConsecutiveProbeSuccesses int                `json:"consecutiveProbeSuccesses"`
+	Probes                    []ProbeResult      `json:"probes"` // oldest first
+	Window                    FailureWindow      `json:"window"`
+	Remediation               *RemediationResult `json:"remediation,omitempty"`
+	Thresholds                WatchdogThresholds `json:"thresholds"`
+}
+
+// Watchdog degrades the sandbox when the Docker daemon stops answering probes
+// or runs start failing in the sandbox itself, so callers fail fast instead of
+// waiting out their timeouts, and clears the state once probes pass again.
+type Watchdog struct {
+	cfg WatchdogConfig
+	now func() time.Time
+
+	mu             sync.Mutex
+	state          WatchdogState
+	reason         string
+	since          time.Time
+	probeFailures  int
+	probeSuccesses int
+	probes         []ProbeResult
+	runs           []bool // ring of recent outcomes, true for a failure
+	runsNext       int
+	runsFull       bool
+	remediating    bool
+	remediation    *RemediationResult
+}
+
+// NewWatchdog returns a healthy watchdog. Non-positive thresholds take their
+// defaults.
+func NewWatchdog(cfg WatchdogConfig) *Watchdog {
+	def := DefaultWatchdogConfig()
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = def.ProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = def.ProbeTimeout
+	}
+	if cfg.ProbeFailures <= 0 {
+		cfg.ProbeFailures = def.ProbeFailures
+	}
+	if cfg.FailureRate <= 0 || cfg.FailureRate > 1 {
+		cfg.FailureRate = def.FailureRate
+	}
+	if cfg.FailureWindow <= 0 {
+		cfg.FailureWindow = def.FailureWindow
+	}
+	if cfg.MinRuns <= 0 || cfg.MinRuns > cfg.FailureWindow {
+		cfg.MinRuns = min(def.MinRuns, cfg.FailureWindow)
+	}
+	if cfg.RecoverSuccesses <= 0 {
+		cfg.RecoverSuccesses = def.RecoverSuccesses
+	}
+	if cfg.RemediateTimeout <= 0 {
+		cfg.RemediateTimeout = def.RemediateTimeout
+	}
+	return &Watchdog{
+		cfg:   cfg,
+		now:   time.Now,
+		state: WatchdogHealthy,
+		since: time.Now(),
+		runs:  make([]bool, cfg.FailureWindow),
+	}
+}
+
+// Run probes the daemon every ProbeInterval until ctx is done
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Probe(ctx)
+		}
+	}
+}
+
+// Probe pings the Docker daemon once and applies the result
+func (w *Watchdog) Probe(ctx context.Context) ProbeResult {
+	probeCtx, cancel := context.WithTimeout(ctx, w.cfg.ProbeTimeout)
+	defer cancel()
+
+	start := w.now()
+	err := pingDaemon(probeCtx)
+	result := ProbeResult{At: start, OK: err == nil, LatencyMs: w.now().Sub(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if w.cfg.OnProbe != nil {
+		w.cfg.OnProbe(result)
+	}
+
+	w.mu.Lock()
+	w.probes = append(w.probes, result)
+	if len(w.probes) > maxProbeHistory {
+		w.probes = w.probes[len(w.probes)-maxProbeHistory:]
+	}
+	var tr *WatchdogTransition
+	if result.OK {
+		w.probeFailures = 0
+		w.probeSuccesses++
+		if w.state == WatchdogDegraded && w.probeSuccesses >= w.cfg.RecoverSuccesses {
+			tr = w.transitionLocked(WatchdogHealthy, WatchdogReasonRecovered)
+		}
+	} else {
+		w.probeSuccesses = 0
+		w.probeFailures++
+		if w.state == WatchdogHealthy && w.probeFailures >= w.cfg.ProbeFailures {
+			tr = w.transitionLocked(WatchdogDegraded, WatchdogReasonProbeFailures)
+		}
+	}
+	w.mu.Unlock()
+
+	w.transitioned(tr)
+	return result
+}
+
+// RecordRun records the outcome of an execution by its error code. Only
+// failures of the sandbox itself count, not errors of the program it ran.
+func (w *Watchdog) RecordRun(errCode string) {
+	failed := errCode == "sandbox_unavailable" || errCode == "sandbox_error"
+
+	w.mu.Lock()
+	if w.state == WatchdogDegraded {
+		w.mu.Unlock()
+		return
+	}
+	w.runs[w.runsNext] = failed
+	w.runsNext = (w.runsNext + 1) % len(w.runs)
+	if w.runsNext == 0 {
+		w.runsFull = true
+	}
+	var tr *WatchdogTransition
+	if window := w.windowLocked(); window.Runs >= w.cfg.MinRuns && window.Rate >= w.cfg.FailureRate {
+		tr = w.transitionLocked(WatchdogDegraded, WatchdogReasonFailureRate)
+	}
+	w.mu.Unlock()
+
+	w.transitioned(tr)
+}
+
+// Degraded reports whether runs should be refused
+func (w *Watchdog) Degraded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state == WatchdogDegraded
+}
+
+// Report returns the current state, recent probes and failure window
+func (w *Watchdog) Report() WatchdogReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	report := WatchdogReport{
+		State:                     w.state,
+		Reason:                    w.reason,
+		Since:                     w.since,
+		ConsecutiveProbeFailures:  w.probeFailures,
+		ConsecutiveProbeSuccesses: w.probeSuccesses,
+		Probes:                    append([]ProbeResult{}, w.probes...),
+		Window:                    w.windowLocked(),
+		Thresholds: WatchdogThresholds{
+			ProbeIntervalMs:  w.cfg.ProbeInterval.Milliseconds(),
+			ProbeFailures:    w.cfg.ProbeFailures,
+			FailureRate:      w.cfg.FailureRate,
+			MinRuns:          w.cfg.MinRuns,
+			RecoverSuccesses: w.cfg.RecoverSuccesses,
+		},
+	}
+	if w.remediation != nil {
+		remediation := *w.remediation
+		report.Remediation = &remediation
+	}
+	return report
+}
+
+func (w *Watchdog) windowLocked() FailureWindow {
+	window := FailureWindow{Size: len(w.runs), Runs: w.runsNext}
+	if w.runsFull {
+		window.Runs = len(w.runs)
+	}
+	for _, failed := range w.runs[:window.Runs] {
+		if failed {
+			window.Failures++
+		}
+	}
+	if window.Runs > 0 {
+		window.Rate = float64(window.Failures) / float64(window.Runs)
+	}
+	return window
+}
+
+// transitionLocked moves to state. Probe streaks restart so recovery needs
+// fresh successes, and the run window is cleared so failures from before a
+// recovery do not trip the service again.
+func (w *Watchdog) transitionLocked(state WatchdogState, reason string) *WatchdogTransition {
+	tr := &WatchdogTransition{From: w.state, To: state, Reason: reason, At: w.now()}
+	w.state, w.reason, w.since = state, reason, tr.At
+	w.probeSuccesses = 0
+	if state == WatchdogHealthy {
+		w.reason = ""
+		w.probeFailures = 0
+		clear(w.runs)
+		w.runsNext, w.runsFull = 0, false
+	}
+	return tr
+}
+
+// transitioned runs the side effects of a transition outside the lock
+func (w *Watchdog) transitioned(tr *WatchdogTransition) {
+	if tr == nil {
+		return
+	}
+	if tr.To == WatchdogDegraded {
+		if w.cfg.Limiter != nil {
+			w.cfg.Limiter.Drain(ErrSandboxDegraded)
+		}
+		w.startRemediation()
+	}
+	if w.cfg.OnTransition != nil {
+		w.cfg.OnTransition(*tr)
+	}
+}
+
+// startRemediation runs the remediation hook in the background unless a run
+// is already in flight
+func (w *Watchdog) startRemediation() {
+	if w.cfg.Remediate == nil {
+		return
+	}
+	w.mu.Lock()
+	if w.remediating {
+		w.mu.Unlock()
+		return
+	}
+	w.remediating = true
+	w.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), w.cfg.RemediateTimeout)
+		defer cancel()
+		err := w.cfg.Remediate(ctx)
+		result := &RemediationResult{At: w.now(), OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		w.mu.Lock()
+		w.remediating = false
+		w.remediation = result
+		w.mu.Unlock()
+	}()
+}
+
+// pingDaemon is the cheap health probe: the daemon answering /_ping
+func pingDaemon(ctx context.Context) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return translateDockerErr(err)
+	}
+	if closer, ok := cli.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	_, err = cli.Ping(ctx)
+	return translateDockerErr(err)
+}
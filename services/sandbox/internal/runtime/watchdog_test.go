@@ -0,0 +1,168 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchdogWithFakeDaemon returns a watchdog probing client and the
+// transitions it records
+func watchdogWithFakeDaemon(t *testing.T, client *fakeDockerClient, cfg WatchdogConfig) (*Watchdog, func() []WatchdogTransition) {
+	t.Helper()
+	orig := newDockerClient
+	newDockerClient = func() (dockerClient, error) { return client, nil }
+	t.Cleanup(func() { newDockerClient = orig })
+
+	var mu sync.Mutex
+	var transitions []WatchdogTransition
+	cfg.OnTransition = func(tr WatchdogTransition) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, tr)
+	}
+	return NewWatchdog(cfg), func() []WatchdogTransition {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]WatchdogTransition{}, transitions...)
+	}
+}
+
+func TestWatchdogProbeFailuresDegradeAndRecoverWithHysteresis(t *testing.T) {
+	client := &fakeDockerClient{t: t}
+	wd, transitions := watchdogWithFakeDaemon(t, client, WatchdogConfig{ProbeFailures: 2, RecoverSuccesses: 3})
+	ctx := context.Background()
+
+	client.pingErr = errors.New("containerd: deadline exceeded")
+	wd.Probe(ctx)
+	if wd.Degraded() {
+		t.Fatalf("expected a single failed probe to be tolerated")
+	}
+	wd.Probe(ctx)
+	if !wd.Degraded() {
+		t.Fatalf("expected two failed probes to degrade the service")
+	}
+
+	// A failure in the middle of the recovery streak restarts it
+	client.pingErr = nil
+	wd.Probe(ctx)
+	wd.Probe(ctx)
+	client.pingErr = errors.New("still wedged")
+	wd.Probe(ctx)
+	client.pingErr = nil
+	wd.Probe(ctx)
+	wd.Probe(ctx)
+	if !wd.Degraded() {
+		t.Fatalf("expected recovery to need %d consecutive successes", 3)
+	}
+	wd.Probe(ctx)
+	if wd.Degraded() {
+		t.Fatalf("expected three consecutive successes to recover")
+	}
+
+	got := transitions()
+	if len(got) != 2 ||
+		got[0].To != WatchdogDegraded || got[0].Reason != WatchdogReasonProbeFailures ||
+		got[1].To != WatchdogHealthy || got[1].Reason != WatchdogReasonRecovered {
+		t.Fatalf("unexpected transitions %+v", got)
+	}
+	if client.pings != 8 {
+		t.Fatalf("expected every probe to ping the daemon, got %d pings", client.pings)
+	}
+
+	report := wd.Report()
+	if len(report.Probes) != 8 || report.Probes[4].OK || report.Probes[4].Error != "still wedged" {
+		t.Fatalf("unexpected probe history %+v", report.Probes)
+	}
+	if report.State != WatchdogHealthy || report.ConsecutiveProbeSuccesses != 0 || report.Reason != "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestWatchdogFailureRateDegrades(t *testing.T) {
+	client := &fakeDockerClient{t: t}
+	wd, transitions := watchdogWithFakeDaemon(t, client, WatchdogConfig{
+		FailureWindow: 4, MinRuns: 4, FailureRate: 0.5, RecoverSuccesses: 1,
+	})
+
+	// Errors of the program itself do not count against the daemon
+	wd.RecordRun("")
+	wd.RecordRun("unsupported_language")
+	wd.RecordRun("sandbox_error")
+	if wd.Degraded() {
+		t.Fatalf("expected no verdict before the window holds MinRuns runs")
+	}
+	wd.RecordRun("sandbox_unavailable")
+	if !wd.Degraded() {
+		t.Fatalf("expected half the window failing to degrade the service")
+	}
+	if report := wd.Report(); report.Reason != WatchdogReasonFailureRate || report.Window.Failures != 2 || report.Window.Rate != 0.5 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// Recovering clears the window so the old failures cannot trip it again
+	wd.Probe(context.Background())
+	if wd.Degraded() {
+		t.Fatalf("expected a passing probe to recover")
+	}
+	if window := wd.Report().Window; window.Runs != 0 || window.Failures != 0 {
+		t.Fatalf("expected an empty window after recovery, got %+v", window)
+	}
+	wd.RecordRun("sandbox_error")
+	if wd.Degraded() {
+		t.Fatalf("expected a single failure after recovery to be tolerated")
+	}
+	if got := transitions(); len(got) != 2 {
+		t.Fatalf("unexpected transitions %+v", got)
+	}
+}
+
+func TestWatchdogDrainsWaitingRunsAndRemediates(t *testing.T) {
+	client := &fakeDockerClient{t: t, pingErr: errors.New("daemon gone")}
+	limiter := NewLimiter(1)
+	remediated := make(chan struct{})
+	wd, _ := watchdogWithFakeDaemon(t, client, WatchdogConfig{
+		ProbeFailures: 1,
+		Limiter:       limiter,
+		Remediate: func(context.Context) error {
+			close(remediated)
+			return errors.New("restart failed")
+		},
+	})
+
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	waiting := make(chan error, 1)
+	go func() { waiting <- limiter.Acquire(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+
+	wd.Probe(context.Background())
+
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, ErrSandboxDegraded) || mapSandboxError(err) != "sandbox_unavailable" {
+			t.Fatalf("expected the waiting run to fail with ErrSandboxDegraded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiting run was not drained")
+	}
+	if limiter.InUse() != 1 {
+		t.Fatalf("expected the running slot to be kept, got %d in use", limiter.InUse())
+	}
+
+	select {
+	case <-remediated:
+	case <-time.After(time.Second):
+		t.Fatalf("remediation hook did not run")
+	}
+	deadline := time.Now().Add(time.Second)
+	for wd.Report().Remediation == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r := wd.Report().Remediation; r == nil || r.OK || r.Error != "restart failed" {
+		t.Fatalf("expected the failed remediation to be reported, got %+v", r)
+	}
+}
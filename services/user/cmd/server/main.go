@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"peerprep/user/internal/audit"
 	"peerprep/user/internal/handlers"
 	"peerprep/user/internal/metrics"
 	"peerprep/user/internal/models"
@@ -18,6 +19,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -117,7 +119,9 @@ func run() error {
 	}
 
 	// Auto-migrate models
-	if err := runAutoMigrate(db, &models.User{}, &models.Token{}, &models.InterviewHistory{}); err != nil {
+	if err := runAutoMigrate(db, &models.User{}, &models.Token{}, &models.InterviewHistory{},
+		&models.EmailOutbox{}, &models.BulkProvisionRun{}, &models.KnownDevice{}, &models.AuthEvent{},
+		&models.AccountPurgeJob{}, &models.AuditEvent{}); err != nil {
 		logger.Error("Failed to migrate database", zap.Error(err))
 		return err
 	}
@@ -125,13 +129,38 @@ func run() error {
 	// Initialize repository and handlers
 	userRepo := &repositories.UserRepository{DB: db}
 	tokenRepo := &repositories.TokenRepository{DB: db}
+	deviceRepo := &repositories.DeviceRepository{DB: db}
+	deletionRepo := &repositories.AccountDeletionRepository{DB: db, AuditRetention: audit.DefaultRetention}
+	if v := os.Getenv("AUDIT_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention <= 0 {
+			logger.Warn("Invalid AUDIT_RETENTION, using the default", zap.String("value", v))
+		} else {
+			deletionRepo.AuditRetention = retention
+		}
+	}
+	auditRepo := &repositories.AuditRepository{DB: db}
 	authHandler := handlers.NewAuthHandler(userRepo, tokenRepo)
-	userHandler := &handlers.UserHandler{Repo: userRepo, JWTSecret: authHandler.JWTSecret, Tokens: tokenRepo}
+	authHandler.Devices = deviceRepo
+	authHandler.Deletions = deletionRepo
+	deviceHandler := &handlers.DeviceHandler{Repo: deviceRepo, Users: userRepo, JWTSecret: authHandler.JWTSecret}
+	userHandler := &handlers.UserHandler{Repo: userRepo, JWTSecret: authHandler.JWTSecret, Tokens: tokenRepo, Deletions: deletionRepo, AuditTrail: auditRepo}
+
+	adminHandler := handlers.NewAdminHandler(userRepo, &repositories.ProvisioningRepository{DB: db})
+	adminHandler.AuditTrail = auditRepo
+	if adminHandler.AdminToken == "" {
+		logger.Warn("USER_ADMIN_TOKEN is not set; admin endpoints will reject every request")
+	}
+
+	// Deliver emails queued in the outbox (bulk provisioning welcome emails, new sign-in notices)
+	outboxRepo := &repositories.EmailOutboxRepository{DB: db}
+	go services.NewEmailDispatcher(outboxRepo, 30*time.Second).Run(context.Background())
 
 	historyRepo := &repositories.HistoryRepository{DB: db}
 	historyHandler := &handlers.HistoryHandler{Repo: historyRepo}
 
 	// Initialize Redis subscriber for session ended events (skip in test mode)
+	var purgeEvents *redis.Client
 	skipRedis := os.Getenv("SKIP_REDIS_SUBSCRIBER")
 	if skipRedis == "" {
 		redisAddr := os.Getenv("REDIS_ADDR")
@@ -142,8 +171,13 @@ func run() error {
 
 		// Start Redis subscriber in background
 		go historySubscriber.SubscribeToSessionEnded(context.Background())
+
+		purgeEvents = redis.NewClient(&redis.Options{Addr: redisAddr})
 	}
 
+	// Permanently delete accounts whose restore window has ended and publish user_deleted
+	go services.NewAccountPurger(deletionRepo, purgeEvents, time.Minute).Run(context.Background())
+
 	// Set up router
 	r := chi.NewRouter()
 	r.Use(
@@ -159,7 +193,7 @@ func run() error {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://127.0.0.1:5173", "http://" + dbHost},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
@@ -178,6 +212,8 @@ func run() error {
 	routers.UserRoutes(r, userHandler)
 	routers.AuthRoutes(r, authHandler)
 	routers.HistoryRoutes(r, historyHandler)
+	routers.AdminRoutes(r, adminHandler)
+	routers.DeviceRoutes(r, deviceHandler)
 
 	// Start server
 	port := os.Getenv("PORT")
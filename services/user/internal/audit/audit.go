@@ -0,0 +1,121 @@
+// Package audit writes the append-only trail of sensitive account actions.
+// Events are recorded with the transaction of the action they describe, so a
+// rolled back action leaves no event behind.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"peerprep/user/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// DefaultRetention is how long events outlive a purged account
+const DefaultRetention = 365 * 24 * time.Hour
+
+const redacted = "[redacted]"
+
+// sensitiveKeys are metadata key fragments whose values are never stored
+var sensitiveKeys = []string{"password", "token", "secret"}
+
+// NewEvent builds an event for userID. metadata is redacted and may be nil.
+func NewEvent(eventType models.AuditEventType, userID uint, actor string, actorID uint, metadata map[string]any) *models.AuditEvent {
+	encoded, err := json.Marshal(Redact(metadata))
+	if err != nil || metadata == nil {
+		encoded = []byte("{}")
+	}
+	return &models.AuditEvent{
+		UserID:   userID,
+		Actor:    actor,
+		ActorID:  actorID,
+		Type:     eventType,
+		Metadata: models.AuditMetadata(encoded),
+	}
+}
+
+// Redact returns a copy of metadata with the values of password, token and
+// secret keys replaced, at any depth
+func Redact(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return nil
+	}
+	out := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		if isSensitive(key) {
+			out[key] = redacted
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			out[key] = Redact(v)
+		case []any:
+			items := make([]any, len(v))
+			for i, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					item = Redact(m)
+				}
+				items[i] = item
+			}
+			out[key] = items
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record appends events using tx, so they commit or roll back with the action
+// they describe. Nil events are skipped.
+func Record(tx *gorm.DB, events ...*models.AuditEvent) error {
+	for _, event := range events {
+		if event == nil {
+			continue
+		}
+		if !event.Type.Valid() {
+			return fmt.Errorf("unknown audit event type %q", event.Type)
+		}
+		if event.Metadata == "" {
+			event.Metadata = "{}"
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Anonymize detaches a purged user's events from the account, dropping the
+// details that identify it, and keeps them until retainUntil
+func Anonymize(tx *gorm.DB, userID uint, retainUntil time.Time) error {
+	// UpdateColumns skips the hooks that make events immutable
+	return tx.Model(&models.AuditEvent{}).Where("user_id = ?", userID).UpdateColumns(map[string]any{
+		"user_id":      0,
+		"actor_id":     0,
+		"metadata":     "{}",
+		"ip":           "",
+		"user_agent":   "",
+		"retain_until": retainUntil,
+	}).Error
+}
+
+// Expire deletes anonymized events whose retention ended by now
+func Expire(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Session(&gorm.Session{SkipHooks: true}).
+		Where("retain_until IS NOT NULL AND retain_until <= ?", now).
+		Delete(&models.AuditEvent{})
+	return result.RowsAffected, result.Error
+}
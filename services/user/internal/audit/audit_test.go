@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"peerprep/user/internal/models"
+)
+
+func TestRedact(t *testing.T) {
+	in := map[string]any{
+		"newPassword": "hunter2!",
+		"resetToken":  "abc",
+		"client": map[string]any{
+			"apiSecret": "s3cr3t",
+			"name":      "web",
+		},
+		"changes": []any{map[string]any{"passwordHash": "$2a$"}, "plain"},
+		"email":   "alice@example.com",
+	}
+	out := Redact(in)
+	if out["newPassword"] != redacted || out["resetToken"] != redacted || out["email"] != "alice@example.com" {
+		t.Fatalf("unexpected top level: %v", out)
+	}
+	client := out["client"].(map[string]any)
+	if client["apiSecret"] != redacted || client["name"] != "web" {
+		t.Fatalf("unexpected nested map: %v", client)
+	}
+	changes := out["changes"].([]any)
+	if changes[0].(map[string]any)["passwordHash"] != redacted || changes[1] != "plain" {
+		t.Fatalf("unexpected list: %v", changes)
+	}
+	if in["newPassword"] != "hunter2!" {
+		t.Fatalf("Redact must not modify its input")
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	event := NewEvent(models.AuditPasswordChanged, 7, models.AuditActorUser, 7, map[string]any{"password": "hunter2!", "forced": true})
+	var metadata map[string]any
+	if err := json.Unmarshal([]byte(event.Metadata), &metadata); err != nil {
+		t.Fatalf("metadata is not JSON: %v", err)
+	}
+	if metadata["password"] != redacted || metadata["forced"] != true {
+		t.Fatalf("unexpected metadata: %v", metadata)
+	}
+	if empty := NewEvent(models.AuditAccountRestored, 7, models.AuditActorUser, 7, nil); empty.Metadata != "{}" {
+		t.Fatalf("expected empty metadata to be {}, got %q", empty.Metadata)
+	}
+}
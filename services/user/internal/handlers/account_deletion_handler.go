@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountRestoreWindow is how long a deleted account can be restored before
+// the purge worker removes it for good
+const AccountRestoreWindow = 14 * 24 * time.Hour
+
+const errAccountScheduledForDeletion = "account_scheduled_for_deletion"
+
+type accountDeletionResponse struct {
+	DeletedAt time.Time `json:"deletedAt"`
+	RestoreBy time.Time `json:"restoreBy"`
+}
+
+type scheduledForDeletionResponse struct {
+	Error     string    `json:"error"`
+	RestoreBy time.Time `json:"restoreBy"`
+}
+
+type restoreAccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// DeleteMeHandler soft deletes the current user. The account can no longer
+// sign in and is purged after AccountRestoreWindow unless it is restored.
+func (h *UserHandler) DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
+		return
+	}
+	h.scheduleDeletion(w, r, userID)
+}
+
+func (h *UserHandler) scheduleDeletion(w http.ResponseWriter, r *http.Request, userID string) {
+	user, err := h.Repo.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrUserNotFound) {
+			utils.JSONError(w, http.StatusNotFound, "User not found")
+		} else {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to delete user")
+		}
+		return
+	}
+	purgeAt := time.Now().Add(AccountRestoreWindow)
+	event := userAuditEvent(r, models.AuditAccountDeletionRequested, user.ID, map[string]any{"restoreBy": purgeAt.UTC()})
+	job, err := h.Deletions.ScheduleDeletion(user, purgeAt, event)
+	if err != nil {
+		if errors.Is(err, repositories.ErrUserNotFound) {
+			utils.JSONError(w, http.StatusNotFound, "User not found")
+		} else {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to delete user")
+		}
+		return
+	}
+	utils.JSON(w, http.StatusOK, accountDeletionResponse{DeletedAt: job.CreatedAt, RestoreBy: job.PurgeAt})
+}
+
+// RestoreAccountHandler undoes a deletion within the restore window. The
+// caller proves ownership with the account's username and password.
+func (h *AuthHandler) RestoreAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req restoreAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		utils.JSONError(w, http.StatusBadRequest, "Invalid payload")
+		return
+	}
+	if h.Deletions == nil {
+		utils.JSONError(w, http.StatusNotFound, "No account pending deletion")
+		return
+	}
+	user, _, err := h.Deletions.GetPendingDeletion(req.Username)
+	if err != nil && !errors.Is(err, repositories.ErrNoPendingDeletion) {
+		utils.JSONError(w, http.StatusInternalServerError, "Failed to restore account")
+		return
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		// do not reveal which accounts are pending deletion
+		utils.JSONError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+	event := userAuditEvent(r, models.AuditAccountRestored, user.ID, nil)
+	if err := h.Deletions.Restore(user.ID, time.Now(), event); err != nil {
+		if errors.Is(err, repositories.ErrNoPendingDeletion) {
+			utils.JSONError(w, http.StatusGone, "Restore window has passed")
+		} else {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to restore account")
+		}
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// pendingDeletion reports the purge job when username belongs to an account
+// pending deletion and password is correct, so login can explain the refusal
+func (h *AuthHandler) pendingDeletion(username, password string) *models.AccountPurgeJob {
+	if h.Deletions == nil {
+		return nil
+	}
+	user, job, err := h.Deletions.GetPendingDeletion(username)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil
+	}
+	return job
+}
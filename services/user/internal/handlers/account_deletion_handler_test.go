@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func newAccountDeletionHandlers(t *testing.T) (*UserHandler, *AuthHandler, *gorm.DB, *models.User) {
+	t.Helper()
+	db := testhelpers.SetupTestDB(t)
+	userRepo := &repositories.UserRepository{DB: db}
+	tokenRepo := &repositories.TokenRepository{DB: db}
+	deletions := &repositories.AccountDeletionRepository{DB: db}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret!12"), bcrypt.MinCost)
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: string(hash), Verified: true}
+	if err := userRepo.CreateUser(user); err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	if err := tokenRepo.Create(&models.Token{Token: "pending", Purpose: models.TokenPurposeEmailChange, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("seed token: %v", err)
+	}
+
+	userHandler := &UserHandler{Repo: userRepo, JWTSecret: "test-secret", Tokens: tokenRepo, Deletions: deletions}
+	authHandler := &AuthHandler{UserRepo: userRepo, TokenRepo: tokenRepo, JWTSecret: "test-secret", Deletions: deletions}
+	return userHandler, authHandler, db, user
+}
+
+func deleteMe(t *testing.T, h *UserHandler, user *models.User) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/me", nil)
+	token := makeToken(t, h.JWTSecret, jwt.MapClaims{"sub": fmt.Sprintf("%d", user.ID), "exp": time.Now().Add(time.Hour).Unix()})
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.DeleteMeHandler(rec, req)
+	return rec
+}
+
+func postJSON(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestAccountDeletion_DeleteLoginRestore(t *testing.T) {
+	userHandler, authHandler, db, user := newAccountDeletionHandlers(t)
+	credentials := `{"username":"alice","password":"secret!12"}`
+
+	rec := deleteMe(t, userHandler, user)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeResponse(t, rec)
+	restoreBy, err := time.Parse(time.RFC3339, body["restoreBy"].(string))
+	if err != nil {
+		t.Fatalf("restoreBy: %v", err)
+	}
+	if d := time.Until(restoreBy); d < AccountRestoreWindow-time.Minute || d > AccountRestoreWindow {
+		t.Fatalf("expected restoreBy about 14 days out, got %v", d)
+	}
+
+	var tokens int64
+	db.Model(&models.Token{}).Where("user_id = ?", user.ID).Count(&tokens)
+	if tokens != 0 {
+		t.Fatalf("expected the user's tokens to be removed, found %d", tokens)
+	}
+	if _, err := userHandler.Repo.GetUserByID(fmt.Sprintf("%d", user.ID)); err != repositories.ErrUserNotFound {
+		t.Fatalf("expected deleted user to be hidden, got %v", err)
+	}
+
+	rec = postJSON(authHandler.LoginHandler, credentials)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("login: expected 403, got %d", rec.Code)
+	}
+	body = decodeResponse(t, rec)
+	if body["error"] != "account_scheduled_for_deletion" || body["restoreBy"] == nil {
+		t.Fatalf("unexpected login body: %v", body)
+	}
+
+	if rec := postJSON(authHandler.LoginHandler, `{"username":"alice","password":"wrong!123"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: expected 401, got %d", rec.Code)
+	}
+	if rec := postJSON(authHandler.RestoreAccountHandler, `{"username":"alice","password":"wrong!123"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("restore with wrong password: expected 401, got %d", rec.Code)
+	}
+
+	if rec := postJSON(authHandler.RestoreAccountHandler, credentials); rec.Code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var jobs int64
+	db.Model(&models.AccountPurgeJob{}).Count(&jobs)
+	if jobs != 0 {
+		t.Fatalf("expected the purge job to be cancelled, found %d", jobs)
+	}
+	if rec := postJSON(authHandler.LoginHandler, credentials); rec.Code != http.StatusOK {
+		t.Fatalf("login after restore: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestAccountDeletion_RevokesEarlierTokens(t *testing.T) {
+	userHandler, authHandler, _, user := newAccountDeletionHandlers(t)
+	token := makeToken(t, userHandler.JWTSecret, jwt.MapClaims{"sub": fmt.Sprintf("%d", user.ID), "exp": time.Now().Add(time.Hour).Unix()})
+	me := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		authHandler.MeHandler(rec, req)
+		return rec.Code
+	}
+	if code := me(); code != http.StatusOK {
+		t.Fatalf("expected the token to work before the deletion, got %d", code)
+	}
+
+	if rec := deleteMe(t, userHandler, user); rec.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", rec.Code)
+	}
+	if code := me(); code != http.StatusUnauthorized {
+		t.Fatalf("expected a token issued before the deletion to get 401, got %d", code)
+	}
+	if rec := deleteMe(t, userHandler, user); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("delete again: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestAccountDeletion_IdentifiersHeldUntilPurge(t *testing.T) {
+	userHandler, authHandler, _, user := newAccountDeletionHandlers(t)
+	if rec := deleteMe(t, userHandler, user); rec.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", rec.Code)
+	}
+
+	rec := postJSON(authHandler.RegisterHandler, `{"username":"ALICE","email":"new@example.com","password":"secret!12"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("register with held username: expected 409, got %d", rec.Code)
+	}
+	rec = postJSON(authHandler.RegisterHandler, `{"username":"bob","email":"alice@example.com","password":"secret!12"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("register with held email: expected 409, got %d", rec.Code)
+	}
+}
+
+func TestAccountDeletion_RestoreAfterWindow(t *testing.T) {
+	userHandler, authHandler, db, user := newAccountDeletionHandlers(t)
+	if rec := deleteMe(t, userHandler, user); rec.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", rec.Code)
+	}
+	db.Model(&models.AccountPurgeJob{}).Where("user_id = ?", user.ID).Update("purge_at", time.Now().Add(-time.Minute))
+
+	rec := postJSON(authHandler.RestoreAccountHandler, `{"username":"alice","password":"secret!12"}`)
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxBulkProvisionRows caps a single bulk provisioning request
+const MaxBulkProvisionRows = 500
+
+// AdminHandler serves staff-only endpoints, authenticated with USER_ADMIN_TOKEN.
+type AdminHandler struct {
+	UserRepo     UserRepository
+	Provisioning ProvisioningRepository
+	AdminToken   string
+
+	// AuditTrail serves users' audit logs to staff
+	AuditTrail AuditRepository
+}
+
+func NewAdminHandler(userRepo UserRepository, provisioning ProvisioningRepository) *AdminHandler {
+	return &AdminHandler{UserRepo: userRepo, Provisioning: provisioning, AdminToken: os.Getenv("USER_ADMIN_TOKEN")}
+}
+
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	if h.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) == 1
+}
+
+// BulkProvisionHandler creates accounts from a roster given as a JSON array or
+// CSV (header: username,email[,displayName]). Every created account gets a
+// temporary password and must change it on first login. Query options:
+// verified (default true) and welcomeEmail (default false), which queues the
+// temporary password to each new user through the email outbox.
+func (h *AdminHandler) BulkProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		utils.JSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if key != "" {
+		if h.replayRun(w, key) {
+			return
+		}
+	}
+
+	verified, welcomeEmail := true, false
+	q := r.URL.Query()
+	if v := q.Get("verified"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.JSONError(w, http.StatusBadRequest, "verified must be true or false")
+			return
+		}
+		verified = b
+	}
+	if v := q.Get("welcomeEmail"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.JSONError(w, http.StatusBadRequest, "welcomeEmail must be true or false")
+			return
+		}
+		welcomeEmail = b
+	}
+
+	rows, err := decodeProvisionRows(r)
+	if err != nil {
+		utils.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		utils.JSONError(w, http.StatusBadRequest, "No rows provided")
+		return
+	}
+	if len(rows) > MaxBulkProvisionRows {
+		utils.JSONError(w, http.StatusRequestEntityTooLarge, "Batch exceeds "+strconv.Itoa(MaxBulkProvisionRows)+" rows")
+		return
+	}
+
+	report := models.BulkProvisionReport{Results: make([]models.BulkProvisionResult, 0, len(rows))}
+	var users []*models.User
+	var emails []*models.EmailOutbox
+	var events []*models.AuditEvent
+	created := make(map[int]*models.User) // result index -> user, to fill in ids after insert
+	seenUsernames := make(map[string]bool)
+	seenEmails := make(map[string]bool)
+
+	for i, row := range rows {
+		// Login lowercases usernames, so store them that way
+		row.Username = strings.ToLower(strings.TrimSpace(row.Username))
+		row.Email = strings.TrimSpace(row.Email)
+		row.DisplayName = strings.TrimSpace(row.DisplayName)
+		result := models.BulkProvisionResult{Row: i + 1, Username: row.Username, Email: row.Email}
+
+		status, reason, err := h.checkProvisionRow(row, seenUsernames, seenEmails)
+		if err != nil {
+			utils.JSONError(w, http.StatusInternalServerError, "Database error checking existing accounts")
+			return
+		}
+		result.Status, result.Reason = status, reason
+		switch status {
+		case models.ProvisionInvalid:
+			report.Invalid++
+			report.Results = append(report.Results, result)
+			continue
+		case models.ProvisionSkippedDuplicate:
+			report.Skipped++
+			report.Results = append(report.Results, result)
+			continue
+		}
+		seenUsernames[row.Username] = true
+		seenEmails[strings.ToLower(row.Email)] = true
+
+		tempPwd := generateCompliantPassword()
+		hash, err := generatePasswordHash([]byte(tempPwd), bcrypt.DefaultCost)
+		if err != nil {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		user := &models.User{
+			Username:           row.Username,
+			Email:              row.Email,
+			DisplayName:        row.DisplayName,
+			PasswordHash:       string(hash),
+			Verified:           verified,
+			MustChangePassword: true,
+		}
+		users = append(users, user)
+		events = append(events, adminAuditEvent(r, models.AuditAccountProvisioned, 0, map[string]any{"verified": verified, "welcomeEmail": welcomeEmail}))
+		created[len(report.Results)] = user
+		report.Created++
+		report.Results = append(report.Results, result)
+
+		if welcomeEmail {
+			emails = append(emails, welcomeEmailFor(user, tempPwd))
+		}
+	}
+	report.EmailsQueued = len(emails)
+
+	// Users get their ids on insert; the report (stored for replays) needs them
+	buildReport := func() string {
+		for i, user := range created {
+			report.Results[i].UserID = user.ID
+		}
+		b, _ := json.Marshal(report)
+		return string(b)
+	}
+	if err := h.Provisioning.Provision(users, emails, key, buildReport, events...); err != nil {
+		// A concurrent request with the same key may have won the race
+		if key != "" && h.replayRun(w, key) {
+			return
+		}
+		utils.JSONError(w, http.StatusInternalServerError, "Failed to provision accounts")
+		return
+	}
+	buildReport()
+
+	utils.JSON(w, http.StatusOK, report)
+}
+
+// replayRun writes the stored report for key, reporting whether one existed.
+func (h *AdminHandler) replayRun(w http.ResponseWriter, key string) bool {
+	run, err := h.Provisioning.GetRun(key)
+	if err != nil || run == nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(run.Report))
+	return true
+}
+
+// checkProvisionRow validates a row and checks it against existing accounts
+// and earlier rows of the same batch.
+func (h *AdminHandler) checkProvisionRow(row models.BulkProvisionRow, seenUsernames, seenEmails map[string]bool) (status, reason string, err error) {
+	switch {
+	case row.Username == "":
+		return models.ProvisionInvalid, "username is required", nil
+	case strings.ContainsAny(row.Username, " \t@"):
+		return models.ProvisionInvalid, "username must not contain spaces or @", nil
+	case row.Email == "":
+		return models.ProvisionInvalid, "email is required", nil
+	}
+	if addr, perr := mail.ParseAddress(row.Email); perr != nil || addr.Address != row.Email {
+		return models.ProvisionInvalid, "email is not a valid address", nil
+	}
+
+	if seenUsernames[row.Username] {
+		return models.ProvisionSkippedDuplicate, "username repeated in batch", nil
+	}
+	if seenEmails[strings.ToLower(row.Email)] {
+		return models.ProvisionSkippedDuplicate, "email repeated in batch", nil
+	}
+	if _, err := h.UserRepo.GetUserByUsername(row.Username); err == nil {
+		return models.ProvisionSkippedDuplicate, "username already registered", nil
+	} else if !errors.Is(err, repositories.ErrUserNotFound) {
+		return "", "", err
+	}
+	if _, err := h.UserRepo.GetUserByEmail(row.Email); err == nil {
+		return models.ProvisionSkippedDuplicate, "email already registered", nil
+	} else if !errors.Is(err, repositories.ErrUserNotFound) {
+		return "", "", err
+	}
+	return models.ProvisionCreated, "", nil
+}
+
+// decodeProvisionRows reads the roster as CSV when the body is text/csv and as
+// a JSON array otherwise.
+func decodeProvisionRows(r *http.Request) ([]models.BulkProvisionRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" {
+		var rows []models.BulkProvisionRow
+		if err := json.NewDecoder(r.Body).Decode(&rows); err != nil {
+			return nil, errors.New("Invalid payload: expected a JSON array of rows")
+		}
+		return rows, nil
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("Invalid CSV: missing header row")
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["username"]; !ok {
+		return nil, errors.New("Invalid CSV: header must include username and email")
+	}
+	if _, ok := cols["email"]; !ok {
+		return nil, errors.New("Invalid CSV: header must include username and email")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := cols[strings.ToLower(name)]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []models.BulkProvisionRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("Invalid CSV: " + err.Error())
+		}
+		rows = append(rows, models.BulkProvisionRow{
+			Username:    field(record, "username"),
+			Email:       field(record, "email"),
+			DisplayName: field(record, "displayName"),
+		})
+		if len(rows) > MaxBulkProvisionRows {
+			break // enough to reject the batch without reading the rest
+		}
+	}
+	return rows, nil
+}
+
+func welcomeEmailFor(user *models.User, tempPwd string) *models.EmailOutbox {
+	name := user.DisplayName
+	if name == "" {
+		name = user.Username
+	}
+	return &models.EmailOutbox{
+		To:      user.Email,
+		Subject: "Your PeerPrep account",
+		Body: "Hello " + name + ",\n\n" +
+			"An account has been created for you on PeerPrep.\n" +
+			"Username: " + user.Username + "\n" +
+			"Temporary password: " + tempPwd + "\n\n" +
+			"You will be asked to choose a new password the first time you log in: " + clientBaseURL() + "/login",
+	}
+}
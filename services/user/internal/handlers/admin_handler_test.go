@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const testAdminToken = "admin-secret"
+
+func newAdminHandlerWithDB(t *testing.T) (*AdminHandler, *repositories.UserRepository, *gorm.DB) {
+	t.Helper()
+	db := testhelpers.SetupTestDB(t)
+	userRepo := &repositories.UserRepository{DB: db}
+	return &AdminHandler{
+		UserRepo:     userRepo,
+		Provisioning: &repositories.ProvisioningRepository{DB: db},
+		AdminToken:   testAdminToken,
+	}, userRepo, db
+}
+
+func bulkRequest(target, contentType, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func decodeReport(t *testing.T, rec *httptest.ResponseRecorder) models.BulkProvisionReport {
+	t.Helper()
+	var report models.BulkProvisionReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v (%s)", err, rec.Body.String())
+	}
+	return report
+}
+
+func countRows(t *testing.T, db *gorm.DB, model any) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(model).Count(&n).Error; err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	return n
+}
+
+func TestAdminHandler_BulkProvision(t *testing.T) {
+	t.Run("rejects missing or wrong token", func(t *testing.T) {
+		handler, _, _ := newAdminHandlerWithDB(t)
+		for _, auth := range []string{"", "Bearer wrong"} {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/bulk", strings.NewReader(`[]`))
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.BulkProvisionHandler(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("auth %q: expected 401, got %d", auth, rec.Code)
+			}
+		}
+	})
+
+	t.Run("unset admin token rejects everything", func(t *testing.T) {
+		handler, _, _ := newAdminHandlerWithDB(t)
+		handler.AdminToken = ""
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/bulk", strings.NewReader(`[]`))
+		req.Header.Set("Authorization", "Bearer ")
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("mixed batch reports each row", func(t *testing.T) {
+		handler, userRepo, db := newAdminHandlerWithDB(t)
+		if err := userRepo.CreateUser(&models.User{Username: "taken", Email: "taken@example.com", PasswordHash: "hash"}); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+		body := `[
+			{"username":"Alice","email":"alice@example.com","displayName":"Alice Tan"},
+			{"username":"taken","email":"new@example.com"},
+			{"username":"bob","email":"taken@example.com"},
+			{"username":"alice","email":"other@example.com"},
+			{"username":"","email":"nouser@example.com"},
+			{"username":"carol","email":"not-an-email"}
+		]`
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk", "application/json", body))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		report := decodeReport(t, rec)
+		if report.Created != 1 || report.Skipped != 3 || report.Invalid != 2 || report.EmailsQueued != 0 {
+			t.Fatalf("unexpected totals: %+v", report)
+		}
+		want := []string{
+			models.ProvisionCreated,
+			models.ProvisionSkippedDuplicate,
+			models.ProvisionSkippedDuplicate,
+			models.ProvisionSkippedDuplicate,
+			models.ProvisionInvalid,
+			models.ProvisionInvalid,
+		}
+		for i, status := range want {
+			if report.Results[i].Status != status || report.Results[i].Row != i+1 {
+				t.Fatalf("row %d: expected %s, got %+v", i+1, status, report.Results[i])
+			}
+		}
+		if report.Results[0].UserID == 0 {
+			t.Fatalf("expected created row to carry the user id")
+		}
+
+		user, err := userRepo.GetUserByUsername("alice")
+		if err != nil {
+			t.Fatalf("expected alice to be created: %v", err)
+		}
+		if !user.MustChangePassword || !user.Verified || user.DisplayName != "Alice Tan" {
+			t.Fatalf("unexpected provisioned user: %+v", user)
+		}
+		if strings.Contains(rec.Body.String(), "password") {
+			t.Fatalf("report must not expose passwords: %s", rec.Body.String())
+		}
+		if n := countRows(t, db, &models.User{}); n != 2 {
+			t.Fatalf("expected 2 users, got %d", n)
+		}
+	})
+
+	t.Run("csv roster with welcome emails", func(t *testing.T) {
+		handler, userRepo, db := newAdminHandlerWithDB(t)
+		body := "email,username,displayName\n" +
+			"dan@example.com,dan,Dan\n" +
+			"erin@example.com,erin,\n"
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk?verified=false&welcomeEmail=true", "text/csv; charset=utf-8", body))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		report := decodeReport(t, rec)
+		if report.Created != 2 || report.EmailsQueued != 2 {
+			t.Fatalf("unexpected totals: %+v", report)
+		}
+		if n := countRows(t, db, &models.EmailOutbox{}); n != 2 {
+			t.Fatalf("expected 2 queued emails, got %d", n)
+		}
+		user, err := userRepo.GetUserByUsername("dan")
+		if err != nil || user.Verified || user.DisplayName != "Dan" {
+			t.Fatalf("unexpected provisioned user: %+v, %v", user, err)
+		}
+	})
+
+	t.Run("csv without required columns", func(t *testing.T) {
+		handler, _, _ := newAdminHandlerWithDB(t)
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk", "text/csv", "name,mail\na,b\n"))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		handler, _, _ := newAdminHandlerWithDB(t)
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk?verified=maybe", "application/json", `[]`))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		handler, _, _ := newAdminHandlerWithDB(t)
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk", "application/json", `[]`))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("batch over the cap is rejected", func(t *testing.T) {
+		handler, _, db := newAdminHandlerWithDB(t)
+		rows := make([]models.BulkProvisionRow, MaxBulkProvisionRows+1)
+		for i := range rows {
+			rows[i] = models.BulkProvisionRow{Username: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)}
+		}
+		body, _ := json.Marshal(rows)
+		rec := httptest.NewRecorder()
+		handler.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk", "application/json", string(body)))
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413, got %d", rec.Code)
+		}
+		if n := countRows(t, db, &models.User{}); n != 0 {
+			t.Fatalf("expected no users, got %d", n)
+		}
+	})
+
+	t.Run("idempotency key replays the first report", func(t *testing.T) {
+		handler, _, db := newAdminHandlerWithDB(t)
+		body := `[{"username":"frank","email":"frank@example.com"}]`
+
+		first := httptest.NewRecorder()
+		req := bulkRequest("/api/v1/admin/users/bulk?welcomeEmail=true", "application/json", body)
+		req.Header.Set("Idempotency-Key", "roster-1")
+		handler.BulkProvisionHandler(first, req)
+		if first.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", first.Code, first.Body.String())
+		}
+
+		second := httptest.NewRecorder()
+		req = bulkRequest("/api/v1/admin/users/bulk?welcomeEmail=true", "application/json", body)
+		req.Header.Set("Idempotency-Key", "roster-1")
+		handler.BulkProvisionHandler(second, req)
+
+		if second.Code != http.StatusOK || second.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("expected replay, got %d %v", second.Code, second.Header())
+		}
+		if decodeReport(t, second).Created != 1 || decodeReport(t, second).Results[0].UserID != decodeReport(t, first).Results[0].UserID {
+			t.Fatalf("replay should return the original report: %s", second.Body.String())
+		}
+		if n := countRows(t, db, &models.User{}); n != 1 {
+			t.Fatalf("expected 1 user, got %d", n)
+		}
+		if n := countRows(t, db, &models.EmailOutbox{}); n != 1 {
+			t.Fatalf("expected 1 queued email, got %d", n)
+		}
+
+		// Without the key the same roster is reported as duplicates
+		third := httptest.NewRecorder()
+		handler.BulkProvisionHandler(third, bulkRequest("/api/v1/admin/users/bulk", "application/json", body))
+		if report := decodeReport(t, third); report.Created != 0 || report.Skipped != 1 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+	})
+}
+
+func TestProvisionedAccount_ForcedPasswordChange(t *testing.T) {
+	admin, userRepo, db := newAdminHandlerWithDB(t)
+	rec := httptest.NewRecorder()
+	admin.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk?welcomeEmail=true", "application/json",
+		`[{"username":"grace","email":"grace@example.com"}]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("provision failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// The temporary password only ever leaves the service in the welcome email
+	var email models.EmailOutbox
+	if err := db.First(&email).Error; err != nil {
+		t.Fatalf("expected queued email: %v", err)
+	}
+	_, after, ok := strings.Cut(email.Body, "Temporary password: ")
+	if !ok {
+		t.Fatalf("welcome email missing password: %s", email.Body)
+	}
+	tempPwd, _, _ := strings.Cut(after, "\n")
+
+	auth := &AuthHandler{UserRepo: userRepo, JWTSecret: "test-secret"}
+	login := func(password string) authResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		auth.LoginHandler(rec, httptest.NewRequest(http.MethodPost, "/login",
+			strings.NewReader(fmt.Sprintf(`{"username":"grace","password":%q}`, password))))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login failed: %d %s", rec.Code, rec.Body.String())
+		}
+		var resp authResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if resp := login(tempPwd); resp.State != loginStatePasswordChangeRequired || resp.Token == "" {
+		t.Fatalf("expected password change state, got %+v", resp)
+	}
+
+	user, _ := userRepo.GetUserByUsername("grace")
+	id := fmt.Sprintf("%d", user.ID)
+	users := &UserHandler{Repo: userRepo, JWTSecret: "test-secret"}
+	token := makeToken(t, users.JWTSecret, jwt.MapClaims{"sub": id, "exp": time.Now().Add(time.Hour).Unix()})
+	req := requestWithUserID(http.MethodPatch, "/users/"+id+"/password", id,
+		strings.NewReader(`{"newPassword":"N3w-password","confirmPassword":"N3w-password"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	users.ChangePasswordHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("change password failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if resp := login("N3w-password"); resp.State != "" {
+		t.Fatalf("expected no state after password change, got %+v", resp)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"peerprep/user/internal/audit"
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultAuditPageSize = 20
+	maxAuditPageSize     = 100
+)
+
+// userAuditEvent is an event for an action the account holder took
+func userAuditEvent(r *http.Request, eventType models.AuditEventType, userID uint, metadata map[string]any) *models.AuditEvent {
+	return requestAuditEvent(r, eventType, userID, models.AuditActorUser, userID, metadata)
+}
+
+// adminAuditEvent is an event for an action staff took on the account
+func adminAuditEvent(r *http.Request, eventType models.AuditEventType, userID uint, metadata map[string]any) *models.AuditEvent {
+	return requestAuditEvent(r, eventType, userID, models.AuditActorAdmin, 0, metadata)
+}
+
+func requestAuditEvent(r *http.Request, eventType models.AuditEventType, userID uint, actor string, actorID uint, metadata map[string]any) *models.AuditEvent {
+	event := audit.NewEvent(eventType, userID, actor, actorID, metadata)
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	return event
+}
+
+// parseUserID parses a user id from a path or token subject
+func parseUserID(s string) (uint, bool) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	return uint(id), err == nil && id > 0
+}
+
+// MyAuditHandler returns a page of the current user's audit trail
+func (h *UserHandler) MyAuditHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
+		return
+	}
+	userID, ok := parseUserID(sub)
+	if !ok {
+		utils.JSONError(w, http.StatusUnauthorized, "Invalid token subject")
+		return
+	}
+	serveAuditPage(w, r, h.AuditTrail, userID)
+}
+
+// UserAuditHandler returns a page of any user's audit trail, including the
+// retained events of an account pending deletion
+func (h *AdminHandler) UserAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		utils.JSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, ok := parseUserID(chi.URLParam(r, "id"))
+	if !ok {
+		utils.JSONError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	serveAuditPage(w, r, h.AuditTrail, userID)
+}
+
+// serveAuditPage lists userID's events filtered by the query: type (comma
+// separated or repeated), from and to (RFC 3339, to exclusive), page and
+// pageSize.
+func serveAuditPage(w http.ResponseWriter, r *http.Request, repo AuditRepository, userID uint) {
+	if repo == nil {
+		utils.JSONError(w, http.StatusNotFound, "Audit log is not enabled")
+		return
+	}
+	filter, msg := parseAuditFilter(r)
+	if msg != "" {
+		utils.JSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+	filter.UserID = userID
+	events, total, err := repo.List(filter)
+	if err != nil {
+		utils.JSONError(w, http.StatusInternalServerError, "Failed to load audit log")
+		return
+	}
+	utils.JSON(w, http.StatusOK, models.AuditPage{Items: events, Page: filter.Page, PageSize: filter.PageSize, Total: total})
+}
+
+func parseAuditFilter(r *http.Request) (repositories.AuditFilter, string) {
+	q := r.URL.Query()
+	filter := repositories.AuditFilter{Page: 1, PageSize: defaultAuditPageSize}
+	for _, raw := range q["type"] {
+		for _, t := range strings.Split(raw, ",") {
+			eventType := models.AuditEventType(strings.TrimSpace(t))
+			if eventType == "" {
+				continue
+			}
+			if !eventType.Valid() {
+				return filter, "Unknown event type: " + string(eventType)
+			}
+			filter.Types = append(filter.Types, eventType)
+		}
+	}
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, name + " must be an RFC 3339 time"
+			}
+			*dst = t
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return filter, "page must be a positive integer"
+		}
+		filter.Page = page
+	}
+	if v := q.Get("pageSize"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 || size > maxAuditPageSize {
+			return filter, "pageSize must be between 1 and " + strconv.Itoa(maxAuditPageSize)
+		}
+		filter.PageSize = size
+	}
+	return filter, ""
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type auditFixture struct {
+	db    *gorm.DB
+	user  *models.User
+	users *UserHandler
+	auth  *AuthHandler
+	admin *AdminHandler
+	devs  *DeviceHandler
+}
+
+func newAuditFixture(t *testing.T) *auditFixture {
+	t.Helper()
+	db := testhelpers.SetupTestDB(t)
+	userRepo := &repositories.UserRepository{DB: db}
+	tokenRepo := &repositories.TokenRepository{DB: db}
+	deletions := &repositories.AccountDeletionRepository{DB: db}
+	trail := &repositories.AuditRepository{DB: db}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret!12"), bcrypt.MinCost)
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: string(hash), Verified: true}
+	if err := userRepo.CreateUser(user); err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	return &auditFixture{
+		db:    db,
+		user:  user,
+		users: &UserHandler{Repo: userRepo, JWTSecret: "test-secret", Tokens: tokenRepo, Deletions: deletions, AuditTrail: trail},
+		auth:  &AuthHandler{UserRepo: userRepo, TokenRepo: tokenRepo, JWTSecret: "test-secret", Deletions: deletions},
+		admin: &AdminHandler{UserRepo: userRepo, Provisioning: &repositories.ProvisioningRepository{DB: db}, AdminToken: testAdminToken, AuditTrail: trail},
+		devs:  &DeviceHandler{Repo: &repositories.DeviceRepository{DB: db}, Users: userRepo, JWTSecret: "test-secret"},
+	}
+}
+
+// as sends a request authenticated as the fixture's user from a fixed client
+func (f *auditFixture) as(t *testing.T, method, target, body string, params map[string]string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	token := makeToken(t, "test-secret", jwt.MapClaims{"sub": fmt.Sprintf("%d", f.user.ID), "exp": time.Now().Add(time.Hour).Unix()})
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", firefoxLinux)
+	req.RemoteAddr = "203.0.113.7:40000"
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func (f *auditFixture) events(t *testing.T, userID uint) []models.AuditEvent {
+	t.Helper()
+	var events []models.AuditEvent
+	if err := f.db.Where("user_id = ?", userID).Order("id").Find(&events).Error; err != nil {
+		t.Fatalf("load audit events: %v", err)
+	}
+	return events
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestAudit_SensitiveActionsAreRecorded(t *testing.T) {
+	origSend := sendEmailAsync
+	sendEmailAsync = func(string, string, string) error { return nil }
+	defer func() { sendEmailAsync = origSend }()
+
+	f := newAuditFixture(t)
+	id := fmt.Sprintf("%d", f.user.ID)
+	device := &models.KnownDevice{UserID: f.user.ID, Fingerprint: "fp", Label: "Firefox on Linux", IPPrefix: "203.0.113", FirstSeen: time.Now(), LastSeen: time.Now()}
+	f.db.Create(device)
+
+	steps := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+	}{
+		{"change password", f.users.ChangePasswordHandler, f.as(t, http.MethodPatch, "/", `{"newPassword":"n3w!secret","confirmPassword":"n3w!secret"}`, map[string]string{"id": id})},
+		{"change username", f.users.ChangeUsernameHandler, f.as(t, http.MethodPatch, "/", `{"username":"alice2"}`, map[string]string{"id": id})},
+		{"request email change", f.users.InitiateEmailChangeHandler, f.as(t, http.MethodPost, "/", `{"email":"alice@new.example.com"}`, map[string]string{"id": id})},
+		{"forget device", f.devs.ForgetDeviceHandler, f.as(t, http.MethodDelete, "/", "", map[string]string{"deviceId": fmt.Sprintf("%d", device.ID)})},
+	}
+	for _, step := range steps {
+		if rec := serve(step.handler, step.req); rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", step.name, rec.Code, rec.Body.String())
+		}
+	}
+
+	var token models.Token
+	f.db.Where("user_id = ? AND purpose = ?", f.user.ID, models.TokenPurposeEmailChange).First(&token)
+	confirm := httptest.NewRequest(http.MethodGet, "/?token="+token.Token, nil)
+	if rec := serve(f.auth.ConfirmEmailChangeHandler, confirm); rec.Code != http.StatusSeeOther {
+		t.Fatalf("confirm email change: expected 303, got %d", rec.Code)
+	}
+	if rec := serve(f.users.DeleteMeHandler, f.as(t, http.MethodDelete, "/", "", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("delete me: expected 200, got %d", rec.Code)
+	}
+	restore := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"alice2","password":"n3w!secret"}`))
+	if rec := serve(f.auth.RestoreAccountHandler, restore); rec.Code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	events := f.events(t, f.user.ID)
+	want := []models.AuditEventType{
+		models.AuditPasswordChanged, models.AuditUsernameChanged, models.AuditEmailChangeRequested,
+		models.AuditDeviceForgotten, models.AuditEmailChanged, models.AuditAccountDeletionRequested,
+		models.AuditAccountRestored,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, event := range events {
+		if event.Type != want[i] || event.Actor != models.AuditActorUser || event.ActorID != f.user.ID {
+			t.Fatalf("event %d: unexpected %+v", i, event)
+		}
+	}
+	if events[0].IP != "203.0.113.7" || events[0].UserAgent != firefoxLinux {
+		t.Fatalf("expected the client to be recorded, got %+v", events[0])
+	}
+	var changed map[string]string
+	if err := json.Unmarshal([]byte(events[4].Metadata), &changed); err != nil || changed["from"] != "alice@example.com" || changed["to"] != "alice@new.example.com" {
+		t.Fatalf("unexpected email change metadata: %s", events[4].Metadata)
+	}
+	for _, event := range events {
+		if strings.Contains(string(event.Metadata), "n3w!secret") || strings.Contains(string(event.Metadata), token.Token) {
+			t.Fatalf("secret leaked into %s metadata: %s", event.Type, event.Metadata)
+		}
+	}
+}
+
+func TestAudit_PasswordResetAndProvisioning(t *testing.T) {
+	origSend := sendEmailAsync
+	sendEmailAsync = func(string, string, string) error { return nil }
+	defer func() { sendEmailAsync = origSend }()
+
+	f := newAuditFixture(t)
+	f.auth.ForgotPasswordHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"alice@example.com"}`)))
+	events := f.events(t, f.user.ID)
+	if len(events) != 1 || events[0].Type != models.AuditPasswordReset || events[0].Actor != models.AuditActorSystem || events[0].ActorID != 0 {
+		t.Fatalf("unexpected reset events: %+v", events)
+	}
+
+	rec := httptest.NewRecorder()
+	f.admin.BulkProvisionHandler(rec, bulkRequest("/api/v1/admin/users/bulk", "application/json", `[{"username":"bob","email":"bob@example.com"},{"username":"carol","email":"carol@example.com"}]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("provision: expected 200, got %d", rec.Code)
+	}
+	for _, name := range []string{"bob", "carol"} {
+		var user models.User
+		f.db.Where("username = ?", name).First(&user)
+		events := f.events(t, user.ID)
+		if len(events) != 1 || events[0].Type != models.AuditAccountProvisioned || events[0].Actor != models.AuditActorAdmin || events[0].ActorID != 0 {
+			t.Fatalf("%s: unexpected provisioning events %+v", name, events)
+		}
+	}
+}
+
+func TestAudit_FailedActionLeavesNoEvent(t *testing.T) {
+	f := newAuditFixture(t)
+	other := &models.User{Username: "bob", Email: "bob@example.com", PasswordHash: "hash"}
+	f.db.Create(other)
+
+	// the unique email constraint fails the update after the event was queued
+	event := userAuditEvent(httptest.NewRequest(http.MethodPut, "/", nil), models.AuditEmailChanged, f.user.ID, nil)
+	if _, err := f.users.Repo.UpdateUser(fmt.Sprintf("%d", f.user.ID), &models.User{Email: other.Email}, event); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	// an invalid event rolls back the action it was recorded with
+	bogus := userAuditEvent(httptest.NewRequest(http.MethodPut, "/", nil), "role_granted", f.user.ID, nil)
+	if _, err := f.users.Repo.UpdateUser(fmt.Sprintf("%d", f.user.ID), &models.User{Username: "mallory"}, bogus); err == nil {
+		t.Fatalf("expected an unknown event type to fail the update")
+	}
+	if events := f.events(t, f.user.ID); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+	user, _ := f.users.Repo.GetUserByID(fmt.Sprintf("%d", f.user.ID))
+	if user.Username != "alice" {
+		t.Fatalf("expected the action to be rolled back, username is %q", user.Username)
+	}
+
+	if rec := serve(f.devs.ForgetDeviceHandler, f.as(t, http.MethodDelete, "/", "", map[string]string{"deviceId": "99"})); rec.Code != http.StatusNotFound {
+		t.Fatalf("forget unknown device: expected 404, got %d", rec.Code)
+	}
+	if events := f.events(t, f.user.ID); len(events) != 0 {
+		t.Fatalf("expected no event for a device that was not forgotten, got %+v", events)
+	}
+}
+
+func TestAudit_EventsAreImmutable(t *testing.T) {
+	f := newAuditFixture(t)
+	event := userAuditEvent(httptest.NewRequest(http.MethodGet, "/", nil), models.AuditPasswordChanged, f.user.ID, nil)
+	if _, err := f.users.Repo.UpdateUser(fmt.Sprintf("%d", f.user.ID), &models.User{}, event); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := f.db.Model(event).Update("type", models.AuditEmailChanged).Error; err != models.ErrAuditEventImmutable {
+		t.Fatalf("expected updates to be refused, got %v", err)
+	}
+	if err := f.db.Delete(event).Error; err != models.ErrAuditEventImmutable {
+		t.Fatalf("expected deletes to be refused, got %v", err)
+	}
+	if events := f.events(t, f.user.ID); len(events) != 1 || events[0].Type != models.AuditPasswordChanged {
+		t.Fatalf("expected the event untouched, got %+v", events)
+	}
+}
+
+func TestAudit_ListEndpoints(t *testing.T) {
+	f := newAuditFixture(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		eventType := models.AuditPasswordChanged
+		if i%5 == 0 {
+			eventType = models.AuditUsernameChanged
+		}
+		f.db.Create(&models.AuditEvent{UserID: f.user.ID, Actor: models.AuditActorUser, ActorID: f.user.ID, Type: eventType, Metadata: "{}", CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+	}
+	f.db.Create(&models.AuditEvent{UserID: f.user.ID + 1, Actor: models.AuditActorUser, Type: models.AuditPasswordChanged, Metadata: "{}", CreatedAt: base})
+
+	page := func(rec *httptest.ResponseRecorder) models.AuditPage {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var p models.AuditPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+		return p
+	}
+
+	p := page(serve(f.users.MyAuditHandler, f.as(t, http.MethodGet, "/?page=2&pageSize=10", "", nil)))
+	if p.Total != 25 || p.Page != 2 || len(p.Items) != 10 || !p.Items[0].CreatedAt.Equal(base.Add(14*time.Hour)) {
+		t.Fatalf("unexpected second page: total=%d page=%d items=%d first=%v", p.Total, p.Page, len(p.Items), p.Items[0].CreatedAt)
+	}
+	p = page(serve(f.users.MyAuditHandler, f.as(t, http.MethodGet, "/?page=3&pageSize=10", "", nil)))
+	if len(p.Items) != 5 {
+		t.Fatalf("expected 5 items on the last page, got %d", len(p.Items))
+	}
+
+	from, to := base.Add(5*time.Hour).Format(time.RFC3339), base.Add(20*time.Hour).Format(time.RFC3339)
+	p = page(serve(f.users.MyAuditHandler, f.as(t, http.MethodGet, "/?type=username_changed&from="+from+"&to="+to, "", nil)))
+	if p.Total != 3 || p.PageSize != defaultAuditPageSize {
+		t.Fatalf("expected 3 username changes in range, got %d", p.Total)
+	}
+	for _, item := range p.Items {
+		if item.Type != models.AuditUsernameChanged {
+			t.Fatalf("unexpected type %s", item.Type)
+		}
+	}
+
+	for _, query := range []string{"?type=role_granted", "?from=yesterday", "?page=0", "?pageSize=1000"} {
+		if rec := serve(f.users.MyAuditHandler, f.as(t, http.MethodGet, "/"+query, "", nil)); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if rec := serve(f.users.MyAuditHandler, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+
+	adminReq := func(token, id string) *http.Request {
+		req := f.as(t, http.MethodGet, "/?pageSize=5", "", map[string]string{"id": id})
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	if rec := serve(f.admin.UserAuditHandler, adminReq("wrong", fmt.Sprintf("%d", f.user.ID))); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a user token, got %d", rec.Code)
+	}
+	p = page(serve(f.admin.UserAuditHandler, adminReq(testAdminToken, fmt.Sprintf("%d", f.user.ID))))
+	if p.Total != 25 || len(p.Items) != 5 {
+		t.Fatalf("unexpected admin page: total=%d items=%d", p.Total, len(p.Items))
+	}
+	if rec := serve(f.admin.UserAuditHandler, adminReq(testAdminToken, "abc")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad id, got %d", rec.Code)
+	}
+}
@@ -29,6 +29,14 @@ type AuthHandler struct {
 	UserRepo  UserRepository
 	JWTSecret string
 	TokenRepo TokenRepository
+
+	// Devices enables known device tracking and new sign-in emails when set
+	Devices       DeviceRepository
+	Locations     LocationResolver
+	notifyLimiter *emailRateLimiter
+
+	// Deletions lets login recognise accounts pending deletion and enables restoring them
+	Deletions AccountDeletionRepository
 }
 
 func NewAuthHandler(userRepo UserRepository, tokenRepo TokenRepository) *AuthHandler {
@@ -36,7 +44,13 @@ func NewAuthHandler(userRepo UserRepository, tokenRepo TokenRepository) *AuthHan
 	if secret == "" {
 		secret = "dev"
 	}
-	return &AuthHandler{UserRepo: userRepo, JWTSecret: secret, TokenRepo: tokenRepo}
+	return &AuthHandler{
+		UserRepo:      userRepo,
+		JWTSecret:     secret,
+		TokenRepo:     tokenRepo,
+		Locations:     NoopLocationResolver{},
+		notifyLimiter: newEmailRateLimiter(newDeviceEmailsPerWindow, newDeviceEmailWindow),
+	}
 }
 
 type registerRequest struct {
@@ -52,8 +66,13 @@ type loginRequest struct {
 
 type authResponse struct {
 	Token string `json:"token"`
+	// State is "password_change_required" for provisioned accounts that still
+	// use their temporary password
+	State string `json:"state,omitempty"`
 }
 
+const loginStatePasswordChangeRequired = "password_change_required"
+
 type forgotRequest struct {
 	Email string `json:"email"`
 }
@@ -80,13 +99,15 @@ func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			utils.JSONError(w, http.StatusInternalServerError, "Database error checking username")
 			return
 		} else if exists {
-			if existing, err := h.UserRepo.GetUserByUsername(req.Username); err == nil && existing != nil {
-				if deleted, _ := repositories.CleanupUnverifiedUserIfExpired(concreteUserRepo, concreteTokenRepo, existing); deleted {
-					// proceed after cleanup
-				} else {
-					utils.JSONError(w, http.StatusConflict, "Username taken")
-					return
-				}
+			existing, err := h.UserRepo.GetUserByUsername(req.Username)
+			if err != nil || existing == nil {
+				// held by an account pending deletion until it is purged
+				utils.JSONError(w, http.StatusConflict, "Username taken")
+				return
+			}
+			if deleted, _ := repositories.CleanupUnverifiedUserIfExpired(concreteUserRepo, concreteTokenRepo, existing); !deleted {
+				utils.JSONError(w, http.StatusConflict, "Username taken")
+				return
 			}
 		}
 	} else {
@@ -106,13 +127,15 @@ func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			utils.JSONError(w, http.StatusInternalServerError, "Database error checking email")
 			return
 		} else if exists {
-			if existing, err := h.UserRepo.GetUserByEmail(req.Email); err == nil && existing != nil {
-				if deleted, _ := repositories.CleanupUnverifiedUserIfExpired(concreteUserRepo, concreteTokenRepo, existing); deleted {
-					// proceed after cleanup
-				} else {
-					utils.JSONError(w, http.StatusConflict, "Email taken")
-					return
-				}
+			existing, err := h.UserRepo.GetUserByEmail(req.Email)
+			if err != nil || existing == nil {
+				// held by an account pending deletion until it is purged
+				utils.JSONError(w, http.StatusConflict, "Email taken")
+				return
+			}
+			if deleted, _ := repositories.CleanupUnverifiedUserIfExpired(concreteUserRepo, concreteTokenRepo, existing); !deleted {
+				utils.JSONError(w, http.StatusConflict, "Email taken")
+				return
 			}
 		}
 	} else {
@@ -196,6 +219,15 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	username := strings.ToLower(req.Username)
 	user, err := h.UserRepo.GetUserByUsername(username)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		if err == nil {
+			h.recordFailedLogin(user, r)
+		} else if job := h.pendingDeletion(username, req.Password); job != nil {
+			utils.JSON(w, http.StatusForbidden, scheduledForDeletionResponse{
+				Error:     errAccountScheduledForDeletion,
+				RestoreBy: job.PurgeAt,
+			})
+			return
+		}
 		utils.JSONError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
@@ -234,8 +266,13 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to sign token")
 		return
 	}
+	h.recordLogin(user, r)
 
-	utils.JSON(w, http.StatusOK, authResponse{Token: signed})
+	resp := authResponse{Token: signed}
+	if user.MustChangePassword {
+		resp.State = loginStatePasswordChangeRequired
+	}
+	utils.JSON(w, http.StatusOK, resp)
 }
 
 // ForgotPasswordHandler sends the username and a newly generated temporary password
@@ -269,7 +306,8 @@ func (h *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		return
 	}
-	_, err = h.UserRepo.UpdateUser(strconv.FormatUint(uint64(user.ID), 10), &models.User{PasswordHash: string(hash)})
+	event := requestAuditEvent(r, models.AuditPasswordReset, user.ID, models.AuditActorSystem, 0, map[string]any{"method": "email"})
+	_, err = h.UserRepo.UpdateUser(strconv.FormatUint(uint64(user.ID), 10), &models.User{PasswordHash: string(hash)}, event)
 	if err != nil {
 		return
 	}
@@ -339,15 +377,8 @@ var sendEmailAsync = func(to, subject, body string) error {
 }
 
 func (h *AuthHandler) MeHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
-
-	uid, err := utils.GetUserIDFromClaims(claims)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, "Invalid token subject")
+	uid, ok := authenticatedUser(w, r, h.JWTSecret, h.UserRepo)
+	if !ok {
 		return
 	}
 
@@ -452,7 +483,8 @@ func (h *AuthHandler) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.R
 	// Apply new email
 	newEmail := *user.NewEmail
 	updates := &models.User{Email: newEmail, NewEmail: nil}
-	if _, err := h.UserRepo.UpdateUser(uid, updates); err != nil {
+	event := userAuditEvent(r, models.AuditEmailChanged, user.ID, map[string]any{"from": user.Email, "to": newEmail})
+	if _, err := h.UserRepo.UpdateUser(uid, updates, event); err != nil {
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to update email")
 		return
 	}
@@ -26,8 +26,13 @@ type mockUserRepo struct {
 	getUserByIDFn       func(string) (*models.User, error)
 	updateUserFn        func(string, *models.User) (*models.User, error)
 	deleteUserFn        func(string) error
+	clearMustChangeFn   func(string) error
 }
 
+// activeUser is a getUserByIDFn for handlers that only look the caller up to
+// check that their account still exists
+func activeUser(string) (*models.User, error) { return &models.User{}, nil }
+
 func (m *mockUserRepo) CreateUser(user *models.User) error {
 	if m.createUserFn == nil {
 		return nil
@@ -56,7 +61,7 @@ func (m *mockUserRepo) GetUserByID(id string) (*models.User, error) {
 	return m.getUserByIDFn(id)
 }
 
-func (m *mockUserRepo) UpdateUser(id string, updates *models.User) (*models.User, error) {
+func (m *mockUserRepo) UpdateUser(id string, updates *models.User, _ ...*models.AuditEvent) (*models.User, error) {
 	if m.updateUserFn == nil {
 		panic("unexpected call to UpdateUser")
 	}
@@ -70,6 +75,13 @@ func (m *mockUserRepo) DeleteUser(id string) error {
 	return m.deleteUserFn(id)
 }
 
+func (m *mockUserRepo) ClearMustChangePassword(id string) error {
+	if m.clearMustChangeFn == nil {
+		panic("unexpected call to ClearMustChangePassword")
+	}
+	return m.clearMustChangeFn(id)
+}
+
 type mockTokenRepo struct {
 	createTokenFn               func(*models.Token) error
 	getTokenByTokenFn           func(string) (*models.Token, error)
@@ -538,8 +550,8 @@ func TestAuthHandler_MeHandler(t *testing.T) {
 
 		handler.MeHandler(rec, req)
 
-		if rec.Code != http.StatusNotFound {
-			t.Fatalf("expected 404, got %d", rec.Code)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for a token of a missing account, got %d", rec.Code)
 		}
 	})
 
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// A login from a new device is suspicious when it follows more than
+	// suspiciousFailedLogins failed attempts within suspiciousWindow
+	suspiciousFailedLogins = 5
+	suspiciousWindow       = time.Hour
+
+	// New sign-in emails are capped per user so repeated logins from fresh
+	// browsers (e.g. while testing) do not flood the inbox
+	newDeviceEmailsPerWindow = 3
+	newDeviceEmailWindow     = time.Hour
+)
+
+// LocationResolver turns an IP address into an approximate, human readable
+// location. An empty result means the location is unknown.
+type LocationResolver interface {
+	Resolve(ip string) string
+}
+
+// NoopLocationResolver resolves nothing; it is used until a geo IP lookup is configured
+type NoopLocationResolver struct{}
+
+func (NoopLocationResolver) Resolve(string) string { return "" }
+
+// emailRateLimiter allows at most max emails per user within window
+type emailRateLimiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	now    func() time.Time
+	sent   map[uint][]time.Time
+}
+
+func newEmailRateLimiter(max int, window time.Duration) *emailRateLimiter {
+	return &emailRateLimiter{max: max, window: window, now: time.Now, sent: make(map[uint][]time.Time)}
+}
+
+// Allow reports whether another email may go to userID and, if so, counts it
+func (l *emailRateLimiter) Allow(userID uint) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	recent := l.sent[userID][:0]
+	for _, at := range l.sent[userID] {
+		if now.Sub(at) < l.window {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= l.max {
+		l.sent[userID] = recent
+		return false
+	}
+	l.sent[userID] = append(recent, now)
+	return true
+}
+
+// clientIP returns the request's IP; RealIP middleware has already applied
+// any forwarding headers to RemoteAddr.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// ipPrefix coarsens ip to its /24 (IPv4) or /48 (IPv6) network, so a device
+// keeps its identity when the address changes within the same network.
+func ipPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "unknown"
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// userAgentFamily reduces a user agent to browser and OS, e.g. "Firefox on Linux".
+// Order matters: Edge and Opera also claim to be Chrome, and Chrome claims Safari.
+func userAgentFamily(ua string) string {
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	os := "unknown OS"
+	switch {
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+	return browser + " on " + os
+}
+
+func deviceFingerprint(family, prefix string) string {
+	sum := sha256.Sum256([]byte(family + "|" + prefix))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordFailedLogin audits a wrong password for an existing account
+func (h *AuthHandler) recordFailedLogin(user *models.User, r *http.Request) {
+	if h.Devices == nil {
+		return
+	}
+	event := &models.AuthEvent{UserID: user.ID, Type: models.AuthEventLoginFailed, IP: clientIP(r), UserAgent: r.UserAgent()}
+	if err := h.Devices.RecordAuthEvent(event); err != nil {
+		log.Printf("[Auth] Failed to record failed login for user %d: %v", user.ID, err)
+	}
+}
+
+// recordLogin remembers the device of a successful login. An unseen device
+// queues a sign-in notification email; it is flagged suspicious when it follows
+// a burst of failed attempts. Errors are logged, never surfaced: the login
+// itself has already succeeded.
+func (h *AuthHandler) recordLogin(user *models.User, r *http.Request) {
+	if h.Devices == nil {
+		return
+	}
+	now := time.Now()
+	ip := clientIP(r)
+	family := userAgentFamily(r.UserAgent())
+	prefix := ipPrefix(ip)
+	fingerprint := deviceFingerprint(family, prefix)
+
+	device, err := h.Devices.GetDevice(user.ID, fingerprint)
+	if err != nil {
+		log.Printf("[Auth] Failed to look up device for user %d: %v", user.ID, err)
+		return
+	}
+	event := &models.AuthEvent{UserID: user.ID, Type: models.AuthEventLoginSuccess, IP: ip, UserAgent: r.UserAgent(), Fingerprint: fingerprint}
+	if device != nil {
+		device.LastSeen = now
+		if err := h.Devices.RecordLogin(device, event, nil); err != nil {
+			log.Printf("[Auth] Failed to record login for user %d: %v", user.ID, err)
+		}
+		return
+	}
+
+	failures, err := h.Devices.CountAuthEvents(user.ID, models.AuthEventLoginFailed, now.Add(-suspiciousWindow))
+	if err != nil {
+		log.Printf("[Auth] Failed to count failed logins for user %d: %v", user.ID, err)
+	}
+	event.NewDevice = true
+	event.Suspicious = failures > suspiciousFailedLogins
+
+	var location string
+	if h.Locations != nil {
+		location = h.Locations.Resolve(ip)
+	}
+	device = &models.KnownDevice{
+		UserID: user.ID, Fingerprint: fingerprint, Label: family, IPPrefix: prefix,
+		Location: location, FirstSeen: now, LastSeen: now,
+	}
+
+	var email *models.EmailOutbox
+	if h.notifyLimiter == nil || h.notifyLimiter.Allow(user.ID) {
+		email = newDeviceEmail(user, device, failures, event.Suspicious)
+	} else {
+		log.Printf("[Auth] New sign-in email for user %d suppressed by rate limit", user.ID)
+	}
+	if err := h.Devices.RecordLogin(device, event, email); err != nil {
+		log.Printf("[Auth] Failed to record new device for user %d: %v", user.ID, err)
+	}
+}
+
+func newDeviceEmail(user *models.User, device *models.KnownDevice, failures int64, suspicious bool) *models.EmailOutbox {
+	location := device.Location
+	if location == "" {
+		location = "Unknown location"
+	}
+	body := "Hello " + user.Username + ",\n\n" +
+		"Your PeerPrep account was just signed in to from a new device:\n" +
+		"Time: " + device.FirstSeen.UTC().Format(time.RFC1123) + "\n" +
+		"Device: " + device.Label + "\n" +
+		"Approximate location: " + location + " (" + device.IPPrefix + ")\n\n"
+	if suspicious {
+		body += "This sign-in followed " + strconv.FormatInt(failures, 10) + " failed attempts in the past hour.\n\n"
+	}
+	body += "If this was you, there is nothing to do. Otherwise, review your devices and change your password:\n" +
+		clientBaseURL() + "/account#devices"
+	return &models.EmailOutbox{To: user.Email, Subject: "New sign-in to your PeerPrep account", Body: body}
+}
+
+// DeviceHandler lets users review and forget the devices they signed in from.
+type DeviceHandler struct {
+	Repo      DeviceRepository
+	Users     UserRepository
+	JWTSecret string
+}
+
+func (h *DeviceHandler) authenticatedUserID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Users)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(sub, 10, 64)
+	if err != nil {
+		utils.JSONError(w, http.StatusUnauthorized, "Invalid token subject")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ListDevicesHandler returns the caller's known devices
+func (h *DeviceHandler) ListDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	devices, err := h.Repo.ListDevices(userID)
+	if err != nil {
+		utils.JSONError(w, http.StatusInternalServerError, "Failed to load devices")
+		return
+	}
+	utils.JSON(w, http.StatusOK, devices)
+}
+
+// ForgetDeviceHandler removes one of the caller's known devices
+func (h *DeviceHandler) ForgetDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	deviceID, err := strconv.ParseUint(chi.URLParam(r, "deviceId"), 10, 64)
+	if err != nil {
+		utils.JSONError(w, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+	event := userAuditEvent(r, models.AuditDeviceForgotten, userID, map[string]any{"deviceId": deviceID})
+	if err := h.Repo.DeleteDevice(userID, uint(deviceID), event); err != nil {
+		if err == repositories.ErrDeviceNotFound {
+			utils.JSONError(w, http.StatusNotFound, "Device not found")
+		} else {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to forget device")
+		}
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	firefoxLinux  = "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+	chromeWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
+)
+
+type fixedLocation string
+
+func (l fixedLocation) Resolve(string) string { return string(l) }
+
+func newDeviceAuthHandler(t *testing.T) (*AuthHandler, *models.User, *gorm.DB) {
+	t.Helper()
+	db := testhelpers.SetupTestDB(t)
+	userRepo := &repositories.UserRepository{DB: db}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("Abcdefg!"), bcrypt.MinCost)
+	user := &models.User{Username: "ada", Email: "ada@example.com", PasswordHash: string(hash), Verified: true}
+	if err := userRepo.CreateUser(user); err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	h := &AuthHandler{
+		UserRepo:      userRepo,
+		JWTSecret:     "test-secret",
+		Devices:       &repositories.DeviceRepository{DB: db},
+		Locations:     fixedLocation("Singapore"),
+		notifyLimiter: newEmailRateLimiter(newDeviceEmailsPerWindow, newDeviceEmailWindow),
+	}
+	return h, user, db
+}
+
+func loginFrom(t *testing.T, h *AuthHandler, password, ip, userAgent string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(fmt.Sprintf(`{"username":"ada","password":%q}`, password)))
+	req.RemoteAddr = ip + ":51234"
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	h.LoginHandler(rec, req)
+	return rec.Code
+}
+
+func outbox(t *testing.T, db *gorm.DB) []models.EmailOutbox {
+	t.Helper()
+	var emails []models.EmailOutbox
+	if err := db.Order("id").Find(&emails).Error; err != nil {
+		t.Fatalf("load outbox: %v", err)
+	}
+	return emails
+}
+
+func TestLogin_NewDeviceNotification(t *testing.T) {
+	h, user, db := newDeviceAuthHandler(t)
+
+	if code := loginFrom(t, h, "Abcdefg!", "203.0.113.7", firefoxLinux); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	emails := outbox(t, db)
+	if len(emails) != 1 {
+		t.Fatalf("expected one sign-in email, got %d", len(emails))
+	}
+	email := emails[0]
+	if email.To != user.Email || !strings.Contains(email.Subject, "New sign-in") {
+		t.Fatalf("unexpected email: %+v", email)
+	}
+	for _, want := range []string{"Firefox on Linux", "Singapore", "203.0.113.0/24", "Time: ", "/account#devices"} {
+		if !strings.Contains(email.Body, want) {
+			t.Fatalf("email body missing %q: %s", want, email.Body)
+		}
+	}
+
+	// Same browser elsewhere in the /24 is the same device: no email, lastSeen moves
+	var before models.KnownDevice
+	db.First(&before)
+	time.Sleep(10 * time.Millisecond)
+	if code := loginFrom(t, h, "Abcdefg!", "203.0.113.99", firefoxLinux); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	if got := len(outbox(t, db)); got != 1 {
+		t.Fatalf("known device should not notify, got %d emails", got)
+	}
+	var devices []models.KnownDevice
+	db.Find(&devices)
+	if len(devices) != 1 || !devices[0].LastSeen.After(before.LastSeen) || !devices[0].FirstSeen.Equal(before.FirstSeen) {
+		t.Fatalf("expected lastSeen update on the one device, got %+v", devices)
+	}
+
+	// A different browser is a new device
+	loginFrom(t, h, "Abcdefg!", "203.0.113.7", chromeWindows)
+	if got := len(outbox(t, db)); got != 2 {
+		t.Fatalf("expected a second email for a new browser, got %d", got)
+	}
+
+	var events []models.AuthEvent
+	db.Order("id").Find(&events)
+	if len(events) != 3 || !events[0].NewDevice || events[1].NewDevice || events[0].Suspicious {
+		t.Fatalf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestLogin_SuspiciousNewDevice(t *testing.T) {
+	h, user, db := newDeviceAuthHandler(t)
+
+	for i := 0; i <= suspiciousFailedLogins; i++ {
+		if code := loginFrom(t, h, "wrong-password", "198.51.100.4", chromeWindows); code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	}
+	// Failures older than the window do not count
+	db.Create(&models.AuthEvent{UserID: user.ID, Type: models.AuthEventLoginFailed, CreatedAt: time.Now().Add(-2 * suspiciousWindow)})
+
+	loginFrom(t, h, "Abcdefg!", "198.51.100.4", chromeWindows)
+
+	var event models.AuthEvent
+	if err := db.Where("type = ?", models.AuthEventLoginSuccess).First(&event).Error; err != nil {
+		t.Fatalf("expected login event: %v", err)
+	}
+	if !event.Suspicious || !event.NewDevice || event.IP != "198.51.100.4" {
+		t.Fatalf("expected suspicious new device login, got %+v", event)
+	}
+	emails := outbox(t, db)
+	if len(emails) != 1 || !strings.Contains(emails[0].Body, fmt.Sprintf("followed %d failed attempts", suspiciousFailedLogins+1)) {
+		t.Fatalf("expected email mentioning the failed attempts, got %+v", emails)
+	}
+
+	// The same failures do not flag a device the user already knows
+	db.Where("type = ?", models.AuthEventLoginSuccess).Delete(&models.AuthEvent{})
+	loginFrom(t, h, "Abcdefg!", "198.51.100.4", chromeWindows)
+	event = models.AuthEvent{}
+	db.Where("type = ?", models.AuthEventLoginSuccess).First(&event)
+	if event.ID == 0 || event.Suspicious || event.NewDevice {
+		t.Fatalf("known device login should not be suspicious: %+v", event)
+	}
+}
+
+func TestLogin_NewDeviceEmailsAreRateLimited(t *testing.T) {
+	h, _, db := newDeviceAuthHandler(t)
+
+	for i := 0; i < newDeviceEmailsPerWindow+2; i++ {
+		loginFrom(t, h, "Abcdefg!", fmt.Sprintf("192.0.%d.1", i), firefoxLinux)
+	}
+	if got := len(outbox(t, db)); got != newDeviceEmailsPerWindow {
+		t.Fatalf("expected %d emails, got %d", newDeviceEmailsPerWindow, got)
+	}
+	// Devices are still recorded while emails are suppressed
+	var count int64
+	db.Model(&models.KnownDevice{}).Count(&count)
+	if count != newDeviceEmailsPerWindow+2 {
+		t.Fatalf("expected every device recorded, got %d", count)
+	}
+}
+
+func TestEmailRateLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newEmailRateLimiter(2, time.Hour)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow(1) || !l.Allow(1) {
+		t.Fatal("expected the first two emails to be allowed")
+	}
+	if l.Allow(1) {
+		t.Fatal("expected the third email to be limited")
+	}
+	if !l.Allow(2) {
+		t.Fatal("limits are per user")
+	}
+	now = now.Add(time.Hour)
+	if !l.Allow(1) {
+		t.Fatal("expected the window to slide")
+	}
+}
+
+func TestUserAgentFamilyAndPrefix(t *testing.T) {
+	families := map[string]string{
+		firefoxLinux:  "Firefox on Linux",
+		chromeWindows: "Chrome on Windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15":         "Safari on macOS",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0": "Edge on Windows",
+		"": "Unknown browser on unknown OS",
+	}
+	for ua, want := range families {
+		if got := userAgentFamily(ua); got != want {
+			t.Errorf("userAgentFamily(%q) = %q, want %q", ua, got, want)
+		}
+	}
+
+	prefixes := map[string]string{
+		"203.0.113.7":          "203.0.113.0/24",
+		"2001:db8:abcd:12::1":  "2001:db8:abcd::/48",
+		"not-an-ip":            "unknown",
+		"::ffff:198.51.100.20": "198.51.100.0/24",
+	}
+	for ip, want := range prefixes {
+		if got := ipPrefix(ip); got != want {
+			t.Errorf("ipPrefix(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestDeviceHandler_ListAndForget(t *testing.T) {
+	h, user, db := newDeviceAuthHandler(t)
+	loginFrom(t, h, "Abcdefg!", "203.0.113.7", firefoxLinux)
+	loginFrom(t, h, "Abcdefg!", "198.51.100.4", chromeWindows)
+
+	other := &models.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	db.Create(other)
+	db.Create(&models.KnownDevice{UserID: other.ID, Fingerprint: "f", Label: "Safari on iOS", IPPrefix: "unknown", FirstSeen: time.Now(), LastSeen: time.Now()})
+
+	devices := &DeviceHandler{Repo: h.Devices, Users: h.UserRepo, JWTSecret: h.JWTSecret}
+	token := makeToken(t, h.JWTSecret, jwt.MapClaims{"sub": user.ID, "exp": time.Now().Add(time.Hour).Unix()})
+	call := func(handler http.HandlerFunc, method, deviceID, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/me/devices/"+deviceID, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("deviceId", deviceID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := call(devices.ListDevicesHandler, http.MethodGet, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+
+	rec := call(devices.ListDevicesHandler, http.MethodGet, "", token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list failed: %d %s", rec.Code, rec.Body.String())
+	}
+	var listed []models.KnownDevice
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(listed) != 2 || listed[0].Label != "Chrome on Windows" || listed[1].Location != "Singapore" {
+		t.Fatalf("unexpected devices: %+v", listed)
+	}
+	if strings.Contains(rec.Body.String(), "fingerprint") {
+		t.Fatalf("fingerprint should not be exposed: %s", rec.Body.String())
+	}
+
+	var foreign models.KnownDevice
+	db.Where("user_id = ?", other.ID).First(&foreign)
+	if rec := call(devices.ForgetDeviceHandler, http.MethodDelete, fmt.Sprint(foreign.ID), token); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another user's device, got %d", rec.Code)
+	}
+	if rec := call(devices.ForgetDeviceHandler, http.MethodDelete, "abc", token); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad id, got %d", rec.Code)
+	}
+	if rec := call(devices.ForgetDeviceHandler, http.MethodDelete, fmt.Sprint(listed[1].ID), token); rec.Code != http.StatusOK {
+		t.Fatalf("forget failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// A forgotten device notifies again on its next login
+	loginFrom(t, h, "Abcdefg!", "203.0.113.7", firefoxLinux)
+	if got := len(outbox(t, db)); got != 3 {
+		t.Fatalf("expected a new email after forgetting the device, got %d", got)
+	}
+}
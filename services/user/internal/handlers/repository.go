@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
 	"time"
 )
 
@@ -11,8 +12,9 @@ type UserRepository interface {
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(userID string) (*models.User, error)
-	UpdateUser(userID string, updates *models.User) (*models.User, error)
+	UpdateUser(userID string, updates *models.User, events ...*models.AuditEvent) (*models.User, error)
 	DeleteUser(userID string) error
+	ClearMustChangePassword(userID string) error
 }
 
 // TokenRepository captures the token persistence operations required by handlers.
@@ -25,3 +27,34 @@ type TokenRepository interface {
 	DeleteByUserAndPurpose(userID uint, purpose models.TokenPurpose) error
 	DeleteExpired(before time.Time) (int64, error)
 }
+
+// ProvisioningRepository captures the persistence operations for bulk provisioning.
+type ProvisioningRepository interface {
+	GetRun(key string) (*models.BulkProvisionRun, error)
+	Provision(users []*models.User, emails []*models.EmailOutbox, key string, report func() string, events ...*models.AuditEvent) error
+}
+
+// DeviceRepository captures the known device and auth event persistence used
+// for login notifications.
+type DeviceRepository interface {
+	GetDevice(userID uint, fingerprint string) (*models.KnownDevice, error)
+	ListDevices(userID uint) ([]models.KnownDevice, error)
+	DeleteDevice(userID, deviceID uint, events ...*models.AuditEvent) error
+	CountAuthEvents(userID uint, eventType string, since time.Time) (int64, error)
+	RecordAuthEvent(event *models.AuthEvent) error
+	RecordLogin(device *models.KnownDevice, event *models.AuthEvent, email *models.EmailOutbox) error
+}
+
+// AccountDeletionRepository captures the two-phase account deletion: the soft
+// delete with its queued purge, and restoring the account before the purge.
+type AccountDeletionRepository interface {
+	ScheduleDeletion(user *models.User, purgeAt time.Time, events ...*models.AuditEvent) (*models.AccountPurgeJob, error)
+	GetPendingDeletion(username string) (*models.User, *models.AccountPurgeJob, error)
+	Restore(userID uint, now time.Time, events ...*models.AuditEvent) error
+}
+
+// AuditRepository reads the audit trail of sensitive account actions, which
+// the repositories above write along with the actions.
+type AuditRepository interface {
+	List(filter repositories.AuditFilter) ([]models.AuditEvent, int64, error)
+}
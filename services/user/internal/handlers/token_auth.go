@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+)
+
+// authenticatedUser verifies the bearer token of r and returns its subject.
+// A token outlives the deletion of its account until it expires, so the
+// account is looked up and the token refused once it is gone.
+func authenticatedUser(w http.ResponseWriter, r *http.Request, secret string, users UserRepository) (string, bool) {
+	claims, err := utils.VerifyToken(r, secret)
+	if err != nil {
+		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+		return "", false
+	}
+	sub, err := utils.GetUserIDFromClaims(claims)
+	if err != nil {
+		utils.JSONError(w, http.StatusUnauthorized, "Invalid token subject")
+		return "", false
+	}
+	if _, err := users.GetUserByID(sub); err != nil {
+		if errors.Is(err, repositories.ErrUserNotFound) {
+			utils.JSONError(w, http.StatusUnauthorized, utils.ErrInvalidToken.Error())
+		} else {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to load user")
+		}
+		return "", false
+	}
+	return sub, true
+}
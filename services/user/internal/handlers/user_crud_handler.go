@@ -19,13 +19,18 @@ type UserHandler struct {
 	Repo      UserRepository
 	JWTSecret string
 	Tokens    *repositories.TokenRepository
+
+	// Deletions turns account deletion into a restorable soft delete when set
+	Deletions AccountDeletionRepository
+
+	// AuditTrail serves the user's audit log
+	AuditTrail AuditRepository
 }
 
 // UpdateUserHandler updates user details
 func (h *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
 		return
 	}
 
@@ -36,8 +41,7 @@ func (h *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Only allow user to update their own record
-	sub, err := utils.GetUserIDFromClaims(claims)
-	if err != nil || sub != userID {
+	if sub != userID {
 		utils.JSONError(w, http.StatusForbidden, "Forbidden")
 		return
 	}
@@ -63,9 +67,8 @@ func (h *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 
 // DeleteUserHandler deletes a user by ID
 func (h *UserHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
 		return
 	}
 
@@ -76,11 +79,16 @@ func (h *UserHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Only allow user to delete their own record
-	if sub, ok := claims["sub"].(string); !ok || sub != userID {
+	if sub != userID {
 		utils.JSONError(w, http.StatusForbidden, "Forbidden")
 		return
 	}
 
+	if h.Deletions != nil {
+		h.scheduleDeletion(w, r, userID)
+		return
+	}
+
 	if err := h.Repo.DeleteUser(userID); err != nil {
 		if err == repositories.ErrUserNotFound {
 			utils.JSONError(w, http.StatusNotFound, "User not found")
@@ -96,13 +104,12 @@ type changeUsernameRequest struct {
 }
 
 func (h *UserHandler) ChangeUsernameHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
 		return
 	}
 	userID := chi.URLParam(r, "id")
-	if sub, err := utils.GetUserIDFromClaims(claims); err != nil || sub != userID {
+	if sub != userID {
 		utils.JSONError(w, http.StatusForbidden, "Forbidden")
 		return
 	}
@@ -118,7 +125,9 @@ func (h *UserHandler) ChangeUsernameHandler(w http.ResponseWriter, r *http.Reque
 			return
 		}
 	}
-	user, err := h.Repo.UpdateUser(userID, &models.User{Username: req.Username})
+	id, _ := parseUserID(userID)
+	event := userAuditEvent(r, models.AuditUsernameChanged, id, map[string]any{"username": req.Username})
+	user, err := h.Repo.UpdateUser(userID, &models.User{Username: req.Username}, event)
 	if err != nil {
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to change username")
 		return
@@ -132,13 +141,12 @@ type changePasswordRequest struct {
 }
 
 func (h *UserHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
 		return
 	}
 	userID := chi.URLParam(r, "id")
-	if sub, err := utils.GetUserIDFromClaims(claims); err != nil || sub != userID {
+	if sub != userID {
 		utils.JSONError(w, http.StatusForbidden, "Forbidden")
 		return
 	}
@@ -160,10 +168,19 @@ func (h *UserHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Reque
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to hash password")
 		return
 	}
-	if _, err := h.Repo.UpdateUser(userID, &models.User{PasswordHash: string(hash)}); err != nil {
+	id, _ := parseUserID(userID)
+	event := userAuditEvent(r, models.AuditPasswordChanged, id, nil)
+	updated, err := h.Repo.UpdateUser(userID, &models.User{PasswordHash: string(hash)}, event)
+	if err != nil {
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to change password")
 		return
 	}
+	if updated != nil && updated.MustChangePassword {
+		if err := h.Repo.ClearMustChangePassword(userID); err != nil {
+			utils.JSONError(w, http.StatusInternalServerError, "Failed to change password")
+			return
+		}
+	}
 	utils.JSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
@@ -172,13 +189,12 @@ type initiateEmailChangeRequest struct {
 }
 
 func (h *UserHandler) InitiateEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
-	claims, err := utils.VerifyToken(r, h.JWTSecret)
-	if err != nil {
-		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+	sub, ok := authenticatedUser(w, r, h.JWTSecret, h.Repo)
+	if !ok {
 		return
 	}
 	userID := chi.URLParam(r, "id")
-	if sub, err := utils.GetUserIDFromClaims(claims); err != nil || sub != userID {
+	if sub != userID {
 		utils.JSONError(w, http.StatusForbidden, "Forbidden")
 		return
 	}
@@ -210,7 +226,8 @@ func (h *UserHandler) InitiateEmailChangeHandler(w http.ResponseWriter, r *http.
 	}
 	// Set new_email and create token
 	newEmail := req.Email
-	if _, err := h.Repo.UpdateUser(userID, &models.User{NewEmail: &newEmail}); err != nil {
+	event := userAuditEvent(r, models.AuditEmailChangeRequested, current.ID, map[string]any{"newEmail": newEmail})
+	if _, err := h.Repo.UpdateUser(userID, &models.User{NewEmail: &newEmail}, event); err != nil {
 		utils.JSONError(w, http.StatusInternalServerError, "Failed to set new email")
 		return
 	}
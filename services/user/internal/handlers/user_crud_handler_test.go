@@ -136,7 +136,8 @@ func TestUserHandler_UpdateUserHandler(t *testing.T) {
 	t.Run("update failure", func(t *testing.T) {
 		handler := &UserHandler{
 			Repo: &mockUserRepo{
-				updateUserFn: func(string, *models.User) (*models.User, error) { return nil, errors.New("db error") },
+				getUserByIDFn: activeUser,
+				updateUserFn:  func(string, *models.User) (*models.User, error) { return nil, errors.New("db error") },
 			},
 			JWTSecret: "secret",
 		}
@@ -241,7 +242,8 @@ func TestUserHandler_DeleteUserHandler(t *testing.T) {
 	t.Run("user not found", func(t *testing.T) {
 		handler := &UserHandler{
 			Repo: &mockUserRepo{
-				deleteUserFn: func(string) error { return repositories.ErrUserNotFound },
+				getUserByIDFn: activeUser,
+				deleteUserFn:  func(string) error { return repositories.ErrUserNotFound },
 			},
 			JWTSecret: "secret",
 		}
@@ -260,7 +262,8 @@ func TestUserHandler_DeleteUserHandler(t *testing.T) {
 	t.Run("delete failure", func(t *testing.T) {
 		handler := &UserHandler{
 			Repo: &mockUserRepo{
-				deleteUserFn: func(string) error { return errors.New("db error") },
+				getUserByIDFn: activeUser,
+				deleteUserFn:  func(string) error { return errors.New("db error") },
 			},
 			JWTSecret: "secret",
 		}
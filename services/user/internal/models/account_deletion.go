@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// AccountPurgeJob is the final, permanent deletion of an account that was
+// soft deleted. The user can restore the account until PurgeAt; after that
+// the purge worker removes the user and its data and announces it. PurgedAt
+// and CompletedAt record progress so a restarted worker resumes where it
+// stopped instead of repeating or skipping steps.
+type AccountPurgeJob struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	UserID   uint      `gorm:"uniqueIndex;not null"`
+	Username string    `gorm:"not null"`
+	PurgeAt  time.Time `gorm:"index;not null"`
+
+	Attempts    int    `gorm:"not null;default:0"`
+	LastError   string `gorm:"type:text"`
+	PurgedAt    *time.Time
+	CompletedAt *time.Time `gorm:"index"`
+}
+
+// UserDeletedEvent is published on the user_deleted channel once an account
+// has been purged
+type UserDeletedEvent struct {
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgedAt  time.Time `json:"purgedAt"`
+}
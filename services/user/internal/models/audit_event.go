@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditEventType is the closed set of sensitive account actions that are
+// audited
+type AuditEventType string
+
+const (
+	AuditPasswordChanged          AuditEventType = "password_changed"
+	AuditPasswordReset            AuditEventType = "password_reset"
+	AuditUsernameChanged          AuditEventType = "username_changed"
+	AuditEmailChangeRequested     AuditEventType = "email_change_requested"
+	AuditEmailChanged             AuditEventType = "email_changed"
+	AuditDeviceForgotten          AuditEventType = "device_forgotten"
+	AuditAccountDeletionRequested AuditEventType = "account_deletion_requested"
+	AuditAccountRestored          AuditEventType = "account_restored"
+	AuditAccountProvisioned       AuditEventType = "account_provisioned"
+)
+
+// AuditEventTypes lists every AuditEventType
+var AuditEventTypes = []AuditEventType{
+	AuditPasswordChanged, AuditPasswordReset, AuditUsernameChanged, AuditEmailChangeRequested,
+	AuditEmailChanged, AuditDeviceForgotten, AuditAccountDeletionRequested, AuditAccountRestored,
+	AuditAccountProvisioned,
+}
+
+func (t AuditEventType) Valid() bool {
+	for _, known := range AuditEventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Audit actors: the account holder, staff using the admin token, or the
+// service itself
+const (
+	AuditActorUser   = "user"
+	AuditActorAdmin  = "admin"
+	AuditActorSystem = "system"
+)
+
+// ErrAuditEventImmutable is returned by any attempt to change or remove an
+// audit event outside the retention purge
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent is an append-only record of a sensitive account action. ActorID
+// is the user who acted, which differs from UserID when someone else acted on
+// the account, and is 0 for admin and system actions. Metadata is a JSON
+// object with secrets redacted. Once the account is purged the event is
+// anonymized (UserID 0) and kept until RetainUntil.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
+	UserID    uint           `gorm:"not null;index:idx_audit_event_user_type" json:"-"`
+	Actor     string         `gorm:"type:varchar(16);not null" json:"actor"`
+	ActorID   uint           `gorm:"not null;default:0" json:"actorId,omitempty"`
+	Type      AuditEventType `gorm:"type:varchar(32);not null;index:idx_audit_event_user_type" json:"type"`
+	Metadata  AuditMetadata  `gorm:"type:text;not null;default:'{}'" json:"metadata"`
+	IP        string         `json:"ip,omitempty"`
+	UserAgent string         `json:"userAgent,omitempty"`
+
+	RetainUntil *time.Time `gorm:"index" json:"-"`
+}
+
+// AuditMetadata is a JSON object stored as text and served as JSON
+type AuditMetadata string
+
+func (m AuditMetadata) MarshalJSON() ([]byte, error) {
+	if m == "" {
+		return []byte("{}"), nil
+	}
+	return []byte(m), nil
+}
+
+func (m *AuditMetadata) UnmarshalJSON(data []byte) error {
+	*m = AuditMetadata(data)
+	return nil
+}
+
+// BeforeUpdate keeps audit events from being edited through the ORM
+func (*AuditEvent) BeforeUpdate(*gorm.DB) error { return ErrAuditEventImmutable }
+
+// BeforeDelete keeps audit events from being deleted through the ORM
+func (*AuditEvent) BeforeDelete(*gorm.DB) error { return ErrAuditEventImmutable }
+
+// AuditPage is one page of a user's audit trail
+type AuditPage struct {
+	Items    []AuditEvent `json:"items"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int64        `json:"total"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// KnownDevice is a device a user has signed in from. Devices are identified
+// by a fingerprint of the user agent family and a coarse IP prefix, so the
+// same browser on the same network is recognised across logins.
+type KnownDevice struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_known_device" json:"-"`
+	Fingerprint string    `gorm:"not null;uniqueIndex:idx_known_device" json:"-"`
+	Label       string    `gorm:"not null" json:"label"` // user agent family, e.g. "Chrome on Windows"
+	IPPrefix    string    `gorm:"not null" json:"ipPrefix"`
+	Location    string    `json:"location,omitempty"`
+	FirstSeen   time.Time `gorm:"not null" json:"firstSeen"`
+	LastSeen    time.Time `gorm:"not null" json:"lastSeen"`
+}
+
+// Auth event types
+const (
+	AuthEventLoginFailed  = "login_failed"
+	AuthEventLoginSuccess = "login_success"
+)
+
+// AuthEvent is an audit record of a login attempt
+type AuthEvent struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	UserID      uint   `gorm:"not null;index:idx_auth_event_user_type"`
+	Type        string `gorm:"type:varchar(32);not null;index:idx_auth_event_user_type"`
+	IP          string
+	UserAgent   string
+	Fingerprint string
+	NewDevice   bool `gorm:"not null;default:false"`
+	Suspicious  bool `gorm:"not null;default:false"`
+}
@@ -24,4 +24,15 @@ type InterviewHistory struct {
 	EndedAt       time.Time `json:"endedAt"`
 	DurationSec   int       `json:"durationSeconds"`
 	RerollsUsed   int       `gorm:"default:0" json:"rerollsUsed"`
+
+	// the question's rubric as ticked off by the pair; empty when it had none
+	RubricResults []RubricResult `gorm:"serializer:json" json:"rubricResults,omitempty"`
+}
+
+// RubricResult is one rubric item at the end of a session.
+type RubricResult struct {
+	Text      string `json:"text"`
+	Checked   bool   `json:"checked"`
+	ToggledBy string `json:"toggledBy,omitempty"`
+	ToggledAt int64  `json:"toggledAt,omitempty"`
 }
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// EmailOutbox is an email queued for delivery. Rows are written in the same
+// transaction as the change that triggers them and sent by the outbox dispatcher.
+type EmailOutbox struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	To        string     `gorm:"not null"`
+	Subject   string     `gorm:"not null"`
+	Body      string     `gorm:"type:text;not null"`
+	Attempts  int        `gorm:"not null;default:0"`
+	LastError string     `gorm:"type:text"`
+	SentAt    *time.Time `gorm:"index"`
+}
+
+// BulkProvisionRun stores the report of a bulk provisioning request so a retry
+// with the same Idempotency-Key replays it instead of creating accounts again.
+type BulkProvisionRun struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	IdempotencyKey string `gorm:"uniqueIndex;not null"`
+	Report         string `gorm:"type:text;not null"`
+}
+
+// Per-row outcomes of a bulk provisioning request
+const (
+	ProvisionCreated          = "created"
+	ProvisionSkippedDuplicate = "skipped_duplicate"
+	ProvisionInvalid          = "invalid"
+)
+
+// BulkProvisionRow is one requested account
+type BulkProvisionRow struct {
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// BulkProvisionResult reports what happened to one row
+type BulkProvisionResult struct {
+	Row      int    `json:"row"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	UserID   uint   `json:"userId,omitempty"`
+}
+
+// BulkProvisionReport summarises a bulk provisioning request
+type BulkProvisionReport struct {
+	Created      int                   `json:"created"`
+	Skipped      int                   `json:"skipped"`
+	Invalid      int                   `json:"invalid"`
+	EmailsQueued int                   `json:"emailsQueued"`
+	Results      []BulkProvisionResult `json:"results"`
+}
@@ -14,6 +14,10 @@ type User struct {
 	PasswordHash string  `gorm:"not null" json:"-"`
 	Verified     bool    `gorm:"not null;default:false" json:"verified"`
 	NewEmail     *string `gorm:"uniqueIndex:new_email_idx" json:"-"`
+	DisplayName  string  `json:"displayName,omitempty"`
+
+	// Set for accounts issued a temporary password; cleared by changing the password
+	MustChangePassword bool `gorm:"not null;default:false" json:"mustChangePassword"`
 
 	// Elo rating fields (hidden from users, used for matchmaking)
 	EloRating         float64    `gorm:"default:1500" json:"-"` // Hidden from JSON response
@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"errors"
+	"peerprep/user/internal/audit"
+	"peerprep/user/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoPendingDeletion is returned when an account is not awaiting deletion,
+// or its restore window has passed
+var ErrNoPendingDeletion = errors.New("no pending deletion")
+
+// AccountDeletionRepository implements the two-phase account deletion: a soft
+// delete that can be undone, followed by a purge job that removes the data.
+type AccountDeletionRepository struct {
+	DB *gorm.DB
+
+	// AuditRetention is how long audit events outlive a purged account;
+	// audit.DefaultRetention when zero
+	AuditRetention time.Duration
+}
+
+// ScheduleDeletion soft deletes the user, drops its outstanding tokens and
+// queues the purge for purgeAt, all in one transaction with events.
+func (r *AccountDeletionRepository) ScheduleDeletion(user *models.User, purgeAt time.Time, events ...*models.AuditEvent) (*models.AccountPurgeJob, error) {
+	job := &models.AccountPurgeJob{UserID: user.ID, Username: user.Username, PurgeAt: purgeAt}
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.User{}, user.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetPendingDeletion returns a soft-deleted user by username together with its
+// purge job, or ErrNoPendingDeletion.
+func (r *AccountDeletionRepository) GetPendingDeletion(username string) (*models.User, *models.AccountPurgeJob, error) {
+	var user models.User
+	err := r.DB.Unscoped().Where("LOWER(username) = LOWER(?) AND deleted_at IS NOT NULL", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNoPendingDeletion
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var job models.AccountPurgeJob
+	err = r.DB.Where("user_id = ? AND purged_at IS NULL", user.ID).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNoPendingDeletion
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, &job, nil
+}
+
+// Restore undoes a soft delete whose purge has not started, as of now, and
+// records events in the same transaction
+func (r *AccountDeletionRepository) Restore(userID uint, now time.Time, events ...*models.AuditEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND purged_at IS NULL AND purge_at > ?", userID, now).
+			Delete(&models.AccountPurgeJob{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNoPendingDeletion
+		}
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, events...)
+	})
+}
+
+// DueJobs returns unfinished purge jobs whose restore window ended by now and
+// that have not exhausted their attempts, oldest first
+func (r *AccountDeletionRepository) DueJobs(now time.Time, limit, maxAttempts int) ([]models.AccountPurgeJob, error) {
+	var jobs []models.AccountPurgeJob
+	err := r.DB.Where("completed_at IS NULL AND purge_at <= ? AND attempts < ?", now, maxAttempts).
+		Order("purge_at").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// Purge permanently removes the user and everything keyed by its id except
+// its audit events, which are anonymized and kept for AuditRetention, and
+// marks the job purged in the same transaction. Running it again for a job
+// that is already purged does nothing.
+func (r *AccountDeletionRepository) Purge(job *models.AccountPurgeJob, now time.Time) error {
+	if job.PurgedAt != nil {
+		return nil
+	}
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&models.Token{}, &models.KnownDevice{}, &models.AuthEvent{}} {
+			if err := tx.Where("user_id = ?", job.UserID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := audit.Anonymize(tx, job.UserID, now.Add(r.auditRetention())); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&models.User{}, job.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Model(job).Update("purged_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+	job.PurgedAt = &now
+	return nil
+}
+
+// ExpireAuditEvents deletes the audit events of purged accounts whose
+// retention ended by now
+func (r *AccountDeletionRepository) ExpireAuditEvents(now time.Time) (int64, error) {
+	return audit.Expire(r.DB, now)
+}
+
+func (r *AccountDeletionRepository) auditRetention() time.Duration {
+	if r.AuditRetention > 0 {
+		return r.AuditRetention
+	}
+	return audit.DefaultRetention
+}
+
+func (r *AccountDeletionRepository) MarkCompleted(id uint, at time.Time) error {
+	return r.DB.Model(&models.AccountPurgeJob{}).Where("id = ?", id).
+		Updates(map[string]any{"completed_at": at, "attempts": gorm.Expr("attempts + 1"), "last_error": ""}).Error
+}
+
+func (r *AccountDeletionRepository) MarkFailed(id uint, jobErr error) error {
+	return r.DB.Model(&models.AccountPurgeJob{}).Where("id = ?", id).
+		Updates(map[string]any{"attempts": gorm.Expr("attempts + 1"), "last_error": jobErr.Error()}).Error
+}
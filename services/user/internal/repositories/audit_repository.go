@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"peerprep/user/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditFilter selects a page of one user's audit events. Empty Types and
+// zero times do not filter.
+type AuditFilter struct {
+	UserID   uint
+	Types    []models.AuditEventType
+	From, To time.Time
+	Page     int // 1-based
+	PageSize int
+}
+
+// AuditRepository reads the audit trail. Events are written with
+// audit.Record inside the transaction of the action they describe.
+type AuditRepository struct {
+	DB *gorm.DB
+}
+
+// List returns the page of events matching filter, newest first, and the
+// total number of matching events
+func (r *AuditRepository) List(filter AuditFilter) ([]models.AuditEvent, int64, error) {
+	matching := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("user_id = ?", filter.UserID)
+		if len(filter.Types) > 0 {
+			db = db.Where("type IN ?", filter.Types)
+		}
+		if !filter.From.IsZero() {
+			db = db.Where("created_at >= ?", filter.From)
+		}
+		if !filter.To.IsZero() {
+			db = db.Where("created_at < ?", filter.To)
+		}
+		return db
+	}
+
+	var total int64
+	if err := r.DB.Model(&models.AuditEvent{}).Scopes(matching).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	events := []models.AuditEvent{}
+	err := r.DB.Scopes(matching).Order("created_at DESC, id DESC").
+		Offset((filter.Page - 1) * filter.PageSize).Limit(filter.PageSize).
+		Find(&events).Error
+	return events, total, err
+}
@@ -20,7 +20,7 @@ func CleanupUnverifiedUserIfExpired(userRepo *UserRepository, tokenRepo *TokenRe
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// No token; treat as expired/invalid and delete the user to free up identifiers
-			if delErr := userRepo.DB.Delete(&models.User{}, user.ID).Error; delErr != nil {
+			if delErr := userRepo.DB.Unscoped().Delete(&models.User{}, user.ID).Error; delErr != nil {
 				return false, delErr
 			}
 			// Also ensure any tokens for this user are removed
@@ -32,7 +32,7 @@ func CleanupUnverifiedUserIfExpired(userRepo *UserRepository, tokenRepo *TokenRe
 
 	if time.Now().After(t.ExpiresAt) {
 		// Token expired: delete user and token
-		if delErr := userRepo.DB.Delete(&models.User{}, user.ID).Error; delErr != nil {
+		if delErr := userRepo.DB.Unscoped().Delete(&models.User{}, user.ID).Error; delErr != nil {
 			return false, delErr
 		}
 		_ = tokenRepo.DeleteByID(t.ID)
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"errors"
+	"peerprep/user/internal/audit"
+	"peerprep/user/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+type DeviceRepository struct {
+	DB *gorm.DB
+}
+
+// GetDevice returns the known device with fingerprint, or nil if there is none
+func (r *DeviceRepository) GetDevice(userID uint, fingerprint string) (*models.KnownDevice, error) {
+	var device models.KnownDevice
+	err := r.DB.Where("user_id = ? AND fingerprint = ?", userID, fingerprint).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// ListDevices returns the user's known devices, most recently seen first
+func (r *DeviceRepository) ListDevices(userID uint) ([]models.KnownDevice, error) {
+	devices := []models.KnownDevice{}
+	err := r.DB.Where("user_id = ?", userID).Order("last_seen DESC").Find(&devices).Error
+	return devices, err
+}
+
+// DeleteDevice forgets a device, so the next login from it notifies again.
+// events are recorded in the same transaction.
+func (r *DeviceRepository) DeleteDevice(userID, deviceID uint, events ...*models.AuditEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", deviceID, userID).Delete(&models.KnownDevice{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeviceNotFound
+		}
+		return audit.Record(tx, events...)
+	})
+}
+
+// CountAuthEvents counts the user's events of type since the given time
+func (r *DeviceRepository) CountAuthEvents(userID uint, eventType string, since time.Time) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.AuthEvent{}).
+		Where("user_id = ? AND type = ? AND created_at >= ?", userID, eventType, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *DeviceRepository) RecordAuthEvent(event *models.AuthEvent) error {
+	return r.DB.Create(event).Error
+}
+
+// RecordLogin saves the device (creating it when it has no id), the audit
+// event and, if given, the notification email in one transaction.
+func (r *DeviceRepository) RecordLogin(device *models.KnownDevice, event *models.AuthEvent, email *models.EmailOutbox) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if device.ID == 0 {
+			if err := tx.Create(device).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(device).Update("last_seen", device.LastSeen).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		if email == nil {
+			return nil
+		}
+		return tx.Create(email).Error
+	})
+}
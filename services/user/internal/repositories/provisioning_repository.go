@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"errors"
+	"peerprep/user/internal/audit"
+	"peerprep/user/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ProvisioningRepository struct {
+	DB *gorm.DB
+}
+
+// GetRun returns the stored run for an idempotency key, or nil if there is none
+func (r *ProvisioningRepository) GetRun(key string) (*models.BulkProvisionRun, error) {
+	var run models.BulkProvisionRun
+	err := r.DB.Where("idempotency_key = ?", key).First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Provision creates the users and queues their emails in one transaction, so
+// either the whole batch lands or none of it. With an idempotency key the run
+// is recorded in the same transaction; report is called once the users have
+// their ids. events[i], when given, is the audit event for users[i] and is
+// recorded once that user has its id.
+func (r *ProvisioningRepository) Provision(users []*models.User, emails []*models.EmailOutbox, key string, report func() string, events ...*models.AuditEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if len(users) > 0 {
+			if err := tx.Create(users).Error; err != nil {
+				return err
+			}
+		}
+		for i, event := range events {
+			if i < len(users) && event != nil {
+				event.UserID = users[i].ID
+			}
+		}
+		if err := audit.Record(tx, events...); err != nil {
+			return err
+		}
+		if len(emails) > 0 {
+			if err := tx.Create(emails).Error; err != nil {
+				return err
+			}
+		}
+		if key == "" {
+			return nil
+		}
+		return tx.Create(&models.BulkProvisionRun{IdempotencyKey: key, Report: report()}).Error
+	})
+}
+
+type EmailOutboxRepository struct {
+	DB *gorm.DB
+}
+
+// Pending returns unsent emails that have not exhausted their attempts, oldest first
+func (r *EmailOutboxRepository) Pending(limit, maxAttempts int) ([]models.EmailOutbox, error) {
+	var out []models.EmailOutbox
+	err := r.DB.Where("sent_at IS NULL AND attempts < ?", maxAttempts).
+		Order("id").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+func (r *EmailOutboxRepository) MarkSent(id uint, at time.Time) error {
+	return r.DB.Model(&models.EmailOutbox{}).Where("id = ?", id).
+		Updates(map[string]any{"sent_at": at, "attempts": gorm.Expr("attempts + 1"), "last_error": ""}).Error
+}
+
+func (r *EmailOutboxRepository) MarkFailed(id uint, sendErr error) error {
+	return r.DB.Model(&models.EmailOutbox{}).Where("id = ?", id).
+		Updates(map[string]any{"attempts": gorm.Expr("attempts + 1"), "last_error": sendErr.Error()}).Error
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"errors"
+	"peerprep/user/internal/audit"
 	"peerprep/user/internal/models"
 	"strconv"
 
@@ -58,10 +59,11 @@ func (r *UserRepository) GetUserByNewEmail(email string) (*models.User, error) {
 	return &user, err
 }
 
-// Exists helpers avoid triggering gorm 'record not found' logs during checks
+// Exists helpers avoid triggering gorm 'record not found' logs during checks.
+// Usernames and emails of accounts pending deletion stay taken until purged.
 func (r *UserRepository) ExistsByUsername(username string) (bool, error) {
 	var count int64
-	if err := r.DB.Model(&models.User{}).Where("LOWER(username) = LOWER(?)", username).Count(&count).Error; err != nil {
+	if err := r.DB.Unscoped().Model(&models.User{}).Where("LOWER(username) = LOWER(?)", username).Count(&count).Error; err != nil {
 		return false, err
 	}
 	return count > 0, nil
@@ -69,7 +71,7 @@ func (r *UserRepository) ExistsByUsername(username string) (bool, error) {
 
 func (r *UserRepository) ExistsByEmail(email string) (bool, error) {
 	var count int64
-	if err := r.DB.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", email).Count(&count).Error; err != nil {
+	if err := r.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) = LOWER(?)", email).Count(&count).Error; err != nil {
 		return false, err
 	}
 	return count > 0, nil
@@ -83,33 +85,51 @@ func (r *UserRepository) ExistsByNewEmail(email string) (bool, error) {
 	return count > 0, nil
 }
 
-func (r *UserRepository) UpdateUser(userID string, updates *models.User) (*models.User, error) {
+// UpdateUser applies updates and records events in the same transaction
+func (r *UserRepository) UpdateUser(userID string, updates *models.User, events ...*models.AuditEvent) (*models.User, error) {
 	var user models.User
 	id, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
 		return nil, err
 	}
-	if err := r.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrUserNotFound
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
 		}
-		return nil, err
-	}
-
-	if err := r.DB.Model(&user).Updates(updates).Error; err != nil {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, events...)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// DeleteUser permanently removes a user. Account deletion requested by the
+// user goes through AccountDeletionRepository instead.
 func (r *UserRepository) DeleteUser(userID string) error {
 	id, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
 		return err
 	}
-	result := r.DB.Where("id = ?", id).Delete(&models.User{})
+	result := r.DB.Unscoped().Where("id = ?", id).Delete(&models.User{})
 	if result.RowsAffected == 0 {
 		return ErrUserNotFound
 	}
 	return result.Error
 }
+
+// ClearMustChangePassword drops the forced password change flag. Updates with
+// a struct skip false values, so this needs its own column update.
+func (r *UserRepository) ClearMustChangePassword(userID string) error {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return r.DB.Model(&models.User{}).Where("id = ?", id).Update("must_change_password", false).Error
+}
@@ -0,0 +1,14 @@
+package routers
+
+import (
+	handlers "peerprep/user/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func AdminRoutes(r *chi.Mux, adminHandler *handlers.AdminHandler) {
+	r.Route("/api/v1/admin", func(r chi.Router) {
+		r.Post("/users/bulk", adminHandler.BulkProvisionHandler)  // Bulk provision accounts from JSON or CSV
+		r.Get("/users/{id}/audit", adminHandler.UserAuditHandler) // A user's audit log
+	})
+}
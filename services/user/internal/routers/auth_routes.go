@@ -14,5 +14,6 @@ func AuthRoutes(r *chi.Mux, authHandler *handlers.AuthHandler) {
 		r.Get("/verify", authHandler.VerifyAccountHandler)                    // Account verification via token
 		r.Get("/change-email/confirm", authHandler.ConfirmEmailChangeHandler) // Confirm email change via token
 		r.Post("/forgot", authHandler.ForgotPasswordHandler)                  // Forgot username/password
+		r.Post("/restore-account", authHandler.RestoreAccountHandler)         // Restore an account pending deletion
 	})
 }
@@ -14,9 +14,10 @@ func TestAuthRoutesRegistered(t *testing.T) {
 	AuthRoutes(r, &handlers.AuthHandler{})
 
 	expected := map[string]struct{}{
-		"POST /api/v1/auth/login":    {},
-		"POST /api/v1/auth/register": {},
-		"GET /api/v1/auth/me":        {},
+		"POST /api/v1/auth/login":           {},
+		"POST /api/v1/auth/register":        {},
+		"GET /api/v1/auth/me":               {},
+		"POST /api/v1/auth/restore-account": {},
 	}
 
 	if err := chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
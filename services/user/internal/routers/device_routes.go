@@ -0,0 +1,14 @@
+package routers
+
+import (
+	handlers "peerprep/user/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func DeviceRoutes(r *chi.Mux, deviceHandler *handlers.DeviceHandler) {
+	r.Route("/api/v1/users/me/devices", func(r chi.Router) {
+		r.Get("/", deviceHandler.ListDevicesHandler)               // List devices the current user signed in from
+		r.Delete("/{deviceId}", deviceHandler.ForgetDeviceHandler) // Forget a device
+	})
+}
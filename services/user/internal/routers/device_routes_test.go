@@ -0,0 +1,31 @@
+package routers
+
+import (
+	"net/http"
+	"testing"
+
+	"peerprep/user/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDeviceRoutesRegistered(t *testing.T) {
+	r := chi.NewRouter()
+	DeviceRoutes(r, &handlers.DeviceHandler{})
+
+	expected := map[string]struct{}{
+		"GET /api/v1/users/me/devices/":              {},
+		"DELETE /api/v1/users/me/devices/{deviceId}": {},
+	}
+
+	if err := chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		delete(expected, method+" "+route)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+
+	if len(expected) != 0 {
+		t.Fatalf("missing routes: %v", expected)
+	}
+}
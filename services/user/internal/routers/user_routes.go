@@ -8,6 +8,8 @@ import (
 
 func UserRoutes(r *chi.Mux, userHandler *handlers.UserHandler) {
 	r.Route("/api/v1/users", func(r chi.Router) {
+		r.Delete("/me", userHandler.DeleteMeHandler)                         // Delete the current user (restorable for 14 days)
+		r.Get("/me/audit", userHandler.MyAuditHandler)                       // Current user's audit log of sensitive account actions
 		r.Put("/{id}", userHandler.UpdateUserHandler)                        // Update user by ID
 		r.Delete("/{id}", userHandler.DeleteUserHandler)                     // Delete user by ID
 		r.Patch("/{id}/username", userHandler.ChangeUsernameHandler)         // Change username
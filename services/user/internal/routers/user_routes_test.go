@@ -14,8 +14,10 @@ func TestUserRoutesRegistered(t *testing.T) {
 	UserRoutes(r, &handlers.UserHandler{})
 
 	expected := map[string]struct{}{
-		"PUT /api/v1/users/{id}":    {},
-		"DELETE /api/v1/users/{id}": {},
+		"PUT /api/v1/users/{id}":     {},
+		"DELETE /api/v1/users/{id}":  {},
+		"DELETE /api/v1/users/me":    {},
+		"GET /api/v1/users/me/audit": {},
 	}
 
 	if err := chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
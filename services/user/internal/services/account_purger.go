@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	purgeBatchSize   = 20
+	purgeMaxAttempts = 10
+
+	// UserDeletedChannel carries a models.UserDeletedEvent for every purged account
+	UserDeletedChannel = "user_deleted"
+)
+
+// AccountPurger permanently deletes accounts whose restore window has ended.
+// Each job is purged and then announced; the job row records both steps, so a
+// pass interrupted by a restart picks up from the step it had reached.
+type AccountPurger struct {
+	repo     *repositories.AccountDeletionRepository
+	publish  func(ctx context.Context, payload string) error
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewAccountPurger creates a purger. With a nil rdb purges are not announced.
+func NewAccountPurger(repo *repositories.AccountDeletionRepository, rdb *redis.Client, interval time.Duration) *AccountPurger {
+	p := &AccountPurger{repo: repo, interval: interval, now: time.Now}
+	if rdb != nil {
+		p.publish = func(ctx context.Context, payload string) error {
+			return rdb.Publish(ctx, UserDeletedChannel, payload).Err()
+		}
+	}
+	return p
+}
+
+// Run purges due accounts every interval until ctx is cancelled
+func (p *AccountPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.PurgeOnce(ctx)
+		}
+	}
+}
+
+// PurgeOnce processes one batch of due jobs and returns how many completed.
+// It also drops the audit events of purged accounts past their retention.
+func (p *AccountPurger) PurgeOnce(ctx context.Context) int {
+	now := p.now()
+	if _, err := p.repo.ExpireAuditEvents(now); err != nil {
+		log.Printf("[AccountPurger] Failed to expire audit events: %v", err)
+	}
+	jobs, err := p.repo.DueJobs(now, purgeBatchSize, purgeMaxAttempts)
+	if err != nil {
+		log.Printf("[AccountPurger] Failed to load due jobs: %v", err)
+		return 0
+	}
+	completed := 0
+	for i := range jobs {
+		job := &jobs[i]
+		if err := p.process(ctx, job, now); err != nil {
+			log.Printf("[AccountPurger] Failed to purge user %d: %v", job.UserID, err)
+			_ = p.repo.MarkFailed(job.ID, err)
+			continue
+		}
+		_ = p.repo.MarkCompleted(job.ID, p.now())
+		completed++
+	}
+	return completed
+}
+
+func (p *AccountPurger) process(ctx context.Context, job *models.AccountPurgeJob, now time.Time) error {
+	if err := p.repo.Purge(job, now); err != nil {
+		return err
+	}
+	if p.publish == nil {
+		return nil
+	}
+	payload, err := json.Marshal(models.UserDeletedEvent{
+		UserID:    strconv.FormatUint(uint64(job.UserID), 10),
+		Username:  job.Username,
+		DeletedAt: job.CreatedAt,
+		PurgedAt:  *job.PurgedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return p.publish(ctx, string(payload))
+}
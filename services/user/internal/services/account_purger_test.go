@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"peerprep/user/internal/audit"
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+
+	"gorm.io/gorm"
+)
+
+func scheduleTestDeletion(t *testing.T, db *gorm.DB, purgeAt time.Time) (*models.User, *repositories.AccountDeletionRepository) {
+	t.Helper()
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	if err := db.Create(&models.KnownDevice{UserID: user.ID, Fingerprint: "fp", Label: "Chrome", IPPrefix: "10.0.0", FirstSeen: time.Now(), LastSeen: time.Now()}).Error; err != nil {
+		t.Fatalf("seed device: %v", err)
+	}
+	if err := db.Create(&models.AuthEvent{UserID: user.ID, Type: models.AuthEventLoginSuccess}).Error; err != nil {
+		t.Fatalf("seed auth event: %v", err)
+	}
+	repo := &repositories.AccountDeletionRepository{DB: db}
+	requested := audit.NewEvent(models.AuditAccountDeletionRequested, user.ID, models.AuditActorUser, user.ID, nil)
+	requested.IP = "203.0.113.7"
+	if _, err := repo.ScheduleDeletion(user, purgeAt, requested); err != nil {
+		t.Fatalf("schedule deletion: %v", err)
+	}
+	return user, repo
+}
+
+func countRows(db *gorm.DB, model any, userID uint) int64 {
+	var n int64
+	db.Unscoped().Model(model).Where("user_id = ?", userID).Count(&n)
+	return n
+}
+
+func TestAccountPurger_PurgesAfterWindow(t *testing.T) {
+	db := testhelpers.SetupTestDB(t)
+	user, repo := scheduleTestDeletion(t, db, time.Now().Add(time.Hour))
+
+	p := NewAccountPurger(repo, nil, 0)
+	var published []models.UserDeletedEvent
+	p.publish = func(_ context.Context, payload string) error {
+		var event models.UserDeletedEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		published = append(published, event)
+		return nil
+	}
+
+	if n := p.PurgeOnce(context.Background()); n != 0 {
+		t.Fatalf("expected nothing purged inside the window, got %d", n)
+	}
+
+	p.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if n := p.PurgeOnce(context.Background()); n != 1 {
+		t.Fatalf("expected 1 purge, got %d", n)
+	}
+	var users int64
+	db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&users)
+	if users != 0 || countRows(db, &models.KnownDevice{}, user.ID) != 0 || countRows(db, &models.AuthEvent{}, user.ID) != 0 {
+		t.Fatalf("expected user, devices and auth events to be gone")
+	}
+	if len(published) != 1 || published[0].Username != "alice" || published[0].UserID == "" {
+		t.Fatalf("unexpected events: %+v", published)
+	}
+
+	if n := p.PurgeOnce(context.Background()); n != 0 || len(published) != 1 {
+		t.Fatalf("expected a completed job not to run again")
+	}
+
+	// the identifiers are free again once purged
+	if err := db.Create(&models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}).Error; err != nil {
+		t.Fatalf("expected the username to be reusable after purge: %v", err)
+	}
+}
+
+func TestAccountPurger_ResumesAfterFailedPublish(t *testing.T) {
+	db := testhelpers.SetupTestDB(t)
+	user, repo := scheduleTestDeletion(t, db, time.Now().Add(-time.Minute))
+
+	p := NewAccountPurger(repo, nil, 0)
+	p.publish = func(context.Context, string) error { return errors.New("redis down") }
+	if n := p.PurgeOnce(context.Background()); n != 0 {
+		t.Fatalf("expected no completed jobs, got %d", n)
+	}
+
+	var job models.AccountPurgeJob
+	db.Where("user_id = ?", user.ID).First(&job)
+	if job.PurgedAt == nil || job.CompletedAt != nil || job.Attempts != 1 || job.LastError != "redis down" {
+		t.Fatalf("unexpected job after failed publish: %+v", job)
+	}
+
+	// a fresh worker, as after a restart, only has the announcement left to do
+	restarted := NewAccountPurger(repo, nil, 0)
+	var publishes int
+	restarted.publish = func(context.Context, string) error { publishes++; return nil }
+	if n := restarted.PurgeOnce(context.Background()); n != 1 || publishes != 1 {
+		t.Fatalf("expected the job to complete on resume, completed=%d publishes=%d", n, publishes)
+	}
+	db.Where("user_id = ?", user.ID).First(&job)
+	if job.CompletedAt == nil {
+		t.Fatalf("expected job to be completed: %+v", job)
+	}
+}
+
+func TestAccountPurger_KeepsAnonymizedAuditEvents(t *testing.T) {
+	db := testhelpers.SetupTestDB(t)
+	user, repo := scheduleTestDeletion(t, db, time.Now().Add(-time.Minute))
+	repo.AuditRetention = 30 * 24 * time.Hour
+
+	p := NewAccountPurger(repo, nil, 0)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	if n := p.PurgeOnce(context.Background()); n != 1 {
+		t.Fatalf("expected 1 purge, got %d", n)
+	}
+	if n := countRows(db, &models.AuditEvent{}, user.ID); n != 0 {
+		t.Fatalf("expected no audit events left under the user id, found %d", n)
+	}
+	var events []models.AuditEvent
+	db.Find(&events)
+	if len(events) != 1 {
+		t.Fatalf("expected the audit event to be retained, got %d", len(events))
+	}
+	event := events[0]
+	if event.UserID != 0 || event.ActorID != 0 || event.IP != "" || event.Type != models.AuditAccountDeletionRequested ||
+		event.RetainUntil == nil || !event.RetainUntil.Equal(now.Add(repo.AuditRetention)) {
+		t.Fatalf("expected an anonymized event kept for 30 days, got %+v", event)
+	}
+
+	p.now = func() time.Time { return now.Add(29 * 24 * time.Hour) }
+	p.PurgeOnce(context.Background())
+	if n := countAll(db, &models.AuditEvent{}); n != 1 {
+		t.Fatalf("expected the event to be kept within retention, found %d", n)
+	}
+	p.now = func() time.Time { return now.Add(31 * 24 * time.Hour) }
+	p.PurgeOnce(context.Background())
+	if n := countAll(db, &models.AuditEvent{}); n != 0 {
+		t.Fatalf("expected the event to expire after retention, found %d", n)
+	}
+}
+
+func countAll(db *gorm.DB, model any) int64 {
+	var n int64
+	db.Model(model).Count(&n)
+	return n
+}
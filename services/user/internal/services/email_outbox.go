@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/utils"
+)
+
+const (
+	outboxBatchSize   = 50
+	outboxMaxAttempts = 5
+)
+
+// EmailDispatcher delivers queued outbox emails. Failed sends are retried on
+// later passes until they reach outboxMaxAttempts.
+type EmailDispatcher struct {
+	repo     *repositories.EmailOutboxRepository
+	send     func(to, subject, body string) error
+	interval time.Duration
+}
+
+func NewEmailDispatcher(repo *repositories.EmailOutboxRepository, interval time.Duration) *EmailDispatcher {
+	return &EmailDispatcher{repo: repo, send: utils.SendEmail, interval: interval}
+}
+
+// Run dispatches pending emails every interval until ctx is cancelled
+func (d *EmailDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.DispatchOnce()
+		}
+	}
+}
+
+// DispatchOnce sends one batch of pending emails and returns how many were sent
+func (d *EmailDispatcher) DispatchOnce() int {
+	pending, err := d.repo.Pending(outboxBatchSize, outboxMaxAttempts)
+	if err != nil {
+		log.Printf("[EmailOutbox] Failed to load pending emails: %v", err)
+		return 0
+	}
+	sent := 0
+	for _, email := range pending {
+		if err := d.send(email.To, email.Subject, email.Body); err != nil {
+			log.Printf("[EmailOutbox] Failed to send email %d: %v", email.ID, err)
+			_ = d.repo.MarkFailed(email.ID, err)
+			continue
+		}
+		_ = d.repo.MarkSent(email.ID, time.Now())
+		sent++
+	}
+	return sent
+}
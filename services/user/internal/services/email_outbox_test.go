@@ -0,0 +1,51 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"peerprep/user/internal/models"
+	"peerprep/user/internal/repositories"
+	"peerprep/user/internal/testhelpers"
+)
+
+func TestEmailDispatcher_RetriesUntilMaxAttempts(t *testing.T) {
+	db := testhelpers.SetupTestDB(t)
+	repo := &repositories.EmailOutboxRepository{DB: db}
+	if err := db.Create([]*models.EmailOutbox{
+		{To: "ok@example.com", Subject: "hi", Body: "body"},
+		{To: "bounce@example.com", Subject: "hi", Body: "body"},
+	}).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	d := NewEmailDispatcher(repo, 0)
+	var calls int
+	d.send = func(to, _, _ string) error {
+		calls++
+		if to == "bounce@example.com" {
+			return errors.New("mailbox unavailable")
+		}
+		return nil
+	}
+
+	if sent := d.DispatchOnce(); sent != 1 {
+		t.Fatalf("expected 1 sent, got %d", sent)
+	}
+	for i := 1; i < outboxMaxAttempts; i++ {
+		if sent := d.DispatchOnce(); sent != 0 {
+			t.Fatalf("pass %d: expected nothing sent, got %d", i, sent)
+		}
+	}
+	calls = 0
+	d.DispatchOnce()
+	if calls != 0 {
+		t.Fatalf("expected failed email to be abandoned after %d attempts", outboxMaxAttempts)
+	}
+
+	var bounced models.EmailOutbox
+	db.Where("\"to\" = ?", "bounce@example.com").First(&bounced)
+	if bounced.SentAt != nil || bounced.Attempts != outboxMaxAttempts || bounced.LastError != "mailbox unavailable" {
+		t.Fatalf("unexpected bounced row: %+v", bounced)
+	}
+}
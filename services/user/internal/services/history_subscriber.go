@@ -28,6 +28,8 @@ type SessionEndedEvent struct {
 	EndedAt       string `json:"endedAt"`
 	DurationSec   int    `json:"durationSeconds"`
 	RerollsUsed   int    `json:"rerollsUsed"`
+
+	RubricResults []models.RubricResult `json:"rubricResults,omitempty"`
 }
 
 type HistorySubscriber struct {
@@ -130,6 +132,7 @@ func (hs *HistorySubscriber) handleSessionEndedEvent(payload string) {
 		EndedAt:       endedAt,
 		DurationSec:   event.DurationSec,
 		RerollsUsed:   event.RerollsUsed,
+		RubricResults: event.RubricResults,
 	}
 
 	// Save to database
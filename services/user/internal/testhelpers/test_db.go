@@ -11,8 +11,11 @@ import (
 )
 
 var (
-	openSQLite      = func(dsn string) (*gorm.DB, error) { return gorm.Open(sqlite.Open(dsn), &gorm.Config{}) }
-	migrateSchema   = func(db *gorm.DB) error { return db.AutoMigrate(&models.User{}, &models.Token{}) }
+	openSQLite    = func(dsn string) (*gorm.DB, error) { return gorm.Open(sqlite.Open(dsn), &gorm.Config{}) }
+	migrateSchema = func(db *gorm.DB) error {
+		return db.AutoMigrate(&models.User{}, &models.Token{}, &models.EmailOutbox{}, &models.BulkProvisionRun{},
+			&models.KnownDevice{}, &models.AuthEvent{}, &models.AccountPurgeJob{}, &models.AuditEvent{})
+	}
 	dropUserTableFn = func(db *gorm.DB) error { return db.Migrator().DropTable(&models.User{}) }
 )
 